@@ -0,0 +1,300 @@
+// Command bench seeds fake keys and measures end-to-end refresh throughput
+// against the mock provider, to find safe MAX_WORKERS / QUEUE_SIZE values.
+//
+//	go run ./cmd/bench -keys 50000 -workers 200 -queue 20000
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"github.com/droid-keyusage-go/internal/services"
+	"github.com/droid-keyusage-go/internal/storage"
+	"github.com/redis/go-redis/v9"
+)
+
+// benchPrefix namespaces everything the benchmark writes to a real Redis
+const benchPrefix = "bench:"
+
+// writeCommands are the Redis commands counted as writes
+var writeCommands = map[string]bool{
+	"set": true, "setnx": true, "del": true, "incr": true, "expire": true,
+	"hset": true, "hsetnx": true, "hdel": true, "sadd": true, "srem": true,
+	"zadd": true, "zrem": true, "zremrangebyscore": true, "publish": true,
+	"eval": true, "evalsha": true,
+}
+
+// commandCounter is a go-redis hook counting commands, including those sent
+// in pipelines
+type commandCounter struct {
+	total  int64
+	writes int64
+}
+
+func (c *commandCounter) count(cmd redis.Cmder) {
+	atomic.AddInt64(&c.total, 1)
+	if writeCommands[strings.ToLower(cmd.Name())] {
+		atomic.AddInt64(&c.writes, 1)
+	}
+}
+
+func (c *commandCounter) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (c *commandCounter) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		c.count(cmd)
+		return next(ctx, cmd)
+	}
+}
+
+func (c *commandCounter) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		for _, cmd := range cmds {
+			c.count(cmd)
+		}
+		return next(ctx, cmds)
+	}
+}
+
+func (c *commandCounter) snapshot() (int64, int64) {
+	return atomic.LoadInt64(&c.total), atomic.LoadInt64(&c.writes)
+}
+
+// memorySampler records the peak heap while a round runs
+type memorySampler struct {
+	peak uint64
+	stop chan struct{}
+	done chan struct{}
+}
+
+func sampleMemory() *memorySampler {
+	m := &memorySampler{stop: make(chan struct{}), done: make(chan struct{})}
+	go func() {
+		defer close(m.done)
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			var stats runtime.MemStats
+			runtime.ReadMemStats(&stats)
+			if stats.HeapInuse > m.peak {
+				m.peak = stats.HeapInuse
+			}
+			select {
+			case <-ticker.C:
+			case <-m.stop:
+				return
+			}
+		}
+	}()
+	return m
+}
+
+func (m *memorySampler) finish() uint64 {
+	close(m.stop)
+	<-m.done
+	return m.peak
+}
+
+// round is the outcome of one full refresh
+type round struct {
+	duration  time.Duration
+	ok        int
+	errors    map[string]int
+	commands  int64
+	writes    int64
+	peakHeap  uint64
+	totalKeys int
+}
+
+func main() {
+	keys := flag.Int("keys", 10000, "number of fake keys to seed")
+	workers := flag.Int("workers", 100, "worker pool size (MAX_WORKERS)")
+	queue := flag.Int("queue", 10000, "task queue size (QUEUE_SIZE)")
+	batch := flag.Int("batch", 500, "storage batch size (STORAGE_BATCH_SIZE)")
+	rounds := flag.Int("rounds", 3, "number of full refreshes")
+	redisURL := flag.String("redis", "", "Redis URL; empty uses the in-memory backend")
+	latency := flag.Duration("latency", 100*time.Millisecond, "mean simulated upstream latency")
+	errorRate := flag.Float64("error-rate", 0.01, "share of simulated upstream failures")
+	flag.Parse()
+
+	if err := run(*keys, *workers, *queue, *batch, *rounds, *redisURL, *latency, *errorRate); err != nil {
+		fmt.Fprintf(os.Stderr, "bench: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(keys, workers, queue, batch, rounds int, redisURL string, latency time.Duration, errorRate float64) error {
+	var client *storage.RedisClient
+	var err error
+	if redisURL == "" {
+		client, err = storage.NewMemoryClient()
+	} else {
+		client, err = storage.NewRedisClient(storage.DefaultRedisOptions(redisURL))
+	}
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	counter := &commandCounter{}
+	client.GetClient().AddHook(counter)
+
+	// Everything goes below a prefix so a real Redis can be cleaned up
+	store := storage.NewStorage(client).WithPrefix(benchPrefix)
+	if redisURL != "" {
+		defer cleanup(client.GetClient())
+	}
+
+	pool := services.NewWorkerPool(workers, queue)
+	pool.UseMock(services.NewMockFetcher(services.MockConfig{
+		Latency:    latency,
+		ErrorRate:  errorRate,
+		UsedMean:   0.5,
+		UsedStddev: 0.25,
+	}))
+	pool.Start()
+	defer pool.Stop()
+
+	apiKeys := services.NewAPIKeyService(store, pool, nil, batch, time.Minute, "", services.MaskPolicy{Prefix: 4, Suffix: 4})
+	// Usage expires right away so every round refreshes every key
+	apiKeys.SetCacheTTL(time.Millisecond)
+
+	fmt.Printf("Seeding %d keys...\n", keys)
+	seedStart := time.Now()
+	if err := seed(store, keys); err != nil {
+		return err
+	}
+	seedTime := time.Since(seedStart)
+
+	admin := services.Principal{Role: services.RoleAdmin}
+	results := make([]round, 0, rounds)
+	for i := 0; i < rounds; i++ {
+		time.Sleep(10 * time.Millisecond)
+		beforeTotal, beforeWrites := counter.snapshot()
+		mem := sampleMemory()
+		start := time.Now()
+
+		data, err := apiKeys.GetAggregatedData(context.Background(), admin)
+		if err != nil {
+			mem.finish()
+			return err
+		}
+
+		r := round{duration: time.Since(start), peakHeap: mem.finish(), errors: make(map[string]int), totalKeys: len(data.Data)}
+		afterTotal, afterWrites := counter.snapshot()
+		r.commands, r.writes = afterTotal-beforeTotal, afterWrites-beforeWrites
+		for _, usage := range data.Data {
+			if usage.ErrorCode == "" {
+				r.ok++
+			} else {
+				r.errors[usage.ErrorCode]++
+			}
+		}
+		results = append(results, r)
+	}
+
+	report(keys, workers, queue, batch, latency, errorRate, redisURL, seedTime, results)
+	return nil
+}
+
+// seed stores fake keys in batches
+func seed(store *storage.Storage, count int) error {
+	const seedBatch = 1000
+	now := time.Now()
+	for start := 0; start < count; start += seedBatch {
+		end := start + seedBatch
+		if end > count {
+			end = count
+		}
+		keys := make([]*storage.APIKey, 0, end-start)
+		for i := start; i < end; i++ {
+			keys = append(keys, &storage.APIKey{
+				ID:        fmt.Sprintf("bench-%08d", i),
+				Key:       fmt.Sprintf("fk-bench-%08d-%d", i, now.UnixNano()),
+				Name:      fmt.Sprintf("bench %d", i),
+				Group:     fmt.Sprintf("group-%d", i%10),
+				CreatedAt: now,
+			})
+		}
+		for id, err := range store.BatchCreateAPIKeys(keys) {
+			if err != storage.ErrDuplicate {
+				return fmt.Errorf("seed %s: %w", id, err)
+			}
+		}
+	}
+	return nil
+}
+
+// cleanup removes everything the benchmark wrote to a real Redis
+func cleanup(client *redis.Client) {
+	ctx := context.Background()
+	var cursor uint64
+	for {
+		keys, next, err := client.Scan(ctx, cursor, benchPrefix+"*", 1000).Result()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "bench: cleanup: %v\n", err)
+			return
+		}
+		if len(keys) > 0 {
+			client.Del(ctx, keys...)
+		}
+		if next == 0 {
+			return
+		}
+		cursor = next
+	}
+}
+
+func report(keys, workers, queue, batch int, latency time.Duration, errorRate float64, redisURL string, seedTime time.Duration, results []round) {
+	backend := "in-memory (heap includes the stored data)"
+	if redisURL != "" {
+		backend = redisURL
+	}
+
+	fmt.Println()
+	fmt.Println("Benchmark report")
+	fmt.Printf("  keys=%d workers=%d queue=%d batch=%d latency=%s error-rate=%.2f\n", keys, workers, queue, batch, latency, errorRate)
+	fmt.Printf("  storage: %s\n", backend)
+	fmt.Printf("  seeding: %s (%.0f keys/s)\n\n", seedTime.Round(time.Millisecond), float64(keys)/seedTime.Seconds())
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "round\tduration\tkeys/s\tok\terrors\tredis cmds/s\tredis writes/s\tpeak heap MB\t")
+	unfetched := 0
+	for i, r := range results {
+		seconds := r.duration.Seconds()
+		failed := r.totalKeys - r.ok
+		fmt.Fprintf(w, "%d\t%s\t%.0f\t%d\t%d\t%.0f\t%.0f\t%.1f\t\n",
+			i+1, r.duration.Round(time.Millisecond), float64(r.totalKeys)/seconds, r.ok, failed,
+			float64(r.commands)/seconds, float64(r.writes)/seconds, float64(r.peakHeap)/(1<<20))
+		unfetched += r.errors[services.UsageErrQueueFull] + r.errors[services.UsageErrProcessingTimeout]
+	}
+	w.Flush()
+
+	fmt.Println()
+	for i, r := range results {
+		if len(r.errors) == 0 {
+			continue
+		}
+		parts := make([]string, 0, len(r.errors))
+		for code, n := range r.errors {
+			parts = append(parts, fmt.Sprintf("%s=%d", code, n))
+		}
+		fmt.Printf("  round %d errors: %s\n", i+1, strings.Join(parts, " "))
+	}
+
+	// Keys that never reached a worker mean the pool is too small for the load
+	if unfetched > 0 {
+		fmt.Printf("\n  %d fetches never ran (queue full or processing timeout): raise -workers or -queue\n", unfetched)
+	} else {
+		fmt.Println("\n  every key was fetched in every round")
+	}
+}
@@ -2,20 +2,25 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"os"
 	"os/signal"
+	"path"
+	"runtime/debug"
 	"syscall"
 	"time"
 
 	"github.com/droid-keyusage-go/internal/api"
 	"github.com/droid-keyusage-go/internal/config"
+	"github.com/droid-keyusage-go/internal/i18n"
 	"github.com/droid-keyusage-go/internal/services"
 	"github.com/droid-keyusage-go/internal/storage"
 	"github.com/droid-keyusage-go/internal/utils"
 	"github.com/gofiber/fiber/v2"
-	"github.com/gofiber/fiber/v2/middleware/cors"
+	"github.com/gofiber/fiber/v2/middleware/helmet"
 	"github.com/gofiber/fiber/v2/middleware/logger"
 	"github.com/gofiber/fiber/v2/middleware/recover"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
 	"github.com/joho/godotenv"
 )
 
@@ -27,71 +32,325 @@ func main() {
 	log := utils.NewLogger()
 	defer log.Sync()
 
-	// Load configuration
+	// Stop requests arrive as signals or, for a Windows service, from the
+	// service manager, which is told when the server is ready and stopped
+	sigChan := make(chan os.Signal, 1)
+	supervisor, err := newSupervisor(sigChan, log)
+	if err != nil {
+		log.Fatal("Failed to start as a service", "error", err)
+	}
+	defer supervisor.Stopped()
+
+	// Load configuration; files mounted in CONFIG_DIR override the
+	// environment
+	if err := config.ReadConfigDirs(); err != nil {
+		log.Fatal("Failed to read CONFIG_DIR", "error", err)
+	}
 	cfg := config.Load()
+	i18n.SetServerLang(cfg.LogLang)
+	configFiles, err := watchConfigDirs(cfg)
+	if err != nil {
+		log.Fatal("Failed to watch CONFIG_DIR", "error", err)
+	}
+
+	// Secrets kept in Vault or AWS Secrets Manager override the environment
+	secrets, err := loadSecrets(cfg)
+	if err != nil {
+		log.Fatal("Failed to read secrets", "backend", cfg.SecretsBackend, "error", err)
+	}
+	if secrets != nil {
+		applied := cfg.ApplySecrets(secrets.Values())
+		log.Info("Secrets loaded", "backend", cfg.SecretsBackend, "settings", applied)
+	}
+	services.SetCredentialSecrets(secrets, configFiles)
 	log.Info("Configuration loaded",
 		"redis_url", cfg.RedisURL,
 		"max_workers", cfg.MaxWorkers,
 		"port", cfg.Port,
 	)
 
-	// Initialize Redis
-	redisClient, err := storage.NewRedisClient(cfg.RedisURL)
-	if err != nil {
-		log.Fatal("Failed to connect to Redis", "error", err)
+	// An open dashboard must be asked for explicitly, and must not end up
+	// on a public address by accident
+	if cfg.OpenAccess() && !cfg.LoopbackOnly() {
+		if !cfg.AuthRiskAccepted {
+			log.Fatal("AUTH_DISABLED exposes the dashboard without login on every interface; "+
+				"set HOST=127.0.0.1 or I_UNDERSTAND_THE_RISK=true", "host", cfg.Host)
+		}
+		log.Warn("Authentication is disabled on a non-loopback address", "host", cfg.Host)
 	}
-	defer redisClient.Close()
 
-	log.Info("Connected to Redis successfully")
+	// Initialize Redis, or the in-memory store for tests and demos
+	var redisClient *storage.RedisClient
+	switch cfg.StorageBackend {
+	case "redis":
+		redisClient, err = storage.NewRedisClient(storage.RedisOptions{
+			URL:          cfg.RedisURL,
+			Password:     cfg.RedisPassword,
+			DB:           cfg.RedisDB,
+			PoolSize:     cfg.RedisPoolSize,
+			MinIdleConns: cfg.RedisMinIdleConns,
+			MaxRetries:   cfg.RedisMaxRetries,
+			DialTimeout:  cfg.RedisDialTimeout,
+			ReadTimeout:  cfg.RedisReadTimeout,
+			WriteTimeout: cfg.RedisWriteTimeout,
+			PoolTimeout:  cfg.RedisPoolTimeout,
+		})
+		if err != nil {
+			log.Fatal("Failed to connect to Redis", "error", err)
+		}
+		log.Info("Connected to Redis successfully")
+	case "memory":
+		redisClient, err = storage.NewMemoryClient()
+		if err != nil {
+			log.Fatal("Failed to start in-memory storage", "error", err)
+		}
+		log.Warn("Using in-memory storage, all data is lost on exit")
+	default:
+		log.Fatal("Invalid storage backend", "backend", cfg.StorageBackend)
+	}
+	defer redisClient.Close()
 
 	// Initialize storage
 	store := storage.NewStorage(redisClient)
 
-	// Initialize services
-	authService := services.NewAuthService(store, cfg.AdminPassword)
-	workerPool := services.NewWorkerPool(cfg.MaxWorkers, cfg.QueueSize)
-	apiKeyService := services.NewAPIKeyService(store, workerPool)
+	// Register the providers and channel types served by plugins
+	plugins, err := services.LoadPlugins(services.ParsePlugins(cfg.Plugins))
+	if err != nil {
+		log.Fatal("Failed to load plugins", "error", err)
+	}
+	defer plugins.Close()
+	for _, manifest := range plugins.Manifests() {
+		log.Info("Plugin loaded",
+			"name", manifest.Name,
+			"version", manifest.Version,
+			"providers", manifest.Providers,
+			"notifiers", manifest.Notifiers,
+		)
+	}
 
-	// Start worker pool
+	// Give each provider its own HTTP client, dialing through the DNS cache
+	services.ConfigureDNS(cfg.DNSCacheTTL, cfg.DNSFallbackDelay)
+	clientOverrides, err := services.ParseProviderClients(cfg.ProviderHTTPClients)
+	if err == nil {
+		err = services.ConfigureProviderClients(services.HTTPClientOptions{
+			Timeout:             cfg.HTTPTimeout.String(),
+			MaxIdleConnsPerHost: cfg.MaxWorkers,
+		}, clientOverrides)
+	}
+	if err != nil {
+		log.Fatal("Invalid PROVIDER_HTTP_CLIENTS", "error", err)
+	}
+
+	// Start worker pool, shared by all tenants
+	workerPool := services.NewWorkerPool(cfg.MaxWorkers, cfg.QueueSize)
+	workerPool.TrackUpstream(cfg.UpstreamWindow, cfg.UpstreamDegradedBelow)
+	workerPool.ConfigureTasks(cfg.TaskTimeout, cfg.SlowTaskThreshold)
+	workerPool.DedupeByKey(cfg.DedupeByKey)
+	workerPool.KeepRawResponses(cfg.UpstreamRawMaxBytes)
+	if cfg.ProviderMock {
+		workerPool.UseMock(services.NewMockFetcher(services.MockConfig{
+			Latency:    cfg.ProviderMockLatency,
+			ErrorRate:  cfg.ProviderMockErrorRate,
+			UsedMean:   cfg.ProviderMockUsedMean,
+			UsedStddev: cfg.ProviderMockUsedStddev,
+			Allowance:  cfg.ProviderMockAllowance,
+		}))
+		log.Warn("Provider mock mode enabled, usage data is synthetic")
+	}
 	workerPool.Start()
 	defer workerPool.Stop()
 
+	// Push metrics to StatsD when configured
+	metrics, err := services.NewStatsdEmitter(cfg.StatsdHost, cfg.StatsdPort, cfg.StatsdPrefix, cfg.StatsdTags)
+	if err != nil {
+		log.Error("Failed to set up StatsD, metrics disabled", "error", err)
+	}
+	defer metrics.Close()
+	queueMetrics := services.NewQueueMetrics(metrics, workerPool, cfg.StatsdInterval)
+	queueMetrics.Start()
+	defer queueMetrics.Stop()
+
+	// Local GeoIP databases enrich audit entries and the access log, and
+	// may restrict the countries administration is allowed from
+	geo, err := services.OpenGeoDB(cfg.GeoIPDB, cfg.GeoIPASNDB)
+	if err != nil {
+		log.Fatal("Failed to open GeoIP database", "error", err)
+	}
+	adminCountries, err := services.NewCountryPolicy(geo, cfg.AdminAllowedCountries, cfg.AdminBlockedCountries)
+	if err != nil {
+		log.Fatal("Invalid admin country restrictions", "error", err)
+	}
+
+	// The default tenant keeps the unprefixed keys; every other tenant gets
+	// its own Redis namespace and is served under its path or subdomain
+	tenantNames, err := api.ParseTenants(cfg.Tenants)
+	if err != nil {
+		log.Fatal("Invalid tenant configuration", "error", err)
+	}
+	if cfg.TenantMode != api.TenantModePath && cfg.TenantMode != api.TenantModeSubdomain {
+		log.Fatal("Invalid tenant mode", "mode", cfg.TenantMode)
+	}
+	defaultTenant := startTenant(api.DefaultTenant, cfg, store, workerPool, metrics, geo, adminCountries, log)
+	defer defaultTenant.stop()
+	tenants := []*tenant{defaultTenant}
+
+	tenantApps := make(map[string]*fiber.App, len(tenantNames))
+	if len(tenantNames) > 0 {
+		overview := map[string]*services.APIKeyService{api.DefaultTenant: defaultTenant.apiKeys}
+		var secretValues map[string]string
+		if secrets != nil {
+			secretValues = secrets.Values()
+		}
+		for _, name := range tenantNames {
+			tenantCfg, err := cfg.ForTenant(name, secretValues)
+			if err != nil {
+				log.Fatal("Invalid tenant configuration", "error", err)
+			}
+			tenantCfg.BasePath = api.TenantBasePath(cfg.TenantMode, cfg.BasePath, name)
+			tenantCfg.S3Prefix = path.Join(cfg.S3Prefix, "tenants", name)
+			t := startTenant(name, tenantCfg, store.WithPrefix(api.TenantRedisPrefix(name)), workerPool, metrics, geo, adminCountries, log)
+			defer t.stop()
+			tenants = append(tenants, t)
+
+			tenantApps[name] = newApp(tenantCfg.BasePath)
+			api.SetupRoutes(tenantApps[name], t.handlers)
+			overview[name] = t.apiKeys
+		}
+		defaultTenant.handlers.SetTenants(overview)
+		log.Info("Multi-tenancy enabled", "mode", cfg.TenantMode, "tenants", tenantNames)
+	}
+
+	// Pick up secrets rotated in the secret store, and changed files
+	if secrets != nil {
+		secrets.OnChange(rotateSecrets(tenants, log))
+		secrets.Start()
+		defer secrets.Stop()
+	}
+	if configFiles != nil {
+		configFiles.OnChange(reloadConfigFiles(cfg, tenants, sigChan, log))
+		configFiles.Start()
+		defer configFiles.Stop()
+	}
+
+	// Push metrics by Prometheus remote-write when configured
+	if labels, err := services.ParseRemoteWriteLabels(cfg.RemoteWriteLabels); err != nil {
+		log.Error("Invalid REMOTE_WRITE_LABELS, remote write disabled", "error", err)
+	} else {
+		usage := make(map[string]*services.APIKeyService, len(tenants))
+		for _, t := range tenants {
+			usage[t.name] = t.apiKeys
+		}
+		remoteWriter := services.NewRemoteWriter(services.RemoteWriteOptions{
+			URL:         cfg.RemoteWriteURL,
+			Username:    cfg.RemoteWriteUsername,
+			Password:    cfg.RemoteWritePassword,
+			BearerToken: cfg.RemoteWriteBearerToken,
+			Labels:      labels,
+			Interval:    cfg.RemoteWriteInterval,
+		}, workerPool, usage)
+		remoteWriter.Start()
+		defer remoteWriter.Stop()
+	}
+
 	// Initialize Fiber app
-	app := fiber.New(fiber.Config{
-		ErrorHandler: api.ErrorHandler,
-		ReadTimeout:  30 * time.Second,
-		WriteTimeout: 30 * time.Second,
-		IdleTimeout:  120 * time.Second,
-		ServerHeader: "Droid-KeyUsage",
-		AppName:      "Droid API Key Usage Monitor",
-	})
+	app := newApp(cfg.BasePath)
 
 	// Middlewares
-	app.Use(recover.New())
+	app.Use(requestid.New())
+	app.Use(recover.New(recover.Config{
+		EnableStackTrace: true,
+		StackTraceHandler: func(c *fiber.Ctx, e interface{}) {
+			log.Error("Panic recovered", "request_id", c.Locals("requestid"), "path", c.Path(), "panic", e, "stack", string(debug.Stack()))
+		},
+	}))
+	clientFormat := "${ip}"
+	if geo != nil {
+		clientFormat = "${ip} ${locals:geo}"
+	}
 	app.Use(logger.New(logger.Config{
-		Format:     "${time} | ${status} | ${latency} | ${ip} | ${method} | ${path} | ${error}\n",
+		Format:     "${time} | ${status} | ${latency} | " + clientFormat + " | ${locals:requestid} | ${method} | ${path} | ${error}\n",
 		TimeFormat: "2006-01-02 15:04:05",
 		TimeZone:   "Asia/Shanghai",
+		Output:     utils.NewRedactingWriter(os.Stdout),
 	}))
-	app.Use(cors.New(cors.Config{
-		AllowOrigins: "*",
-		AllowHeaders: "Origin, Content-Type, Accept, Authorization",
-		AllowMethods: "GET, POST, PUT, DELETE, OPTIONS",
+	if geo != nil {
+		app.Use(api.GeoLogMiddleware(geo))
+	}
+	app.Use(api.DebugLogMiddleware(cfg.DebugLogSamplePercent, cfg.DebugLogMaxBody, log))
+	app.Use(api.CORSMiddleware(cfg.CORSAllowedOrigins))
+	app.Use(helmet.New(helmet.Config{
+		ContentSecurityPolicy: cfg.ContentSecurityPolicy,
+		XFrameOptions:         cfg.FrameOptions,
+		ReferrerPolicy:        cfg.ReferrerPolicy,
+		HSTSMaxAge:            int(cfg.HSTSMaxAge / time.Second),
+		// Google Fonts are loaded without CORP headers
+		CrossOriginEmbedderPolicy: "unsafe-none",
 	}))
 
-	// Initialize handlers
-	handlers := api.NewHandlers(apiKeyService, authService, cfg)
+	// Setup routes; tenant requests are handed to the tenant's own app
+	if len(tenantApps) > 0 {
+		app.Use(api.TenantMiddleware(cfg.TenantMode, cfg.BasePath, tenantApps))
+	}
+	api.SetupRoutes(app, defaultTenant.handlers)
 
-	// Setup routes
-	api.SetupRoutes(app, handlers)
+	// Take over the socket of the process being upgraded, if any
+	ln, inherited, err := listen(cfg.ListenAddr())
+	if err != nil {
+		log.Fatal("Failed to start server", "error", err)
+	}
+	if inherited {
+		log.Info("Took over listener from the previous process", "addr", ln.Addr().String())
+	}
+	// TLS wraps the socket rather than replacing it, so upgrades still hand
+	// over the plain listener
+	serveLn := ln
+	tlsConfig, err := serverTLSConfig(cfg)
+	if err != nil {
+		log.Fatal("Invalid TLS configuration", "error", err)
+	}
+	if tlsConfig != nil {
+		serveLn = tls.NewListener(ln, tlsConfig)
+		log.Info("TLS enabled", "client_ca", cfg.TLSClientCAFile != "", "client_cert_required", cfg.TLSClientCertRequired)
+	}
+	if cfg.ClientCertScopes != "" && cfg.TLSClientCAFile == "" {
+		log.Warn("CLIENT_CERT_SCOPES is set but TLS_CLIENT_CA_FILE is not, client certificates are not checked")
+	}
 
-	// Graceful shutdown
+	// Graceful shutdown, and binary upgrades on SIGHUP: the new process
+	// takes over the socket, then this one finishes in-flight requests and
+	// the running scheduled refreshes before exiting
+	shutdownDone := make(chan struct{})
 	go func() {
-		sigChan := make(chan os.Signal, 1)
+		defer close(shutdownDone)
 		signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
-		<-sigChan
+		if cfg.GracefulUpgrade {
+			signal.Notify(sigChan, syscall.SIGHUP)
+		}
+		for sig := range sigChan {
+			if sig != syscall.SIGHUP {
+				break
+			}
+
+			log.Info("Upgrading server...")
+			if err := startUpgrade(ln); err != nil {
+				log.Error("Upgrade failed, still serving", "error", err)
+				continue
+			}
+			log.Info("New process is serving, draining", "timeout", cfg.UpgradeDrainTimeout)
+
+			drainCtx, cancel := context.WithTimeout(context.Background(), cfg.UpgradeDrainTimeout)
+			if err := app.ShutdownWithContext(drainCtx); err != nil {
+				log.Error("Server shutdown error", "error", err)
+			}
+			for _, t := range tenants {
+				t.drain(drainCtx)
+			}
+			cancel()
+			return
+		}
 
 		log.Info("Shutting down server...")
+		supervisor.Stopping()
 		
 		shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancel()
@@ -102,8 +361,32 @@ func main() {
 	}()
 
 	// Start server
-	log.Info("Starting server", "port", cfg.Port)
-	if err := app.Listen(":" + cfg.Port); err != nil {
+	if err := signalReady(); err != nil {
+		log.Error("Failed to signal the previous process", "error", err)
+	}
+	if cfg.PIDFile != "" {
+		if err := writePIDFile(cfg.PIDFile); err != nil {
+			log.Error("Failed to write PID file", "path", cfg.PIDFile, "error", err)
+		}
+	}
+	supervisor.Ready()
+	log.Info("Starting server", "addr", ln.Addr().String())
+	if err := app.Listener(serveLn); err != nil {
 		log.Fatal("Failed to start server", "error", err)
 	}
+	// Serving stops as soon as the listener closes; the background jobs are
+	// only stopped once the requests and refreshes have been drained
+	<-shutdownDone
+}
+
+// newApp creates a Fiber app serving routes under basePath
+func newApp(basePath string) *fiber.App {
+	return fiber.New(fiber.Config{
+		ErrorHandler: api.ErrorHandler(basePath),
+		ReadTimeout:  30 * time.Second,
+		WriteTimeout: 30 * time.Second,
+		IdleTimeout:  120 * time.Second,
+		ServerHeader: "Droid-KeyUsage",
+		AppName:      "Droid API Key Usage Monitor",
+	})
 }
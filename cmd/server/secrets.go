@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/droid-keyusage-go/internal/config"
+	"github.com/droid-keyusage-go/internal/services"
+	"go.uber.org/zap"
+)
+
+// secretsLoadTimeout bounds the first read of the secret store at startup
+const secretsLoadTimeout = 30 * time.Second
+
+// loadSecrets reads the secret store SECRETS_BACKEND names, and returns nil
+// when it names none
+func loadSecrets(cfg *config.Config) (*services.Secrets, error) {
+	var source services.SecretSource
+	var err error
+	switch cfg.SecretsBackend {
+	case "":
+		return nil, nil
+	case services.SecretsBackendVault:
+		source, err = services.NewVaultSource(services.VaultOptions{
+			Addr:      cfg.VaultAddr,
+			Token:     cfg.VaultToken,
+			Namespace: cfg.VaultNamespace,
+			Path:      cfg.VaultSecretPath,
+		})
+	case services.SecretsBackendAWS:
+		source, err = services.NewAWSSecretsSource(services.AWSSecretsOptions{
+			Region:       cfg.AWSRegion,
+			SecretID:     cfg.AWSSecretID,
+			AccessKey:    cfg.AWSAccessKeyID,
+			SecretKey:    cfg.AWSSecretAccessKey,
+			SessionToken: cfg.AWSSessionToken,
+			Endpoint:     cfg.AWSSecretsEndpoint,
+		})
+	default:
+		return nil, fmt.Errorf("unknown secrets backend %q", cfg.SecretsBackend)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	secrets := services.NewSecrets(source, cfg.SecretsRefreshInterval)
+	ctx, cancel := context.WithTimeout(context.Background(), secretsLoadTimeout)
+	defer cancel()
+	if err := secrets.Load(ctx); err != nil {
+		return nil, err
+	}
+	return secrets, nil
+}
+
+// rotateSecrets returns the OnChange function applying rotated secrets to
+// the running tenants: each tenant's passwords and the JWT secret take
+// effect at once, the other settings with the next restart. Credential
+// headers referring to secrets read them on every request anyway.
+func rotateSecrets(tenants []*tenant, log *zap.SugaredLogger) func(map[string]string, []string) {
+	return func(values map[string]string, changed []string) {
+		for _, t := range tenants {
+			updated := *t.cfg
+			updated.ApplySecrets(values)
+			t.auth.SetPasswords(updated.AdminPassword, updated.ViewerPassword)
+			t.auth.SetJWTSecret(updated.JWTSecret)
+		}
+		log.Info("Secrets changed", "names", changed)
+	}
+}
+
+// watchConfigDirs reads the files of CONFIG_DIR again whenever they change,
+// and every SECRETS_REFRESH_INTERVAL where watching isn't supported; it
+// returns nil without CONFIG_DIR
+func watchConfigDirs(cfg *config.Config) (*services.Secrets, error) {
+	if len(cfg.ConfigDirs) == 0 {
+		return nil, nil
+	}
+	source, err := services.NewDirSource(cfg.ConfigDirs)
+	if err != nil {
+		return nil, err
+	}
+	files := services.NewSecrets(source, cfg.SecretsRefreshInterval)
+	if err := files.Load(context.Background()); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// reloadConfigFiles returns the OnChange function applying changed files:
+// the secret settings like rotated secrets, any other setting with a
+// graceful upgrade, which starts a new process reading them, when
+// CONFIG_RELOAD and GRACEFUL_UPGRADE allow it
+func reloadConfigFiles(cfg *config.Config, tenants []*tenant, upgrade chan<- os.Signal, log *zap.SugaredLogger) func(map[string]string, []string) {
+	rotate := rotateSecrets(tenants, log)
+	return func(values map[string]string, changed []string) {
+		rotate(values, changed)
+
+		var restart []string
+		for _, name := range changed {
+			if !config.IsSecretSetting(name) {
+				restart = append(restart, name)
+			}
+		}
+		if len(restart) == 0 {
+			return
+		}
+		if !cfg.ConfigReload || !cfg.GracefulUpgrade {
+			log.Warn("Config files changed, restart to apply them", "names", restart)
+			return
+		}
+		log.Info("Config files changed, upgrading to apply them", "names", restart)
+		select {
+		case upgrade <- syscall.SIGHUP:
+		default:
+		}
+	}
+}
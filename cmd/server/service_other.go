@@ -0,0 +1,15 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+
+	"go.uber.org/zap"
+)
+
+// newSupervisor returns the systemd notifier; stop requests only arrive as
+// signals outside Windows
+func newSupervisor(stop chan<- os.Signal, log *zap.SugaredLogger) (supervisor, error) {
+	return newSystemdNotifier(log), nil
+}
@@ -0,0 +1,106 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"sync"
+	"syscall"
+
+	"go.uber.org/zap"
+	"golang.org/x/sys/windows/svc"
+)
+
+// serviceName is the name the server runs under as a Windows service; the
+// service manager ignores it for services with their own process
+const serviceName = "DroidKeyUsage"
+
+// stopWaitHint is how long the service manager is told a stop may take,
+// matching the shutdown timeout
+const stopWaitHint = 30000
+
+// windowsService reports the server's state to the Windows service manager
+// and turns its stop and shutdown requests into SIGTERM on stop
+type windowsService struct {
+	stop chan<- os.Signal
+	log  *zap.SugaredLogger
+
+	ready     chan struct{}
+	readyOnce sync.Once
+	stopped   chan struct{}
+	stopOnce  sync.Once
+	done      chan struct{}
+}
+
+// newSupervisor runs the server as a Windows service when the service
+// manager started it, and returns the systemd notifier, which does nothing
+// here, otherwise
+func newSupervisor(stop chan<- os.Signal, log *zap.SugaredLogger) (supervisor, error) {
+	isService, err := svc.IsWindowsService()
+	if err != nil {
+		return nil, err
+	}
+	if !isService {
+		return newSystemdNotifier(log), nil
+	}
+
+	s := &windowsService{
+		stop:    stop,
+		log:     log,
+		ready:   make(chan struct{}),
+		stopped: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go func() {
+		defer close(s.done)
+		if err := svc.Run(serviceName, s); err != nil {
+			log.Error("Windows service failed", "error", err)
+		}
+	}()
+	return s, nil
+}
+
+// Execute is called by the service manager for the lifetime of the service
+func (s *windowsService) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+	select {
+	case <-s.ready:
+	case <-s.stopped:
+		return false, 0
+	}
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+
+	for {
+		select {
+		case req := <-requests:
+			switch req.Cmd {
+			case svc.Interrogate:
+				status <- req.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending, WaitHint: stopWaitHint}
+				select {
+				case s.stop <- syscall.SIGTERM:
+				default:
+				}
+			}
+		case <-s.stopped:
+			return false, 0
+		}
+	}
+}
+
+// Ready reports the service as running
+func (s *windowsService) Ready() {
+	s.readyOnce.Do(func() { close(s.ready) })
+}
+
+// Stopping is reported by Execute when the service manager asked for the
+// stop; a stop from elsewhere is reported by Stopped
+func (s *windowsService) Stopping() {}
+
+// Stopped reports the service as stopped and waits until the service
+// manager has been told
+func (s *windowsService) Stopped() {
+	s.stopOnce.Do(func() { close(s.stopped) })
+	<-s.done
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// supervisor is told how the server is doing, so the process manager that
+// started it can follow: systemd or the Windows service manager
+type supervisor interface {
+	// Ready is called once the server is about to serve
+	Ready()
+	// Stopping is called when a graceful shutdown starts
+	Stopping()
+	// Stopped is called last, right before the process exits
+	Stopped()
+}
+
+// systemdNotifier reports readiness to systemd when it started the server
+// with Type=notify, and pings its watchdog when WatchdogSec is set; without
+// NOTIFY_SOCKET it does nothing
+type systemdNotifier struct {
+	socket   string
+	watchdog time.Duration
+	log      *zap.SugaredLogger
+
+	stopOnce sync.Once
+	shutdown chan struct{}
+}
+
+// newSystemdNotifier reads the notification socket and watchdog interval
+// systemd passed in the environment
+func newSystemdNotifier(log *zap.SugaredLogger) *systemdNotifier {
+	n := &systemdNotifier{
+		socket:   os.Getenv("NOTIFY_SOCKET"),
+		log:      log,
+		shutdown: make(chan struct{}),
+	}
+	// WATCHDOG_PID names the process systemd expects the pings from
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	pid := os.Getenv("WATCHDOG_PID")
+	if err == nil && usec > 0 && (pid == "" || pid == strconv.Itoa(os.Getpid())) {
+		n.watchdog = time.Duration(usec) * time.Microsecond
+	}
+	return n
+}
+
+// Ready tells systemd the server is serving, and that this process is now
+// the main one, which it isn't yet after an upgrade, then starts pinging the
+// watchdog at half its interval
+func (n *systemdNotifier) Ready() {
+	if n.socket == "" {
+		return
+	}
+	n.notify("MAINPID=" + strconv.Itoa(os.Getpid()) + "\nREADY=1")
+	if n.watchdog <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(n.watchdog / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				n.notify("WATCHDOG=1")
+			case <-n.shutdown:
+				return
+			}
+		}
+	}()
+}
+
+// Stopping tells systemd the server is shutting down
+func (n *systemdNotifier) Stopping() {
+	if n.socket != "" {
+		n.notify("STOPPING=1")
+	}
+}
+
+// Stopped stops pinging the watchdog
+func (n *systemdNotifier) Stopped() {
+	n.stopOnce.Do(func() { close(n.shutdown) })
+}
+
+// notify sends state to the notification socket; names starting with @ are
+// in the abstract namespace
+func (n *systemdNotifier) notify(state string) {
+	addr := &net.UnixAddr{Name: n.socket, Net: "unixgram"}
+	if strings.HasPrefix(addr.Name, "@") {
+		addr.Name = "\x00" + addr.Name[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, addr)
+	if err != nil {
+		n.log.Error("Failed to notify systemd", "error", err)
+		return
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		n.log.Error("Failed to notify systemd", "error", err)
+	}
+}
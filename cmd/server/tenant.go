@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/droid-keyusage-go/internal/api"
+	"github.com/droid-keyusage-go/internal/config"
+	"github.com/droid-keyusage-go/internal/models"
+	"github.com/droid-keyusage-go/internal/services"
+	"github.com/droid-keyusage-go/internal/storage"
+	"go.uber.org/zap"
+)
+
+// tenant holds the running services of one tenant
+type tenant struct {
+	name      string
+	cfg       *config.Config
+	handlers  *api.Handlers
+	auth      *services.AuthService
+	apiKeys   *services.APIKeyService
+	scheduler *services.RefreshScheduler
+	stops     []func()
+}
+
+// startTenant builds the services of one tenant on its own (namespaced)
+// storage and starts their background jobs; the worker pool, the metrics
+// connection and the GeoIP databases are shared
+func startTenant(name string, cfg *config.Config, store *storage.Storage, workerPool *services.WorkerPool, metrics *services.StatsdEmitter, geo *services.GeoDB, adminCountries *services.CountryPolicy, log *zap.SugaredLogger) *tenant {
+	if err := store.RebuildKeyIndex(); err != nil {
+		log.Error("Failed to rebuild key index", "tenant", name, "error", err)
+	}
+	if err := store.RebuildSearchIndex(); err != nil {
+		log.Error("Failed to rebuild search index", "tenant", name, "error", err)
+	}
+
+	// Initialize services
+	authService, err := services.NewAuthService(store, cfg.AdminPassword, cfg.ViewerPassword, cfg.Users, cfg.SessionTTL, cfg.SessionShortTTL, cfg.StepUpTTL)
+	if err != nil {
+		log.Fatal("Invalid USERS", "tenant", name, "error", err)
+	}
+	authService.ConfigureTokens(services.TokenConfig{
+		Secret:     cfg.JWTSecret,
+		Audience:   name,
+		AccessTTL:  cfg.JWTAccessTTL,
+		RefreshTTL: cfg.JWTRefreshTTL,
+	})
+	authService.ConfigureSetup(cfg.AuthDisabled)
+	authService.SetSlidingSessions(cfg.SessionSliding, cfg.SessionMaxAge)
+	if magicUsers, err := services.ParseMagicLinkUsers(cfg.MagicLinkUsers); err != nil {
+		log.Error("Invalid MAGIC_LINK_USERS, email login disabled", "tenant", name, "error", err)
+	} else if cfg.PublicURL != "" {
+		mailer := services.NewMailer(services.MailerConfig{
+			Host:     cfg.SMTPHost,
+			Port:     cfg.SMTPPort,
+			Username: cfg.SMTPUsername,
+			Password: cfg.SMTPPassword,
+			From:     cfg.SMTPFrom,
+		})
+		publicURL := strings.TrimRight(strings.ReplaceAll(cfg.PublicURL, "{tenant}", name), "/")
+		authService.ConfigureMagicLinks(magicUsers, mailer, publicURL+cfg.BasePath, cfg.MagicLinkTTL)
+	}
+	if certScopes, err := services.ParseClientCertScopes(cfg.ClientCertScopes); err != nil {
+		log.Error("Invalid CLIENT_CERT_SCOPES, client certificate auth disabled", "tenant", name, "error", err)
+	} else {
+		authService.ConfigureClientCerts(certScopes)
+	}
+	eventBus := services.NewEventBus(store)
+	maskPolicy := services.MaskPolicy{Prefix: cfg.MaskPrefixChars, Suffix: cfg.MaskSuffixChars}
+	apiKeyService := services.NewAPIKeyService(store, workerPool, eventBus, cfg.StorageBatchSize, cfg.CacheTTL, cfg.KeyFormatRules, maskPolicy)
+	apiKeyService.SetVisibility(cfg.KeyVisibility)
+	if groupTTLs, err := services.ParseGroupCacheTTLs(cfg.CacheTTLGroups); err != nil {
+		log.Error("Invalid CACHE_TTL_GROUPS, using CACHE_TTL for every group", "tenant", name, "error", err)
+	} else {
+		apiKeyService.SetGroupCacheTTLs(groupTTLs)
+	}
+	apiKeyService.SetMinRefreshInterval(cfg.MinRefreshInterval)
+	apiKeyService.SetRefreshChunkSize(cfg.RefreshChunkSize)
+	if err := apiKeyService.SetResultOrder(cfg.DataOrder); err != nil {
+		log.Error("Invalid DATA_ORDER, ordering by created_at", "tenant", name, "error", err)
+	}
+	if name != api.DefaultTenant {
+		metrics = metrics.WithTags("tenant:" + name)
+	}
+	apiKeyService.SetMetrics(metrics)
+	retentionService := services.NewRetentionService(store, cfg.PruneInterval, cfg.HistoryRetention)
+	notificationService := services.NewNotificationService(cfg.NotifyWebhookURL, cfg.NotifyWebhookSecret, cfg.NotifyQuietHours)
+	notifyChannels, err := services.ParseNotificationChannels(cfg.NotifyChannels)
+	if err != nil {
+		log.Error("Invalid NOTIFY_CHANNELS, only the webhook is notified", "tenant", name, "error", err)
+	}
+	notificationService.SetChannels(notifyChannels)
+	expiryService := services.NewExpiryService(store, notificationService, cfg.ExpiryCheckInterval, cfg.ExpiryReminderDays)
+	alertService := services.NewAlertService(store, notificationService, cfg.AlertUsageThreshold, cfg.AlertDedupWindow, eventBus)
+	alertService.SetUpstreamDownAfter(cfg.AlertUpstreamDownAfter)
+	healthService := services.NewHealthService(store, apiKeyService, workerPool, notificationService, cfg.AutoDisableFailures, cfg.KeyRecheckInterval)
+	apiKeyService.OnRefresh(alertService.Evaluate)
+	apiKeyService.OnRefresh(healthService.Track)
+	retryService := services.NewRetryService(store, apiKeyService, workerPool, cfg.RetryMaxAttempts, cfg.RetryBackoff, cfg.RetryCheckInterval)
+	apiKeyService.OnRefresh(retryService.Track)
+	scripts, err := services.LoadScriptHooks(cfg.HooksScript, cfg.HooksTimeout)
+	if err != nil {
+		log.Error("Invalid HOOKS_SCRIPT, hooks disabled", "tenant", name, "error", err)
+	} else if scripts != nil {
+		log.Info("Hook script loaded", "tenant", name, "path", cfg.HooksScript, "hooks", scripts.Defined())
+	}
+	apiKeyService.SetScriptHooks(scripts)
+	notificationService.SetScriptHooks(scripts)
+	retentionService.Register("alerts", cfg.AlertRetention, store.PruneAlerts)
+	retentionService.Register("deleted_keys", cfg.DeletedKeyRetention, store.PruneDeletedKeys)
+	retentionService.Register("job_history", cfg.JobHistoryRetention, store.PruneJobSummaries)
+	notificationService.SetDeliveryLog(store)
+	retentionService.Register("deliveries", cfg.DeliveryRetention, store.PruneDeliveries)
+	idempotencyService := services.NewIdempotencyService(store, cfg.IdempotencyTTL)
+	auditService := services.NewAuditService(store)
+	auditService.ConfigureLogins(services.NewGeoIP(cfg.GeoIPURL), notificationService, cfg.LoginNotifyNewIP)
+	auditService.SetGeoDB(geo)
+	retentionService.Register("audit", cfg.AuditRetention, store.PruneAudit)
+	reportService := services.NewReportService(store, apiKeyService, notificationService, cfg.ReportInterval, cfg.ReportFormats, cfg.ReportNotify)
+	retentionService.Register("reports", cfg.ReportRetention, store.PruneReports)
+
+	// Backups and reports are also pushed to the S3 bucket when configured
+	objectStore, err := services.NewObjectStore(services.ObjectStoreConfig{
+		Endpoint:  cfg.S3Endpoint,
+		Region:    cfg.S3Region,
+		Bucket:    cfg.S3Bucket,
+		AccessKey: cfg.S3AccessKey,
+		SecretKey: cfg.S3SecretKey,
+		Prefix:    cfg.S3Prefix,
+	})
+	if err != nil {
+		log.Error("Invalid S3 configuration, uploads disabled", "tenant", name, "error", err)
+	}
+	reportService.SetObjectStore(objectStore)
+	backupService := services.NewBackupService(store, objectStore, cfg.BackupInterval)
+	heartbeatService := services.NewHeartbeatService(apiKeyService,
+		tenantURL(cfg.HeartbeatURL, name), tenantURL(cfg.HeartbeatFailURL, name), cfg.HeartbeatInterval)
+	refreshScheduler := services.NewRefreshScheduler(apiKeyService, cfg.RefreshCheckInterval)
+	refreshScheduler.SetStartup(cfg.CacheWarmOnStart, cfg.RefreshOnStart)
+
+	// Runtime settings override the environment defaults
+	settingsService := services.NewSettingsService(store, eventBus, models.Settings{
+		CacheTTLSeconds:     int(cfg.CacheTTL / time.Second),
+		AlertUsageThreshold: cfg.AlertUsageThreshold,
+		AutoDisableFailures: cfg.AutoDisableFailures,
+		MaskPrefixChars:     cfg.MaskPrefixChars,
+		MaskSuffixChars:     cfg.MaskSuffixChars,
+		NotifyWebhookURL:    cfg.NotifyWebhookURL,
+		NotifyWebhookSecret: cfg.NotifyWebhookSecret,
+		NotifyQuietHours:    cfg.NotifyQuietHours,
+		ComputedFields:      cfg.ComputedFields,
+		NotifyChannels:      notifyChannels,
+	})
+	settingsService.OnChange(func(settings models.Settings) {
+		apiKeyService.SetCacheTTL(time.Duration(settings.CacheTTLSeconds) * time.Second)
+		apiKeyService.SetMaskPolicy(services.MaskPolicy{Prefix: settings.MaskPrefixChars, Suffix: settings.MaskSuffixChars})
+		alertService.SetUsageThreshold(settings.AlertUsageThreshold)
+		healthService.SetMaxFailures(settings.AutoDisableFailures)
+		notificationService.SetWebhook(settings.NotifyWebhookURL, settings.NotifyWebhookSecret, settings.NotifyQuietHours)
+		notificationService.SetChannels(settings.NotifyChannels)
+		if fields, err := services.ParseComputedFields(settings.ComputedFields); err != nil {
+			log.Error("Invalid COMPUTED_FIELDS, computed fields disabled", "tenant", name, "error", err)
+			apiKeyService.SetComputedFields(nil)
+		} else {
+			apiKeyService.SetComputedFields(fields)
+		}
+	})
+	if err := settingsService.Load(); err != nil {
+		log.Error("Failed to load settings, using defaults", "tenant", name, "error", err)
+	}
+
+	// Peers may be per tenant through a {tenant} placeholder, like the
+	// heartbeat URLs
+	federationPeers, err := services.ParseFederationPeers(tenantURL(cfg.FederationPeers, name))
+	if err != nil {
+		log.Error("Invalid FEDERATION_PEERS, federation disabled", "tenant", name, "error", err)
+	}
+	federation := services.NewFederation(cfg.FederationName, federationPeers, cfg.FederationInterval)
+
+	rateLimiter := services.NewRateLimiter(store, cfg.RateLimit, cfg.RateLimitBurst)
+	if limits, err := services.ParseEndpointLimits(cfg.RateLimitEndpoints); err != nil {
+		log.Error("Invalid RATE_LIMIT_ENDPOINTS, endpoint limits disabled", "tenant", name, "error", err)
+	} else {
+		rateLimiter.SetEndpointLimits(limits)
+	}
+	if quotas, err := services.ParseTokenQuotas(cfg.TokenQuotas); err != nil {
+		log.Error("Invalid TOKEN_QUOTAS, quotas disabled", "tenant", name, "error", err)
+	} else {
+		rateLimiter.SetTokenQuotas(quotas)
+	}
+
+	t := &tenant{
+		name:      name,
+		cfg:       cfg,
+		handlers:  api.NewHandlers(apiKeyService, authService, retentionService, alertService, notificationService, idempotencyService, auditService, settingsService, reportService, retryService, rateLimiter, adminCountries, federation, cfg),
+		auth:      authService,
+		apiKeys:   apiKeyService,
+		scheduler: refreshScheduler,
+	}
+
+	// Listen for events from other replicas, deliver notifications, prune
+	// old data, send expiry reminders, re-check auto-disabled keys, render
+	// scheduled reports, upload backups, refresh usage for the heartbeat,
+	// refresh keys as they fall due, retry failed fetches and pull
+	// federation peers
+	eventBus.Start()
+	notificationService.Start()
+	retentionService.Start()
+	expiryService.Start()
+	healthService.Start()
+	reportService.Start()
+	backupService.Start()
+	heartbeatService.Start()
+	refreshScheduler.Start()
+	retryService.Start()
+	federation.Start()
+	t.stops = []func(){scripts.Close, eventBus.Stop, notificationService.Stop, retentionService.Stop, expiryService.Stop, healthService.Stop, reportService.Stop, backupService.Stop, heartbeatService.Stop, refreshScheduler.Stop, retryService.Stop, federation.Stop}
+
+	return t
+}
+
+// tenantURL fills the {tenant} placeholder of a URL; without a placeholder
+// the URL belongs to the default tenant only, so other tenants get none
+func tenantURL(url, name string) string {
+	if strings.Contains(url, "{tenant}") {
+		return strings.ReplaceAll(url, "{tenant}", name)
+	}
+	if name != api.DefaultTenant {
+		return ""
+	}
+	return url
+}
+
+// drain lets the tenant's running scheduled refresh finish, until ctx is
+// done, before stop is called
+func (t *tenant) drain(ctx context.Context) {
+	t.scheduler.Drain(ctx)
+}
+
+// stop stops the tenant's background jobs in reverse start order
+func (t *tenant) stop() {
+	for i := len(t.stops) - 1; i >= 0; i-- {
+		t.stops[i]()
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/droid-keyusage-go/internal/config"
+)
+
+// serverTLSConfig returns the TLS configuration of the listener, nil when
+// TLS_CERT_FILE is not set and the server speaks plain HTTP. With a client
+// CA, certificates signed by it are verified and authenticate their callers
+// (see services.ClientCertPrincipal); others are refused.
+func serverTLSConfig(cfg *config.Config) (*tls.Config, error) {
+	if cfg.TLSCertFile == "" && cfg.TLSKeyFile == "" {
+		if cfg.TLSClientCAFile != "" {
+			return nil, errors.New("TLS_CLIENT_CA_FILE needs TLS_CERT_FILE and TLS_KEY_FILE")
+		}
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if cfg.TLSClientCAFile == "" {
+		if cfg.TLSClientCertRequired {
+			return nil, errors.New("TLS_CLIENT_CERT_REQUIRED needs TLS_CLIENT_CA_FILE")
+		}
+		return tlsConfig, nil
+	}
+
+	pem, err := os.ReadFile(cfg.TLSClientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates in %s", cfg.TLSClientCAFile)
+	}
+	tlsConfig.ClientCAs = pool
+	// Browsers and probes without a certificate still reach the login page
+	// and /health unless certificates are required
+	tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	if cfg.TLSClientCertRequired {
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsConfig, nil
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Environment variables through which an upgrading process hands its
+// listening socket to the new one and learns when it is serving
+const (
+	listenFDEnv = "DROID_LISTEN_FD"
+	readyFDEnv  = "DROID_READY_FD"
+)
+
+// upgradeReadyTimeout bounds how long the new process may take to start
+// before the upgrade is given up and the old process keeps serving
+const upgradeReadyTimeout = 2 * time.Minute
+
+// listen returns the socket passed on by the process being upgraded, or a
+// new one on addr; inherited reports which
+func listen(addr string) (ln net.Listener, inherited bool, err error) {
+	fdEnv := os.Getenv(listenFDEnv)
+	if fdEnv == "" {
+		ln, err = net.Listen("tcp4", addr)
+		return ln, false, err
+	}
+	// Not handed down to a later upgrade, which passes its own
+	os.Unsetenv(listenFDEnv)
+
+	fd, err := strconv.Atoi(fdEnv)
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid %s %q", listenFDEnv, fdEnv)
+	}
+	file := os.NewFile(uintptr(fd), "listener")
+	defer file.Close()
+	ln, err = net.FileListener(file)
+	if err != nil {
+		return nil, false, fmt.Errorf("inherited listener: %w", err)
+	}
+	return ln, true, nil
+}
+
+// signalReady tells the process being upgraded, if any, that this one is
+// about to serve, so it stops accepting connections and drains
+func signalReady() error {
+	fdEnv := os.Getenv(readyFDEnv)
+	if fdEnv == "" {
+		return nil
+	}
+	os.Unsetenv(readyFDEnv)
+
+	fd, err := strconv.Atoi(fdEnv)
+	if err != nil {
+		return fmt.Errorf("invalid %s %q", readyFDEnv, fdEnv)
+	}
+	file := os.NewFile(uintptr(fd), "ready")
+	defer file.Close()
+	_, err = file.Write([]byte{1})
+	return err
+}
+
+// startUpgrade starts the binary on disk, which may have been replaced since
+// this process started, with the same arguments and the listening socket of
+// ln, and waits until it is ready to serve. Connections arriving meanwhile
+// wait in the socket's backlog, so none are refused. When it returns nil the
+// caller should stop accepting, let in-flight work finish and exit; on error
+// the new process has been stopped and this one keeps serving.
+func startUpgrade(ln net.Listener) error {
+	tcp, ok := ln.(*net.TCPListener)
+	if !ok {
+		return errors.New("listener can't be passed on")
+	}
+	listenerFile, err := tcp.File()
+	if err != nil {
+		return fmt.Errorf("listener: %w", err)
+	}
+	defer listenerFile.Close()
+
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer readyR.Close()
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	// ExtraFiles start at fd 3
+	cmd.ExtraFiles = []*os.File{listenerFile, readyW}
+	cmd.Env = append(upgradeEnv(), listenFDEnv+"=3", readyFDEnv+"=4")
+	err = cmd.Start()
+	readyW.Close()
+	if err != nil {
+		return fmt.Errorf("start %s: %w", exe, err)
+	}
+
+	ready := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 1)
+		_, err := readyR.Read(buf)
+		ready <- err
+	}()
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+
+	select {
+	case err = <-ready:
+		if err == nil {
+			return nil
+		}
+		// The pipe closed without a byte: the process exited
+		err = fmt.Errorf("new process exited before it was ready: %v", <-exited)
+	case err = <-exited:
+		err = fmt.Errorf("new process exited before it was ready: %v", err)
+	case <-time.After(upgradeReadyTimeout):
+		cmd.Process.Kill()
+		err = fmt.Errorf("new process not ready after %s", upgradeReadyTimeout)
+	}
+	return err
+}
+
+// upgradeEnv is the environment of the new process: WATCHDOG_PID is left
+// out, the new process pings the systemd watchdog once it takes over
+func upgradeEnv() []string {
+	var env []string
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, "WATCHDOG_PID=") {
+			env = append(env, kv)
+		}
+	}
+	return env
+}
+
+// writePIDFile records the serving process in path, replacing the file at
+// once so a supervisor following it never reads a partial one
+func writePIDFile(path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".pid-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := fmt.Fprintf(tmp, "%d\n", os.Getpid()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,49 @@
+package api
+
+import (
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/cors"
+)
+
+// corsAllowHeaders lists the request headers cross-origin clients may send
+const corsAllowHeaders = "Origin, Content-Type, Accept, Accept-Language, Authorization, Idempotency-Key, " + StepUpHeader
+
+// CORSMiddleware allows cross-origin requests from a comma-separated list of
+// origins such as "https://app.example.com,https://*.example.com". Session
+// cookies are only accepted from listed origins: "*" allows any origin
+// without credentials, and an empty list disables cross-origin access.
+func CORSMiddleware(allowedOrigins string) fiber.Handler {
+	var origins []string
+	wildcard := false
+	for _, origin := range strings.Split(allowedOrigins, ",") {
+		origin = strings.TrimRight(strings.TrimSpace(origin), "/")
+		if origin == "" {
+			continue
+		}
+		if origin == "*" {
+			wildcard = true
+			continue
+		}
+		origins = append(origins, origin)
+	}
+
+	if len(origins) == 0 && !wildcard {
+		return func(c *fiber.Ctx) error {
+			return c.Next()
+		}
+	}
+
+	config := cors.Config{
+		AllowHeaders: corsAllowHeaders,
+		AllowMethods: "GET, POST, PUT, PATCH, DELETE, OPTIONS",
+	}
+	if wildcard {
+		config.AllowOrigins = "*"
+	} else {
+		config.AllowOrigins = strings.Join(origins, ",")
+		config.AllowCredentials = true
+	}
+	return cors.New(config)
+}
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/droid-keyusage-go/internal/utils"
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+)
+
+// DebugLogMiddleware logs the request and response bodies of a sample of
+// API requests, percent out of every hundred, to help diagnose client
+// integrations. Bodies are cut to maxBody bytes and credentials are masked;
+// only JSON, text and form bodies are logged. percent <= 0 disables it.
+func DebugLogMiddleware(percent float64, maxBody int, log *zap.SugaredLogger) fiber.Handler {
+	if percent <= 0 {
+		return func(c *fiber.Ctx) error {
+			return c.Next()
+		}
+	}
+
+	return func(c *fiber.Ctx) error {
+		if !strings.Contains(c.Path(), "/api/") || rand.Float64()*100 >= percent {
+			return c.Next()
+		}
+
+		start := time.Now()
+		requestBody := debugBody(string(c.Request().Header.ContentType()), c.Body(), maxBody)
+
+		// Let the error handler write the response so its body is logged too
+		if err := c.Next(); err != nil {
+			if err := c.App().Config().ErrorHandler(c, err); err != nil {
+				_ = c.SendStatus(fiber.StatusInternalServerError)
+			}
+		}
+
+		responseBody := "(stream)"
+		if !c.Response().IsBodyStream() {
+			responseBody = debugBody(string(c.Response().Header.ContentType()), c.Response().Body(), maxBody)
+		}
+		log.Infow("HTTP debug",
+			"request_id", c.Locals("requestid"),
+			"method", c.Method(),
+			"url", utils.Redact(c.OriginalURL()),
+			"status", c.Response().StatusCode(),
+			"latency", time.Since(start).String(),
+			"request_body", requestBody,
+			"response_body", responseBody,
+		)
+		return nil
+	}
+}
+
+// debugBody returns a body fit for the debug log: masked, cut to maxBody
+// bytes, and left out unless it is text
+func debugBody(contentType string, body []byte, maxBody int) string {
+	if len(body) == 0 {
+		return ""
+	}
+	contentType = strings.ToLower(contentType)
+	switch {
+	case strings.Contains(contentType, "json"):
+		return truncateBody(utils.RedactJSON(body), maxBody)
+	case strings.HasPrefix(contentType, "text/"), strings.Contains(contentType, "x-www-form-urlencoded"):
+		return truncateBody(utils.Redact(string(body)), maxBody)
+	}
+	return "(" + contentType + ", " + strconv.Itoa(len(body)) + " bytes)"
+}
+
+// truncateBody cuts s to at most maxBody bytes; maxBody <= 0 keeps it whole
+func truncateBody(s string, maxBody int) string {
+	if maxBody <= 0 || len(s) <= maxBody {
+		return s
+	}
+	return strings.ToValidUTF8(s[:maxBody], "") + "…(truncated)"
+}
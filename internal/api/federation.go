@@ -0,0 +1,39 @@
+package api
+
+import (
+	"github.com/droid-keyusage-go/internal/services"
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetFederationSummary returns the aggregated usage of this instance, the
+// summary federation peers pull; it holds no keys
+func (h *Handlers) GetFederationSummary(c *fiber.Ctx) error {
+	stats, err := h.apiKeyService.GetStats(c.UserContext(), requestPrincipal(c))
+	if err != nil {
+		return err
+	}
+	return c.JSON(services.NewFederationSummary(h.config.FederationName, stats))
+}
+
+// GetFederation returns the combined usage of this instance and its
+// federation peers (admin only)
+func (h *Handlers) GetFederation(c *fiber.Ctx) error {
+	if h.federation == nil {
+		return fiber.ErrNotFound
+	}
+	stats, err := h.apiKeyService.GetStats(c.UserContext(), requestPrincipal(c))
+	if err != nil {
+		return err
+	}
+	local := services.NewFederationSummary(h.config.FederationName, stats)
+	return c.JSON(h.federation.Overview(local))
+}
+
+// GetFederationPeers lists the federation peers with the summaries last
+// pulled from them (admin only)
+func (h *Handlers) GetFederationPeers(c *fiber.Ctx) error {
+	if h.federation == nil {
+		return fiber.ErrNotFound
+	}
+	return sendList(c, h.federation.Instances())
+}
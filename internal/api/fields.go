@@ -0,0 +1,145 @@
+package api
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/droid-keyusage-go/internal/models"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Sparse field responses: list endpoints accept ?fields=id,name,remaining
+// to return only some fields of each item, which keeps large responses
+// small for bandwidth-sensitive clients
+
+// parseFields reads ?fields= and checks every name against the JSON fields
+// of itemType. It returns nil when the parameter is absent, meaning all
+// fields.
+func parseFields(c *fiber.Ctx, itemType reflect.Type) ([]string, *models.FieldError) {
+	spec := c.Query("fields")
+	if spec == "" {
+		return nil, nil
+	}
+
+	known := jsonFields(itemType)
+	fields := make([]string, 0)
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !known[name] {
+			names := make([]string, 0, len(known))
+			for n := range known {
+				names = append(names, n)
+			}
+			sort.Strings(names)
+			return nil, &models.FieldError{
+				Field:   "fields",
+				Rule:    "oneof",
+				Message: msg(c, "field.oneof", strings.Join(names, " ")),
+			}
+		}
+		fields = append(fields, name)
+	}
+	return fields, nil
+}
+
+// jsonFields returns the JSON names of a struct's fields, including those
+// of embedded structs
+func jsonFields(t reflect.Type) map[string]bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	fields := make(map[string]bool)
+	if t.Kind() != reflect.Struct {
+		return fields
+	}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" {
+			for embedded := range jsonFields(f.Type) {
+				fields[embedded] = true
+			}
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields[name] = true
+	}
+	return fields
+}
+
+// sparseItems re-encodes a slice keeping only the given fields of each
+// item; omitted empty fields stay omitted
+func sparseItems(items interface{}, fields []string) ([]map[string]json.RawMessage, error) {
+	data, err := json.Marshal(items)
+	if err != nil {
+		return nil, err
+	}
+	var decoded []map[string]json.RawMessage
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return nil, err
+	}
+
+	sparse := make([]map[string]json.RawMessage, len(decoded))
+	for i, item := range decoded {
+		sparse[i] = make(map[string]json.RawMessage, len(fields))
+		for _, field := range fields {
+			if value, ok := item[field]; ok {
+				sparse[i][field] = value
+			}
+		}
+	}
+	return sparse, nil
+}
+
+// sendSparseEnvelope responds with an object whose list under key keeps
+// only the given fields of each item
+func sendSparseEnvelope(c *fiber.Ctx, envelope interface{}, key string, items interface{}, fields []string) error {
+	data, err := json.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+	var decoded map[string]json.RawMessage
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+
+	sparse, err := sparseItems(items, fields)
+	if err != nil {
+		return err
+	}
+	if decoded[key], err = json.Marshal(sparse); err != nil {
+		return err
+	}
+	return c.JSON(decoded)
+}
+
+// sendList responds with a slice, honoring ?fields=
+func sendList(c *fiber.Ctx, items interface{}) error {
+	fields, fieldErr := parseFields(c, reflect.TypeOf(items).Elem())
+	if fieldErr != nil {
+		return writeFieldErrors(c, *fieldErr)
+	}
+	if fields == nil {
+		return c.JSON(items)
+	}
+
+	sparse, err := sparseItems(items, fields)
+	if err != nil {
+		return err
+	}
+	return c.JSON(sparse)
+}
@@ -0,0 +1,21 @@
+package api
+
+import (
+	"github.com/droid-keyusage-go/internal/services"
+	"github.com/gofiber/fiber/v2"
+)
+
+// localsGeo holds the country and network of the client address, e.g.
+// "US AS15169", for the access log
+const localsGeo = "geo"
+
+// GeoLogMiddleware looks up the client address in the local GeoIP
+// databases, so the access log can show where requests come from
+func GeoLogMiddleware(geo *services.GeoDB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if info := geo.Lookup(c.IP()).String(); info != "" {
+			c.Locals(localsGeo, info)
+		}
+		return c.Next()
+	}
+}
@@ -0,0 +1,119 @@
+package api
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/droid-keyusage-go/internal/models"
+	"github.com/droid-keyusage-go/internal/services"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Handlers for the Grafana JSON datasource (simpod-json-datasource) and
+// the Infinity datasource, serving the usage history under /api/grafana
+
+// GrafanaTest answers the datasource's connection test
+func (h *Handlers) GrafanaTest(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{"status": "ok"})
+}
+
+// GrafanaSearch lists the targets matching the query, as {text, value}
+// pairs for /search and {label, value} pairs for /metrics
+func (h *Handlers) GrafanaSearch(c *fiber.Ctx) error {
+	var req models.GrafanaSearchRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return writeBindError(c, err)
+		}
+	}
+
+	targets, err := h.apiKeyService.SearchGrafanaTargets(c.UserContext(), req.Target, requestPrincipal(c))
+	if err != nil {
+		return err
+	}
+	if strings.HasSuffix(c.Path(), "/metrics") {
+		metrics := make([]fiber.Map, len(targets))
+		for i, t := range targets {
+			metrics[i] = fiber.Map{"label": t.Text, "value": t.Value}
+		}
+		return c.JSON(metrics)
+	}
+	return c.JSON(targets)
+}
+
+// GrafanaQuery returns each target as a time series, or as a table of
+// time and value for targets of type "table"
+func (h *Handlers) GrafanaQuery(c *fiber.Ctx) error {
+	var req models.GrafanaQueryRequest
+	if err := c.BodyParser(&req); err != nil {
+		return writeBindError(c, err)
+	}
+
+	from, to := req.Range.From, req.Range.To
+	if to.IsZero() {
+		to = time.Now()
+	}
+	if from.IsZero() {
+		from = to.Add(-24 * time.Hour)
+	}
+	if !to.After(from) {
+		return writeFieldErrors(c, models.FieldError{
+			Field:   "range",
+			Rule:    "time_range",
+			Message: msg(c, "field.time_range"),
+		})
+	}
+
+	interval := time.Duration(req.IntervalMs) * time.Millisecond
+	if req.MaxDataPoints > 0 {
+		if floor := to.Sub(from) / time.Duration(req.MaxDataPoints); interval < floor {
+			interval = floor
+		}
+	}
+	if interval < time.Second {
+		interval = time.Minute
+	}
+
+	principal := requestPrincipal(c)
+	results := make([]interface{}, 0, len(req.Targets))
+	for _, target := range req.Targets {
+		if target.Hide || target.Target == "" {
+			continue
+		}
+
+		series, err := h.apiKeyService.GrafanaSeries(c.UserContext(), target.Target, from, to, interval, principal)
+		if err != nil {
+			switch {
+			case errors.Is(err, services.ErrUnknownMetric):
+				return writeFieldErrors(c, models.FieldError{
+					Field:   "targets",
+					Rule:    "oneof",
+					Message: msg(c, "field.oneof", strings.Join(services.GrafanaMetrics, " ")),
+				})
+			case errors.Is(err, services.ErrKeyNotFound):
+				return writeError(c, 404, "error.key_not_found")
+			}
+			return err
+		}
+
+		if target.Type != "table" {
+			results = append(results, series)
+			continue
+		}
+		table := models.GrafanaTable{
+			Type: "table",
+			Columns: []models.GrafanaColumn{
+				{Text: "Time", Type: "time"},
+				{Text: series.Target, Type: "number"},
+			},
+			Rows: make([][]interface{}, len(series.Datapoints)),
+		}
+		for i, point := range series.Datapoints {
+			table.Rows[i] = []interface{}{int64(point[1]), point[0]}
+		}
+		results = append(results, table)
+	}
+
+	return c.JSON(results)
+}
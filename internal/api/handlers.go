@@ -1,27 +1,66 @@
 package api
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/droid-keyusage-go/internal/config"
 	"github.com/droid-keyusage-go/internal/models"
 	"github.com/droid-keyusage-go/internal/services"
+	"github.com/droid-keyusage-go/internal/storage"
+	"github.com/droid-keyusage-go/internal/utils"
 	"github.com/gofiber/fiber/v2"
 )
 
 // Handlers contains all HTTP handlers
 type Handlers struct {
-	apiKeyService *services.APIKeyService
-	authService   *services.AuthService
-	config        *config.Config
+	apiKeyService    *services.APIKeyService
+	authService      *services.AuthService
+	retentionService *services.RetentionService
+	alertService     *services.AlertService
+	notifier         *services.NotificationService
+	idempotency      *services.IdempotencyService
+	audit            *services.AuditService
+	settings         *services.SettingsService
+	reports          *services.ReportService
+	retries          *services.RetryService
+	limiter          *services.RateLimiter
+	adminCountries   *services.CountryPolicy
+	federation       *services.Federation
+	tenants          map[string]*services.APIKeyService
+	static           fs.FS
+	config           *config.Config
 }
 
 // NewHandlers creates new handlers
-func NewHandlers(apiKeyService *services.APIKeyService, authService *services.AuthService, cfg *config.Config) *Handlers {
+func NewHandlers(apiKeyService *services.APIKeyService, authService *services.AuthService, retentionService *services.RetentionService, alertService *services.AlertService, notifier *services.NotificationService, idempotency *services.IdempotencyService, audit *services.AuditService, settings *services.SettingsService, reports *services.ReportService, retries *services.RetryService, limiter *services.RateLimiter, adminCountries *services.CountryPolicy, federation *services.Federation, cfg *config.Config) *Handlers {
 	return &Handlers{
-		apiKeyService: apiKeyService,
-		authService:   authService,
-		config:        cfg,
+		apiKeyService:    apiKeyService,
+		authService:      authService,
+		retentionService: retentionService,
+		alertService:     alertService,
+		notifier:         notifier,
+		idempotency:      idempotency,
+		audit:            audit,
+		settings:         settings,
+		reports:          reports,
+		retries:          retries,
+		limiter:          limiter,
+		adminCountries:   adminCountries,
+		federation:       federation,
+		static:           staticRoot(cfg.StaticDir),
+		config:           cfg,
 	}
 }
 
@@ -34,35 +73,409 @@ func (h *Handlers) Health(c *fiber.Ctx) error {
 	})
 }
 
+// Ready is the readiness probe: unavailable without Redis, degraded (but
+// still serving) when a provider's recent success rate is too low
+func (h *Handlers) Ready(c *fiber.Ctx) error {
+	readiness := models.Readiness{
+		Status:   "ready",
+		Time:     time.Now().Format(time.RFC3339),
+		Storage:  "ok",
+		Upstream: h.apiKeyService.UpstreamStats(),
+	}
+	if err := h.apiKeyService.PingStorage(); err != nil {
+		readiness.Status = "unavailable"
+		readiness.Storage = "unreachable"
+		return c.Status(fiber.StatusServiceUnavailable).JSON(readiness)
+	}
+
+	for provider, stats := range readiness.Upstream {
+		if stats.Degraded {
+			readiness.DegradedProviders = append(readiness.DegradedProviders, provider)
+		}
+	}
+	if len(readiness.DegradedProviders) > 0 {
+		sort.Strings(readiness.DegradedProviders)
+		readiness.Status = "degraded"
+	}
+	return c.JSON(readiness)
+}
+
+// Index serves the dashboard page for the base path and any unmatched
+// non-API route, pointing relative asset and API URLs at the base path
+func (h *Handlers) Index(c *fiber.Ctx) error {
+	// Unknown API routes should not fall back to the dashboard
+	if strings.HasPrefix(strings.TrimPrefix(c.Path(), h.config.BasePath), "/api/") {
+		return fiber.ErrNotFound
+	}
+
+	page, err := fs.ReadFile(h.static, "index.html")
+	if err != nil {
+		return fiber.ErrNotFound
+	}
+
+	baseTag := `<head>
+    <base href="` + h.config.BasePath + `/">`
+	html := strings.Replace(string(page), "<head>", baseTag, 1)
+
+	c.Set(fiber.HeaderContentType, fiber.MIMETextHTMLCharsetUTF8)
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	// The static middleware marks unknown paths 404 before falling through
+	return c.Status(fiber.StatusOK).SendString(html)
+}
+
+// cookiePath scopes cookies to the base path so multiple apps can share a host
+func (h *Handlers) cookiePath() string {
+	return h.config.BasePath + "/"
+}
+
 // Login handles authentication
 func (h *Handlers) Login(c *fiber.Ctx) error {
 	var req models.LoginRequest
-	if err := c.BodyParser(&req); err != nil {
-		return c.Status(400).JSON(models.ErrorResponse{Error: "Invalid request"})
+	if err := bindAndValidate(c, &req); err != nil {
+		return writeBindError(c, err)
 	}
 
-	if !h.authService.ValidatePassword(req.Password) {
-		return c.Status(401).JSON(models.ErrorResponse{Error: "Invalid password"})
+	principal, ok := h.authService.Authenticate(req.Username, req.Password)
+	h.recordLogin(c, principal, req.Username, "password", ok)
+	if !ok {
+		return writeError(c, 401, "error.invalid_password")
 	}
 
-	// Create session
-	sessionID, err := h.authService.CreateSession()
+	return h.startSession(c, principal, req.Remember)
+}
+
+// startSession creates a session and sets its cookie
+func (h *Handlers) startSession(c *fiber.Ctx, principal services.Principal, remember bool) error {
+	sessionID, err := h.authService.CreateSession(principal, remember)
 	if err != nil {
-		return c.Status(500).JSON(models.ErrorResponse{Error: "Failed to create session"})
+		return writeError(c, 500, "error.session_create_failed")
 	}
 
-	// Set session cookie
+	// Set session cookie; without "remember me" it is a browser session
+	// cookie and the server drops the session after the short TTL
+	var expires time.Time
+	if remember {
+		expires = time.Now().Add(h.authService.SessionTTL())
+	}
+	c.Cookie(h.sessionCookie(sessionID, expires))
+
+	return c.JSON(models.SuccessResponse{Success: true})
+}
+
+// sessionCookie builds the session cookie; a zero expires makes it a
+// browser session cookie
+func (h *Handlers) sessionCookie(sessionID string, expires time.Time) *fiber.Cookie {
 	// Only use Secure flag in production (HTTPS)
 	secure := h.config.Env == "production"
-	c.Cookie(&fiber.Cookie{
+	return &fiber.Cookie{
 		Name:     "session",
 		Value:    sessionID,
-		Expires:  time.Now().Add(7 * 24 * time.Hour),
+		Expires:  expires,
+		Path:     h.cookiePath(),
 		HTTPOnly: true,
 		Secure:   secure,
 		SameSite: "Lax",
+	}
+}
+
+// RenewSession extends the session of a caller signed in with the session
+// cookie while they are active (see AuthService.RenewSession) and moves the
+// expiry of a remembered session's cookie along with it
+func (h *Handlers) RenewSession(c *fiber.Ctx) error {
+	session, ok := c.Locals(localsSession).(*storage.Session)
+	if ok && h.authService.RenewSession(session) && session.Remember {
+		c.Cookie(h.sessionCookie(session.ID, session.ExpiresAt))
+	}
+	return c.Next()
+}
+
+// SetupStatus tells whether the first-run setup still has to be run
+func (h *Handlers) SetupStatus(c *fiber.Ctx) error {
+	return c.JSON(h.authService.SetupStatus())
+}
+
+// Setup runs the first-run setup of a fresh install: it sets the admin
+// password, generates the JWT secret, applies the initial settings and
+// logs the caller in as admin
+func (h *Handlers) Setup(c *fiber.Ctx) error {
+	var req models.SetupRequest
+	if err := bindAndValidate(c, &req); err != nil {
+		return writeBindError(c, err)
+	}
+	if !h.authService.SetupRequired() {
+		return services.ErrSetupComplete
+	}
+
+	// Settings are checked first so a rejected request leaves the install
+	// untouched
+	if req.Settings != nil {
+		if err := h.settings.Check(req.Settings); err != nil {
+			return writeSettingsError(c, err)
+		}
+	}
+	if err := h.authService.CompleteSetup(req.Password); err != nil {
+		return err
+	}
+	principal := services.Principal{Role: services.RoleAdmin}
+	c.Locals(localsRole, principal.Role)
+	h.recordAudit(c, services.AuditSetup, "", true, "")
+
+	if req.Settings != nil {
+		settings, err := h.settings.Update(req.Settings)
+		if err != nil {
+			return writeSettingsError(c, err)
+		}
+		h.recordAudit(c, services.AuditSettings, "", true, strings.Join(settings.Overridden, ","))
+	}
+
+	return h.startSession(c, principal, req.Remember)
+}
+
+// LoginMethods tells the login page which ways to log in are available
+func (h *Handlers) LoginMethods(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{
+		"password":   true,
+		"magic_link": h.authService.MagicLinksEnabled(),
+	})
+}
+
+// RequestMagicLink emails a one-time login link. It answers the same way
+// whether or not the address may log in.
+func (h *Handlers) RequestMagicLink(c *fiber.Ctx) error {
+	if !h.authService.MagicLinksEnabled() {
+		return fiber.ErrNotFound
+	}
+	var req models.MagicLinkRequest
+	if err := bindAndValidate(c, &req); err != nil {
+		return writeBindError(c, err)
+	}
+
+	if err := h.authService.SendMagicLink(req.Email); err != nil {
+		return err
+	}
+	return c.Status(fiber.StatusAccepted).JSON(models.SuccessResponse{Success: true})
+}
+
+// MagicLinkLogin exchanges the token of a login link for a session
+func (h *Handlers) MagicLinkLogin(c *fiber.Ctx) error {
+	if !h.authService.MagicLinksEnabled() {
+		return fiber.ErrNotFound
+	}
+	var req models.MagicLinkLoginRequest
+	if err := bindAndValidate(c, &req); err != nil {
+		return writeBindError(c, err)
+	}
+
+	principal, err := h.authService.ExchangeMagicLink(req.Token)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidToken) {
+			h.recordLogin(c, principal, "", "magic_link", false)
+			return writeError(c, 401, "error.invalid_token")
+		}
+		return err
+	}
+	h.recordLogin(c, principal, "", "magic_link", true)
+	return h.startSession(c, principal, req.Remember)
+}
+
+// StepUp re-checks the caller's password and returns a short-lived token to
+// be sent in the X-Step-Up-Token header of its sensitive requests
+func (h *Handlers) StepUp(c *fiber.Ctx) error {
+	var req models.StepUpRequest
+	if err := bindAndValidate(c, &req); err != nil {
+		return writeBindError(c, err)
+	}
+
+	token, expiresAt, err := h.authService.StepUp(requestPrincipal(c), req.Password)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidPassword) {
+			h.recordAudit(c, services.AuditStepUp, "", false, "invalid password")
+			return writeError(c, 401, "error.invalid_password")
+		}
+		return err
+	}
+
+	h.recordAudit(c, services.AuditStepUp, "", true, "")
+	return c.JSON(fiber.Map{
+		"token":      token,
+		"expires_at": expiresAt,
+	})
+}
+
+// GetAudit lists recent audit entries, optionally filtered by ?action=
+func (h *Handlers) GetAudit(c *fiber.Ctx) error {
+	entries, err := h.audit.List(c.Query("action"), c.QueryInt("limit", 100))
+	if err != nil {
+		return err
+	}
+
+	return sendList(c, entries)
+}
+
+// GetLoginAudit lists recent login attempts, optionally only ?success=true
+// or false ones and those of ?actor=
+func (h *Handlers) GetLoginAudit(c *fiber.Ctx) error {
+	var success *bool
+	if value := c.Query("success"); value != "" {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return writeFieldErrors(c, models.FieldError{
+				Field:   "success",
+				Rule:    "oneof",
+				Message: msg(c, "field.oneof", "true false"),
+			})
+		}
+		success = &parsed
+	}
+
+	entries, err := h.audit.ListLogins(c.QueryInt("limit", 100), success, c.Query("actor"))
+	if err != nil {
+		return err
+	}
+	return sendList(c, entries)
+}
+
+// GetSettings returns the effective runtime settings (admin only)
+func (h *Handlers) GetSettings(c *fiber.Ctx) error {
+	return c.JSON(h.settings.Get())
+}
+
+// UpdateSettings overrides runtime settings; omitted fields are left as they are
+func (h *Handlers) UpdateSettings(c *fiber.Ctx) error {
+	var req models.SettingsUpdate
+	if err := bindAndValidate(c, &req); err != nil {
+		return writeBindError(c, err)
+	}
+
+	settings, err := h.settings.Update(&req)
+	if err != nil {
+		return writeSettingsError(c, err)
+	}
+
+	h.recordAudit(c, services.AuditSettings, "", true, strings.Join(settings.Overridden, ","))
+	return c.JSON(settings)
+}
+
+// writeSettingsError reports settings the service rejected as field errors
+func writeSettingsError(c *fiber.Ctx, err error) error {
+	var settingsErr *services.SettingsError
+	if errors.As(err, &settingsErr) {
+		return writeFieldErrors(c, models.FieldError{
+			Field:   settingsErr.Field,
+			Rule:    "format",
+			Message: settingsErr.Reason,
+		})
+	}
+	return err
+}
+
+// ResetSettings drops every override so the environment defaults apply again
+func (h *Handlers) ResetSettings(c *fiber.Ctx) error {
+	settings, err := h.settings.Reset()
+	if err != nil {
+		return err
+	}
+
+	h.recordAudit(c, services.AuditSettings, "", true, "reset")
+	return c.JSON(settings)
+}
+
+// recordAudit records an action taken by the current caller
+func (h *Handlers) recordAudit(c *fiber.Ctx, action, keyID string, success bool, detail string) {
+	h.audit.Record(&storage.AuditEntry{
+		Action:    action,
+		Actor:     requestActor(c),
+		IP:        c.IP(),
+		UserAgent: c.Get("User-Agent"),
+		KeyID:     keyID,
+		Success:   success,
+		Detail:    detail,
 	})
+}
+
+// recordLogin records a login attempt made with method. A failed attempt is
+// attributed to the username it gave, if any.
+func (h *Handlers) recordLogin(c *fiber.Ctx, principal services.Principal, username, method string, success bool) {
+	actor := principal.User
+	if actor == "" {
+		actor = principal.Role
+	}
+	if !success {
+		actor = strings.TrimSpace(username)
+	}
+
+	// The entry is completed in the background, after fiber has reused the
+	// request buffers, so nothing may point into them
+	h.audit.RecordLogin(&storage.AuditEntry{
+		Actor:     strings.Clone(actor),
+		IP:        strings.Clone(c.IP()),
+		UserAgent: strings.Clone(c.Get("User-Agent")),
+		Success:   success,
+		Detail:    method,
+	})
+}
+
+// IssueToken exchanges credentials for an access and a refresh token, for
+// API clients that cannot keep a session cookie
+func (h *Handlers) IssueToken(c *fiber.Ctx) error {
+	var req models.TokenRequest
+	if err := bindAndValidate(c, &req); err != nil {
+		return writeBindError(c, err)
+	}
+
+	scopes, err := services.ParseScopes(req.Scope)
+	if err != nil {
+		return writeFieldErrors(c, models.FieldError{
+			Field:   "scope",
+			Rule:    "oneof",
+			Message: msg(c, "field.oneof", strings.Join(services.Scopes, " ")),
+		})
+	}
+
+	principal, ok := h.authService.Authenticate(req.Username, req.Password)
+	h.recordLogin(c, principal, req.Username, "token", ok)
+	if !ok {
+		return writeError(c, 401, "error.invalid_password")
+	}
+	principal.Scopes = scopes
+
+	tokens, err := h.authService.IssueTokens(principal)
+	if err != nil {
+		return err
+	}
+	return c.JSON(tokens)
+}
+
+// RefreshToken exchanges a refresh token for a new token pair
+func (h *Handlers) RefreshToken(c *fiber.Ctx) error {
+	var req models.RefreshTokenRequest
+	if err := bindAndValidate(c, &req); err != nil {
+		return writeBindError(c, err)
+	}
+
+	tokens, err := h.authService.RefreshTokens(req.RefreshToken)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidToken) {
+			return writeError(c, 401, "error.invalid_token")
+		}
+		return err
+	}
+	return c.JSON(tokens)
+}
 
+// RevokeToken revokes an access or refresh token before it expires
+func (h *Handlers) RevokeToken(c *fiber.Ctx) error {
+	var req models.RefreshTokenRequest
+	if err := bindAndValidate(c, &req); err != nil {
+		return writeBindError(c, err)
+	}
+
+	if err := h.authService.RevokeToken(req.RefreshToken); err != nil {
+		if errors.Is(err, services.ErrInvalidToken) {
+			return writeError(c, 401, "error.invalid_token")
+		}
+		return err
+	}
 	return c.JSON(models.SuccessResponse{Success: true})
 }
 
@@ -79,6 +492,7 @@ func (h *Handlers) Logout(c *fiber.Ctx) error {
 		Name:     "session",
 		Value:    "",
 		Expires:  time.Now().Add(-time.Hour),
+		Path:     h.cookiePath(),
 		HTTPOnly: true,
 		Secure:   secure,
 		SameSite: "Lax",
@@ -87,68 +501,306 @@ func (h *Handlers) Logout(c *fiber.Ctx) error {
 	return c.JSON(models.SuccessResponse{Success: true})
 }
 
-// GetData returns aggregated usage data
+// GetData returns aggregated usage data; ?max_age=<seconds> refreshes usage
+// older than that instead of the configured cache TTLs (0 refreshes all)
 func (h *Handlers) GetData(c *fiber.Ctx) error {
-	data, err := h.apiKeyService.GetAggregatedData()
+	maxAge := time.Duration(-1)
+	if raw := c.Query("max_age"); raw != "" {
+		seconds, err := strconv.Atoi(raw)
+		if err != nil || seconds < 0 {
+			return writeFieldErrors(c, models.FieldError{
+				Field:   "max_age",
+				Rule:    "seconds",
+				Message: msg(c, "field.seconds"),
+			})
+		}
+		maxAge = time.Duration(seconds) * time.Second
+	}
+
+	fields, fieldErr := parseFields(c, reflect.TypeOf(models.Usage{}))
+	if fieldErr != nil {
+		return writeFieldErrors(c, *fieldErr)
+	}
+
+	// With ?limit= only one page of keys is built, for very large key sets
+	var data *models.AggregatedData
+	var err error
+	if c.Query("limit") != "" {
+		limit, limitErr := strconv.Atoi(c.Query("limit"))
+		if limitErr != nil || limit < 1 || limit > services.MaxPageSize {
+			return writeFieldErrors(c, models.FieldError{
+				Field:   "limit",
+				Rule:    "range",
+				Message: msg(c, "field.int_range", "1", strconv.Itoa(services.MaxPageSize)),
+			})
+		}
+		offset, offsetErr := strconv.Atoi(c.Query("offset", "0"))
+		if offsetErr != nil || offset < 0 {
+			return writeFieldErrors(c, models.FieldError{
+				Field:   "offset",
+				Rule:    "min",
+				Message: msg(c, "field.int_min", "0"),
+			})
+		}
+		data, err = h.apiKeyService.GetAggregatedPage(c.UserContext(), requestPrincipal(c), maxAge, offset, limit)
+	} else {
+		data, err = h.apiKeyService.GetAggregatedDataMaxAge(c.UserContext(), requestPrincipal(c), maxAge)
+	}
 	if err != nil {
-		return c.Status(500).JSON(models.ErrorResponse{Error: err.Error()})
+		return err
 	}
 
-	return c.JSON(data)
+	if fields == nil {
+		return c.JSON(data)
+	}
+	items := data.Data
+	data.Data = nil
+	return sendSparseEnvelope(c, data, "data", items, fields)
+}
+
+// GetStats returns aggregate statistics computed after the last refresh
+func (h *Handlers) GetStats(c *fiber.Ctx) error {
+	stats, err := h.apiKeyService.GetStats(c.UserContext(), requestPrincipal(c))
+	if err != nil {
+		return err
+	}
+	stats.Upstream = h.apiKeyService.UpstreamStats()
+
+	return c.JSON(stats)
+}
+
+// GetOrgs lists the detected organizations with the keys sharing their
+// allowance
+func (h *Handlers) GetOrgs(c *fiber.Ctx) error {
+	orgs, err := h.apiKeyService.Orgs(c.UserContext(), requestPrincipal(c))
+	if err != nil {
+		return err
+	}
+
+	return sendList(c, orgs)
+}
+
+// CompareStats compares usage in the current period with the previous one,
+// e.g. ?period=week
+func (h *Handlers) CompareStats(c *fiber.Ctx) error {
+	period := c.Query("period", "week")
+	if err := validate.Var(period, "oneof=day week month"); err != nil {
+		return writeFieldErrors(c, models.FieldError{
+			Field:   "period",
+			Rule:    "oneof",
+			Message: msg(c, "field.oneof", "day week month"),
+		})
+	}
+
+	comparison, err := h.apiKeyService.ComparePeriods(period, requestPrincipal(c))
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(comparison)
+}
+
+// ExportHistory downloads the usage history of the visible keys as NDJSON
+// or Parquet, for loading into analytics tools. The history is streamed as
+// it is read; an error part way through aborts the response, so the client
+// sees a failed download rather than a truncated file.
+func (h *Handlers) ExportHistory(c *fiber.Ctx) error {
+	format := c.Query("format", services.HistoryFormatNDJSON)
+	contentType := services.HistoryContentType(format)
+	if contentType == "" {
+		return writeFieldErrors(c, models.FieldError{
+			Field:   "format",
+			Rule:    "oneof",
+			Message: msg(c, "field.oneof", "ndjson parquet"),
+		})
+	}
+	rng, err := utils.ParseDuration(c.Query("range", "30d"))
+	if err != nil || rng < time.Minute {
+		return writeFieldErrors(c, models.FieldError{
+			Field:   "range",
+			Rule:    "duration",
+			Message: msg(c, "field.duration_min", "1m"),
+		})
+	}
+
+	// The stream is written after the handler returns, when the request
+	// context is done; it ends when the export does or the client goes
+	to := time.Now()
+	p := requestPrincipal(c)
+	reader, writer := io.Pipe()
+	go func() {
+		buffered := bufio.NewWriter(writer)
+		err := h.apiKeyService.ExportHistory(context.Background(), buffered, format, to.Add(-rng), to, p)
+		if err == nil {
+			err = buffered.Flush()
+		}
+		writer.CloseWithError(err)
+	}()
+
+	c.Set(fiber.HeaderContentType, contentType)
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="usage-history-%s.%s"`, to.UTC().Format("20060102"), format))
+	return c.SendStream(reader)
+}
+
+// GetSlowKeys returns the keys whose usage fetches were slow or ran into
+// the task deadline, slowest first
+func (h *Handlers) GetSlowKeys(c *fiber.Ctx) error {
+	keys, err := h.apiKeyService.SlowKeys(c.UserContext(), c.QueryInt("limit", 50), requestPrincipal(c))
+	if err != nil {
+		return err
+	}
+	return sendList(c, keys)
 }
 
 // GetKeys returns all API keys (masked)
 func (h *Handlers) GetKeys(c *fiber.Ctx) error {
-	keys, err := h.apiKeyService.GetAllKeys()
+	keys, err := h.apiKeyService.GetAllKeys(requestPrincipal(c))
 	if err != nil {
-		return c.Status(500).JSON(models.ErrorResponse{Error: err.Error()})
+		return err
 	}
 
-	return c.JSON(keys)
+	return sendList(c, keys)
+}
+
+// SearchKeys returns the keys matching ?q= by name, notes, group, tags or
+// masked suffix (masked)
+func (h *Handlers) SearchKeys(c *fiber.Ctx) error {
+	query := strings.TrimSpace(c.Query("q"))
+	if query == "" {
+		return writeFieldErrors(c, models.FieldError{Field: "q", Rule: "required", Message: msg(c, "field.required")})
+	}
+
+	keys, err := h.apiKeyService.SearchKeys(c.UserContext(), query, c.QueryInt("limit", services.DefaultSearchLimit), requestPrincipal(c))
+	if err != nil {
+		return err
+	}
+
+	return sendList(c, keys)
 }
 
 // GetFullKey returns the full API key
 func (h *Handlers) GetFullKey(c *fiber.Ctx) error {
 	id := c.Params("id")
-	if id == "" {
-		return c.Status(400).JSON(models.ErrorResponse{Error: "Key ID required"})
+	if err := validateKeyID(id); err != nil {
+		return writeBindError(c, err)
+	}
+
+	if denied, resp := h.requireStepUp(c, services.AuditFullKeyRead, id); denied {
+		return resp
 	}
 
 	key, err := h.apiKeyService.GetFullKey(id)
 	if err != nil {
-		// Log the error for debugging
-		c.Context().Logger().Printf("Error getting full key for id %s: %v", id, err)
-		return c.Status(500).JSON(models.ErrorResponse{Error: err.Error()})
+		return err
 	}
-
 	if key == nil {
-		c.Context().Logger().Printf("Key not found for id: %s", id)
-		return c.Status(404).JSON(models.ErrorResponse{Error: "Key not found"})
+		return writeError(c, 404, "error.key_not_found")
 	}
 
-	// Log successful retrieval
-	c.Context().Logger().Printf("Successfully retrieved key for id: %s", id)
-	
+	h.recordAudit(c, services.AuditFullKeyRead, id, true, "")
+
 	return c.JSON(fiber.Map{
 		"id":  key.ID,
 		"key": key.Key,
 	})
 }
 
+// ExportFullKeys returns full keys filtered by status, group, tag and
+// remaining balance, as txt (one key per line), csv or json
+func (h *Handlers) ExportFullKeys(c *fiber.Ctx) error {
+	var req models.ExportKeysRequest
+	if len(c.Body()) > 0 {
+		if err := bindAndValidate(c, &req); err != nil {
+			return writeBindError(c, err)
+		}
+	}
+	if req.Format == "" {
+		req.Format = "txt"
+	}
+	if denied, resp := h.requireStepUp(c, services.AuditKeyExport, ""); denied {
+		return resp
+	}
+
+	keys, err := h.apiKeyService.ExportFullKeys(c.UserContext(), &req)
+	if err != nil {
+		return err
+	}
+	h.recordAudit(c, services.AuditKeyExport, "", true, fmt.Sprintf("%d keys as %s", len(keys), req.Format))
+
+	switch req.Format {
+	case "csv":
+		var buf bytes.Buffer
+		w := csv.NewWriter(&buf)
+		_ = w.Write([]string{"id", "name", "key", "group", "tags", "status", "remaining"})
+		for _, key := range keys {
+			_ = w.Write([]string{
+				key.ID, key.Name, key.Key, key.Group, strings.Join(key.Tags, ";"),
+				key.Status, strconv.FormatFloat(key.Remaining, 'f', -1, 64),
+			})
+		}
+		w.Flush()
+		c.Set(fiber.HeaderContentType, "text/csv; charset=utf-8")
+		c.Set(fiber.HeaderContentDisposition, `attachment; filename="keys.csv"`)
+		return c.Send(buf.Bytes())
+	case "json":
+		return c.JSON(keys)
+	default:
+		lines := make([]string, len(keys))
+		for i, key := range keys {
+			lines[i] = key.Key
+		}
+		c.Set(fiber.HeaderContentType, fiber.MIMETextPlainCharsetUTF8)
+		return c.SendString(strings.Join(lines, "\n"))
+	}
+}
+
+// requireStepUp responds with 403 unless the caller holds a valid step-up
+// token issued to it; denials are audited. The role is checked by the route
+// policy.
+func (h *Handlers) requireStepUp(c *fiber.Ctx, action, keyID string) (bool, error) {
+	if !h.authService.ValidateStepUp(requestPrincipal(c), c.Get(StepUpHeader)) {
+		h.recordAudit(c, action, keyID, false, "step-up required")
+		return true, writeError(c, 403, "error.step_up_required")
+	}
+	return false, nil
+}
+
 // ImportKeys handles batch import
 func (h *Handlers) ImportKeys(c *fiber.Ctx) error {
 	var req models.ImportRequest
-	if err := c.BodyParser(&req); err != nil {
-		return c.Status(400).JSON(models.ErrorResponse{Error: "Invalid request"})
+	if err := bindAndValidate(c, &req); err != nil {
+		return writeBindError(c, err)
+	}
+	if len(req.Keys)+len(req.Items) == 0 {
+		return writeFieldErrors(c, models.FieldError{Field: "keys", Rule: "required", Message: msg(c, "field.required")})
+	}
+	if len(req.Keys)+len(req.Items) > h.config.MaxImportKeys {
+		return writeTooManyItems(c, "keys", h.config.MaxImportKeys)
 	}
 
-	if len(req.Keys) == 0 {
-		return c.Status(400).JSON(models.ErrorResponse{Error: "No keys provided"})
+	// Reject the whole import if any entry is malformed
+	var fields []models.FieldError
+	for i, key := range req.Keys {
+		key = strings.TrimSpace(key)
+		if key == "" {
+			continue
+		}
+		if err := h.apiKeyService.CheckKeyFormat("", key); err != nil {
+			fields = append(fields, keyFormatField(c, fmt.Sprintf("keys[%d]", i), err))
+		}
+	}
+	for i, item := range req.Items {
+		if err := h.apiKeyService.CheckKeyFormat("", strings.TrimSpace(item.Key)); err != nil {
+			fields = append(fields, keyFormatField(c, fmt.Sprintf("items[%d].key", i), err))
+		}
+	}
+	if len(fields) > 0 {
+		return writeFieldErrors(c, fields...)
 	}
 
-	result, err := h.apiKeyService.ImportKeys(req.Keys)
+	result, err := h.apiKeyService.ImportKeys(&req, requestPrincipal(c))
 	if err != nil {
-		return c.Status(500).JSON(models.ErrorResponse{Error: err.Error()})
+		return err
 	}
 
 	return c.JSON(result)
@@ -157,12 +809,12 @@ func (h *Handlers) ImportKeys(c *fiber.Ctx) error {
 // DeleteKey deletes a single API key
 func (h *Handlers) DeleteKey(c *fiber.Ctx) error {
 	id := c.Params("id")
-	if id == "" {
-		return c.Status(400).JSON(models.ErrorResponse{Error: "Key ID required"})
+	if err := validateKeyID(id); err != nil {
+		return writeBindError(c, err)
 	}
 
-	if err := h.apiKeyService.DeleteKey(id); err != nil {
-		return c.Status(500).JSON(models.ErrorResponse{Error: err.Error()})
+	if err := h.apiKeyService.DeleteKey(id, requestPrincipal(c)); err != nil {
+		return err
 	}
 
 	return c.JSON(models.SuccessResponse{Success: true})
@@ -171,17 +823,16 @@ func (h *Handlers) DeleteKey(c *fiber.Ctx) error {
 // BatchDeleteKeys handles batch deletion
 func (h *Handlers) BatchDeleteKeys(c *fiber.Ctx) error {
 	var req models.BatchDeleteRequest
-	if err := c.BodyParser(&req); err != nil {
-		return c.Status(400).JSON(models.ErrorResponse{Error: "Invalid request"})
+	if err := bindAndValidate(c, &req); err != nil {
+		return writeBindError(c, err)
 	}
-
-	if len(req.IDs) == 0 {
-		return c.Status(400).JSON(models.ErrorResponse{Error: "No IDs provided"})
+	if len(req.IDs) > h.config.MaxBatchDelete {
+		return writeTooManyItems(c, "ids", h.config.MaxBatchDelete)
 	}
 
-	result, err := h.apiKeyService.BatchDeleteKeys(req.IDs)
+	result, err := h.apiKeyService.BatchDeleteKeys(req.IDs, requestPrincipal(c))
 	if err != nil {
-		return c.Status(500).JSON(models.ErrorResponse{Error: err.Error()})
+		return err
 	}
 
 	return c.JSON(result)
@@ -189,35 +840,442 @@ func (h *Handlers) BatchDeleteKeys(c *fiber.Ctx) error {
 
 // AddKey adds a single API key
 func (h *Handlers) AddKey(c *fiber.Ctx) error {
-	var req struct {
-		Key  string `json:"key"`
-		Name string `json:"name"`
+	var req models.AddKeyRequest
+	if err := bindAndValidate(c, &req); err != nil {
+		return writeBindError(c, err)
+	}
+
+	if _, err := h.apiKeyService.AddKey(&req, requestPrincipal(c)); err != nil {
+		if errors.Is(err, services.ErrDuplicateKey) {
+			return writeError(c, 400, "error.key_exists")
+		}
+		if errors.Is(err, services.ErrReadOnly) {
+			return err
+		}
+		if errors.Is(err, services.ErrInvalidKeyFormat) {
+			return writeFieldErrors(c, keyFormatField(c, "key", err))
+		}
+		var rejected *services.KeyRejectedError
+		if errors.As(err, &rejected) {
+			return writeError(c, 400, "error.key_rejected", rejected.Reason)
+		}
+		if handled, resp := providerError(c, err); handled {
+			return resp
+		}
+		return writeError(c, 500, "error.key_add_failed")
+	}
+
+	return c.JSON(models.SuccessResponse{
+		Success: true,
+		Message: msg(c, "message.key_added"),
+	})
+}
+
+// GetKeyChart returns bucketed usage history for charting,
+// e.g. ?interval=1h&range=7d
+func (h *Handlers) GetKeyChart(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if err := validateKeyID(id); err != nil {
+		return writeBindError(c, err)
+	}
+
+	interval, err := utils.ParseDuration(c.Query("interval", "1h"))
+	if err != nil || interval < time.Minute {
+		return writeFieldErrors(c, models.FieldError{
+			Field:   "interval",
+			Rule:    "duration",
+			Message: msg(c, "field.duration_min", "1m"),
+		})
 	}
-	
-	if err := c.BodyParser(&req); err != nil {
-		return c.Status(400).JSON(models.ErrorResponse{Error: "Invalid request"})
+	rng, err := utils.ParseDuration(c.Query("range", "7d"))
+	if err != nil || rng < interval {
+		return writeFieldErrors(c, models.FieldError{
+			Field:   "range",
+			Rule:    "duration",
+			Message: msg(c, "field.duration_min", interval.String()),
+		})
+	}
+	if rng/interval > services.MaxChartPoints {
+		return writeFieldErrors(c, models.FieldError{
+			Field:   "range",
+			Rule:    "max",
+			Message: msg(c, "field.too_many_points", strconv.Itoa(services.MaxChartPoints)),
+		})
+	}
+
+	chart, err := h.apiKeyService.GetChart(id, interval, rng, requestPrincipal(c))
+	if err != nil {
+		if errors.Is(err, services.ErrKeyNotFound) {
+			return writeError(c, 404, "error.key_not_found")
+		}
+		return err
 	}
 
-	if req.Key == "" {
-		return c.Status(400).JSON(models.ErrorResponse{Error: "Key is required"})
+	return c.JSON(chart)
+}
+
+// GetRawResponse returns the last provider response kept for a key, to
+// diagnose usage that could not be read fully (UPSTREAM_RAW_MAX_BYTES)
+func (h *Handlers) GetRawResponse(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if err := validateKeyID(id); err != nil {
+		return writeBindError(c, err)
 	}
 
-	// Import as single key
-	result, err := h.apiKeyService.ImportKeys([]string{req.Key})
+	raw, err := h.apiKeyService.GetRawResponse(id, requestPrincipal(c))
 	if err != nil {
-		return c.Status(500).JSON(models.ErrorResponse{Error: err.Error()})
+		if errors.Is(err, services.ErrKeyNotFound) {
+			return writeError(c, 404, "error.key_not_found")
+		}
+		return err
+	}
+	if raw == nil {
+		return writeError(c, 404, "error.raw_response_not_found")
 	}
 
-	if result.Success > 0 {
-		return c.JSON(models.SuccessResponse{
-			Success: true,
-			Message: "Key added successfully",
-		})
+	return c.JSON(raw)
+}
+
+// DisableKey stops a key from being refreshed without deleting it
+func (h *Handlers) DisableKey(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if err := validateKeyID(id); err != nil {
+		return writeBindError(c, err)
+	}
+
+	var req models.DisableKeyRequest
+	if len(c.Body()) > 0 {
+		if err := bindAndValidate(c, &req); err != nil {
+			return writeBindError(c, err)
+		}
 	}
 
-	if result.Duplicates > 0 {
-		return c.Status(400).JSON(models.ErrorResponse{Error: "Key already exists"})
+	return h.setKeyEnabled(c, id, false, req.Reason)
+}
+
+// EnableKey resumes refreshing a disabled key
+func (h *Handlers) EnableKey(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if err := validateKeyID(id); err != nil {
+		return writeBindError(c, err)
+	}
+
+	return h.setKeyEnabled(c, id, true, "")
+}
+
+func (h *Handlers) setKeyEnabled(c *fiber.Ctx, id string, enabled bool, reason string) error {
+	key, err := h.apiKeyService.SetEnabled(id, enabled, reason, requestPrincipal(c))
+	if err != nil {
+		if errors.Is(err, services.ErrKeyNotFound) {
+			return writeError(c, 404, "error.key_not_found")
+		}
+		return err
+	}
+
+	return c.JSON(fiber.Map{
+		"id":              key.ID,
+		"enabled":         !key.Disabled,
+		"disabled_at":     key.DisabledAt,
+		"disabled_reason": key.DisabledReason,
+	})
+}
+
+// TestKey fetches usage for a raw key without storing it
+func (h *Handlers) TestKey(c *fiber.Ctx) error {
+	var req models.TestKeyRequest
+	if err := bindAndValidate(c, &req); err != nil {
+		return writeBindError(c, err)
+	}
+
+	usage, err := h.apiKeyService.TestKey(c.UserContext(), &req)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidKeyFormat) {
+			return writeFieldErrors(c, keyFormatField(c, "key", err))
+		}
+		if handled, resp := providerError(c, err); handled {
+			return resp
+		}
+		if errors.Is(err, services.ErrUpstreamUnavailable) || c.UserContext().Err() != nil {
+			return err
+		}
+		return writeError(c, fiber.StatusBadGateway, "error.upstream_failed", utils.Redact(err.Error()))
+	}
+
+	return c.JSON(fiber.Map{
+		"valid": usage.Error == "",
+		"usage": usage,
+	})
+}
+
+// UpdateKey updates the metadata (name, group, tags, notes, expiry,
+// refresh interval) of a key
+func (h *Handlers) UpdateKey(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if err := validateKeyID(id); err != nil {
+		return writeBindError(c, err)
+	}
+
+	var req models.UpdateKeyRequest
+	if err := bindAndValidate(c, &req); err != nil {
+		return writeBindError(c, err)
+	}
+
+	key, err := h.apiKeyService.UpdateKey(id, &req, requestPrincipal(c))
+	if err != nil {
+		if errors.Is(err, services.ErrKeyNotFound) {
+			return writeError(c, 404, "error.key_not_found")
+		}
+		if handled, resp := providerError(c, err); handled {
+			return resp
+		}
+		return err
+	}
+
+	return c.JSON(fiber.Map{
+		"id":               key.ID,
+		"name":             key.Name,
+		"provider":         key.Provider,
+		"group":            key.Group,
+		"tags":             key.Tags,
+		"owner":            key.Owner,
+		"notes":            key.Notes,
+		"expires_at":       key.ExpiresAt,
+		"refresh_interval": key.RefreshInterval,
+	})
+}
+
+// keyFormatField describes a key rejected by its provider's format rule
+func keyFormatField(c *fiber.Ctx, field string, err error) models.FieldError {
+	var formatErr *services.KeyFormatError
+	if !errors.As(err, &formatErr) {
+		return models.FieldError{Field: field, Rule: "format", Message: err.Error()}
+	}
+	return models.FieldError{
+		Field:   field,
+		Rule:    "format",
+		Message: msg(c, "field.key_"+formatErr.Rule, formatErr.Provider, formatErr.Param),
+	}
+}
+
+// providerError responds with 400 for unknown providers and invalid credentials
+func providerError(c *fiber.Ctx, err error) (bool, error) {
+	switch {
+	case errors.Is(err, services.ErrUnknownProvider):
+		return true, writeError(c, 400, "error.unknown_provider")
+	case errors.Is(err, services.ErrInvalidCredential):
+		return true, writeError(c, 400, "error.invalid_credential",
+			strings.TrimPrefix(err.Error(), services.ErrInvalidCredential.Error()+": "))
+	default:
+		return false, nil
+	}
+}
+
+// Prune runs the data retention policies immediately
+func (h *Handlers) Prune(c *fiber.Ctx) error {
+	return c.JSON(h.retentionService.Prune())
+}
+
+// GetCacheStats reports the usage cache hits and misses of this replica
+func (h *Handlers) GetCacheStats(c *fiber.Ctx) error {
+	return c.JSON(h.apiKeyService.CacheStats())
+}
+
+// FlushCache drops all cached usage so it is fetched again
+func (h *Handlers) FlushCache(c *fiber.Ctx) error {
+	result, err := h.apiKeyService.FlushCache(c.UserContext())
+	if err != nil {
+		return err
+	}
+
+	h.recordAudit(c, services.AuditCacheFlush, "", true, "")
+	return c.JSON(result)
+}
+
+// GetAlerts lists recent alerts, optionally filtered by ?state=; users
+// limited to their own keys only see the alerts of those
+func (h *Handlers) GetAlerts(c *fiber.Ctx) error {
+	alerts, err := h.alertService.ListAlerts(c.Query("state"), c.QueryInt("limit", 100))
+	if err != nil {
+		return err
+	}
+
+	ids := make([]string, len(alerts))
+	for i, alert := range alerts {
+		ids[i] = alert.KeyID
+	}
+	hidden, err := h.apiKeyService.HiddenKeyIDs(ids, requestPrincipal(c))
+	if err != nil {
+		return err
+	}
+	if len(hidden) > 0 {
+		visible := make([]*storage.Alert, 0, len(alerts))
+		for _, alert := range alerts {
+			if !hidden[alert.KeyID] {
+				visible = append(visible, alert)
+			}
+		}
+		alerts = visible
+	}
+
+	return sendList(c, alerts)
+}
+
+// AckAlert acknowledges an alert so it is tracked as being handled, in the
+// name of the caller
+func (h *Handlers) AckAlert(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if err := validate.Var(id, "required,uuid"); err != nil {
+		return writeBindError(c, err)
+	}
+
+	// Alerts of keys the caller may not see don't exist for them
+	alert, err := h.alertService.GetAlert(id)
+	if errors.Is(err, services.ErrAlertNotFound) {
+		return writeError(c, 404, "error.alert_not_found")
+	}
+	if err != nil {
+		return err
+	}
+	hidden, err := h.apiKeyService.HiddenKeyIDs([]string{alert.KeyID}, requestPrincipal(c))
+	if err != nil {
+		return err
+	}
+	if hidden[alert.KeyID] {
+		return writeError(c, 404, "error.alert_not_found")
+	}
+
+	alert, err = h.alertService.Acknowledge(id, requestActor(c))
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrAlertNotFound):
+			return writeError(c, 404, "error.alert_not_found")
+		case errors.Is(err, services.ErrAlertResolved):
+			return writeError(c, 409, "error.alert_resolved")
+		}
+		return err
+	}
+
+	return c.JSON(alert)
+}
+
+// GetReports lists the stored usage reports, newest first
+func (h *Handlers) GetReports(c *fiber.Ctx) error {
+	if h.apiKeyService.LimitedToOwnKeys(requestPrincipal(c)) {
+		return writeError(c, 403, "error.forbidden")
+	}
+
+	reports, err := h.reports.ListReports(c.QueryInt("limit", 100))
+	if err != nil {
+		return err
+	}
+
+	return sendList(c, reports)
+}
+
+// GetJobHistory lists the summaries of past refresh batches, newest first,
+// optionally only those of one ?trigger=
+func (h *Handlers) GetJobHistory(c *fiber.Ctx) error {
+	if h.apiKeyService.LimitedToOwnKeys(requestPrincipal(c)) {
+		return writeError(c, 403, "error.forbidden")
+	}
+
+	jobs, err := h.apiKeyService.ListJobs(c.UserContext(), c.Query("trigger"), c.QueryInt("limit", 100))
+	if err != nil {
+		return err
+	}
+
+	return sendList(c, jobs)
+}
+
+// GetRefreshProgress returns the progress of the refresh batch running or
+// last finished
+func (h *Handlers) GetRefreshProgress(c *fiber.Ctx) error {
+	if h.apiKeyService.LimitedToOwnKeys(requestPrincipal(c)) {
+		return writeError(c, 403, "error.forbidden")
+	}
+
+	progress, err := h.apiKeyService.RefreshProgress(c.UserContext())
+	if err != nil {
+		return err
+	}
+	if progress == nil {
+		return writeError(c, 404, "error.refresh_not_found")
+	}
+
+	return c.JSON(progress)
+}
+
+// GetDeadLetters lists the keys whose fetches kept failing after every
+// retry (admin only)
+func (h *Handlers) GetDeadLetters(c *fiber.Ctx) error {
+	letters, err := h.retries.DeadLetters(c.UserContext())
+	if err != nil {
+		return err
+	}
+
+	return sendList(c, letters)
+}
+
+// RequeueDeadLetter moves a dead-lettered key back onto the retry queue
+// (admin only)
+func (h *Handlers) RequeueDeadLetter(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if err := validateKeyID(id); err != nil {
+		return writeBindError(c, err)
+	}
+
+	if err := h.retries.Requeue(c.UserContext(), id); err != nil {
+		return err
+	}
+
+	return c.JSON(models.SuccessResponse{Success: true})
+}
+
+// GenerateReport renders a usage report immediately (admin only)
+func (h *Handlers) GenerateReport(c *fiber.Ctx) error {
+	report, err := h.reports.Generate(c.UserContext())
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(report)
+}
+
+// GetReport downloads a stored report in one of its formats
+func (h *Handlers) GetReport(c *fiber.Ctx) error {
+	if h.apiKeyService.LimitedToOwnKeys(requestPrincipal(c)) {
+		return writeError(c, 403, "error.forbidden")
+	}
+
+	id, format := c.Params("id"), c.Params("format")
+	artifact, contentType, err := h.reports.GetArtifact(id, format)
+	if err != nil {
+		if errors.Is(err, services.ErrReportNotFound) {
+			return writeError(c, 404, "error.report_not_found")
+		}
+		return err
+	}
+
+	c.Set(fiber.HeaderContentType, contentType)
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="%s.%s"`, id, format))
+	return c.Send(artifact)
+}
+
+// TestNotification sends a test delivery to every notification channel
+func (h *Handlers) TestNotification(c *fiber.Ctx) error {
+	if !h.notifier.Enabled() {
+		return writeError(c, 400, "error.no_channels")
+	}
+
+	return c.JSON(h.notifier.TestDelivery())
+}
+
+// GetDeliveries lists recent notification delivery attempts, newest first
+func (h *Handlers) GetDeliveries(c *fiber.Ctx) error {
+	deliveries, err := h.notifier.Deliveries(c.QueryInt("limit", 100))
+	if err != nil {
+		return err
 	}
 
-	return c.Status(500).JSON(models.ErrorResponse{Error: "Failed to add key"})
+	return sendList(c, deliveries)
 }
@@ -0,0 +1,57 @@
+package api
+
+import (
+	"io"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/droid-keyusage-go/internal/services"
+	"github.com/droid-keyusage-go/internal/storage"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestIdempotencyPerCaller(t *testing.T) {
+	client, err := storage.NewMemoryClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	idempotency := services.NewIdempotencyService(storage.NewStorage(client), time.Hour)
+
+	created := 0
+	app := fiber.New()
+	app.Post("/api/keys", func(c *fiber.Ctx) error {
+		c.Locals(localsRole, services.RoleEditor)
+		c.Locals(localsUser, c.Get("X-User"))
+		return c.Next()
+	}, IdempotencyMiddleware(idempotency), func(c *fiber.Ctx) error {
+		created++
+		return c.Status(fiber.StatusCreated).SendString("key-" + strconv.Itoa(created))
+	})
+
+	post := func(user string) (string, bool) {
+		t.Helper()
+		req := httptest.NewRequest("POST", "/api/keys", strings.NewReader(`{"key":"fk-same"}`))
+		req.Header.Set("X-User", user)
+		req.Header.Set("Idempotency-Key", "retry-1")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		return string(body), resp.Header.Get("Idempotent-Replayed") == "true"
+	}
+
+	if body, replayed := post("alice"); body != "key-1" || replayed {
+		t.Fatalf("first request: %q, replayed %v", body, replayed)
+	}
+	if body, replayed := post("bob"); body != "key-2" || replayed {
+		t.Fatalf("another user with the same key: %q, replayed %v", body, replayed)
+	}
+	if body, replayed := post("alice"); body != "key-1" || !replayed {
+		t.Fatalf("retry: %q, replayed %v", body, replayed)
+	}
+}
@@ -0,0 +1,45 @@
+package api
+
+import (
+	"strings"
+
+	"github.com/droid-keyusage-go/internal/i18n"
+	"github.com/droid-keyusage-go/internal/models"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+)
+
+// requestLang resolves the response language from the Accept-Language header
+func requestLang(c *fiber.Ctx) i18n.Lang {
+	return i18n.FromAcceptLanguage(c.Get(fiber.HeaderAcceptLanguage))
+}
+
+// msg translates a message ID into the language requested by the client
+func msg(c *fiber.Ctx, key string, args ...interface{}) string {
+	return i18n.T(requestLang(c), key, args...)
+}
+
+// errorCode derives the machine-readable code of an error message ID, e.g.
+// "error.key_not_found" becomes KEY_NOT_FOUND
+func errorCode(key string) string {
+	return strings.ToUpper(strings.TrimPrefix(key, "error."))
+}
+
+// statusCode derives an error code from an HTTP status, e.g. 404 becomes NOT_FOUND
+func statusCode(status int) string {
+	return strings.ToUpper(strings.ReplaceAll(utils.StatusMessage(status), " ", "_"))
+}
+
+// errorResponse builds a localized error body carrying its code and the request ID
+func errorResponse(c *fiber.Ctx, key string, args ...interface{}) models.ErrorResponse {
+	return models.ErrorResponse{
+		Error:     msg(c, key, args...),
+		Code:      errorCode(key),
+		RequestID: requestID(c),
+	}
+}
+
+// writeError responds with the given status and a localized error body
+func writeError(c *fiber.Ctx, status int, key string, args ...interface{}) error {
+	return c.Status(status).JSON(errorResponse(c, key, args...))
+}
@@ -1,28 +1,118 @@
 package api
 
 import (
-	"github.com/droid-keyusage-go/internal/models"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
 	"github.com/droid-keyusage-go/internal/services"
+	"github.com/droid-keyusage-go/internal/utils"
+	"github.com/go-playground/validator/v10"
 	"github.com/gofiber/fiber/v2"
 )
 
+// StepUpHeader carries the token returned by POST /api/auth/step-up
+const StepUpHeader = "X-Step-Up-Token"
+
+// localsRole holds the caller's role, set by AuthMiddleware
+const localsRole = "role"
+
+// localsUser holds the caller's user name, set by AuthMiddleware for named users
+const localsUser = "user"
+
+// localsScopes holds the scopes of a scoped bearer token, set by AuthMiddleware
+const localsScopes = "scopes"
+
+// localsAPIClient holds whom the daily quotas count the request against,
+// set by AuthMiddleware for bearer tokens, Basic auth and client
+// certificates: the user name, or the role for shared passwords
+const localsAPIClient = "apiclient"
+
+// localsSession holds the caller's session, set by AuthMiddleware for
+// callers signed in with the session cookie
+const localsSession = "session"
+
+// localsRequestID holds the request ID, set by the requestid middleware
+const localsRequestID = "requestid"
+
+// requestRole returns the role of the authenticated caller
+func requestRole(c *fiber.Ctx) string {
+	if role, ok := c.Locals(localsRole).(string); ok && role != "" {
+		return role
+	}
+	return services.RoleAdmin
+}
+
+// requestPrincipal returns who the authenticated caller is
+func requestPrincipal(c *fiber.Ctx) services.Principal {
+	user, _ := c.Locals(localsUser).(string)
+	scopes, _ := c.Locals(localsScopes).([]string)
+	return services.Principal{User: user, Role: requestRole(c), Scopes: scopes}
+}
+
+// requestActor names the caller in the audit log: the user name of named
+// users and the role otherwise
+func requestActor(c *fiber.Ctx) string {
+	if p := requestPrincipal(c); p.User != "" {
+		return p.User
+	}
+	return requestRole(c)
+}
+
+// RequestContextMiddleware gives every request a context that handlers pass
+// to services via c.UserContext(). It is canceled when the request ends or
+// the server shuts down, and after timeout when timeout > 0, so Redis and
+// upstream calls made for the request do not outlive it.
+func RequestContextMiddleware(timeout time.Duration) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var ctx context.Context = c.Context()
+		var cancel context.CancelFunc
+		if timeout > 0 {
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+		} else {
+			ctx, cancel = context.WithCancel(ctx)
+		}
+		defer cancel()
+
+		c.SetUserContext(ctx)
+		return c.Next()
+	}
+}
+
 // AuthMiddleware checks if the user is authenticated
-func AuthMiddleware(authService *services.AuthService) fiber.Handler {
+func AuthMiddleware(authService *services.AuthService, basePath string) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		// Skip auth for health check and static files
-		path := c.Path()
-		if path == "/health" || path == "/api/login" {
+		path := strings.TrimPrefix(c.Path(), basePath)
+		if path == "/health" || path == "/api/login" || strings.HasPrefix(path, "/api/login/") || strings.HasPrefix(path, "/api/token") {
+			return c.Next()
+		}
+
+		// A fresh install only answers the setup endpoints until an admin
+		// password has been set
+		if authService.SetupRequired() {
+			if isAPIPath(path) {
+				return writeError(c, 403, "error.setup_required")
+			}
 			return c.Next()
 		}
 
 		// Check if auth is required
 		if !authService.IsAuthRequired() {
+			c.Locals(localsRole, services.RoleAdmin)
 			return c.Next()
 		}
 
 		// Check session cookie
-		sessionID := c.Cookies("session")
-		if sessionID != "" && authService.ValidateSession(sessionID) {
+		if session := authService.GetSession(c.Cookies("session")); session != nil {
+			c.Locals(localsRole, session.Role)
+			c.Locals(localsUser, session.User)
+			c.Locals(localsSession, session)
 			return c.Next()
 		}
 
@@ -32,39 +122,205 @@ func AuthMiddleware(authService *services.AuthService) fiber.Handler {
 			// Extract token from "Bearer <token>" format
 			if len(authHeader) > 7 && authHeader[:7] == "Bearer " {
 				token := authHeader[7:]
-				if authService.ValidateJWT(token) {
+				p, err := authService.ValidateAccessToken(token)
+				if err == nil {
+					c.Locals(localsRole, p.Role)
+					c.Locals(localsUser, p.User)
+					c.Locals(localsScopes, p.Scopes)
+					c.Locals(localsAPIClient, apiClient(p))
+					return c.Next()
+				}
+				// Tokens that may be revoked are refused while the
+				// denylist can't be checked
+				if errors.Is(err, services.ErrDenylistUnavailable) {
+					return writeError(c, fiber.StatusServiceUnavailable, "error.auth_unavailable")
+				}
+			}
+
+			// Basic auth lets tools that can't refresh tokens, such as
+			// Grafana datasources, read data with a password
+			if username, password, ok := parseBasicAuth(authHeader); ok {
+				if p, ok := authService.Authenticate(username, password); ok {
+					c.Locals(localsRole, p.Role)
+					c.Locals(localsUser, p.User)
+					c.Locals(localsScopes, []string{services.ScopeRead})
+					c.Locals(localsAPIClient, apiClient(p))
 					return c.Next()
 				}
 			}
 		}
 
+		// Services may authenticate with a client certificate instead of a
+		// bearer token when the server asks for one (TLS_CLIENT_CA_FILE)
+		if p, ok := authService.ClientCertPrincipal(c.Context().TLSConnectionState()); ok {
+			c.Locals(localsRole, p.Role)
+			c.Locals(localsUser, p.User)
+			c.Locals(localsScopes, p.Scopes)
+			c.Locals(localsAPIClient, apiClient(p))
+			return c.Next()
+		}
+
 		// Return 401 for API requests
-		if len(path) > 4 && path[:4] == "/api" {
-			return c.Status(401).JSON(models.ErrorResponse{Error: "Unauthorized"})
+		if isAPIPath(path) {
+			return writeError(c, 401, "error.unauthorized")
 		}
 
 		// Redirect to login page for web requests
-		return c.Redirect("/login")
+		return c.Redirect(basePath + "/login.html")
 	}
 }
 
-// ErrorHandler handles global errors
-func ErrorHandler(c *fiber.Ctx, err error) error {
-	code := fiber.StatusInternalServerError
-	message := "Internal Server Error"
+// apiClient names an API client for its quota
+func apiClient(p services.Principal) string {
+	if p.User != "" {
+		return p.User
+	}
+	return p.Role
+}
 
-	if e, ok := err.(*fiber.Error); ok {
-		code = e.Code
-		message = e.Message
+// parseBasicAuth extracts the credentials of a "Basic" Authorization header
+func parseBasicAuth(header string) (username, password string, ok bool) {
+	const prefix = "Basic "
+	if len(header) < len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return "", "", false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(header[len(prefix):])
+	if err != nil {
+		return "", "", false
 	}
+	return strings.Cut(string(decoded), ":")
+}
 
-	// API error response
-	if c.Path()[:4] == "/api" {
-		return c.Status(code).JSON(models.ErrorResponse{
-			Error: message,
-		})
+// isAPIPath reports whether a path, relative to the base path, is an API route
+func isAPIPath(path string) bool {
+	return path == "/api" || strings.HasPrefix(path, "/api/")
+}
+
+// requestID returns the ID assigned to the request by the requestid middleware
+func requestID(c *fiber.Ctx) string {
+	id, _ := c.Locals(localsRequestID).(string)
+	return id
+}
+
+// ErrorHandler handles errors returned by handlers: known service errors get
+// their proper status, and internal details are only shown to signed-in callers
+func ErrorHandler(basePath string) fiber.ErrorHandler {
+	return func(c *fiber.Ctx, err error) error {
+		status := fiber.StatusInternalServerError
+		resp := errorResponse(c, "error.internal")
+		authenticated := c.Locals(localsRole) != nil
+
+		var fiberErr *fiber.Error
+		var upstreamErr *services.UpstreamError
+		var validationErrs validator.ValidationErrors
+		switch {
+		case errors.As(err, &fiberErr):
+			status = fiberErr.Code
+			resp.Error = utils.Redact(fiberErr.Message)
+			resp.Code = statusCode(status)
+		case errors.Is(err, services.ErrKeyNotFound):
+			status = fiber.StatusNotFound
+			resp = errorResponse(c, "error.key_not_found")
+		case errors.Is(err, services.ErrDenylistUnavailable):
+			status = fiber.StatusServiceUnavailable
+			resp = errorResponse(c, "error.auth_unavailable")
+		case errors.Is(err, services.ErrReadOnly):
+			status = fiber.StatusForbidden
+			resp = errorResponse(c, "error.forbidden")
+		case errors.Is(err, services.ErrAlertNotFound):
+			status = fiber.StatusNotFound
+			resp = errorResponse(c, "error.alert_not_found")
+		case errors.Is(err, services.ErrDeadLetterNotFound):
+			status = fiber.StatusNotFound
+			resp = errorResponse(c, "error.dead_letter_not_found")
+		case errors.Is(err, services.ErrSetupComplete):
+			status = fiber.StatusConflict
+			resp = errorResponse(c, "error.setup_complete")
+		case errors.As(err, &validationErrs),
+			errors.Is(err, services.ErrInvalidKeyFormat),
+			errors.Is(err, services.ErrInvalidSettings),
+			errors.Is(err, services.ErrInvalidCredential),
+			errors.Is(err, services.ErrUnknownProvider):
+			status = fiber.StatusUnprocessableEntity
+			resp = errorResponse(c, "error.validation_failed")
+		case errors.Is(err, context.DeadlineExceeded),
+			errors.As(err, &upstreamErr) && upstreamErr.Timeout:
+			status = fiber.StatusGatewayTimeout
+			resp = errorResponse(c, "error.upstream_timeout")
+		case errors.Is(err, services.ErrUpstreamUnavailable):
+			status = fiber.StatusBadGateway
+			resp = errorResponse(c, "error.upstream_unavailable")
+		}
+
+		// Only signed-in callers see why the upstream request failed
+		if authenticated && errors.Is(err, services.ErrUpstreamUnavailable) {
+			resp.Details = map[string]interface{}{"reason": utils.Redact(err.Error())}
+		}
+
+		if status >= fiber.StatusInternalServerError {
+			fmt.Printf("⚠️  请求处理失败 [%s] %s %s: %s\n", resp.RequestID, c.Method(), c.Path(), utils.Redact(err.Error()))
+		}
+
+		// API error response
+		if isAPIPath(strings.TrimPrefix(c.Path(), basePath)) {
+			return c.Status(status).JSON(resp)
+		}
+
+		// HTML error response
+		return c.Status(status).SendString(resp.Error)
 	}
+}
+
+// IdempotencyMiddleware replays the stored response when a mutating request
+// is retried by the same caller with the same Idempotency-Key header
+func IdempotencyMiddleware(idempotencyService *services.IdempotencyService) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		key := strings.TrimSpace(c.Get("Idempotency-Key"))
+		if key == "" {
+			return c.Next()
+		}
+		if len(key) > 255 {
+			return writeError(c, 400, "error.idempotency_key_too_long")
+		}
+
+		// Each caller has their own keys, so nobody is replayed the
+		// response to someone else's request
+		key = requestActor(c) + ":" + key
+		sum := sha256.Sum256(c.Body())
+		fingerprint := c.Method() + " " + c.Path() + " " + hex.EncodeToString(sum[:])
 
-	// HTML error response
-	return c.Status(code).SendString(message)
+		stored, err := idempotencyService.Begin(key, fingerprint)
+		switch {
+		case errors.Is(err, services.ErrIdempotencyInProgress):
+			return writeError(c, 409, "error.idempotency_in_progress")
+		case errors.Is(err, services.ErrIdempotencyMismatch):
+			return writeError(c, 422, "error.idempotency_mismatch")
+		case err != nil:
+			return writeError(c, 500, "error.idempotency_check_failed")
+		case stored != nil:
+			c.Set("Idempotent-Replayed", "true")
+			c.Set(fiber.HeaderContentType, stored.ContentType)
+			return c.Status(stored.Status).Send(stored.Body)
+		}
+
+		if err := c.Next(); err != nil {
+			_ = idempotencyService.Release(key)
+			return err
+		}
+
+		// Server errors are not remembered so the client can retry them
+		status := c.Response().StatusCode()
+		if status >= 500 {
+			_ = idempotencyService.Release(key)
+			return nil
+		}
+
+		_ = idempotencyService.Complete(key, &services.IdempotentResponse{
+			Fingerprint: fingerprint,
+			Status:      status,
+			ContentType: string(c.Response().Header.ContentType()),
+			Body:        append([]byte(nil), c.Response().Body()...),
+		})
+		return nil
+	}
 }
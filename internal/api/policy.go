@@ -0,0 +1,95 @@
+package api
+
+import (
+	"github.com/droid-keyusage-go/internal/services"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Policy is what a route requires of the caller; routes declare theirs in
+// SetupRoutes instead of checking roles in handlers
+type Policy struct {
+	// Role is the lowest role allowed; empty allows every signed-in role
+	Role string
+	// Scope must be granted to scoped bearer tokens
+	Scope string
+	// Audit is the audit action recorded when the policy denies a request;
+	// empty records nothing
+	Audit string
+}
+
+// Route policies
+var (
+	// PolicyRead covers dashboards and listings
+	PolicyRead = Policy{Scope: services.ScopeRead}
+	// PolicyWrite covers changes to keys and alerts, within what the
+	// caller may see; viewers are read-only
+	PolicyWrite = Policy{Role: services.RoleEditor, Scope: services.ScopeWrite}
+	// PolicyAdmin covers settings, audit, reports and maintenance
+	PolicyAdmin = Policy{Role: services.RoleAdmin, Scope: services.ScopeAdmin}
+	// PolicyFullKeyRead covers reading a full key; handlers additionally
+	// require a step-up token
+	PolicyFullKeyRead = Policy{Role: services.RoleAdmin, Scope: services.ScopeFullKeys, Audit: services.AuditFullKeyRead}
+	// PolicyKeyExport covers exporting full keys
+	PolicyKeyExport = Policy{Role: services.RoleAdmin, Scope: services.ScopeFullKeys, Audit: services.AuditKeyExport}
+	// PolicyStepUp covers requesting a step-up token
+	PolicyStepUp = Policy{Role: services.RoleAdmin, Scope: services.ScopeFullKeys, Audit: services.AuditStepUp}
+)
+
+// roleRank orders roles from least to most privileged
+var roleRank = map[string]int{
+	services.RoleViewer: 1,
+	services.RoleEditor: 2,
+	services.RoleAdmin:  3,
+}
+
+// Allows reports whether the policy lets the principal through
+func (p Policy) Allows(principal services.Principal) bool {
+	return p.denial(principal) == ""
+}
+
+// denial explains why the policy rejects the principal, or is empty
+func (p Policy) denial(principal services.Principal) string {
+	if p.Role != "" && roleRank[principal.Role] < roleRank[p.Role] {
+		return "forbidden role"
+	}
+	if p.Scope != "" && !principal.HasScope(p.Scope) {
+		return "missing scope " + p.Scope
+	}
+	return ""
+}
+
+// Require enforces a policy; it runs after AuthMiddleware
+func (h *Handlers) Require(p Policy) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		reason := p.denial(requestPrincipal(c))
+		if reason != "" {
+			h.recordDenial(c, p, reason)
+			return writeError(c, fiber.StatusForbidden, "error.forbidden")
+		}
+
+		// ADMIN_ALLOWED_COUNTRIES and ADMIN_BLOCKED_COUNTRIES restrict
+		// where the admin surface may be used from
+		if p.Role == services.RoleAdmin {
+			if country, ok := h.adminCountries.Allows(c.IP()); !ok {
+				if country == "" {
+					country = "unknown"
+				}
+				h.recordDenial(c, p, "country "+country)
+				return writeError(c, fiber.StatusForbidden, "error.country_forbidden")
+			}
+		}
+		return c.Next()
+	}
+}
+
+// recordDenial audits a request the policy denied, if it asks for that
+func (h *Handlers) recordDenial(c *fiber.Ctx, p Policy, reason string) {
+	if p.Audit == "" {
+		return
+	}
+	id := c.Params("id")
+	if validateKeyID(id) != nil {
+		id = ""
+	}
+	h.recordAudit(c, p.Audit, id, false, reason)
+}
@@ -0,0 +1,53 @@
+package api
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/droid-keyusage-go/internal/services"
+	"github.com/gofiber/fiber/v2"
+)
+
+// policyApp serves POST /api/keys behind PolicyWrite for a caller signed in
+// with role
+func policyApp(role string) *fiber.App {
+	h := &Handlers{}
+	app := fiber.New()
+	app.Post("/api/keys", func(c *fiber.Ctx) error {
+		c.Locals(localsRole, role)
+		return c.Next()
+	}, h.Require(PolicyWrite), func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusCreated)
+	})
+	return app
+}
+
+func TestPolicyWriteRoles(t *testing.T) {
+	tests := []struct {
+		role   string
+		status int
+	}{
+		{services.RoleViewer, fiber.StatusForbidden},
+		{services.RoleEditor, fiber.StatusCreated},
+		{services.RoleAdmin, fiber.StatusCreated},
+	}
+	for _, tt := range tests {
+		resp, err := policyApp(tt.role).Test(httptest.NewRequest("POST", "/api/keys", nil))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.role, err)
+		}
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s: POST /api/keys returned %d, want %d", tt.role, resp.StatusCode, tt.status)
+		}
+	}
+}
+
+func TestPolicyWriteScope(t *testing.T) {
+	p := services.Principal{Role: services.RoleAdmin, Scopes: []string{services.ScopeRead}}
+	if PolicyWrite.Allows(p) {
+		t.Error("PolicyWrite allows a token scoped to read")
+	}
+	if !PolicyRead.Allows(services.Principal{Role: services.RoleViewer}) {
+		t.Error("PolicyRead denies a viewer")
+	}
+}
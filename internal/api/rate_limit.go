@@ -0,0 +1,91 @@
+package api
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/droid-keyusage-go/internal/storage"
+	"github.com/gofiber/fiber/v2"
+)
+
+// GlobalRateLimit limits the API requests of each client address to
+// RATE_LIMIT a second; it runs before authentication, so it also covers
+// the login and token endpoints
+func (h *Handlers) GlobalRateLimit(c *fiber.Ctx) error {
+	if h.limiter == nil || !isAPIPath(strings.TrimPrefix(c.Path(), h.config.BasePath)) {
+		return c.Next()
+	}
+	// While Redis is unavailable requests are let through
+	result, limit, err := h.limiter.Global(c.IP())
+	if err != nil || result == nil {
+		return c.Next()
+	}
+	setRateLimitHeaders(c, result, limit)
+	if !result.Allowed {
+		return writeRateLimited(c, result.RetryAfter)
+	}
+	return c.Next()
+}
+
+// APIRateLimit applies the stricter limits of expensive endpoints and the
+// daily quotas of API tokens to authenticated requests
+func (h *Handlers) APIRateLimit(c *fiber.Ctx) error {
+	if h.limiter == nil {
+		return c.Next()
+	}
+	apiClient, _ := c.Locals(localsAPIClient).(string)
+
+	// Endpoint limits count per API client, named user or else address
+	client := apiClient
+	if client == "" {
+		client = requestPrincipal(c).User
+	}
+	if client == "" {
+		client = c.IP()
+	}
+	path := strings.TrimPrefix(c.Path(), h.config.BasePath)
+	result, limit, err := h.limiter.Endpoint(c.Method(), path, func(name string) string { return c.Query(name) }, client)
+	if err == nil && result != nil {
+		setRateLimitHeaders(c, result, limit)
+		if !result.Allowed {
+			return writeRateLimited(c, result.RetryAfter)
+		}
+	}
+
+	// Quotas apply to API clients, not to people signed in to the dashboard
+	if apiClient == "" {
+		return c.Next()
+	}
+	quota, err := h.limiter.Quota(apiClient)
+	if err != nil || quota == nil {
+		return c.Next()
+	}
+	c.Set("X-Quota-Limit", strconv.Itoa(quota.Limit))
+	c.Set("X-Quota-Remaining", strconv.Itoa(quota.Remaining))
+	c.Set("X-Quota-Reset", strconv.Itoa(seconds(quota.Reset)))
+	if !quota.Allowed {
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(seconds(quota.Reset)))
+		return writeError(c, fiber.StatusTooManyRequests, "error.quota_exceeded", quota.Limit)
+	}
+	return c.Next()
+}
+
+// setRateLimitHeaders describes a limit in the X-RateLimit-* headers; a
+// stricter endpoint limit replaces the global one
+func setRateLimitHeaders(c *fiber.Ctx, result *storage.RateLimitResult, limit int) {
+	c.Set("X-RateLimit-Limit", strconv.Itoa(limit))
+	c.Set("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	c.Set("X-RateLimit-Reset", strconv.Itoa(seconds(result.Reset)))
+}
+
+// writeRateLimited responds with 429 and when to try again
+func writeRateLimited(c *fiber.Ctx, retryAfter time.Duration) error {
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(seconds(retryAfter)))
+	return writeError(c, fiber.StatusTooManyRequests, "error.rate_limited", seconds(retryAfter))
+}
+
+// seconds rounds a duration up to whole seconds
+func seconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
+}
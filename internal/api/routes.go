@@ -1,35 +1,128 @@
 package api
 
 import (
+	"net/http"
+
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/filesystem"
 )
 
 // SetupRoutes configures all routes
 func SetupRoutes(app *fiber.App, handlers *Handlers) {
-	// Health check
+	basePath := handlers.config.BasePath
+
+	app.Use(RequestContextMiddleware(handlers.config.RequestTimeout))
+
+	// Health and readiness checks (also kept at the root for container probes)
 	app.Get("/health", handlers.Health)
+	app.Get("/ready", handlers.Ready)
+
+	// All other routes live under the configured base path
+	root := app.Group(basePath)
+	if basePath != "" {
+		root.Get("/health", handlers.Health)
+		root.Get("/ready", handlers.Ready)
+	}
+
+	// Requests per client address are limited before authentication
+	root.Use(handlers.GlobalRateLimit)
 
 	// Authentication routes (no auth middleware)
-	app.Post("/api/login", handlers.Login)
-	app.Post("/api/logout", handlers.Logout)
+	root.Post("/api/login", handlers.Login)
+	root.Post("/api/logout", handlers.Logout)
+	root.Get("/api/login/methods", handlers.LoginMethods)
+	root.Post("/api/login/magic/request", handlers.RequestMagicLink)
+	root.Post("/api/login/magic", handlers.MagicLinkLogin)
+	root.Post("/api/token", handlers.IssueToken)
+	root.Post("/api/token/refresh", handlers.RefreshToken)
+	root.Post("/api/token/revoke", handlers.RevokeToken)
+	root.Get("/api/setup/status", handlers.SetupStatus)
+	root.Post("/api/setup", handlers.Setup)
+
+	// API routes group with auth middleware; every route declares the
+	// policy it requires (see policy.go)
+	api := root.Group("/api", AuthMiddleware(handlers.authService, basePath), handlers.RenewSession, handlers.APIRateLimit)
+	read := handlers.Require(PolicyRead)
+	write := handlers.Require(PolicyWrite)
+	admin := handlers.Require(PolicyAdmin)
 
-	// API routes group with auth middleware
-	api := app.Group("/api", AuthMiddleware(handlers.authService))
-	
 	// Data endpoints
-	api.Get("/data", handlers.GetData)
-	
+	api.Get("/data", read, handlers.GetData)
+	api.Get("/stats", read, handlers.GetStats)
+	api.Get("/stats/compare", read, handlers.CompareStats)
+	api.Get("/stats/slow-keys", read, handlers.GetSlowKeys)
+	api.Get("/orgs", read, handlers.GetOrgs)
+	api.Get("/history/export", read, handlers.ExportHistory)
+
+	// Grafana JSON datasource
+	api.Get("/grafana", read, handlers.GrafanaTest)
+	api.Post("/grafana/search", read, handlers.GrafanaSearch)
+	api.Post("/grafana/metrics", read, handlers.GrafanaSearch)
+	api.Post("/grafana/query", read, handlers.GrafanaQuery)
+
 	// API Key management
-	api.Get("/keys", handlers.GetKeys)
-	api.Post("/keys", handlers.AddKey)
-	api.Post("/keys/import", handlers.ImportKeys)
-	api.Get("/keys/:id/full", handlers.GetFullKey)
-	api.Delete("/keys/:id", handlers.DeleteKey)
-	api.Post("/keys/batch-delete", handlers.BatchDeleteKeys)
-
-	// Serve static files
-	app.Static("/", "./web/static", fiber.Static{
+	api.Get("/keys", read, handlers.GetKeys)
+	api.Get("/search", read, handlers.SearchKeys)
+	idempotent := IdempotencyMiddleware(handlers.idempotency)
+	api.Post("/keys", write, idempotent, handlers.AddKey)
+	api.Post("/keys/import", write, idempotent, handlers.ImportKeys)
+	api.Post("/keys/test", write, handlers.TestKey)
+	api.Post("/keys/export-full", handlers.Require(PolicyKeyExport), handlers.ExportFullKeys)
+	api.Get("/keys/:id/full", handlers.Require(PolicyFullKeyRead), handlers.GetFullKey)
+	api.Get("/keys/:id/chart", read, handlers.GetKeyChart)
+	api.Get("/keys/:id/raw", admin, handlers.GetRawResponse)
+	api.Patch("/keys/:id", write, handlers.UpdateKey)
+	api.Post("/keys/:id/disable", write, handlers.DisableKey)
+	api.Post("/keys/:id/enable", write, handlers.EnableKey)
+	api.Delete("/keys/:id", write, handlers.DeleteKey)
+	api.Post("/keys/batch-delete", write, idempotent, handlers.BatchDeleteKeys)
+
+	// Alerts
+	api.Get("/alerts", read, handlers.GetAlerts)
+	api.Post("/alerts/:id/ack", write, handlers.AckAlert)
+	api.Post("/notifications/test", admin, handlers.TestNotification)
+	api.Get("/notifications/deliveries", admin, handlers.GetDeliveries)
+
+	// Reports
+	api.Get("/reports", read, handlers.GetReports)
+	api.Post("/reports", admin, handlers.GenerateReport)
+	api.Get("/reports/:id/:format", read, handlers.GetReport)
+
+	// Refresh jobs
+	api.Get("/jobs/history", read, handlers.GetJobHistory)
+	api.Get("/jobs/current", read, handlers.GetRefreshProgress)
+
+	// Step-up and audit
+	api.Post("/auth/step-up", handlers.Require(PolicyStepUp), handlers.StepUp)
+	api.Get("/audit", admin, handlers.GetAudit)
+	api.Get("/audit/logins", admin, handlers.GetLoginAudit)
+
+	// Administration
+	api.Post("/admin/prune", admin, handlers.Prune)
+	api.Get("/admin/cache", admin, handlers.GetCacheStats)
+	api.Post("/admin/cache/flush", admin, handlers.FlushCache)
+	api.Get("/admin/deadletter", admin, handlers.GetDeadLetters)
+	api.Post("/admin/deadletter/:id/requeue", admin, handlers.RequeueDeadLetter)
+	api.Get("/settings", admin, handlers.GetSettings)
+	api.Put("/settings", admin, handlers.UpdateSettings)
+	api.Delete("/settings", admin, handlers.ResetSettings)
+	api.Get("/tenants", admin, handlers.GetTenants)
+
+	// Federation: peers pull the summary, the combined view shows them all
+	api.Get("/federation/summary", read, handlers.GetFederationSummary)
+	api.Get("/federation", admin, handlers.GetFederation)
+	api.Get("/federation/peers", admin, handlers.GetFederationPeers)
+
+	// Dashboard entry point
+	root.Get("/", handlers.Index)
+
+	// Serve static files from the embedded assets (or STATIC_DIR)
+	root.Use(StaticCacheMiddleware(handlers.config.StaticMaxAge), filesystem.New(filesystem.Config{
+		Root:   http.FS(handlers.static),
 		Browse: false,
 		Index:  "index.html",
-	})
+	}))
+
+	// SPA fallback for client-side routes
+	root.Get("/*", handlers.Index)
 }
@@ -0,0 +1,47 @@
+package api
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/droid-keyusage-go/web"
+	"github.com/gofiber/fiber/v2"
+)
+
+// fingerprinted matches asset names carrying a content hash, such as
+// app.3f2a1b9c.js; they never change and can be cached forever
+var fingerprinted = regexp.MustCompile(`\.[0-9a-fA-F]{8,}\.[a-zA-Z0-9]+$`)
+
+// staticRoot returns the dashboard assets: the embedded copy, or dir on disk
+// when set so assets can be edited without rebuilding
+func staticRoot(dir string) fs.FS {
+	if dir != "" {
+		return os.DirFS(dir)
+	}
+	return web.Static()
+}
+
+// StaticCacheMiddleware sets Cache-Control on static assets: fingerprinted
+// assets are immutable, HTML is always revalidated and everything else is
+// cached for maxAge
+func StaticCacheMiddleware(maxAge time.Duration) fiber.Handler {
+	cacheControl := "public, max-age=" + strconv.Itoa(int(maxAge/time.Second))
+	return func(c *fiber.Ctx) error {
+		name := path.Base(c.Path())
+		switch {
+		case fingerprinted.MatchString(name):
+			c.Set(fiber.HeaderCacheControl, "public, max-age=31536000, immutable")
+		case path.Ext(name) == ".html" || path.Ext(name) == "":
+			c.Set(fiber.HeaderCacheControl, "no-cache")
+		case maxAge > 0:
+			c.Set(fiber.HeaderCacheControl, cacheControl)
+		default:
+			c.Set(fiber.HeaderCacheControl, "no-cache")
+		}
+		return c.Next()
+	}
+}
@@ -0,0 +1,136 @@
+package api
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/droid-keyusage-go/internal/models"
+	"github.com/droid-keyusage-go/internal/services"
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+)
+
+// Tenant selection modes
+const (
+	// TenantModePath serves tenant <name> under <base path>/t/<name>
+	TenantModePath = "path"
+	// TenantModeSubdomain serves tenant <name> on hosts starting with "<name>."
+	TenantModeSubdomain = "subdomain"
+)
+
+// DefaultTenant names the tenant serving requests that select no tenant;
+// it keeps the unprefixed Redis keys of single-tenant deployments
+const DefaultTenant = "default"
+
+// tenantPathPrefix precedes the tenant name in path mode
+const tenantPathPrefix = "/t/"
+
+var tenantNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,31}$`)
+
+// ParseTenants parses a comma-separated list of tenant names such as
+// "engineering,sales"
+func ParseTenants(spec string) ([]string, error) {
+	var names []string
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !tenantNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("invalid tenant name %q: use lowercase letters, digits and dashes", name)
+		}
+		if name == DefaultTenant || seen[name] {
+			return nil, fmt.Errorf("tenant %q is reserved or defined twice", name)
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// TenantBasePath returns the base path a tenant's routes are served under
+func TenantBasePath(mode, basePath, name string) string {
+	if mode == TenantModeSubdomain {
+		return basePath
+	}
+	return basePath + tenantPathPrefix + name
+}
+
+// TenantRedisPrefix namespaces the Redis keys of a tenant
+func TenantRedisPrefix(name string) string {
+	return "tenant:" + name + ":"
+}
+
+// TenantMiddleware hands requests selecting a tenant to that tenant's app;
+// other requests continue to the default tenant's routes
+func TenantMiddleware(mode, basePath string, tenants map[string]*fiber.App) fiber.Handler {
+	handlers := make(map[string]fasthttp.RequestHandler, len(tenants))
+	for name, app := range tenants {
+		handlers[name] = app.Handler()
+	}
+
+	return func(c *fiber.Ctx) error {
+		var name string
+		if mode == TenantModeSubdomain {
+			// Hosts that don't start with a tenant name belong to the default tenant
+			label, _, found := strings.Cut(c.Hostname(), ".")
+			if !found || handlers[label] == nil {
+				return c.Next()
+			}
+			name = label
+		} else {
+			rest, ok := strings.CutPrefix(c.Path(), basePath+tenantPathPrefix)
+			if !ok {
+				return c.Next()
+			}
+			name, _, _ = strings.Cut(rest, "/")
+		}
+
+		handler := handlers[name]
+		if handler == nil {
+			return fiber.ErrNotFound
+		}
+		handler(c.Context())
+		return nil
+	}
+}
+
+// SetTenants gives the handlers an overview of every tenant for GET
+// /api/tenants; only the default tenant's handlers get one
+func (h *Handlers) SetTenants(tenants map[string]*services.APIKeyService) {
+	h.tenants = tenants
+}
+
+// GetTenants returns the statistics of every tenant (admin only)
+func (h *Handlers) GetTenants(c *fiber.Ctx) error {
+	if h.tenants == nil {
+		return fiber.ErrNotFound
+	}
+
+	admin := services.Principal{Role: services.RoleAdmin}
+	summaries := make([]*models.TenantSummary, 0, len(h.tenants))
+	for name, apiKeys := range h.tenants {
+		summary := &models.TenantSummary{
+			Name:     name,
+			BasePath: TenantBasePath(h.config.TenantMode, h.config.BasePath, name),
+		}
+		if name == DefaultTenant {
+			summary.BasePath = h.config.BasePath
+		}
+		stats, err := apiKeys.GetStats(c.UserContext(), admin)
+		if err != nil {
+			summary.Error = err.Error()
+		} else {
+			summary.Stats = stats
+		}
+		summaries = append(summaries, summary)
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		return summaries[i].Name < summaries[j].Name
+	})
+
+	return sendList(c, summaries)
+}
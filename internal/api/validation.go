@@ -0,0 +1,139 @@
+package api
+
+import (
+	"errors"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/droid-keyusage-go/internal/models"
+	"github.com/droid-keyusage-go/internal/services"
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+)
+
+// keyIDPattern matches IDs generated for stored keys ("key-<8 hex>-<unix>")
+var keyIDPattern = regexp.MustCompile(`^key-[0-9a-f]{8}-[0-9]+$`)
+
+var validate = newValidator()
+
+func newValidator() *validator.Validate {
+	v := validator.New(validator.WithRequiredStructEnabled())
+
+	// Report fields by their JSON names
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+
+	_ = v.RegisterValidation("keyid", func(fl validator.FieldLevel) bool {
+		return keyIDPattern.MatchString(fl.Field().String())
+	})
+	_ = v.RegisterValidation("notblank", func(fl validator.FieldLevel) bool {
+		return strings.TrimSpace(fl.Field().String()) != ""
+	})
+	// Empty durations are accepted so updates can clear a value
+	_ = v.RegisterValidation("duration", func(fl validator.FieldLevel) bool {
+		value := strings.TrimSpace(fl.Field().String())
+		if value == "" {
+			return true
+		}
+		_, err := services.ParseRefreshInterval(value)
+		return err == nil
+	})
+
+	return v
+}
+
+// bindAndValidate parses the request body into req and validates its struct tags
+func bindAndValidate(c *fiber.Ctx, req interface{}) error {
+	if err := c.BodyParser(req); err != nil {
+		return err
+	}
+	return validate.Struct(req)
+}
+
+// validateKeyID checks a key ID taken from the URL
+func validateKeyID(id string) error {
+	return validate.Var(id, "required,keyid")
+}
+
+// writeBindError responds with 422 and per-field details for validation
+// failures, or 400 when the body could not be parsed at all
+func writeBindError(c *fiber.Ctx, err error) error {
+	var validationErrors validator.ValidationErrors
+	if !errors.As(err, &validationErrors) {
+		return writeError(c, 400, "error.invalid_request")
+	}
+
+	fields := make([]models.FieldError, 0, len(validationErrors))
+	for _, fe := range validationErrors {
+		fields = append(fields, models.FieldError{
+			Field:   fieldPath(fe),
+			Rule:    fe.Tag(),
+			Message: fieldMessage(c, fe),
+		})
+	}
+
+	resp := errorResponse(c, "error.validation_failed")
+	resp.Fields = fields
+	return c.Status(fiber.StatusUnprocessableEntity).JSON(resp)
+}
+
+// writeFieldErrors responds with 422 and the given per-field details
+func writeFieldErrors(c *fiber.Ctx, fields ...models.FieldError) error {
+	resp := errorResponse(c, "error.validation_failed")
+	resp.Fields = fields
+	return c.Status(fiber.StatusUnprocessableEntity).JSON(resp)
+}
+
+// writeTooManyItems responds with 422 when a list exceeds its configured limit
+func writeTooManyItems(c *fiber.Ctx, field string, limit int) error {
+	return writeFieldErrors(c, models.FieldError{
+		Field:   field,
+		Rule:    "max",
+		Message: msg(c, "field.max_items", strconv.Itoa(limit)),
+	})
+}
+
+// fieldPath strips the struct name from the error namespace ("ImportRequest.keys[3]" -> "keys[3]")
+func fieldPath(fe validator.FieldError) string {
+	namespace := fe.Namespace()
+	if i := strings.Index(namespace, "."); i >= 0 {
+		return namespace[i+1:]
+	}
+	if namespace == "" {
+		return "id"
+	}
+	return namespace
+}
+
+// fieldMessage renders a localized message for a failed rule
+func fieldMessage(c *fiber.Ctx, fe validator.FieldError) string {
+	isCollection := fe.Kind() == reflect.Slice || fe.Kind() == reflect.Map
+
+	switch fe.Tag() {
+	case "required", "notblank", "keyid", "uuid":
+		return msg(c, "field."+fe.Tag())
+	case "min":
+		if isCollection {
+			return msg(c, "field.min_items", fe.Param())
+		}
+		return msg(c, "field.min_chars", fe.Param())
+	case "max":
+		if isCollection {
+			return msg(c, "field.max_items", fe.Param())
+		}
+		return msg(c, "field.max_chars", fe.Param())
+	case "oneof":
+		return msg(c, "field.oneof", fe.Param())
+	case "duration":
+		return msg(c, "field.duration")
+	default:
+		return msg(c, "field.invalid", fe.Tag())
+	}
+}
@@ -1,75 +1,625 @@
 package config
 
 import (
+	"net"
 	"os"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 )
 
+// DefaultContentSecurityPolicy allows the dashboard's inline scripts and
+// styles and Google Fonts, and nothing else from other origins
+const DefaultContentSecurityPolicy = "default-src 'self'; " +
+	"script-src 'self' 'unsafe-inline'; " +
+	"style-src 'self' 'unsafe-inline' https://fonts.googleapis.com; " +
+	"font-src 'self' https://fonts.gstatic.com; " +
+	"img-src 'self' data:; " +
+	"connect-src 'self'; " +
+	"frame-ancestors 'none'; " +
+	"base-uri 'self'; " +
+	"form-action 'self'"
+
 type Config struct {
-	// Server
-	Port string
-	Env  string
+	// Server; an empty Host listens on every interface
+	Host     string
+	Port     string
+	Env      string
+	BasePath string
+	LogLang  string
+
+	// Debug logging of request and response bodies for a sample of
+	// DebugLogSamplePercent percent of API requests
+	DebugLogSamplePercent float64
+	DebugLogMaxBody       int
+
+	// Multi-tenancy; tenant is set in the configuration of a tenant other
+	// than the default one (see ForTenant)
+	Tenants    string
+	TenantMode string
+	tenant     string
+
+	// RequestTimeout bounds the Redis and upstream work of one API request;
+	// 0 means no limit
+	RequestTimeout time.Duration
+
+	// Binary upgrades on SIGHUP: the old process hands its socket to the new
+	// one and waits up to UpgradeDrainTimeout for in-flight requests and a
+	// running scheduled refresh. PIDFile records the process serving.
+	GracefulUpgrade     bool
+	UpgradeDrainTimeout time.Duration
+	PIDFile             string
+
+	// Static assets
+	StaticDir    string
+	StaticMaxAge time.Duration
+
+	// Cross-origin access
+	CORSAllowedOrigins string
 
-	// Redis
-	RedisURL      string
-	RedisPassword string
-	RedisDB       int
+	// Security headers
+	ContentSecurityPolicy string
+	FrameOptions          string
+	ReferrerPolicy        string
+	HSTSMaxAge            time.Duration
+
+	// Redis; StorageBackend "memory" uses an in-process store instead.
+	// RedisPassword and RedisDB (when >= 0) override the values in RedisURL.
+	RedisURL          string
+	RedisPassword     string
+	RedisDB           int
+	RedisPoolSize     int
+	RedisMinIdleConns int
+	RedisMaxRetries   int
+	RedisDialTimeout  time.Duration
+	RedisReadTimeout  time.Duration
+	RedisWriteTimeout time.Duration
+	RedisPoolTimeout  time.Duration
+	StorageBackend    string
 
 	// Auth
-	AdminPassword string
-	SessionTTL    time.Duration
+	AdminPassword  string
+	ViewerPassword string
+	SessionTTL     time.Duration
+	// SessionShortTTL applies to logins without "remember me"
+	SessionShortTTL time.Duration
+	// With SessionSliding, sessions are extended on activity but never
+	// past SessionMaxAge after login
+	SessionSliding bool
+	SessionMaxAge  time.Duration
+	Users          string
+	KeyVisibility  string
+	// AuthDisabled turns off login when ADMIN_PASSWORD is not set, instead
+	// of requiring the first-run setup; on a non-loopback Host it also
+	// needs AuthRiskAccepted
+	AuthDisabled     bool
+	AuthRiskAccepted bool
+
+	// Bearer tokens (POST /api/token)
+	JWTSecret     string
+	JWTAccessTTL  time.Duration
+	JWTRefreshTTL time.Duration
+
+	// HTTPS with TLSCertFile and TLSKeyFile; clients presenting a
+	// certificate signed by TLSClientCAFile authenticate with the scopes
+	// ClientCertScopes maps its identity to, and TLSClientCertRequired
+	// refuses connections without one
+	TLSCertFile           string
+	TLSKeyFile            string
+	TLSClientCAFile       string
+	TLSClientCertRequired bool
+	ClientCertScopes      string
+
+	// ConfigDirs are the directories of CONFIG_DIR (see ReadConfigDirs);
+	// with ConfigReload a change to a setting that can't change at runtime
+	// starts a graceful upgrade, which reads them again
+	ConfigDirs   []string
+	ConfigReload bool
+
+	// Secrets read from HashiCorp Vault ("vault") or AWS Secrets Manager
+	// ("aws") instead of the environment, and read again every
+	// SecretsRefreshInterval (see ApplySecrets)
+	SecretsBackend         string
+	SecretsRefreshInterval time.Duration
+	VaultAddr              string
+	VaultToken             string
+	VaultNamespace         string
+	VaultSecretPath        string
+	AWSRegion              string
+	AWSSecretID            string
+	AWSAccessKeyID         string
+	AWSSecretAccessKey     string
+	AWSSessionToken        string
+	AWSSecretsEndpoint     string
+
+	// Login by emailed one-time links; PublicURL is the address links
+	// point to ({tenant} is replaced by the tenant name)
+	PublicURL      string
+	MagicLinkUsers string
+	MagicLinkTTL   time.Duration
+	SMTPHost       string
+	SMTPPort       int
+	SMTPUsername   string
+	SMTPPassword   string
+	SMTPFrom       string
+
+	// Key masking
+	MaskPrefixChars int
+	MaskSuffixChars int
 
-	// Worker Pool
-	MaxWorkers int
-	QueueSize  int
+	// Step-up and audit; GeoIPURL looks up the location of login IPs, with
+	// an {ip} placeholder, and LoginNotifyNewIP notifies logins from new IPs
+	StepUpTTL        time.Duration
+	AuditRetention   time.Duration
+	GeoIPURL         string
+	LoginNotifyNewIP bool
 
-	// HTTP Client
-	HTTPTimeout time.Duration
-	MaxRetries  int
+	// GeoIPDB and GeoIPASNDB are local MaxMind DB files adding the country
+	// and network of client IPs to audit entries and the access log; the
+	// admin surface may be limited to or closed for some countries
+	GeoIPDB               string
+	GeoIPASNDB            string
+	AdminAllowedCountries string
+	AdminBlockedCountries string
 
-	// Cache
+	// Worker Pool; TaskTimeout is the deadline of a single fetch, fetches
+	// slower than SlowTaskThreshold are logged, DedupeByKey fetches
+	// entries storing the same key value only once and UpstreamRawMaxBytes
+	// keeps that much of each provider response; refreshes save their
+	// results every RefreshChunkSize keys
+	MaxWorkers          int
+	QueueSize           int
+	TaskTimeout         time.Duration
+	SlowTaskThreshold   time.Duration
+	DedupeByKey         bool
+	UpstreamRawMaxBytes int
+	RefreshChunkSize    int
+
+	// HooksScript is a Lua script run at the refresh, alert and import
+	// hooks, each call bounded by HooksTimeout
+	HooksScript  string
+	HooksTimeout time.Duration
+
+	// HTTP Client; ProviderHTTPClients tunes the client of each provider,
+	// upstream host names are cached for DNSCacheTTL and the other address
+	// family is tried after DNSFallbackDelay
+	HTTPTimeout         time.Duration
+	MaxRetries          int
+	ProviderHTTPClients string
+	DNSCacheTTL         time.Duration
+	DNSFallbackDelay    time.Duration
+
+	// Plugins lists the executables serving extra providers and channel types
+	Plugins string
+
+	// Cache; CacheTTLGroups overrides CacheTTL per key group
 	CacheTTL       time.Duration
+	CacheTTLGroups string
 	LocalCacheSize int
 
+	// DataOrder orders the results of /api/data: created_at or name
+	DataOrder string
+
+	// Refresh scheduling; keys, groups and CacheTTL never refresh more
+	// often than MinRefreshInterval, and RefreshCheckInterval (0 = off)
+	// is how often keys due for a refresh are refreshed in the background
+	MinRefreshInterval   time.Duration
+	RefreshCheckInterval time.Duration
+
+	// Startup: load cached usage from Redis into the local cache and
+	// refresh every key in the background
+	CacheWarmOnStart bool
+	RefreshOnStart   bool
+
+	// Retry queue; keys whose fetch failed transiently are retried up to
+	// RetryMaxAttempts times (0 = off) starting RetryBackoff after the
+	// failure, then dead-lettered
+	RetryMaxAttempts   int
+	RetryBackoff       time.Duration
+	RetryCheckInterval time.Duration
+
+	// ComputedFields defines extra per-key values returned by /api/data
+	ComputedFields string
+
+	// Batch limits
+	MaxImportKeys    int
+	MaxBatchDelete   int
+	StorageBatchSize int
+
+	// Key validation
+	KeyFormatRules string
+
+	// Idempotency
+	IdempotencyTTL time.Duration
+
 	// Rate Limiting
 	RateLimit      int
 	RateLimitBurst int
+	// RateLimitEndpoints are stricter limits for expensive endpoints, e.g.
+	// "GET /api/data?refresh=true 10/m;POST /api/keys/import 5/m"
+	RateLimitEndpoints string
+	// TokenQuotas are daily request quotas of API clients, e.g.
+	// "alice=10000;*=50000"
+	TokenQuotas string
+
+	// Retention
+	PruneInterval       time.Duration
+	HistoryRetention    time.Duration
+	DeletedKeyRetention time.Duration
+	JobHistoryRetention time.Duration
+	DeliveryRetention   time.Duration
+
+	// Notifications
+	NotifyWebhookURL    string
+	NotifyWebhookSecret string
+	NotifyQuietHours    string
+	// NotifyChannels is a JSON array of further notification channels
+	NotifyChannels      string
+	ExpiryReminderDays  int
+	ExpiryCheckInterval time.Duration
+
+	// Key health
+	AutoDisableFailures int
+	KeyRecheckInterval  time.Duration
+
+	// Reports
+	ReportInterval  time.Duration
+	ReportFormats   string
+	ReportRetention time.Duration
+	ReportNotify    bool
+
+	// S3-compatible bucket for backups and reports
+	S3Endpoint     string
+	S3Region       string
+	S3Bucket       string
+	S3AccessKey    string
+	S3SecretKey    string
+	S3Prefix       string
+	BackupInterval time.Duration
+
+	// StatsD/DogStatsD metrics
+	StatsdHost     string
+	StatsdPort     int
+	StatsdPrefix   string
+	StatsdTags     string
+	StatsdInterval time.Duration
+
+	// Prometheus remote-write; RemoteWriteLabels are added to every series,
+	// e.g. "env=prod,region=eu"
+	RemoteWriteURL         string
+	RemoteWriteInterval    time.Duration
+	RemoteWriteUsername    string
+	RemoteWritePassword    string
+	RemoteWriteBearerToken string
+	RemoteWriteLabels      string
+
+	// Mock provider mode
+	ProviderMock           bool
+	ProviderMockLatency    time.Duration
+	ProviderMockErrorRate  float64
+	ProviderMockUsedMean   float64
+	ProviderMockUsedStddev float64
+	ProviderMockAllowance  float64
+
+	// Upstream statistics
+	UpstreamWindow        int
+	UpstreamDegradedBelow float64
+
+	// Dead-man's switch heartbeat
+	HeartbeatURL      string
+	HeartbeatFailURL  string
+	HeartbeatInterval time.Duration
+
+	// Federation; FederationPeers are the instances pulled into the
+	// combined dashboard, FederationName is how this one appears in it
+	FederationPeers    string
+	FederationName     string
+	FederationInterval time.Duration
+
+	// Alerts
+	AlertUsageThreshold float64
+	AlertDedupWindow    time.Duration
+	AlertRetention      time.Duration
+	// AlertUpstreamDownAfter is how long every fetch from a provider must
+	// fail before the critical upstream_down alert fires; 0 disables it
+	AlertUpstreamDownAfter time.Duration
 }
 
 func Load() *Config {
 	return &Config{
+		Host: getEnv("HOST", ""),
 		Port: getEnv("PORT", "8080"),
 		Env:  getEnv("ENV", "development"),
 
-		RedisURL:      getEnv("REDIS_URL", "redis://localhost:6379/0"),
-		RedisPassword: getEnv("REDIS_PASSWORD", ""),
-		RedisDB:       getEnvAsInt("REDIS_DB", 0),
+		BasePath: normalizeBasePath(getEnv("BASE_PATH", "")),
+		LogLang:  getEnv("LOG_LANG", "zh"),
+
+		DebugLogSamplePercent: getEnvAsFloat("DEBUG_LOG_SAMPLE_PERCENT", 0),
+		DebugLogMaxBody:       getEnvAsInt("DEBUG_LOG_MAX_BODY", 4096),
+
+		Tenants:    getEnv("TENANTS", ""),
+		TenantMode: getEnv("TENANT_MODE", "path"),
+
+		RequestTimeout: getEnvAsDuration("REQUEST_TIMEOUT", 0),
+
+		GracefulUpgrade:     getEnvAsBool("GRACEFUL_UPGRADE", true),
+		UpgradeDrainTimeout: getEnvAsDuration("UPGRADE_DRAIN_TIMEOUT", 5*time.Minute),
+		PIDFile:             getEnv("PID_FILE", ""),
+
+		StaticDir:    getEnv("STATIC_DIR", ""),
+		StaticMaxAge: getEnvAsDuration("STATIC_MAX_AGE", time.Hour),
+
+		CORSAllowedOrigins: getEnv("CORS_ALLOWED_ORIGINS", ""),
+
+		ContentSecurityPolicy: getEnvOrOff("SECURITY_CSP", DefaultContentSecurityPolicy),
+		FrameOptions:          getEnv("SECURITY_FRAME_OPTIONS", "DENY"),
+		ReferrerPolicy:        getEnv("SECURITY_REFERRER_POLICY", "strict-origin-when-cross-origin"),
+		HSTSMaxAge:            getEnvAsDuration("SECURITY_HSTS_MAX_AGE", 180*24*time.Hour),
+
+		RedisURL:          getEnv("REDIS_URL", "redis://localhost:6379/0"),
+		RedisPassword:     getEnv("REDIS_PASSWORD", ""),
+		RedisDB:           getEnvAsInt("REDIS_DB", -1),
+		RedisPoolSize:     getEnvAsInt("REDIS_POOL_SIZE", 100),
+		RedisMinIdleConns: getEnvAsInt("REDIS_MIN_IDLE_CONNS", 10),
+		RedisMaxRetries:   getEnvAsInt("REDIS_MAX_RETRIES", 3),
+		RedisDialTimeout:  getEnvAsDuration("REDIS_DIAL_TIMEOUT", 5*time.Second),
+		RedisReadTimeout:  getEnvAsDuration("REDIS_READ_TIMEOUT", 3*time.Second),
+		RedisWriteTimeout: getEnvAsDuration("REDIS_WRITE_TIMEOUT", 3*time.Second),
+		RedisPoolTimeout:  getEnvAsDuration("REDIS_POOL_TIMEOUT", 4*time.Second),
+		StorageBackend:    getEnv("STORAGE_BACKEND", "redis"),
+
+		AdminPassword:    getEnv("ADMIN_PASSWORD", ""),
+		ViewerPassword:   getEnv("VIEWER_PASSWORD", ""),
+		AuthDisabled:     getEnvAsBool("AUTH_DISABLED", false),
+		AuthRiskAccepted: getEnvAsBool("I_UNDERSTAND_THE_RISK", false),
+		SessionTTL:       getEnvAsDuration("SESSION_TTL", 7*24*time.Hour),
+		SessionShortTTL:  getEnvAsDuration("SESSION_SHORT_TTL", 12*time.Hour),
+		SessionSliding:   getEnvAsBool("SESSION_SLIDING", true),
+		SessionMaxAge:    getEnvAsDuration("SESSION_MAX_AGE", 30*24*time.Hour),
+		Users:            getEnv("USERS", ""),
+		KeyVisibility:    getEnv("KEY_VISIBILITY", "all"),
+
+		JWTSecret:     getEnv("JWT_SECRET", ""),
+		JWTAccessTTL:  getEnvAsDuration("JWT_ACCESS_TTL", 15*time.Minute),
+		JWTRefreshTTL: getEnvAsDuration("JWT_REFRESH_TTL", 30*24*time.Hour),
+
+		TLSCertFile:           getEnv("TLS_CERT_FILE", ""),
+		TLSKeyFile:            getEnv("TLS_KEY_FILE", ""),
+		TLSClientCAFile:       getEnv("TLS_CLIENT_CA_FILE", ""),
+		TLSClientCertRequired: getEnvAsBool("TLS_CLIENT_CERT_REQUIRED", false),
+		ClientCertScopes:      getEnv("CLIENT_CERT_SCOPES", ""),
+
+		ConfigDirs:   ParseConfigDirs(os.Getenv("CONFIG_DIR")),
+		ConfigReload: getEnvAsBool("CONFIG_RELOAD", false),
+
+		SecretsBackend:         getEnv("SECRETS_BACKEND", ""),
+		SecretsRefreshInterval: getEnvAsDuration("SECRETS_REFRESH_INTERVAL", 5*time.Minute),
+		VaultAddr:              getEnv("VAULT_ADDR", ""),
+		VaultToken:             getEnv("VAULT_TOKEN", ""),
+		VaultNamespace:         getEnv("VAULT_NAMESPACE", ""),
+		VaultSecretPath:        getEnv("VAULT_SECRET_PATH", ""),
+		AWSRegion:              getEnv("AWS_REGION", ""),
+		AWSSecretID:            getEnv("AWS_SECRET_ID", ""),
+		AWSAccessKeyID:         getEnv("AWS_ACCESS_KEY_ID", ""),
+		AWSSecretAccessKey:     getEnv("AWS_SECRET_ACCESS_KEY", ""),
+		AWSSessionToken:        getEnv("AWS_SESSION_TOKEN", ""),
+		AWSSecretsEndpoint:     getEnv("AWS_SECRETS_ENDPOINT", ""),
+
+		PublicURL:      getEnv("PUBLIC_URL", ""),
+		MagicLinkUsers: getEnv("MAGIC_LINK_USERS", ""),
+		MagicLinkTTL:   getEnvAsDuration("MAGIC_LINK_TTL", 15*time.Minute),
+		SMTPHost:       getEnv("SMTP_HOST", ""),
+		SMTPPort:       getEnvAsInt("SMTP_PORT", 587),
+		SMTPUsername:   getEnv("SMTP_USERNAME", ""),
+		SMTPPassword:   getEnv("SMTP_PASSWORD", ""),
+		SMTPFrom:       getEnv("SMTP_FROM", ""),
+
+		MaskPrefixChars: getEnvAsInt("MASK_PREFIX_CHARS", 4),
+		MaskSuffixChars: getEnvAsInt("MASK_SUFFIX_CHARS", 4),
+
+		StepUpTTL:        getEnvAsDuration("STEP_UP_TTL", 5*time.Minute),
+		AuditRetention:   getEnvAsDuration("AUDIT_RETENTION", 90*24*time.Hour),
+		GeoIPURL:         getEnv("GEOIP_URL", ""),
+		LoginNotifyNewIP: getEnvAsBool("LOGIN_NOTIFY_NEW_IP", true),
+
+		GeoIPDB:               getEnv("GEOIP_DB", ""),
+		GeoIPASNDB:            getEnv("GEOIP_ASN_DB", ""),
+		AdminAllowedCountries: getEnv("ADMIN_ALLOWED_COUNTRIES", ""),
+		AdminBlockedCountries: getEnv("ADMIN_BLOCKED_COUNTRIES", ""),
 
-		AdminPassword: getEnv("ADMIN_PASSWORD", ""),
-		SessionTTL:    getEnvAsDuration("SESSION_TTL", 7*24*time.Hour),
+		MaxWorkers:          getEnvAsInt("MAX_WORKERS", 100),
+		QueueSize:           getEnvAsInt("QUEUE_SIZE", 10000),
+		TaskTimeout:         getEnvAsDuration("TASK_TIMEOUT", 15*time.Second),
+		SlowTaskThreshold:   getEnvAsDuration("SLOW_TASK_THRESHOLD", 10*time.Second),
+		DedupeByKey:         getEnvAsBool("REFRESH_DEDUPE_BY_KEY", false),
+		UpstreamRawMaxBytes: getEnvAsInt("UPSTREAM_RAW_MAX_BYTES", 0),
+		RefreshChunkSize:    getEnvAsInt("REFRESH_CHUNK_SIZE", 500),
 
-		MaxWorkers: getEnvAsInt("MAX_WORKERS", 100),
-		QueueSize:  getEnvAsInt("QUEUE_SIZE", 10000),
+		HooksScript:  getEnv("HOOKS_SCRIPT", ""),
+		HooksTimeout: getEnvAsDuration("HOOKS_TIMEOUT", 100*time.Millisecond),
 
-		HTTPTimeout: getEnvAsDuration("HTTP_TIMEOUT", 30*time.Second),
-		MaxRetries:  getEnvAsInt("MAX_RETRIES", 3),
+		HTTPTimeout:         getEnvAsDuration("HTTP_TIMEOUT", 30*time.Second),
+		MaxRetries:          getEnvAsInt("MAX_RETRIES", 3),
+		ProviderHTTPClients: getEnv("PROVIDER_HTTP_CLIENTS", ""),
+		Plugins:             getEnv("PLUGINS", ""),
+		DNSCacheTTL:         getEnvAsDuration("DNS_CACHE_TTL", time.Minute),
+		DNSFallbackDelay:    getEnvAsDuration("DNS_FALLBACK_DELAY", 300*time.Millisecond),
 
 		CacheTTL:       getEnvAsDuration("CACHE_TTL", 5*time.Minute),
+		CacheTTLGroups: getEnv("CACHE_TTL_GROUPS", ""),
 		LocalCacheSize: getEnvAsInt("LOCAL_CACHE_SIZE", 1000),
 
-		RateLimit:      getEnvAsInt("RATE_LIMIT", 100),
-		RateLimitBurst: getEnvAsInt("RATE_LIMIT_BURST", 200),
+		DataOrder: getEnv("DATA_ORDER", "created_at"),
+
+		MinRefreshInterval:   getEnvAsDuration("MIN_REFRESH_INTERVAL", 10*time.Second),
+		RefreshCheckInterval: getEnvAsDuration("REFRESH_CHECK_INTERVAL", 0),
+
+		CacheWarmOnStart: getEnvAsBool("CACHE_WARM_ON_START", true),
+		RefreshOnStart:   getEnvAsBool("REFRESH_ON_START", false),
+
+		RetryMaxAttempts:   getEnvAsInt("RETRY_MAX_ATTEMPTS", 5),
+		RetryBackoff:       getEnvAsDuration("RETRY_BACKOFF", 30*time.Second),
+		RetryCheckInterval: getEnvAsDuration("RETRY_CHECK_INTERVAL", 10*time.Second),
+
+		ComputedFields: getEnv("COMPUTED_FIELDS", ""),
+
+		MaxImportKeys:    getEnvAsInt("MAX_IMPORT_KEYS", 10000),
+		MaxBatchDelete:   getEnvAsInt("MAX_BATCH_DELETE", 10000),
+		StorageBatchSize: getEnvAsInt("STORAGE_BATCH_SIZE", 500),
+
+		KeyFormatRules: getEnv("KEY_FORMAT_RULES", "factory:8:512:^fk-"),
+
+		IdempotencyTTL: getEnvAsDuration("IDEMPOTENCY_TTL", 24*time.Hour),
+
+		RateLimit:          getEnvAsInt("RATE_LIMIT", 100),
+		RateLimitBurst:     getEnvAsInt("RATE_LIMIT_BURST", 200),
+		RateLimitEndpoints: getEnv("RATE_LIMIT_ENDPOINTS", ""),
+		TokenQuotas:        getEnv("TOKEN_QUOTAS", ""),
+
+		PruneInterval:       getEnvAsDuration("PRUNE_INTERVAL", time.Hour),
+		HistoryRetention:    getEnvAsDuration("HISTORY_RETENTION", 90*24*time.Hour),
+		DeletedKeyRetention: getEnvAsDuration("DELETED_KEY_RETENTION", 30*24*time.Hour),
+		JobHistoryRetention: getEnvAsDuration("JOB_HISTORY_RETENTION", 30*24*time.Hour),
+		DeliveryRetention:   getEnvAsDuration("DELIVERY_RETENTION", 30*24*time.Hour),
+
+		NotifyWebhookURL:    getEnv("NOTIFY_WEBHOOK_URL", ""),
+		NotifyWebhookSecret: getEnv("NOTIFY_WEBHOOK_SECRET", ""),
+		NotifyQuietHours:    getEnv("NOTIFY_WEBHOOK_QUIET_HOURS", ""),
+		NotifyChannels:      getEnv("NOTIFY_CHANNELS", ""),
+		ExpiryReminderDays:  getEnvAsInt("EXPIRY_REMINDER_DAYS", 7),
+		ExpiryCheckInterval: getEnvAsDuration("EXPIRY_CHECK_INTERVAL", time.Hour),
+
+		AutoDisableFailures: getEnvAsInt("AUTO_DISABLE_FAILURES", 5),
+		KeyRecheckInterval:  getEnvAsDuration("KEY_RECHECK_INTERVAL", 30*time.Minute),
+
+		ReportInterval:  getEnvAsDuration("REPORT_INTERVAL", 24*time.Hour),
+		ReportFormats:   getEnv("REPORT_FORMATS", "json,csv,html"),
+		ReportRetention: getEnvAsDuration("REPORT_RETENTION", 90*24*time.Hour),
+		ReportNotify:    getEnvAsBool("REPORT_NOTIFY", false),
+
+		S3Endpoint:     getEnv("S3_ENDPOINT", ""),
+		S3Region:       getEnv("S3_REGION", "us-east-1"),
+		S3Bucket:       getEnv("S3_BUCKET", ""),
+		S3AccessKey:    getEnv("S3_ACCESS_KEY_ID", ""),
+		S3SecretKey:    getEnv("S3_SECRET_ACCESS_KEY", ""),
+		S3Prefix:       getEnv("S3_PREFIX", ""),
+		BackupInterval: getEnvAsDuration("BACKUP_INTERVAL", 24*time.Hour),
+
+		StatsdHost:     getEnv("STATSD_HOST", ""),
+		StatsdPort:     getEnvAsInt("STATSD_PORT", 8125),
+		StatsdPrefix:   getEnv("STATSD_PREFIX", "keyusage."),
+		StatsdTags:     getEnv("STATSD_TAGS", ""),
+		StatsdInterval: getEnvAsDuration("STATSD_INTERVAL", 10*time.Second),
+
+		RemoteWriteURL:         getEnv("REMOTE_WRITE_URL", ""),
+		RemoteWriteInterval:    getEnvAsDuration("REMOTE_WRITE_INTERVAL", 30*time.Second),
+		RemoteWriteUsername:    getEnv("REMOTE_WRITE_USERNAME", ""),
+		RemoteWritePassword:    getEnv("REMOTE_WRITE_PASSWORD", ""),
+		RemoteWriteBearerToken: getEnv("REMOTE_WRITE_BEARER_TOKEN", ""),
+		RemoteWriteLabels:      getEnv("REMOTE_WRITE_LABELS", ""),
+
+		ProviderMock:           getEnvAsBool("PROVIDER_MOCK", false),
+		ProviderMockLatency:    getEnvAsDuration("PROVIDER_MOCK_LATENCY", 100*time.Millisecond),
+		ProviderMockErrorRate:  getEnvAsFloat("PROVIDER_MOCK_ERROR_RATE", 0.05),
+		ProviderMockUsedMean:   getEnvAsFloat("PROVIDER_MOCK_USED_MEAN", 0.5),
+		ProviderMockUsedStddev: getEnvAsFloat("PROVIDER_MOCK_USED_STDDEV", 0.25),
+		ProviderMockAllowance:  getEnvAsFloat("PROVIDER_MOCK_ALLOWANCE", 20000000),
+
+		UpstreamWindow:        getEnvAsInt("UPSTREAM_WINDOW", 200),
+		UpstreamDegradedBelow: getEnvAsFloat("UPSTREAM_DEGRADED_BELOW", 0.9),
+
+		HeartbeatURL:      getEnv("HEARTBEAT_URL", ""),
+		HeartbeatFailURL:  getEnv("HEARTBEAT_FAIL_URL", ""),
+		HeartbeatInterval: getEnvAsDuration("HEARTBEAT_INTERVAL", 5*time.Minute),
+
+		FederationPeers:    getEnv("FEDERATION_PEERS", ""),
+		FederationName:     getEnv("FEDERATION_NAME", hostname()),
+		FederationInterval: getEnvAsDuration("FEDERATION_INTERVAL", time.Minute),
+
+		AlertUsageThreshold:    getEnvAsFloat("ALERT_USAGE_THRESHOLD", 0.9),
+		AlertDedupWindow:       getEnvAsDuration("ALERT_DEDUP_WINDOW", 6*time.Hour),
+		AlertRetention:         getEnvAsDuration("ALERT_RETENTION", 90*24*time.Hour),
+		AlertUpstreamDownAfter: getEnvAsDuration("ALERT_UPSTREAM_DOWN_AFTER", 10*time.Minute),
+	}
+}
+
+// secretSettings are the settings a secret store may supply, by the name of
+// their environment variable
+var secretSettings = map[string]func(c *Config) *string{
+	"ADMIN_PASSWORD":        func(c *Config) *string { return &c.AdminPassword },
+	"VIEWER_PASSWORD":       func(c *Config) *string { return &c.ViewerPassword },
+	"JWT_SECRET":            func(c *Config) *string { return &c.JWTSecret },
+	"REDIS_PASSWORD":        func(c *Config) *string { return &c.RedisPassword },
+	"SMTP_PASSWORD":         func(c *Config) *string { return &c.SMTPPassword },
+	"S3_SECRET_ACCESS_KEY":  func(c *Config) *string { return &c.S3SecretKey },
+	"NOTIFY_WEBHOOK_SECRET": func(c *Config) *string { return &c.NotifyWebhookSecret },
+}
+
+// ApplySecrets overrides the settings named in values, such as
+// ADMIN_PASSWORD, with the values read from a secret store, and returns the
+// names of those it set. Empty values and other names are ignored. The
+// configuration of a tenant takes its passwords from TENANT_<NAME>_
+// ADMIN_PASSWORD and TENANT_<NAME>_VIEWER_PASSWORD instead.
+func (c *Config) ApplySecrets(values map[string]string) []string {
+	var applied []string
+	for setting, field := range secretSettings {
+		name := c.settingName(setting)
+		if value := values[name]; value != "" {
+			*field(c) = value
+			applied = append(applied, name)
+		}
+	}
+	sort.Strings(applied)
+	return applied
+}
+
+// ListenAddr returns the address the server listens on
+func (c *Config) ListenAddr() string {
+	return net.JoinHostPort(c.Host, c.Port)
+}
+
+// OpenAccess reports whether anyone reaching the server gets admin access,
+// because auth is disabled and no admin password is set
+func (c *Config) OpenAccess() bool {
+	return c.AuthDisabled && c.AdminPassword == ""
+}
+
+// LoopbackOnly reports whether the server only listens on a loopback
+// address, so it can't be reached from other machines
+func (c *Config) LoopbackOnly() bool {
+	if c.Host == "localhost" {
+		return true
 	}
+	ip := net.ParseIP(c.Host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// hostname names this machine, or is empty when it can't be determined
+func hostname() string {
+	name, _ := os.Hostname()
+	return name
 }
 
 func getEnv(key, defaultValue string) string {
+	if value := fileValues[key]; value != "" {
+		return value
+	}
 	if value := os.Getenv(key); value != "" {
 		return value
 	}
 	return defaultValue
 }
 
+// getEnvOrOff is getEnv where the value "off" turns the setting off
+func getEnvOrOff(key, defaultValue string) string {
+	value := getEnv(key, defaultValue)
+	if strings.EqualFold(value, "off") {
+		return ""
+	}
+	return value
+}
+
 func getEnvAsInt(key string, defaultValue int) int {
 	valueStr := getEnv(key, "")
 	if value, err := strconv.Atoi(valueStr); err == nil {
@@ -78,6 +628,22 @@ func getEnvAsInt(key string, defaultValue int) int {
 	return defaultValue
 }
 
+func getEnvAsFloat(key string, defaultValue float64) float64 {
+	valueStr := getEnv(key, "")
+	if value, err := strconv.ParseFloat(valueStr, 64); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+func getEnvAsBool(key string, defaultValue bool) bool {
+	valueStr := getEnv(key, "")
+	if value, err := strconv.ParseBool(valueStr); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
 func getEnvAsDuration(key string, defaultValue time.Duration) time.Duration {
 	valueStr := getEnv(key, "")
 	if value, err := time.ParseDuration(valueStr); err == nil {
@@ -85,3 +651,13 @@ func getEnvAsDuration(key string, defaultValue time.Duration) time.Duration {
 	}
 	return defaultValue
 }
+
+// normalizeBasePath turns values like "droid", "/droid/" or "/" into the
+// canonical "/droid" form (or "" when serving from the root)
+func normalizeBasePath(basePath string) string {
+	basePath = strings.Trim(strings.TrimSpace(basePath), "/")
+	if basePath == "" {
+		return ""
+	}
+	return "/" + basePath
+}
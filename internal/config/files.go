@@ -0,0 +1,89 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// fileValues holds the settings read from CONFIG_DIR by ReadConfigDirs
+var fileValues map[string]string
+
+// ParseConfigDirs splits a comma-separated list of directories such as
+// CONFIG_DIR; an empty spec means none
+func ParseConfigDirs(spec string) []string {
+	var dirs []string
+	for _, dir := range strings.Split(spec, ",") {
+		if dir = strings.TrimSpace(dir); dir != "" {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// ReadConfigDirs reads the directories listed in CONFIG_DIR, such as a
+// mounted Kubernetes Secret or ConfigMap, and makes their settings visible
+// to Load, where they take precedence over the environment
+func ReadConfigDirs() error {
+	values, err := ReadDirs(ParseConfigDirs(os.Getenv("CONFIG_DIR")))
+	if err != nil {
+		return err
+	}
+	fileValues = values
+	return nil
+}
+
+// ReadDirs reads one setting per file from each directory: the file name is
+// the name of the environment variable and the content, without trailing
+// line breaks, its value. Hidden files, such as the ..data link Kubernetes
+// swaps on updates, and subdirectories are skipped; a later directory
+// overrides an earlier one.
+func ReadDirs(dirs []string) (map[string]string, error) {
+	values := make(map[string]string)
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			if strings.HasPrefix(name, ".") {
+				continue
+			}
+			// Mounted files are usually links into the ..data directory
+			path := filepath.Join(dir, name)
+			info, err := os.Stat(path)
+			if err != nil {
+				return nil, err
+			}
+			if !info.Mode().IsRegular() {
+				continue
+			}
+			content, err := os.ReadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("config file %s: %w", path, err)
+			}
+			values[name] = strings.TrimRight(string(content), "\r\n")
+		}
+	}
+	return values, nil
+}
+
+// IsSecretSetting reports whether the setting named name may change while
+// the server runs, when it is rotated in a secret store or a mounted file
+// (see ApplySecrets)
+func IsSecretSetting(name string) bool {
+	if _, ok := secretSettings[name]; ok {
+		return true
+	}
+	if !strings.HasPrefix(name, tenantSettingPrefix) {
+		return false
+	}
+	for setting := range secretSettings {
+		if _, ok := tenantCredentials[setting]; ok && strings.HasSuffix(name, "_"+setting) {
+			return true
+		}
+	}
+	return false
+}
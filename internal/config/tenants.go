@@ -0,0 +1,55 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// tenantSettingPrefix precedes the tenant name in the settings of a tenant
+const tenantSettingPrefix = "TENANT_"
+
+// tenantCredentials are the settings every tenant other than the default
+// one sets for itself, by the name of the default tenant's setting
+var tenantCredentials = map[string]func(c *Config) *string{
+	"ADMIN_PASSWORD":     func(c *Config) *string { return &c.AdminPassword },
+	"VIEWER_PASSWORD":    func(c *Config) *string { return &c.ViewerPassword },
+	"USERS":              func(c *Config) *string { return &c.Users },
+	"MAGIC_LINK_USERS":   func(c *Config) *string { return &c.MagicLinkUsers },
+	"CLIENT_CERT_SCOPES": func(c *Config) *string { return &c.ClientCertScopes },
+}
+
+// TenantSettingName returns the name of the environment variable holding
+// setting for tenant, e.g. TENANT_SALES_ADMIN_PASSWORD
+func TenantSettingName(tenant, setting string) string {
+	return tenantSettingPrefix + strings.ToUpper(strings.ReplaceAll(tenant, "-", "_")) + "_" + setting
+}
+
+// ForTenant returns the configuration of the tenant name: a copy of c whose
+// credentials are read from TENANT_<NAME>_ADMIN_PASSWORD, _VIEWER_PASSWORD,
+// _USERS, _MAGIC_LINK_USERS and _CLIENT_CERT_SCOPES, with the passwords in
+// secrets (read from a secret store, may be nil) taking precedence. A tenant
+// never falls back to the credentials of the default tenant, so it fails
+// when the tenant has neither an admin password nor users.
+func (c *Config) ForTenant(name string, secrets map[string]string) (*Config, error) {
+	tenantCfg := *c
+	tenantCfg.tenant = name
+	for setting, field := range tenantCredentials {
+		*field(&tenantCfg) = getEnv(TenantSettingName(name, setting), "")
+	}
+	tenantCfg.ApplySecrets(secrets)
+
+	if tenantCfg.AdminPassword == "" && tenantCfg.Users == "" {
+		return nil, fmt.Errorf("tenant %q has no credentials: set %s or %s", name,
+			TenantSettingName(name, "ADMIN_PASSWORD"), TenantSettingName(name, "USERS"))
+	}
+	return &tenantCfg, nil
+}
+
+// settingName returns the name of the variable holding setting for the
+// tenant of this configuration
+func (c *Config) settingName(setting string) string {
+	if _, ok := tenantCredentials[setting]; ok && c.tenant != "" {
+		return TenantSettingName(c.tenant, setting)
+	}
+	return setting
+}
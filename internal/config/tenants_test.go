@@ -0,0 +1,56 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestForTenantUsesOwnCredentials(t *testing.T) {
+	t.Setenv("TENANT_SALES_EU_ADMIN_PASSWORD", "sales-admin")
+	t.Setenv("TENANT_SALES_EU_USERS", "alice:viewer:pw")
+	cfg := &Config{AdminPassword: "default-admin", ViewerPassword: "default-viewer", Users: "bob:admin:pw", JWTSecret: "shared"}
+
+	tenantCfg, err := cfg.ForTenant("sales-eu", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tenantCfg.AdminPassword != "sales-admin" || tenantCfg.Users != "alice:viewer:pw" {
+		t.Errorf("tenant credentials: admin %q, users %q", tenantCfg.AdminPassword, tenantCfg.Users)
+	}
+	if tenantCfg.ViewerPassword != "" {
+		t.Errorf("tenant inherited the default viewer password %q", tenantCfg.ViewerPassword)
+	}
+	if tenantCfg.JWTSecret != "shared" || cfg.AdminPassword != "default-admin" {
+		t.Error("ForTenant changed the shared settings or the default configuration")
+	}
+}
+
+func TestForTenantRequiresCredentials(t *testing.T) {
+	cfg := &Config{AdminPassword: "default-admin", Users: "bob:admin:pw"}
+	_, err := cfg.ForTenant("sales", nil)
+	if err == nil || !strings.Contains(err.Error(), "TENANT_SALES_ADMIN_PASSWORD") {
+		t.Fatalf("got %v, want an error naming TENANT_SALES_ADMIN_PASSWORD", err)
+	}
+}
+
+func TestForTenantSecrets(t *testing.T) {
+	t.Setenv("TENANT_SALES_ADMIN_PASSWORD", "from-env")
+	cfg := &Config{}
+	secrets := map[string]string{
+		"ADMIN_PASSWORD":               "default-secret",
+		"TENANT_SALES_ADMIN_PASSWORD":  "sales-secret",
+		"TENANT_SALES_VIEWER_PASSWORD": "sales-viewer",
+		"JWT_SECRET":                   "jwt",
+	}
+
+	tenantCfg, err := cfg.ForTenant("sales", secrets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tenantCfg.AdminPassword != "sales-secret" || tenantCfg.ViewerPassword != "sales-viewer" || tenantCfg.JWTSecret != "jwt" {
+		t.Errorf("got admin %q, viewer %q, jwt %q", tenantCfg.AdminPassword, tenantCfg.ViewerPassword, tenantCfg.JWTSecret)
+	}
+	if !IsSecretSetting("TENANT_SALES_ADMIN_PASSWORD") || IsSecretSetting("TENANT_SALES_USERS") {
+		t.Error("IsSecretSetting misclassifies tenant settings")
+	}
+}
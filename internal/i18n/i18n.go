@@ -0,0 +1,105 @@
+package i18n
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Lang is a supported message language
+type Lang string
+
+const (
+	English Lang = "en"
+	Chinese Lang = "zh"
+)
+
+// DefaultLang is used for API responses when the client expresses no preference
+const DefaultLang = English
+
+// serverLang is the language of messages written to the server console
+var serverLang = Chinese
+
+// SetServerLang selects the language for server console messages
+func SetServerLang(lang string) {
+	if l, ok := parseLang(lang); ok {
+		serverLang = l
+	}
+}
+
+// T returns the message for key in lang, formatted with args. Unknown
+// languages fall back to English and unknown keys are returned as-is.
+func T(lang Lang, key string, args ...interface{}) string {
+	translations, ok := messages[key]
+	if !ok {
+		return key
+	}
+
+	format, ok := translations[lang]
+	if !ok {
+		format = translations[English]
+	}
+	if len(args) == 0 {
+		return format
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// Server returns a message in the server console language
+func Server(key string, args ...interface{}) string {
+	return T(serverLang, key, args...)
+}
+
+// FromAcceptLanguage picks the best supported language from an
+// Accept-Language header, honouring quality values
+func FromAcceptLanguage(header string) Lang {
+	type candidate struct {
+		lang    Lang
+		quality float64
+	}
+
+	var candidates []candidate
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		lang, ok := parseLang(fields[0])
+		if !ok {
+			continue
+		}
+
+		quality := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					quality = q
+				}
+			}
+		}
+		if quality > 0 {
+			candidates = append(candidates, candidate{lang: lang, quality: quality})
+		}
+	}
+
+	if len(candidates) == 0 {
+		return DefaultLang
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].quality > candidates[j].quality
+	})
+	return candidates[0].lang
+}
+
+// parseLang maps a language tag such as "zh-CN" to a supported language
+func parseLang(tag string) (Lang, bool) {
+	primary := strings.ToLower(strings.SplitN(strings.TrimSpace(tag), "-", 2)[0])
+	switch primary {
+	case "en":
+		return English, true
+	case "zh":
+		return Chinese, true
+	default:
+		return "", false
+	}
+}
@@ -0,0 +1,264 @@
+package i18n
+
+// messages holds every translatable string keyed by message ID
+var messages = map[string]map[Lang]string{
+	// API errors
+	"error.invalid_request": {
+		English: "Invalid request",
+		Chinese: "无效的请求",
+	},
+	"error.validation_failed": {
+		English: "Validation failed",
+		Chinese: "请求参数校验失败",
+	},
+	"error.unauthorized": {
+		English: "Unauthorized",
+		Chinese: "未登录或登录已失效",
+	},
+	"error.rate_limited": {
+		English: "Too many requests, retry in %d seconds",
+		Chinese: "请求过于频繁，请在 %d 秒后重试",
+	},
+	"error.quota_exceeded": {
+		English: "Daily quota of %d requests exceeded",
+		Chinese: "已超出每日 %d 次的请求配额",
+	},
+	"error.country_forbidden": {
+		English: "Administration is not allowed from your location",
+		Chinese: "不允许从当前所在地区进行管理操作",
+	},
+	"error.forbidden": {
+		English: "Forbidden",
+		Chinese: "没有权限",
+	},
+	"error.step_up_required": {
+		English: "Re-enter your password to view full keys",
+		Chinese: "查看完整 Key 前请重新输入密码",
+	},
+	"error.invalid_password": {
+		English: "Invalid password",
+		Chinese: "密码错误",
+	},
+	"error.invalid_token": {
+		English: "Invalid or expired token",
+		Chinese: "Token 无效或已过期",
+	},
+	"error.setup_required": {
+		English: "Run the first-time setup (POST /api/setup) first",
+		Chinese: "请先完成初始化（POST /api/setup）",
+	},
+	"error.setup_complete": {
+		English: "Setup has already been completed",
+		Chinese: "已完成初始化",
+	},
+	"error.session_create_failed": {
+		English: "Failed to create session",
+		Chinese: "创建会话失败",
+	},
+	"error.key_not_found": {
+		English: "Key not found",
+		Chinese: "Key 不存在",
+	},
+	"error.refresh_not_found": {
+		English: "No refresh has run within the last hour",
+		Chinese: "最近一小时内没有刷新",
+	},
+	"error.raw_response_not_found": {
+		English: "No upstream response has been kept for this key",
+		Chinese: "该 Key 没有保存的上游响应",
+	},
+	"error.key_exists": {
+		English: "Key already exists",
+		Chinese: "Key 已存在",
+	},
+	"error.key_rejected": {
+		English: "Key rejected: %s",
+		Chinese: "Key 被拒绝：%s",
+	},
+	"error.key_add_failed": {
+		English: "Failed to add key",
+		Chinese: "添加 Key 失败",
+	},
+	"error.unknown_provider": {
+		English: "Unknown provider",
+		Chinese: "未知的服务提供方",
+	},
+	"error.invalid_credential": {
+		English: "Invalid credential: %s",
+		Chinese: "凭证配置无效: %s",
+	},
+	"error.upstream_failed": {
+		English: "Upstream request failed: %s",
+		Chinese: "请求上游失败: %s",
+	},
+	"error.alert_not_found": {
+		English: "Alert not found",
+		Chinese: "告警不存在",
+	},
+	"error.report_not_found": {
+		English: "Report not found",
+		Chinese: "报表不存在",
+	},
+	"error.dead_letter_not_found": {
+		English: "Key is not in the dead-letter list",
+		Chinese: "Key 不在死信列表中",
+	},
+	"error.alert_resolved": {
+		English: "Alert already resolved",
+		Chinese: "告警已恢复",
+	},
+	"error.no_channels": {
+		English: "No notification channels configured",
+		Chinese: "未配置任何通知渠道",
+	},
+	"error.idempotency_key_too_long": {
+		English: "Idempotency-Key too long",
+		Chinese: "Idempotency-Key 过长",
+	},
+	"error.idempotency_check_failed": {
+		English: "Failed to check idempotency key",
+		Chinese: "校验幂等键失败",
+	},
+	"error.idempotency_in_progress": {
+		English: "A request with this idempotency key is in progress",
+		Chinese: "使用该幂等键的请求正在处理中",
+	},
+	"error.idempotency_mismatch": {
+		English: "Idempotency key was used for a different request",
+		Chinese: "该幂等键已用于其他请求",
+	},
+	"error.auth_unavailable": {
+		English: "Tokens cannot be verified right now, try again later",
+		Chinese: "暂时无法校验 Token，请稍后重试",
+	},
+	"error.upstream_unavailable": {
+		English: "Upstream service unavailable",
+		Chinese: "上游服务不可用",
+	},
+	"error.upstream_timeout": {
+		English: "Upstream request timed out",
+		Chinese: "请求上游超时",
+	},
+	"error.internal": {
+		English: "Internal Server Error",
+		Chinese: "服务器内部错误",
+	},
+
+	// API status messages
+	"message.key_added": {
+		English: "Key added successfully",
+		Chinese: "Key 添加成功",
+	},
+
+	// Field validation
+	"field.required": {
+		English: "is required",
+		Chinese: "为必填项",
+	},
+	"field.min_items": {
+		English: "must contain at least %s items",
+		Chinese: "至少需要 %s 项",
+	},
+	"field.min_chars": {
+		English: "must be at least %s characters",
+		Chinese: "长度不能少于 %s 个字符",
+	},
+	"field.max_items": {
+		English: "must contain at most %s items",
+		Chinese: "最多只能包含 %s 项",
+	},
+	"field.max_chars": {
+		English: "must be at most %s characters",
+		Chinese: "长度不能超过 %s 个字符",
+	},
+	"field.notblank": {
+		English: "must not be blank",
+		Chinese: "不能为空白",
+	},
+	"field.keyid": {
+		English: "must be a valid key ID",
+		Chinese: "不是有效的 Key ID",
+	},
+	"field.uuid": {
+		English: "must be a valid UUID",
+		Chinese: "不是有效的 UUID",
+	},
+	"field.oneof": {
+		English: "must be one of: %s",
+		Chinese: "必须是以下值之一: %s",
+	},
+	"field.duration_min": {
+		English: "must be a duration of at least %s (e.g. 1h, 7d)",
+		Chinese: "必须是不小于 %s 的时长（例如 1h、7d）",
+	},
+	"field.duration": {
+		English: "must be a positive duration (e.g. 1m, 1h, 1d)",
+		Chinese: "必须是正的时长（例如 1m、1h、1d）",
+	},
+	"field.seconds": {
+		English: "must be a whole number of seconds (0 or more)",
+		Chinese: "必须是不小于 0 的整数秒数",
+	},
+	"field.int_range": {
+		English: "must be a whole number from %s to %s",
+		Chinese: "必须是 %s 到 %s 之间的整数",
+	},
+	"field.int_min": {
+		English: "must be a whole number of at least %s",
+		Chinese: "必须是不小于 %s 的整数",
+	},
+	"field.time_range": {
+		English: "must end after it starts",
+		Chinese: "结束时间必须晚于开始时间",
+	},
+	"field.too_many_points": {
+		English: "would produce more than %s points; use a larger interval",
+		Chinese: "数据点超过 %s 个，请增大 interval",
+	},
+	"field.key_too_short": {
+		English: "is too short for a %s key (min %s characters)",
+		Chinese: "长度不足，%s Key 至少 %s 个字符",
+	},
+	"field.key_too_long": {
+		English: "is too long for a %s key (max %s characters)",
+		Chinese: "长度超限，%s Key 最多 %s 个字符",
+	},
+	"field.key_pattern": {
+		English: "does not look like a %s key (expected %s)",
+		Chinese: "不是有效的 %s Key 格式（应匹配 %s）",
+	},
+	"field.invalid": {
+		English: "failed %s validation",
+		Chinese: "未通过 %s 校验",
+	},
+
+	// Refresh progress (server console)
+	"progress.start": {
+		English: "🚀 Processing %d API keys with %d workers, timeout %v",
+		Chinese: "🚀 开始处理 %d 个 API Keys，使用 %d 个 workers，超时时间：%v",
+	},
+	"progress.submitted": {
+		English: "✅ Submitted %d/%d tasks to the queue",
+		Chinese: "✅ 已提交 %d/%d 个任务到队列",
+	},
+	"progress.attached": {
+		English: "🔗 %d keys are already being fetched, waiting for those results",
+		Chinese: "🔗 %d 个 Key 已在队列中或正在查询，等待已有结果",
+	},
+	"progress.received": {
+		English: "📊 Progress: %d/%d (%.1f%%) | Rate: %.1f keys/s",
+		Chinese: "📊 进度: %d/%d (%.1f%%) | 速度: %.1f keys/s",
+	},
+	"progress.tick": {
+		English: "⏱️  Processing: %d/%d (%.1f%%) | Rate: %.1f keys/s | Elapsed: %v",
+		Chinese: "⏱️  处理中: %d/%d (%.1f%%) | 速度: %.1f keys/s | 耗时: %v",
+	},
+	"progress.timeout": {
+		English: "⚠️  Timed out! Received %d/%d results",
+		Chinese: "⚠️  超时! 已收到 %d/%d 个结果",
+	},
+	"progress.done": {
+		English: "🎉 Done! Total: %d | Received: %d | Elapsed: %v | Average rate: %.1f keys/s",
+		Chinese: "🎉 处理完成! 总计: %d 个 | 成功: %d 个 | 耗时: %v | 平均速度: %.1f keys/s",
+	},
+}
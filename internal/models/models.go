@@ -2,101 +2,524 @@ package models
 
 import "time"
 
-// APIKey represents a stored API key
-type APIKey struct {
-	ID        string    `json:"id"`
-	Key       string    `json:"key"`
-	Name      string    `json:"name"`
-	CreatedAt time.Time `json:"created_at"`
+// Credential describes how a key is sent upstream: as a bearer token
+// (default), basic auth password, custom header or query parameter
+type Credential struct {
+	Type     string            `json:"type" validate:"required,oneof=bearer basic header query"`
+	Username string            `json:"username,omitempty" validate:"max=256"`
+	Name     string            `json:"name,omitempty" validate:"max=128"`
+	Headers  map[string]string `json:"headers,omitempty" validate:"max=20"`
 }
 
 // APIKeyMasked represents an API key with masked value for display
 type APIKeyMasked struct {
-	ID        string    `json:"id"`
-	Name      string    `json:"name"`
-	Masked    string    `json:"masked"`
-	CreatedAt time.Time `json:"created_at"`
+	ID              string     `json:"id"`
+	Name            string     `json:"name"`
+	Provider        string     `json:"provider"`
+	CredentialType  string     `json:"credential_type"`
+	Group           string     `json:"group,omitempty"`
+	Tags            []string   `json:"tags,omitempty"`
+	Owner           string     `json:"owner,omitempty"`
+	Notes           string     `json:"notes,omitempty"`
+	RefreshInterval string     `json:"refresh_interval,omitempty"`
+	Masked          string     `json:"masked"`
+	CreatedAt       time.Time  `json:"created_at"`
+	ExpiresAt       *time.Time `json:"expires_at,omitempty"`
+	Expired         bool       `json:"expired"`
+	Enabled         bool       `json:"enabled"`
+	DisabledAt      *time.Time `json:"disabled_at,omitempty"`
+	DisabledReason  string     `json:"disabled_reason,omitempty"`
+	AutoDisabled    bool       `json:"auto_disabled,omitempty"`
 }
 
-// Usage represents API key usage information
+// Usage represents API key usage information as returned by the API; the
+// services convert it to and from storage.Usage, which holds the fields
+// that are cached
 type Usage struct {
-	ID               string    `json:"id"`
-	Key              string    `json:"key,omitempty"`
-	StartDate        string    `json:"start_date"`
-	EndDate          string    `json:"end_date"`
-	TotalAllowance   float64   `json:"total_allowance"`
-	OrgTotalUsed     float64   `json:"org_total_tokens_used"`
-	Remaining        float64   `json:"remaining"`
-	UsedRatio        float64   `json:"used_ratio"`
-	LastUpdated      time.Time `json:"last_updated"`
-	Error            string    `json:"error,omitempty"`
-}
-
-// FactoryAPIResponse represents the response from Factory.ai API
-type FactoryAPIResponse struct {
-	Usage struct {
-		StartDate int64 `json:"startDate"`
-		EndDate   int64 `json:"endDate"`
-		Standard  struct {
-			OrgTotalTokensUsed float64 `json:"orgTotalTokensUsed"`
-			TotalAllowance     float64 `json:"totalAllowance"`
-			UsedRatio          float64 `json:"usedRatio"`
-		} `json:"standard"`
-	} `json:"usage"`
+	ID             string     `json:"id"`
+	Key            string     `json:"key,omitempty"`
+	Name           string     `json:"name,omitempty"`
+	StartDate      string     `json:"start_date"`
+	EndDate        string     `json:"end_date"`
+	TotalAllowance float64    `json:"total_allowance"`
+	OrgTotalUsed   float64    `json:"org_total_tokens_used"`
+	Remaining      float64    `json:"remaining"`
+	UsedRatio      float64    `json:"used_ratio"`
+	LastUpdated    time.Time  `json:"last_updated"`
+	LatencyMs      int64      `json:"latency_ms,omitempty"`
+	ExpiresAt      *time.Time `json:"expires_at,omitempty"`
+
+	// Changes derived from the usage history; omitted when there is no
+	// snapshot old enough to compare against
+	UsedDelta1h     *float64 `json:"used_delta_1h,omitempty"`
+	UsedDelta24h    *float64 `json:"used_delta_24h,omitempty"`
+	RemainingChange *float64 `json:"remaining_change_since_last_refresh,omitempty"`
+
+	Disabled  bool   `json:"disabled,omitempty"`
+	Error     string `json:"error,omitempty"`
+	ErrorCode string `json:"error_code,omitempty"`
+	// ErrorDetail is the reason given by the provider, when it gave one
+	ErrorDetail string `json:"error_detail,omitempty"`
+
+	// ExcludedReason is the key status ("error", "disabled", "expired" or
+	// "exhausted") when the key is left out of the healthy totals
+	ExcludedReason string `json:"excluded_reason,omitempty"`
+
+	// OrgID identifies the organization detected for the key; keys with the
+	// same OrgID share one allowance
+	OrgID string `json:"org_id,omitempty"`
+
+	// Computed holds the values of the computed fields (COMPUTED_FIELDS);
+	// null when an expression has no value for this key
+	Computed map[string]interface{} `json:"computed,omitempty"`
+
+	// Blocks holds the usage the provider reports besides the standard
+	// allowance, by the name it uses, e.g. "premium" or "overage"
+	Blocks map[string]*UsageBlock `json:"blocks,omitempty"`
+
+	// Partial is set when the provider response lacked some of the standard
+	// figures, which are then left at zero; MissingFields names them
+	Partial       bool     `json:"partial,omitempty"`
+	MissingFields []string `json:"missing_fields,omitempty"`
+
+	// Raw is the provider response the usage was read from, kept apart
+	// from the usage when UPSTREAM_RAW_MAX_BYTES is set
+	Raw *RawResponse `json:"-"`
+}
+
+// UsageBlock is one tier or breakdown of a key's usage, such as premium
+// tokens, overage or the usage of one model
+type UsageBlock struct {
+	Used      float64 `json:"used"`
+	Allowance float64 `json:"allowance,omitempty"`
+	UsedRatio float64 `json:"used_ratio,omitempty"`
+	// Other holds the numeric fields that are not recognized
+	Other map[string]float64 `json:"other,omitempty"`
+	// Breakdown splits the block further, e.g. by model
+	Breakdown map[string]*UsageBlock `json:"breakdown,omitempty"`
+}
+
+// RawResponse is a provider response kept to diagnose changes in its
+// format; Body is masked and cut to UPSTREAM_RAW_MAX_BYTES
+type RawResponse struct {
+	Status int `json:"status"`
+	// Size is the length of the body as read, before it was cut
+	Size      int       `json:"size"`
+	Truncated bool      `json:"truncated,omitempty"`
+	Body      string    `json:"body"`
+	FetchedAt time.Time `json:"fetched_at"`
 }
 
 // AggregatedData represents the aggregated usage data
 type AggregatedData struct {
-	UpdateTime  string   `json:"update_time"`
-	TotalCount  int      `json:"total_count"`
-	Totals      Totals   `json:"totals"`
-	Data        []*Usage `json:"data"`
+	UpdateTime string          `json:"update_time"`
+	TotalCount int             `json:"total_count"`
+	Totals     Totals          `json:"totals"`
+	Groups     []*GroupSummary `json:"groups,omitempty"`
+	Data       []*Usage        `json:"data"`
+
+	// Offset and Limit select the page of keys in Data when the request
+	// was paged; TotalCount and Totals still cover every key
+	Offset int `json:"offset,omitempty"`
+	Limit  int `json:"limit,omitempty"`
+
+	// Degraded is set when Redis is unavailable and the data comes from
+	// this replica's local cache
+	Degraded bool `json:"degraded,omitempty"`
+}
+
+// GroupSummary holds the totals of one key group; usage is summed over the
+// keys of the group that were fetched successfully
+type GroupSummary struct {
+	Name           string  `json:"name"`
+	KeyCount       int     `json:"key_count"`
+	SuccessCount   int     `json:"success_count"`
+	TotalAllowance float64 `json:"total_allowance"`
+	TotalUsed      float64 `json:"total_used"`
+	Remaining      float64 `json:"remaining"`
+}
+
+// OrgSummary is a detected organization: the allowance and usage its keys
+// share, counted once, and the keys that belong to it
+type OrgSummary struct {
+	ID             string       `json:"id"`
+	Provider       string       `json:"provider"`
+	StartDate      string       `json:"start_date"`
+	EndDate        string       `json:"end_date"`
+	TotalAllowance float64      `json:"total_allowance"`
+	TotalUsed      float64      `json:"total_used"`
+	Remaining      float64      `json:"remaining"`
+	UsedRatio      float64      `json:"used_ratio"`
+	KeyCount       int          `json:"key_count"`
+	Keys           []*OrgMember `json:"keys"`
+}
+
+// OrgMember is a key of an organization
+type OrgMember struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Key  string `json:"key"`
+}
+
+// ChartData represents downsampled usage history for one key
+type ChartData struct {
+	ID       string       `json:"id"`
+	Interval string       `json:"interval"`
+	Range    string       `json:"range"`
+	From     time.Time    `json:"from"`
+	To       time.Time    `json:"to"`
+	Points   []ChartPoint `json:"points"`
+}
+
+// ChartPoint is the last usage snapshot within one time bucket
+type ChartPoint struct {
+	Time           time.Time `json:"time"`
+	TotalAllowance float64   `json:"total_allowance"`
+	Used           float64   `json:"used"`
+	Remaining      float64   `json:"remaining"`
+	UsedRatio      float64   `json:"used_ratio"`
+	Samples        int       `json:"samples"`
+}
+
+// HistoryRow is one exported usage snapshot; every field is always
+// present so the rows load into a fixed table schema
+type HistoryRow struct {
+	Time           time.Time `json:"time"`
+	KeyID          string    `json:"key_id"`
+	KeyName        string    `json:"key_name"`
+	Group          string    `json:"group"`
+	Provider       string    `json:"provider"`
+	StartDate      string    `json:"start_date"`
+	EndDate        string    `json:"end_date"`
+	TotalAllowance float64   `json:"total_allowance"`
+	Used           float64   `json:"used"`
+	Remaining      float64   `json:"remaining"`
+	UsedRatio      float64   `json:"used_ratio"`
+	LatencyMs      int64     `json:"latency_ms"`
+	Error          string    `json:"error"`
+}
+
+// GrafanaQueryRequest is a query from the Grafana JSON datasource
+type GrafanaQueryRequest struct {
+	Range struct {
+		From time.Time `json:"from"`
+		To   time.Time `json:"to"`
+	} `json:"range"`
+	IntervalMs    int64                `json:"intervalMs"`
+	MaxDataPoints int                  `json:"maxDataPoints"`
+	Targets       []GrafanaQueryTarget `json:"targets"`
+}
+
+// GrafanaQueryTarget is one target of a Grafana query; Type is
+// "timeserie" (default) or "table"
+type GrafanaQueryTarget struct {
+	Target string `json:"target"`
+	RefID  string `json:"refId"`
+	Type   string `json:"type"`
+	Hide   bool   `json:"hide"`
+}
+
+// GrafanaSearchRequest asks the Grafana JSON datasource for target names
+type GrafanaSearchRequest struct {
+	Target string `json:"target"`
+}
+
+// GrafanaTarget is a target offered to Grafana
+type GrafanaTarget struct {
+	Text  string `json:"text"`
+	Value string `json:"value"`
+}
+
+// GrafanaSeries is a time series answer; each datapoint is
+// [value, unix milliseconds]
+type GrafanaSeries struct {
+	Target     string       `json:"target"`
+	Datapoints [][2]float64 `json:"datapoints"`
+}
+
+// GrafanaTable is a table answer
+type GrafanaTable struct {
+	Type    string          `json:"type"`
+	Columns []GrafanaColumn `json:"columns"`
+	Rows    [][]interface{} `json:"rows"`
+}
+
+// GrafanaColumn describes a column of a table answer
+type GrafanaColumn struct {
+	Text string `json:"text"`
+	Type string `json:"type"`
+}
+
+// PeriodComparison compares usage in the current period with the previous one
+type PeriodComparison struct {
+	Period   string                 `json:"period"`
+	Current  PeriodRange            `json:"current"`
+	Previous PeriodRange            `json:"previous"`
+	Overall  PeriodDelta            `json:"overall"`
+	Keys     []*KeyPeriodComparison `json:"keys"`
+}
+
+// PeriodRange is the time span of a compared period
+type PeriodRange struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+// PeriodDelta holds the usage of two periods; ChangeRatio is omitted when
+// there was no usage in the previous period
+type PeriodDelta struct {
+	Current     float64  `json:"current"`
+	Previous    float64  `json:"previous"`
+	Change      float64  `json:"change"`
+	ChangeRatio *float64 `json:"change_ratio,omitempty"`
+}
+
+// KeyPeriodComparison is the period comparison for a single key
+type KeyPeriodComparison struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	PeriodDelta
 }
 
 // Totals represents the total usage statistics
 type Totals struct {
 	TotalOrgTotalTokensUsed float64 `json:"total_orgTotalTokensUsed"`
 	TotalAllowance          float64 `json:"total_totalAllowance"`
-}
 
-// Session represents a user session
-type Session struct {
-	ID        string    `json:"id"`
-	CreatedAt time.Time `json:"created_at"`
-	ExpiresAt time.Time `json:"expires_at"`
+	// Key counts; exhausted keys are also counted as successful
+	SuccessCount   int `json:"success_count"`
+	ErrorCount     int `json:"error_count"`
+	ExhaustedCount int `json:"exhausted_count"`
+	DisabledCount  int `json:"disabled_count"`
+	ExpiredCount   int `json:"expired_count"`
+
+	// Totals over healthy keys only (fetched successfully and not exhausted)
+	HealthyAllowance float64 `json:"healthy_total_allowance"`
+	HealthyUsed      float64 `json:"healthy_total_used"`
+	HealthyRemaining float64 `json:"healthy_remaining"`
+
+	// Totals over organizations: keys of the same organization all report
+	// the organization's usage, so it is counted once per organization
+	// instead of once per key
+	OrgCount     int     `json:"org_count"`
+	OrgAllowance float64 `json:"org_total_allowance"`
+	OrgUsed      float64 `json:"org_total_used"`
+	OrgRemaining float64 `json:"org_remaining"`
 }
 
 // LoginRequest represents login credentials
 type LoginRequest struct {
-	Password string `json:"password"`
+	Username string `json:"username" validate:"max=64"`
+	Password string `json:"password" validate:"max=256"`
+	// Remember keeps the session for SESSION_TTL; otherwise it ends with
+	// the browser session or after SESSION_SHORT_TTL
+	Remember bool `json:"remember"`
+}
+
+// MagicLinkRequest asks for a login link to be emailed
+type MagicLinkRequest struct {
+	Email string `json:"email" validate:"required,email,max=254"`
+}
+
+// SetupStatus tells whether a fresh install still needs its first-run setup
+type SetupStatus struct {
+	Required bool `json:"required"`
+	// PasswordSource is where the admin password comes from: "env"
+	// (ADMIN_PASSWORD), "setup", or "disabled" when auth is turned off
+	PasswordSource string     `json:"password_source,omitempty"`
+	CompletedAt    *time.Time `json:"completed_at,omitempty"`
+}
+
+// SetupRequest sets the admin password of a fresh install, optionally with
+// initial runtime settings
+type SetupRequest struct {
+	Password string          `json:"password" validate:"required,min=8,max=256"`
+	Settings *SettingsUpdate `json:"settings"`
+	Remember bool            `json:"remember"`
+}
+
+// MagicLinkLoginRequest exchanges the token of a login link for a session
+type MagicLinkLoginRequest struct {
+	Token    string `json:"token" validate:"required,max=4096"`
+	Remember bool   `json:"remember"`
+}
+
+// TokenRequest exchanges credentials for bearer tokens; Scope optionally
+// limits them, e.g. "read" for a read-only integration
+type TokenRequest struct {
+	Username string `json:"username" validate:"max=64"`
+	Password string `json:"password" validate:"max=256"`
+	Scope    string `json:"scope" validate:"max=256"`
+}
+
+// RefreshTokenRequest carries a refresh token, or any token to revoke
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token" validate:"required,max=4096"`
+}
+
+// TokenResponse is an OAuth2-style bearer token pair
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	Scope        string `json:"scope,omitempty"`
+}
+
+// StepUpRequest re-confirms the admin password before a sensitive operation
+type StepUpRequest struct {
+	Password string `json:"password" validate:"max=256"`
 }
 
 // ImportRequest represents batch import request
 type ImportRequest struct {
-	Keys []string `json:"keys"`
+	Keys        []string     `json:"keys" validate:"omitempty,dive,max=512"`
+	Items       []ImportItem `json:"items" validate:"omitempty,dive"`
+	OnDuplicate string       `json:"on_duplicate" validate:"omitempty,oneof=skip update_name restore"`
+	Owner       string       `json:"owner" validate:"max=64"`
+}
+
+// How an import treats keys that are already stored or were deleted
+const (
+	OnDuplicateSkip       = "skip"
+	OnDuplicateUpdateName = "update_name"
+	OnDuplicateRestore    = "restore"
+)
+
+// ImportItem is an imported key with optional metadata
+type ImportItem struct {
+	Key   string   `json:"key" validate:"required,notblank,max=512"`
+	Name  string   `json:"name" validate:"max=100"`
+	Group string   `json:"group" validate:"max=64"`
+	Tags  []string `json:"tags" validate:"max=20,dive,required,max=32"`
+	Notes string   `json:"notes" validate:"max=1000"`
 }
 
 // ImportResult represents batch import result
 type ImportResult struct {
-	Success    int `json:"success"`
-	Failed     int `json:"failed"`
-	Duplicates int `json:"duplicates"`
+	Success      int               `json:"success"`
+	Failed       int               `json:"failed"`
+	Duplicates   int               `json:"duplicates"`
+	Updated      int               `json:"updated"`
+	Restored     int               `json:"restored"`
+	Chunks       int               `json:"chunks"`
+	FailedChunks int               `json:"failed_chunks"`
+	Results      []BatchItemResult `json:"results"`
+}
+
+// AddKeyRequest represents a single key creation request
+type AddKeyRequest struct {
+	Key        string      `json:"key" validate:"required,notblank,max=512"`
+	Name       string      `json:"name" validate:"max=100"`
+	Provider   string      `json:"provider" validate:"max=32"`
+	Credential *Credential `json:"credential"`
+	Group      string      `json:"group" validate:"max=64"`
+	Tags       []string    `json:"tags" validate:"max=20,dive,required,max=32"`
+	ExpiresAt  *time.Time  `json:"expires_at"`
+	Owner      string      `json:"owner" validate:"max=64"`
+	Notes      string      `json:"notes" validate:"max=1000"`
+	// RefreshInterval overrides the cache TTL of the key's group, e.g. "1m"
+	RefreshInterval string `json:"refresh_interval" validate:"omitempty,duration"`
+}
+
+// ExportKeysRequest filters the keys returned by the full-key export
+type ExportKeysRequest struct {
+	Format       string   `json:"format" validate:"omitempty,oneof=txt csv json"`
+	Status       []string `json:"status" validate:"dive,oneof=active exhausted error expired disabled"`
+	Group        string   `json:"group" validate:"max=64"`
+	Tag          string   `json:"tag" validate:"max=32"`
+	MinRemaining *float64 `json:"min_remaining"`
+	MaxRemaining *float64 `json:"max_remaining"`
+}
+
+// ExportedKey is a full key with its latest status, as exported
+type ExportedKey struct {
+	ID        string   `json:"id"`
+	Name      string   `json:"name"`
+	Key       string   `json:"key"`
+	Group     string   `json:"group,omitempty"`
+	Tags      []string `json:"tags,omitempty"`
+	Status    string   `json:"status"`
+	Remaining float64  `json:"remaining"`
+}
+
+// TestKeyRequest represents a live check of a key that is not stored
+type TestKeyRequest struct {
+	Key        string      `json:"key" validate:"required,notblank,max=512"`
+	Provider   string      `json:"provider" validate:"max=32"`
+	Credential *Credential `json:"credential"`
+}
+
+// UpdateKeyRequest represents a partial key metadata update
+type UpdateKeyRequest struct {
+	Name        *string     `json:"name" validate:"omitempty,max=100"`
+	Provider    *string     `json:"provider" validate:"omitempty,max=32"`
+	Credential  *Credential `json:"credential"`
+	Group       *string     `json:"group" validate:"omitempty,max=64"`
+	Tags        []string    `json:"tags" validate:"max=20,dive,required,max=32"`
+	ExpiresAt   *time.Time  `json:"expires_at"`
+	ClearExpiry bool        `json:"clear_expiry"`
+	Owner       *string     `json:"owner" validate:"omitempty,max=64"`
+	Notes       *string     `json:"notes" validate:"omitempty,max=1000"`
+	// An empty RefreshInterval clears the key's own interval
+	RefreshInterval *string `json:"refresh_interval" validate:"omitempty,duration"`
+}
+
+// DisableKeyRequest represents an optional reason for disabling a key
+type DisableKeyRequest struct {
+	Reason string `json:"reason" validate:"max=256"`
 }
 
 // BatchDeleteRequest represents batch delete request
 type BatchDeleteRequest struct {
-	IDs []string `json:"ids"`
+	IDs []string `json:"ids" validate:"required,min=1,dive,keyid"`
 }
 
 // BatchDeleteResult represents batch delete result
 type BatchDeleteResult struct {
-	Success int `json:"success"`
-	Failed  int `json:"failed"`
+	Success      int               `json:"success"`
+	NotFound     int               `json:"not_found"`
+	Failed       int               `json:"failed"`
+	Chunks       int               `json:"chunks"`
+	FailedChunks int               `json:"failed_chunks"`
+	Results      []BatchItemResult `json:"results"`
+}
+
+// Outcomes of a single item of a batch request
+const (
+	BatchSucceeded = "succeeded"
+	BatchDuplicate = "duplicate"
+	BatchUpdated   = "updated"
+	BatchRestored  = "restored"
+	BatchNotFound  = "not_found"
+	BatchFailed    = "failed"
+)
+
+// BatchItemResult reports the outcome of one item of a batch request; Index
+// is its position in the request (imports number keys before items) and Key
+// is masked
+type BatchItemResult struct {
+	Index  int    `json:"index"`
+	ID     string `json:"id,omitempty"`
+	Key    string `json:"key,omitempty"`
+	Status string `json:"status"`
+	Reason string `json:"reason,omitempty"`
 }
 
 // ErrorResponse represents an error response
 type ErrorResponse struct {
-	Error string `json:"error"`
+	Error     string                 `json:"error"`
+	Code      string                 `json:"code,omitempty"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+	Fields    []FieldError           `json:"fields,omitempty"`
+	RequestID string                 `json:"request_id,omitempty"`
+}
+
+// FieldError describes why a single request field failed validation
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
 }
 
 // SuccessResponse represents a success response
@@ -104,3 +527,241 @@ type SuccessResponse struct {
 	Success bool   `json:"success"`
 	Message string `json:"message,omitempty"`
 }
+
+// PruneResult represents the outcome of a retention pruning run
+type PruneResult struct {
+	Removed map[string]int64  `json:"removed"`
+	Total   int64             `json:"total"`
+	Errors  map[string]string `json:"errors,omitempty"`
+}
+
+// Stats represents precomputed aggregate statistics over all keys
+type Stats struct {
+	GeneratedAt         time.Time               `json:"generated_at"`
+	TotalKeys           int                     `json:"total_keys"`
+	ByStatus            map[string]int          `json:"by_status"`
+	ByGroup             map[string]*StatsTotals `json:"by_group"`
+	ByTag               map[string]*StatsTotals `json:"by_tag"`
+	ByProvider          map[string]*StatsTotals `json:"by_provider"`
+	UsedRatioBuckets    []RatioBucket           `json:"used_ratio_buckets"`
+	ExhaustedThisPeriod int                     `json:"exhausted_this_period"`
+	AvgRefreshLatencyMs float64                 `json:"avg_refresh_latency_ms"`
+
+	// Upstream is measured by the replica serving the request
+	Upstream map[string]*UpstreamStats `json:"upstream,omitempty"`
+}
+
+// UpstreamStats describes the recent fetches from one provider
+type UpstreamStats struct {
+	Samples      int     `json:"samples"`
+	SuccessRate  float64 `json:"success_rate"`
+	P50LatencyMs int64   `json:"p50_latency_ms"`
+	P95LatencyMs int64   `json:"p95_latency_ms"`
+	Degraded     bool    `json:"degraded"`
+	// Connections is omitted until the provider has been fetched over HTTP
+	Connections *ConnectionStats `json:"connections,omitempty"`
+}
+
+// ConnectionStats counts the requests sent to one provider by whether they
+// opened a new connection or reused an idle or multiplexed one, and how many
+// were answered over HTTP/2
+type ConnectionStats struct {
+	Requests   int64   `json:"requests"`
+	New        int64   `json:"new"`
+	Reused     int64   `json:"reused"`
+	ReuseRatio float64 `json:"reuse_ratio"`
+	HTTP2      int64   `json:"http2"`
+}
+
+// CacheStats describes how the usage caches of the replica serving the
+// request have been used since it started or the caches were last flushed
+type CacheStats struct {
+	Since      time.Time       `json:"since"`
+	TTLSeconds int             `json:"ttl_seconds"`
+	Local      CacheLayerStats `json:"local"`
+	Redis      CacheLayerStats `json:"redis"`
+	// Fresh counts usage served from either cache, Stale usage that was
+	// cached but older than its TTL and Missing usage that was not cached;
+	// HitRatio is Fresh over all three
+	Fresh    int64   `json:"fresh"`
+	Stale    int64   `json:"stale"`
+	Missing  int64   `json:"missing"`
+	HitRatio float64 `json:"hit_ratio"`
+}
+
+// CacheLayerStats counts the lookups in one cache layer
+type CacheLayerStats struct {
+	Hits     int64   `json:"hits"`
+	Misses   int64   `json:"misses"`
+	Errors   int64   `json:"errors,omitempty"`
+	Entries  int     `json:"entries,omitempty"`
+	HitRatio float64 `json:"hit_ratio"`
+}
+
+// CacheFlushResult reports what flushing the usage caches removed
+type CacheFlushResult struct {
+	Success bool `json:"success"`
+	Local   int  `json:"local"`
+	Redis   int  `json:"redis"`
+}
+
+// SlowKey is a key whose usage fetches were slow or ran into the task
+// deadline
+type SlowKey struct {
+	ID            string    `json:"id"`
+	Name          string    `json:"name"`
+	Provider      string    `json:"provider"`
+	SlowCount     int64     `json:"slow_count"`
+	Timeouts      int64     `json:"timeouts"`
+	MaxLatencyMs  int64     `json:"max_latency_ms"`
+	LastLatencyMs int64     `json:"last_latency_ms"`
+	LastSeen      time.Time `json:"last_seen"`
+}
+
+// DeadLetter is a key whose fetches kept failing after every retry
+type DeadLetter struct {
+	ID            string    `json:"id"`
+	Name          string    `json:"name"`
+	Attempts      int       `json:"attempts"`
+	ErrorCode     string    `json:"error_code"`
+	LastError     string    `json:"last_error"`
+	FirstFailedAt time.Time `json:"first_failed_at"`
+	DeadAt        time.Time `json:"dead_at"`
+}
+
+// Readiness is the result of the readiness probe
+type Readiness struct {
+	Status            string                    `json:"status"`
+	Time              string                    `json:"time"`
+	Storage           string                    `json:"storage"`
+	Upstream          map[string]*UpstreamStats `json:"upstream"`
+	DegradedProviders []string                  `json:"degraded_providers,omitempty"`
+}
+
+// UsageReport is the content of a generated usage report
+type UsageReport struct {
+	ID          string         `json:"id"`
+	GeneratedAt time.Time      `json:"generated_at"`
+	TotalKeys   int            `json:"total_keys"`
+	ByStatus    map[string]int `json:"by_status"`
+	Totals      StatsTotals    `json:"totals"`
+	ByGroup     []ReportRow    `json:"by_group"`
+	ByProvider  []ReportRow    `json:"by_provider"`
+}
+
+// ReportRow holds the totals of one group or provider in a report
+type ReportRow struct {
+	Name string `json:"name"`
+	StatsTotals
+	UsedRatio float64 `json:"used_ratio"`
+}
+
+// TenantSummary describes one tenant in the cross-tenant admin view
+type TenantSummary struct {
+	Name     string `json:"name"`
+	BasePath string `json:"base_path"`
+	Stats    *Stats `json:"stats,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// FederationSummary is the aggregated view of one instance that its
+// federation peers pull; it holds no keys
+type FederationSummary struct {
+	Instance string       `json:"instance"`
+	Totals   *StatsTotals `json:"totals"`
+	Stats    *Stats       `json:"stats"`
+}
+
+// FederationInstance describes one instance of the combined dashboard and
+// the summary last pulled from it, kept when a later pull fails
+type FederationInstance struct {
+	Name      string             `json:"name"`
+	URL       string             `json:"url,omitempty"`
+	Local     bool               `json:"local,omitempty"`
+	FetchedAt *time.Time         `json:"fetched_at,omitempty"`
+	Error     string             `json:"error,omitempty"`
+	Summary   *FederationSummary `json:"summary,omitempty"`
+}
+
+// FederationOverview combines the summaries of this instance and its peers
+type FederationOverview struct {
+	Totals    *StatsTotals          `json:"totals"`
+	Stats     *Stats                `json:"stats"`
+	Instances []*FederationInstance `json:"instances"`
+}
+
+// StatsTotals represents usage totals for one slice of keys
+type StatsTotals struct {
+	Keys           int     `json:"keys"`
+	TotalAllowance float64 `json:"total_allowance"`
+	TotalUsed      float64 `json:"total_used"`
+	Remaining      float64 `json:"remaining"`
+}
+
+// RatioBucket counts keys whose used ratio falls in a range
+type RatioBucket struct {
+	Range string `json:"range"`
+	Count int    `json:"count"`
+}
+
+// DeliveryResult represents the outcome of delivering to one notification channel
+type DeliveryResult struct {
+	Channel string `json:"channel"`
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Settings holds the effective runtime-tunable settings
+type Settings struct {
+	CacheTTLSeconds     int     `json:"cache_ttl_seconds"`
+	AlertUsageThreshold float64 `json:"alert_usage_threshold"`
+	AutoDisableFailures int     `json:"auto_disable_failures"`
+	MaskPrefixChars     int     `json:"mask_prefix_chars"`
+	MaskSuffixChars     int     `json:"mask_suffix_chars"`
+	NotifyWebhookURL    string  `json:"notify_webhook_url"`
+	NotifyWebhookSecret string  `json:"-"`
+	NotifyQuietHours    string  `json:"notify_quiet_hours"`
+	ComputedFields      string  `json:"computed_fields"`
+
+	NotifyChannels []NotificationChannel `json:"notify_channels"`
+}
+
+// NotificationChannel configures a notification channel besides the
+// webhook; Type selects the notifier and Options holds settings specific
+// to it
+type NotificationChannel struct {
+	Name       string            `json:"name" validate:"required,max=64"`
+	Type       string            `json:"type" validate:"required,max=32"`
+	URL        string            `json:"url,omitempty" validate:"omitempty,max=2048"`
+	Secret     string            `json:"secret,omitempty" validate:"omitempty,max=256"`
+	QuietHours string            `json:"quiet_hours,omitempty" validate:"omitempty,max=16"`
+	Options    map[string]string `json:"options,omitempty" validate:"omitempty,max=32,dive,keys,max=64,endkeys,max=1024"`
+
+	// SecretSet is reported instead of the secret, which is never returned;
+	// a channel submitted with it and without a secret keeps its secret
+	SecretSet bool `json:"secret_set,omitempty"`
+}
+
+// SettingsResponse represents the effective settings and which of them are
+// overridden from the environment defaults
+type SettingsResponse struct {
+	Settings
+	WebhookSecretSet bool     `json:"notify_webhook_secret_set"`
+	Overridden       []string `json:"overridden"`
+}
+
+// SettingsUpdate represents a partial settings override; omitted fields keep
+// their current value
+type SettingsUpdate struct {
+	CacheTTLSeconds     *int     `json:"cache_ttl_seconds,omitempty" validate:"omitempty,min=10,max=86400"`
+	AlertUsageThreshold *float64 `json:"alert_usage_threshold,omitempty" validate:"omitempty,min=0,max=1"`
+	AutoDisableFailures *int     `json:"auto_disable_failures,omitempty" validate:"omitempty,min=0,max=1000"`
+	MaskPrefixChars     *int     `json:"mask_prefix_chars,omitempty" validate:"omitempty,min=0,max=32"`
+	MaskSuffixChars     *int     `json:"mask_suffix_chars,omitempty" validate:"omitempty,min=0,max=32"`
+	NotifyWebhookURL    *string  `json:"notify_webhook_url,omitempty" validate:"omitempty,max=2048"`
+	NotifyWebhookSecret *string  `json:"notify_webhook_secret,omitempty" validate:"omitempty,max=256"`
+	NotifyQuietHours    *string  `json:"notify_quiet_hours,omitempty" validate:"omitempty,max=16"`
+	ComputedFields      *string  `json:"computed_fields,omitempty" validate:"omitempty,max=4096"`
+
+	NotifyChannels *[]NotificationChannel `json:"notify_channels,omitempty" validate:"omitempty,max=20,dive"`
+}
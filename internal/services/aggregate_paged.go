@@ -0,0 +1,132 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/droid-keyusage-go/internal/models"
+	"github.com/droid-keyusage-go/internal/storage"
+)
+
+// MaxPageSize bounds the keys returned by one page of GetAggregatedPage
+const MaxPageSize = 1000
+
+// GetAggregatedPage is GetAggregatedDataMaxAge returning only the results
+// of the keys at [offset, offset+limit) among those p may see, for key sets
+// too large to hold in memory at once. Stale keys are fetched in chunks
+// whose results go straight to storage; the totals and the page are then
+// built by reading the usage back one key at a time, so only one chunk, the
+// page and the failed fetches are held. Deltas and computed fields are
+// attached to the page only, and refresh hooks and statistics are left to
+// the full aggregation.
+func (s *APIKeyService) GetAggregatedPage(ctx context.Context, p Principal, maxAge time.Duration, offset, limit int) (*models.AggregatedData, error) {
+	keys, degraded, err := s.loadKeys(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get API keys: %w", err)
+	}
+
+	policy := s.cachePolicy(maxAge)
+	keys = s.visibleKeys(s.sortKeys(keys), p)
+	now := time.Now()
+
+	// Only the keys to fetch are kept from the cache lookups
+	var staleKeys []*storage.APIKey
+	for _, key := range keys {
+		if s.cachedResult(ctx, key, policy, maxAge, degraded, now) == nil {
+			staleKeys = append(staleKeys, key)
+		}
+	}
+
+	// Failed fetches are not stored, so they are kept for the second pass
+	failed := make(map[string]*models.Usage)
+	if len(staleKeys) > 0 {
+		refreshStart := time.Now()
+		tally := &jobTally{}
+		err = s.refreshChunked(ctx, staleKeys, policy, degraded, func(results []*models.Usage) {
+			tally.add(results)
+			for _, usage := range results {
+				if usage.Error != "" {
+					failed[usage.ID] = usage
+				}
+			}
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to process keys: %w", err)
+		}
+		s.metrics.Timing("refresh.duration", time.Since(refreshStart))
+		s.metrics.Count("refresh.keys", int64(len(staleKeys)))
+		if !degraded {
+			s.recordJob(ctx, refreshStart, time.Now(), len(keys), len(staleKeys), tally)
+		}
+	}
+
+	totals := newTotalsBuilder(now)
+	groups := newGroupsBuilder(now)
+	page := make([]*models.Usage, 0, min(limit, MaxPageSize))
+	for i, key := range keys {
+		usage := s.storedResult(ctx, key, failed[key.ID], degraded, now)
+		usage.ErrorCode = usageErrorCode(usage)
+		if failed[key.ID] != nil && usage.ErrorCode != "" {
+			s.metrics.Count("upstream.errors", 1, "error_code:"+usage.ErrorCode, "provider:"+providerName(key))
+		}
+		totals.add(key, usage)
+		groups.add(key, usage)
+		if i >= offset && i < offset+limit {
+			page = append(page, usage)
+		}
+	}
+
+	if !degraded {
+		s.attachDeltas(page, now)
+		if len(staleKeys) > 0 {
+			refreshedIDs := make([]string, len(staleKeys))
+			for i, key := range staleKeys {
+				refreshedIDs[i] = key.ID
+			}
+			s.events.Publish(EventRefreshCompleted, refreshedIDs, map[string]interface{}{
+				"total_keys": len(keys),
+				"refreshed":  len(staleKeys),
+			})
+		}
+	}
+	s.attachComputed(page, now)
+	maskResults(page, p)
+
+	return &models.AggregatedData{
+		UpdateTime: time.Now().Format("2006-01-02 15:04:05"),
+		TotalCount: len(keys),
+		Totals:     totals.totals,
+		Groups:     groups.result(),
+		Data:       page,
+		Offset:     offset,
+		Limit:      limit,
+		Degraded:   degraded,
+	}, nil
+}
+
+// storedResult returns the latest result of key: its failed fetch, if the
+// last one failed, else the usage in storage whatever its age
+func (s *APIKeyService) storedResult(ctx context.Context, key *storage.APIKey, failed *models.Usage, degraded bool, now time.Time) *models.Usage {
+	switch {
+	case key.Disabled:
+		usage := &models.Usage{ID: key.ID, Disabled: true, Error: "Key disabled"}
+		s.attachKey(usage, key)
+		return usage
+	case key.IsExpired(now):
+		usage := &models.Usage{ID: key.ID, Error: "Key expired"}
+		s.attachKey(usage, key)
+		return usage
+	case failed != nil:
+		s.attachKey(failed, key)
+		return failed
+	}
+
+	stored, err := s.getUsage(ctx, key.ID, degraded)
+	if err != nil || stored == nil {
+		usage := &models.Usage{ID: key.ID, Error: errProcessingTimeout}
+		s.attachKey(usage, key)
+		return usage
+	}
+	return s.usageFromStorage(key, stored)
+}
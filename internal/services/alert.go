@@ -0,0 +1,404 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/droid-keyusage-go/internal/models"
+	"github.com/droid-keyusage-go/internal/storage"
+	"github.com/google/uuid"
+)
+
+// Alert rules evaluated after each refresh
+const (
+	AlertRuleUsageHigh  = "usage_high"
+	AlertRuleExhausted  = "exhausted"
+	AlertRuleFetchError = "fetch_error"
+	AlertRuleInvalidKey = "invalid_key"
+
+	// Critical rules, escalated to incident management channels and
+	// resolved there when they clear
+	AlertRuleAllExhausted = "all_exhausted"
+	AlertRuleUpstreamDown = "upstream_down"
+)
+
+var (
+	// ErrAlertNotFound is returned when an alert ID does not exist
+	ErrAlertNotFound = errors.New("alert not found")
+	// ErrAlertResolved is returned when acknowledging an alert that already cleared
+	ErrAlertResolved = errors.New("alert already resolved")
+)
+
+// AlertService raises alerts from refresh results and tracks their state so
+// a condition that persists across refreshes only notifies once
+type AlertService struct {
+	store          *storage.Storage
+	notifier       *NotificationService
+	usageThreshold float64
+	dedupWindow    time.Duration
+	events         *EventBus
+	mu             sync.Mutex
+
+	// downAfter is how long every fetch from a provider must fail before
+	// the upstream_down alert fires; downSince tracks when that started
+	downAfter time.Duration
+	downSince map[string]time.Time
+}
+
+// NewAlertService creates an alert service; a threshold of 0 disables the usage
+// rule and a dedup window of 0 notifies every time an alert fires
+func NewAlertService(store *storage.Storage, notifier *NotificationService, usageThreshold float64, dedupWindow time.Duration, events *EventBus) *AlertService {
+	return &AlertService{
+		store:          store,
+		notifier:       notifier,
+		usageThreshold: usageThreshold,
+		dedupWindow:    dedupWindow,
+		events:         events,
+		downSince:      make(map[string]time.Time),
+	}
+}
+
+// SetUpstreamDownAfter sets how long every fetch from a provider must fail
+// before the upstream_down alert fires; 0 disables the rule
+func (s *AlertService) SetUpstreamDownAfter(after time.Duration) {
+	s.mu.Lock()
+	s.downAfter = after
+	s.mu.Unlock()
+}
+
+// SetUsageThreshold changes the used ratio that fires the usage alert; 0
+// disables the rule
+func (s *AlertService) SetUsageThreshold(threshold float64) {
+	s.mu.Lock()
+	s.usageThreshold = threshold
+	s.mu.Unlock()
+}
+
+// Evaluate checks refresh results against the alert rules, firing new alerts
+// and resolving those whose condition has cleared
+func (s *AlertService) Evaluate(keys []*storage.APIKey, results []*models.Usage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	active, err := s.store.GetActiveAlerts()
+	if err != nil {
+		fmt.Fprintf(console, "⚠️  读取告警状态失败: %v\n", err)
+		return
+	}
+
+	keyMap := make(map[string]*storage.APIKey, len(keys))
+	for _, key := range keys {
+		keyMap[key.ID] = key
+	}
+
+	now := time.Now()
+	firing := make(map[string]bool)
+	evaluated := make(map[string]bool, len(results))
+
+	for _, usage := range results {
+		key := keyMap[usage.ID]
+		if key == nil || key.Disabled || key.IsExpired(now) {
+			continue
+		}
+		evaluated[usage.ID] = true
+
+		for _, candidate := range s.rulesFor(key, usage) {
+			s.fire(candidate, active, firing, now)
+		}
+	}
+	for _, candidate := range s.criticalRules(keyMap, results, now) {
+		s.fire(candidate, active, firing, now)
+	}
+
+	// Resolve alerts whose condition no longer holds for keys seen in this refresh
+	for fingerprint, alert := range active {
+		if firing[fingerprint] {
+			continue
+		}
+		if _, exists := keyMap[alert.KeyID]; exists && !evaluated[alert.KeyID] {
+			continue
+		}
+		alert.State = storage.AlertStateResolved
+		alert.ResolvedAt = &now
+		if err := s.store.SaveAlert(alert); err == nil && alert.Severity == SeverityCritical {
+			s.dispatchResolved(alert)
+		}
+	}
+}
+
+// fire records a firing alert: an alert already active for the same
+// condition is updated, otherwise the candidate is opened and dispatched
+func (s *AlertService) fire(candidate *storage.Alert, active map[string]*storage.Alert, firing map[string]bool, now time.Time) {
+	fingerprint := candidate.Fingerprint()
+	firing[fingerprint] = true
+
+	if existing, ok := active[fingerprint]; ok {
+		existing.Count++
+		existing.LastSeenAt = now
+		existing.Message = candidate.Message
+		_ = s.store.SaveAlert(existing)
+		return
+	}
+
+	candidate.ID = uuid.New().String()
+	candidate.State = storage.AlertStateOpen
+	candidate.Count = 1
+	candidate.FiredAt = now
+	candidate.LastSeenAt = now
+	if err := s.store.SaveAlert(candidate); err != nil {
+		return
+	}
+	var keyIDs []string
+	if candidate.KeyID != "" {
+		keyIDs = []string{candidate.KeyID}
+	}
+	s.events.Publish(EventAlertFired, keyIDs, map[string]interface{}{
+		"alert_id": candidate.ID,
+		"rule":     candidate.Rule,
+	})
+	s.dispatch(candidate)
+}
+
+// upstreamDown reports whether a fetch failed because the provider could
+// not be reached or answered with a server error
+func upstreamDown(usage *models.Usage) bool {
+	switch usageErrorCode(usage) {
+	case UsageErrUpstreamTimeout, UsageErrUpstreamFailed, UsageErrUpstream5xx:
+		return true
+	}
+	return false
+}
+
+// criticalRules returns the critical alerts raised by a refresh as a whole:
+// every fetched key exhausted, and providers whose every fetch has failed
+// for longer than downAfter
+func (s *AlertService) criticalRules(keyMap map[string]*storage.APIKey, results []*models.Usage, now time.Time) []*storage.Alert {
+	alerts := make([]*storage.Alert, 0)
+
+	fetched, exhausted := 0, 0
+	type providerState struct{ fetches, down int }
+	providers := make(map[string]*providerState)
+	for _, usage := range results {
+		key := keyMap[usage.ID]
+		if key == nil || key.Disabled || key.IsExpired(now) {
+			continue
+		}
+
+		provider := providerName(key)
+		state := providers[provider]
+		if state == nil {
+			state = &providerState{}
+			providers[provider] = state
+		}
+		state.fetches++
+		if upstreamDown(usage) {
+			state.down++
+		}
+
+		if usage.Error != "" {
+			continue
+		}
+		fetched++
+		if usage.TotalAllowance > 0 && usage.Remaining <= 0 {
+			exhausted++
+		}
+	}
+
+	if fetched > 0 && exhausted == fetched {
+		alerts = append(alerts, &storage.Alert{
+			Rule:     AlertRuleAllExhausted,
+			Severity: SeverityCritical,
+			Message:  fmt.Sprintf("all %d keys have exhausted their allowance", fetched),
+		})
+	}
+
+	for provider, state := range providers {
+		if state.down < state.fetches {
+			delete(s.downSince, provider)
+			continue
+		}
+		since, ok := s.downSince[provider]
+		if !ok {
+			since = now
+			s.downSince[provider] = since
+		}
+		if s.downAfter <= 0 || now.Sub(since) < s.downAfter {
+			continue
+		}
+		alerts = append(alerts, &storage.Alert{
+			Rule:     AlertRuleUpstreamDown,
+			Provider: provider,
+			Severity: SeverityCritical,
+			Message: fmt.Sprintf("%s: every fetch has failed for %s",
+				provider, now.Sub(since).Truncate(time.Second)),
+		})
+	}
+	// Providers no longer fetched are not down
+	for provider := range s.downSince {
+		if providers[provider] == nil {
+			delete(s.downSince, provider)
+		}
+	}
+
+	return alerts
+}
+
+// rulesFor returns the alerts a key currently triggers
+func (s *AlertService) rulesFor(key *storage.APIKey, usage *models.Usage) []*storage.Alert {
+	alerts := make([]*storage.Alert, 0, 1)
+	newAlert := func(rule, message string) *storage.Alert {
+		return &storage.Alert{
+			Rule:    rule,
+			KeyID:   key.ID,
+			KeyName: key.Name,
+			Message: message,
+		}
+	}
+
+	switch {
+	case usageErrorCode(usage) == UsageErrUnauthorized:
+		alerts = append(alerts, newAlert(AlertRuleInvalidKey,
+			fmt.Sprintf("%s: key rejected by the provider (%s)", key.Name, usage.Error)))
+	case usage.Error != "":
+		alerts = append(alerts, newAlert(AlertRuleFetchError,
+			fmt.Sprintf("%s: failed to fetch usage (%s)", key.Name, usage.Error)))
+	case usage.TotalAllowance > 0 && usage.Remaining <= 0:
+		alerts = append(alerts, newAlert(AlertRuleExhausted,
+			fmt.Sprintf("%s: allowance exhausted", key.Name)))
+	case s.usageThreshold > 0 && usage.UsedRatio >= s.usageThreshold:
+		alerts = append(alerts, newAlert(AlertRuleUsageHigh,
+			fmt.Sprintf("%s: %.1f%% of allowance used", key.Name, usage.UsedRatio*100)))
+	}
+
+	return alerts
+}
+
+// dispatch sends a newly fired alert to the notification channels, unless the
+// same alert for the same key was already sent within the dedup window
+func (s *AlertService) dispatch(alert *storage.Alert) {
+	// Critical alerts are always escalated, so the incident opened for them
+	// is never left resolved while the condition holds
+	if s.dedupWindow > 0 && alert.Severity != SeverityCritical {
+		first, err := s.store.MarkAlertSent(alert.Fingerprint(), s.dedupWindow)
+		if err == nil && !first {
+			return
+		}
+	}
+
+	err := s.notifier.Notify(&Notification{
+		Event:    "alert.fired",
+		Title:    "Alert: " + alert.Rule,
+		Message:  alert.Message,
+		KeyID:    alert.KeyID,
+		Time:     alert.FiredAt,
+		Data:     alertData(alert),
+		Severity: alert.Severity,
+		DedupKey: alertDedupKey(alert),
+	})
+	if err != nil {
+		fmt.Fprintf(console, "⚠️  发送告警通知失败 (%s): %v\n", alert.ID, err)
+	}
+}
+
+// dispatchResolved tells the notification channels a critical alert has
+// cleared, so incidents opened for it are resolved
+func (s *AlertService) dispatchResolved(alert *storage.Alert) {
+	err := s.notifier.Notify(&Notification{
+		Event:    "alert.resolved",
+		Title:    "Resolved: " + alert.Rule,
+		Message:  alert.Message,
+		KeyID:    alert.KeyID,
+		Time:     *alert.ResolvedAt,
+		Data:     alertData(alert),
+		Severity: alert.Severity,
+		DedupKey: alertDedupKey(alert),
+	})
+	if err != nil {
+		fmt.Fprintf(console, "⚠️  发送告警恢复通知失败 (%s): %v\n", alert.ID, err)
+	}
+}
+
+// alertData returns the data attached to the notifications of an alert
+func alertData(alert *storage.Alert) map[string]interface{} {
+	data := map[string]interface{}{
+		"alert_id": alert.ID,
+		"rule":     alert.Rule,
+	}
+	if alert.Provider != "" {
+		data["provider"] = alert.Provider
+	}
+	return data
+}
+
+// alertDedupKey identifies the incident of a critical alert; every firing
+// of an alert gets its own incident
+func alertDedupKey(alert *storage.Alert) string {
+	if alert.Severity != SeverityCritical {
+		return ""
+	}
+	return "droid-keyusage-" + alert.ID
+}
+
+// ListAlerts returns recent alerts, optionally filtered by state
+func (s *AlertService) ListAlerts(state string, limit int) ([]*storage.Alert, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+
+	alerts, err := s.store.ListAlerts(int64(limit))
+	if err != nil {
+		return nil, err
+	}
+	if state == "" {
+		return alerts, nil
+	}
+
+	filtered := make([]*storage.Alert, 0, len(alerts))
+	for _, alert := range alerts {
+		if alert.State == state {
+			filtered = append(filtered, alert)
+		}
+	}
+	return filtered, nil
+}
+
+// GetAlert returns one alert, or ErrAlertNotFound
+func (s *AlertService) GetAlert(id string) (*storage.Alert, error) {
+	alert, err := s.store.GetAlert(id)
+	if err != nil {
+		return nil, err
+	}
+	if alert == nil {
+		return nil, ErrAlertNotFound
+	}
+	return alert, nil
+}
+
+// Acknowledge marks an open alert as acknowledged by the given user
+func (s *AlertService) Acknowledge(id, by string) (*storage.Alert, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	alert, err := s.store.GetAlert(id)
+	if err != nil {
+		return nil, err
+	}
+	if alert == nil {
+		return nil, ErrAlertNotFound
+	}
+	if alert.State == storage.AlertStateResolved {
+		return nil, ErrAlertResolved
+	}
+
+	now := time.Now()
+	alert.State = storage.AlertStateAcknowledged
+	alert.AcknowledgedBy = by
+	alert.AcknowledgedAt = &now
+
+	if err := s.store.SaveAlert(alert); err != nil {
+		return nil, err
+	}
+	return alert, nil
+}
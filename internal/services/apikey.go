@@ -2,8 +2,11 @@ package services
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/allegro/bigcache/v3"
@@ -12,18 +15,62 @@ import (
 	"github.com/google/uuid"
 )
 
+var (
+	// ErrKeyNotFound is returned when a key ID does not exist
+	ErrKeyNotFound = errors.New("key not found")
+	// ErrDuplicateKey is returned when adding a key that is already stored
+	ErrDuplicateKey = errors.New("key already exists")
+	// ErrReadOnly is returned when a viewer tries to change keys
+	ErrReadOnly = errors.New("read-only role")
+)
+
+// RefreshHook is called with the latest results each time usage data is aggregated
+type RefreshHook func(keys []*storage.APIKey, results []*models.Usage)
+
 // APIKeyService handles API key operations
 type APIKeyService struct {
-	store       *storage.Storage
-	workerPool  *WorkerPool
-	localCache  *bigcache.BigCache
-	cacheTTL    time.Duration
+	store        *storage.Storage
+	workerPool   *WorkerPool
+	localCache   *bigcache.BigCache
+	cacheTTL     time.Duration
+	groupTTLs    map[string]time.Duration
+	minRefresh   time.Duration
+	batchSize    int
+	events       *EventBus
+	refreshHooks []RefreshHook
+	scripts      *ScriptHooks
+	keyFormats   map[string]*KeyFormat
+	mask         MaskPolicy
+	ownerOnly    bool
+	computed     []ComputedField
+	settingsMu   sync.RWMutex
+	metrics      *StatsdEmitter
+	storageState storageState
+	warming      int32 // set while the startup refresh runs, see servingWarm
+	cacheStats   cacheCounters
+	order        string
+	chunkSize    int
 }
 
-// NewAPIKeyService creates a new API key service
-func NewAPIKeyService(store *storage.Storage, workerPool *WorkerPool) *APIKeyService {
-	// Configure local cache
-	config := bigcache.DefaultConfig(5 * time.Minute)
+// NewAPIKeyService creates a new API key service; keyFormats holds the
+// per-provider key format rules (see ParseKeyFormats)
+func NewAPIKeyService(store *storage.Storage, workerPool *WorkerPool, events *EventBus, batchSize int, cacheTTL time.Duration, keyFormats string, mask MaskPolicy) *APIKeyService {
+	if batchSize <= 0 {
+		batchSize = 500
+	}
+	if cacheTTL <= 0 {
+		cacheTTL = 5 * time.Minute
+	}
+
+	formats, err := ParseKeyFormats(keyFormats)
+	if err != nil {
+		fmt.Fprintf(console, "⚠️  %v，已忽略 Key 格式校验\n", err)
+		formats = nil
+	}
+
+	// Configure local cache; entries outlive the cache TTL (freshness is
+	// checked against LastUpdated) so they can be served while Redis is down
+	config := bigcache.DefaultConfig(24 * time.Hour)
 	config.Shards = 16
 	config.MaxEntriesInWindow = 10000
 	config.MaxEntrySize = 500
@@ -31,20 +78,114 @@ func NewAPIKeyService(store *storage.Storage, workerPool *WorkerPool) *APIKeySer
 	
 	cache, _ := bigcache.New(context.Background(), config)
 
-	return &APIKeyService{
+	s := &APIKeyService{
 		store:      store,
 		workerPool: workerPool,
 		localCache: cache,
-		cacheTTL:   5 * time.Minute,
+		cacheTTL:   cacheTTL,
+		batchSize:  batchSize,
+		events:     events,
+		keyFormats: formats,
+		mask:       mask,
+		order:      OrderCreatedAt,
+		chunkSize:  defaultRefreshChunkSize,
+	}
+	s.cacheStats.reset()
+
+	// Drop local cache entries changed on other replicas
+	if events != nil {
+		invalidate := func(event *Event) {
+			s.invalidateLocal(event.KeyIDs)
+		}
+		events.Subscribe(EventCacheInvalidate, invalidate)
+		events.Subscribe(EventKeyDeleted, invalidate)
+	}
+
+	return s
+}
+
+// getUsage reads usage from the local cache, falling back to Redis unless
+// localOnly is set
+func (s *APIKeyService) getUsage(ctx context.Context, id string, localOnly bool) (*storage.Usage, error) {
+	if data, err := s.localCache.Get(id); err == nil {
+		var usage storage.Usage
+		if json.Unmarshal(data, &usage) == nil {
+			return &usage, nil
+		}
+	}
+	if localOnly {
+		return nil, nil
+	}
+
+	usage, err := s.store.WithContext(ctx).GetUsage(id)
+	switch {
+	case err != nil:
+		s.cacheStats.redisErrors.Add(1)
+		return nil, err
+	case usage == nil:
+		s.cacheStats.redisMisses.Add(1)
+		return nil, nil
+	}
+	s.cacheStats.redisHits.Add(1)
+	s.setLocalUsage(usage)
+	return usage, nil
+}
+
+// setLocalUsage stores usage in the local cache
+func (s *APIKeyService) setLocalUsage(usage *storage.Usage) {
+	if data, err := json.Marshal(usage); err == nil {
+		_ = s.localCache.Set(usage.ID, data)
+	}
+}
+
+// invalidateLocal drops keys from the local cache
+func (s *APIKeyService) invalidateLocal(ids []string) {
+	for _, id := range ids {
+		_ = s.localCache.Delete(id)
+	}
+}
+
+// SetMetrics makes refreshes report their duration and upstream errors
+func (s *APIKeyService) SetMetrics(metrics *StatsdEmitter) {
+	s.metrics = metrics
+}
+
+// OnRefresh registers a hook run after every aggregation; hooks must be
+// registered before the service starts serving requests
+func (s *APIKeyService) OnRefresh(hook RefreshHook) {
+	s.refreshHooks = append(s.refreshHooks, hook)
+}
+
+// SetScriptHooks runs the on_import hook of scripts on the keys added and
+// registers its after_refresh hook, saving the keys the script changed
+func (s *APIKeyService) SetScriptHooks(scripts *ScriptHooks) {
+	s.scripts = scripts
+	if scripts == nil {
+		return
 	}
+	s.OnRefresh(func(keys []*storage.APIKey, results []*models.Usage) {
+		for _, key := range scripts.AfterRefresh(keys, results) {
+			if err := s.store.SaveAPIKey(key); err != nil {
+				fmt.Fprintf(console, "⚠️  保存钩子脚本修改的 Key 失败: %v\n", err)
+				return
+			}
+		}
+	})
 }
 
-// ImportKeys imports multiple API keys
-func (s *APIKeyService) ImportKeys(keys []string) (*models.ImportResult, error) {
+// ImportKeys imports multiple API keys on behalf of p; keys p may not see
+// are reported as duplicates but never updated or restored
+func (s *APIKeyService) ImportKeys(req *models.ImportRequest, p Principal) (*models.ImportResult, error) {
+	if err := requireWriter(p); err != nil {
+		return nil, err
+	}
+	items := importItems(req)
+	owner := keyOwner(strings.TrimSpace(req.Owner), p)
 	result := &models.ImportResult{
 		Success:    0,
 		Failed:     0,
 		Duplicates: 0,
+		Results:    make([]models.BatchItemResult, 0, len(items)),
 	}
 
 	// Get existing keys to check for duplicates
@@ -54,226 +195,804 @@ func (s *APIKeyService) ImportKeys(keys []string) (*models.ImportResult, error)
 	}
 
 	// Create a map for fast duplicate checking
-	existingMap := make(map[string]bool)
+	existingMap := make(map[string]*storage.APIKey)
 	for _, k := range existingKeys {
-		existingMap[k.Key] = true
+		existingMap[k.Key] = k
 	}
-
-	// Process each key
-	for _, keyStr := range keys {
-		keyStr = strings.TrimSpace(keyStr)
+	seen := make(map[string]bool, len(items))
+
+	// Collect new keys, remembering where each one came from
+	pending := make([]*storage.APIKey, 0, len(items))
+	positions := make(map[string]int, len(items))
+	addedIDs := make([]string, 0, len(items))
+	for i, entry := range items {
+		keyStr := strings.TrimSpace(entry.Key)
 		if keyStr == "" {
 			continue
 		}
+		item := models.BatchItemResult{Index: i, Key: s.maskKey(keyStr)}
 
-		// Check for duplicate
-		if existingMap[keyStr] {
+		// Repeated within this import
+		if seen[keyStr] {
 			result.Duplicates++
+			item.Status = models.BatchDuplicate
+			result.Results = append(result.Results, item)
 			continue
 		}
+		seen[keyStr] = true
 
-		// Generate unique ID
-		id := fmt.Sprintf("key-%s-%d", uuid.New().String()[:8], time.Now().Unix())
+		// Check for duplicate
+		if existing, ok := existingMap[keyStr]; ok {
+			item.ID = existing.ID
+			item.Status = models.BatchDuplicate
+			if req.OnDuplicate == models.OnDuplicateUpdateName && s.canSee(existing, p) && applyImportMetadata(existing, &entry) {
+				if err := s.store.SaveAPIKey(existing); err != nil {
+					result.Failed++
+					item.Status = models.BatchFailed
+					item.Reason = err.Error()
+				} else {
+					result.Updated++
+					item.Status = models.BatchUpdated
+				}
+			} else {
+				result.Duplicates++
+			}
+			result.Results = append(result.Results, item)
+			continue
+		}
+
+		// Reject malformed keys
+		if err := s.CheckKeyFormat("", keyStr); err != nil {
+			result.Failed++
+			item.Status = models.BatchFailed
+			item.Reason = err.Error()
+			result.Results = append(result.Results, item)
+			continue
+		}
 
-		// Create API key object
-		apiKey := &storage.APIKey{
-			ID:        id,
-			Key:       keyStr,
-			Name:      fmt.Sprintf("Key %s", id),
-			CreatedAt: time.Now(),
+		// Bring back a deleted key with its ID, metadata and history
+		if req.OnDuplicate == models.OnDuplicateRestore {
+			deleted, err := s.store.FindDeletedAPIKey(keyStr)
+			if err == nil && deleted != nil && !s.canSee(deleted, p) {
+				deleted = nil
+			}
+			if err == nil && deleted != nil {
+				applyImportMetadata(deleted, &entry)
+				err = s.store.RestoreAPIKey(deleted)
+				item.ID = deleted.ID
+			}
+			switch {
+			case errors.Is(err, storage.ErrDuplicate):
+				result.Duplicates++
+				item.Status = models.BatchDuplicate
+			case err != nil:
+				result.Failed++
+				item.Status = models.BatchFailed
+				item.Reason = err.Error()
+			case deleted != nil:
+				result.Restored++
+				item.Status = models.BatchRestored
+				addedIDs = append(addedIDs, deleted.ID)
+			}
+			if item.Status != "" {
+				result.Results = append(result.Results, item)
+				continue
+			}
 		}
 
-		// Save to storage
-		if err := s.store.SaveAPIKey(apiKey); err != nil {
+		key := newAPIKey(keyStr, strings.TrimSpace(entry.Name))
+		key.Group = strings.TrimSpace(entry.Group)
+		key.Tags = normalizeTags(entry.Tags)
+		key.Notes = strings.TrimSpace(entry.Notes)
+		key.Owner = owner
+		if reason := s.scripts.OnImport(key); reason != "" {
 			result.Failed++
-		} else {
+			item.Status = models.BatchFailed
+			item.Reason = reason
+			result.Results = append(result.Results, item)
+			continue
+		}
+		pending = append(pending, key)
+		positions[key.ID] = len(result.Results)
+		item.ID = key.ID
+		item.Status = models.BatchSucceeded
+		result.Results = append(result.Results, item)
+	}
+
+	// Save in pipelined chunks so a huge import never becomes one giant pipeline
+	for _, chunk := range chunkKeys(pending, s.batchSize) {
+		result.Chunks++
+		failed := s.store.BatchCreateAPIKeys(chunk)
+		chunkFailed := false
+		for _, key := range chunk {
+			if err, ok := failed[key.ID]; ok {
+				item := &result.Results[positions[key.ID]]
+				// Stored concurrently by another request since the check above
+				if errors.Is(err, storage.ErrDuplicate) {
+					result.Duplicates++
+					item.ID = ""
+					item.Status = models.BatchDuplicate
+					continue
+				}
+				result.Failed++
+				chunkFailed = true
+				item.Status = models.BatchFailed
+				item.Reason = err.Error()
+				continue
+			}
 			result.Success++
-			existingMap[keyStr] = true // Add to map to prevent duplicates in same batch
+			addedIDs = append(addedIDs, key.ID)
 		}
+		if chunkFailed {
+			result.FailedChunks++
+		}
+	}
+
+	if len(addedIDs) > 0 {
+		s.events.Publish(EventKeyAdded, addedIDs, nil)
 	}
 
 	return result, nil
 }
 
-// GetAllKeys retrieves all API keys with masked values
-func (s *APIKeyService) GetAllKeys() ([]*models.APIKeyMasked, error) {
+// chunkKeys splits keys into consecutive slices of at most size elements
+func chunkKeys(keys []*storage.APIKey, size int) [][]*storage.APIKey {
+	chunks := make([][]*storage.APIKey, 0, (len(keys)+size-1)/size)
+	for start := 0; start < len(keys); start += size {
+		end := start + size
+		if end > len(keys) {
+			end = len(keys)
+		}
+		chunks = append(chunks, keys[start:end])
+	}
+	return chunks
+}
+
+// importItems lists the keys of an import request followed by its items
+func importItems(req *models.ImportRequest) []models.ImportItem {
+	items := make([]models.ImportItem, 0, len(req.Keys)+len(req.Items))
+	for _, key := range req.Keys {
+		items = append(items, models.ImportItem{Key: key})
+	}
+	return append(items, req.Items...)
+}
+
+// applyImportMetadata copies the name, group and tags given in an import
+// item onto a stored key and reports whether anything changed
+func applyImportMetadata(key *storage.APIKey, item *models.ImportItem) bool {
+	changed := false
+	if name := strings.TrimSpace(item.Name); name != "" && name != key.Name {
+		key.Name = name
+		changed = true
+	}
+	if group := strings.TrimSpace(item.Group); group != "" && group != key.Group {
+		key.Group = group
+		changed = true
+	}
+	if item.Tags != nil {
+		tags := normalizeTags(item.Tags)
+		if strings.Join(tags, ",") != strings.Join(key.Tags, ",") {
+			key.Tags = tags
+			changed = true
+		}
+	}
+	if notes := strings.TrimSpace(item.Notes); notes != "" && notes != key.Notes {
+		key.Notes = notes
+		changed = true
+	}
+	return changed
+}
+
+// chunkIDs splits ids into consecutive slices of at most size elements
+func chunkIDs(ids []string, size int) [][]string {
+	chunks := make([][]string, 0, (len(ids)+size-1)/size)
+	for start := 0; start < len(ids); start += size {
+		end := start + size
+		if end > len(ids) {
+			end = len(ids)
+		}
+		chunks = append(chunks, ids[start:end])
+	}
+	return chunks
+}
+
+// AddKey adds a single API key with optional metadata on behalf of p
+func (s *APIKeyService) AddKey(req *models.AddKeyRequest, p Principal) (*storage.APIKey, error) {
+	if err := requireWriter(p); err != nil {
+		return nil, err
+	}
+	keyStr := strings.TrimSpace(req.Key)
+	if err := s.CheckKeyFormat(strings.TrimSpace(req.Provider), keyStr); err != nil {
+		return nil, err
+	}
+
+	apiKey := newAPIKey(keyStr, strings.TrimSpace(req.Name))
+	if err := setProvider(apiKey, strings.TrimSpace(req.Provider), req.Credential); err != nil {
+		return nil, err
+	}
+	apiKey.Group = strings.TrimSpace(req.Group)
+	apiKey.Tags = normalizeTags(req.Tags)
+	apiKey.Notes = strings.TrimSpace(req.Notes)
+	apiKey.RefreshInterval = strings.TrimSpace(req.RefreshInterval)
+	apiKey.ExpiresAt = req.ExpiresAt
+	apiKey.Owner = keyOwner(strings.TrimSpace(req.Owner), p)
+	if reason := s.scripts.OnImport(apiKey); reason != "" {
+		return nil, &KeyRejectedError{Reason: reason}
+	}
+
+	// The store rejects the key atomically if the same value already exists
+	if err := s.store.CreateAPIKey(apiKey); err != nil {
+		if errors.Is(err, storage.ErrDuplicate) {
+			return nil, ErrDuplicateKey
+		}
+		return nil, err
+	}
+
+	s.events.Publish(EventKeyAdded, []string{apiKey.ID}, nil)
+	return apiKey, nil
+}
+
+// SetEnabled enables or disables a key; reason is recorded when disabling
+func (s *APIKeyService) SetEnabled(id string, enabled bool, reason string, p Principal) (*storage.APIKey, error) {
+	if err := requireWriter(p); err != nil {
+		return nil, err
+	}
+	key, err := s.getVisibleKey(id, p)
+	if err != nil {
+		return nil, err
+	}
+
+	if enabled {
+		err = s.enableKey(key)
+	} else {
+		err = s.disableKey(key, strings.TrimSpace(reason), false)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// disableKey marks a key disabled; auto records that the health check did it
+func (s *APIKeyService) disableKey(key *storage.APIKey, reason string, auto bool) error {
+	now := time.Now()
+	key.Disabled = true
+	key.DisabledAt = &now
+	key.DisabledReason = reason
+	key.AutoDisabled = auto
+	return s.store.SaveAPIKey(key)
+}
+
+// enableKey clears the disabled state of a key and its failure counter
+func (s *APIKeyService) enableKey(key *storage.APIKey) error {
+	key.Disabled = false
+	key.DisabledAt = nil
+	key.DisabledReason = ""
+	key.AutoDisabled = false
+	if err := s.store.SaveAPIKey(key); err != nil {
+		return err
+	}
+	return s.store.ResetKeyFailures([]string{key.ID})
+}
+
+// TestKey fetches usage for a key that is not stored, so it can be checked before import
+func (s *APIKeyService) TestKey(ctx context.Context, req *models.TestKeyRequest) (*models.Usage, error) {
+	keyStr := strings.TrimSpace(req.Key)
+	provider := strings.TrimSpace(req.Provider)
+	if err := s.CheckKeyFormat(provider, keyStr); err != nil {
+		return nil, err
+	}
+
+	key := &storage.APIKey{Key: keyStr}
+	if err := setProvider(key, provider, req.Credential); err != nil {
+		return nil, err
+	}
+
+	usage, err := s.workerPool.Fetch(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	usage.ID = ""
+	usage.Key = s.maskKey(keyStr)
+	usage.ErrorCode = usageErrorCode(usage)
+	return usage, nil
+}
+
+// UpdateKey updates the metadata of an existing API key; only admins may
+// change its owner
+func (s *APIKeyService) UpdateKey(id string, req *models.UpdateKeyRequest, p Principal) (*storage.APIKey, error) {
+	if err := requireWriter(p); err != nil {
+		return nil, err
+	}
+	key, err := s.getVisibleKey(id, p)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Name != nil {
+		key.Name = strings.TrimSpace(*req.Name)
+	}
+	if req.Provider != nil || req.Credential != nil {
+		provider := key.Provider
+		if req.Provider != nil {
+			provider = strings.TrimSpace(*req.Provider)
+		}
+		credential := toModelCredential(key.Credential)
+		if req.Credential != nil {
+			credential = req.Credential
+		}
+		if err := setProvider(key, provider, credential); err != nil {
+			return nil, err
+		}
+	}
+	if req.Group != nil {
+		key.Group = strings.TrimSpace(*req.Group)
+	}
+	if req.Tags != nil {
+		key.Tags = normalizeTags(req.Tags)
+	}
+	if req.Notes != nil {
+		key.Notes = strings.TrimSpace(*req.Notes)
+	}
+	if req.RefreshInterval != nil {
+		key.RefreshInterval = strings.TrimSpace(*req.RefreshInterval)
+	}
+	if req.ClearExpiry {
+		key.ExpiresAt = nil
+	} else if req.ExpiresAt != nil {
+		key.ExpiresAt = req.ExpiresAt
+	}
+	if req.Owner != nil && p.Role == RoleAdmin {
+		key.Owner = strings.TrimSpace(*req.Owner)
+	}
+
+	if err := s.store.SaveAPIKey(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// saveFetched caches the successfully fetched usage of keys and appends it
+// to their history
+func (s *APIKeyService) saveFetched(keys []*storage.APIKey, results []*models.Usage, policy cachePolicy, degraded bool) {
+	if !degraded {
+		s.saveRawResponses(results)
+	}
+
+	validResults := make([]*storage.Usage, 0)
+	for _, usage := range results {
+		if usage.Error == "" {
+			validResults = append(validResults, usageToStorage(usage))
+		}
+	}
+	if len(validResults) == 0 {
+		return
+	}
+
+	updatedIDs := make([]string, len(validResults))
+	for i, usage := range validResults {
+		s.setLocalUsage(usage)
+		updatedIDs[i] = usage.ID
+	}
+
+	// Writes that fail are retried once Redis is back
+	if degraded || s.store.BatchSaveUsage(validResults, policy.storageTTL(keys)) != nil {
+		s.queueUsageWrites(validResults)
+	} else {
+		_ = s.store.BatchAppendHistory(validResults)
+		s.events.Publish(EventCacheInvalidate, updatedIDs, nil)
+	}
+}
+
+// newAPIKey builds a key record with a generated ID
+func newAPIKey(keyStr, name string) *storage.APIKey {
+	id := fmt.Sprintf("key-%s-%d", uuid.New().String()[:8], time.Now().Unix())
+	if name == "" {
+		name = fmt.Sprintf("Key %s", id)
+	}
+
+	return &storage.APIKey{
+		ID:        id,
+		Key:       keyStr,
+		Name:      name,
+		CreatedAt: time.Now(),
+	}
+}
+
+// setProvider validates and assigns a key's provider and upstream credential
+func setProvider(key *storage.APIKey, provider string, cred *models.Credential) error {
+	if provider != "" {
+		if _, err := GetProvider(provider); err != nil {
+			return err
+		}
+	}
+
+	var credential *storage.Credential
+	if cred != nil && cred.Type != CredentialBearer {
+		credential = &storage.Credential{
+			Type:     cred.Type,
+			Username: strings.TrimSpace(cred.Username),
+			Name:     strings.TrimSpace(cred.Name),
+			Headers:  cred.Headers,
+		}
+	} else if cred != nil && len(cred.Headers) > 0 {
+		credential = &storage.Credential{Type: CredentialBearer, Headers: cred.Headers}
+	}
+	if err := validateCredential(credential); err != nil {
+		return err
+	}
+
+	key.Provider = provider
+	key.Credential = credential
+	return nil
+}
+
+// toModelCredential converts a stored credential to its API form
+func toModelCredential(cred *storage.Credential) *models.Credential {
+	if cred == nil {
+		return nil
+	}
+	return &models.Credential{
+		Type:     cred.Type,
+		Username: cred.Username,
+		Name:     cred.Name,
+		Headers:  cred.Headers,
+	}
+}
+
+// credentialType returns the credential type of a key, defaulting to bearer
+func credentialType(key *storage.APIKey) string {
+	if key.Credential == nil || key.Credential.Type == "" {
+		return CredentialBearer
+	}
+	return key.Credential.Type
+}
+
+// normalizeTags trims tags and drops empty and repeated entries
+func normalizeTags(tags []string) []string {
+	seen := make(map[string]bool, len(tags))
+	result := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		result = append(result, tag)
+	}
+	return result
+}
+
+// GetAllKeys retrieves the API keys p may see with masked values
+func (s *APIKeyService) GetAllKeys(p Principal) ([]*models.APIKeyMasked, error) {
 	keys, err := s.store.GetAllAPIKeys()
 	if err != nil {
 		return nil, err
 	}
+	keys = s.visibleKeys(keys, p)
 
+	now := time.Now()
 	maskedKeys := make([]*models.APIKeyMasked, len(keys))
 	for i, key := range keys {
-		masked := s.maskKey(key.Key)
-		maskedKeys[i] = &models.APIKeyMasked{
-			ID:        key.ID,
-			Name:      key.Name,
-			Masked:    masked,
-			CreatedAt: key.CreatedAt,
-		}
+		maskedKeys[i] = s.maskedKey(key, now, p)
 	}
 
 	return maskedKeys, nil
 }
 
+// maskedKey describes a key without its value, masked for the principal
+func (s *APIKeyService) maskedKey(key *storage.APIKey, now time.Time, p Principal) *models.APIKeyMasked {
+	return &models.APIKeyMasked{
+		ID:              key.ID,
+		Name:            key.Name,
+		Provider:        providerName(key),
+		CredentialType:  credentialType(key),
+		Group:           key.Group,
+		Tags:            key.Tags,
+		Owner:           key.Owner,
+		Notes:           key.Notes,
+		RefreshInterval: key.RefreshInterval,
+		Masked:          s.MaskPolicy().MaskFor(key.Key, p.Role),
+		CreatedAt:       key.CreatedAt,
+		ExpiresAt:       key.ExpiresAt,
+		Expired:         key.IsExpired(now),
+		Enabled:         !key.Disabled,
+		DisabledAt:      key.DisabledAt,
+		DisabledReason:  key.DisabledReason,
+		AutoDisabled:    key.AutoDisabled,
+	}
+}
+
 // GetFullKey retrieves the full API key by ID
 func (s *APIKeyService) GetFullKey(id string) (*storage.APIKey, error) {
 	return s.store.GetAPIKey(id)
 }
 
 // DeleteKey deletes an API key
-func (s *APIKeyService) DeleteKey(id string) error {
+func (s *APIKeyService) DeleteKey(id string, p Principal) error {
+	if err := requireWriter(p); err != nil {
+		return err
+	}
+	if s.restricted(p) {
+		if _, err := s.getVisibleKey(id, p); err != nil {
+			return err
+		}
+	}
+
 	// Clear from local cache
 	_ = s.localCache.Delete(id)
-	
-	return s.store.DeleteAPIKey(id)
+
+	if err := s.store.DeleteAPIKey(id); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return ErrKeyNotFound
+		}
+		return err
+	}
+
+	s.events.Publish(EventKeyDeleted, []string{id}, nil)
+	return nil
 }
 
-// BatchDeleteKeys deletes multiple API keys
-func (s *APIKeyService) BatchDeleteKeys(ids []string) (*models.BatchDeleteResult, error) {
-	success, failed := s.store.BatchDeleteAPIKeys(ids)
-	
+// BatchDeleteKeys deletes multiple API keys; keys p may not see are
+// reported as not found
+func (s *APIKeyService) BatchDeleteKeys(ids []string, p Principal) (*models.BatchDeleteResult, error) {
+	if err := requireWriter(p); err != nil {
+		return nil, err
+	}
+	result := &models.BatchDeleteResult{
+		Results: make([]models.BatchItemResult, 0, len(ids)),
+	}
+	hidden, err := s.HiddenKeyIDs(ids, p)
+	if err != nil {
+		return nil, err
+	}
+
+	// Delete in pipelined chunks
+	deletedIDs := make([]string, 0, len(ids))
+	offset := 0
+	for _, chunk := range chunkIDs(ids, s.batchSize) {
+		visible := chunk
+		if len(hidden) > 0 {
+			visible = make([]string, 0, len(chunk))
+			for _, id := range chunk {
+				if !hidden[id] {
+					visible = append(visible, id)
+				}
+			}
+		}
+		outcomes := s.store.BatchDeleteAPIKeys(visible)
+		for _, id := range chunk {
+			if hidden[id] {
+				outcomes[id] = storage.ErrNotFound
+			}
+		}
+		result.Chunks++
+		chunkFailed := false
+		for i, id := range chunk {
+			item := models.BatchItemResult{Index: offset + i, ID: id, Status: models.BatchSucceeded}
+			switch err := outcomes[id]; {
+			case err == nil:
+				result.Success++
+				deletedIDs = append(deletedIDs, id)
+			case errors.Is(err, storage.ErrNotFound):
+				result.NotFound++
+				item.Status = models.BatchNotFound
+			default:
+				result.Failed++
+				chunkFailed = true
+				item.Status = models.BatchFailed
+				item.Reason = err.Error()
+			}
+			result.Results = append(result.Results, item)
+		}
+		if chunkFailed {
+			result.FailedChunks++
+		}
+		offset += len(chunk)
+	}
+
 	// Clear from local cache
-	for _, id := range ids {
+	for _, id := range deletedIDs {
 		_ = s.localCache.Delete(id)
 	}
 
-	return &models.BatchDeleteResult{
-		Success: success,
-		Failed:  failed,
-	}, nil
+	if len(deletedIDs) > 0 {
+		s.events.Publish(EventKeyDeleted, deletedIDs, nil)
+	}
+
+	return result, nil
 }
 
-// GetAggregatedData fetches and aggregates usage data for all keys
-func (s *APIKeyService) GetAggregatedData() (*models.AggregatedData, error) {
-	// Get all API keys
-	keys, err := s.store.GetAllAPIKeys()
+// GetAggregatedData fetches usage data for all keys and aggregates the keys
+// p may see; refresh hooks and statistics always cover every key. Keys are
+// fetched in chunks (SetRefreshChunkSize), each saved as it finishes; when
+// ctx is canceled, pending fetches are dropped and only the chunks already
+// finished are stored.
+func (s *APIKeyService) GetAggregatedData(ctx context.Context, p Principal) (*models.AggregatedData, error) {
+	return s.GetAggregatedDataMaxAge(ctx, p, -1)
+}
+
+// GetAggregatedDataMaxAge is GetAggregatedData refreshing usage older than
+// maxAge instead of the configured cache TTLs; maxAge < 0 keeps the TTLs
+func (s *APIKeyService) GetAggregatedDataMaxAge(ctx context.Context, p Principal, maxAge time.Duration) (*models.AggregatedData, error) {
+	// Get all API keys; while Redis is unavailable the last known keys and
+	// the local cache are served instead, marked as degraded
+	keys, degraded, err := s.loadKeys(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get API keys: %w", err)
 	}
 
+	policy := s.cachePolicy(maxAge)
+	keys = s.sortKeys(keys)
+
 	if len(keys) == 0 {
 		return &models.AggregatedData{
 			UpdateTime: time.Now().Format("2006-01-02 15:04:05"),
 			TotalCount: 0,
 			Totals:     models.Totals{},
 			Data:       []*models.Usage{},
+			Degraded:   degraded,
 		}, nil
 	}
 
 	// Check cache first
 	cachedResults := make([]*models.Usage, 0)
 	uncachedKeys := make([]*storage.APIKey, 0)
+	now := time.Now()
 
 	for _, key := range keys {
-		// Try to get from cache
-		usage, err := s.store.GetUsage(key.ID)
-		if err == nil && usage != nil {
-			// Check if cache is still valid (within TTL)
-			if time.Since(usage.LastUpdated) < s.cacheTTL {
-				// Convert storage.Usage to models.Usage
-				modelUsage := &models.Usage{
-					ID:             usage.ID,
-					Key:            s.maskKey(key.Key),
-					StartDate:      usage.StartDate,
-					EndDate:        usage.EndDate,
-					TotalAllowance: usage.TotalAllowance,
-					OrgTotalUsed:   usage.OrgTotalUsed,
-					Remaining:      usage.Remaining,
-					UsedRatio:      usage.UsedRatio,
-					LastUpdated:    usage.LastUpdated,
-					Error:          usage.Error,
-				}
-				cachedResults = append(cachedResults, modelUsage)
-				continue
-			}
+		if usage := s.cachedResult(ctx, key, policy, maxAge, degraded, now); usage != nil {
+			cachedResults = append(cachedResults, usage)
+		} else {
+			uncachedKeys = append(uncachedKeys, key)
 		}
-		uncachedKeys = append(uncachedKeys, key)
 	}
 
 	// Fetch uncached keys using worker pool
 	var freshResults []*models.Usage
 	if len(uncachedKeys) > 0 {
-		freshResults, err = s.workerPool.BatchProcess(uncachedKeys)
+		refreshStart := time.Now()
+		// Results are saved after each chunk
+		freshResults = make([]*models.Usage, 0, len(uncachedKeys))
+		err = s.refreshChunked(ctx, uncachedKeys, policy, degraded, func(results []*models.Usage) {
+			freshResults = append(freshResults, results...)
+		})
 		if err != nil {
 			return nil, fmt.Errorf("failed to process keys: %w", err)
 		}
-
-		// Save fresh results to cache
-		validResults := make([]*storage.Usage, 0)
-		for _, usage := range freshResults {
-			if usage.Error == "" {
-				storageUsage := &storage.Usage{
-					ID:             usage.ID,
-					StartDate:      usage.StartDate,
-					EndDate:        usage.EndDate,
-					TotalAllowance: usage.TotalAllowance,
-					OrgTotalUsed:   usage.OrgTotalUsed,
-					Remaining:      usage.Remaining,
-					UsedRatio:      usage.UsedRatio,
-					LastUpdated:    usage.LastUpdated,
-				}
-				validResults = append(validResults, storageUsage)
-			}
-		}
-		
-		if len(validResults) > 0 {
-			_ = s.store.BatchSaveUsage(validResults, s.cacheTTL)
+		s.metrics.Timing("refresh.duration", time.Since(refreshStart))
+		s.metrics.Count("refresh.keys", int64(len(uncachedKeys)))
+		if !degraded {
+			s.recordJob(ctx, refreshStart, time.Now(), len(keys), len(uncachedKeys), tallyResults(freshResults))
 		}
 	}
 
-	// Combine results
-	allResults := append(cachedResults, freshResults...)
-
-	// Calculate totals
-	totals := models.Totals{
-		TotalOrgTotalTokensUsed: 0,
-		TotalAllowance:          0,
+	// Attach masked keys and expiry metadata to fresh results
+	uncachedMap := make(map[string]*storage.APIKey, len(uncachedKeys))
+	for _, key := range uncachedKeys {
+		uncachedMap[key.ID] = key
+	}
+	for _, usage := range freshResults {
+		if key := uncachedMap[usage.ID]; key != nil {
+			s.attachKey(usage, key)
+		}
 	}
 
+	// Combine results in a stable order, one per key
+	allResults := orderResults(keys, append(cachedResults, freshResults...))
 	for _, usage := range allResults {
-		if usage.Error == "" {
-			totals.TotalOrgTotalTokensUsed += usage.OrgTotalUsed
-			totals.TotalAllowance += usage.TotalAllowance
+		usage.ErrorCode = usageErrorCode(usage)
+	}
+	for _, usage := range freshResults {
+		if usage.ErrorCode != "" {
+			s.metrics.Count("upstream.errors", 1, "error_code:"+usage.ErrorCode, "provider:"+providerName(uncachedMap[usage.ID]))
 		}
 	}
 
-	// Print keys with remaining balance > 0
-	fmt.Println("\n" + strings.Repeat("=", 80))
-	fmt.Println("📋 API Keys with remaining balance > 0:")
-	fmt.Println(strings.Repeat("-", 80))
-	
-	hasPositiveBalance := false
-	for _, usage := range allResults {
-		if usage.Error == "" && usage.Remaining > 0 {
-			// Find the original key
-			for _, key := range keys {
-				if key.ID == usage.ID {
-					fmt.Println(key.Key)
-					hasPositiveBalance = true
-					break
-				}
-			}
+	// Calculate totals
+	totals := computeTotals(keys, allResults, now)
+
+	// Deltas, statistics and hooks all need Redis
+	if !degraded {
+		s.attachDeltas(allResults, now)
+
+		// Precompute statistics for the dashboard
+		s.saveStats(keys, allResults)
+		for _, hook := range s.refreshHooks {
+			hook(keys, allResults)
 		}
 	}
-	
-	if !hasPositiveBalance {
-		fmt.Println("⚠️  No API Keys with remaining balance > 0")
+
+	totalKeys := len(keys)
+
+	// Limit the response to the keys the caller may see
+	if s.restricted(p) {
+		keys = s.visibleKeys(keys, p)
+		allResults = visibleResults(keys, allResults)
+		totals = computeTotals(keys, allResults, now)
+	}
+	s.attachComputed(allResults, now)
+	maskResults(allResults, p)
+
+	if len(uncachedKeys) > 0 && !degraded {
+		refreshedIDs := make([]string, len(uncachedKeys))
+		for i, key := range uncachedKeys {
+			refreshedIDs[i] = key.ID
+		}
+		s.events.Publish(EventRefreshCompleted, refreshedIDs, map[string]interface{}{
+			"total_keys": totalKeys,
+			"refreshed":  len(uncachedKeys),
+		})
 	}
-	fmt.Println(strings.Repeat("=", 80) + "\n")
 
 	return &models.AggregatedData{
 		UpdateTime: time.Now().Format("2006-01-02 15:04:05"),
 		TotalCount: len(keys),
 		Totals:     totals,
+		Groups:     computeGroups(keys, allResults, now),
 		Data:       allResults,
+		Degraded:   degraded,
 	}, nil
 }
 
-// maskKey masks an API key for display
+// cachedResult returns the result of key that needs no fetch: the
+// placeholder of a disabled or expired key, or its cached usage while it is
+// fresh. Degraded mode and requests made during the startup refresh serve
+// whatever is cached rather than refreshing. It returns nil when the key
+// must be fetched.
+func (s *APIKeyService) cachedResult(ctx context.Context, key *storage.APIKey, policy cachePolicy, maxAge time.Duration, degraded bool, now time.Time) *models.Usage {
+	// Disabled keys are never refreshed and are kept out of the totals
+	if key.Disabled {
+		usage := &models.Usage{ID: key.ID, Disabled: true, Error: "Key disabled"}
+		s.attachKey(usage, key)
+		return usage
+	}
+
+	// Expired keys are never refreshed
+	if key.IsExpired(now) {
+		usage := &models.Usage{ID: key.ID, Error: "Key expired"}
+		s.attachKey(usage, key)
+		return usage
+	}
+
+	usage, err := s.getUsage(ctx, key.ID, degraded)
+	if err != nil || usage == nil {
+		s.cacheStats.missing.Add(1)
+		return nil
+	}
+	if degraded || policy.fresh(key, usage.LastUpdated) || (maxAge < 0 && s.servingWarm(ctx)) {
+		s.cacheStats.fresh.Add(1)
+		return s.usageFromStorage(key, usage)
+	}
+	s.cacheStats.stale.Add(1)
+	return nil
+}
+
+// maskKey masks an API key for display and logs
 func (s *APIKeyService) maskKey(key string) string {
-	if len(key) <= 8 {
-		return key
+	return s.MaskPolicy().Mask(key)
+}
+
+// MaskPolicy returns the policy used to mask keys
+func (s *APIKeyService) MaskPolicy() MaskPolicy {
+	s.settingsMu.RLock()
+	defer s.settingsMu.RUnlock()
+	return s.mask
+}
+
+// SetMaskPolicy changes the policy used to mask keys
+func (s *APIKeyService) SetMaskPolicy(mask MaskPolicy) {
+	s.settingsMu.Lock()
+	s.mask = mask
+	s.settingsMu.Unlock()
+}
+
+// SetCacheTTL changes how long fetched usage is served from cache
+func (s *APIKeyService) SetCacheTTL(ttl time.Duration) {
+	if ttl <= 0 {
+		return
 	}
-	return fmt.Sprintf("%s...%s", key[:4], key[len(key)-4:])
+	s.settingsMu.Lock()
+	s.cacheTTL = ttl
+	s.settingsMu.Unlock()
 }
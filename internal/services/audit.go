@@ -0,0 +1,67 @@
+package services
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/droid-keyusage-go/internal/storage"
+	"github.com/google/uuid"
+)
+
+// Audited actions
+const (
+	AuditFullKeyRead = "key.full_read"
+	AuditKeyExport   = "key.export"
+	AuditStepUp      = "auth.step_up"
+	AuditSettings    = "settings.update"
+	AuditSetup       = "auth.setup"
+	AuditLogin       = "auth.login"
+	AuditCacheFlush  = "cache.flush"
+)
+
+// AuditService records security-relevant actions
+type AuditService struct {
+	store *storage.Storage
+
+	geo         *GeoIP
+	geoDB       *GeoDB
+	notifier    *NotificationService
+	notifyNewIP bool
+}
+
+// NewAuditService creates an audit service
+func NewAuditService(store *storage.Storage) *AuditService {
+	return &AuditService{store: store}
+}
+
+// SetGeoDB sets the local GeoIP databases entries are enriched from with
+// the country and network of their IP; nil disables it
+func (s *AuditService) SetGeoDB(geo *GeoDB) {
+	s.geoDB = geo
+}
+
+// Record stores an audit entry; failures are logged but never block the action
+func (s *AuditService) Record(entry *storage.AuditEntry) {
+	entry.ID = uuid.New().String()
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+	if entry.IP != "" && s.geoDB != nil {
+		info := s.geoDB.Lookup(entry.IP)
+		entry.Country, entry.ASN, entry.ASOrg = info.Country, info.ASN, info.ASOrg
+		if entry.Location == "" {
+			entry.Location = info.Location()
+		}
+	}
+	if err := s.store.AppendAudit(entry); err != nil {
+		fmt.Fprintf(console, "⚠️  写入审计日志失败: %v\n", err)
+	}
+}
+
+// List returns recent audit entries, newest first
+func (s *AuditService) List(action string, limit int) ([]*storage.AuditEntry, error) {
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+	return s.store.ListAudit(action, limit)
+}
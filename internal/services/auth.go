@@ -1,53 +1,128 @@
 package services
 
 import (
+	"errors"
+	"fmt"
+	"sync"
 	"time"
 
 	"github.com/droid-keyusage-go/internal/storage"
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 )
 
+// ErrInvalidPassword is returned when a step-up password is wrong
+var ErrInvalidPassword = errors.New("invalid password")
+
 // AuthService handles authentication
 type AuthService struct {
-	store         *storage.Storage
-	adminPassword string
-	jwtSecret     []byte
+	store          *storage.Storage
+	// passwordsMu guards adminPassword and viewerPassword, which rotated
+	// secrets replace at runtime (see SetPasswords)
+	passwordsMu    sync.RWMutex
+	adminPassword  string
+	viewerPassword string
+	users          map[string]*User
+	sessionTTL     time.Duration
+	shortTTL       time.Duration
+	stepUpTTL      time.Duration
+	sliding        bool
+	maxAge         time.Duration
+	jwtSecret      []byte
+	tokens         TokenConfig
+
+	// Login by emailed one-time links (see ConfigureMagicLinks)
+	magicUsers   map[string]string
+	mailer       *Mailer
+	magicBaseURL string
+	magicTTL     time.Duration
+
+	// Client certificate identities and their scopes (see
+	// ConfigureClientCerts)
+	clientCerts map[string][]string
+
+	// First-run setup (see ConfigureSetup); setupMu also guards jwtSecret,
+	// which a setup completed at runtime replaces
+	authDisabled bool
+	setupMu      sync.RWMutex
+	setup        *setupState
+
+	// known holds the sessions read on this replica, so signed-in users
+	// stay signed in while Redis is unavailable
+	known sync.Map
 }
 
-// NewAuthService creates a new auth service
-func NewAuthService(store *storage.Storage, adminPassword string) *AuthService {
-	// Generate a secret for JWT if not provided
-	jwtSecret := []byte("your-secret-key-change-this-in-production")
+// NewAuthService creates a new auth service; an empty viewerPassword
+// disables read-only viewer logins and users holds the named logins
+// (see ParseUsers). Remembered sessions last sessionTTL (7 days when <= 0),
+// others shortTTL (12 hours when <= 0). Invalid users are an error rather
+// than ignored, since dropping them could leave the first-run setup open.
+func NewAuthService(store *storage.Storage, adminPassword, viewerPassword, users string, sessionTTL, shortTTL, stepUpTTL time.Duration) (*AuthService, error) {
+	named, err := ParseUsers(users)
+	if err != nil {
+		return nil, err
+	}
+	if sessionTTL <= 0 {
+		sessionTTL = 7 * 24 * time.Hour
+	}
+	if shortTTL <= 0 {
+		shortTTL = 12 * time.Hour
+	}
 	
 	return &AuthService{
-		store:         store,
-		adminPassword: adminPassword,
-		jwtSecret:     jwtSecret,
-	}
+		store:          store,
+		adminPassword:  adminPassword,
+		viewerPassword: viewerPassword,
+		users:          named,
+		sessionTTL:     sessionTTL,
+		shortTTL:       shortTTL,
+		stepUpTTL:      stepUpTTL,
+	}, nil
 }
 
 // ValidatePassword checks if the password is correct
 func (s *AuthService) ValidatePassword(password string) bool {
-	// If no password is set, allow access
-	if s.adminPassword == "" {
+	if admin := s.currentAdminPassword(); admin != "" {
+		return password == admin
+	}
+	// Without ADMIN_PASSWORD the password set by the first-run setup
+	// applies, unless auth is disabled altogether
+	if s.authDisabled {
 		return true
 	}
-	return password == s.adminPassword
+	return s.checkSetupPassword(password)
+}
+
+// RoleForPassword returns the role a password logs in as, or "" if it matches none
+func (s *AuthService) RoleForPassword(password string) string {
+	if s.ValidatePassword(password) {
+		return RoleAdmin
+	}
+	if viewer := s.currentViewerPassword(); viewer != "" && password == viewer {
+		return RoleViewer
+	}
+	return ""
 }
 
-// CreateSession creates a new session
-func (s *AuthService) CreateSession() (string, error) {
+// CreateSession creates a new session for the given principal; remembered
+// sessions last the long session TTL, others the short one
+func (s *AuthService) CreateSession(p Principal, remember bool) (string, error) {
 	sessionID := uuid.New().String()
+	ttl := s.shortTTL
+	if remember {
+		ttl = s.sessionTTL
+	}
 	
 	session := &storage.Session{
 		ID:        sessionID,
+		Role:      p.Role,
+		User:      p.User,
 		CreatedAt: time.Now(),
-		ExpiresAt: time.Now().Add(7 * 24 * time.Hour), // 7 days
+		ExpiresAt: time.Now().Add(ttl),
+		Remember:  remember,
 	}
 	
 	// Save to Redis with TTL
-	err := s.store.SaveSession(session, 7*24*time.Hour)
+	err := s.store.SaveSession(session, ttl)
 	if err != nil {
 		return "", err
 	}
@@ -55,65 +130,180 @@ func (s *AuthService) CreateSession() (string, error) {
 	return sessionID, nil
 }
 
+// SetSlidingSessions makes sessions in use last their TTL from the latest
+// request instead of from login, but never longer than maxAge after login
+// (no limit when maxAge <= 0)
+func (s *AuthService) SetSlidingSessions(sliding bool, maxAge time.Duration) {
+	s.sliding = sliding
+	s.maxAge = maxAge
+}
+
+// RenewSession extends a session in use to its TTL from now, capped at the
+// maximum age; it reports whether the session was extended. Sessions are
+// only renewed once less than half their TTL is left, so an active session
+// is written at most twice per TTL rather than on every request.
+func (s *AuthService) RenewSession(session *storage.Session) bool {
+	if !s.sliding {
+		return false
+	}
+	ttl := s.shortTTL
+	if session.Remember {
+		ttl = s.sessionTTL
+	}
+	now := time.Now()
+	if session.ExpiresAt.Sub(now) > ttl/2 {
+		return false
+	}
+
+	expiresAt := now.Add(ttl)
+	if s.maxAge > 0 {
+		if limit := session.CreatedAt.Add(s.maxAge); expiresAt.After(limit) {
+			expiresAt = limit
+		}
+	}
+	if !expiresAt.After(session.ExpiresAt) {
+		return false
+	}
+
+	renewed := *session
+	renewed.ExpiresAt = expiresAt
+	if err := s.store.SaveSession(&renewed, expiresAt.Sub(now)); err != nil {
+		return false
+	}
+	s.known.Store(session.ID, &renewed)
+	session.ExpiresAt = expiresAt
+	return true
+}
+
+// SessionTTL returns how long remembered sessions last
+func (s *AuthService) SessionTTL() time.Duration {
+	return s.sessionTTL
+}
+
 // ValidateSession checks if a session is valid
 func (s *AuthService) ValidateSession(sessionID string) bool {
-	if s.adminPassword == "" {
+	if !s.IsAuthRequired() {
 		return true // No auth required
 	}
-	
+	return s.GetSession(sessionID) != nil
+}
+
+// GetSession returns a valid, unexpired session or nil
+func (s *AuthService) GetSession(sessionID string) *storage.Session {
 	if sessionID == "" {
-		return false
+		return nil
 	}
 	
 	session, err := s.store.GetSession(sessionID)
-	if err != nil || session == nil {
-		return false
+	if err != nil {
+		known, ok := s.known.Load(sessionID)
+		if !ok {
+			return nil
+		}
+		copied := *known.(*storage.Session)
+		session = &copied
+	}
+	if session == nil {
+		s.known.Delete(sessionID)
+		return nil
 	}
 	
 	// Check if session is expired
 	if time.Now().After(session.ExpiresAt) {
+		s.known.Delete(sessionID)
 		_ = s.store.DeleteSession(sessionID)
+		return nil
+	}
+	if err == nil {
+		s.known.Store(sessionID, session)
+	}
+
+	// Sessions created before roles existed belong to the admin
+	if session.Role == "" {
+		session.Role = RoleAdmin
+	}
+	return session
+}
+
+// stepUpGrant is what a step-up token unlocks, and for whom
+type stepUpGrant struct {
+	User      string    `json:"user,omitempty"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// StepUp re-authenticates the principal with its own password (a named
+// admin's, or the shared admin password) and issues a short-lived token
+// that unlocks sensitive operations such as reading full keys for it only
+func (s *AuthService) StepUp(p Principal, password string) (string, time.Time, error) {
+	checked, ok := s.Authenticate(p.User, password)
+	if !ok || checked.User != p.User || checked.Role != RoleAdmin {
+		return "", time.Time{}, ErrInvalidPassword
+	}
+
+	token := uuid.New().String()
+	grant := stepUpGrant{User: p.User, ExpiresAt: time.Now().Add(s.stepUpTTL)}
+	if err := s.store.SetJSON(stepUpKey(token), grant, s.stepUpTTL); err != nil {
+		return "", time.Time{}, err
+	}
+	return token, grant.ExpiresAt, nil
+}
+
+// ValidateStepUp checks a step-up token issued to the principal; it always
+// passes when auth is disabled
+func (s *AuthService) ValidateStepUp(p Principal, token string) bool {
+	if !s.IsAuthRequired() {
+		return true
+	}
+	if token == "" {
 		return false
 	}
-	
-	return true
+
+	var grant stepUpGrant
+	found, err := s.store.GetJSON(stepUpKey(token), &grant)
+	return err == nil && found && grant.User == p.User && time.Now().Before(grant.ExpiresAt)
+}
+
+func stepUpKey(token string) string {
+	return "stepup:" + token
 }
 
 // DeleteSession removes a session
 func (s *AuthService) DeleteSession(sessionID string) error {
+	s.known.Delete(sessionID)
 	return s.store.DeleteSession(sessionID)
 }
 
-// IsAuthRequired checks if authentication is required
+// IsAuthRequired checks if authentication is required; it only is not
+// when AUTH_DISABLED is set without ADMIN_PASSWORD
 func (s *AuthService) IsAuthRequired() bool {
-	return s.adminPassword != ""
+	return s.currentAdminPassword() != "" || !s.authDisabled
 }
 
-// GenerateJWT creates a JWT token (alternative to session)
-func (s *AuthService) GenerateJWT() (string, error) {
-	claims := jwt.MapClaims{
-		"authorized": true,
-		"exp":        time.Now().Add(7 * 24 * time.Hour).Unix(),
-		"iat":        time.Now().Unix(),
-	}
-	
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(s.jwtSecret)
+// currentAdminPassword returns ADMIN_PASSWORD, or its rotated value
+func (s *AuthService) currentAdminPassword() string {
+	s.passwordsMu.RLock()
+	defer s.passwordsMu.RUnlock()
+	return s.adminPassword
 }
 
-// ValidateJWT validates a JWT token
-func (s *AuthService) ValidateJWT(tokenString string) bool {
-	if s.adminPassword == "" {
-		return true
-	}
-	
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		return s.jwtSecret, nil
-	})
-	
-	if err != nil || !token.Valid {
-		return false
+// currentViewerPassword returns VIEWER_PASSWORD, or its rotated value
+func (s *AuthService) currentViewerPassword() string {
+	s.passwordsMu.RLock()
+	defer s.passwordsMu.RUnlock()
+	return s.viewerPassword
+}
+
+// SetPasswords replaces the shared admin and viewer passwords, e.g. after
+// they were rotated in the secret store. Signed-in sessions stay valid. An
+// admin password can't be removed at runtime, which would hand the service
+// to whoever runs the first-run setup first.
+func (s *AuthService) SetPasswords(adminPassword, viewerPassword string) {
+	s.passwordsMu.Lock()
+	defer s.passwordsMu.Unlock()
+	if adminPassword != "" || s.adminPassword == "" {
+		s.adminPassword = adminPassword
+	} else {
+		fmt.Fprintln(console, "⚠️  ADMIN_PASSWORD 不能在运行时移除，继续使用原密码")
 	}
-	
-	return true
+	s.viewerPassword = viewerPassword
 }
@@ -0,0 +1,74 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestStepUpNamedAdmin(t *testing.T) {
+	store, _ := newTestStore(t)
+	auth, err := NewAuthService(store, "shared", "", "alice:admin:alice-pw;bob:admin:bob-pw;carol:editor:carol-pw", 0, 0, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice := Principal{User: "alice", Role: RoleAdmin}
+	bob := Principal{User: "bob", Role: RoleAdmin}
+
+	token, _, err := auth.StepUp(alice, "alice-pw")
+	if err != nil {
+		t.Fatalf("named admin can't step up with its password: %v", err)
+	}
+	if !auth.ValidateStepUp(alice, token) {
+		t.Error("step-up token rejected for the admin it was issued to")
+	}
+	if auth.ValidateStepUp(bob, token) {
+		t.Error("step-up token accepted for another admin")
+	}
+	if auth.ValidateStepUp(Principal{Role: RoleAdmin}, token) {
+		t.Error("step-up token accepted for the shared admin login")
+	}
+
+	for _, tt := range []struct {
+		p        Principal
+		password string
+	}{
+		{alice, "shared"},
+		{alice, "bob-pw"},
+		{Principal{User: "carol", Role: RoleEditor}, "carol-pw"},
+		{Principal{Role: RoleAdmin}, "alice-pw"},
+	} {
+		if _, _, err := auth.StepUp(tt.p, tt.password); !errors.Is(err, ErrInvalidPassword) {
+			t.Errorf("StepUp(%q, %q): got %v, want ErrInvalidPassword", tt.p.User, tt.password, err)
+		}
+	}
+}
+
+func TestStepUpSharedAdmin(t *testing.T) {
+	store, _ := newTestStore(t)
+	auth, err := NewAuthService(store, "shared", "", "", 0, 0, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin := Principal{Role: RoleAdmin}
+
+	token, _, err := auth.StepUp(admin, "shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !auth.ValidateStepUp(admin, token) {
+		t.Error("step-up token rejected for the shared admin login")
+	}
+	if auth.ValidateStepUp(admin, "unknown") {
+		t.Error("unknown step-up token accepted")
+	}
+}
+
+func TestNewAuthServiceInvalidUsers(t *testing.T) {
+	store, _ := newTestStore(t)
+	for _, users := range []string{"alice", "alice:owner:pw", "alice:admin:"} {
+		if _, err := NewAuthService(store, "", "", users, 0, 0, 0); err == nil {
+			t.Errorf("USERS=%q accepted", users)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/droid-keyusage-go/internal/models"
+	"github.com/droid-keyusage-go/internal/storage"
+)
+
+// KeyBackup is the content of a backup: every stored key including its full
+// secret, and the settings overridden through the API
+type KeyBackup struct {
+	GeneratedAt time.Time              `json:"generated_at"`
+	Keys        []*storage.APIKey      `json:"keys"`
+	Settings    *models.SettingsUpdate `json:"settings,omitempty"`
+}
+
+// BackupService periodically uploads a backup of the keys to an
+// S3-compatible bucket, so Redis is not the only copy
+type BackupService struct {
+	store    *storage.Storage
+	objects  *ObjectStore
+	interval time.Duration
+	shutdown chan struct{}
+	wg       sync.WaitGroup
+}
+
+// NewBackupService creates a backup service; backups are off when objects
+// is nil or interval <= 0
+func NewBackupService(store *storage.Storage, objects *ObjectStore, interval time.Duration) *BackupService {
+	return &BackupService{
+		store:    store,
+		objects:  objects,
+		interval: interval,
+		shutdown: make(chan struct{}),
+	}
+}
+
+// Start launches the background backup job
+func (s *BackupService) Start() {
+	if s.objects == nil || s.interval <= 0 {
+		return
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		ctx, cancel := shutdownContext(s.shutdown)
+		defer cancel()
+
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if _, err := s.Backup(ctx); err != nil {
+					fmt.Fprintf(console, "⚠️  上传备份失败: %v\n", err)
+				}
+			case <-s.shutdown:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the background backup job
+func (s *BackupService) Stop() {
+	close(s.shutdown)
+	s.wg.Wait()
+}
+
+// Backup uploads a backup and returns its object name
+func (s *BackupService) Backup(ctx context.Context) (string, error) {
+	store := s.store.WithContext(ctx)
+	keys, err := store.GetAllAPIKeys()
+	if err != nil {
+		return "", err
+	}
+	backup := &KeyBackup{GeneratedAt: time.Now(), Keys: keys}
+
+	var overrides models.SettingsUpdate
+	found, err := store.GetJSON(settingsKey, &overrides)
+	if err != nil {
+		return "", err
+	}
+	if found {
+		backup.Settings = &overrides
+	}
+
+	data, err := json.Marshal(backup)
+	if err != nil {
+		return "", err
+	}
+	name := fmt.Sprintf("backups/keys-%s.json", backup.GeneratedAt.UTC().Format("20060102-150405"))
+	if err := s.objects.Put(ctx, name, "application/json", data); err != nil {
+		return "", err
+	}
+	return name, nil
+}
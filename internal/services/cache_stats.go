@@ -0,0 +1,92 @@
+package services
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/droid-keyusage-go/internal/models"
+)
+
+// cacheCounters counts usage cache lookups; bigcache counts the local
+// layer itself
+type cacheCounters struct {
+	since       atomic.Int64 // UnixNano
+	redisHits   atomic.Int64
+	redisMisses atomic.Int64
+	redisErrors atomic.Int64
+	fresh       atomic.Int64
+	stale       atomic.Int64
+	missing     atomic.Int64
+}
+
+// reset starts counting over
+func (c *cacheCounters) reset() {
+	c.since.Store(time.Now().UnixNano())
+	c.redisHits.Store(0)
+	c.redisMisses.Store(0)
+	c.redisErrors.Store(0)
+	c.fresh.Store(0)
+	c.stale.Store(0)
+	c.missing.Store(0)
+}
+
+// hitRatio returns hits over all lookups, or 0 before the first lookup
+func hitRatio(hits, lookups int64) float64 {
+	if lookups == 0 {
+		return 0
+	}
+	return float64(hits) / float64(lookups)
+}
+
+// CacheStats reports the hits and misses of the local and Redis usage
+// caches, and how often cached usage was fresh enough to be served
+func (s *APIKeyService) CacheStats() *models.CacheStats {
+	local := s.localCache.Stats()
+	stats := &models.CacheStats{
+		Since:      time.Unix(0, s.cacheStats.since.Load()),
+		TTLSeconds: int(s.cachePolicy(-1).ttl / time.Second),
+		Local: models.CacheLayerStats{
+			Hits:     local.Hits,
+			Misses:   local.Misses,
+			Entries:  s.localCache.Len(),
+			HitRatio: hitRatio(local.Hits, local.Hits+local.Misses),
+		},
+		Redis: models.CacheLayerStats{
+			Hits:   s.cacheStats.redisHits.Load(),
+			Misses: s.cacheStats.redisMisses.Load(),
+			Errors: s.cacheStats.redisErrors.Load(),
+		},
+		Fresh:   s.cacheStats.fresh.Load(),
+		Stale:   s.cacheStats.stale.Load(),
+		Missing: s.cacheStats.missing.Load(),
+	}
+	stats.Redis.HitRatio = hitRatio(stats.Redis.Hits, stats.Redis.Hits+stats.Redis.Misses+stats.Redis.Errors)
+	stats.HitRatio = hitRatio(stats.Fresh, stats.Fresh+stats.Stale+stats.Missing)
+	return stats
+}
+
+// FlushCache drops the cached usage of every key from Redis and the local
+// cache, so the next request refetches it, and starts the statistics over.
+// Other replicas drop the keys from their local caches too.
+func (s *APIKeyService) FlushCache(ctx context.Context) (*models.CacheFlushResult, error) {
+	keys, err := s.store.WithContext(ctx).GetAllAPIKeys()
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, len(keys))
+	for i, key := range keys {
+		ids[i] = key.ID
+	}
+
+	result := &models.CacheFlushResult{Success: true, Local: s.localCache.Len()}
+	result.Redis, err = s.store.WithContext(ctx).DeleteUsage(ids)
+	if err != nil {
+		return nil, err
+	}
+	_ = s.localCache.Reset()
+	_ = s.localCache.ResetStats()
+	s.cacheStats.reset()
+	s.events.Publish(EventCacheInvalidate, ids, nil)
+	return result, nil
+}
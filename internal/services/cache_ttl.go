@@ -0,0 +1,129 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/droid-keyusage-go/internal/storage"
+	"github.com/droid-keyusage-go/internal/utils"
+)
+
+// ParseGroupCacheTTLs parses per-group cache TTLs such as
+// "production:1m;archive:1h". Keys in other groups use the global TTL.
+func ParseGroupCacheTTLs(spec string) (map[string]time.Duration, error) {
+	ttls := make(map[string]time.Duration)
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		i := strings.LastIndex(entry, ":")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid group cache TTL %q: expected group:ttl", entry)
+		}
+		group := strings.TrimSpace(entry[:i])
+		ttl, err := time.ParseDuration(strings.TrimSpace(entry[i+1:]))
+		if err != nil || ttl <= 0 {
+			return nil, fmt.Errorf("invalid group cache TTL %q: bad duration", entry)
+		}
+		if _, ok := ttls[group]; ok {
+			return nil, fmt.Errorf("invalid group cache TTL %q: group defined twice", entry)
+		}
+		ttls[group] = ttl
+	}
+	return ttls, nil
+}
+
+// SetGroupCacheTTLs sets how long usage of keys in the given groups is
+// served from cache, overriding the global cache TTL
+func (s *APIKeyService) SetGroupCacheTTLs(ttls map[string]time.Duration) {
+	s.settingsMu.Lock()
+	s.groupTTLs = ttls
+	s.settingsMu.Unlock()
+}
+
+// SetMinRefreshInterval sets the floor below which no key, group or
+// global TTL refreshes usage, protecting the upstream from keys configured
+// to refresh too often
+func (s *APIKeyService) SetMinRefreshInterval(floor time.Duration) {
+	s.settingsMu.Lock()
+	s.minRefresh = floor
+	s.settingsMu.Unlock()
+}
+
+// ParseRefreshInterval parses a key's refresh interval such as "1m" or
+// "1d"; it must be positive
+func ParseRefreshInterval(interval string) (time.Duration, error) {
+	d, err := utils.ParseDuration(interval)
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("refresh interval must be positive")
+	}
+	return d, nil
+}
+
+// cachePolicy decides how long cached usage is fresh
+type cachePolicy struct {
+	ttl    time.Duration
+	groups map[string]time.Duration
+	floor  time.Duration
+	// maxAge, when >= 0, overrides all of them for a single request
+	maxAge time.Duration
+}
+
+// cachePolicy returns the current cache TTLs with maxAge applied
+func (s *APIKeyService) cachePolicy(maxAge time.Duration) cachePolicy {
+	s.settingsMu.RLock()
+	defer s.settingsMu.RUnlock()
+	return cachePolicy{ttl: s.cacheTTL, groups: s.groupTTLs, floor: s.minRefresh, maxAge: maxAge}
+}
+
+// interval is how often usage of key is refreshed: its own refresh
+// interval, else its group's TTL, else the global TTL, but never more
+// often than the floor allows
+func (p cachePolicy) interval(key *storage.APIKey) time.Duration {
+	ttl := p.ttl
+	if groupTTL, ok := p.groups[key.Group]; ok {
+		ttl = groupTTL
+	}
+	if key.RefreshInterval != "" {
+		// Intervals are validated when stored
+		if own, err := ParseRefreshInterval(key.RefreshInterval); err == nil {
+			ttl = own
+		}
+	}
+	if ttl < p.floor {
+		ttl = p.floor
+	}
+	return ttl
+}
+
+// fresh reports whether usage of key fetched at lastUpdated can be served
+func (p cachePolicy) fresh(key *storage.APIKey, lastUpdated time.Time) bool {
+	ttl := p.interval(key)
+	if p.maxAge >= 0 {
+		ttl = p.maxAge
+	}
+	return time.Since(lastUpdated) < ttl
+}
+
+// storageTTL is how long usage is kept in Redis: long enough for the
+// group with the longest TTL and for the refresh intervals of keys
+func (p cachePolicy) storageTTL(keys []*storage.APIKey) time.Duration {
+	ttl := p.ttl
+	for _, groupTTL := range p.groups {
+		if groupTTL > ttl {
+			ttl = groupTTL
+		}
+	}
+	for _, key := range keys {
+		if interval := p.interval(key); interval > ttl {
+			ttl = interval
+		}
+	}
+	return ttl
+}
@@ -0,0 +1,53 @@
+package services
+
+import (
+	"time"
+
+	"github.com/droid-keyusage-go/internal/models"
+)
+
+// MaxChartPoints caps the number of buckets a single chart request may produce
+const MaxChartPoints = 1000
+
+// GetChart downsamples a key's usage history over the last rng into buckets
+// of the given interval; each point holds the last snapshot of its bucket
+func (s *APIKeyService) GetChart(id string, interval, rng time.Duration, p Principal) (*models.ChartData, error) {
+	if _, err := s.getVisibleKey(id, p); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	from := now.Add(-rng)
+	history, err := s.store.GetHistory(id, from, now)
+	if err != nil {
+		return nil, err
+	}
+
+	chart := &models.ChartData{
+		ID:       id,
+		Interval: interval.String(),
+		Range:    rng.String(),
+		From:     from,
+		To:       now,
+		Points:   make([]models.ChartPoint, 0),
+	}
+
+	// History is ordered by time, so consecutive snapshots share a bucket
+	for _, snapshot := range history {
+		bucket := snapshot.LastUpdated.Truncate(interval)
+		n := len(chart.Points)
+		if n == 0 || !chart.Points[n-1].Time.Equal(bucket) {
+			chart.Points = append(chart.Points, models.ChartPoint{Time: bucket})
+			n++
+		}
+
+		point := &chart.Points[n-1]
+		point.TotalAllowance = snapshot.TotalAllowance
+		point.Used = snapshot.OrgTotalUsed
+		point.Remaining = snapshot.Remaining
+		point.UsedRatio = snapshot.UsedRatio
+		point.Samples++
+	}
+
+	return chart, nil
+}
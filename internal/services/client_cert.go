@@ -0,0 +1,74 @@
+package services
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+)
+
+// clientCertUserPrefix marks the user name of callers authenticated by a
+// client certificate, e.g. "cert:billing", so the audit log tells them apart
+// from named users
+const clientCertUserPrefix = "cert:"
+
+// ParseClientCertScopes parses the client certificate identities allowed to
+// call the API and the scopes each gets, such as
+// "billing=read;spiffe://prod/ns/ops/sa/rotator=read write". An identity is
+// a URI, DNS name or email address SAN or the subject common name.
+func ParseClientCertScopes(spec string) (map[string][]string, error) {
+	identities := make(map[string][]string)
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		// Scopes never contain "=", URIs may
+		i := strings.LastIndex(entry, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid client certificate %q: expected identity=scopes", entry)
+		}
+		identity := strings.TrimSpace(entry[:i])
+		scopes, err := ParseScopes(entry[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid client certificate %q: %w", entry, err)
+		}
+		// No scopes would mean unrestricted, which has to be asked for
+		if len(scopes) == 0 {
+			return nil, fmt.Errorf("invalid client certificate %q: no scopes", entry)
+		}
+		identities[identity] = scopes
+	}
+	return identities, nil
+}
+
+// ConfigureClientCerts lets callers presenting a verified client certificate
+// whose identity is in identities use the API with the mapped scopes
+func (s *AuthService) ConfigureClientCerts(identities map[string][]string) {
+	s.clientCerts = identities
+}
+
+// ClientCertPrincipal returns who the client certificate of a TLS
+// connection authenticates. Only certificates the server verified against
+// its client CA count; the SANs are tried before the common name.
+func (s *AuthService) ClientCertPrincipal(state *tls.ConnectionState) (Principal, bool) {
+	if state == nil || len(s.clientCerts) == 0 || len(state.VerifiedChains) == 0 {
+		return Principal{}, false
+	}
+	cert := state.VerifiedChains[0][0]
+
+	var identities []string
+	for _, uri := range cert.URIs {
+		identities = append(identities, uri.String())
+	}
+	identities = append(identities, cert.DNSNames...)
+	identities = append(identities, cert.EmailAddresses...)
+	identities = append(identities, cert.Subject.CommonName)
+
+	for _, identity := range identities {
+		if scopes, ok := s.clientCerts[identity]; ok && identity != "" {
+			return Principal{User: clientCertUserPrefix + identity, Role: RoleAdmin, Scopes: scopes}, true
+		}
+	}
+	return Principal{}, false
+}
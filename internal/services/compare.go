@@ -0,0 +1,113 @@
+package services
+
+import (
+	"errors"
+	"time"
+
+	"github.com/droid-keyusage-go/internal/models"
+	"github.com/droid-keyusage-go/internal/storage"
+)
+
+// ErrUnknownPeriod is returned for unsupported comparison periods
+var ErrUnknownPeriod = errors.New("unknown period")
+
+// comparePeriods maps period names to their rolling length
+var comparePeriods = map[string]time.Duration{
+	"day":   24 * time.Hour,
+	"week":  7 * 24 * time.Hour,
+	"month": 30 * 24 * time.Hour,
+}
+
+// ComparePeriods compares usage in the current rolling period with the one
+// before it, per key and overall, using the stored usage history of the keys
+// p may see
+func (s *APIKeyService) ComparePeriods(period string, p Principal) (*models.PeriodComparison, error) {
+	length, ok := comparePeriods[period]
+	if !ok {
+		return nil, ErrUnknownPeriod
+	}
+
+	keys, err := s.store.GetAllAPIKeys()
+	if err != nil {
+		return nil, err
+	}
+	keys = s.visibleKeys(keys, p)
+
+	now := time.Now()
+	currentStart := now.Add(-length)
+	previousStart := currentStart.Add(-length)
+
+	ids := make([]string, len(keys))
+	before := make(map[string]time.Time, len(keys))
+	for i, key := range keys {
+		ids[i] = key.ID
+		before[key.ID] = previousStart
+	}
+
+	histories, err := s.store.BatchGetHistory(ids, previousStart, now)
+	if err != nil {
+		return nil, err
+	}
+	baselines, err := s.store.LatestSnapshotsBefore(before)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &models.PeriodComparison{
+		Period:   period,
+		Current:  models.PeriodRange{From: currentStart, To: now},
+		Previous: models.PeriodRange{From: previousStart, To: currentStart},
+		Keys:     make([]*models.KeyPeriodComparison, 0, len(keys)),
+	}
+
+	var currentTotal, previousTotal float64
+	for _, key := range keys {
+		current, previous := splitUsage(baselines[key.ID], histories[key.ID], currentStart)
+		currentTotal += current
+		previousTotal += previous
+
+		result.Keys = append(result.Keys, &models.KeyPeriodComparison{
+			ID:          key.ID,
+			Name:        key.Name,
+			PeriodDelta: newPeriodDelta(current, previous),
+		})
+	}
+	result.Overall = newPeriodDelta(currentTotal, previousTotal)
+
+	return result, nil
+}
+
+// splitUsage sums the usage increments between consecutive snapshots into the
+// period each increment ended in; a drop in usage means the billing period
+// reset, so the new value counts in full
+func splitUsage(baseline *storage.Usage, history []*storage.Usage, boundary time.Time) (current, previous float64) {
+	prev := baseline
+	for _, snapshot := range history {
+		if prev != nil {
+			increment := snapshot.OrgTotalUsed - prev.OrgTotalUsed
+			if increment < 0 || snapshot.StartDate != prev.StartDate {
+				increment = snapshot.OrgTotalUsed
+			}
+			if snapshot.LastUpdated.Before(boundary) {
+				previous += increment
+			} else {
+				current += increment
+			}
+		}
+		prev = snapshot
+	}
+	return current, previous
+}
+
+func newPeriodDelta(current, previous float64) models.PeriodDelta {
+	delta := models.PeriodDelta{
+		Current:  current,
+		Previous: previous,
+		Change:   current - previous,
+	}
+	if previous > 0 {
+		ratio := (current - previous) / previous
+		delta.ChangeRatio = &ratio
+	}
+	return delta
+}
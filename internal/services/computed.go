@@ -0,0 +1,559 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/droid-keyusage-go/internal/models"
+)
+
+// maxComputedFields caps how many computed fields can be defined
+const maxComputedFields = 20
+
+// ComputedVariables are the usage values computed fields can refer to.
+// Values that are unknown for a key, such as deltas without enough
+// history, are null and make the expressions using them null.
+var ComputedVariables = []string{
+	"total_allowance", "used", "remaining", "used_ratio", "latency_ms",
+	"used_delta_1h", "used_delta_24h", "burn_rate", "days_left_in_period",
+	"disabled",
+}
+
+var computedNamePattern = regexp.MustCompile(`^[a-z_][a-z0-9_]{0,63}$`)
+
+// ComputedField is a named expression evaluated for every key in /api/data
+type ComputedField struct {
+	Name string
+	Expr string
+	node exprNode
+}
+
+// ParseComputedFields parses definitions such as
+// "critical = remaining < total_allowance * 0.05; days_left = remaining / burn_rate".
+// A field may use the fields defined before it.
+func ParseComputedFields(spec string) ([]ComputedField, error) {
+	var fields []ComputedField
+	builtin := make(map[string]bool, len(ComputedVariables))
+	known := make(map[string]bool, len(ComputedVariables))
+	for _, v := range ComputedVariables {
+		builtin[v] = true
+		known[v] = true
+	}
+
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		name, expr, ok := strings.Cut(entry, "=")
+		name, expr = strings.TrimSpace(name), strings.TrimSpace(expr)
+		if !ok || expr == "" {
+			return nil, fmt.Errorf("invalid computed field %q: expected name = expression", entry)
+		}
+		if !computedNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("invalid computed field name %q", name)
+		}
+		if builtin[name] {
+			return nil, fmt.Errorf("computed field %q would hide the variable of that name", name)
+		}
+		if known[name] {
+			return nil, fmt.Errorf("computed field %q is already defined", name)
+		}
+
+		node, err := parseExpr(expr, known)
+		if err != nil {
+			return nil, fmt.Errorf("computed field %q: %w", name, err)
+		}
+		known[name] = true
+		fields = append(fields, ComputedField{Name: name, Expr: expr, node: node})
+	}
+
+	if len(fields) > maxComputedFields {
+		return nil, fmt.Errorf("at most %d computed fields can be defined", maxComputedFields)
+	}
+	return fields, nil
+}
+
+// SetComputedFields replaces the computed fields returned with usage data
+func (s *APIKeyService) SetComputedFields(fields []ComputedField) {
+	s.settingsMu.Lock()
+	s.computed = fields
+	s.settingsMu.Unlock()
+}
+
+// attachComputed evaluates the computed fields for every successful result
+func (s *APIKeyService) attachComputed(results []*models.Usage, now time.Time) {
+	s.settingsMu.RLock()
+	fields := s.computed
+	s.settingsMu.RUnlock()
+	if len(fields) == 0 {
+		return
+	}
+
+	for _, usage := range results {
+		if usage.Error != "" {
+			continue
+		}
+		vars := usageVariables(usage, now)
+		computed := make(map[string]interface{}, len(fields))
+		for _, field := range fields {
+			value := field.node.eval(vars)
+			vars[field.Name] = value
+			computed[field.Name] = value
+		}
+		usage.Computed = computed
+	}
+}
+
+// usageVariables returns the values of ComputedVariables for a key
+func usageVariables(usage *models.Usage, now time.Time) map[string]interface{} {
+	vars := map[string]interface{}{
+		"total_allowance":     usage.TotalAllowance,
+		"used":                usage.OrgTotalUsed,
+		"remaining":           usage.Remaining,
+		"used_ratio":          usage.UsedRatio,
+		"latency_ms":          float64(usage.LatencyMs),
+		"used_delta_1h":       nil,
+		"used_delta_24h":      nil,
+		"burn_rate":           nil,
+		"days_left_in_period": nil,
+		"disabled":            usage.Disabled,
+	}
+
+	// burn_rate is usage per day, from the last day or else the last hour
+	if usage.UsedDelta1h != nil {
+		vars["used_delta_1h"] = *usage.UsedDelta1h
+		vars["burn_rate"] = *usage.UsedDelta1h * 24
+	}
+	if usage.UsedDelta24h != nil {
+		vars["used_delta_24h"] = *usage.UsedDelta24h
+		vars["burn_rate"] = *usage.UsedDelta24h
+	}
+	if end, err := time.Parse("2006-01-02", usage.EndDate); err == nil {
+		vars["days_left_in_period"] = end.AddDate(0, 0, 1).Sub(now).Hours() / 24
+	}
+	return vars
+}
+
+// Expressions are numbers and booleans combined with arithmetic (+ - * / %),
+// comparisons (< <= > >= == !=), logic (&& || !), the conditional
+// operator (c ? a : b) and the functions in exprFuncs. Null (an unknown
+// value, a division by zero or mixing numbers and booleans) propagates.
+
+// exprNode is a compiled expression
+type exprNode interface {
+	eval(vars map[string]interface{}) interface{}
+}
+
+type exprLiteral struct{ value interface{} }
+
+type exprVar struct{ name string }
+
+type exprUnary struct {
+	op      string
+	operand exprNode
+}
+
+type exprBinary struct {
+	op          string
+	left, right exprNode
+}
+
+type exprCond struct {
+	cond, then, otherwise exprNode
+}
+
+type exprCall struct {
+	fn   exprFunc
+	args []exprNode
+}
+
+// exprFunc is a function callable from expressions; it gets numbers or nil
+type exprFunc struct {
+	minArgs, maxArgs int
+	call             func(args []interface{}) interface{}
+}
+
+// exprFuncs are the functions expressions can call
+var exprFuncs = map[string]exprFunc{
+	"min":      {1, -1, func(args []interface{}) interface{} { return foldNumbers(args, math.Min) }},
+	"max":      {1, -1, func(args []interface{}) interface{} { return foldNumbers(args, math.Max) }},
+	"abs":      {1, 1, numberFunc(math.Abs)},
+	"floor":    {1, 1, numberFunc(math.Floor)},
+	"ceil":     {1, 1, numberFunc(math.Ceil)},
+	"round":    {1, 2, roundNumber},
+	"coalesce": {1, -1, coalesce},
+}
+
+func (n exprLiteral) eval(map[string]interface{}) interface{} { return n.value }
+
+func (n exprVar) eval(vars map[string]interface{}) interface{} { return vars[n.name] }
+
+func (n exprUnary) eval(vars map[string]interface{}) interface{} {
+	switch v := n.operand.eval(vars).(type) {
+	case float64:
+		if n.op == "-" {
+			return -v
+		}
+	case bool:
+		if n.op == "!" {
+			return !v
+		}
+	}
+	return nil
+}
+
+func (n exprBinary) eval(vars map[string]interface{}) interface{} {
+	// && and || short-circuit like in Go
+	if n.op == "&&" || n.op == "||" {
+		left, ok := n.left.eval(vars).(bool)
+		if !ok {
+			return nil
+		}
+		if left == (n.op == "||") {
+			return left
+		}
+		right, ok := n.right.eval(vars).(bool)
+		if !ok {
+			return nil
+		}
+		return right
+	}
+
+	left, right := n.left.eval(vars), n.right.eval(vars)
+	if n.op == "==" || n.op == "!=" {
+		if left == nil || right == nil {
+			return nil
+		}
+		return (left == right) == (n.op == "==")
+	}
+
+	a, ok := left.(float64)
+	if !ok {
+		return nil
+	}
+	b, ok := right.(float64)
+	if !ok {
+		return nil
+	}
+	switch n.op {
+	case "+":
+		return finite(a + b)
+	case "-":
+		return finite(a - b)
+	case "*":
+		return finite(a * b)
+	case "/":
+		return finite(a / b)
+	case "%":
+		return finite(math.Mod(a, b))
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	case ">":
+		return a > b
+	case ">=":
+		return a >= b
+	}
+	return nil
+}
+
+func (n exprCond) eval(vars map[string]interface{}) interface{} {
+	cond, ok := n.cond.eval(vars).(bool)
+	switch {
+	case !ok:
+		return nil
+	case cond:
+		return n.then.eval(vars)
+	default:
+		return n.otherwise.eval(vars)
+	}
+}
+
+func (n exprCall) eval(vars map[string]interface{}) interface{} {
+	args := make([]interface{}, len(n.args))
+	for i, arg := range n.args {
+		args[i] = arg.eval(vars)
+	}
+	return n.fn.call(args)
+}
+
+// finite turns infinities and NaN, which JSON cannot carry, into null
+func finite(v float64) interface{} {
+	if math.IsInf(v, 0) || math.IsNaN(v) {
+		return nil
+	}
+	return v
+}
+
+func numberFunc(f func(float64) float64) func([]interface{}) interface{} {
+	return func(args []interface{}) interface{} {
+		v, ok := args[0].(float64)
+		if !ok {
+			return nil
+		}
+		return finite(f(v))
+	}
+}
+
+func foldNumbers(args []interface{}, f func(a, b float64) float64) interface{} {
+	result, ok := args[0].(float64)
+	if !ok {
+		return nil
+	}
+	for _, arg := range args[1:] {
+		v, ok := arg.(float64)
+		if !ok {
+			return nil
+		}
+		result = f(result, v)
+	}
+	return result
+}
+
+// roundNumber rounds to the given number of decimals (default 0)
+func roundNumber(args []interface{}) interface{} {
+	v, ok := args[0].(float64)
+	if !ok {
+		return nil
+	}
+	scale := 1.0
+	if len(args) > 1 {
+		digits, ok := args[1].(float64)
+		if !ok {
+			return nil
+		}
+		scale = math.Pow(10, math.Round(digits))
+	}
+	return finite(math.Round(v*scale) / scale)
+}
+
+// coalesce returns its first non-null argument
+func coalesce(args []interface{}) interface{} {
+	for _, arg := range args {
+		if arg != nil {
+			return arg
+		}
+	}
+	return nil
+}
+
+// exprParser is a recursive descent parser over the tokens of an expression
+type exprParser struct {
+	tokens []string
+	pos    int
+	known  map[string]bool
+}
+
+// parseExpr compiles an expression that may refer to the known names
+func parseExpr(src string, known map[string]bool) (exprNode, error) {
+	tokens, err := tokenizeExpr(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{tokens: tokens, known: known}
+	node, err := p.conditional()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q", p.tokens[p.pos])
+	}
+	return node, nil
+}
+
+func tokenizeExpr(src string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(src); {
+		c := rune(src[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case unicode.IsDigit(c) || c == '.':
+			j := i
+			for j < len(src) && (unicode.IsDigit(rune(src[j])) || src[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, src[i:j])
+			i = j
+		case unicode.IsLetter(c) || c == '_':
+			j := i
+			for j < len(src) && (unicode.IsLetter(rune(src[j])) || unicode.IsDigit(rune(src[j])) || src[j] == '_') {
+				j++
+			}
+			tokens = append(tokens, src[i:j])
+			i = j
+		default:
+			if i+1 < len(src) {
+				switch two := src[i : i+2]; two {
+				case "<=", ">=", "==", "!=", "&&", "||":
+					tokens = append(tokens, two)
+					i += 2
+					continue
+				}
+			}
+			if !strings.ContainsRune("+-*/%<>!?:(),", c) {
+				return nil, fmt.Errorf("unexpected character %q", c)
+			}
+			tokens = append(tokens, string(c))
+			i++
+		}
+	}
+	return tokens, nil
+}
+
+func (p *exprParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *exprParser) expect(token string) error {
+	if p.peek() != token {
+		if p.pos >= len(p.tokens) {
+			return fmt.Errorf("expected %q at end of expression", token)
+		}
+		return fmt.Errorf("expected %q, got %q", token, p.peek())
+	}
+	p.pos++
+	return nil
+}
+
+func (p *exprParser) conditional() (exprNode, error) {
+	cond, err := p.binary(0)
+	if err != nil || p.peek() != "?" {
+		return cond, err
+	}
+	p.pos++
+	then, err := p.conditional()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+	otherwise, err := p.conditional()
+	if err != nil {
+		return nil, err
+	}
+	return exprCond{cond, then, otherwise}, nil
+}
+
+// exprPrecedence lists binary operators from the loosest binding
+var exprPrecedence = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!=", "<", "<=", ">", ">="},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (p *exprParser) binary(level int) (exprNode, error) {
+	if level == len(exprPrecedence) {
+		return p.unary()
+	}
+	left, err := p.binary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.peek()
+		matched := false
+		for _, candidate := range exprPrecedence[level] {
+			matched = matched || op == candidate
+		}
+		if !matched {
+			return left, nil
+		}
+		p.pos++
+		right, err := p.binary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = exprBinary{op, left, right}
+	}
+}
+
+func (p *exprParser) unary() (exprNode, error) {
+	if op := p.peek(); op == "-" || op == "!" {
+		p.pos++
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return exprUnary{op, operand}, nil
+	}
+	return p.primary()
+}
+
+func (p *exprParser) primary() (exprNode, error) {
+	token := p.peek()
+	if token == "" {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	p.pos++
+
+	switch {
+	case token == "(":
+		node, err := p.conditional()
+		if err != nil {
+			return nil, err
+		}
+		return node, p.expect(")")
+	case token == "true" || token == "false":
+		return exprLiteral{token == "true"}, nil
+	case token == "null":
+		return exprLiteral{nil}, nil
+	case unicode.IsDigit(rune(token[0])) || token[0] == '.':
+		v, err := strconv.ParseFloat(token, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", token)
+		}
+		return exprLiteral{v}, nil
+	case unicode.IsLetter(rune(token[0])) || token[0] == '_':
+		if p.peek() == "(" {
+			return p.call(token)
+		}
+		if !p.known[token] {
+			return nil, fmt.Errorf("unknown name %q", token)
+		}
+		return exprVar{token}, nil
+	}
+	return nil, fmt.Errorf("unexpected %q", token)
+}
+
+func (p *exprParser) call(name string) (exprNode, error) {
+	fn, ok := exprFuncs[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %q", name)
+	}
+	p.pos++ // "("
+
+	var args []exprNode
+	for p.peek() != ")" {
+		if len(args) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		arg, err := p.conditional()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	p.pos++ // ")"
+
+	if len(args) < fn.minArgs || (fn.maxArgs >= 0 && len(args) > fn.maxArgs) {
+		return nil, fmt.Errorf("wrong number of arguments to %s", name)
+	}
+	return exprCall{fn, args}, nil
+}
@@ -0,0 +1,65 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/droid-keyusage-go/internal/config"
+)
+
+// configSettleDelay is how long changes to mounted files are collected
+// before they are read; Kubernetes updates a volume with several events
+const configSettleDelay = time.Second
+
+// dirSource reads the settings in mounted directories, such as Kubernetes
+// Secrets and ConfigMaps, one per file (see config.ReadDirs), and reports
+// when they change where the platform can watch files
+type dirSource struct {
+	dirs    []string
+	changes chan struct{}
+	watcher io.Closer
+}
+
+// NewDirSource creates a source reading the files in dirs. Where watching
+// files isn't supported only the periodic re-read picks up changes.
+func NewDirSource(dirs []string) (SecretSource, error) {
+	d := &dirSource{dirs: dirs, changes: make(chan struct{}, 1)}
+	watcher, err := watchDirs(dirs, d.notify)
+	if err != nil {
+		return nil, err
+	}
+	d.watcher = watcher
+	return d, nil
+}
+
+// Fetch reads the files
+func (d *dirSource) Fetch(ctx context.Context) (map[string]string, error) {
+	values, err := config.ReadDirs(d.dirs)
+	if err != nil {
+		return nil, fmt.Errorf("config files: %w", err)
+	}
+	return values, nil
+}
+
+// Changes receives a value after files changed
+func (d *dirSource) Changes() <-chan struct{} {
+	return d.changes
+}
+
+// Close stops watching the files
+func (d *dirSource) Close() error {
+	if d.watcher == nil {
+		return nil
+	}
+	return d.watcher.Close()
+}
+
+// notify reports a change without waiting for it to be read
+func (d *dirSource) notify() {
+	select {
+	case d.changes <- struct{}{}:
+	default:
+	}
+}
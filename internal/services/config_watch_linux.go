@@ -0,0 +1,47 @@
+//go:build linux
+
+package services
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// watchEvents are the inotify events that change the files of a directory;
+// Kubernetes renames its ..data link when it updates a volume
+const watchEvents = unix.IN_CREATE | unix.IN_DELETE | unix.IN_CLOSE_WRITE | unix.IN_MOVED_TO | unix.IN_MOVED_FROM | unix.IN_ATTRIB
+
+// watchDirs calls changed whenever a file in one of dirs changes, until the
+// returned watcher is closed
+func watchDirs(dirs []string, changed func()) (io.Closer, error) {
+	if len(dirs) == 0 {
+		return nil, nil
+	}
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+	for _, dir := range dirs {
+		if _, err := unix.InotifyAddWatch(fd, dir, watchEvents); err != nil {
+			unix.Close(fd)
+			return nil, fmt.Errorf("watch %s: %w", dir, err)
+		}
+	}
+
+	// A non-blocking descriptor uses the runtime poller, so closing the
+	// file ends the pending read
+	events := os.NewFile(uintptr(fd), "inotify")
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			if _, err := events.Read(buf); err != nil {
+				return
+			}
+			changed()
+		}
+	}()
+	return events, nil
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package services
+
+import "io"
+
+// watchDirs doesn't watch files on this platform; changes are picked up by
+// the periodic re-read
+func watchDirs(dirs []string, changed func()) (io.Closer, error) {
+	return nil, nil
+}
@@ -0,0 +1,13 @@
+package services
+
+import (
+	"io"
+	"os"
+
+	"github.com/droid-keyusage-go/internal/utils"
+)
+
+// console receives the progress messages and warnings the services print.
+// Warnings often quote upstream errors, so keys and tokens in them are
+// masked as in the logger.
+var console io.Writer = utils.NewRedactingWriter(os.Stdout)
@@ -0,0 +1,43 @@
+package services
+
+import "context"
+
+// shutdownContext returns a context canceled when shutdown is closed, so
+// background jobs stop their Redis and upstream calls on Stop
+func shutdownContext(shutdown <-chan struct{}) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-shutdown:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// Refresh triggers recorded in the job history
+const (
+	TriggerRequest   = "request"
+	TriggerScheduler = "scheduler"
+	TriggerHeartbeat = "heartbeat"
+	TriggerReport    = "report"
+	TriggerRetry     = "retry"
+	TriggerStartup   = "startup"
+)
+
+type refreshTriggerKey struct{}
+
+// withRefreshTrigger labels the refreshes made with ctx in the job history
+func withRefreshTrigger(ctx context.Context, trigger string) context.Context {
+	return context.WithValue(ctx, refreshTriggerKey{}, trigger)
+}
+
+// refreshTrigger returns what started the refreshes made with ctx; API
+// requests are not labeled
+func refreshTrigger(ctx context.Context) string {
+	if trigger, ok := ctx.Value(refreshTriggerKey{}).(string); ok {
+		return trigger
+	}
+	return TriggerRequest
+}
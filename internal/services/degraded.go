@@ -0,0 +1,112 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/droid-keyusage-go/internal/storage"
+)
+
+// ErrStorageUnavailable is returned when Redis is down and nothing was
+// cached locally to fall back to
+var ErrStorageUnavailable = errors.New("storage unavailable")
+
+// storageRetryInterval is how long requests skip Redis after it failed, so
+// an outage does not make every request wait for connection timeouts
+const storageRetryInterval = 5 * time.Second
+
+// maxPendingUsage bounds the usage writes kept for retry while Redis is
+// unavailable; the oldest are dropped first
+const maxPendingUsage = 100000
+
+// storageState tracks Redis availability for degraded mode
+type storageState struct {
+	mu        sync.Mutex
+	keys      []*storage.APIKey // last key list read from Redis
+	downSince time.Time
+	retryAt   time.Time
+	pending   []*storage.Usage
+}
+
+// loadKeys reads the key list; while Redis is unavailable it falls back to
+// the last list read and reports degraded. A canceled ctx is not an outage
+// and returns its error.
+func (s *APIKeyService) loadKeys(ctx context.Context) (keys []*storage.APIKey, degraded bool, err error) {
+	st := &s.storageState
+	st.mu.Lock()
+	skip := time.Now().Before(st.retryAt)
+	st.mu.Unlock()
+
+	if !skip {
+		keys, err = s.store.WithContext(ctx).GetAllAPIKeys()
+		if err == nil {
+			s.storageRecovered(keys)
+			return keys, false, nil
+		}
+		if ctx.Err() != nil {
+			return nil, false, ctx.Err()
+		}
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if err != nil {
+		if st.downSince.IsZero() {
+			st.downSince = time.Now()
+			fmt.Fprintf(console, "⚠️  Redis 不可用，已切换到降级模式: %v\n", err)
+		}
+		st.retryAt = time.Now().Add(storageRetryInterval)
+	}
+	if st.keys == nil {
+		if err == nil {
+			err = ErrStorageUnavailable
+		}
+		return nil, false, err
+	}
+	return st.keys, true, nil
+}
+
+// storageRecovered remembers the key list and, after an outage, writes the
+// usage queued in the meantime
+func (s *APIKeyService) storageRecovered(keys []*storage.APIKey) {
+	st := &s.storageState
+	st.mu.Lock()
+	st.keys = keys
+	downSince := st.downSince
+	st.downSince = time.Time{}
+	pending := st.pending
+	st.pending = nil
+	st.mu.Unlock()
+
+	if len(pending) == 0 {
+		if !downSince.IsZero() {
+			fmt.Fprintf(console, "✅ Redis 已恢复，降级持续 %s\n", time.Since(downSince).Round(time.Second))
+		}
+		return
+	}
+
+	if err := s.store.BatchSaveUsage(pending, s.cachePolicy(-1).storageTTL(keys)); err != nil {
+		s.queueUsageWrites(pending)
+		return
+	}
+	if err := s.store.BatchAppendHistory(pending); err != nil {
+		fmt.Fprintf(console, "⚠️  补写用量历史失败: %v\n", err)
+	}
+	fmt.Fprintf(console, "✅ Redis 已恢复，已补写 %d 条用量\n", len(pending))
+}
+
+// queueUsageWrites keeps usage that could not be written for the next time
+// Redis is reachable
+func (s *APIKeyService) queueUsageWrites(usages []*storage.Usage) {
+	st := &s.storageState
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.pending = append(st.pending, usages...)
+	if overflow := len(st.pending) - maxPendingUsage; overflow > 0 {
+		st.pending = st.pending[overflow:]
+	}
+}
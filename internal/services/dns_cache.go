@@ -0,0 +1,245 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// Defaults of ConfigureDNS
+const (
+	defaultDNSCacheTTL   = time.Minute
+	defaultFallbackDelay = 300 * time.Millisecond
+	dialTimeout          = 10 * time.Second
+)
+
+// dnsEntry is the cached resolution of one host; done is closed once the
+// lookup filling it has finished, so concurrent dials share one lookup
+type dnsEntry struct {
+	done    chan struct{}
+	ips     []net.IP
+	err     error
+	expires time.Time
+}
+
+// DNSCache resolves upstream hosts once per TTL instead of on every new
+// connection, and dials the resolved addresses with IPv4/IPv6 fallback
+type DNSCache struct {
+	ttl           time.Duration
+	fallbackDelay time.Duration
+	resolver      *net.Resolver
+	dialer        *net.Dialer
+
+	mu      sync.Mutex
+	entries map[string]*dnsEntry
+}
+
+// upstreamDNS is used by the provider HTTP clients to dial the upstream
+var upstreamDNS = NewDNSCache(defaultDNSCacheTTL, defaultFallbackDelay)
+
+// NewDNSCache creates a caching dialer; ttl <= 0 resolves on every dial and
+// fallbackDelay is how long the preferred address family gets before the
+// other one is tried too
+func NewDNSCache(ttl, fallbackDelay time.Duration) *DNSCache {
+	if fallbackDelay <= 0 {
+		fallbackDelay = defaultFallbackDelay
+	}
+	return &DNSCache{
+		ttl:           ttl,
+		fallbackDelay: fallbackDelay,
+		resolver:      net.DefaultResolver,
+		dialer:        &net.Dialer{Timeout: dialTimeout, KeepAlive: 30 * time.Second},
+		entries:       make(map[string]*dnsEntry),
+	}
+}
+
+// ConfigureDNS sets how long upstream host names are cached and the delay
+// before falling back to the other address family; it applies to the
+// clients built by ConfigureProviderClients afterwards
+func ConfigureDNS(ttl, fallbackDelay time.Duration) {
+	upstreamDNS = NewDNSCache(ttl, fallbackDelay)
+}
+
+// DialContext connects to addr, resolving its host through the cache. When
+// every address fails the host is resolved again on the next dial.
+func (d *DNSCache) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || net.ParseIP(host) != nil {
+		return d.dialer.DialContext(ctx, network, addr)
+	}
+
+	ips, err := d.lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	primary, fallback := splitAddressFamilies(ips)
+	conn, err := d.dialParallel(ctx, network, primary, fallback, port)
+	if err != nil {
+		d.forget(host)
+	}
+	return conn, err
+}
+
+// lookup returns the addresses of host, from the cache while they are fresh
+func (d *DNSCache) lookup(ctx context.Context, host string) ([]net.IP, error) {
+	if d.ttl <= 0 {
+		return d.resolve(ctx, host)
+	}
+
+	d.mu.Lock()
+	entry, ok := d.entries[host]
+	if ok {
+		select {
+		case <-entry.done:
+			if time.Now().After(entry.expires) {
+				ok = false
+			}
+		default:
+		}
+	}
+	if !ok {
+		entry = &dnsEntry{done: make(chan struct{})}
+		d.entries[host] = entry
+		d.mu.Unlock()
+
+		// The lookup is shared, so it must not end with the dial that started it
+		resolveCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), dialTimeout)
+		entry.ips, entry.err = d.resolve(resolveCtx, host)
+		cancel()
+		// Failures are not cached, the next dial resolves again
+		entry.expires = time.Now().Add(d.ttl)
+		if entry.err != nil {
+			entry.expires = time.Time{}
+		}
+		close(entry.done)
+		return entry.ips, entry.err
+	}
+	d.mu.Unlock()
+
+	select {
+	case <-entry.done:
+		return entry.ips, entry.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// resolve looks host up without the cache
+func (d *DNSCache) resolve(ctx context.Context, host string) ([]net.IP, error) {
+	addrs, err := d.resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	ips := make([]net.IP, len(addrs))
+	for i, addr := range addrs {
+		ips[i] = addr.IP
+	}
+	return ips, nil
+}
+
+// forget drops the cached addresses of host
+func (d *DNSCache) forget(host string) {
+	d.mu.Lock()
+	if entry, ok := d.entries[host]; ok {
+		select {
+		case <-entry.done:
+			delete(d.entries, host)
+		default:
+		}
+	}
+	d.mu.Unlock()
+}
+
+// splitAddressFamilies splits ips into those of the family of the first
+// address, which the resolver prefers, and those of the other family
+func splitAddressFamilies(ips []net.IP) (primary, fallback []net.IP) {
+	for _, ip := range ips {
+		if (ip.To4() != nil) == (ips[0].To4() != nil) {
+			primary = append(primary, ip)
+		} else {
+			fallback = append(fallback, ip)
+		}
+	}
+	return primary, fallback
+}
+
+// dialResult is the outcome of one of the racing dials
+type dialResult struct {
+	conn    net.Conn
+	err     error
+	primary bool
+}
+
+// dialParallel dials the primary addresses and, fallbackDelay later or as
+// soon as they fail, the fallback ones too; the first connection wins
+// ("Happy Eyeballs", RFC 8305)
+func (d *DNSCache) dialParallel(ctx context.Context, network string, primary, fallback []net.IP, port string) (net.Conn, error) {
+	if len(fallback) == 0 {
+		return d.dialSerial(ctx, network, primary, port)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan dialResult)
+	race := func(ips []net.IP, isPrimary bool) {
+		conn, err := d.dialSerial(ctx, network, ips, port)
+		select {
+		case results <- dialResult{conn: conn, err: err, primary: isPrimary}:
+		case <-ctx.Done():
+			if conn != nil {
+				conn.Close()
+			}
+		}
+	}
+	go race(primary, true)
+
+	fallbackTimer := time.NewTimer(d.fallbackDelay)
+	defer fallbackTimer.Stop()
+
+	var primaryErr, fallbackErr error
+	fallbackStarted := false
+	for {
+		select {
+		case <-fallbackTimer.C:
+			if !fallbackStarted {
+				fallbackStarted = true
+				go race(fallback, false)
+			}
+		case res := <-results:
+			if res.err == nil {
+				return res.conn, nil
+			}
+			if res.primary {
+				primaryErr = res.err
+			} else {
+				fallbackErr = res.err
+			}
+			if primaryErr != nil && fallbackErr != nil {
+				return nil, primaryErr
+			}
+			if !fallbackStarted {
+				fallbackStarted = true
+				go race(fallback, false)
+			}
+		}
+	}
+}
+
+// dialSerial tries each address in turn until one connects
+func (d *DNSCache) dialSerial(ctx context.Context, network string, ips []net.IP, port string) (net.Conn, error) {
+	err := errors.New("no addresses to dial")
+	for _, ip := range ips {
+		var conn net.Conn
+		conn, err = d.dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+	}
+	return nil, err
+}
@@ -0,0 +1,152 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/droid-keyusage-go/internal/storage"
+	"github.com/google/uuid"
+)
+
+// Event types published on the event bus
+const (
+	EventKeyAdded         = "key.added"
+	EventKeyDeleted       = "key.deleted"
+	EventRefreshCompleted = "refresh.completed"
+	EventAlertFired       = "alert.fired"
+	EventCacheInvalidate  = "cache.invalidate"
+	EventSettingsChanged  = "settings.changed"
+)
+
+// eventChannelPrefix namespaces event bus channels; the event type is appended
+const eventChannelPrefix = "droid:events:"
+
+// Event is an internal event shared with other replicas and sibling services
+type Event struct {
+	ID     string                 `json:"id"`
+	Type   string                 `json:"type"`
+	Source string                 `json:"source"`
+	Time   time.Time              `json:"time"`
+	KeyIDs []string               `json:"key_ids,omitempty"`
+	Data   map[string]interface{} `json:"data,omitempty"`
+}
+
+// EventHandler reacts to an event received from another instance
+type EventHandler func(event *Event)
+
+// EventBus publishes events over Redis pub/sub on "droid:events:<type>"
+// channels and dispatches events published by other instances to subscribers
+type EventBus struct {
+	store      *storage.Storage
+	instanceID string
+	handlers   map[string][]EventHandler
+	mu         sync.RWMutex
+	cancel     context.CancelFunc
+	wg         sync.WaitGroup
+}
+
+// NewEventBus creates an event bus with a unique instance ID
+func NewEventBus(store *storage.Storage) *EventBus {
+	return &EventBus{
+		store:      store,
+		instanceID: uuid.New().String(),
+		handlers:   make(map[string][]EventHandler),
+	}
+}
+
+// InstanceID identifies this process as the source of published events
+func (b *EventBus) InstanceID() string {
+	return b.instanceID
+}
+
+// Publish broadcasts an event; it is a no-op on a nil bus
+func (b *EventBus) Publish(eventType string, keyIDs []string, data map[string]interface{}) {
+	if b == nil {
+		return
+	}
+
+	event := &Event{
+		ID:     uuid.New().String(),
+		Type:   eventType,
+		Source: b.instanceID,
+		Time:   time.Now(),
+		KeyIDs: keyIDs,
+		Data:   data,
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	if err := b.store.Publish(eventChannelPrefix+eventType, payload); err != nil {
+		fmt.Fprintf(console, "⚠️  发布事件失败 (%s): %v\n", eventType, err)
+	}
+}
+
+// Subscribe registers a handler for events of the given type ("*" for all)
+// published by other instances
+func (b *EventBus) Subscribe(eventType string, handler EventHandler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers[eventType] = append(b.handlers[eventType], handler)
+}
+
+// Start listens for events from other instances in the background
+func (b *EventBus) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	b.cancel = cancel
+
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		for {
+			err := b.store.Subscribe(ctx, eventChannelPrefix+"*", b.dispatch)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				fmt.Fprintf(console, "⚠️  事件订阅中断，稍后重试: %v\n", err)
+			}
+
+			select {
+			case <-time.After(5 * time.Second):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops listening for events
+func (b *EventBus) Stop() {
+	if b.cancel != nil {
+		b.cancel()
+	}
+	b.wg.Wait()
+}
+
+// dispatch decodes a pub/sub message and hands it to subscribers
+func (b *EventBus) dispatch(channel string, payload []byte) {
+	var event Event
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return
+	}
+	if event.Source == b.instanceID {
+		return
+	}
+	if event.Type == "" {
+		event.Type = strings.TrimPrefix(channel, eventChannelPrefix)
+	}
+
+	b.mu.RLock()
+	handlers := append(append([]EventHandler{}, b.handlers[event.Type]...), b.handlers["*"]...)
+	b.mu.RUnlock()
+
+	for _, handler := range handlers {
+		handler(&event)
+	}
+}
@@ -0,0 +1,106 @@
+package services
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/droid-keyusage-go/internal/storage"
+)
+
+// ExpiryService sends reminders for keys approaching their expiry date
+type ExpiryService struct {
+	store         *storage.Storage
+	notifier      *NotificationService
+	interval      time.Duration
+	reminderAhead time.Duration
+	shutdown      chan struct{}
+	wg            sync.WaitGroup
+}
+
+// NewExpiryService creates an expiry reminder service
+func NewExpiryService(store *storage.Storage, notifier *NotificationService, interval time.Duration, reminderDays int) *ExpiryService {
+	return &ExpiryService{
+		store:         store,
+		notifier:      notifier,
+		interval:      interval,
+		reminderAhead: time.Duration(reminderDays) * 24 * time.Hour,
+		shutdown:      make(chan struct{}),
+	}
+}
+
+// Start launches the background reminder job
+func (s *ExpiryService) Start() {
+	if s.interval <= 0 || s.reminderAhead <= 0 {
+		return
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.CheckExpiringKeys()
+
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				s.CheckExpiringKeys()
+			case <-s.shutdown:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the background reminder job
+func (s *ExpiryService) Stop() {
+	close(s.shutdown)
+	s.wg.Wait()
+}
+
+// CheckExpiringKeys notifies once for every key expiring within the reminder window
+func (s *ExpiryService) CheckExpiringKeys() {
+	if !s.notifier.Enabled() {
+		return
+	}
+
+	keys, err := s.store.GetAllAPIKeys()
+	if err != nil {
+		fmt.Fprintf(console, "⚠️  检查即将过期的 Key 失败: %v\n", err)
+		return
+	}
+
+	now := time.Now()
+	for _, key := range keys {
+		if key.ExpiresAt == nil || key.Disabled || key.IsExpired(now) {
+			continue
+		}
+
+		untilExpiry := key.ExpiresAt.Sub(now)
+		if untilExpiry > s.reminderAhead {
+			continue
+		}
+
+		// Remember the reminder until shortly after expiry so it is sent only once
+		first, err := s.store.MarkExpiryReminded(key.ID, untilExpiry+24*time.Hour)
+		if err != nil || !first {
+			continue
+		}
+
+		err = s.notifier.Notify(&Notification{
+			Event:   "key.expiring",
+			Title:   "API Key expiring soon",
+			Message: fmt.Sprintf("%s expires on %s", key.Name, key.ExpiresAt.Format("2006-01-02 15:04")),
+			KeyID:   key.ID,
+			Data: map[string]interface{}{
+				"expires_at": key.ExpiresAt,
+				"days_left":  int(untilExpiry.Hours() / 24),
+			},
+		})
+		if err != nil {
+			fmt.Fprintf(console, "⚠️  发送过期提醒失败 (%s): %v\n", key.ID, err)
+		}
+	}
+}
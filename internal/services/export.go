@@ -0,0 +1,76 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/droid-keyusage-go/internal/models"
+)
+
+// ExportFullKeys returns full keys with their latest status, filtered by
+// status, group, tag and remaining balance
+func (s *APIKeyService) ExportFullKeys(ctx context.Context, filter *models.ExportKeysRequest) ([]*models.ExportedKey, error) {
+	keys, err := s.store.WithContext(ctx).GetAllAPIKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	// Use the latest (possibly cached) usage to classify keys
+	data, err := s.GetAggregatedData(ctx, adminPrincipal)
+	if err != nil {
+		return nil, err
+	}
+	usages := make(map[string]*models.Usage, len(data.Data))
+	for _, usage := range data.Data {
+		usages[usage.ID] = usage
+	}
+
+	statuses := make(map[string]bool, len(filter.Status))
+	for _, status := range filter.Status {
+		statuses[status] = true
+	}
+
+	now := time.Now()
+	exported := make([]*models.ExportedKey, 0, len(keys))
+	for _, key := range keys {
+		usage := usages[key.ID]
+		if usage == nil {
+			continue
+		}
+
+		status := usageStatus(key, usage, now)
+		switch {
+		case len(statuses) > 0 && !statuses[status]:
+			continue
+		case filter.Group != "" && key.Group != filter.Group:
+			continue
+		case filter.Tag != "" && !hasTag(key.Tags, filter.Tag):
+			continue
+		case filter.MinRemaining != nil && usage.Remaining < *filter.MinRemaining:
+			continue
+		case filter.MaxRemaining != nil && usage.Remaining > *filter.MaxRemaining:
+			continue
+		}
+
+		exported = append(exported, &models.ExportedKey{
+			ID:        key.ID,
+			Name:      key.Name,
+			Key:       key.Key,
+			Group:     key.Group,
+			Tags:      key.Tags,
+			Status:    status,
+			Remaining: usage.Remaining,
+		})
+	}
+
+	return exported, nil
+}
+
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
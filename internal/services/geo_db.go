@@ -0,0 +1,203 @@
+package services
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// GeoInfo is what the local GeoIP databases know about an IP address
+type GeoInfo struct {
+	// Country is the ISO 3166-1 alpha-2 code, e.g. "US"
+	Country     string
+	CountryName string
+	City        string
+	ASN         uint
+	ASOrg       string
+}
+
+// Location describes the place as "city, country", or "" when unknown
+func (i GeoInfo) Location() string {
+	parts := make([]string, 0, 2)
+	for _, part := range []string{i.City, i.CountryName} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// String describes the country and network for logs, e.g. "US AS15169"
+func (i GeoInfo) String() string {
+	parts := make([]string, 0, 2)
+	if i.Country != "" {
+		parts = append(parts, i.Country)
+	}
+	if i.ASN != 0 {
+		parts = append(parts, "AS"+strconv.FormatUint(uint64(i.ASN), 10))
+	}
+	return strings.Join(parts, " ")
+}
+
+// GeoDB resolves IP addresses to their country and autonomous system with
+// local MaxMind DB files, without sending them anywhere
+type GeoDB struct {
+	dbs []*mmdbReader
+}
+
+// OpenGeoDB opens a country or city database and an ASN database, either of
+// which may be empty; a single file holding both kinds, like IPinfo's,
+// works too. It returns nil when both are empty, which disables lookups.
+func OpenGeoDB(locationPath, asnPath string) (*GeoDB, error) {
+	g := &GeoDB{}
+	for _, path := range []string{locationPath, asnPath} {
+		if path == "" {
+			continue
+		}
+		db, err := openMMDB(path)
+		if err != nil {
+			return nil, err
+		}
+		g.dbs = append(g.dbs, db)
+	}
+	if len(g.dbs) == 0 {
+		return nil, nil
+	}
+	return g, nil
+}
+
+// Lookup returns what the databases know about an IP; private and loopback
+// addresses are not looked up
+func (g *GeoDB) Lookup(ip string) GeoInfo {
+	var info GeoInfo
+	parsed := net.ParseIP(ip)
+	if g == nil || parsed == nil || parsed.IsLoopback() || parsed.IsPrivate() || parsed.IsUnspecified() {
+		return info
+	}
+	for _, db := range g.dbs {
+		record, err := db.lookup(parsed)
+		if err != nil {
+			fmt.Fprintf(console, "⚠️  查询 GeoIP 数据库失败 (%s): %v\n", ip, err)
+			continue
+		}
+		mergeGeoRecord(&info, record)
+	}
+	return info
+}
+
+// mergeGeoRecord fills the fields info is missing from a database record.
+// GeoIP2/GeoLite2 and DB-IP records nest names by language, e.g.
+// country.iso_code and city.names.en; IPinfo records are flat, with
+// country, country_name, asn ("AS15169") and as_name.
+func mergeGeoRecord(info *GeoInfo, record map[string]interface{}) {
+	set := func(field *string, value string) {
+		if *field == "" {
+			*field = value
+		}
+	}
+	for _, name := range []string{"country", "registered_country"} {
+		switch country := record[name].(type) {
+		case map[string]interface{}:
+			code, _ := country["iso_code"].(string)
+			set(&info.Country, code)
+			set(&info.CountryName, geoName(country))
+		case string:
+			set(&info.Country, country)
+		}
+	}
+	if name, ok := record["country_name"].(string); ok {
+		set(&info.CountryName, name)
+	}
+	switch city := record["city"].(type) {
+	case map[string]interface{}:
+		set(&info.City, geoName(city))
+	case string:
+		set(&info.City, city)
+	}
+
+	if asn, ok := record["autonomous_system_number"].(uint64); ok && info.ASN == 0 {
+		info.ASN = uint(asn)
+	}
+	if asn, ok := record["asn"].(string); ok && info.ASN == 0 {
+		n, _ := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(asn), "AS"), 10, 32)
+		info.ASN = uint(n)
+	}
+	for _, name := range []string{"autonomous_system_organization", "as_name"} {
+		if org, ok := record[name].(string); ok {
+			set(&info.ASOrg, org)
+		}
+	}
+}
+
+// geoName returns the English name of a place record
+func geoName(place map[string]interface{}) string {
+	names, _ := place["names"].(map[string]interface{})
+	name, _ := names["en"].(string)
+	return name
+}
+
+// CountryPolicy limits the countries the admin surface may be used from.
+// Private and loopback addresses are always allowed; with an allow list,
+// public addresses of unknown country are not.
+type CountryPolicy struct {
+	geo     *GeoDB
+	allowed map[string]bool
+	blocked map[string]bool
+}
+
+// NewCountryPolicy creates a policy from comma-separated country codes such
+// as "DE,FR"; it returns nil when both lists are empty, which allows every
+// country
+func NewCountryPolicy(geo *GeoDB, allowed, blocked string) (*CountryPolicy, error) {
+	p := &CountryPolicy{geo: geo}
+	var err error
+	if p.allowed, err = parseCountries(allowed); err != nil {
+		return nil, err
+	}
+	if p.blocked, err = parseCountries(blocked); err != nil {
+		return nil, err
+	}
+	if len(p.allowed) == 0 && len(p.blocked) == 0 {
+		return nil, nil
+	}
+	if geo == nil {
+		return nil, fmt.Errorf("country restrictions need a GeoIP database (GEOIP_DB)")
+	}
+	return p, nil
+}
+
+// parseCountries parses comma-separated ISO 3166-1 alpha-2 codes
+func parseCountries(spec string) (map[string]bool, error) {
+	countries := make(map[string]bool)
+	for _, code := range strings.Split(spec, ",") {
+		code = strings.ToUpper(strings.TrimSpace(code))
+		if code == "" {
+			continue
+		}
+		if len(code) != 2 || code[0] < 'A' || code[0] > 'Z' || code[1] < 'A' || code[1] > 'Z' {
+			return nil, fmt.Errorf("invalid country code %q: expected two letters such as US", code)
+		}
+		countries[code] = true
+	}
+	return countries, nil
+}
+
+// Allows reports whether requests from ip may use the admin surface, and
+// returns the country it is in
+func (p *CountryPolicy) Allows(ip string) (string, bool) {
+	if p == nil {
+		return "", true
+	}
+	if parsed := net.ParseIP(ip); parsed != nil && (parsed.IsLoopback() || parsed.IsPrivate()) {
+		return "", true
+	}
+	country := p.geo.Lookup(ip).Country
+	if p.blocked[country] {
+		return country, false
+	}
+	if len(p.allowed) > 0 && !p.allowed[country] {
+		return country, false
+	}
+	return country, true
+}
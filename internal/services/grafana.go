@@ -0,0 +1,193 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/droid-keyusage-go/internal/models"
+	"github.com/droid-keyusage-go/internal/storage"
+)
+
+// ErrUnknownMetric is returned for Grafana targets naming an unknown metric
+var ErrUnknownMetric = errors.New("unknown metric")
+
+// GrafanaMetrics are the usage fields Grafana can chart
+var GrafanaMetrics = []string{"used", "remaining", "total_allowance", "used_ratio"}
+
+// grafanaTarget is a parsed Grafana target: "<metric>" sums every visible
+// key, "<metric>:<key id>" selects one key and "<metric>:group:<name>" the
+// keys of a group
+type grafanaTarget struct {
+	metric string
+	keyID  string
+	group  string
+	// grouped is set for group targets, whose group may be empty
+	grouped bool
+}
+
+func parseGrafanaTarget(target string) (grafanaTarget, error) {
+	metric, selector, _ := strings.Cut(strings.TrimSpace(target), ":")
+	known := false
+	for _, m := range GrafanaMetrics {
+		known = known || m == metric
+	}
+	if !known {
+		return grafanaTarget{}, ErrUnknownMetric
+	}
+
+	t := grafanaTarget{metric: metric}
+	if group, ok := strings.CutPrefix(selector, "group:"); ok {
+		t.group, t.grouped = group, true
+	} else {
+		t.keyID = selector
+	}
+	return t, nil
+}
+
+// SearchGrafanaTargets lists the targets Grafana may query: each metric
+// summed over all visible keys, per group and per key
+func (s *APIKeyService) SearchGrafanaTargets(ctx context.Context, query string, p Principal) ([]models.GrafanaTarget, error) {
+	keys, err := s.store.WithContext(ctx).GetAllAPIKeys()
+	if err != nil {
+		return nil, err
+	}
+	keys = s.visibleKeys(keys, p)
+
+	groups := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, key := range keys {
+		if key.Group != "" && !seen[key.Group] {
+			seen[key.Group] = true
+			groups = append(groups, key.Group)
+		}
+	}
+
+	query = strings.ToLower(strings.TrimSpace(query))
+	targets := make([]models.GrafanaTarget, 0)
+	add := func(text, value string) {
+		if query == "" || strings.Contains(strings.ToLower(text), query) {
+			targets = append(targets, models.GrafanaTarget{Text: text, Value: value})
+		}
+	}
+	for _, metric := range GrafanaMetrics {
+		add(metric, metric)
+		for _, group := range groups {
+			add(metric+" · "+group, metric+":group:"+group)
+		}
+		for _, key := range keys {
+			add(metric+" · "+key.Name, metric+":"+key.ID)
+		}
+	}
+	return targets, nil
+}
+
+// GrafanaSeries returns a target as a time series over [from, to] in
+// buckets of interval. Each key contributes its last snapshot up to the
+// end of every bucket, so keys refreshed at different moments still add
+// up; used_ratio is the ratio of the summed values.
+func (s *APIKeyService) GrafanaSeries(ctx context.Context, target string, from, to time.Time, interval time.Duration, p Principal) (*models.GrafanaSeries, error) {
+	t, err := parseGrafanaTarget(target)
+	if err != nil {
+		return nil, err
+	}
+	if to.Sub(from)/interval > MaxChartPoints {
+		interval = to.Sub(from) / MaxChartPoints
+	}
+
+	store := s.store.WithContext(ctx)
+	keys, err := store.GetAllAPIKeys()
+	if err != nil {
+		return nil, err
+	}
+	keys = grafanaKeys(s.visibleKeys(keys, p), t)
+	if t.keyID != "" && len(keys) == 0 {
+		return nil, ErrKeyNotFound
+	}
+
+	ids := make([]string, len(keys))
+	before := make(map[string]time.Time, len(keys))
+	for i, key := range keys {
+		ids[i] = key.ID
+		before[key.ID] = from
+	}
+	baselines, err := store.LatestSnapshotsBefore(before)
+	if err != nil {
+		return nil, err
+	}
+
+	start := from.Truncate(interval)
+	buckets := int(to.Sub(start)/interval) + 1
+	used := make([]float64, buckets)
+	allowance := make([]float64, buckets)
+	remaining := make([]float64, buckets)
+	known := make([]bool, buckets)
+
+	for _, chunk := range chunkIDs(ids, s.batchSize) {
+		histories, err := store.BatchGetHistory(chunk, from, to)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range chunk {
+			// Walk the buckets, carrying the key's latest snapshot forward
+			last := baselines[id]
+			history := histories[id]
+			next := 0
+			for b := 0; b < buckets; b++ {
+				end := start.Add(time.Duration(b+1) * interval)
+				for next < len(history) && history[next].LastUpdated.Before(end) {
+					last = history[next]
+					next++
+				}
+				if last == nil {
+					continue
+				}
+				used[b] += last.OrgTotalUsed
+				allowance[b] += last.TotalAllowance
+				remaining[b] += last.Remaining
+				known[b] = true
+			}
+		}
+	}
+
+	series := &models.GrafanaSeries{
+		Target:     target,
+		Datapoints: make([][2]float64, 0, buckets),
+	}
+	for b := 0; b < buckets; b++ {
+		if !known[b] {
+			continue
+		}
+		var value float64
+		switch t.metric {
+		case "used":
+			value = used[b]
+		case "remaining":
+			value = remaining[b]
+		case "total_allowance":
+			value = allowance[b]
+		case "used_ratio":
+			if allowance[b] > 0 {
+				value = used[b] / allowance[b]
+			}
+		}
+		ms := start.Add(time.Duration(b) * interval).UnixMilli()
+		series.Datapoints = append(series.Datapoints, [2]float64{value, float64(ms)})
+	}
+	return series, nil
+}
+
+// grafanaKeys narrows keys to those a target selects
+func grafanaKeys(keys []*storage.APIKey, t grafanaTarget) []*storage.APIKey {
+	if t.keyID == "" && !t.grouped {
+		return keys
+	}
+	selected := make([]*storage.APIKey, 0)
+	for _, key := range keys {
+		if (t.grouped && key.Group == t.group) || (!t.grouped && key.ID == t.keyID) {
+			selected = append(selected, key)
+		}
+	}
+	return selected
+}
@@ -0,0 +1,200 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/droid-keyusage-go/internal/models"
+	"github.com/droid-keyusage-go/internal/storage"
+)
+
+// HealthService disables keys that keep failing and re-enables them once
+// they start working again
+type HealthService struct {
+	store       *storage.Storage
+	apiKeys     *APIKeyService
+	workerPool  *WorkerPool
+	notifier    *NotificationService
+	maxFailures int
+	interval    time.Duration
+	mu          sync.RWMutex
+	shutdown    chan struct{}
+	wg          sync.WaitGroup
+}
+
+// NewHealthService creates a key health service; maxFailures <= 0 turns off
+// auto-disabling and interval <= 0 turns off the re-check job
+func NewHealthService(store *storage.Storage, apiKeys *APIKeyService, workerPool *WorkerPool, notifier *NotificationService, maxFailures int, interval time.Duration) *HealthService {
+	return &HealthService{
+		store:       store,
+		apiKeys:     apiKeys,
+		workerPool:  workerPool,
+		notifier:    notifier,
+		maxFailures: maxFailures,
+		interval:    interval,
+		shutdown:    make(chan struct{}),
+	}
+}
+
+// SetMaxFailures changes the number of consecutive failures that disables a
+// key; 0 turns off auto-disabling
+func (s *HealthService) SetMaxFailures(maxFailures int) {
+	s.mu.Lock()
+	s.maxFailures = maxFailures
+	s.mu.Unlock()
+}
+
+// Track updates the consecutive failure count of every refreshed key and
+// disables keys that reach the limit; it is registered as a refresh hook
+func (s *HealthService) Track(keys []*storage.APIKey, results []*models.Usage) {
+	s.mu.RLock()
+	maxFailures := s.maxFailures
+	s.mu.RUnlock()
+	if maxFailures <= 0 {
+		return
+	}
+
+	keyMap := make(map[string]*storage.APIKey, len(keys))
+	for _, key := range keys {
+		keyMap[key.ID] = key
+	}
+
+	now := time.Now()
+	var failed, succeeded []string
+	for _, usage := range results {
+		key := keyMap[usage.ID]
+		if key == nil || key.Disabled || key.IsExpired(now) {
+			continue
+		}
+		switch usage.Error {
+		case "":
+			succeeded = append(succeeded, usage.ID)
+		case errQueueFull, errProcessingTimeout:
+			// Not the key's fault
+		default:
+			failed = append(failed, usage.ID)
+		}
+	}
+
+	if err := s.store.ResetKeyFailures(succeeded); err != nil {
+		fmt.Fprintf(console, "⚠️  重置 Key 失败计数失败: %v\n", err)
+	}
+	if len(failed) == 0 {
+		return
+	}
+
+	counts, err := s.store.IncrKeyFailures(failed)
+	if err != nil {
+		fmt.Fprintf(console, "⚠️  更新 Key 失败计数失败: %v\n", err)
+		return
+	}
+
+	lastErrors := make(map[string]string, len(results))
+	for _, usage := range results {
+		lastErrors[usage.ID] = usage.Error
+	}
+
+	for id, count := range counts {
+		if count < int64(maxFailures) {
+			continue
+		}
+		key := keyMap[id]
+		reason := fmt.Sprintf("%d consecutive failures (last: %s)", count, lastErrors[id])
+		if err := s.apiKeys.disableKey(key, reason, true); err != nil {
+			fmt.Fprintf(console, "⚠️  自动停用 Key %s 失败: %v\n", id, err)
+			continue
+		}
+		fmt.Fprintf(console, "⛔ 已自动停用 Key %s: %s\n", key.Name, reason)
+
+		err := s.notifier.Notify(&Notification{
+			Event:   "key.auto_disabled",
+			Title:   "API Key disabled",
+			Message: fmt.Sprintf("%s was disabled after %s", key.Name, reason),
+			KeyID:   key.ID,
+			Data: map[string]interface{}{
+				"failures": count,
+				"error":    lastErrors[id],
+			},
+		})
+		if err != nil {
+			fmt.Fprintf(console, "⚠️  发送停用通知失败 (%s): %v\n", id, err)
+		}
+	}
+}
+
+// Start launches the background job re-checking auto-disabled keys; it runs
+// even while auto-disabling is off so the limit can be changed at runtime
+func (s *HealthService) Start() {
+	if s.interval <= 0 {
+		return
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		ctx, cancel := shutdownContext(s.shutdown)
+		defer cancel()
+
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				s.RecheckDisabled(ctx)
+			case <-s.shutdown:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the re-check job
+func (s *HealthService) Stop() {
+	close(s.shutdown)
+	s.wg.Wait()
+}
+
+// RecheckDisabled fetches usage for every auto-disabled key and re-enables
+// the ones that succeed; keys disabled by hand are left alone
+func (s *HealthService) RecheckDisabled(ctx context.Context) {
+	keys, err := s.store.WithContext(ctx).GetAllAPIKeys()
+	if err != nil {
+		fmt.Fprintf(console, "⚠️  检查已停用的 Key 失败: %v\n", err)
+		return
+	}
+
+	now := time.Now()
+	for _, key := range keys {
+		if !key.Disabled || !key.AutoDisabled || key.IsExpired(now) {
+			continue
+		}
+
+		if ctx.Err() != nil {
+			return
+		}
+		usage, err := s.workerPool.Fetch(ctx, key)
+		if err != nil || usage.Error != "" {
+			continue
+		}
+
+		if err := s.apiKeys.enableKey(key); err != nil {
+			fmt.Fprintf(console, "⚠️  重新启用 Key %s 失败: %v\n", key.ID, err)
+			continue
+		}
+		fmt.Fprintf(console, "✅ Key %s 已恢复，重新启用\n", key.Name)
+
+		err = s.notifier.Notify(&Notification{
+			Event:   "key.recovered",
+			Title:   "API Key re-enabled",
+			Message: fmt.Sprintf("%s is working again and was re-enabled", key.Name),
+			KeyID:   key.ID,
+		})
+		if err != nil {
+			fmt.Fprintf(console, "⚠️  发送恢复通知失败 (%s): %v\n", key.ID, err)
+		}
+	}
+}
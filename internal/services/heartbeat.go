@@ -0,0 +1,130 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HeartbeatService refreshes usage on a schedule and pings a dead-man's
+// switch URL (healthchecks.io style) after each run: the URL itself when
+// the refresh succeeded, failURL with the reason as body when it did not.
+// If pings stop arriving the external monitor raises the alarm.
+type HeartbeatService struct {
+	apiKeys  *APIKeyService
+	url      string
+	failURL  string
+	interval time.Duration
+	client   *http.Client
+	shutdown chan struct{}
+	wg       sync.WaitGroup
+}
+
+// NewHeartbeatService creates the heartbeat; it does nothing when url is
+// empty or interval <= 0. failURL defaults to url + "/fail".
+func NewHeartbeatService(apiKeys *APIKeyService, url, failURL string, interval time.Duration) *HeartbeatService {
+	if url != "" && failURL == "" {
+		failURL = strings.TrimRight(url, "/") + "/fail"
+	}
+	return &HeartbeatService{
+		apiKeys:  apiKeys,
+		url:      url,
+		failURL:  failURL,
+		interval: interval,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		shutdown: make(chan struct{}),
+	}
+}
+
+// Start launches the scheduled refresh
+func (s *HeartbeatService) Start() {
+	if s.url == "" || s.interval <= 0 {
+		return
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		ctx, cancel := shutdownContext(s.shutdown)
+		defer cancel()
+		ctx = withRefreshTrigger(ctx, TriggerHeartbeat)
+
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				s.beat(ctx)
+			case <-s.shutdown:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the scheduled refresh
+func (s *HeartbeatService) Stop() {
+	close(s.shutdown)
+	s.wg.Wait()
+}
+
+// beat runs one refresh and reports its outcome
+func (s *HeartbeatService) beat(ctx context.Context) {
+	url, body := s.url, ""
+	if reason := s.refresh(ctx); reason != "" {
+		url, body = s.failURL, reason
+	}
+	// A refresh cut short by shutdown is not a failure to report
+	if ctx.Err() != nil {
+		return
+	}
+	if err := s.ping(ctx, url, body); err != nil {
+		fmt.Fprintf(console, "⚠️  发送心跳失败: %v\n", err)
+	}
+}
+
+// refresh refreshes usage and returns why it failed, or "" on success.
+// Single keys failing upstream are not a failure of the service, but keys
+// that were never fetched point at a stuck or overloaded queue.
+func (s *HeartbeatService) refresh(ctx context.Context) string {
+	data, err := s.apiKeys.GetAggregatedData(ctx, adminPrincipal)
+	if err != nil {
+		return fmt.Sprintf("refresh failed: %v", err)
+	}
+
+	unfetched := 0
+	for _, usage := range data.Data {
+		if usage.ErrorCode == UsageErrQueueFull || usage.ErrorCode == UsageErrProcessingTimeout {
+			unfetched++
+		}
+	}
+	if unfetched > 0 {
+		return fmt.Sprintf("%d of %d keys were not fetched (queue full or processing timeout)", unfetched, len(data.Data))
+	}
+	return ""
+}
+
+func (s *HeartbeatService) ping(ctx context.Context, url, body string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("ping %s: status %d", url, resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/droid-keyusage-go/internal/models"
+	"github.com/droid-keyusage-go/internal/storage"
+)
+
+// History export formats
+const (
+	HistoryFormatNDJSON  = "ndjson"
+	HistoryFormatParquet = "parquet"
+)
+
+// ErrUnknownExportFormat is returned for unsupported history export formats
+var ErrUnknownExportFormat = errors.New("unknown export format")
+
+// historyContentTypes maps history export formats to their content type
+var historyContentTypes = map[string]string{
+	HistoryFormatNDJSON:  "application/x-ndjson",
+	HistoryFormatParquet: "application/vnd.apache.parquet",
+}
+
+// HistoryContentType returns the content type of a history export format,
+// or "" when the format is not supported
+func HistoryContentType(format string) string {
+	return historyContentTypes[format]
+}
+
+// historyColumns are the Parquet columns of an exported history, matching
+// the JSON fields of models.HistoryRow
+var historyColumns = []parquetColumn{
+	{"time", parquetTimestamp},
+	{"key_id", parquetString},
+	{"key_name", parquetString},
+	{"group", parquetString},
+	{"provider", parquetString},
+	{"start_date", parquetString},
+	{"end_date", parquetString},
+	{"total_allowance", parquetDouble},
+	{"used", parquetDouble},
+	{"remaining", parquetDouble},
+	{"used_ratio", parquetDouble},
+	{"latency_ms", parquetInt64},
+	{"error", parquetString},
+}
+
+// ExportHistory writes every usage snapshot taken within [from, to] of the
+// keys p may see, one row per snapshot ordered by key and time. Histories
+// are read a batch of keys at a time.
+func (s *APIKeyService) ExportHistory(ctx context.Context, w io.Writer, format string, from, to time.Time, p Principal) error {
+	var write func(*models.HistoryRow) error
+	var finish func() error
+	switch format {
+	case HistoryFormatNDJSON:
+		enc := json.NewEncoder(w)
+		write = func(row *models.HistoryRow) error { return enc.Encode(row) }
+		finish = func() error { return nil }
+	case HistoryFormatParquet:
+		pw := newParquetWriter(w, historyColumns)
+		write = func(row *models.HistoryRow) error {
+			return pw.WriteRow(row.Time, row.KeyID, row.KeyName, row.Group, row.Provider,
+				row.StartDate, row.EndDate, row.TotalAllowance, row.Used, row.Remaining,
+				row.UsedRatio, row.LatencyMs, row.Error)
+		}
+		finish = pw.Close
+	default:
+		return ErrUnknownExportFormat
+	}
+
+	store := s.store.WithContext(ctx)
+	keys, err := store.GetAllAPIKeys()
+	if err != nil {
+		return err
+	}
+	keys = s.visibleKeys(keys, p)
+
+	for _, chunk := range chunkKeys(keys, s.batchSize) {
+		ids := make([]string, len(chunk))
+		for i, key := range chunk {
+			ids[i] = key.ID
+		}
+		histories, err := store.BatchGetHistory(ids, from, to)
+		if err != nil {
+			return err
+		}
+
+		for _, key := range chunk {
+			for _, snapshot := range histories[key.ID] {
+				if err := write(historyRow(key, snapshot)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return finish()
+}
+
+// historyRow flattens a usage snapshot for export
+func historyRow(key *storage.APIKey, snapshot *storage.Usage) *models.HistoryRow {
+	return &models.HistoryRow{
+		Time:           snapshot.LastUpdated.UTC(),
+		KeyID:          key.ID,
+		KeyName:        key.Name,
+		Group:          key.Group,
+		Provider:       providerName(key),
+		StartDate:      snapshot.StartDate,
+		EndDate:        snapshot.EndDate,
+		TotalAllowance: snapshot.TotalAllowance,
+		Used:           snapshot.OrgTotalUsed,
+		Remaining:      snapshot.Remaining,
+		UsedRatio:      snapshot.UsedRatio,
+		LatencyMs:      snapshot.LatencyMs,
+		Error:          snapshot.Error,
+	}
+}
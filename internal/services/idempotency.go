@@ -0,0 +1,82 @@
+package services
+
+import (
+	"errors"
+	"time"
+
+	"github.com/droid-keyusage-go/internal/storage"
+)
+
+var (
+	// ErrIdempotencyInProgress is returned while the original request is still running
+	ErrIdempotencyInProgress = errors.New("a request with this idempotency key is in progress")
+	// ErrIdempotencyMismatch is returned when a key is reused for a different request
+	ErrIdempotencyMismatch = errors.New("idempotency key was used for a different request")
+)
+
+// IdempotentResponse is a stored response replayed for retried requests
+type IdempotentResponse struct {
+	Fingerprint string `json:"fingerprint"`
+	Completed   bool   `json:"completed"`
+	Status      int    `json:"status,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	Body        []byte `json:"body,omitempty"`
+}
+
+// IdempotencyService remembers responses to mutating requests by client key
+type IdempotencyService struct {
+	store *storage.Storage
+	ttl   time.Duration
+}
+
+// NewIdempotencyService creates an idempotency service keeping responses for ttl
+func NewIdempotencyService(store *storage.Storage, ttl time.Duration) *IdempotencyService {
+	return &IdempotencyService{store: store, ttl: ttl}
+}
+
+func idempotencyKey(key string) string {
+	return "idempotency:" + key
+}
+
+// Begin claims an idempotency key for a request identified by fingerprint.
+// It returns the stored response if the request already completed, nil if
+// the caller now owns the key, or an error if the key is busy or reused.
+func (s *IdempotencyService) Begin(key, fingerprint string) (*IdempotentResponse, error) {
+	claimed, err := s.store.SetJSONNX(idempotencyKey(key), &IdempotentResponse{
+		Fingerprint: fingerprint,
+	}, s.ttl)
+	if err != nil {
+		return nil, err
+	}
+	if claimed {
+		return nil, nil
+	}
+
+	var existing IdempotentResponse
+	found, err := s.store.GetJSON(idempotencyKey(key), &existing)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		// Expired between the two calls; try once more
+		return s.Begin(key, fingerprint)
+	}
+	if existing.Fingerprint != fingerprint {
+		return nil, ErrIdempotencyMismatch
+	}
+	if !existing.Completed {
+		return nil, ErrIdempotencyInProgress
+	}
+	return &existing, nil
+}
+
+// Complete stores the final response for a claimed key
+func (s *IdempotencyService) Complete(key string, resp *IdempotentResponse) error {
+	resp.Completed = true
+	return s.store.SetJSON(idempotencyKey(key), resp, s.ttl)
+}
+
+// Release frees a claimed key so the request can be retried
+func (s *IdempotencyService) Release(key string) error {
+	return s.store.DeleteKey(idempotencyKey(key))
+}
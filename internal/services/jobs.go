@@ -0,0 +1,73 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/droid-keyusage-go/internal/models"
+	"github.com/droid-keyusage-go/internal/storage"
+	"github.com/google/uuid"
+)
+
+// jobTally counts the outcomes of the fetches of a refresh batch
+type jobTally struct {
+	succeeded int
+	failed    int
+	byReason  map[string]int
+}
+
+// tallyResults counts the outcomes of results
+func tallyResults(results []*models.Usage) *jobTally {
+	tally := &jobTally{}
+	tally.add(results)
+	return tally
+}
+
+// add counts the outcomes of results
+func (t *jobTally) add(results []*models.Usage) {
+	for _, usage := range results {
+		if usage.Error == "" {
+			t.succeeded++
+			continue
+		}
+		if t.byReason == nil {
+			t.byReason = make(map[string]int)
+		}
+		t.failed++
+		t.byReason[usageErrorCode(usage)]++
+	}
+}
+
+// recordJob persists the summary of a refresh batch that fetched
+// attempted of totalKeys keys between start and end
+func (s *APIKeyService) recordJob(ctx context.Context, start, end time.Time, totalKeys, attempted int, tally *jobTally) {
+	job := &storage.JobSummary{
+		ID:             uuid.New().String(),
+		Trigger:        refreshTrigger(ctx),
+		StartedAt:      start,
+		FinishedAt:     end,
+		DurationMs:     end.Sub(start).Milliseconds(),
+		TotalKeys:      totalKeys,
+		Attempted:      attempted,
+		Succeeded:      tally.succeeded,
+		Failed:         tally.failed,
+		FailedByReason: tally.byReason,
+	}
+	if seconds := end.Sub(start).Seconds(); seconds > 0 {
+		job.Throughput = float64(attempted) / seconds
+	}
+
+	if err := s.store.WithContext(ctx).AppendJobSummary(job); err != nil {
+		fmt.Fprintf(console, "⚠️  保存刷新记录失败: %v\n", err)
+	}
+}
+
+// ListJobs returns up to limit refresh batches, newest first, optionally
+// only those started by trigger
+func (s *APIKeyService) ListJobs(ctx context.Context, trigger string, limit int) ([]*storage.JobSummary, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+	return s.store.WithContext(ctx).ListJobSummaries(trigger, limit)
+}
@@ -0,0 +1,108 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidKeyFormat is matched by every KeyFormatError
+var ErrInvalidKeyFormat = errors.New("invalid key format")
+
+// Key format rules reported in KeyFormatError
+const (
+	KeyFormatTooShort = "too_short"
+	KeyFormatTooLong  = "too_long"
+	KeyFormatPattern  = "pattern"
+)
+
+// KeyFormatError describes why a key was rejected by its provider's format rule
+type KeyFormatError struct {
+	Provider string
+	Rule     string
+	Param    string
+}
+
+func (e *KeyFormatError) Error() string {
+	return fmt.Sprintf("invalid %s key: %s %s", e.Provider, e.Rule, e.Param)
+}
+
+// Is makes errors.Is(err, ErrInvalidKeyFormat) match
+func (e *KeyFormatError) Is(target error) bool {
+	return target == ErrInvalidKeyFormat
+}
+
+// KeyFormat constrains the raw keys accepted for a provider
+type KeyFormat struct {
+	minLength int
+	maxLength int
+	pattern   *regexp.Regexp
+}
+
+// ParseKeyFormats parses rules such as "factory:20:512:^fk-;other::64:" into
+// formats by provider. Each rule is provider:min:max:regex; empty parts are
+// unconstrained and the regex may itself contain colons.
+func ParseKeyFormats(spec string) (map[string]*KeyFormat, error) {
+	formats := make(map[string]*KeyFormat)
+	for _, rule := range strings.Split(spec, ";") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+
+		parts := strings.SplitN(rule, ":", 4)
+		provider := strings.TrimSpace(parts[0])
+		if provider == "" {
+			return nil, fmt.Errorf("invalid key format rule %q: missing provider", rule)
+		}
+
+		format := &KeyFormat{}
+		var err error
+		if len(parts) > 1 && parts[1] != "" {
+			if format.minLength, err = strconv.Atoi(parts[1]); err != nil {
+				return nil, fmt.Errorf("invalid key format rule %q: bad min length", rule)
+			}
+		}
+		if len(parts) > 2 && parts[2] != "" {
+			if format.maxLength, err = strconv.Atoi(parts[2]); err != nil {
+				return nil, fmt.Errorf("invalid key format rule %q: bad max length", rule)
+			}
+		}
+		if len(parts) > 3 && parts[3] != "" {
+			if format.pattern, err = regexp.Compile(parts[3]); err != nil {
+				return nil, fmt.Errorf("invalid key format rule %q: %w", rule, err)
+			}
+		}
+		formats[provider] = format
+	}
+	return formats, nil
+}
+
+// Check validates a raw key against the format
+func (f *KeyFormat) Check(provider, key string) error {
+	if f.minLength > 0 && len(key) < f.minLength {
+		return &KeyFormatError{Provider: provider, Rule: KeyFormatTooShort, Param: strconv.Itoa(f.minLength)}
+	}
+	if f.maxLength > 0 && len(key) > f.maxLength {
+		return &KeyFormatError{Provider: provider, Rule: KeyFormatTooLong, Param: strconv.Itoa(f.maxLength)}
+	}
+	if f.pattern != nil && !f.pattern.MatchString(key) {
+		return &KeyFormatError{Provider: provider, Rule: KeyFormatPattern, Param: f.pattern.String()}
+	}
+	return nil
+}
+
+// CheckKeyFormat validates a raw key against the format rule of its provider;
+// providers without a rule accept any key
+func (s *APIKeyService) CheckKeyFormat(provider, key string) error {
+	if provider == "" {
+		provider = DefaultProvider
+	}
+	format, ok := s.keyFormats[provider]
+	if !ok {
+		return nil
+	}
+	return format.Check(provider, key)
+}
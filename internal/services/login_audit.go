@@ -0,0 +1,213 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/droid-keyusage-go/internal/storage"
+)
+
+const (
+	// geoIPTimeout bounds one GeoIP lookup
+	geoIPTimeout = 3 * time.Second
+	// geoIPCacheSize bounds the lookups kept in memory; the cache starts
+	// over when it is full
+	geoIPCacheSize = 10000
+)
+
+// GeoIP resolves IP addresses to a rough location through an HTTP lookup
+// service such as ipinfo.io or ip-api.com
+type GeoIP struct {
+	urlTemplate string
+	client      *http.Client
+	mu          sync.Mutex
+	cache       map[string]string
+}
+
+// NewGeoIP creates a GeoIP lookup; urlTemplate is the service URL with an
+// {ip} placeholder, e.g. https://ipinfo.io/{ip}/json. It returns nil when
+// urlTemplate is empty, which disables lookups.
+func NewGeoIP(urlTemplate string) *GeoIP {
+	if urlTemplate == "" {
+		return nil
+	}
+	return &GeoIP{
+		urlTemplate: urlTemplate,
+		client:      &http.Client{Timeout: geoIPTimeout},
+		cache:       make(map[string]string),
+	}
+}
+
+// Lookup returns the location of an IP as "city, region, country", or ""
+// when it is unknown; private and loopback addresses are not looked up
+func (g *GeoIP) Lookup(ctx context.Context, ip string) string {
+	parsed := net.ParseIP(ip)
+	if g == nil || parsed == nil || parsed.IsLoopback() || parsed.IsPrivate() || parsed.IsUnspecified() {
+		return ""
+	}
+
+	g.mu.Lock()
+	location, ok := g.cache[ip]
+	g.mu.Unlock()
+	if ok {
+		return location
+	}
+
+	location, err := g.fetch(ctx, ip)
+	if err != nil {
+		fmt.Fprintf(console, "⚠️  查询 IP 归属地失败 (%s): %v\n", ip, err)
+		return ""
+	}
+
+	g.mu.Lock()
+	if len(g.cache) >= geoIPCacheSize {
+		g.cache = make(map[string]string)
+	}
+	g.cache[ip] = location
+	g.mu.Unlock()
+	return location
+}
+
+// fetch asks the lookup service about an IP. The field names of the common
+// services are understood: city, region or regionName, and country or
+// country_name.
+func (g *GeoIP) fetch(ctx context.Context, ip string) (string, error) {
+	target := strings.ReplaceAll(g.urlTemplate, "{ip}", ip)
+	req, err := http.NewRequestWithContext(ctx, "GET", target, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+
+	var body map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", err
+	}
+	field := func(names ...string) string {
+		for _, name := range names {
+			if value, ok := body[name].(string); ok && value != "" {
+				return value
+			}
+		}
+		return ""
+	}
+
+	parts := make([]string, 0, 3)
+	for _, part := range []string{
+		field("city"),
+		field("region", "regionName"),
+		field("country_name", "country"),
+	} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, ", "), nil
+}
+
+// ConfigureLogins sets up login auditing: geo resolves the location of
+// login IPs (nil disables it) and, with notifyNewIP, successful logins from
+// an IP the user never logged in from before are notified
+func (s *AuditService) ConfigureLogins(geo *GeoIP, notifier *NotificationService, notifyNewIP bool) {
+	s.geo = geo
+	s.notifier = notifier
+	s.notifyNewIP = notifyNewIP
+}
+
+// RecordLogin records a login attempt. The GeoIP lookup, the entry and the
+// new-IP notification are handled in the background so logging in never
+// waits on them; entry must not be used by the caller afterwards.
+func (s *AuditService) RecordLogin(entry *storage.AuditEntry) {
+	entry.Action = AuditLogin
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), geoIPTimeout)
+		defer cancel()
+		entry.Location = s.geo.Lookup(ctx, entry.IP)
+
+		s.Record(entry)
+		if entry.Success && s.notifyNewIP {
+			s.checkNewIP(entry)
+		}
+	}()
+}
+
+// checkNewIP notifies a successful login from an IP the user has not logged
+// in from before; a user's very first login is not notified
+func (s *AuditService) checkNewIP(entry *storage.AuditEntry) {
+	if entry.IP == "" || s.notifier == nil {
+		return
+	}
+	isNew, seenBefore, err := s.store.MarkLoginIP(entry.Actor, entry.IP)
+	if err != nil {
+		fmt.Fprintf(console, "⚠️  记录登录 IP 失败: %v\n", err)
+		return
+	}
+	if !isNew || !seenBefore {
+		return
+	}
+
+	from := entry.IP
+	if entry.Location != "" {
+		from += " (" + entry.Location + ")"
+	}
+	err = s.notifier.Notify(&Notification{
+		Event:   "auth.new_ip_login",
+		Title:   "Login from a new IP",
+		Message: fmt.Sprintf("%s logged in from %s", entry.Actor, from),
+		Time:    entry.Time,
+		Data: map[string]interface{}{
+			"actor":      entry.Actor,
+			"ip":         entry.IP,
+			"location":   entry.Location,
+			"user_agent": entry.UserAgent,
+			"method":     entry.Detail,
+		},
+	})
+	if err != nil {
+		fmt.Fprintf(console, "⚠️  发送新 IP 登录通知失败: %v\n", err)
+	}
+}
+
+// ListLogins returns recent login attempts, newest first, optionally only
+// successful or failed ones (success) and those of one actor
+func (s *AuditService) ListLogins(limit int, success *bool, actor string) ([]*storage.AuditEntry, error) {
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+	entries, err := s.store.ListAudit(AuditLogin, 1000)
+	if err != nil {
+		return nil, err
+	}
+
+	logins := make([]*storage.AuditEntry, 0, limit)
+	for _, entry := range entries {
+		if success != nil && entry.Success != *success {
+			continue
+		}
+		if actor != "" && entry.Actor != actor {
+			continue
+		}
+		logins = append(logins, entry)
+		if len(logins) >= limit {
+			break
+		}
+	}
+	return logins, nil
+}
@@ -0,0 +1,109 @@
+package services
+
+import (
+	"fmt"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// tokenMagicLink is the "typ" claim of magic-link login tokens
+const tokenMagicLink = "magic"
+
+// magicLinkInterval is how often a link can be requested for one address
+const magicLinkInterval = time.Minute
+
+// ParseMagicLinkUsers parses the addresses allowed to log in by email, such
+// as "alice@example.com:admin;bob@example.com:viewer"
+func ParseMagicLinkUsers(spec string) (map[string]string, error) {
+	users := make(map[string]string)
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		i := strings.LastIndex(entry, ":")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid magic link user %q: expected email:role", entry)
+		}
+		email := strings.ToLower(strings.TrimSpace(entry[:i]))
+		role := strings.TrimSpace(entry[i+1:])
+		if _, err := mail.ParseAddress(email); err != nil {
+			return nil, fmt.Errorf("invalid magic link user %q: bad email", entry)
+		}
+		if !knownRole(role) {
+			return nil, fmt.Errorf("invalid magic link user %q: unknown role %q", entry, role)
+		}
+		users[email] = role
+	}
+	return users, nil
+}
+
+// ConfigureMagicLinks enables login by emailed one-time links for the
+// given addresses; links point to baseURL and expire after ttl
+func (s *AuthService) ConfigureMagicLinks(users map[string]string, mailer *Mailer, baseURL string, ttl time.Duration) {
+	if ttl <= 0 {
+		ttl = 15 * time.Minute
+	}
+	s.magicUsers = users
+	s.mailer = mailer
+	s.magicBaseURL = strings.TrimRight(baseURL, "/")
+	s.magicTTL = ttl
+}
+
+// MagicLinksEnabled reports whether login by email is available
+func (s *AuthService) MagicLinksEnabled() bool {
+	return s.mailer != nil && s.magicBaseURL != "" && len(s.magicUsers) > 0
+}
+
+// SendMagicLink emails a one-time login link. Unknown addresses and repeated
+// requests within a minute are ignored without error, so the response does
+// not reveal who may log in.
+func (s *AuthService) SendMagicLink(email string) error {
+	email = strings.ToLower(strings.TrimSpace(email))
+	role, ok := s.magicUsers[email]
+	if !ok || !s.MagicLinksEnabled() {
+		return nil
+	}
+
+	first, err := s.store.SetJSONNX("magic:sent:"+email, true, magicLinkInterval)
+	if err != nil || !first {
+		return err
+	}
+
+	token, err := s.signToken(Principal{User: email, Role: role}, tokenMagicLink, s.magicTTL)
+	if err != nil {
+		return err
+	}
+	link := s.magicBaseURL + "/login.html?magic_token=" + url.QueryEscape(token)
+	body := fmt.Sprintf("Use this link to sign in to the Droid API Key Usage Monitor:\n\n%s\n\n"+
+		"The link works once and expires in %s. If you did not request it, ignore this email.\n",
+		link, s.magicTTL)
+	return s.mailer.Send(email, "Your sign-in link", body)
+}
+
+// ExchangeMagicLink consumes a magic-link token and returns whom it was
+// issued to; each token works once
+func (s *AuthService) ExchangeMagicLink(token string) (Principal, error) {
+	claims, err := s.parseToken(token, tokenMagicLink)
+	if err != nil {
+		return Principal{}, err
+	}
+
+	// The address may have been removed since the link was sent
+	role, ok := s.magicUsers[claims.User]
+	if !ok {
+		return Principal{}, ErrInvalidToken
+	}
+
+	first, err := s.store.SetJSONNX(deniedTokenKey(claims.ID), true, time.Until(claims.ExpiresAt.Time))
+	if err != nil {
+		return Principal{}, err
+	}
+	if !first {
+		return Principal{}, ErrInvalidToken
+	}
+	return Principal{User: claims.User, Role: role}, nil
+}
@@ -0,0 +1,62 @@
+package services
+
+import (
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MailerConfig configures the SMTP server mail is sent through
+type MailerConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+// Mailer sends plain-text mail over SMTP, upgrading to TLS with STARTTLS
+// when the server offers it
+type Mailer struct {
+	cfg MailerConfig
+}
+
+// NewMailer creates a mailer; it returns nil when no SMTP host is set
+func NewMailer(cfg MailerConfig) *Mailer {
+	if cfg.Host == "" {
+		return nil
+	}
+	if cfg.Port <= 0 {
+		cfg.Port = 587
+	}
+	if cfg.From == "" {
+		cfg.From = cfg.Username
+	}
+	return &Mailer{cfg: cfg}
+}
+
+// Send delivers a plain-text message to a single recipient
+func (m *Mailer) Send(to, subject, body string) error {
+	if strings.ContainsAny(to, "\r\n") || strings.ContainsAny(subject, "\r\n") {
+		return fmt.Errorf("invalid mail header")
+	}
+
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", m.cfg.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", to)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+
+	var auth smtp.Auth
+	if m.cfg.Username != "" {
+		auth = smtp.PlainAuth("", m.cfg.Username, m.cfg.Password, m.cfg.Host)
+	}
+	addr := net.JoinHostPort(m.cfg.Host, strconv.Itoa(m.cfg.Port))
+	return smtp.SendMail(addr, auth, m.cfg.From, []string{to}, []byte(msg.String()))
+}
@@ -0,0 +1,73 @@
+package services
+
+import "github.com/droid-keyusage-go/internal/models"
+
+// Session roles; editors change the keys they may see, viewers only read
+const (
+	RoleAdmin  = "admin"
+	RoleEditor = "editor"
+	RoleViewer = "viewer"
+)
+
+// knownRole reports whether role is one of the session roles
+func knownRole(role string) bool {
+	return role == RoleAdmin || role == RoleEditor || role == RoleViewer
+}
+
+// fullMask replaces the whole key for roles that may not see any part of it
+const fullMask = "********"
+
+// MaskPolicy controls how much of a key is shown outside the full-key endpoint
+type MaskPolicy struct {
+	// Prefix and Suffix are the numbers of characters left visible
+	Prefix int
+	Suffix int
+}
+
+// Mask hides the middle of a key, keeping the configured prefix and suffix.
+// Keys too short to hide anything are masked completely.
+func (p MaskPolicy) Mask(key string) string {
+	if p.Prefix < 0 || p.Suffix < 0 || len(key) <= p.Prefix+p.Suffix+4 {
+		return fullMask
+	}
+	if p.Prefix == 0 && p.Suffix == 0 {
+		return fullMask
+	}
+	return key[:p.Prefix] + "..." + key[len(key)-p.Suffix:]
+}
+
+// MaskFor masks a key for a session role; viewers never see any characters
+func (p MaskPolicy) MaskFor(key, role string) string {
+	if role == RoleViewer {
+		return fullMask
+	}
+	return p.Mask(key)
+}
+
+// maskResults hides the keys of results from viewers; results carry keys
+// masked by the policy already
+func maskResults(results []*models.Usage, p Principal) {
+	if p.Role != RoleViewer {
+		return
+	}
+	for _, usage := range results {
+		usage.Key = fullMask
+	}
+}
+
+// requireWriter rejects changes by viewers, whose role is read-only
+func requireWriter(p Principal) error {
+	if p.Role == RoleViewer {
+		return ErrReadOnly
+	}
+	return nil
+}
+
+// visibleSuffix returns how many trailing characters Mask shows of a key of
+// the given length
+func (p MaskPolicy) visibleSuffix(length int) int {
+	if p.Prefix < 0 || p.Suffix < 0 || length <= p.Prefix+p.Suffix+4 {
+		return 0
+	}
+	return p.Suffix
+}
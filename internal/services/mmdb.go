@@ -0,0 +1,286 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"net"
+	"os"
+)
+
+// mmdbMetadataMarker precedes the metadata at the end of a MaxMind DB file
+var mmdbMetadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// mmdbMaxDepth bounds the nesting of decoded values, against corrupt files
+const mmdbMaxDepth = 32
+
+// errMMDBCorrupt is returned for data pointing outside the file
+var errMMDBCorrupt = errors.New("corrupt MaxMind DB data")
+
+// mmdbReader looks up IP addresses in a MaxMind DB file (.mmdb), the format
+// of the GeoLite2/GeoIP2, DB-IP and IPinfo databases. The file is read into
+// memory once.
+type mmdbReader struct {
+	tree       []byte
+	data       mmdbDecoder
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+	// ipv4Start is the node IPv4 addresses start from: in IPv6 databases
+	// they live under ::/96
+	ipv4Start uint
+}
+
+// openMMDB reads a MaxMind DB file
+func openMMDB(path string) (*mmdbReader, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	i := bytes.LastIndex(buf, mmdbMetadataMarker)
+	if i < 0 {
+		return nil, fmt.Errorf("%s: not a MaxMind DB file", path)
+	}
+	value, _, err := mmdbDecoder(buf[i+len(mmdbMetadataMarker):]).decode(0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("%s: metadata: %w", path, err)
+	}
+	metadata, _ := value.(map[string]interface{})
+	number := func(name string) uint {
+		n, _ := metadata[name].(uint64)
+		return uint(n)
+	}
+
+	r := &mmdbReader{
+		nodeCount:  number("node_count"),
+		recordSize: number("record_size"),
+		ipVersion:  number("ip_version"),
+	}
+	if r.recordSize != 24 && r.recordSize != 28 && r.recordSize != 32 {
+		return nil, fmt.Errorf("%s: unsupported record size %d", path, r.recordSize)
+	}
+	if r.ipVersion != 4 && r.ipVersion != 6 {
+		return nil, fmt.Errorf("%s: unsupported IP version %d", path, r.ipVersion)
+	}
+	// The search tree is followed by 16 zero bytes and the data section
+	treeSize := r.nodeCount * r.recordSize / 4
+	if r.nodeCount > uint(i) || treeSize+16 > uint(i) {
+		return nil, fmt.Errorf("%s: %w", path, errMMDBCorrupt)
+	}
+	r.tree = buf[:treeSize]
+	r.data = mmdbDecoder(buf[treeSize+16 : i])
+
+	if r.ipVersion == 6 {
+		for bit := 0; bit < 96 && r.ipv4Start < r.nodeCount; bit++ {
+			r.ipv4Start = r.record(r.ipv4Start, 0)
+		}
+	}
+	return r, nil
+}
+
+// lookup returns the record of the network containing ip, or nil when the
+// database has none
+func (r *mmdbReader) lookup(ip net.IP) (map[string]interface{}, error) {
+	node, addr := uint(0), ip.To16()
+	if v4 := ip.To4(); v4 != nil {
+		node, addr = r.ipv4Start, v4
+	} else if r.ipVersion == 4 || addr == nil {
+		return nil, nil
+	}
+	for i := 0; i < len(addr)*8 && node < r.nodeCount; i++ {
+		node = r.record(node, addr[i/8]>>(7-i%8)&1)
+	}
+	if node <= r.nodeCount {
+		return nil, nil
+	}
+
+	value, _, err := r.data.decode(node-r.nodeCount-16, 0)
+	if err != nil {
+		return nil, err
+	}
+	record, _ := value.(map[string]interface{})
+	return record, nil
+}
+
+// record reads the left (bit 0) or right (bit 1) record of a search tree
+// node; node must be below nodeCount
+func (r *mmdbReader) record(node uint, bit byte) uint {
+	switch r.recordSize {
+	case 24:
+		b := r.tree[node*6+uint(bit)*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		b := r.tree[node*7:]
+		if bit == 0 {
+			return uint(b[3]&0xf0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0f)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		return uint(binary.BigEndian.Uint32(r.tree[node*8+uint(bit)*4:]))
+	}
+}
+
+// mmdbDecoder decodes the values of a data or metadata section; pointers
+// are offsets from its start
+type mmdbDecoder []byte
+
+// Data types of the MaxMind DB format
+const (
+	mmdbPointer = 1
+	mmdbString  = 2
+	mmdbDouble  = 3
+	mmdbBytes   = 4
+	mmdbUint16  = 5
+	mmdbUint32  = 6
+	mmdbMap     = 7
+	mmdbInt32   = 8
+	mmdbUint64  = 9
+	mmdbUint128 = 10
+	mmdbArray   = 11
+	mmdbBool    = 14
+	mmdbFloat   = 15
+)
+
+// decode decodes the value at off and returns the offset after it. Maps
+// become map[string]interface{}, arrays []interface{}, unsigned integers
+// uint64 (*big.Int for uint128) and signed ones int.
+func (d mmdbDecoder) decode(off uint, depth int) (interface{}, uint, error) {
+	if depth > mmdbMaxDepth {
+		return nil, 0, errMMDBCorrupt
+	}
+	b, err := d.slice(off, 1)
+	if err != nil {
+		return nil, 0, err
+	}
+	ctrl := b[0]
+	off++
+	kind := uint(ctrl >> 5)
+
+	if kind == mmdbPointer {
+		n := uint(ctrl>>3&0x3) + 1
+		b, err := d.slice(off, n)
+		if err != nil {
+			return nil, 0, err
+		}
+		var target uint
+		switch n {
+		case 1:
+			target = uint(ctrl&0x7)<<8 | uint(b[0])
+		case 2:
+			target = (uint(ctrl&0x7)<<16 | uint(b[0])<<8 | uint(b[1])) + 2048
+		case 3:
+			target = (uint(ctrl&0x7)<<24 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])) + 526336
+		default:
+			target = uint(binary.BigEndian.Uint32(b))
+		}
+		value, _, err := d.decode(target, depth+1)
+		return value, off + n, err
+	}
+
+	if kind == 0 {
+		b, err := d.slice(off, 1)
+		if err != nil {
+			return nil, 0, err
+		}
+		kind = 7 + uint(b[0])
+		off++
+	}
+	size := uint(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		b, err := d.slice(off, n)
+		if err != nil {
+			return nil, 0, err
+		}
+		off += n
+		size = [...]uint{29, 285, 65821}[n-1] + uint(d.unsigned(b))
+	}
+
+	switch kind {
+	case mmdbMap:
+		values := make(map[string]interface{}, min(size, 64))
+		for i := uint(0); i < size; i++ {
+			var key, value interface{}
+			if key, off, err = d.decode(off, depth+1); err != nil {
+				return nil, 0, err
+			}
+			name, ok := key.(string)
+			if !ok {
+				return nil, 0, errMMDBCorrupt
+			}
+			if value, off, err = d.decode(off, depth+1); err != nil {
+				return nil, 0, err
+			}
+			values[name] = value
+		}
+		return values, off, nil
+	case mmdbArray:
+		values := make([]interface{}, 0, min(size, 64))
+		for i := uint(0); i < size; i++ {
+			var value interface{}
+			if value, off, err = d.decode(off, depth+1); err != nil {
+				return nil, 0, err
+			}
+			values = append(values, value)
+		}
+		return values, off, nil
+	case mmdbBool:
+		return size != 0, off, nil
+	}
+
+	b, err = d.slice(off, size)
+	if err != nil {
+		return nil, 0, err
+	}
+	off += size
+	switch kind {
+	case mmdbString:
+		return string(b), off, nil
+	case mmdbBytes:
+		return append([]byte(nil), b...), off, nil
+	case mmdbDouble:
+		if size != 8 {
+			return nil, 0, errMMDBCorrupt
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), off, nil
+	case mmdbFloat:
+		if size != 4 {
+			return nil, 0, errMMDBCorrupt
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), off, nil
+	case mmdbUint16, mmdbUint32, mmdbUint64:
+		if size > 8 {
+			return nil, 0, errMMDBCorrupt
+		}
+		return d.unsigned(b), off, nil
+	case mmdbUint128:
+		return new(big.Int).SetBytes(b), off, nil
+	case mmdbInt32:
+		if size > 4 {
+			return nil, 0, errMMDBCorrupt
+		}
+		return int(int32(d.unsigned(b))), off, nil
+	default:
+		return nil, 0, fmt.Errorf("unknown MaxMind DB data type %d", kind)
+	}
+}
+
+// slice returns n bytes at off
+func (d mmdbDecoder) slice(off, n uint) ([]byte, error) {
+	if off > uint(len(d)) || n > uint(len(d))-off {
+		return nil, errMMDBCorrupt
+	}
+	return d[off : off+n], nil
+}
+
+// unsigned reads a big-endian number of up to 8 bytes
+func (d mmdbDecoder) unsigned(b []byte) uint64 {
+	var n uint64
+	for _, c := range b {
+		n = n<<8 | uint64(c)
+	}
+	return n
+}
@@ -0,0 +1,89 @@
+package services
+
+import (
+	"context"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/droid-keyusage-go/internal/models"
+	"github.com/droid-keyusage-go/internal/storage"
+)
+
+// MockConfig shapes the synthetic usage returned in mock mode
+type MockConfig struct {
+	// Latency is the mean simulated request time; each fetch varies by ±50%
+	Latency time.Duration
+	// ErrorRate is the share of fetches that fail like a real upstream would
+	ErrorRate float64
+	// UsedMean and UsedStddev describe the normal distribution of the used
+	// ratio a key reaches by the middle of the month, clamped to [0, 1]
+	UsedMean   float64
+	UsedStddev float64
+	// Allowance is the token allowance of every key
+	Allowance float64
+}
+
+// mockStatuses are the HTTP errors a failing mock fetch picks from; a fifth
+// of failures are timeouts instead
+var mockStatuses = []int{401, 429, 500, 502}
+
+// MockFetcher returns synthetic usage instead of calling providers, so load
+// tests of the worker pool, cache and API don't consume real quota. Each
+// key gets a stable share of its allowance that grows over the month.
+type MockFetcher struct {
+	cfg MockConfig
+}
+
+// NewMockFetcher creates a mock fetcher
+func NewMockFetcher(cfg MockConfig) *MockFetcher {
+	if cfg.Allowance <= 0 {
+		cfg.Allowance = 20000000
+	}
+	return &MockFetcher{cfg: cfg}
+}
+
+// Fetch simulates one usage request for the key
+func (m *MockFetcher) Fetch(ctx context.Context, key *storage.APIKey) (*models.Usage, error) {
+	if m.cfg.Latency > 0 {
+		timer := time.NewTimer(time.Duration(float64(m.cfg.Latency) * (0.5 + rand.Float64())))
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	if rand.Float64() < m.cfg.ErrorRate {
+		if rand.Intn(5) == 0 {
+			return nil, &UpstreamError{Message: "mock timeout", Timeout: true}
+		}
+		return statusUsage(key.ID, mockStatuses[rand.Intn(len(mockStatuses))], nil), nil
+	}
+
+	// The same key always draws the same ratio
+	h := fnv.New64a()
+	h.Write([]byte(key.ID))
+	keyRand := rand.New(rand.NewSource(int64(h.Sum64())))
+	base := m.cfg.UsedMean + m.cfg.UsedStddev*keyRand.NormFloat64()
+
+	now := time.Now()
+	start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	end := start.AddDate(0, 1, 0)
+	progress := float64(now.Sub(start)) / float64(end.Sub(start))
+	ratio := math.Max(0, math.Min(1, base*(0.5+progress)))
+
+	used := math.Round(m.cfg.Allowance * ratio)
+	return &models.Usage{
+		ID:             key.ID,
+		StartDate:      start.Format("2006-01-02"),
+		EndDate:        end.AddDate(0, 0, -1).Format("2006-01-02"),
+		TotalAllowance: m.cfg.Allowance,
+		OrgTotalUsed:   used,
+		Remaining:      m.cfg.Allowance - used,
+		UsedRatio:      ratio,
+		LastUpdated:    now,
+	}, nil
+}
@@ -0,0 +1,403 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/droid-keyusage-go/internal/models"
+	"github.com/droid-keyusage-go/internal/storage"
+	"github.com/droid-keyusage-go/internal/utils"
+)
+
+// Headers attached to signed webhook deliveries
+const (
+	SignatureHeader = "X-Droid-Signature"
+	TimestampHeader = "X-Droid-Timestamp"
+	NonceHeader     = "X-Droid-Nonce"
+)
+
+// SeverityCritical marks notifications of critical alerts, which incident
+// management channels escalate
+const SeverityCritical = "critical"
+
+// Notification is a message delivered to the configured notification channels
+type Notification struct {
+	Event   string                 `json:"event"`
+	Title   string                 `json:"title"`
+	Message string                 `json:"message"`
+	KeyID   string                 `json:"key_id,omitempty"`
+	Time    time.Time              `json:"time"`
+	Data    map[string]interface{} `json:"data,omitempty"`
+
+	// Severity and DedupKey are set on critical alerts; the alert.resolved
+	// notification of an alert carries the DedupKey it fired with
+	Severity string `json:"severity,omitempty"`
+	DedupKey string `json:"dedup_key,omitempty"`
+}
+
+// QuietHours is a daily local-time window during which a channel stays silent
+type QuietHours struct {
+	start int // minutes since midnight
+	end   int
+}
+
+// ParseQuietHours parses a window such as "22:00-08:00"; an empty spec means none
+func ParseQuietHours(spec string) (*QuietHours, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nil, nil
+	}
+
+	parts := strings.Split(spec, "-")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid quiet hours %q, expected HH:MM-HH:MM", spec)
+	}
+
+	var bounds [2]int
+	for i, part := range parts {
+		t, err := time.Parse("15:04", strings.TrimSpace(part))
+		if err != nil {
+			return nil, fmt.Errorf("invalid quiet hours %q: %w", spec, err)
+		}
+		bounds[i] = t.Hour()*60 + t.Minute()
+	}
+
+	return &QuietHours{start: bounds[0], end: bounds[1]}, nil
+}
+
+// Contains reports whether t falls inside the window, which may wrap past midnight
+func (q *QuietHours) Contains(t time.Time) bool {
+	if q == nil || q.start == q.end {
+		return false
+	}
+
+	minute := t.Hour()*60 + t.Minute()
+	if q.start < q.end {
+		return minute >= q.start && minute < q.end
+	}
+	return minute >= q.start || minute < q.end
+}
+
+func init() {
+	RegisterNotifier("webhook", newWebhookNotifier)
+}
+
+// webhookNotifier posts notifications as JSON to a URL, signed when a
+// secret is set
+type webhookNotifier struct {
+	url    string
+	secret string
+}
+
+func newWebhookNotifier(channel *models.NotificationChannel) (Notifier, error) {
+	if err := checkHTTPURL(channel.URL); err != nil {
+		return nil, err
+	}
+	return &webhookNotifier{url: channel.URL, secret: channel.Secret}, nil
+}
+
+// Send posts the notification
+func (w *webhookNotifier) Send(ctx context.Context, n *Notification) error {
+	payload, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+
+	header := http.Header{}
+	if w.secret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		nonce := newNonce()
+		header.Set(TimestampHeader, timestamp)
+		header.Set(NonceHeader, nonce)
+		header.Set(SignatureHeader, SignPayload(w.secret, timestamp, nonce, payload))
+	}
+	return postJSON(ctx, w.url, payload, header, nil)
+}
+
+// notifyChannel is a configured channel and the notifier delivering to it
+type notifyChannel struct {
+	name       string
+	notifier   Notifier
+	quietHours *QuietHours
+}
+
+// SignPayload computes the webhook signature over timestamp, nonce and body.
+// Receivers recompute it with their copy of the secret to verify a delivery.
+func SignPayload(secret, timestamp, nonce string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write([]byte(nonce))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func newNonce() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// notificationQueueSize bounds the notifications waiting for delivery
+const notificationQueueSize = 1000
+
+// ErrNotificationQueueFull is returned for a notification dropped because
+// too many are waiting for delivery
+var ErrNotificationQueueFull = errors.New("notification queue full")
+
+// NotificationService delivers notifications to the configured channels:
+// the webhook of NOTIFY_WEBHOOK_URL and the channels of NOTIFY_CHANNELS.
+// Delivery runs in the background, in the order notifications were sent,
+// so a slow channel never holds up a refresh or a request.
+type NotificationService struct {
+	webhook  *notifyChannel
+	channels []*notifyChannel
+	scripts  *ScriptHooks
+	mu       sync.RWMutex
+
+	// deliveries records every delivery attempt when set
+	deliveries *storage.Storage
+
+	queue    chan *Notification
+	shutdown chan struct{}
+	wg       sync.WaitGroup
+}
+
+// NewNotificationService creates a notification service; an empty URL disables
+// delivery and a non-empty secret signs every webhook payload. Notifications
+// are delivered once Start is called.
+func NewNotificationService(webhookURL, secret, quietHours string) *NotificationService {
+	s := &NotificationService{
+		queue:    make(chan *Notification, notificationQueueSize),
+		shutdown: make(chan struct{}),
+	}
+	s.SetWebhook(webhookURL, secret, quietHours)
+	return s
+}
+
+// Start delivers the queued notifications in the background
+func (s *NotificationService) Start() {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for {
+			select {
+			case n := <-s.queue:
+				s.deliver(n)
+			case <-s.shutdown:
+				// Deliver what was sent before the shutdown
+				for {
+					select {
+					case n := <-s.queue:
+						s.deliver(n)
+					default:
+						return
+					}
+				}
+			}
+		}
+	}()
+}
+
+// Stop delivers the notifications still queued and stops
+func (s *NotificationService) Stop() {
+	close(s.shutdown)
+	s.wg.Wait()
+}
+
+// SetWebhook replaces the webhook channel; an empty URL disables delivery
+func (s *NotificationService) SetWebhook(webhookURL, secret, quietHours string) {
+	var webhook *notifyChannel
+	if webhookURL != "" {
+		quiet, err := ParseQuietHours(quietHours)
+		if err != nil {
+			fmt.Fprintf(console, "⚠️  %v，已忽略免打扰时段\n", err)
+		}
+		webhook = &notifyChannel{
+			name:       "webhook",
+			notifier:   &webhookNotifier{url: webhookURL, secret: secret},
+			quietHours: quiet,
+		}
+	}
+
+	s.mu.Lock()
+	s.webhook = webhook
+	s.mu.Unlock()
+}
+
+// SetChannels replaces the channels configured besides the webhook;
+// channels whose notifier rejects their configuration are skipped
+func (s *NotificationService) SetChannels(configs []models.NotificationChannel) {
+	channels := make([]*notifyChannel, 0, len(configs))
+	for i := range configs {
+		config := &configs[i]
+		notifier, err := NewNotifier(config)
+		if err != nil {
+			fmt.Fprintf(console, "⚠️  通知渠道 %s 配置无效，已跳过: %v\n", config.Name, err)
+			continue
+		}
+		quiet, err := ParseQuietHours(config.QuietHours)
+		if err != nil {
+			fmt.Fprintf(console, "⚠️  %v，已忽略免打扰时段\n", err)
+		}
+		channels = append(channels, &notifyChannel{name: config.Name, notifier: notifier, quietHours: quiet})
+	}
+
+	s.mu.Lock()
+	s.channels = channels
+	s.mu.Unlock()
+}
+
+// SetScriptHooks runs the before_alert hook of scripts on every notification
+// before it is delivered
+func (s *NotificationService) SetScriptHooks(scripts *ScriptHooks) {
+	s.mu.Lock()
+	s.scripts = scripts
+	s.mu.Unlock()
+}
+
+// SetDeliveryLog records every delivery attempt in store, where the
+// retention service prunes them
+func (s *NotificationService) SetDeliveryLog(store *storage.Storage) {
+	s.mu.Lock()
+	s.deliveries = store
+	s.mu.Unlock()
+}
+
+// activeChannels returns a snapshot of the configured channels
+func (s *NotificationService) activeChannels() []*notifyChannel {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	channels := make([]*notifyChannel, 0, len(s.channels)+1)
+	if s.webhook != nil {
+		channels = append(channels, s.webhook)
+	}
+	return append(channels, s.channels...)
+}
+
+// Enabled reports whether any notification channel is configured
+func (s *NotificationService) Enabled() bool {
+	return len(s.activeChannels()) > 0
+}
+
+// Notify queues a notification for delivery to every channel outside its
+// quiet hours and returns at once; it fails only when the queue is full.
+// Failed deliveries are logged and recorded in the delivery log.
+func (s *NotificationService) Notify(n *Notification) error {
+	if !s.Enabled() {
+		return nil
+	}
+	if n.Time.IsZero() {
+		n.Time = time.Now()
+	}
+	select {
+	case s.queue <- n:
+		return nil
+	default:
+		return ErrNotificationQueueFull
+	}
+}
+
+// deliver sends a queued notification to every channel outside its quiet
+// hours
+func (s *NotificationService) deliver(n *Notification) {
+	channels := s.activeChannels()
+	if len(channels) == 0 {
+		return
+	}
+	s.mu.RLock()
+	scripts := s.scripts
+	s.mu.RUnlock()
+	if !scripts.BeforeAlert(n) {
+		fmt.Fprintf(console, "🔕 钩子脚本丢弃了通知: %s\n", n.Title)
+		return
+	}
+
+	now := time.Now()
+	for _, channel := range channels {
+		if channel.quietHours.Contains(now) {
+			fmt.Fprintf(console, "🔕 免打扰时段，跳过 %s 通知: %s\n", channel.name, n.Title)
+			continue
+		}
+		if err := s.send(channel, n); err != nil {
+			fmt.Fprintf(console, "⚠️  %s 通知发送失败 (%s): %v\n", channel.name, n.Event, err)
+		}
+	}
+}
+
+// send delivers a notification to one channel and records the attempt
+func (s *NotificationService) send(channel *notifyChannel, n *Notification) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	start := time.Now()
+	err := channel.notifier.Send(ctx, n)
+
+	s.mu.RLock()
+	deliveries := s.deliveries
+	s.mu.RUnlock()
+	if deliveries != nil {
+		record := &storage.DeliveryRecord{
+			Time:       start,
+			Channel:    channel.name,
+			Event:      n.Event,
+			Title:      n.Title,
+			KeyID:      n.KeyID,
+			Success:    err == nil,
+			DurationMs: time.Since(start).Milliseconds(),
+		}
+		if err != nil {
+			record.Error = utils.Redact(err.Error())
+		}
+		if logErr := deliveries.AppendDelivery(record); logErr != nil {
+			fmt.Fprintf(console, "⚠️  记录通知投递失败: %v\n", logErr)
+		}
+	}
+	return err
+}
+
+// Deliveries lists up to limit recent delivery attempts, newest first
+func (s *NotificationService) Deliveries(limit int) ([]*storage.DeliveryRecord, error) {
+	s.mu.RLock()
+	deliveries := s.deliveries
+	s.mu.RUnlock()
+	if deliveries == nil {
+		return []*storage.DeliveryRecord{}, nil
+	}
+	return deliveries.ListDeliveries(limit)
+}
+
+// TestDelivery sends a test notification to every channel, ignoring quiet
+// hours, and reports the outcome per channel
+func (s *NotificationService) TestDelivery() []models.DeliveryResult {
+	n := &Notification{
+		Event:   "test",
+		Title:   "Test notification",
+		Message: "This is a test delivery from Droid API Key Usage Monitor",
+		Time:    time.Now(),
+	}
+
+	channels := s.activeChannels()
+	results := make([]models.DeliveryResult, 0, len(channels))
+	for _, channel := range channels {
+		result := models.DeliveryResult{Channel: channel.name, Success: true}
+		if err := s.send(channel, n); err != nil {
+			result.Success = false
+			result.Error = utils.Redact(err.Error())
+		}
+		results = append(results, result)
+	}
+	return results
+}
@@ -0,0 +1,87 @@
+package services
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/droid-keyusage-go/internal/models"
+	"github.com/droid-keyusage-go/internal/storage"
+)
+
+func TestDeliveryErrorsHideWebhookURLs(t *testing.T) {
+	client, err := storage.NewMemoryClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	// Nothing listens at the webhook, so each delivery fails
+	server := httptest.NewServer(nil)
+	base := server.URL
+	server.Close()
+
+	const token = "hook-token-8f2c41"
+	s := NewNotificationService("", "", "")
+	s.SetDeliveryLog(storage.NewStorage(client))
+	s.SetChannels([]models.NotificationChannel{
+		{Name: "dingtalk", Type: "dingtalk", URL: base + "/robot/send?access_token=" + token},
+		{Name: "feishu", Type: "feishu", URL: base + "/open-apis/bot/v2/hook/" + token},
+	})
+
+	results := s.TestDelivery()
+	if len(results) != 2 {
+		t.Fatalf("%d results, want 2", len(results))
+	}
+	for _, result := range results {
+		if result.Success {
+			t.Fatalf("%s: delivery to a closed server succeeded", result.Channel)
+		}
+		if strings.Contains(result.Error, token) {
+			t.Errorf("%s: result error %q has the webhook token", result.Channel, result.Error)
+		}
+	}
+
+	deliveries, err := s.Deliveries(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 2 {
+		t.Fatalf("%d deliveries logged, want 2", len(deliveries))
+	}
+	for _, record := range deliveries {
+		if record.Error == "" || strings.Contains(record.Error, token) {
+			t.Errorf("%s: logged error %q", record.Channel, record.Error)
+		}
+	}
+}
+
+func TestNotifyDoesNotWait(t *testing.T) {
+	received := make(chan string, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		received <- r.URL.Path
+	}))
+	defer server.Close()
+
+	s := NewNotificationService(server.URL+"/hook", "", "")
+	s.Start()
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := s.Notify(&Notification{Event: "test", Title: "Slow channel"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Fatalf("Notify took %v on a channel answering in 200ms", elapsed)
+	}
+
+	// Stop delivers what is still queued
+	s.Stop()
+	if len(received) != 3 {
+		t.Fatalf("%d notifications delivered, want 3", len(received))
+	}
+}
@@ -0,0 +1,152 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/droid-keyusage-go/internal/models"
+)
+
+// ErrUnknownNotifier is returned for channels of an unregistered type
+var ErrUnknownNotifier = errors.New("unknown notification channel type")
+
+// Notifier delivers notifications over one kind of channel
+type Notifier interface {
+	// Send delivers a notification, giving up when ctx ends
+	Send(ctx context.Context, n *Notification) error
+}
+
+// NotifierFactory builds the notifier of a channel, rejecting
+// configurations it can't deliver with
+type NotifierFactory func(channel *models.NotificationChannel) (Notifier, error)
+
+// notifierTypes holds every registered notifier factory by channel type
+var notifierTypes = map[string]NotifierFactory{}
+
+// RegisterNotifier makes a channel type available to notification channels
+func RegisterNotifier(kind string, factory NotifierFactory) {
+	notifierTypes[kind] = factory
+}
+
+// NewNotifier builds the notifier of a channel from its type
+func NewNotifier(channel *models.NotificationChannel) (Notifier, error) {
+	factory, ok := notifierTypes[channel.Type]
+	if !ok {
+		return nil, fmt.Errorf("%w %q, expected one of %s", ErrUnknownNotifier, channel.Type, strings.Join(notifierTypeNames(), ", "))
+	}
+	return factory(channel)
+}
+
+// notifierTypeNames lists the registered channel types
+func notifierTypeNames() []string {
+	names := make([]string, 0, len(notifierTypes))
+	for name := range notifierTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ParseNotificationChannels decodes a JSON array of channels such as
+// NOTIFY_CHANNELS; an empty spec means none
+func ParseNotificationChannels(spec string) ([]models.NotificationChannel, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nil, nil
+	}
+
+	var channels []models.NotificationChannel
+	if err := json.Unmarshal([]byte(spec), &channels); err != nil {
+		return nil, fmt.Errorf("invalid notification channels: %w", err)
+	}
+	if index, err := checkNotificationChannels(channels); err != nil {
+		return nil, fmt.Errorf("notification channel %d: %w", index, err)
+	}
+	return channels, nil
+}
+
+// checkNotificationChannels checks that every channel has a unique name and
+// a configuration its notifier accepts; it returns the index of the first
+// channel that doesn't
+func checkNotificationChannels(channels []models.NotificationChannel) (int, error) {
+	names := make(map[string]bool, len(channels))
+	for i := range channels {
+		channel := &channels[i]
+		if channel.Name == "" {
+			return i, errors.New("name is required")
+		}
+		if names[channel.Name] {
+			return i, fmt.Errorf("duplicate name %q", channel.Name)
+		}
+		names[channel.Name] = true
+		if _, err := ParseQuietHours(channel.QuietHours); err != nil {
+			return i, err
+		}
+		if _, err := NewNotifier(channel); err != nil {
+			return i, err
+		}
+	}
+	return -1, nil
+}
+
+// notificationClient is shared by the notifiers delivering over HTTP
+var notificationClient = &http.Client{Timeout: 10 * time.Second}
+
+// checkHTTPURL checks that a channel URL is an absolute http(s) URL
+func checkHTTPURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("url must be an http(s) URL")
+	}
+	return nil
+}
+
+// postJSON posts a JSON payload with the given extra headers; responses
+// other than 2xx fail. The response body is decoded into reply unless it
+// is nil.
+func postJSON(ctx context.Context, target string, payload []byte, header http.Header, reply interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", target, bytes.NewReader(payload))
+	if err != nil {
+		return withoutURL(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, values := range header {
+		for _, value := range values {
+			req.Header.Add(name, value)
+		}
+	}
+
+	resp, err := notificationClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("notification delivery failed: %w", withoutURL(err))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("notification delivery failed: HTTP %d", resp.StatusCode)
+	}
+	if reply != nil {
+		if err := json.NewDecoder(resp.Body).Decode(reply); err != nil {
+			return fmt.Errorf("notification delivery failed: invalid response: %w", err)
+		}
+	}
+	return nil
+}
+
+// withoutURL drops the target URL from a request error: the webhook URLs of
+// most chat bots carry their access token
+func withoutURL(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return fmt.Errorf("%s: %w", urlErr.Op, urlErr.Err)
+	}
+	return err
+}
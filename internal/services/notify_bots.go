@@ -0,0 +1,170 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/droid-keyusage-go/internal/models"
+)
+
+func init() {
+	RegisterNotifier("dingtalk", newDingTalkNotifier)
+	RegisterNotifier("wecom", newWeComNotifier)
+	RegisterNotifier("feishu", newFeishuNotifier)
+}
+
+// botReply is the response of the group robot APIs, which report failures
+// in the body of a 200 response: DingTalk and WeCom as errcode, Feishu as
+// code
+type botReply struct {
+	ErrCode int    `json:"errcode"`
+	ErrMsg  string `json:"errmsg"`
+	Code    int    `json:"code"`
+	Msg     string `json:"msg"`
+}
+
+func (r *botReply) err() error {
+	switch {
+	case r.ErrCode != 0:
+		return fmt.Errorf("notification delivery failed: %d %s", r.ErrCode, r.ErrMsg)
+	case r.Code != 0:
+		return fmt.Errorf("notification delivery failed: %d %s", r.Code, r.Msg)
+	}
+	return nil
+}
+
+// postBot posts a message to a group robot
+func postBot(ctx context.Context, target string, message interface{}) error {
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	var reply botReply
+	if err := postJSON(ctx, target, payload, nil, &reply); err != nil {
+		return err
+	}
+	return reply.err()
+}
+
+// botMarkdown renders a notification as the Markdown the robots display
+func botMarkdown(n *Notification) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "### %s\n\n%s\n\n", n.Title, n.Message)
+	if n.KeyID != "" {
+		fmt.Fprintf(&b, "- Key: %s\n", n.KeyID)
+	}
+	fmt.Fprintf(&b, "- Event: %s\n- Time: %s", n.Event, n.Time.Format("2006-01-02 15:04:05"))
+	return b.String()
+}
+
+// botText renders a notification as plain text
+func botText(n *Notification) string {
+	text := fmt.Sprintf("%s\n%s", n.Title, n.Message)
+	if n.KeyID != "" {
+		text += "\nKey: " + n.KeyID
+	}
+	return text + fmt.Sprintf("\nEvent: %s\nTime: %s", n.Event, n.Time.Format("2006-01-02 15:04:05"))
+}
+
+// dingTalkNotifier posts to a DingTalk group robot; with a secret the
+// robot's "加签" security setting is used
+type dingTalkNotifier struct {
+	url    string
+	secret string
+}
+
+func newDingTalkNotifier(channel *models.NotificationChannel) (Notifier, error) {
+	if err := checkHTTPURL(channel.URL); err != nil {
+		return nil, err
+	}
+	return &dingTalkNotifier{url: channel.URL, secret: channel.Secret}, nil
+}
+
+// Send posts the notification as a Markdown message
+func (d *dingTalkNotifier) Send(ctx context.Context, n *Notification) error {
+	target := d.url
+	if d.secret != "" {
+		timestamp := strconv.FormatInt(time.Now().UnixMilli(), 10)
+		mac := hmac.New(sha256.New, []byte(d.secret))
+		mac.Write([]byte(timestamp + "\n" + d.secret))
+		sign := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+
+		separator := "?"
+		if strings.Contains(target, "?") {
+			separator = "&"
+		}
+		target += separator + "timestamp=" + timestamp + "&sign=" + url.QueryEscape(sign)
+	}
+
+	return postBot(ctx, target, map[string]interface{}{
+		"msgtype": "markdown",
+		"markdown": map[string]string{
+			"title": n.Title,
+			"text":  botMarkdown(n),
+		},
+	})
+}
+
+// weComNotifier posts to a WeCom (企业微信) group robot; its webhook URL
+// carries the robot's key, so there is nothing to sign
+type weComNotifier struct {
+	url string
+}
+
+func newWeComNotifier(channel *models.NotificationChannel) (Notifier, error) {
+	if err := checkHTTPURL(channel.URL); err != nil {
+		return nil, err
+	}
+	return &weComNotifier{url: channel.URL}, nil
+}
+
+// Send posts the notification as a Markdown message
+func (w *weComNotifier) Send(ctx context.Context, n *Notification) error {
+	return postBot(ctx, w.url, map[string]interface{}{
+		"msgtype": "markdown",
+		"markdown": map[string]string{
+			"content": botMarkdown(n),
+		},
+	})
+}
+
+// feishuNotifier posts to a Feishu (飞书) group robot; with a secret the
+// robot's "签名校验" security setting is used
+type feishuNotifier struct {
+	url    string
+	secret string
+}
+
+func newFeishuNotifier(channel *models.NotificationChannel) (Notifier, error) {
+	if err := checkHTTPURL(channel.URL); err != nil {
+		return nil, err
+	}
+	return &feishuNotifier{url: channel.URL, secret: channel.Secret}, nil
+}
+
+// Send posts the notification as a text message
+func (f *feishuNotifier) Send(ctx context.Context, n *Notification) error {
+	message := map[string]interface{}{
+		"msg_type": "text",
+		"content": map[string]string{
+			"text": botText(n),
+		},
+	}
+	if f.secret != "" {
+		// Feishu signs with timestamp + "\n" + secret as the key and an
+		// empty message
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		mac := hmac.New(sha256.New, []byte(timestamp+"\n"+f.secret))
+		message["timestamp"] = timestamp
+		message["sign"] = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	}
+	return postBot(ctx, f.url, message)
+}
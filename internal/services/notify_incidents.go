@@ -0,0 +1,200 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/droid-keyusage-go/internal/models"
+)
+
+func init() {
+	RegisterNotifier("pagerduty", newPagerDutyNotifier)
+	RegisterNotifier("opsgenie", newOpsgenieNotifier)
+}
+
+const (
+	pagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+	opsgenieAPIURL     = "https://api.opsgenie.com"
+	// incidentSource is reported as the source of incidents unless the
+	// channel sets the "source" option
+	incidentSource = "droid-keyusage"
+)
+
+// incidentAction tells whether a notification opens or closes an incident;
+// incident management channels only take critical alerts, everything else
+// (including test deliveries) is skipped
+func incidentAction(n *Notification) (action string, ok bool) {
+	if n.Severity != SeverityCritical || n.DedupKey == "" {
+		return "", false
+	}
+	switch n.Event {
+	case "alert.fired":
+		return "trigger", true
+	case "alert.resolved":
+		return "resolve", true
+	}
+	return "", false
+}
+
+// incidentDetails returns the fields attached to an incident
+func incidentDetails(n *Notification) map[string]interface{} {
+	details := make(map[string]interface{}, len(n.Data)+1)
+	for k, v := range n.Data {
+		details[k] = v
+	}
+	if n.KeyID != "" {
+		details["key_id"] = n.KeyID
+	}
+	return details
+}
+
+// incidentURL returns the channel URL, or fallback when it is not set
+func incidentURL(channel *models.NotificationChannel, fallback string) (string, error) {
+	if channel.URL == "" {
+		return fallback, nil
+	}
+	if err := checkHTTPURL(channel.URL); err != nil {
+		return "", err
+	}
+	return channel.URL, nil
+}
+
+// incidentSourceOf returns the source the channel reports incidents from
+func incidentSourceOf(channel *models.NotificationChannel) string {
+	if source := channel.Options["source"]; source != "" {
+		return source
+	}
+	return incidentSource
+}
+
+// pagerDutyNotifier opens and resolves PagerDuty incidents through the
+// Events API v2; the secret is the integration's routing key
+type pagerDutyNotifier struct {
+	url        string
+	routingKey string
+	source     string
+}
+
+func newPagerDutyNotifier(channel *models.NotificationChannel) (Notifier, error) {
+	if channel.Secret == "" {
+		return nil, errors.New("secret must be the PagerDuty routing key")
+	}
+	target, err := incidentURL(channel, pagerDutyEventsURL)
+	if err != nil {
+		return nil, err
+	}
+	return &pagerDutyNotifier{url: target, routingKey: channel.Secret, source: incidentSourceOf(channel)}, nil
+}
+
+// Send triggers or resolves the incident of a critical alert
+func (p *pagerDutyNotifier) Send(ctx context.Context, n *Notification) error {
+	action, ok := incidentAction(n)
+	if !ok {
+		return nil
+	}
+
+	event := map[string]interface{}{
+		"routing_key":  p.routingKey,
+		"event_action": action,
+		"dedup_key":    n.DedupKey,
+	}
+	if action == "trigger" {
+		event["payload"] = map[string]interface{}{
+			"summary":        n.Title + ": " + n.Message,
+			"source":         p.source,
+			"severity":       "critical",
+			"timestamp":      n.Time.Format(time.RFC3339),
+			"custom_details": incidentDetails(n),
+		}
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return postJSON(ctx, p.url, payload, nil, nil)
+}
+
+// opsgenieNotifier creates and closes Opsgenie alerts through the Alert
+// API; the secret is an API integration key and the URL selects the
+// region (https://api.eu.opsgenie.com for the EU instance)
+type opsgenieNotifier struct {
+	url    string
+	apiKey string
+	source string
+}
+
+func newOpsgenieNotifier(channel *models.NotificationChannel) (Notifier, error) {
+	if channel.Secret == "" {
+		return nil, errors.New("secret must be the Opsgenie API key")
+	}
+	target, err := incidentURL(channel, opsgenieAPIURL)
+	if err != nil {
+		return nil, err
+	}
+	return &opsgenieNotifier{
+		url:    strings.TrimRight(target, "/"),
+		apiKey: channel.Secret,
+		source: incidentSourceOf(channel),
+	}, nil
+}
+
+// Send creates or closes the Opsgenie alert of a critical alert, using the
+// dedup key as the alias so repeated triggers update one alert
+func (o *opsgenieNotifier) Send(ctx context.Context, n *Notification) error {
+	action, ok := incidentAction(n)
+	if !ok {
+		return nil
+	}
+
+	target := o.url + "/v2/alerts"
+	var body map[string]interface{}
+	if action == "trigger" {
+		// Opsgenie rejects messages longer than 130 characters
+		message := []rune(n.Title + ": " + n.Message)
+		if len(message) > 130 {
+			message = message[:130]
+		}
+		body = map[string]interface{}{
+			"message":     string(message),
+			"alias":       n.DedupKey,
+			"description": n.Message,
+			"priority":    "P1",
+			"source":      o.source,
+			"details":     opsgenieDetails(n),
+		}
+	} else {
+		target += "/" + url.PathEscape(n.DedupKey) + "/close?identifierType=alias"
+		body = map[string]interface{}{
+			"source": o.source,
+			"note":   n.Message,
+		}
+	}
+
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	header := http.Header{}
+	header.Set("Authorization", "GenieKey "+o.apiKey)
+	return postJSON(ctx, target, payload, header, nil)
+}
+
+// opsgenieDetails returns the alert details, which Opsgenie only accepts as
+// strings
+func opsgenieDetails(n *Notification) map[string]string {
+	details := make(map[string]string)
+	for k, v := range incidentDetails(n) {
+		if s, ok := v.(string); ok {
+			details[k] = s
+		} else if data, err := json.Marshal(v); err == nil {
+			details[k] = string(data)
+		}
+	}
+	return details
+}
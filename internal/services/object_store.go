@@ -0,0 +1,150 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// ObjectStoreConfig describes an S3-compatible bucket (AWS S3, MinIO, R2, ...)
+type ObjectStoreConfig struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	Prefix    string
+}
+
+// ObjectStore uploads objects to an S3-compatible bucket using path-style
+// URLs and AWS Signature Version 4
+type ObjectStore struct {
+	endpoint *url.URL
+	cfg      ObjectStoreConfig
+	client   *http.Client
+}
+
+// NewObjectStore creates an object store; it returns nil when no endpoint or
+// bucket is configured, which turns uploads off
+func NewObjectStore(cfg ObjectStoreConfig) (*ObjectStore, error) {
+	if cfg.Endpoint == "" || cfg.Bucket == "" {
+		return nil, nil
+	}
+	endpoint, err := url.Parse(strings.TrimRight(cfg.Endpoint, "/"))
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid S3 endpoint %q", cfg.Endpoint)
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	cfg.Prefix = strings.Trim(cfg.Prefix, "/")
+
+	return &ObjectStore{
+		endpoint: endpoint,
+		cfg:      cfg,
+		client:   &http.Client{Timeout: 60 * time.Second},
+	}, nil
+}
+
+// Put uploads an object under the configured prefix
+func (o *ObjectStore) Put(ctx context.Context, name, contentType string, body []byte) error {
+	objectPath := "/" + o.cfg.Bucket + "/" + path.Join(o.cfg.Prefix, name)
+	u := *o.endpoint
+	u.Path = strings.TrimRight(u.Path, "/") + objectPath
+	u.RawPath = s3Escape(u.Path)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	o.sign(req, body, time.Now().UTC())
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("upload %s: status %d: %s", name, resp.StatusCode, strings.TrimSpace(string(detail)))
+	}
+	return nil
+}
+
+// sign adds an AWS Signature Version 4 authorization header
+func (o *ObjectStore) sign(req *http.Request, body []byte, now time.Time) {
+	payloadHash := sha256Hex(body)
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		"",
+		"host:" + req.URL.Host,
+		"x-amz-content-sha256:" + payloadHash,
+		"x-amz-date:" + amzDate,
+		"",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + o.cfg.Region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+o.cfg.SecretKey), date)
+	key = hmacSHA256(key, o.cfg.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		o.cfg.AccessKey, scope, signedHeaders, signature))
+}
+
+// s3Escape URI-encodes a path the way Signature Version 4 expects: every
+// byte except unreserved characters and slashes is percent-encoded
+func s3Escape(p string) string {
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		if c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
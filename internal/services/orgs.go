@@ -0,0 +1,83 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+
+	"github.com/droid-keyusage-go/internal/models"
+	"github.com/droid-keyusage-go/internal/storage"
+)
+
+// orgID identifies the organization a successfully fetched key belongs to.
+// Providers report the usage of the whole organization for every key, so
+// keys of the same organization share the provider, billing period,
+// allowance and tokens used; a key that has used nothing yet can't be told
+// apart from an unrelated organization and is treated as its own.
+func orgID(key *storage.APIKey, usage *models.Usage) string {
+	fingerprint := fmt.Sprintf("%s|%s|%s|%g|%g", providerName(key),
+		usage.StartDate, usage.EndDate, usage.TotalAllowance, usage.OrgTotalUsed)
+	if usage.OrgTotalUsed == 0 {
+		fingerprint += "|" + usage.ID
+	}
+	sum := sha256.Sum256([]byte(fingerprint))
+	return "org-" + hex.EncodeToString(sum[:6])
+}
+
+// Orgs groups the keys p may see by detected organization, the most used
+// organization first. Keys whose usage could not be fetched, and disabled
+// or expired keys, belong to no organization and are left out.
+func (s *APIKeyService) Orgs(ctx context.Context, p Principal) ([]*models.OrgSummary, error) {
+	data, err := s.GetAggregatedData(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := s.store.WithContext(ctx).GetAllAPIKeys()
+	if err != nil {
+		return nil, err
+	}
+	keyMap := make(map[string]*storage.APIKey, len(keys))
+	for _, key := range keys {
+		keyMap[key.ID] = key
+	}
+
+	orgs := make([]*models.OrgSummary, 0)
+	byID := make(map[string]*models.OrgSummary)
+	for _, usage := range data.Data {
+		key := keyMap[usage.ID]
+		if usage.OrgID == "" || key == nil {
+			continue
+		}
+		org, ok := byID[usage.OrgID]
+		if !ok {
+			org = &models.OrgSummary{
+				ID:             usage.OrgID,
+				Provider:       providerName(key),
+				StartDate:      usage.StartDate,
+				EndDate:        usage.EndDate,
+				TotalAllowance: usage.TotalAllowance,
+				TotalUsed:      usage.OrgTotalUsed,
+				Remaining:      usage.Remaining,
+				UsedRatio:      usage.UsedRatio,
+				Keys:           make([]*models.OrgMember, 0, 1),
+			}
+			byID[usage.OrgID] = org
+			orgs = append(orgs, org)
+		}
+		org.KeyCount++
+		org.Keys = append(org.Keys, &models.OrgMember{ID: key.ID, Name: key.Name, Key: usage.Key})
+	}
+
+	sort.Slice(orgs, func(i, j int) bool {
+		if orgs[i].TotalUsed != orgs[j].TotalUsed {
+			return orgs[i].TotalUsed > orgs[j].TotalUsed
+		}
+		return orgs[i].ID < orgs[j].ID
+	})
+	for _, org := range orgs {
+		sort.Slice(org.Keys, func(i, j int) bool { return org.Keys[i].Name < org.Keys[j].Name })
+	}
+	return orgs, nil
+}
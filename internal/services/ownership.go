@@ -0,0 +1,146 @@
+package services
+
+import (
+	"fmt"
+
+	"github.com/droid-keyusage-go/internal/models"
+	"github.com/droid-keyusage-go/internal/storage"
+)
+
+// Key visibility modes
+const (
+	// VisibilityAll lets every signed-in user see every key
+	VisibilityAll = "all"
+	// VisibilityOwner limits non-admin users to the keys they own
+	VisibilityOwner = "owner"
+)
+
+// Principal identifies who a request is made for; User is empty for logins
+// with the shared admin or viewer password. Scopes limits a bearer token to
+// some operations; nil allows everything the role allows.
+type Principal struct {
+	User   string
+	Role   string
+	Scopes []string
+}
+
+// HasScope reports whether the principal may perform operations of scope
+func (p Principal) HasScope(scope string) bool {
+	if p.Scopes == nil {
+		return true
+	}
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// adminPrincipal is used for internal work that must see every key
+var adminPrincipal = Principal{Role: RoleAdmin}
+
+// SetVisibility sets whether non-admin users see every key (VisibilityAll)
+// or only the keys they own (VisibilityOwner)
+func (s *APIKeyService) SetVisibility(mode string) {
+	if mode != VisibilityAll && mode != VisibilityOwner {
+		fmt.Fprintf(console, "⚠️  未知的 Key 可见范围 %q，已使用 %s\n", mode, VisibilityAll)
+		mode = VisibilityAll
+	}
+	s.settingsMu.Lock()
+	s.ownerOnly = mode == VisibilityOwner
+	s.settingsMu.Unlock()
+}
+
+// restricted reports whether the principal only sees the keys it owns
+func (s *APIKeyService) restricted(p Principal) bool {
+	s.settingsMu.RLock()
+	defer s.settingsMu.RUnlock()
+	return s.ownerOnly && p.Role != RoleAdmin
+}
+
+// LimitedToOwnKeys reports whether the principal only sees the keys it
+// owns, and therefore none of the deployment-wide aggregates
+func (s *APIKeyService) LimitedToOwnKeys(p Principal) bool {
+	return s.restricted(p)
+}
+
+// canSee reports whether the principal may see a key; users without a name
+// own nothing, so they see no keys while visibility is restricted
+func (s *APIKeyService) canSee(key *storage.APIKey, p Principal) bool {
+	return !s.restricted(p) || (p.User != "" && key.Owner == p.User)
+}
+
+// visibleKeys returns the keys the principal may see
+func (s *APIKeyService) visibleKeys(keys []*storage.APIKey, p Principal) []*storage.APIKey {
+	if !s.restricted(p) {
+		return keys
+	}
+	visible := make([]*storage.APIKey, 0, len(keys))
+	for _, key := range keys {
+		if s.canSee(key, p) {
+			visible = append(visible, key)
+		}
+	}
+	return visible
+}
+
+// visibleResults returns the results belonging to the given keys
+func visibleResults(keys []*storage.APIKey, results []*models.Usage) []*models.Usage {
+	ids := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		ids[key.ID] = true
+	}
+	visible := make([]*models.Usage, 0, len(keys))
+	for _, usage := range results {
+		if ids[usage.ID] {
+			visible = append(visible, usage)
+		}
+	}
+	return visible
+}
+
+// getVisibleKey loads a key, reporting keys the principal may not see as
+// not found so their existence is not revealed
+func (s *APIKeyService) getVisibleKey(id string, p Principal) (*storage.APIKey, error) {
+	key, err := s.store.GetAPIKey(id)
+	if err != nil {
+		return nil, err
+	}
+	if key == nil || !s.canSee(key, p) {
+		return nil, ErrKeyNotFound
+	}
+	return key, nil
+}
+
+// keyOwner returns the owner recorded on keys created by the principal:
+// admins may assign any owner, everyone else owns what they create
+func keyOwner(requested string, p Principal) string {
+	if p.Role == RoleAdmin {
+		return requested
+	}
+	return p.User
+}
+
+// HiddenKeyIDs returns the IDs among ids of keys the principal may not see,
+// including IDs of no key; nil when the principal sees every key
+func (s *APIKeyService) HiddenKeyIDs(ids []string, p Principal) (map[string]bool, error) {
+	if !s.restricted(p) {
+		return nil, nil
+	}
+	keys, err := s.store.GetAllAPIKeys()
+	if err != nil {
+		return nil, err
+	}
+	visible := make(map[string]bool, len(keys))
+	for _, key := range s.visibleKeys(keys, p) {
+		visible[key.ID] = true
+	}
+	hidden := make(map[string]bool)
+	for _, id := range ids {
+		if !visible[id] {
+			hidden[id] = true
+		}
+	}
+	return hidden, nil
+}
@@ -0,0 +1,365 @@
+package services
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// parquetMagic starts and ends every Parquet file
+const parquetMagic = "PAR1"
+
+// parquetRowGroupRows is how many rows are buffered before a row group is
+// written out
+const parquetRowGroupRows = 65536
+
+// Column types supported by parquetWriter
+const (
+	parquetInt64 = iota
+	parquetDouble
+	parquetString
+	parquetTimestamp
+)
+
+// Parquet physical types, encodings and codecs (parquet.thrift)
+const (
+	parquetTypeInt64     = 2
+	parquetTypeDouble    = 5
+	parquetTypeByteArray = 6
+
+	parquetEncodingPlain = 0
+	parquetEncodingRLE   = 3
+
+	parquetCodecGzip = 2
+
+	parquetConvertedUTF8            = 0
+	parquetConvertedTimestampMillis = 9
+)
+
+// parquetColumn is a required, non-nested column
+type parquetColumn struct {
+	name string
+	kind int
+}
+
+// physicalType returns the Parquet type the column is stored as
+func (c parquetColumn) physicalType() int32 {
+	switch c.kind {
+	case parquetDouble:
+		return parquetTypeDouble
+	case parquetString:
+		return parquetTypeByteArray
+	default:
+		return parquetTypeInt64
+	}
+}
+
+// parquetChunk locates a written column chunk for the file footer; size
+// is what it takes in the file, uncompressedSize what it would take with
+// its pages uncompressed
+type parquetChunk struct {
+	offset           int64
+	size             int64
+	uncompressedSize int64
+}
+
+// parquetRowGroup describes a written row group for the file footer; size
+// is the uncompressed size of its column chunks
+type parquetRowGroup struct {
+	rows   int64
+	size   int64
+	chunks []parquetChunk
+}
+
+// parquetWriter writes a flat table as a Parquet file. Every column is
+// required and PLAIN-encoded, and each row group holds one gzip-compressed
+// page per column, which every Parquet reader understands.
+type parquetWriter struct {
+	w       io.Writer
+	offset  int64
+	columns []parquetColumn
+	values  []bytes.Buffer
+	rows    int64
+	groups  []parquetRowGroup
+	err     error
+}
+
+// newParquetWriter starts a Parquet file with the given columns
+func newParquetWriter(w io.Writer, columns []parquetColumn) *parquetWriter {
+	p := &parquetWriter{
+		w:       w,
+		columns: columns,
+		values:  make([]bytes.Buffer, len(columns)),
+	}
+	p.write([]byte(parquetMagic))
+	return p
+}
+
+// WriteRow appends a row; values must match the columns in order, as
+// int64, float64, string or time.Time
+func (p *parquetWriter) WriteRow(values ...interface{}) error {
+	if len(values) != len(p.columns) {
+		return fmt.Errorf("parquet: got %d values for %d columns", len(values), len(p.columns))
+	}
+	for i, column := range p.columns {
+		buf := &p.values[i]
+		switch column.kind {
+		case parquetInt64:
+			binary.Write(buf, binary.LittleEndian, values[i].(int64))
+		case parquetDouble:
+			binary.Write(buf, binary.LittleEndian, math.Float64bits(values[i].(float64)))
+		case parquetString:
+			s := values[i].(string)
+			binary.Write(buf, binary.LittleEndian, uint32(len(s)))
+			buf.WriteString(s)
+		case parquetTimestamp:
+			binary.Write(buf, binary.LittleEndian, values[i].(time.Time).UnixMilli())
+		}
+	}
+	p.rows++
+	if p.rows >= parquetRowGroupRows {
+		p.flush()
+	}
+	return p.err
+}
+
+// Close writes the buffered rows and the file footer
+func (p *parquetWriter) Close() error {
+	p.flush()
+
+	var meta thriftWriter
+	meta.i32Field(1, 1) // version
+	meta.listField(2, thriftStruct, len(p.columns)+1)
+	meta.begin()
+	meta.binaryField(4, "schema")
+	meta.i32Field(5, int32(len(p.columns)))
+	meta.end()
+	for _, column := range p.columns {
+		meta.begin()
+		meta.i32Field(1, column.physicalType())
+		meta.i32Field(3, 0) // required
+		meta.binaryField(4, column.name)
+		switch column.kind {
+		case parquetString:
+			meta.i32Field(6, parquetConvertedUTF8)
+			meta.structField(10) // logical type: string
+			meta.structField(1)
+			meta.end()
+			meta.end()
+		case parquetTimestamp:
+			meta.i32Field(6, parquetConvertedTimestampMillis)
+			meta.structField(10) // logical type: timestamp
+			meta.structField(8)
+			meta.boolField(1, true) // adjusted to UTC
+			meta.structField(2)     // unit
+			meta.structField(1)     // milliseconds
+			meta.end()
+			meta.end()
+			meta.end()
+			meta.end()
+		}
+		meta.end()
+	}
+
+	var total int64
+	for _, group := range p.groups {
+		total += group.rows
+	}
+	meta.i64Field(3, total)
+	meta.listField(4, thriftStruct, len(p.groups))
+	for _, group := range p.groups {
+		meta.begin()
+		meta.listField(1, thriftStruct, len(group.chunks))
+		for i, chunk := range group.chunks {
+			meta.begin()
+			meta.i64Field(2, chunk.offset)
+			meta.structField(3)
+			meta.i32Field(1, p.columns[i].physicalType())
+			meta.listField(2, thriftI32, 1)
+			meta.zigzag(parquetEncodingPlain)
+			meta.listField(3, thriftBinary, 1)
+			meta.binary(p.columns[i].name)
+			meta.i32Field(4, parquetCodecGzip)
+			meta.i64Field(5, group.rows)
+			meta.i64Field(6, chunk.uncompressedSize)
+			meta.i64Field(7, chunk.size)
+			meta.i64Field(9, chunk.offset)
+			meta.end()
+			meta.end()
+		}
+		meta.i64Field(2, group.size)
+		meta.i64Field(3, group.rows)
+		meta.end()
+	}
+	meta.binaryField(6, "droid-keyusage")
+	meta.end()
+
+	p.write(meta.buf.Bytes())
+	var length [4]byte
+	binary.LittleEndian.PutUint32(length[:], uint32(meta.buf.Len()))
+	p.write(length[:])
+	p.write([]byte(parquetMagic))
+	return p.err
+}
+
+// flush writes the buffered rows as a row group
+func (p *parquetWriter) flush() {
+	if p.rows == 0 || p.err != nil {
+		return
+	}
+
+	group := parquetRowGroup{rows: p.rows}
+	for i := range p.values {
+		var compressed bytes.Buffer
+		zw := gzip.NewWriter(&compressed)
+		zw.Write(p.values[i].Bytes())
+		if err := zw.Close(); err != nil {
+			p.err = err
+			return
+		}
+
+		var header thriftWriter
+		header.i32Field(1, 0) // data page
+		header.i32Field(2, int32(p.values[i].Len()))
+		header.i32Field(3, int32(compressed.Len()))
+		header.structField(5)
+		header.i32Field(1, int32(p.rows))
+		header.i32Field(2, parquetEncodingPlain)
+		header.i32Field(3, parquetEncodingRLE)
+		header.i32Field(4, parquetEncodingRLE)
+		header.end()
+		header.end()
+
+		chunk := parquetChunk{
+			offset:           p.offset,
+			size:             int64(header.buf.Len() + compressed.Len()),
+			uncompressedSize: int64(header.buf.Len() + p.values[i].Len()),
+		}
+		p.write(header.buf.Bytes())
+		p.write(compressed.Bytes())
+		group.chunks = append(group.chunks, chunk)
+		group.size += chunk.uncompressedSize
+		p.values[i].Reset()
+	}
+	p.groups = append(p.groups, group)
+	p.rows = 0
+}
+
+func (p *parquetWriter) write(b []byte) {
+	if p.err != nil {
+		return
+	}
+	n, err := p.w.Write(b)
+	p.offset += int64(n)
+	p.err = err
+}
+
+// Thrift compact protocol types
+const (
+	thriftTrue   = 1
+	thriftFalse  = 2
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter encodes the Thrift compact protocol used by Parquet
+// metadata. The top-level struct is open from the start; nested structs
+// and struct list elements are opened with begin (or structField) and
+// closed with end.
+type thriftWriter struct {
+	buf bytes.Buffer
+	// current is the previous field ID of the innermost open struct and
+	// outer those of the structs around it
+	current int16
+	outer   []int16
+}
+
+func (t *thriftWriter) field(id int16, typ byte) {
+	if delta := id - t.current; delta > 0 && delta <= 15 {
+		t.buf.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		t.buf.WriteByte(typ)
+		t.zigzag(int64(id))
+	}
+	t.current = id
+}
+
+// begin opens a struct, such as an element of a list of structs
+func (t *thriftWriter) begin() {
+	t.outer = append(t.outer, t.current)
+	t.current = 0
+}
+
+// end closes the innermost open struct
+func (t *thriftWriter) end() {
+	t.buf.WriteByte(0)
+	if n := len(t.outer); n > 0 {
+		t.current = t.outer[n-1]
+		t.outer = t.outer[:n-1]
+	}
+}
+
+func (t *thriftWriter) i32Field(id int16, v int32) {
+	t.field(id, thriftI32)
+	t.zigzag(int64(v))
+}
+
+func (t *thriftWriter) i64Field(id int16, v int64) {
+	t.field(id, thriftI64)
+	t.zigzag(v)
+}
+
+func (t *thriftWriter) boolField(id int16, v bool) {
+	if v {
+		t.field(id, thriftTrue)
+	} else {
+		t.field(id, thriftFalse)
+	}
+}
+
+func (t *thriftWriter) binaryField(id int16, s string) {
+	t.field(id, thriftBinary)
+	t.binary(s)
+}
+
+// structField starts a struct-valued field; close it with end
+func (t *thriftWriter) structField(id int16) {
+	t.field(id, thriftStruct)
+	t.begin()
+}
+
+// listField starts a list-valued field of size elements, which follow
+// as bare values or as structs opened with begin
+func (t *thriftWriter) listField(id int16, elem byte, size int) {
+	t.field(id, thriftList)
+	if size < 15 {
+		t.buf.WriteByte(byte(size)<<4 | elem)
+	} else {
+		t.buf.WriteByte(0xf0 | elem)
+		t.varint(uint64(size))
+	}
+}
+
+func (t *thriftWriter) binary(s string) {
+	t.varint(uint64(len(s)))
+	t.buf.WriteString(s)
+}
+
+func (t *thriftWriter) zigzag(v int64) {
+	t.varint(uint64(v<<1) ^ uint64(v>>63))
+}
+
+func (t *thriftWriter) varint(v uint64) {
+	for v >= 0x80 {
+		t.buf.WriteByte(byte(v) | 0x80)
+		v >>= 7
+	}
+	t.buf.WriteByte(byte(v))
+}
@@ -0,0 +1,100 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+func TestParquetRoundTrip(t *testing.T) {
+	columns := []parquetColumn{
+		{"time", parquetTimestamp},
+		{"key_id", parquetString},
+		{"used", parquetDouble},
+		{"latency_ms", parquetInt64},
+		{"error", parquetString},
+	}
+	// More rows than fit one row group, so the file has two
+	rows := parquetRowGroupRows + 100
+	base := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	var buf bytes.Buffer
+	w := newParquetWriter(&buf, columns)
+	for i := 0; i < rows; i++ {
+		if err := w.WriteRow(base.Add(time.Duration(i)*time.Minute), fmt.Sprintf("key-%d", i%7), float64(i)/2, int64(i), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := parquet.OpenFile(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if file.NumRows() != int64(rows) {
+		t.Fatalf("file has %d rows, want %d", file.NumRows(), rows)
+	}
+	fields := file.Schema().Fields()
+	for i, column := range columns {
+		if fields[i].Name() != column.name {
+			t.Errorf("column %d is %q, want %q", i, fields[i].Name(), column.name)
+		}
+	}
+
+	// The error column is all empty strings, which compress well: its
+	// uncompressed size must be reported as such
+	groups := file.Metadata().RowGroups
+	if len(groups) != 2 {
+		t.Fatalf("file has %d row groups, want 2", len(groups))
+	}
+	for _, group := range groups {
+		var total int64
+		for _, chunk := range group.Columns {
+			total += chunk.MetaData.TotalUncompressedSize
+		}
+		if group.TotalByteSize != total {
+			t.Errorf("row group size %d, want the %d bytes of its uncompressed chunks", group.TotalByteSize, total)
+		}
+		errors := group.Columns[4].MetaData
+		if errors.TotalUncompressedSize <= errors.TotalCompressedSize {
+			t.Errorf("error column: uncompressed size %d, compressed %d", errors.TotalUncompressedSize, errors.TotalCompressedSize)
+		}
+	}
+
+	reader := parquet.NewReader(bytes.NewReader(buf.Bytes()))
+	defer reader.Close()
+	read := make([]parquet.Row, 1000)
+	for i := 0; i < rows; {
+		n, err := reader.ReadRows(read)
+		for _, row := range read[:n] {
+			if got := row[0].Int64(); got != base.Add(time.Duration(i)*time.Minute).UnixMilli() {
+				t.Fatalf("row %d: time %d", i, got)
+			}
+			if got := string(row[1].ByteArray()); got != fmt.Sprintf("key-%d", i%7) {
+				t.Fatalf("row %d: key_id %q", i, got)
+			}
+			if got := row[2].Double(); got != float64(i)/2 {
+				t.Fatalf("row %d: used %v", i, got)
+			}
+			if got := row[3].Int64(); got != int64(i) {
+				t.Fatalf("row %d: latency_ms %d", i, got)
+			}
+			i++
+		}
+		if err == io.EOF {
+			if i != rows {
+				t.Fatalf("read %d rows, want %d", i, rows)
+			}
+			break
+		}
+		if err != nil {
+			t.Fatalf("read row %d: %v", i, err)
+		}
+	}
+}
@@ -0,0 +1,350 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/droid-keyusage-go/internal/models"
+	"github.com/droid-keyusage-go/plugin"
+)
+
+// pluginCallTimeout bounds a plugin call, unless its context ends sooner
+const pluginCallTimeout = 10 * time.Second
+
+// ParsePlugins splits a comma-separated list of plugin executables such as
+// PLUGINS; an empty spec means none
+func ParsePlugins(spec string) []string {
+	var paths []string
+	for _, path := range strings.Split(spec, ",") {
+		if path = strings.TrimSpace(path); path != "" {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// Plugins are the running plugin processes
+type Plugins struct {
+	processes []*pluginProcess
+}
+
+// LoadPlugins starts each plugin executable and registers the providers
+// and channel types it provides. A name already taken by a built-in or by
+// another plugin is an error, and so is a plugin that doesn't start; the
+// plugins started so far are stopped then.
+func LoadPlugins(paths []string) (*Plugins, error) {
+	plugins := &Plugins{}
+	for _, path := range paths {
+		p := &pluginProcess{path: path}
+		if err := p.start(); err != nil {
+			plugins.Close()
+			return nil, fmt.Errorf("plugin %s: %w", path, err)
+		}
+		plugins.processes = append(plugins.processes, p)
+
+		for _, name := range p.manifest.Providers {
+			if _, ok := providers[name]; ok {
+				plugins.Close()
+				return nil, fmt.Errorf("plugin %s: provider %s already exists", path, name)
+			}
+			RegisterProvider(&pluginProvider{name: name, process: p})
+		}
+		for _, kind := range p.manifest.Notifiers {
+			if _, ok := notifierTypes[kind]; ok {
+				plugins.Close()
+				return nil, fmt.Errorf("plugin %s: channel type %s already exists", path, kind)
+			}
+			RegisterNotifier(kind, pluginNotifierFactory(p))
+		}
+	}
+	return plugins, nil
+}
+
+// Manifests describes the loaded plugins
+func (ps *Plugins) Manifests() []plugin.Manifest {
+	manifests := make([]plugin.Manifest, len(ps.processes))
+	for i, p := range ps.processes {
+		manifests[i] = p.manifest
+	}
+	return manifests
+}
+
+// Close stops the plugin processes
+func (ps *Plugins) Close() {
+	for _, p := range ps.processes {
+		p.stop()
+	}
+}
+
+// pluginProcess is a running plugin. A plugin that exits is started again
+// by the next call.
+type pluginProcess struct {
+	path     string
+	manifest plugin.Manifest
+
+	mu     sync.Mutex
+	cmd    *exec.Cmd
+	client *rpc.Client
+	exited chan struct{}
+	closed bool
+}
+
+// start runs the plugin and reads its manifest
+func (p *pluginProcess) start() error {
+	client, err := p.connect()
+	if err != nil {
+		return err
+	}
+
+	var manifest plugin.Manifest
+	if err := p.callClient(context.Background(), client, "Manifest", plugin.Empty{}, &manifest, pluginCallTimeout); err != nil {
+		p.stop()
+		return fmt.Errorf("manifest: %w", err)
+	}
+	if manifest.ProtocolVersion != plugin.ProtocolVersion {
+		p.stop()
+		return fmt.Errorf("protocol version %d, expected %d", manifest.ProtocolVersion, plugin.ProtocolVersion)
+	}
+	if manifest.Name == "" {
+		manifest.Name = filepath.Base(p.path)
+	}
+	p.manifest = manifest
+	return nil
+}
+
+// connect starts the plugin process, unless it is running, and returns
+// its RPC client
+func (p *pluginProcess) connect() (*rpc.Client, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil, errors.New("plugins are stopped")
+	}
+	if p.client != nil {
+		select {
+		case <-p.exited:
+			fmt.Fprintf(console, "⚠️  插件 %s 已退出，重新启动\n", p.path)
+			p.client.Close()
+		default:
+			return p.client, nil
+		}
+	}
+
+	cmd := exec.Command(p.path)
+	cmd.Env = append(os.Environ(), plugin.MagicEnv+"="+plugin.MagicValue)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	exited := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(exited)
+	}()
+	p.cmd = cmd
+	p.exited = exited
+	p.client = jsonrpc.NewClient(pluginConn{Reader: stdout, WriteCloser: stdin})
+	return p.client, nil
+}
+
+// call calls a method of the plugin, giving up when ctx ends or after
+// timeout
+func (p *pluginProcess) call(ctx context.Context, method string, args, reply interface{}, timeout time.Duration) error {
+	client, err := p.connect()
+	if err != nil {
+		return fmt.Errorf("plugin %s: %w", p.manifest.Name, err)
+	}
+	return p.callClient(ctx, client, method, args, reply, timeout)
+}
+
+func (p *pluginProcess) callClient(ctx context.Context, client *rpc.Client, method string, args, reply interface{}, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	call := client.Go(plugin.ServiceName+"."+method, args, reply, make(chan *rpc.Call, 1))
+	select {
+	case <-call.Done:
+		return call.Error
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// stop ends the plugin process; it is not started again
+func (p *pluginProcess) stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	if p.client == nil {
+		return
+	}
+	// Closing stdin ends Serve; a plugin still running after that is killed
+	p.client.Close()
+	select {
+	case <-p.exited:
+	case <-time.After(2 * time.Second):
+		p.cmd.Process.Kill()
+		<-p.exited
+	}
+}
+
+// pluginConn joins the plugin's stdout and stdin into one connection
+type pluginConn struct {
+	io.Reader
+	io.WriteCloser
+}
+
+// pluginProvider is a provider served by a plugin
+type pluginProvider struct {
+	name    string
+	process *pluginProcess
+}
+
+// Name returns the provider identifier
+func (p *pluginProvider) Name() string {
+	return p.name
+}
+
+// NewUsageRequest builds the usage request the plugin describes
+func (p *pluginProvider) NewUsageRequest(ctx context.Context) (*http.Request, error) {
+	var desc plugin.Request
+	if err := p.process.call(ctx, "UsageRequest", plugin.UsageRequestArgs{Provider: p.name}, &desc, pluginCallTimeout); err != nil {
+		return nil, fmt.Errorf("plugin %s: %w", p.process.manifest.Name, err)
+	}
+
+	method := desc.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	var body io.Reader
+	if len(desc.Body) > 0 {
+		body = bytes.NewReader(desc.Body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, desc.URL, body)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range desc.Header {
+		req.Header.Set(name, value)
+	}
+	return req, nil
+}
+
+// ParseUsage has the plugin decode a usage response
+func (p *pluginProvider) ParseUsage(id string, body []byte) (*models.Usage, error) {
+	var parsed plugin.Usage
+	if err := p.process.call(context.Background(), "ParseUsage", plugin.ParseUsageArgs{Provider: p.name, Body: body}, &parsed, pluginCallTimeout); err != nil {
+		return nil, err
+	}
+
+	usage := &models.Usage{
+		ID:             id,
+		StartDate:      parsed.StartDate,
+		EndDate:        parsed.EndDate,
+		TotalAllowance: parsed.TotalAllowance,
+		OrgTotalUsed:   parsed.OrgTotalUsed,
+		Remaining:      parsed.TotalAllowance - parsed.OrgTotalUsed,
+		UsedRatio:      parsed.UsedRatio,
+		LastUpdated:    time.Now(),
+		OrgID:          parsed.OrgID,
+		Blocks:         pluginBlocks(parsed.Blocks),
+		Partial:        parsed.Partial,
+		MissingFields:  parsed.MissingFields,
+	}
+	if usage.UsedRatio == 0 && usage.TotalAllowance > 0 {
+		usage.UsedRatio = usage.OrgTotalUsed / usage.TotalAllowance
+	}
+	return usage, nil
+}
+
+// pluginBlocks converts the usage blocks a plugin reported
+func pluginBlocks(blocks map[string]*plugin.UsageBlock) map[string]*models.UsageBlock {
+	if len(blocks) == 0 {
+		return nil
+	}
+	converted := make(map[string]*models.UsageBlock, len(blocks))
+	for name, block := range blocks {
+		if block == nil {
+			continue
+		}
+		converted[name] = &models.UsageBlock{
+			Used:      block.Used,
+			Allowance: block.Allowance,
+			UsedRatio: block.UsedRatio,
+			Other:     block.Other,
+			Breakdown: pluginBlocks(block.Breakdown),
+		}
+	}
+	return converted
+}
+
+// pluginNotifier delivers the notifications of one channel through a plugin
+type pluginNotifier struct {
+	process *pluginProcess
+	channel plugin.Channel
+}
+
+// pluginNotifierFactory builds the notifiers of the channel types served
+// by a plugin, which checks their configuration
+func pluginNotifierFactory(process *pluginProcess) NotifierFactory {
+	return func(channel *models.NotificationChannel) (Notifier, error) {
+		n := &pluginNotifier{
+			process: process,
+			channel: plugin.Channel{
+				Name:    channel.Name,
+				Type:    channel.Type,
+				URL:     channel.URL,
+				Secret:  channel.Secret,
+				Options: channel.Options,
+			},
+		}
+		if err := process.call(context.Background(), "CheckChannel", plugin.CheckChannelArgs{Channel: n.channel}, &plugin.Empty{}, pluginCallTimeout); err != nil {
+			return nil, err
+		}
+		return n, nil
+	}
+}
+
+// Send has the plugin deliver a notification
+func (n *pluginNotifier) Send(ctx context.Context, notification *Notification) error {
+	timeout := pluginCallTimeout
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+	}
+	args := plugin.SendArgs{
+		Channel: n.channel,
+		Notification: plugin.Notification{
+			Event:    notification.Event,
+			Title:    notification.Title,
+			Message:  notification.Message,
+			KeyID:    notification.KeyID,
+			Time:     notification.Time,
+			Data:     notification.Data,
+			Severity: notification.Severity,
+			DedupKey: notification.DedupKey,
+		},
+		Timeout: timeout.Milliseconds(),
+	}
+	return n.process.call(ctx, "Send", args, &plugin.Empty{}, timeout)
+}
@@ -0,0 +1,134 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/droid-keyusage-go/internal/models"
+	"github.com/droid-keyusage-go/internal/storage"
+)
+
+// Credential types describing how a key is presented to the upstream
+const (
+	CredentialBearer = "bearer"
+	CredentialBasic  = "basic"
+	CredentialHeader = "header"
+	CredentialQuery  = "query"
+)
+
+var (
+	// ErrUnknownProvider is returned for keys that reference an unregistered provider
+	ErrUnknownProvider = errors.New("unknown provider")
+	// ErrInvalidCredential is returned when a credential is missing required fields
+	ErrInvalidCredential = errors.New("invalid credential")
+)
+
+// Provider fetches usage for keys of one upstream service
+type Provider interface {
+	// Name is the identifier stored on keys
+	Name() string
+	// NewUsageRequest builds the usage request, without credentials applied
+	NewUsageRequest(ctx context.Context) (*http.Request, error)
+	// ParseUsage decodes a successful usage response body
+	ParseUsage(id string, body []byte) (*models.Usage, error)
+}
+
+// providers holds every registered provider by name
+var providers = map[string]Provider{}
+
+// RegisterProvider makes a provider available to keys by its name
+func RegisterProvider(provider Provider) {
+	providers[provider.Name()] = provider
+}
+
+// GetProvider looks up a provider by name, defaulting to the Factory.ai provider
+func GetProvider(name string) (Provider, error) {
+	if name == "" {
+		name = DefaultProvider
+	}
+	provider, ok := providers[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownProvider, name)
+	}
+	return provider, nil
+}
+
+// providerName returns the provider a key is fetched from
+func providerName(key *storage.APIKey) string {
+	if key == nil || key.Provider == "" {
+		return DefaultProvider
+	}
+	return key.Provider
+}
+
+// validateCredential checks that a credential has the fields its type requires
+func validateCredential(cred *storage.Credential) error {
+	if cred == nil {
+		return nil
+	}
+
+	switch cred.Type {
+	case "", CredentialBearer:
+	case CredentialBasic:
+		if cred.Username == "" {
+			return fmt.Errorf("%w: basic auth requires a username", ErrInvalidCredential)
+		}
+	case CredentialHeader, CredentialQuery:
+		if cred.Name == "" {
+			return fmt.Errorf("%w: %s credential requires a name", ErrInvalidCredential, cred.Type)
+		}
+	default:
+		return fmt.Errorf("%w: unsupported type %q", ErrInvalidCredential, cred.Type)
+	}
+	for name, value := range cred.Headers {
+		if err := checkSecretRef(value); err != nil {
+			return fmt.Errorf("%w: header %s: %v", ErrInvalidCredential, name, err)
+		}
+	}
+	return nil
+}
+
+// applyCredential attaches the key to an upstream request according to its credential type
+func applyCredential(req *http.Request, key *storage.APIKey) error {
+	cred := key.Credential
+	if cred == nil {
+		cred = &storage.Credential{}
+	}
+
+	switch cred.Type {
+	case "", CredentialBearer:
+		req.Header.Set("Authorization", "Bearer "+key.Key)
+	case CredentialBasic:
+		token := base64.StdEncoding.EncodeToString([]byte(cred.Username + ":" + key.Key))
+		req.Header.Set("Authorization", "Basic "+token)
+	case CredentialHeader:
+		req.Header.Set(cred.Name, key.Key)
+	case CredentialQuery:
+		query := req.URL.Query()
+		query.Set(cred.Name, key.Key)
+		req.URL.RawQuery = query.Encode()
+	default:
+		return fmt.Errorf("%w: unsupported type %q", ErrInvalidCredential, cred.Type)
+	}
+
+	for name, value := range cred.Headers {
+		if strings.EqualFold(name, "Authorization") && (cred.Type == "" || cred.Type == CredentialBearer || cred.Type == CredentialBasic) {
+			continue
+		}
+		// Values shared by many keys may live in the secret store; keys
+		// saved before the CREDENTIAL_ rule are checked here too
+		if err := checkSecretRef(value); err != nil {
+			return err
+		}
+		value, err := resolveSecretRef(value)
+		if err != nil {
+			return err
+		}
+		req.Header.Set(name, value)
+	}
+	return nil
+}
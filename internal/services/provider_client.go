@@ -0,0 +1,282 @@
+package services
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/droid-keyusage-go/internal/models"
+)
+
+// HTTPClientOptions tunes the HTTP client a provider is fetched with. Zero
+// fields take the value of the default options (HTTP_TIMEOUT and the
+// built-in pool sizes).
+type HTTPClientOptions struct {
+	// Timeout bounds a whole request, e.g. "20s"
+	Timeout string `json:"timeout,omitempty"`
+	// MaxConnsPerHost caps the connections to one host; 0 is unlimited
+	MaxConnsPerHost     int    `json:"max_conns_per_host,omitempty"`
+	MaxIdleConnsPerHost int    `json:"max_idle_conns_per_host,omitempty"`
+	IdleConnTimeout     string `json:"idle_conn_timeout,omitempty"`
+	// Proxy is an http(s) or socks5 proxy URL; empty connects directly
+	Proxy string `json:"proxy,omitempty"`
+	// CAFile is a PEM bundle trusted instead of the system roots
+	CAFile             string `json:"ca_file,omitempty"`
+	TLSServerName      string `json:"tls_server_name,omitempty"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify,omitempty"`
+}
+
+// Defaults of the provider HTTP clients; the idle connections kept per host
+// should match the number of workers, which all fetch from the same host
+const (
+	defaultClientTimeout       = 30 * time.Second
+	defaultMaxIdleConnsPerHost = 100
+	defaultIdleConnTimeout     = 90 * time.Second
+)
+
+var (
+	// providerClientsMu guards providerClients
+	providerClientsMu sync.RWMutex
+	// providerClients holds the HTTP client of each provider by name
+	providerClients = map[string]*http.Client{}
+	// defaultClient is used until ConfigureProviderClients is called
+	defaultClient = mustNewHTTPClient(HTTPClientOptions{})
+)
+
+// ParseProviderClients decodes a JSON object of HTTP client options by
+// provider name such as PROVIDER_HTTP_CLIENTS; an empty spec means none
+func ParseProviderClients(spec string) (map[string]HTTPClientOptions, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nil, nil
+	}
+
+	var options map[string]HTTPClientOptions
+	if err := json.Unmarshal([]byte(spec), &options); err != nil {
+		return nil, fmt.Errorf("invalid provider HTTP clients: %w", err)
+	}
+	for name := range options {
+		if _, err := GetProvider(name); err != nil {
+			return nil, err
+		}
+	}
+	return options, nil
+}
+
+// ConfigureProviderClients gives every registered provider its own HTTP
+// client, so a slow provider can't use up the connections of another:
+// defaults applies to all of them and overrides, by provider name, replaces
+// single options. Nothing changes when an option is invalid.
+func ConfigureProviderClients(defaults HTTPClientOptions, overrides map[string]HTTPClientOptions) error {
+	clients := make(map[string]*http.Client, len(providers))
+	for name := range providers {
+		options := defaults
+		if override, ok := overrides[name]; ok {
+			options = defaults.merge(override)
+		}
+		client, err := newHTTPClient(options)
+		if err != nil {
+			return fmt.Errorf("HTTP client of provider %s: %w", name, err)
+		}
+		clients[name] = client
+	}
+
+	providerClientsMu.Lock()
+	providerClients = clients
+	providerClientsMu.Unlock()
+	return nil
+}
+
+// providerHTTPClient returns the HTTP client a provider is fetched with
+func providerHTTPClient(name string) *http.Client {
+	providerClientsMu.RLock()
+	defer providerClientsMu.RUnlock()
+	if client, ok := providerClients[name]; ok {
+		return client
+	}
+	return defaultClient
+}
+
+// ProviderConnectionStats returns how the requests to each provider used
+// their connections since the clients were configured
+func ProviderConnectionStats() map[string]*models.ConnectionStats {
+	providerClientsMu.RLock()
+	defer providerClientsMu.RUnlock()
+
+	stats := make(map[string]*models.ConnectionStats, len(providerClients))
+	for name, client := range providerClients {
+		if tracked, ok := client.Transport.(*connTrackingTransport); ok {
+			if s := tracked.counters.stats(); s.Requests > 0 {
+				stats[name] = s
+			}
+		}
+	}
+	return stats
+}
+
+// connCounters counts the requests of one client by connection and protocol
+type connCounters struct {
+	requests atomic.Int64
+	newConns atomic.Int64
+	reused   atomic.Int64
+	http2    atomic.Int64
+}
+
+func (c *connCounters) stats() *models.ConnectionStats {
+	s := &models.ConnectionStats{
+		Requests: c.requests.Load(),
+		New:      c.newConns.Load(),
+		Reused:   c.reused.Load(),
+		HTTP2:    c.http2.Load(),
+	}
+	if total := s.New + s.Reused; total > 0 {
+		s.ReuseRatio = float64(s.Reused) / float64(total)
+	}
+	return s
+}
+
+// connTrackingTransport counts whether each request got a new or a reused
+// connection and whether it was answered over HTTP/2
+type connTrackingTransport struct {
+	base     http.RoundTripper
+	counters *connCounters
+}
+
+// RoundTrip sends req through the base transport
+func (t *connTrackingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Reused {
+				t.counters.reused.Add(1)
+			} else {
+				t.counters.newConns.Add(1)
+			}
+		},
+	}
+	resp, err := t.base.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+	if err != nil {
+		return nil, err
+	}
+	t.counters.requests.Add(1)
+	if resp.ProtoMajor == 2 {
+		t.counters.http2.Add(1)
+	}
+	return resp, nil
+}
+
+// merge returns o with the fields set in override replaced
+func (o HTTPClientOptions) merge(override HTTPClientOptions) HTTPClientOptions {
+	if override.Timeout != "" {
+		o.Timeout = override.Timeout
+	}
+	if override.MaxConnsPerHost != 0 {
+		o.MaxConnsPerHost = override.MaxConnsPerHost
+	}
+	if override.MaxIdleConnsPerHost != 0 {
+		o.MaxIdleConnsPerHost = override.MaxIdleConnsPerHost
+	}
+	if override.IdleConnTimeout != "" {
+		o.IdleConnTimeout = override.IdleConnTimeout
+	}
+	if override.Proxy != "" {
+		o.Proxy = override.Proxy
+	}
+	if override.CAFile != "" {
+		o.CAFile = override.CAFile
+	}
+	if override.TLSServerName != "" {
+		o.TLSServerName = override.TLSServerName
+	}
+	o.InsecureSkipVerify = o.InsecureSkipVerify || override.InsecureSkipVerify
+	return o
+}
+
+// newHTTPClient builds a client with its own connection pool from options
+func newHTTPClient(options HTTPClientOptions) (*http.Client, error) {
+	timeout, err := optionDuration(options.Timeout, defaultClientTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("timeout: %w", err)
+	}
+	idleTimeout, err := optionDuration(options.IdleConnTimeout, defaultIdleConnTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("idle_conn_timeout: %w", err)
+	}
+	if options.MaxConnsPerHost < 0 || options.MaxIdleConnsPerHost < 0 {
+		return nil, errors.New("connection limits must not be negative")
+	}
+	maxIdle := options.MaxIdleConnsPerHost
+	if maxIdle == 0 {
+		maxIdle = defaultMaxIdleConnsPerHost
+	}
+
+	transport := &http.Transport{
+		DialContext:         upstreamDNS.DialContext,
+		ForceAttemptHTTP2:   true,
+		MaxIdleConns:        maxIdle * 2,
+		MaxIdleConnsPerHost: maxIdle,
+		MaxConnsPerHost:     options.MaxConnsPerHost,
+		IdleConnTimeout:     idleTimeout,
+		DisableCompression:  true,
+		DisableKeepAlives:   false,
+	}
+	if options.Proxy != "" {
+		proxy, err := url.Parse(options.Proxy)
+		if err != nil || proxy.Host == "" {
+			return nil, fmt.Errorf("proxy %q is not a URL", options.Proxy)
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+	if options.CAFile != "" || options.TLSServerName != "" || options.InsecureSkipVerify {
+		tlsConfig := &tls.Config{
+			ServerName:         options.TLSServerName,
+			InsecureSkipVerify: options.InsecureSkipVerify,
+		}
+		if options.CAFile != "" {
+			pem, err := os.ReadFile(options.CAFile)
+			if err != nil {
+				return nil, fmt.Errorf("ca_file: %w", err)
+			}
+			tlsConfig.RootCAs = x509.NewCertPool()
+			if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("ca_file: no certificates in %s", options.CAFile)
+			}
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: &connTrackingTransport{base: transport, counters: &connCounters{}},
+	}, nil
+}
+
+// mustNewHTTPClient builds a client from options known to be valid
+func mustNewHTTPClient(options HTTPClientOptions) *http.Client {
+	client, err := newHTTPClient(options)
+	if err != nil {
+		panic(err)
+	}
+	return client
+}
+
+// optionDuration parses a duration option, fallback when it is empty
+func optionDuration(value string, fallback time.Duration) (time.Duration, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+	return d, nil
+}
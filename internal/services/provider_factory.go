@@ -0,0 +1,219 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/droid-keyusage-go/internal/models"
+)
+
+// factoryUsageURL is the Factory.ai endpoint reporting organization usage
+const factoryUsageURL = "https://app.factory.ai/api/organization/members/chat-usage"
+
+func init() {
+	RegisterProvider(&FactoryProvider{})
+}
+
+// FactoryProvider fetches usage from Factory.ai
+type FactoryProvider struct{}
+
+// Name returns the provider identifier
+func (p *FactoryProvider) Name() string {
+	return DefaultProvider
+}
+
+// NewUsageRequest builds the Factory.ai usage request
+func (p *FactoryProvider) NewUsageRequest(ctx context.Context) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", factoryUsageURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36")
+	return req, nil
+}
+
+// ParseUsage decodes a Factory.ai usage response. Fields are looked up
+// under the names Factory.ai has used for them, so a renamed or missing
+// field marks the usage as partial instead of failing it; only a response
+// without a usage object is an error.
+func (p *FactoryProvider) ParseUsage(id string, body []byte) (*models.Usage, error) {
+	var resp struct {
+		Usage map[string]json.RawMessage `json:"usage"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	if resp.Usage == nil {
+		return nil, errors.New("response has no usage object")
+	}
+	var standard map[string]json.RawMessage
+	_ = json.Unmarshal(resp.Usage["standard"], &standard)
+
+	var missing []string
+	field := func(fields map[string]json.RawMessage, name string, aliases []string) float64 {
+		value, ok := usageNumber(fields, aliases)
+		if !ok {
+			missing = append(missing, name)
+		}
+		return value
+	}
+
+	// Format dates
+	formatDate := func(timestamp float64) string {
+		if timestamp == 0 {
+			return "N/A"
+		}
+		return time.Unix(int64(timestamp)/1000, 0).Format("2006-01-02")
+	}
+
+	usage := &models.Usage{
+		ID:             id,
+		StartDate:      formatDate(field(resp.Usage, "start_date", usageStartFields)),
+		EndDate:        formatDate(field(resp.Usage, "end_date", usageEndFields)),
+		OrgTotalUsed:   field(standard, "org_total_tokens_used", usageUsedFields),
+		TotalAllowance: field(standard, "total_allowance", usageAllowanceFields),
+		LastUpdated:    time.Now(),
+		Blocks:         parseUsageBlocks(resp.Usage),
+	}
+	usage.Remaining = usage.TotalAllowance - usage.OrgTotalUsed
+	if ratio, ok := usageNumber(standard, usageRatioFields); ok {
+		usage.UsedRatio = ratio
+	} else if usage.TotalAllowance > 0 {
+		usage.UsedRatio = usage.OrgTotalUsed / usage.TotalAllowance
+	} else {
+		missing = append(missing, "used_ratio")
+	}
+	if len(missing) > 0 {
+		usage.Partial = true
+		usage.MissingFields = missing
+	}
+	return usage, nil
+}
+
+// Field names Factory.ai has used for the dates and the standard allowance,
+// current name first
+var (
+	usageStartFields     = []string{"startDate", "start_date", "periodStart"}
+	usageEndFields       = []string{"endDate", "end_date", "periodEnd"}
+	usageUsedFields      = []string{"orgTotalTokensUsed", "totalTokensUsed", "tokensUsed", "used"}
+	usageAllowanceFields = []string{"totalAllowance", "allowance", "limit"}
+	usageRatioFields     = []string{"usedRatio", "used_ratio"}
+)
+
+// usageNumber returns the first of the named fields holding a number
+func usageNumber(fields map[string]json.RawMessage, names []string) (float64, bool) {
+	for _, name := range names {
+		var number float64
+		if value, ok := fields[name]; ok && string(value) != "null" && json.Unmarshal(value, &number) == nil {
+			return number, true
+		}
+	}
+	return 0, false
+}
+
+// Field names Factory.ai uses within usage blocks
+var (
+	blockUsedFields      = map[string]bool{"orgTotalTokensUsed": true, "totalTokensUsed": true, "tokensUsed": true, "used": true}
+	blockAllowanceFields = map[string]bool{"totalAllowance": true, "allowance": true, "limit": true}
+	blockNameFields      = []string{"model", "modelId", "name", "id"}
+)
+
+// parseUsageBlocks returns every block of the "usage" object besides the
+// dates and the standard allowance, such as premium tokens, overage or
+// per-model breakdowns, or nil when there are none
+func parseUsageBlocks(usage map[string]json.RawMessage) map[string]*models.UsageBlock {
+	var blocks map[string]*models.UsageBlock
+	for name, raw := range usage {
+		if name == "standard" || slices.Contains(usageStartFields, name) || slices.Contains(usageEndFields, name) {
+			continue
+		}
+		if block := parseUsageBlock(raw); block != nil {
+			if blocks == nil {
+				blocks = make(map[string]*models.UsageBlock)
+			}
+			blocks[name] = block
+		}
+	}
+	return blocks
+}
+
+// parseUsageBlock reads a block from an object of usage figures, whose
+// nested objects and arrays become its breakdown; an array holds one named
+// entry per element. Without a figure of its own, the block uses the sum of
+// its breakdown. It returns nil when raw holds no figures at all.
+func parseUsageBlock(raw json.RawMessage) *models.UsageBlock {
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(raw, &fields) != nil {
+		var items []json.RawMessage
+		if json.Unmarshal(raw, &items) != nil {
+			return nil
+		}
+		fields = make(map[string]json.RawMessage, len(items))
+		for i, item := range items {
+			fields[usageItemName(item, i)] = item
+		}
+	}
+
+	block := &models.UsageBlock{}
+	found, hasUsed := false, false
+	for name, value := range fields {
+		if string(value) == "null" {
+			continue
+		}
+		var number float64
+		if json.Unmarshal(value, &number) == nil {
+			found = true
+			switch {
+			case blockUsedFields[name]:
+				block.Used = number
+				hasUsed = true
+			case blockAllowanceFields[name]:
+				block.Allowance = number
+			case name == "usedRatio":
+				block.UsedRatio = number
+			default:
+				if block.Other == nil {
+					block.Other = make(map[string]float64)
+				}
+				block.Other[name] = number
+			}
+			continue
+		}
+		if child := parseUsageBlock(value); child != nil {
+			found = true
+			if block.Breakdown == nil {
+				block.Breakdown = make(map[string]*models.UsageBlock)
+			}
+			block.Breakdown[name] = child
+		}
+	}
+	if !found {
+		return nil
+	}
+	if !hasUsed {
+		for _, child := range block.Breakdown {
+			block.Used += child.Used
+		}
+	}
+	return block
+}
+
+// usageItemName names an element of a breakdown array after its model or
+// name field, else its position
+func usageItemName(item json.RawMessage, index int) string {
+	var fields map[string]interface{}
+	if json.Unmarshal(item, &fields) == nil {
+		for _, field := range blockNameFields {
+			if name, ok := fields[field].(string); ok && name != "" {
+				return name
+			}
+		}
+	}
+	return strconv.Itoa(index)
+}
@@ -0,0 +1,231 @@
+package services
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/droid-keyusage-go/internal/storage"
+)
+
+// Rate is a number of requests allowed per period
+type Rate struct {
+	Requests int
+	Period   time.Duration
+}
+
+// ratePeriods are the period units of a rate, e.g. "10/m"
+var ratePeriods = map[string]time.Duration{
+	"s": time.Second,
+	"m": time.Minute,
+	"h": time.Hour,
+	"d": 24 * time.Hour,
+}
+
+// ParseRate parses a rate such as "10/m"; the units are s, m, h and d
+func ParseRate(spec string) (Rate, error) {
+	count, unit, ok := strings.Cut(strings.TrimSpace(spec), "/")
+	period, known := ratePeriods[unit]
+	requests, err := strconv.Atoi(count)
+	if !ok || !known || err != nil || requests <= 0 {
+		return Rate{}, fmt.Errorf("invalid rate %q: expected requests/unit with unit s, m, h or d", spec)
+	}
+	return Rate{Requests: requests, Period: period}, nil
+}
+
+// EndpointLimit is a rate for the requests to one endpoint, stricter than
+// the global limit
+type EndpointLimit struct {
+	Method string
+	Path   string
+	// Query are query parameters a request must carry to match, e.g.
+	// refresh=true
+	Query map[string]string
+	Rate  Rate
+	// spec identifies the limit in Redis
+	spec string
+}
+
+// ParseEndpointLimits parses the endpoint limits such as
+// "GET /api/data?refresh=true 10/m;POST /api/keys/import 5/m"; a path
+// ending in * matches every path starting with the rest
+func ParseEndpointLimits(spec string) ([]EndpointLimit, error) {
+	var limits []EndpointLimit
+	for _, entry := range strings.Split(spec, ";") {
+		fields := strings.Fields(entry)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("invalid endpoint limit %q: expected METHOD path rate", strings.TrimSpace(entry))
+		}
+		rate, err := ParseRate(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("invalid endpoint limit %q: %w", strings.TrimSpace(entry), err)
+		}
+
+		path, rawQuery, _ := strings.Cut(fields[1], "?")
+		limit := EndpointLimit{
+			Method: strings.ToUpper(fields[0]),
+			Path:   path,
+			Rate:   rate,
+			spec:   strings.ToUpper(fields[0]) + " " + fields[1],
+		}
+		if rawQuery != "" {
+			limit.Query = make(map[string]string)
+			for _, pair := range strings.Split(rawQuery, "&") {
+				name, value, _ := strings.Cut(pair, "=")
+				limit.Query[name] = value
+			}
+		}
+		limits = append(limits, limit)
+	}
+	return limits, nil
+}
+
+// matches reports whether a request is subject to the limit; query returns
+// the value of a query parameter
+func (l *EndpointLimit) matches(method, path string, query func(string) string) bool {
+	if l.Method != method {
+		return false
+	}
+	if prefix, ok := strings.CutSuffix(l.Path, "*"); ok {
+		if !strings.HasPrefix(path, prefix) {
+			return false
+		}
+	} else if l.Path != path {
+		return false
+	}
+	for name, value := range l.Query {
+		if query(name) != value {
+			return false
+		}
+	}
+	return true
+}
+
+// anyTokenQuota is the quota subject of API clients without their own
+const anyTokenQuota = "*"
+
+// ParseTokenQuotas parses the daily request quotas of API clients such as
+// "alice=10000;cert:billing=5000;*=50000", by the user name of the token
+// (the role for shared-password tokens, cert:<identity> for client
+// certificates); * applies to the others
+func ParseTokenQuotas(spec string) (map[string]int, error) {
+	quotas := make(map[string]int)
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		i := strings.LastIndex(entry, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid token quota %q: expected subject=requests", entry)
+		}
+		limit, err := strconv.Atoi(strings.TrimSpace(entry[i+1:]))
+		if err != nil || limit <= 0 {
+			return nil, fmt.Errorf("invalid token quota %q: requests must be a positive number", entry)
+		}
+		quotas[strings.TrimSpace(entry[:i])] = limit
+	}
+	return quotas, nil
+}
+
+// QuotaResult is the state of an API client's daily quota after a request
+type QuotaResult struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is how long until the quota starts over, at midnight UTC
+	Reset time.Duration
+}
+
+// RateLimiter enforces request limits in Redis, so they hold across
+// replicas: a global rate per client address, stricter rates for
+// expensive endpoints and daily quotas for API tokens. While Redis is
+// unavailable requests are let through.
+type RateLimiter struct {
+	store     *storage.Storage
+	global    Rate
+	burst     int
+	endpoints []EndpointLimit
+	quotas    map[string]int
+}
+
+// NewRateLimiter creates a limiter allowing perSecond requests a second
+// from each client address, with bursts of up to burst requests;
+// perSecond <= 0 turns the global limit off
+func NewRateLimiter(store *storage.Storage, perSecond, burst int) *RateLimiter {
+	l := &RateLimiter{store: store}
+	if perSecond > 0 {
+		if burst < perSecond {
+			burst = perSecond
+		}
+		l.global = Rate{Requests: perSecond, Period: time.Second}
+		l.burst = burst
+	}
+	return l
+}
+
+// SetEndpointLimits sets the rates of expensive endpoints
+func (l *RateLimiter) SetEndpointLimits(limits []EndpointLimit) {
+	l.endpoints = limits
+}
+
+// SetTokenQuotas sets the daily request quotas of API clients
+func (l *RateLimiter) SetTokenQuotas(quotas map[string]int) {
+	l.quotas = quotas
+}
+
+// Global takes a request of client from the global limit and returns it
+// with the number of requests the limit allows at once; it returns nil
+// when there is no global limit
+func (l *RateLimiter) Global(client string) (*storage.RateLimitResult, int, error) {
+	if l.global.Requests == 0 {
+		return nil, 0, nil
+	}
+	result, err := l.store.TakeRateLimit("global:"+client, l.global.Period/time.Duration(l.global.Requests), l.burst)
+	return result, l.burst, err
+}
+
+// Endpoint takes a request of client from the first endpoint limit matching
+// the request and returns it with the number of requests the limit allows
+// per period; it returns nil when none matches
+func (l *RateLimiter) Endpoint(method, path string, query func(string) string, client string) (*storage.RateLimitResult, int, error) {
+	for i := range l.endpoints {
+		limit := &l.endpoints[i]
+		if !limit.matches(method, path, query) {
+			continue
+		}
+		interval := limit.Rate.Period / time.Duration(limit.Rate.Requests)
+		result, err := l.store.TakeRateLimit("endpoint:"+limit.spec+":"+client, interval, limit.Rate.Requests)
+		return result, limit.Rate.Requests, err
+	}
+	return nil, 0, nil
+}
+
+// Quota counts a request of an API client against its daily quota; it
+// returns nil when the client has none
+func (l *RateLimiter) Quota(subject string) (*QuotaResult, error) {
+	limit, ok := l.quotas[subject]
+	if !ok {
+		limit, ok = l.quotas[anyTokenQuota]
+	}
+	if !ok {
+		return nil, nil
+	}
+
+	now := time.Now().UTC()
+	end := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+	allowed, used, err := l.store.TakeQuota("token:"+subject+":"+now.Format("20060102"), limit, end)
+	if err != nil {
+		return nil, err
+	}
+	return &QuotaResult{
+		Allowed:   allowed,
+		Limit:     limit,
+		Remaining: max(limit-used, 0),
+		Reset:     end.Sub(now),
+	}, nil
+}
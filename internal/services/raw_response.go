@@ -0,0 +1,46 @@
+package services
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/droid-keyusage-go/internal/models"
+)
+
+// rawResponseTTL is how long the last provider response of a key is kept
+const rawResponseTTL = 24 * time.Hour
+
+// rawResponseKey is the storage key of the last provider response of a key
+func rawResponseKey(id string) string {
+	return fmt.Sprintf("key:%s:raw", id)
+}
+
+// saveRawResponses keeps the provider responses the results were read from,
+// replacing the previous one of each key; only results fetched with
+// UPSTREAM_RAW_MAX_BYTES set carry one
+func (s *APIKeyService) saveRawResponses(results []*models.Usage) {
+	for _, usage := range results {
+		if usage.Raw == nil {
+			continue
+		}
+		if err := s.store.SetJSON(rawResponseKey(usage.ID), usage.Raw, rawResponseTTL); err != nil {
+			fmt.Fprintf(console, "⚠️  保存上游原始响应失败: %v\n", err)
+			return
+		}
+	}
+}
+
+// GetRawResponse returns the last provider response kept for a key, or nil
+// when none was kept within rawResponseTTL
+func (s *APIKeyService) GetRawResponse(id string, p Principal) (*models.RawResponse, error) {
+	if _, err := s.getVisibleKey(id, p); err != nil {
+		return nil, err
+	}
+
+	var raw models.RawResponse
+	found, err := s.store.GetJSON(rawResponseKey(id), &raw)
+	if err != nil || !found {
+		return nil, err
+	}
+	return &raw, nil
+}
@@ -0,0 +1,94 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/droid-keyusage-go/internal/models"
+	"github.com/droid-keyusage-go/internal/storage"
+	"github.com/google/uuid"
+)
+
+// defaultRefreshChunkSize is the number of keys fetched before the results
+// are saved, unless SetRefreshChunkSize changes it
+const defaultRefreshChunkSize = 500
+
+// SetRefreshChunkSize sets how many keys a refresh fetches before saving
+// their results and its progress; size <= 0 fetches every key at once
+func (s *APIKeyService) SetRefreshChunkSize(size int) {
+	s.settingsMu.Lock()
+	s.chunkSize = size
+	s.settingsMu.Unlock()
+}
+
+// refreshChunked fetches keys a chunk at a time. The results of each chunk
+// are saved as soon as it finishes, so they are served to other requests
+// before the whole batch is done, then handed to each and the progress of
+// the batch is updated. When ctx is canceled, the chunks already finished
+// stay saved.
+func (s *APIKeyService) refreshChunked(ctx context.Context, keys []*storage.APIKey, policy cachePolicy, degraded bool, each func(results []*models.Usage)) error {
+	s.settingsMu.RLock()
+	size := s.chunkSize
+	s.settingsMu.RUnlock()
+	if size <= 0 || size > len(keys) {
+		size = len(keys)
+	}
+
+	now := time.Now()
+	progress := &storage.RefreshProgress{
+		ID:        uuid.New().String(),
+		Trigger:   refreshTrigger(ctx),
+		StartedAt: now,
+		UpdatedAt: now,
+		Total:     len(keys),
+		Chunks:    (len(keys) + size - 1) / size,
+	}
+	s.saveProgress(ctx, progress, degraded)
+
+	for start := 0; start < len(keys); start += size {
+		end := start + size
+		if end > len(keys) {
+			end = len(keys)
+		}
+		chunk := keys[start:end]
+
+		chunkResults, err := s.workerPool.BatchProcess(ctx, chunk)
+		if err != nil {
+			return err
+		}
+		s.saveFetched(chunk, chunkResults, policy, degraded)
+		each(chunkResults)
+
+		progress.ChunksDone++
+		progress.Done += len(chunk)
+		for _, usage := range chunkResults {
+			if usage.Error == "" {
+				progress.Succeeded++
+			} else {
+				progress.Failed++
+			}
+		}
+		progress.Finished = progress.Done == progress.Total
+		progress.UpdatedAt = time.Now()
+		s.saveProgress(ctx, progress, degraded)
+	}
+	return nil
+}
+
+// saveProgress stores the progress of a refresh; it is not kept while
+// Redis is unavailable
+func (s *APIKeyService) saveProgress(ctx context.Context, progress *storage.RefreshProgress, degraded bool) {
+	if degraded {
+		return
+	}
+	if err := s.store.WithContext(ctx).SaveRefreshProgress(progress); err != nil {
+		fmt.Fprintf(console, "⚠️  保存刷新进度失败: %v\n", err)
+	}
+}
+
+// RefreshProgress returns the progress of the refresh batch running or last
+// finished, or nil when none ran within the last hour
+func (s *APIKeyService) RefreshProgress(ctx context.Context) (*storage.RefreshProgress, error) {
+	return s.store.WithContext(ctx).GetRefreshProgress()
+}
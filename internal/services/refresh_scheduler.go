@@ -0,0 +1,128 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// RefreshScheduler refreshes usage in the background so every key is
+// refreshed at its own interval (see cachePolicy.interval) even when
+// nobody is looking at the dashboard. Each check only fetches the keys
+// whose usage is due, so it can run much more often than most keys refresh.
+// On start it can also warm the local cache and refresh every key once.
+type RefreshScheduler struct {
+	apiKeys        *APIKeyService
+	interval       time.Duration
+	warmOnStart    bool
+	refreshOnStart bool
+	shutdown       chan struct{}
+	draining       chan struct{}
+	drainOnce      sync.Once
+	wg             sync.WaitGroup
+}
+
+// NewRefreshScheduler creates the scheduler; it does nothing when interval
+// <= 0, leaving usage to be refreshed on request
+func NewRefreshScheduler(apiKeys *APIKeyService, interval time.Duration) *RefreshScheduler {
+	return &RefreshScheduler{
+		apiKeys:  apiKeys,
+		interval: interval,
+		shutdown: make(chan struct{}),
+		draining: make(chan struct{}),
+	}
+}
+
+// SetStartup makes Start load the cached usage from Redis into the local
+// cache (warm) and refresh every key in the background (refresh); requests
+// made during that refresh are served the warmed usage instead of waiting
+func (s *RefreshScheduler) SetStartup(warm, refresh bool) {
+	s.warmOnStart = warm
+	s.refreshOnStart = refresh
+}
+
+// Start launches the background checks
+func (s *RefreshScheduler) Start() {
+	if s.interval <= 0 && !s.warmOnStart && !s.refreshOnStart {
+		return
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		ctx, cancel := shutdownContext(s.shutdown)
+		defer cancel()
+
+		s.startup(ctx)
+		if s.interval <= 0 {
+			return
+		}
+		ctx = withRefreshTrigger(ctx, TriggerScheduler)
+
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		for {
+			// A drained scheduler starts no more refreshes
+			select {
+			case <-s.draining:
+				return
+			default:
+			}
+
+			select {
+			case <-ticker.C:
+				_, err := s.apiKeys.GetAggregatedData(ctx, adminPrincipal)
+				if err != nil && ctx.Err() == nil {
+					fmt.Fprintf(console, "⚠️  定时刷新用量失败: %v\n", err)
+				}
+			case <-s.draining:
+				return
+			case <-s.shutdown:
+				return
+			}
+		}
+	}()
+}
+
+// startup warms the local cache and runs the startup refresh
+func (s *RefreshScheduler) startup(ctx context.Context) {
+	if s.warmOnStart {
+		start := time.Now()
+		loaded, err := s.apiKeys.WarmCache(ctx)
+		if err != nil && ctx.Err() == nil {
+			fmt.Fprintf(console, "⚠️  预热用量缓存失败: %v\n", err)
+		} else if loaded > 0 {
+			fmt.Fprintf(console, "✅ 已预热 %d 个 Key 的用量缓存，耗时 %s\n", loaded, time.Since(start).Round(time.Millisecond))
+		}
+	}
+	if s.refreshOnStart {
+		if err := s.apiKeys.refreshWarm(ctx); err != nil && ctx.Err() == nil {
+			fmt.Fprintf(console, "⚠️  启动刷新用量失败: %v\n", err)
+		}
+	}
+}
+
+// Drain stops scheduling refreshes and waits until the one running, if
+// any, has finished or ctx is done, so it isn't cut short by Stop
+func (s *RefreshScheduler) Drain(ctx context.Context) {
+	s.drainOnce.Do(func() { close(s.draining) })
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+}
+
+// Stop stops the background checks
+func (s *RefreshScheduler) Stop() {
+	close(s.shutdown)
+	s.wg.Wait()
+}
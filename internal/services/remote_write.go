@@ -0,0 +1,270 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+)
+
+// remoteWriteTimeout bounds one push
+const remoteWriteTimeout = 30 * time.Second
+
+// remoteLabelName is the syntax of Prometheus label names
+var remoteLabelName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// RemoteWriteOptions configure pushing metrics with the Prometheus
+// remote-write protocol
+type RemoteWriteOptions struct {
+	// URL is the receiver, e.g. http://victoria:8428/api/v1/write
+	URL string
+	// Username and Password authenticate with Basic auth, BearerToken with
+	// a bearer token; either may refer to a secret as secret:NAME
+	Username    string
+	Password    string
+	BearerToken string
+	// Labels are added to every series, e.g. env="prod"
+	Labels   map[string]string
+	Interval time.Duration
+}
+
+// ParseRemoteWriteLabels parses labels such as "env=prod,region=eu"
+func ParseRemoteWriteLabels(spec string) (map[string]string, error) {
+	labels := make(map[string]string)
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(pair, "=")
+		name = strings.TrimSpace(name)
+		if !ok || !remoteLabelName.MatchString(name) || strings.HasPrefix(name, "__") {
+			return nil, fmt.Errorf("invalid label %q: expected name=value with a Prometheus label name", pair)
+		}
+		labels[name] = strings.TrimSpace(value)
+	}
+	return labels, nil
+}
+
+// remoteSeries is one sample of a series; labels include __name__
+type remoteSeries struct {
+	labels map[string]string
+	value  float64
+}
+
+// RemoteWriter pushes usage and operational metrics to a Prometheus
+// remote-write receiver such as VictoriaMetrics, Mimir or Prometheus
+// itself, for deployments no scraper reaches. Usage comes from the same
+// cached statistics as the dashboard; each replica pushes its own queue and
+// upstream measurements, told apart by the instance label.
+type RemoteWriter struct {
+	opts    RemoteWriteOptions
+	pool    *WorkerPool
+	tenants map[string]*APIKeyService
+	client  *http.Client
+
+	shutdown chan struct{}
+	wg       sync.WaitGroup
+}
+
+// NewRemoteWriter creates the writer pushing the metrics of the worker pool
+// and the tenants; it returns nil when opts has no URL. The job and
+// instance labels default to droid-keyusage and the host name.
+func NewRemoteWriter(opts RemoteWriteOptions, pool *WorkerPool, tenants map[string]*APIKeyService) *RemoteWriter {
+	if opts.URL == "" {
+		return nil
+	}
+	labels := map[string]string{"job": "droid-keyusage"}
+	if hostname, err := os.Hostname(); err == nil {
+		labels["instance"] = hostname
+	}
+	for name, value := range opts.Labels {
+		labels[name] = value
+	}
+	opts.Labels = labels
+	return &RemoteWriter{
+		opts:     opts,
+		pool:     pool,
+		tenants:  tenants,
+		client:   &http.Client{Timeout: remoteWriteTimeout},
+		shutdown: make(chan struct{}),
+	}
+}
+
+// Start pushes every interval
+func (w *RemoteWriter) Start() {
+	if w == nil || w.opts.Interval <= 0 {
+		return
+	}
+
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+
+		ctx, cancel := shutdownContext(w.shutdown)
+		defer cancel()
+
+		ticker := time.NewTicker(w.opts.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := w.push(ctx, w.collect(ctx), time.Now()); err != nil && ctx.Err() == nil {
+					fmt.Fprintf(console, "⚠️  推送 remote-write 指标失败: %v\n", err)
+				}
+			case <-w.shutdown:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops pushing
+func (w *RemoteWriter) Stop() {
+	if w == nil {
+		return
+	}
+	close(w.shutdown)
+	w.wg.Wait()
+}
+
+// collect gathers the current value of every series
+func (w *RemoteWriter) collect(ctx context.Context) []remoteSeries {
+	var series []remoteSeries
+	add := func(name string, value float64, labels ...string) {
+		s := remoteSeries{labels: map[string]string{"__name__": name}, value: value}
+		for i := 0; i+1 < len(labels); i += 2 {
+			s.labels[labels[i]] = labels[i+1]
+		}
+		series = append(series, s)
+	}
+
+	add("keyusage_queue_depth", float64(len(w.pool.taskQueue)))
+	add("keyusage_queue_results", float64(len(w.pool.resultQueue)))
+	add("keyusage_queue_in_flight", float64(w.pool.inFlight()))
+	add("keyusage_workers_active", float64(atomic.LoadInt32(&w.pool.activeWorkers)))
+	for provider, stats := range w.pool.UpstreamStats() {
+		add("keyusage_upstream_latency_p50_ms", float64(stats.P50LatencyMs), "provider", provider)
+		add("keyusage_upstream_latency_p95_ms", float64(stats.P95LatencyMs), "provider", provider)
+		add("keyusage_upstream_success_rate", stats.SuccessRate, "provider", provider)
+	}
+
+	for tenant, apiKeys := range w.tenants {
+		stats, err := apiKeys.GetStats(ctx, adminPrincipal)
+		if err != nil {
+			fmt.Fprintf(console, "⚠️  读取租户 %s 的统计数据失败: %v\n", tenant, err)
+			continue
+		}
+		add("keyusage_keys_total", float64(stats.TotalKeys), "tenant", tenant)
+		add("keyusage_keys_exhausted", float64(stats.ExhaustedThisPeriod), "tenant", tenant)
+		add("keyusage_refresh_latency_avg_ms", stats.AvgRefreshLatencyMs, "tenant", tenant)
+		for status, n := range stats.ByStatus {
+			add("keyusage_keys", float64(n), "tenant", tenant, "status", status)
+		}
+		for provider, t := range stats.ByProvider {
+			add("keyusage_allowance_tokens", t.TotalAllowance, "tenant", tenant, "provider", provider)
+			add("keyusage_used_tokens", t.TotalUsed, "tenant", tenant, "provider", provider)
+			add("keyusage_remaining_tokens", t.Remaining, "tenant", tenant, "provider", provider)
+		}
+		for group, t := range stats.ByGroup {
+			add("keyusage_group_used_tokens", t.TotalUsed, "tenant", tenant, "group", group)
+			add("keyusage_group_allowance_tokens", t.TotalAllowance, "tenant", tenant, "group", group)
+		}
+	}
+	return series
+}
+
+// push sends the series as one remote-write request, sampled at now
+func (w *RemoteWriter) push(ctx context.Context, series []remoteSeries, now time.Time) error {
+	body := snappy.Encode(nil, encodeWriteRequest(series, w.opts.Labels, now.UnixMilli()))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.opts.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	if w.opts.BearerToken != "" {
+		token, err := resolveSecretRef(w.opts.BearerToken)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else if w.opts.Username != "" || w.opts.Password != "" {
+		password, err := resolveSecretRef(w.opts.Password)
+		if err != nil {
+			return err
+		}
+		req.SetBasicAuth(w.opts.Username, password)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(detail)))
+	}
+	return nil
+}
+
+// encodeWriteRequest encodes a prometheus.WriteRequest protobuf message:
+//
+//	WriteRequest { repeated TimeSeries timeseries = 1; }
+//	TimeSeries   { repeated Label labels = 1; repeated Sample samples = 2; }
+//	Label        { string name = 1; string value = 2; }
+//	Sample       { double value = 1; int64 timestamp = 2; }
+//
+// Labels are sorted by name, as receivers expect; extra labels don't
+// override those of a series.
+func encodeWriteRequest(series []remoteSeries, extra map[string]string, timestamp int64) []byte {
+	var buf, ts, label, sample []byte
+	for _, s := range series {
+		labels := make(map[string]string, len(s.labels)+len(extra))
+		for name, value := range extra {
+			labels[name] = value
+		}
+		for name, value := range s.labels {
+			labels[name] = value
+		}
+		names := make([]string, 0, len(labels))
+		for name := range labels {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		ts = ts[:0]
+		for _, name := range names {
+			label = appendProtoBytes(label[:0], 1, []byte(name))
+			label = appendProtoBytes(label, 2, []byte(labels[name]))
+			ts = appendProtoBytes(ts, 1, label)
+		}
+		sample = binary.LittleEndian.AppendUint64(append(sample[:0], 1<<3|1), math.Float64bits(s.value))
+		sample = binary.AppendUvarint(append(sample, 2<<3|0), uint64(timestamp))
+		ts = appendProtoBytes(ts, 2, sample)
+		buf = appendProtoBytes(buf, 1, ts)
+	}
+	return buf
+}
+
+// appendProtoBytes appends a length-delimited protobuf field
+func appendProtoBytes(b []byte, field int, data []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(field)<<3|2)
+	b = binary.AppendUvarint(b, uint64(len(data)))
+	return append(b, data...)
+}
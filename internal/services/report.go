@@ -0,0 +1,362 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/droid-keyusage-go/internal/models"
+	"github.com/droid-keyusage-go/internal/storage"
+	"github.com/google/uuid"
+)
+
+// Report formats
+const (
+	ReportFormatJSON = "json"
+	ReportFormatCSV  = "csv"
+	ReportFormatHTML = "html"
+)
+
+// ErrReportNotFound is returned when a report or one of its formats does not exist
+var ErrReportNotFound = errors.New("report not found")
+
+// reportContentTypes maps report formats to their content type
+var reportContentTypes = map[string]string{
+	ReportFormatJSON: "application/json",
+	ReportFormatCSV:  "text/csv; charset=utf-8",
+	ReportFormatHTML: "text/html; charset=utf-8",
+}
+
+// ReportService periodically renders usage reports per group and provider
+// and stores them for download
+type ReportService struct {
+	store    *storage.Storage
+	apiKeys  *APIKeyService
+	notifier *NotificationService
+	objects  *ObjectStore
+	interval time.Duration
+	formats  []string
+	notify   bool
+	shutdown chan struct{}
+	wg       sync.WaitGroup
+}
+
+// ParseReportFormats parses a comma-separated list such as "json,csv,html"
+func ParseReportFormats(spec string) ([]string, error) {
+	var formats []string
+	seen := make(map[string]bool)
+	for _, format := range strings.Split(spec, ",") {
+		format = strings.ToLower(strings.TrimSpace(format))
+		if format == "" || seen[format] {
+			continue
+		}
+		if _, ok := reportContentTypes[format]; !ok {
+			return nil, fmt.Errorf("unknown report format %q", format)
+		}
+		seen[format] = true
+		formats = append(formats, format)
+	}
+	if len(formats) == 0 {
+		return nil, fmt.Errorf("no report formats given")
+	}
+	return formats, nil
+}
+
+// NewReportService creates a report service; interval <= 0 turns off
+// scheduled reports and notify pushes a summary of each report to the
+// notification channels
+func NewReportService(store *storage.Storage, apiKeys *APIKeyService, notifier *NotificationService, interval time.Duration, formats string, notify bool) *ReportService {
+	parsed, err := ParseReportFormats(formats)
+	if err != nil {
+		fmt.Fprintf(console, "⚠️  %v，已使用全部报表格式\n", err)
+		parsed = []string{ReportFormatJSON, ReportFormatCSV, ReportFormatHTML}
+	}
+
+	return &ReportService{
+		store:    store,
+		apiKeys:  apiKeys,
+		notifier: notifier,
+		interval: interval,
+		formats:  parsed,
+		notify:   notify,
+		shutdown: make(chan struct{}),
+	}
+}
+
+// SetObjectStore makes every generated report also upload its artifacts
+// to an S3-compatible bucket
+func (s *ReportService) SetObjectStore(objects *ObjectStore) {
+	s.objects = objects
+}
+
+// Start launches the background report job
+func (s *ReportService) Start() {
+	if s.interval <= 0 {
+		return
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		ctx, cancel := shutdownContext(s.shutdown)
+		defer cancel()
+
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if _, err := s.Generate(ctx); err != nil {
+					fmt.Fprintf(console, "⚠️  生成用量报表失败: %v\n", err)
+				}
+			case <-s.shutdown:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the background report job
+func (s *ReportService) Stop() {
+	close(s.shutdown)
+	s.wg.Wait()
+}
+
+// Generate refreshes usage, renders a report in every configured format
+// and stores it
+func (s *ReportService) Generate(ctx context.Context) (*storage.Report, error) {
+	// Refresh stale usage first; this also stores up-to-date statistics
+	if _, err := s.apiKeys.GetAggregatedData(withRefreshTrigger(ctx, TriggerReport), adminPrincipal); err != nil {
+		return nil, err
+	}
+	stats, err := s.apiKeys.GetStats(ctx, adminPrincipal)
+	if err != nil {
+		return nil, err
+	}
+
+	content := buildReport(stats, time.Now())
+	report := &storage.Report{
+		ID:          content.ID,
+		GeneratedAt: content.GeneratedAt,
+		TotalKeys:   content.TotalKeys,
+		Formats:     s.formats,
+		Sizes:       make(map[string]int, len(s.formats)),
+	}
+	artifacts := make(map[string][]byte, len(s.formats))
+	for _, format := range s.formats {
+		artifact, err := renderReport(content, format)
+		if err != nil {
+			return nil, err
+		}
+		artifacts[format] = artifact
+		report.Sizes[format] = len(artifact)
+	}
+
+	if err := s.store.SaveReport(report, artifacts); err != nil {
+		return nil, err
+	}
+
+	// The report is stored already, so a failed upload is only logged
+	if s.objects != nil {
+		for format, artifact := range artifacts {
+			name := fmt.Sprintf("reports/%s.%s", report.ID, format)
+			if err := s.objects.Put(ctx, name, reportContentTypes[format], artifact); err != nil {
+				fmt.Fprintf(console, "⚠️  上传用量报表失败: %v\n", err)
+			}
+		}
+	}
+
+	if s.notify {
+		err := s.notifier.Notify(&Notification{
+			Event: "report.generated",
+			Title: "Usage report",
+			Message: fmt.Sprintf("%d keys, %s of %s used, %s remaining",
+				content.TotalKeys, formatAmount(content.Totals.TotalUsed),
+				formatAmount(content.Totals.TotalAllowance), formatAmount(content.Totals.Remaining)),
+			Data: map[string]interface{}{
+				"report_id":   report.ID,
+				"formats":     report.Formats,
+				"totals":      content.Totals,
+				"by_group":    content.ByGroup,
+				"by_provider": content.ByProvider,
+			},
+		})
+		if err != nil {
+			fmt.Fprintf(console, "⚠️  发送报表通知失败: %v\n", err)
+		}
+	}
+
+	return report, nil
+}
+
+// ListReports returns the most recent reports, newest first
+func (s *ReportService) ListReports(limit int) ([]*storage.Report, error) {
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+	return s.store.ListReports(limit)
+}
+
+// GetArtifact returns a stored report in the given format with its content type
+func (s *ReportService) GetArtifact(id, format string) ([]byte, string, error) {
+	contentType, ok := reportContentTypes[format]
+	if !ok {
+		return nil, "", ErrReportNotFound
+	}
+	artifact, err := s.store.GetReportArtifact(id, format)
+	if err != nil {
+		return nil, "", err
+	}
+	if artifact == nil {
+		return nil, "", ErrReportNotFound
+	}
+	return artifact, contentType, nil
+}
+
+// buildReport turns statistics into report rows per group and provider
+func buildReport(stats *models.Stats, now time.Time) *models.UsageReport {
+	report := &models.UsageReport{
+		ID:          fmt.Sprintf("report-%s-%s", now.Format("20060102-150405"), uuid.New().String()[:8]),
+		GeneratedAt: now,
+		TotalKeys:   stats.TotalKeys,
+		ByStatus:    stats.ByStatus,
+		ByGroup:     reportRows(stats.ByGroup),
+		ByProvider:  reportRows(stats.ByProvider),
+	}
+
+	// Every counted key has exactly one provider
+	for _, row := range report.ByProvider {
+		report.Totals.Keys += row.Keys
+		report.Totals.TotalAllowance += row.TotalAllowance
+		report.Totals.TotalUsed += row.TotalUsed
+		report.Totals.Remaining += row.Remaining
+	}
+
+	// Keys without a group are not part of the group statistics
+	ungrouped := report.Totals
+	for _, row := range report.ByGroup {
+		ungrouped.Keys -= row.Keys
+		ungrouped.TotalAllowance -= row.TotalAllowance
+		ungrouped.TotalUsed -= row.TotalUsed
+		ungrouped.Remaining -= row.Remaining
+	}
+	if ungrouped.Keys > 0 {
+		report.ByGroup = append(report.ByGroup, newReportRow(UngroupedName, &ungrouped))
+	}
+	return report
+}
+
+func reportRows(totals map[string]*models.StatsTotals) []models.ReportRow {
+	rows := make([]models.ReportRow, 0, len(totals))
+	for name, t := range totals {
+		rows = append(rows, newReportRow(name, t))
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		return rows[i].Name < rows[j].Name
+	})
+	return rows
+}
+
+func newReportRow(name string, t *models.StatsTotals) models.ReportRow {
+	row := models.ReportRow{Name: name, StatsTotals: *t}
+	if t.TotalAllowance > 0 {
+		row.UsedRatio = t.TotalUsed / t.TotalAllowance
+	}
+	return row
+}
+
+// renderReport renders a report in one format
+func renderReport(report *models.UsageReport, format string) ([]byte, error) {
+	switch format {
+	case ReportFormatJSON:
+		return json.MarshalIndent(report, "", "  ")
+	case ReportFormatCSV:
+		return renderReportCSV(report)
+	case ReportFormatHTML:
+		var buf bytes.Buffer
+		if err := reportTemplate.Execute(&buf, report); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	default:
+		return nil, fmt.Errorf("unknown report format %q", format)
+	}
+}
+
+func renderReportCSV(report *models.UsageReport) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	_ = w.Write([]string{"dimension", "name", "keys", "total_allowance", "total_used", "remaining", "used_ratio"})
+	write := func(dimension string, rows []models.ReportRow) {
+		for _, row := range rows {
+			_ = w.Write([]string{
+				dimension, row.Name, strconv.Itoa(row.Keys),
+				strconv.FormatFloat(row.TotalAllowance, 'f', -1, 64),
+				strconv.FormatFloat(row.TotalUsed, 'f', -1, 64),
+				strconv.FormatFloat(row.Remaining, 'f', -1, 64),
+				strconv.FormatFloat(row.UsedRatio, 'f', 4, 64),
+			})
+		}
+	}
+	write("total", []models.ReportRow{newReportRow("all", &report.Totals)})
+	write("group", report.ByGroup)
+	write("provider", report.ByProvider)
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// formatAmount renders a token amount compactly, e.g. 12.5M
+func formatAmount(v float64) string {
+	switch {
+	case v >= 1e9 || v <= -1e9:
+		return strconv.FormatFloat(v/1e9, 'f', 1, 64) + "B"
+	case v >= 1e6 || v <= -1e6:
+		return strconv.FormatFloat(v/1e6, 'f', 1, 64) + "M"
+	case v >= 1e3 || v <= -1e3:
+		return strconv.FormatFloat(v/1e3, 'f', 1, 64) + "K"
+	default:
+		return strconv.FormatFloat(v, 'f', 0, 64)
+	}
+}
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"amount":  formatAmount,
+	"percent": func(v float64) string { return strconv.FormatFloat(v*100, 'f', 1, 64) + "%" },
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Usage report {{.GeneratedAt.Format "2006-01-02 15:04"}}</title>
+<style>
+body { font-family: -apple-system, BlinkMacSystemFont, sans-serif; margin: 32px; color: #1D1D1F; }
+table { border-collapse: collapse; margin-bottom: 24px; min-width: 480px; }
+th, td { padding: 6px 12px; border-bottom: 1px solid #E5E5EA; text-align: right; }
+th:first-child, td:first-child { text-align: left; }
+</style>
+</head>
+<body>
+<h1>Usage report</h1>
+<p>Generated {{.GeneratedAt.Format "2006-01-02 15:04:05 MST"}} &middot; {{.TotalKeys}} keys &middot;
+{{amount .Totals.TotalUsed}} of {{amount .Totals.TotalAllowance}} used ({{amount .Totals.Remaining}} remaining)</p>
+{{define "rows"}}<table>
+<tr><th>Name</th><th>Keys</th><th>Allowance</th><th>Used</th><th>Remaining</th><th>Used %</th></tr>
+{{range .}}<tr><td>{{.Name}}</td><td>{{.Keys}}</td><td>{{amount .TotalAllowance}}</td><td>{{amount .TotalUsed}}</td><td>{{amount .Remaining}}</td><td>{{percent .UsedRatio}}</td></tr>
+{{end}}</table>{{end}}
+<h2>By group</h2>
+{{template "rows" .ByGroup}}
+<h2>By provider</h2>
+{{template "rows" .ByProvider}}
+</body>
+</html>
+`))
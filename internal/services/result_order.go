@@ -0,0 +1,72 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/droid-keyusage-go/internal/models"
+	"github.com/droid-keyusage-go/internal/storage"
+)
+
+// Orders of the usage results in /api/data
+const (
+	OrderCreatedAt = "created_at"
+	OrderName      = "name"
+)
+
+// SetResultOrder sets how usage results are ordered: by the time their key
+// was added (OrderCreatedAt, the default) or by key name (OrderName); ties
+// are broken by key ID so the order never changes between requests
+func (s *APIKeyService) SetResultOrder(order string) error {
+	switch order {
+	case "":
+		order = OrderCreatedAt
+	case OrderCreatedAt, OrderName:
+	default:
+		return fmt.Errorf("unknown order %q, expected %s or %s", order, OrderCreatedAt, OrderName)
+	}
+	s.settingsMu.Lock()
+	s.order = order
+	s.settingsMu.Unlock()
+	return nil
+}
+
+// sortKeys returns keys ordered by the configured result order; the slice
+// passed in may be shared with the degraded mode and is left as it is
+func (s *APIKeyService) sortKeys(keys []*storage.APIKey) []*storage.APIKey {
+	s.settingsMu.RLock()
+	byName := s.order == OrderName
+	s.settingsMu.RUnlock()
+
+	keys = append([]*storage.APIKey(nil), keys...)
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if byName && a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+		return a.ID < b.ID
+	})
+	return keys
+}
+
+// orderResults returns one result per key, in the order of keys; when a key
+// has several results the last one wins, so fresh results replace cached
+// ones. Results of unknown keys are dropped.
+func orderResults(keys []*storage.APIKey, results []*models.Usage) []*models.Usage {
+	byID := make(map[string]*models.Usage, len(results))
+	for _, usage := range results {
+		byID[usage.ID] = usage
+	}
+
+	ordered := make([]*models.Usage, 0, len(keys))
+	for _, key := range keys {
+		if usage, ok := byID[key.ID]; ok {
+			ordered = append(ordered, usage)
+			delete(byID, key.ID)
+		}
+	}
+	return ordered
+}
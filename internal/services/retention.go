@@ -0,0 +1,112 @@
+package services
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/droid-keyusage-go/internal/models"
+	"github.com/droid-keyusage-go/internal/storage"
+)
+
+// PruneFunc removes records older than cutoff and reports how many were removed
+type PruneFunc func(cutoff time.Time) (int64, error)
+
+// retentionPolicy describes how long one kind of data is kept
+type retentionPolicy struct {
+	name      string
+	retention time.Duration
+	prune     PruneFunc
+}
+
+// RetentionService periodically prunes data past its retention window
+type RetentionService struct {
+	interval time.Duration
+	policies []retentionPolicy
+	mu       sync.Mutex
+	shutdown chan struct{}
+	wg       sync.WaitGroup
+}
+
+// NewRetentionService creates a retention service with the usage history policy registered
+func NewRetentionService(store *storage.Storage, interval, historyRetention time.Duration) *RetentionService {
+	s := &RetentionService{
+		interval: interval,
+		shutdown: make(chan struct{}),
+	}
+	s.Register("usage_history", historyRetention, store.PruneHistory)
+	return s
+}
+
+// Register adds a retention policy; a zero or negative retention keeps data forever
+func (s *RetentionService) Register(name string, retention time.Duration, prune PruneFunc) {
+	if retention <= 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.policies = append(s.policies, retentionPolicy{
+		name:      name,
+		retention: retention,
+		prune:     prune,
+	})
+}
+
+// Start launches the background pruning job
+func (s *RetentionService) Start() {
+	if s.interval <= 0 {
+		return
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				result := s.Prune()
+				if result.Total > 0 {
+					fmt.Fprintf(console, "🧹 数据清理完成: 共删除 %d 条过期记录 %v\n", result.Total, result.Removed)
+				}
+			case <-s.shutdown:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the background pruning job
+func (s *RetentionService) Stop() {
+	close(s.shutdown)
+	s.wg.Wait()
+}
+
+// Prune runs every registered policy once
+func (s *RetentionService) Prune() *models.PruneResult {
+	s.mu.Lock()
+	policies := make([]retentionPolicy, len(s.policies))
+	copy(policies, s.policies)
+	s.mu.Unlock()
+
+	result := &models.PruneResult{
+		Removed: make(map[string]int64, len(policies)),
+		Errors:  make(map[string]string),
+	}
+
+	now := time.Now()
+	for _, policy := range policies {
+		removed, err := policy.prune(now.Add(-policy.retention))
+		if err != nil {
+			result.Errors[policy.name] = err.Error()
+			continue
+		}
+		result.Removed[policy.name] = removed
+		result.Total += removed
+	}
+
+	return result
+}
@@ -0,0 +1,332 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/droid-keyusage-go/internal/models"
+	"github.com/droid-keyusage-go/internal/storage"
+)
+
+// ErrDeadLetterNotFound is returned when a key is not on the dead-letter list
+var ErrDeadLetterNotFound = errors.New("dead letter not found")
+
+const (
+	// retryBatchSize bounds how many queued keys one check retries
+	retryBatchSize = 100
+	// maxRetryBackoff caps the delay between two retries of a key
+	maxRetryBackoff = time.Hour
+)
+
+// RetryService retries the keys whose fetches failed for a reason that may
+// go away (timeouts, 5xx and 429 responses, a full queue) with exponential
+// backoff, instead of waiting for the next refresh. Keys that still fail
+// after the last attempt, or fail for a reason that won't go away, are
+// moved to the dead-letter list until they are requeued or fetched
+// successfully by a regular refresh.
+type RetryService struct {
+	store       *storage.Storage
+	apiKeys     *APIKeyService
+	workerPool  *WorkerPool
+	maxAttempts int
+	backoff     time.Duration
+	interval    time.Duration
+	shutdown    chan struct{}
+	wg          sync.WaitGroup
+}
+
+// NewRetryService creates the retry queue; maxAttempts <= 0 turns it off.
+// The first retry of a key happens backoff after it failed, and every
+// further retry waits twice as long as the previous one.
+func NewRetryService(store *storage.Storage, apiKeys *APIKeyService, workerPool *WorkerPool, maxAttempts int, backoff, interval time.Duration) *RetryService {
+	return &RetryService{
+		store:       store,
+		apiKeys:     apiKeys,
+		workerPool:  workerPool,
+		maxAttempts: maxAttempts,
+		backoff:     backoff,
+		interval:    interval,
+		shutdown:    make(chan struct{}),
+	}
+}
+
+// retryable reports whether a failed fetch may succeed when tried again
+func retryable(usage *models.Usage) bool {
+	switch usageErrorCode(usage) {
+	case UsageErrQueueFull, UsageErrProcessingTimeout, UsageErrUpstreamTimeout, UsageErrUpstreamFailed,
+		UsageErrUpstream5xx, UsageErrRateLimited:
+		return true
+	}
+	return false
+}
+
+// delay is how long a key waits for its next retry after attempts failed
+// retries
+func (s *RetryService) delay(attempts int) time.Duration {
+	delay := s.backoff
+	for i := 0; i < attempts && delay < maxRetryBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryBackoff {
+		delay = maxRetryBackoff
+	}
+	return delay
+}
+
+// Track queues the keys whose fetches failed for a retryable reason and
+// takes keys that were fetched successfully off the retry queue and the
+// dead-letter list; it is registered as a refresh hook
+func (s *RetryService) Track(keys []*storage.APIKey, results []*models.Usage) {
+	if s.maxAttempts <= 0 {
+		return
+	}
+
+	keyMap := make(map[string]*storage.APIKey, len(keys))
+	for _, key := range keys {
+		keyMap[key.ID] = key
+	}
+
+	now := time.Now()
+	var failed []*storage.RetryEntry
+	var succeeded []string
+	for _, usage := range results {
+		key := keyMap[usage.ID]
+		if key == nil || key.Disabled || key.IsExpired(now) {
+			continue
+		}
+		switch {
+		case usage.Error == "":
+			succeeded = append(succeeded, usage.ID)
+		case retryable(usage):
+			failed = append(failed, &storage.RetryEntry{
+				KeyID:         usage.ID,
+				ErrorCode:     usageErrorCode(usage),
+				LastError:     usage.Error,
+				FirstFailedAt: now,
+				NextAttemptAt: now.Add(s.delay(0)),
+			})
+		}
+	}
+
+	if err := s.store.EnqueueRetries(failed); err != nil {
+		fmt.Fprintf(console, "⚠️  加入重试队列失败: %v\n", err)
+	}
+	if len(succeeded) == 0 {
+		return
+	}
+
+	// Most refreshes succeed for keys that never failed; only touch the
+	// ones that are queued or dead-lettered
+	retrying, err := s.store.RetryingKeyIDs()
+	if err != nil {
+		fmt.Fprintf(console, "⚠️  读取重试队列失败: %v\n", err)
+		return
+	}
+	recovered := make([]string, 0)
+	for _, id := range succeeded {
+		if retrying[id] {
+			recovered = append(recovered, id)
+		}
+	}
+	if err := s.store.ClearRetries(recovered); err != nil {
+		fmt.Fprintf(console, "⚠️  清理重试队列失败: %v\n", err)
+	}
+}
+
+// Start launches the background retries
+func (s *RetryService) Start() {
+	if s.maxAttempts <= 0 || s.interval <= 0 {
+		return
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		ctx, cancel := shutdownContext(s.shutdown)
+		defer cancel()
+		ctx = withRefreshTrigger(ctx, TriggerRetry)
+
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := s.retryDue(ctx); err != nil && ctx.Err() == nil {
+					fmt.Fprintf(console, "⚠️  重试失败的 Key 失败: %v\n", err)
+				}
+			case <-s.shutdown:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the background retries
+func (s *RetryService) Stop() {
+	close(s.shutdown)
+	s.wg.Wait()
+}
+
+// retryDue fetches the queued keys whose next attempt is due, reschedules
+// the ones that fail again and dead-letters the ones out of attempts
+func (s *RetryService) retryDue(ctx context.Context) error {
+	store := s.store.WithContext(ctx)
+	now := time.Now()
+	entries, err := store.DueRetries(now, retryBatchSize)
+	if err != nil || len(entries) == 0 {
+		return err
+	}
+
+	ids := make([]string, len(entries))
+	for i, entry := range entries {
+		ids[i] = entry.KeyID
+	}
+	keys, err := store.GetAPIKeys(ids)
+	if err != nil {
+		return err
+	}
+
+	// Keys deleted, disabled or expired since they failed are not retried
+	keyMap := make(map[string]*storage.APIKey, len(keys))
+	due := make([]*storage.APIKey, 0, len(keys))
+	for _, key := range keys {
+		if !key.Disabled && !key.IsExpired(now) {
+			keyMap[key.ID] = key
+			due = append(due, key)
+		}
+	}
+	var gone []string
+	for _, id := range ids {
+		if keyMap[id] == nil {
+			gone = append(gone, id)
+		}
+	}
+	if err := store.ClearRetries(gone); err != nil {
+		return err
+	}
+	if len(due) == 0 {
+		return nil
+	}
+
+	start := time.Now()
+	results, err := s.workerPool.BatchProcess(ctx, due)
+	if err != nil {
+		return err
+	}
+	s.apiKeys.recordJob(ctx, start, time.Now(), len(due), len(due), tallyResults(results))
+	s.apiKeys.saveFetched(due, results, s.apiKeys.cachePolicy(-1), false)
+
+	entryMap := make(map[string]*storage.RetryEntry, len(entries))
+	for _, entry := range entries {
+		entryMap[entry.KeyID] = entry
+	}
+
+	var succeeded []string
+	for _, usage := range results {
+		entry := entryMap[usage.ID]
+		if entry == nil {
+			continue
+		}
+		if usage.Error == "" {
+			succeeded = append(succeeded, usage.ID)
+			continue
+		}
+
+		entry.Attempts++
+		entry.ErrorCode = usageErrorCode(usage)
+		entry.LastError = usage.Error
+		if retryable(usage) && entry.Attempts < s.maxAttempts {
+			entry.NextAttemptAt = time.Now().Add(s.delay(entry.Attempts))
+			if err := store.RescheduleRetry(entry); err != nil {
+				return err
+			}
+			continue
+		}
+
+		deadAt := time.Now()
+		entry.DeadAt = &deadAt
+		entry.NextAttemptAt = time.Time{}
+		if err := store.MoveToDeadLetter(entry); err != nil {
+			return err
+		}
+		fmt.Fprintf(console, "☠️  Key %s 重试 %d 次后仍然失败, 已移入死信列表: %s\n", keyMap[usage.ID].Name, entry.Attempts, entry.LastError)
+	}
+	return store.ClearRetries(succeeded)
+}
+
+// DeadLetters returns the dead-lettered keys, most recent first
+func (s *RetryService) DeadLetters(ctx context.Context) ([]*models.DeadLetter, error) {
+	store := s.store.WithContext(ctx)
+	entries, err := store.ListDeadLetters()
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, len(entries))
+	for i, entry := range entries {
+		ids[i] = entry.KeyID
+	}
+	keys, err := store.GetAPIKeys(ids)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]string, len(keys))
+	for _, key := range keys {
+		names[key.ID] = key.Name
+	}
+
+	letters := make([]*models.DeadLetter, 0, len(entries))
+	var gone []string
+	for _, entry := range entries {
+		name, ok := names[entry.KeyID]
+		if !ok {
+			gone = append(gone, entry.KeyID)
+			continue
+		}
+		letters = append(letters, &models.DeadLetter{
+			ID:            entry.KeyID,
+			Name:          name,
+			Attempts:      entry.Attempts,
+			ErrorCode:     entry.ErrorCode,
+			LastError:     entry.LastError,
+			FirstFailedAt: entry.FirstFailedAt,
+			DeadAt:        entry.DeadTime(),
+		})
+	}
+
+	// Keys deleted since they were dead-lettered are dropped
+	if err := store.ClearRetries(gone); err != nil {
+		return nil, err
+	}
+	return letters, nil
+}
+
+// Requeue moves a dead-lettered key back onto the retry queue with a full
+// set of attempts; it is retried on the next check
+func (s *RetryService) Requeue(ctx context.Context, id string) error {
+	store := s.store.WithContext(ctx)
+	entry, err := store.GetDeadLetter(id)
+	if err != nil {
+		return err
+	}
+	if entry == nil {
+		return ErrDeadLetterNotFound
+	}
+
+	entry.Attempts = 0
+	entry.DeadAt = nil
+	entry.NextAttemptAt = time.Now()
+	moved, err := store.RequeueDeadLetter(entry)
+	if err != nil {
+		return err
+	}
+	if !moved {
+		return ErrDeadLetterNotFound
+	}
+	return nil
+}
@@ -0,0 +1,360 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/droid-keyusage-go/internal/models"
+	"github.com/droid-keyusage-go/internal/storage"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// Functions a hook script may define
+const (
+	hookOnImport     = "on_import"
+	hookAfterRefresh = "after_refresh"
+	hookBeforeAlert  = "before_alert"
+)
+
+// ErrKeyRejected is matched by every KeyRejectedError
+var ErrKeyRejected = errors.New("key rejected")
+
+// KeyRejectedError is returned for a key the on_import hook rejected
+type KeyRejectedError struct {
+	Reason string
+}
+
+func (e *KeyRejectedError) Error() string {
+	return "key rejected: " + e.Reason
+}
+
+// Is makes errors.Is(err, ErrKeyRejected) match
+func (e *KeyRejectedError) Is(target error) bool {
+	return target == ErrKeyRejected
+}
+
+// defaultHookTimeout bounds one call of a hook function
+const defaultHookTimeout = 100 * time.Millisecond
+
+// ScriptHooks runs the functions of a user-supplied Lua script at defined
+// points: on_import(key) when a key is added or imported, after_refresh(keys)
+// after each aggregation, and before_alert(notification) before a
+// notification is delivered. The functions receive tables they may change in
+// place; see the README for the fields. The script gets the base, string,
+// table and math libraries only, and a call running longer than the timeout
+// is stopped. A failing call is logged and changes nothing.
+type ScriptHooks struct {
+	path    string
+	timeout time.Duration
+
+	// mu serializes the calls, the Lua state isn't safe for concurrent use
+	mu    sync.Mutex
+	state *lua.LState
+}
+
+// LoadScriptHooks runs the script at path, which defines the hook functions;
+// an empty path means no hooks and returns nil, on which every hook is a
+// no-op. timeout <= 0 uses the default of 100ms.
+func LoadScriptHooks(path string, timeout time.Duration) (*ScriptHooks, error) {
+	if path == "" {
+		return nil, nil
+	}
+	if timeout <= 0 {
+		timeout = defaultHookTimeout
+	}
+	source, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	state := newHookState()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	state.SetContext(ctx)
+	if err := state.DoString(string(source)); err != nil {
+		state.Close()
+		return nil, fmt.Errorf("hook script %s: %w", path, err)
+	}
+	state.RemoveContext()
+
+	h := &ScriptHooks{path: path, timeout: timeout, state: state}
+	if len(h.Defined()) == 0 {
+		state.Close()
+		return nil, fmt.Errorf("hook script %s defines none of %s, %s, %s", path, hookOnImport, hookAfterRefresh, hookBeforeAlert)
+	}
+	return h, nil
+}
+
+// newHookState creates a Lua state with only the libraries that can't reach
+// the file system, the network or other processes
+func newHookState() *lua.LState {
+	state := lua.NewState(lua.Options{SkipOpenLibs: true})
+	for _, lib := range []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		state.Push(state.NewFunction(lib.open))
+		state.Push(lua.LString(lib.name))
+		state.Call(1, 0)
+	}
+	for _, name := range []string{"dofile", "loadfile", "load", "loadstring", "require", "module"} {
+		state.SetGlobal(name, lua.LNil)
+	}
+	return state
+}
+
+// Defined lists the hook functions the script defines
+func (h *ScriptHooks) Defined() []string {
+	if h == nil {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var defined []string
+	for _, name := range []string{hookOnImport, hookAfterRefresh, hookBeforeAlert} {
+		if _, ok := h.state.GetGlobal(name).(*lua.LFunction); ok {
+			defined = append(defined, name)
+		}
+	}
+	return defined
+}
+
+// Close releases the Lua state
+func (h *ScriptHooks) Close() {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	h.state.Close()
+	h.mu.Unlock()
+}
+
+// call runs the hook function name with args and returns its two results,
+// nil when the script doesn't define it; h.mu must be held
+func (h *ScriptHooks) call(name string, args ...lua.LValue) (lua.LValue, lua.LValue, bool) {
+	fn, ok := h.state.GetGlobal(name).(*lua.LFunction)
+	if !ok {
+		return nil, nil, false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.timeout)
+	defer cancel()
+	h.state.SetContext(ctx)
+	defer h.state.RemoveContext()
+
+	if err := h.state.CallByParam(lua.P{Fn: fn, NRet: 2, Protect: true}, args...); err != nil {
+		fmt.Fprintf(console, "⚠️  钩子脚本 %s 的 %s 出错: %v\n", h.path, name, err)
+		return nil, nil, false
+	}
+	first, second := h.state.Get(-2), h.state.Get(-1)
+	h.state.Pop(2)
+	return first, second, true
+}
+
+// OnImport runs on_import for a key about to be added, which may change its
+// name, group, tags and notes. It returns a reason when the script rejects
+// the key by returning false, optionally followed by the reason.
+func (h *ScriptHooks) OnImport(key *storage.APIKey) (rejected string) {
+	if h == nil {
+		return ""
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	table := h.keyTable(key, nil)
+	result, reason, ok := h.call(hookOnImport, table)
+	if !ok {
+		return ""
+	}
+	if result == lua.LFalse {
+		if reason.Type() == lua.LTString {
+			return reason.String()
+		}
+		return "rejected by " + hookOnImport
+	}
+	readKeyTable(table, key)
+	return ""
+}
+
+// AfterRefresh runs after_refresh with every key and its latest result, and
+// returns copies of the keys whose name, group, tags or notes the script
+// changed, for the caller to save
+func (h *ScriptHooks) AfterRefresh(keys []*storage.APIKey, results []*models.Usage) []*storage.APIKey {
+	if h == nil {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	resultMap := make(map[string]*models.Usage, len(results))
+	for _, usage := range results {
+		resultMap[usage.ID] = usage
+	}
+	list := h.state.NewTable()
+	tables := make([]*lua.LTable, len(keys))
+	for i, key := range keys {
+		tables[i] = h.keyTable(key, resultMap[key.ID])
+		list.Append(tables[i])
+	}
+	if _, _, ok := h.call(hookAfterRefresh, list); !ok {
+		return nil
+	}
+
+	var changed []*storage.APIKey
+	for i, key := range keys {
+		updated := *key
+		if readKeyTable(tables[i], &updated) {
+			changed = append(changed, &updated)
+		}
+	}
+	return changed
+}
+
+// BeforeAlert runs before_alert for a notification about to be delivered,
+// which may change its title, message and severity; false means the script
+// dropped it
+func (h *ScriptHooks) BeforeAlert(n *Notification) bool {
+	if h == nil {
+		return true
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	table := h.state.NewTable()
+	table.RawSetString("event", lua.LString(n.Event))
+	table.RawSetString("title", lua.LString(n.Title))
+	table.RawSetString("message", lua.LString(n.Message))
+	table.RawSetString("key_id", lua.LString(n.KeyID))
+	table.RawSetString("severity", lua.LString(n.Severity))
+	table.RawSetString("dedup_key", lua.LString(n.DedupKey))
+	data := h.state.NewTable()
+	for name, value := range n.Data {
+		data.RawSetString(name, h.luaValue(value))
+	}
+	table.RawSetString("data", data)
+
+	result, _, ok := h.call(hookBeforeAlert, table)
+	if !ok {
+		return true
+	}
+	if result == lua.LFalse {
+		return false
+	}
+	n.Title = tableString(table, "title", n.Title)
+	n.Message = tableString(table, "message", n.Message)
+	n.Severity = tableString(table, "severity", n.Severity)
+	return true
+}
+
+// keyTable describes a key, and its latest result when given, to a script;
+// the key itself is never passed
+func (h *ScriptHooks) keyTable(key *storage.APIKey, usage *models.Usage) *lua.LTable {
+	table := h.state.NewTable()
+	table.RawSetString("id", lua.LString(key.ID))
+	table.RawSetString("name", lua.LString(key.Name))
+	table.RawSetString("provider", lua.LString(providerName(key)))
+	table.RawSetString("group", lua.LString(key.Group))
+	table.RawSetString("notes", lua.LString(key.Notes))
+	table.RawSetString("owner", lua.LString(key.Owner))
+	table.RawSetString("disabled", lua.LBool(key.Disabled))
+	tags := h.state.NewTable()
+	for _, tag := range key.Tags {
+		tags.Append(lua.LString(tag))
+	}
+	table.RawSetString("tags", tags)
+
+	if usage != nil {
+		table.RawSetString("used", lua.LNumber(usage.OrgTotalUsed))
+		table.RawSetString("allowance", lua.LNumber(usage.TotalAllowance))
+		table.RawSetString("remaining", lua.LNumber(usage.Remaining))
+		table.RawSetString("used_ratio", lua.LNumber(usage.UsedRatio))
+		table.RawSetString("error", lua.LString(usage.Error))
+		table.RawSetString("error_code", lua.LString(usage.ErrorCode))
+	}
+	return table
+}
+
+// readKeyTable copies the fields a script may change back to key and
+// reports whether any of them changed
+func readKeyTable(table *lua.LTable, key *storage.APIKey) bool {
+	name := strings.TrimSpace(tableString(table, "name", key.Name))
+	group := strings.TrimSpace(tableString(table, "group", key.Group))
+	notes := strings.TrimSpace(tableString(table, "notes", key.Notes))
+	tags := key.Tags
+	if list, ok := table.RawGetString("tags").(*lua.LTable); ok {
+		var values []string
+		list.ForEach(func(_, value lua.LValue) {
+			if value.Type() == lua.LTString {
+				values = append(values, value.String())
+			}
+		})
+		tags = normalizeTags(values)
+	}
+
+	changed := name != key.Name || group != key.Group || notes != key.Notes || !sameTags(tags, key.Tags)
+	key.Name, key.Group, key.Notes, key.Tags = name, group, notes, tags
+	return changed
+}
+
+// sameTags reports whether a and b hold the same tags in any order
+func sameTags(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a, b = append([]string(nil), a...), append([]string(nil), b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// tableString returns the string field name of table, fallback when it
+// isn't a string
+func tableString(table *lua.LTable, name, fallback string) string {
+	if value, ok := table.RawGetString(name).(lua.LString); ok {
+		return string(value)
+	}
+	return fallback
+}
+
+// luaValue converts a notification data value for a script
+func (h *ScriptHooks) luaValue(value interface{}) lua.LValue {
+	switch v := value.(type) {
+	case nil:
+		return lua.LNil
+	case string:
+		return lua.LString(v)
+	case bool:
+		return lua.LBool(v)
+	case int:
+		return lua.LNumber(v)
+	case int64:
+		return lua.LNumber(v)
+	case float64:
+		return lua.LNumber(v)
+	case []string:
+		list := h.state.NewTable()
+		for _, s := range v {
+			list.Append(lua.LString(s))
+		}
+		return list
+	default:
+		return lua.LString(fmt.Sprint(v))
+	}
+}
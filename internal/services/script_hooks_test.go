@@ -0,0 +1,150 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/droid-keyusage-go/internal/storage"
+)
+
+// writeHookScript saves source as a hook script and returns its path
+func writeHookScript(t *testing.T, source string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "hooks.lua")
+	if err := os.WriteFile(path, []byte(source), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestScriptHooksTimeout(t *testing.T) {
+	hooks, err := LoadScriptHooks(writeHookScript(t, `
+function on_import(key)
+	if key.name == "loop" then
+		while true do end
+	end
+	key.group = "checked"
+end
+`), 50*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hooks.Close()
+
+	key := &storage.APIKey{Name: "loop"}
+	start := time.Now()
+	if rejected := hooks.OnImport(key); rejected != "" {
+		t.Fatalf("endless hook rejected the key: %q", rejected)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("endless hook ran for %v with a 50ms timeout", elapsed)
+	}
+	if key.Group != "" {
+		t.Fatalf("stopped hook changed the key: group %q", key.Group)
+	}
+
+	// The state stays usable after a call was stopped
+	key = &storage.APIKey{Name: "next"}
+	hooks.OnImport(key)
+	if key.Group != "checked" {
+		t.Fatalf("hook after a timeout: group %q, want checked", key.Group)
+	}
+}
+
+func TestScriptHooksLoadTimeout(t *testing.T) {
+	path := writeHookScript(t, `
+function on_import(key) end
+while true do end
+`)
+	start := time.Now()
+	if hooks, err := LoadScriptHooks(path, 50*time.Millisecond); err == nil {
+		hooks.Close()
+		t.Fatal("script looping at load time was accepted")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("loading an endless script took %v with a 50ms timeout", elapsed)
+	}
+}
+
+func TestScriptHooksSandbox(t *testing.T) {
+	secret := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(secret, []byte("leaked"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each call would store the file's contents in the group if it worked
+	escapes := map[string]string{
+		"io":       `io.open(path):read("*a")`,
+		"os":       `os.getenv("HOME") .. (os.execute("true") and "leaked" or "")`,
+		"dofile":   `dofile(path)`,
+		"loadfile": `loadfile(path)()`,
+		"load":     `load("return 'leaked'")()`,
+		"require":  `require("io").open(path):read("*a")`,
+		"package":  `package.loadlib(path, "open")()`,
+		"debug":    `debug.getregistry()._LOADED.io.open(path):read("*a")`,
+	}
+	for name, call := range escapes {
+		t.Run(name, func(t *testing.T) {
+			hooks, err := LoadScriptHooks(writeHookScript(t, `
+local path = "`+secret+`"
+function on_import(key)
+	key.group = `+call+`
+end
+`), 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer hooks.Close()
+
+			key := &storage.APIKey{Name: "key"}
+			if rejected := hooks.OnImport(key); rejected != "" {
+				t.Fatalf("failed hook rejected the key: %q", rejected)
+			}
+			if key.Group != "" {
+				t.Fatalf("hook read %q through %s", key.Group, name)
+			}
+		})
+	}
+
+	// The same calls at load time fail the script
+	path := writeHookScript(t, `
+function on_import(key) end
+local f = io.open("`+secret+`")
+`)
+	if hooks, err := LoadScriptHooks(path, 0); err == nil {
+		hooks.Close()
+		t.Fatal("script opening a file at load time was accepted")
+	} else if !strings.Contains(err.Error(), path) {
+		t.Fatalf("load error %q doesn't name the script", err)
+	}
+}
+
+func TestScriptHooksOnImport(t *testing.T) {
+	hooks, err := LoadScriptHooks(writeHookScript(t, `
+function on_import(key)
+	if key.name:find("^test") then
+		return false, "test keys aren't imported"
+	end
+	key.name = key.name:upper()
+	table.insert(key.tags, "imported")
+end
+`), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hooks.Close()
+
+	if rejected := hooks.OnImport(&storage.APIKey{Name: "test-1"}); rejected != "test keys aren't imported" {
+		t.Fatalf("rejection reason %q", rejected)
+	}
+	key := &storage.APIKey{Name: "prod", Tags: []string{"team"}}
+	if rejected := hooks.OnImport(key); rejected != "" {
+		t.Fatalf("key rejected: %q", rejected)
+	}
+	if key.Name != "PROD" || !sameTags(key.Tags, []string{"team", "imported"}) {
+		t.Fatalf("hook result: name %q, tags %v", key.Name, key.Tags)
+	}
+}
@@ -0,0 +1,109 @@
+package services
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/droid-keyusage-go/internal/models"
+	"github.com/droid-keyusage-go/internal/storage"
+)
+
+// Limits on the number of keys a search returns
+const (
+	DefaultSearchLimit = 20
+	MaxSearchLimit     = 100
+)
+
+// SearchKeys returns the keys p may see that match every whitespace
+// separated term of query, ordered by name. A term matches when it appears,
+// case-insensitively, in the key's name, notes, group or one of its tags,
+// or in the part of the key value the mask policy shows; viewers, who see
+// no part of any key, can't match key values.
+func (s *APIKeyService) SearchKeys(ctx context.Context, query string, limit int, p Principal) ([]*models.APIKeyMasked, error) {
+	if limit <= 0 || limit > MaxSearchLimit {
+		limit = DefaultSearchLimit
+	}
+	terms := strings.Fields(strings.ToLower(query))
+	if len(terms) == 0 {
+		return []*models.APIKeyMasked{}, nil
+	}
+
+	store := s.store.WithContext(ctx)
+	entries, err := store.GetKeySearchEntries()
+	if err != nil {
+		return nil, err
+	}
+
+	policy := s.MaskPolicy()
+	ids := make([]string, 0)
+	for id, entry := range entries {
+		if !s.canSee(&storage.APIKey{Owner: entry.Owner}, p) {
+			continue
+		}
+		suffix := ""
+		if p.Role != RoleViewer {
+			suffix = entry.Suffix
+			if n := policy.visibleSuffix(entry.Length); n < len(suffix) {
+				suffix = suffix[len(suffix)-n:]
+			}
+		}
+		if searchMatches(entry, strings.ToLower(suffix), terms) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		a, b := strings.ToLower(entries[ids[i]].Name), strings.ToLower(entries[ids[j]].Name)
+		if a != b {
+			return a < b
+		}
+		return ids[i] < ids[j]
+	})
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+
+	keys, err := store.GetAPIKeys(ids)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	results := make([]*models.APIKeyMasked, 0, len(keys))
+	for _, key := range keys {
+		// Deleted between reading the index and the keys
+		if key.DeletedAt != nil {
+			continue
+		}
+		results = append(results, s.maskedKey(key, now, p))
+	}
+	return results, nil
+}
+
+// searchMatches reports whether every term appears in the entry's metadata
+// or its visible suffix
+func searchMatches(entry *storage.KeySearchEntry, suffix string, terms []string) bool {
+	fields := make([]string, 0, len(entry.Tags)+4)
+	fields = append(fields, entry.Name, entry.Notes, entry.Group)
+	fields = append(fields, entry.Tags...)
+	for i := range fields {
+		fields[i] = strings.ToLower(fields[i])
+	}
+	if suffix != "" {
+		fields = append(fields, suffix)
+	}
+
+	for _, term := range terms {
+		found := false
+		for _, field := range fields {
+			if strings.Contains(field, term) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
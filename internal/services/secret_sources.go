@@ -0,0 +1,253 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// secretFetchTimeout bounds one read of a secret store
+const secretFetchTimeout = 10 * time.Second
+
+// VaultOptions locate a HashiCorp Vault KV secret
+type VaultOptions struct {
+	// Addr is the Vault server, e.g. https://vault.example.com:8200
+	Addr  string
+	Token string
+	// Namespace is the Vault Enterprise namespace, if any
+	Namespace string
+	// Path is the API path below /v1, e.g. "secret/data/droid" for a KV
+	// version 2 engine mounted at secret/
+	Path string
+}
+
+// vaultSource reads a Vault KV secret, of either engine version
+type vaultSource struct {
+	url    string
+	opts   VaultOptions
+	client *http.Client
+}
+
+// NewVaultSource creates a source reading the Vault secret opts describes
+func NewVaultSource(opts VaultOptions) (SecretSource, error) {
+	if opts.Addr == "" || opts.Path == "" {
+		return nil, errors.New("vault needs VAULT_ADDR and VAULT_SECRET_PATH")
+	}
+	if opts.Token == "" {
+		return nil, errors.New("vault needs VAULT_TOKEN")
+	}
+	base, err := url.Parse(strings.TrimRight(opts.Addr, "/"))
+	if err != nil || base.Host == "" {
+		return nil, fmt.Errorf("invalid VAULT_ADDR %q", opts.Addr)
+	}
+	return &vaultSource{
+		url:    base.String() + "/v1/" + strings.Trim(opts.Path, "/"),
+		opts:   opts,
+		client: &http.Client{Timeout: secretFetchTimeout},
+	}, nil
+}
+
+// Fetch reads the secret's key/value pairs
+func (v *vaultSource) Fetch(ctx context.Context) (map[string]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", v.opts.Token)
+	if v.opts.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.opts.Namespace)
+	}
+
+	body, err := fetchSecret(v.client, req)
+	if err != nil {
+		return nil, fmt.Errorf("vault %s: %w", v.opts.Path, err)
+	}
+	var resp struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("vault %s: %w", v.opts.Path, err)
+	}
+
+	// KV version 2 nests the pairs under data.data next to data.metadata
+	data := resp.Data
+	if nested, ok := data["data"]; ok {
+		if _, ok := data["metadata"]; ok {
+			data = nil
+			if err := json.Unmarshal(nested, &data); err != nil {
+				return nil, fmt.Errorf("vault %s: %w", v.opts.Path, err)
+			}
+		}
+	}
+	return secretValues(data), nil
+}
+
+// AWSSecretsOptions locate an AWS Secrets Manager secret holding a JSON
+// object, and the credentials to read it with
+type AWSSecretsOptions struct {
+	Region       string
+	SecretID     string
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+	// Endpoint replaces the regional endpoint, e.g. for a VPC endpoint
+	Endpoint string
+}
+
+// awsSecretsSource reads a secret with GetSecretValue, signed with AWS
+// Signature Version 4
+type awsSecretsSource struct {
+	endpoint *url.URL
+	opts     AWSSecretsOptions
+	client   *http.Client
+}
+
+// NewAWSSecretsSource creates a source reading the secret opts describes
+func NewAWSSecretsSource(opts AWSSecretsOptions) (SecretSource, error) {
+	if opts.Region == "" || opts.SecretID == "" {
+		return nil, errors.New("aws needs AWS_REGION and AWS_SECRET_ID")
+	}
+	if opts.AccessKey == "" || opts.SecretKey == "" {
+		return nil, errors.New("aws needs AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	if opts.Endpoint == "" {
+		opts.Endpoint = "https://secretsmanager." + opts.Region + ".amazonaws.com"
+	}
+	endpoint, err := url.Parse(strings.TrimRight(opts.Endpoint, "/"))
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid AWS_SECRETS_ENDPOINT %q", opts.Endpoint)
+	}
+	return &awsSecretsSource{
+		endpoint: endpoint,
+		opts:     opts,
+		client:   &http.Client{Timeout: secretFetchTimeout},
+	}, nil
+}
+
+// Fetch reads the current version of the secret
+func (a *awsSecretsSource) Fetch(ctx context.Context) (map[string]string, error) {
+	payload, err := json.Marshal(map[string]string{"SecretId": a.opts.SecretID})
+	if err != nil {
+		return nil, err
+	}
+	u := *a.endpoint
+	u.Path = "/"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	a.sign(req, payload, time.Now().UTC())
+
+	body, err := fetchSecret(a.client, req)
+	if err != nil {
+		return nil, fmt.Errorf("aws secret %s: %w", a.opts.SecretID, err)
+	}
+	var resp struct {
+		SecretString string `json:"SecretString"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("aws secret %s: %w", a.opts.SecretID, err)
+	}
+	var data map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(resp.SecretString), &data); err != nil {
+		return nil, fmt.Errorf("aws secret %s: expected a JSON object of key/value pairs", a.opts.SecretID)
+	}
+	return secretValues(data), nil
+}
+
+// sign adds an AWS Signature Version 4 authorization header
+func (a *awsSecretsSource) sign(req *http.Request, body []byte, now time.Time) {
+	payloadHash := sha256Hex(body)
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	headers := []string{
+		"content-type:" + req.Header.Get("Content-Type"),
+		"host:" + req.URL.Host,
+		"x-amz-date:" + amzDate,
+	}
+	signedHeaders := "content-type;host;x-amz-date"
+	if a.opts.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", a.opts.SessionToken)
+		headers = append(headers, "x-amz-security-token:"+a.opts.SessionToken)
+		signedHeaders += ";x-amz-security-token"
+	}
+	headers = append(headers, "x-amz-target:"+req.Header.Get("X-Amz-Target"))
+	signedHeaders += ";x-amz-target"
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		"/",
+		"",
+		strings.Join(headers, "\n"),
+		"",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + a.opts.Region + "/secretsmanager/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+a.opts.SecretKey), date)
+	key = hmacSHA256(key, a.opts.Region)
+	key = hmacSHA256(key, "secretsmanager")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		a.opts.AccessKey, scope, signedHeaders, signature))
+}
+
+// fetchSecret sends a request to a secret store and returns the body of a
+// successful response
+func fetchSecret(client *http.Client, req *http.Request) ([]byte, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		// Error bodies of secret stores don't echo secrets, but keep it short
+		detail := strings.TrimSpace(string(body))
+		if len(detail) > 512 {
+			detail = detail[:512]
+		}
+		return nil, fmt.Errorf("status %d: %s", resp.StatusCode, detail)
+	}
+	return body, nil
+}
+
+// secretValues turns the pairs of a secret into strings; values that
+// aren't strings keep their JSON form
+func secretValues(data map[string]json.RawMessage) map[string]string {
+	values := make(map[string]string, len(data))
+	for name, raw := range data {
+		var value string
+		if err := json.Unmarshal(raw, &value); err != nil {
+			value = string(raw)
+		}
+		values[name] = value
+	}
+	return values
+}
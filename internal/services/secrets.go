@@ -0,0 +1,259 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/droid-keyusage-go/internal/config"
+)
+
+// Secret backends (SECRETS_BACKEND)
+const (
+	SecretsBackendVault = "vault"
+	SecretsBackendAWS   = "aws"
+)
+
+// secretRefPrefix marks a credential header value read from the secret
+// store, e.g. "secret:CREDENTIAL_ORG_TOKEN"
+const secretRefPrefix = "secret:"
+
+// credentialSecretPrefix starts the names credential headers may refer to,
+// so the server's own secrets, such as JWT_SECRET, are never sent upstream
+const credentialSecretPrefix = "CREDENTIAL_"
+
+var (
+	// ErrSecretNotFound is returned for a secret reference the store lacks
+	ErrSecretNotFound = errors.New("secret not found")
+	// ErrSecretNotAllowed is returned for a secret reference outside the
+	// CREDENTIAL_ names
+	ErrSecretNotAllowed = errors.New("secret not allowed in credentials")
+)
+
+// SecretSource reads the named values of one entry in a secret store, such
+// as a Vault KV secret or an AWS Secrets Manager secret holding a JSON object
+type SecretSource interface {
+	Fetch(ctx context.Context) (map[string]string, error)
+}
+
+// Secrets holds the values read from a secret store and reads them again
+// every interval, so rotated secrets are picked up without a restart. A
+// failed re-read is logged and keeps the previous values.
+type Secrets struct {
+	source   SecretSource
+	interval time.Duration
+
+	mu       sync.RWMutex
+	values   map[string]string
+	onChange []func(values map[string]string, changed []string)
+
+	shutdown chan struct{}
+	wg       sync.WaitGroup
+}
+
+// NewSecrets creates the secrets read from source; interval <= 0 reads them
+// only once
+func NewSecrets(source SecretSource, interval time.Duration) *Secrets {
+	return &Secrets{
+		source:   source,
+		interval: interval,
+		shutdown: make(chan struct{}),
+	}
+}
+
+// Load reads the secrets; the server doesn't start when the first read fails
+func (s *Secrets) Load(ctx context.Context) error {
+	values, err := s.source.Fetch(ctx)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.values = values
+	s.mu.Unlock()
+	return nil
+}
+
+// Values returns a copy of the secrets
+func (s *Secrets) Values() map[string]string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	values := make(map[string]string, len(s.values))
+	for name, value := range s.values {
+		values[name] = value
+	}
+	return values
+}
+
+// Get returns one secret
+func (s *Secrets) Get(name string) (string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	value, ok := s.values[name]
+	return value, ok
+}
+
+// OnChange registers fn to run with every secret and the names of those
+// that changed, after a re-read changed any
+func (s *Secrets) OnChange(fn func(values map[string]string, changed []string)) {
+	s.mu.Lock()
+	s.onChange = append(s.onChange, fn)
+	s.mu.Unlock()
+}
+
+// Start re-reads the secrets every interval, and soon after a source that
+// watches for changes, such as mounted files, reports one
+func (s *Secrets) Start() {
+	var changes <-chan struct{}
+	if watched, ok := s.source.(interface{ Changes() <-chan struct{} }); ok {
+		changes = watched.Changes()
+	}
+	if s.interval <= 0 && changes == nil {
+		return
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		ctx, cancel := shutdownContext(s.shutdown)
+		defer cancel()
+
+		var tick <-chan time.Time
+		if s.interval > 0 {
+			ticker := time.NewTicker(s.interval)
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+		var settled <-chan time.Time
+
+		for {
+			select {
+			case <-tick:
+				s.reload(ctx)
+			case <-changes:
+				// Read once the burst of changes is over
+				settled = time.After(configSettleDelay)
+			case <-settled:
+				settled = nil
+				s.reload(ctx)
+			case <-s.shutdown:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops re-reading the secrets
+func (s *Secrets) Stop() {
+	close(s.shutdown)
+	s.wg.Wait()
+	if closer, ok := s.source.(io.Closer); ok {
+		closer.Close()
+	}
+}
+
+// reload reads the secrets again and tells the OnChange functions when
+// any changed
+func (s *Secrets) reload(ctx context.Context) {
+	values, err := s.source.Fetch(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			fmt.Fprintf(console, "⚠️  重新读取密钥失败，继续使用上次的值: %v\n", err)
+		}
+		return
+	}
+
+	s.mu.Lock()
+	changed := changedSecrets(s.values, values)
+	if len(changed) > 0 {
+		s.values = values
+	}
+	onChange := s.onChange
+	s.mu.Unlock()
+
+	if len(changed) == 0 {
+		return
+	}
+	for _, fn := range onChange {
+		fn(values, changed)
+	}
+}
+
+// changedSecrets returns the sorted names of the secrets added, removed or
+// changed between old and current
+func changedSecrets(old, current map[string]string) []string {
+	var changed []string
+	for name, value := range current {
+		if previous, ok := old[name]; !ok || previous != value {
+			changed = append(changed, name)
+		}
+	}
+	for name := range old {
+		if _, ok := current[name]; !ok {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// credentialSecrets resolve secret references in credential headers;
+// empty until SetCredentialSecrets is called
+var (
+	credentialSecretsMu sync.RWMutex
+	credentialSecrets   []*Secrets
+)
+
+// SetCredentialSecrets lets the credential headers of keys refer to
+// secrets as "secret:CREDENTIAL_NAME", so provider credentials shared by
+// many keys are kept in the secret store and follow its rotations. A name
+// is looked up in each of secrets in turn; nil entries are skipped.
+func SetCredentialSecrets(secrets ...*Secrets) {
+	var stores []*Secrets
+	for _, store := range secrets {
+		if store != nil {
+			stores = append(stores, store)
+		}
+	}
+	credentialSecretsMu.Lock()
+	credentialSecrets = stores
+	credentialSecretsMu.Unlock()
+}
+
+// checkSecretRef returns an error when value refers to a secret the
+// credential headers of keys may not use; settings made by the operator,
+// such as REMOTE_WRITE_PASSWORD, may refer to any secret
+func checkSecretRef(value string) error {
+	name, ok := strings.CutPrefix(value, secretRefPrefix)
+	if !ok {
+		return nil
+	}
+	if !strings.HasPrefix(name, credentialSecretPrefix) || config.IsSecretSetting(name) {
+		return fmt.Errorf("%w: %s (names must start with %s)", ErrSecretNotAllowed, name, credentialSecretPrefix)
+	}
+	return nil
+}
+
+// resolveSecretRef returns value, or the secret it refers to; values from
+// keys are checked with checkSecretRef first
+func resolveSecretRef(value string) (string, error) {
+	name, ok := strings.CutPrefix(value, secretRefPrefix)
+	if !ok {
+		return value, nil
+	}
+
+	credentialSecretsMu.RLock()
+	stores := credentialSecrets
+	credentialSecretsMu.RUnlock()
+	for _, store := range stores {
+		if secret, ok := store.Get(name); ok {
+			return secret, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s", ErrSecretNotFound, name)
+}
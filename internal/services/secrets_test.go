@@ -0,0 +1,58 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/droid-keyusage-go/internal/storage"
+)
+
+// staticSecrets is a secret source with fixed values
+type staticSecrets map[string]string
+
+func (s staticSecrets) Fetch(context.Context) (map[string]string, error) {
+	return s, nil
+}
+
+func TestCredentialSecretRefs(t *testing.T) {
+	secrets := NewSecrets(staticSecrets{
+		"JWT_SECRET":           "signing-secret",
+		"ORG_TOKEN":            "unprefixed",
+		"CREDENTIAL_ORG_TOKEN": "org-token",
+	}, 0)
+	if err := secrets.Load(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	SetCredentialSecrets(secrets)
+	t.Cleanup(func() { SetCredentialSecrets() })
+
+	key := func(header string) *storage.APIKey {
+		return &storage.APIKey{Key: "fk-test", Credential: &storage.Credential{
+			Headers: map[string]string{"X-Org-Token": header},
+		}}
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "https://example.com", nil)
+	if err := applyCredential(req, key("secret:CREDENTIAL_ORG_TOKEN")); err != nil {
+		t.Fatal(err)
+	}
+	if got := req.Header.Get("X-Org-Token"); got != "org-token" {
+		t.Fatalf("header %q, want org-token", got)
+	}
+
+	for _, name := range []string{"JWT_SECRET", "ORG_TOKEN"} {
+		if err := validateCredential(key("secret:" + name).Credential); !errors.Is(err, ErrInvalidCredential) {
+			t.Errorf("saving a reference to %s: %v, want ErrInvalidCredential", name, err)
+		}
+		// Keys saved before the rule are refused when fetched
+		req, _ := http.NewRequest(http.MethodGet, "https://example.com", nil)
+		if err := applyCredential(req, key("secret:"+name)); !errors.Is(err, ErrSecretNotAllowed) {
+			t.Errorf("fetching with a reference to %s: %v, want ErrSecretNotAllowed", name, err)
+		}
+		if got := req.Header.Get("X-Org-Token"); got != "" {
+			t.Errorf("%s sent upstream as %q", name, got)
+		}
+	}
+}
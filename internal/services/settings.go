@@ -0,0 +1,327 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+
+	"github.com/droid-keyusage-go/internal/models"
+	"github.com/droid-keyusage-go/internal/storage"
+)
+
+// settingsKey stores the settings overridden through the API
+const settingsKey = "settings:overrides"
+
+// ErrInvalidSettings is matched by every SettingsError
+var ErrInvalidSettings = errors.New("invalid settings")
+
+// SettingsError describes a settings value that passed field validation but
+// cannot be applied
+type SettingsError struct {
+	Field  string
+	Reason string
+}
+
+func (e *SettingsError) Error() string {
+	return fmt.Sprintf("invalid %s: %s", e.Field, e.Reason)
+}
+
+// Is makes errors.Is(err, ErrInvalidSettings) match
+func (e *SettingsError) Is(target error) bool {
+	return target == ErrInvalidSettings
+}
+
+// SettingsListener receives the effective settings whenever they change
+type SettingsListener func(settings models.Settings)
+
+// SettingsService keeps runtime-tunable settings in Redis on top of the
+// environment defaults and pushes every change to its listeners
+type SettingsService struct {
+	store     *storage.Storage
+	events    *EventBus
+	defaults  models.Settings
+	overrides models.SettingsUpdate
+	listeners []SettingsListener
+	mu        sync.RWMutex
+}
+
+// NewSettingsService creates a settings service; defaults come from the
+// environment and apply to every setting that is not overridden
+func NewSettingsService(store *storage.Storage, events *EventBus, defaults models.Settings) *SettingsService {
+	s := &SettingsService{
+		store:    store,
+		events:   events,
+		defaults: defaults,
+	}
+
+	// Pick up changes saved on other replicas
+	if events != nil {
+		events.Subscribe(EventSettingsChanged, func(event *Event) {
+			if err := s.Load(); err != nil {
+				fmt.Fprintf(console, "⚠️  重新加载设置失败: %v\n", err)
+			}
+		})
+	}
+
+	return s
+}
+
+// OnChange registers a listener; call Load afterwards to apply the stored settings
+func (s *SettingsService) OnChange(listener SettingsListener) {
+	s.mu.Lock()
+	s.listeners = append(s.listeners, listener)
+	s.mu.Unlock()
+}
+
+// Load reads the stored overrides and applies the effective settings
+func (s *SettingsService) Load() error {
+	var overrides models.SettingsUpdate
+	if _, err := s.store.GetJSON(settingsKey, &overrides); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.overrides = overrides
+	s.mu.Unlock()
+
+	s.apply()
+	return nil
+}
+
+// Get returns the effective settings
+func (s *SettingsService) Get() *models.SettingsResponse {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	settings := mergeSettings(s.defaults, &s.overrides)
+	settings.NotifyChannels = redactChannels(settings.NotifyChannels)
+	return &models.SettingsResponse{
+		Settings:         settings,
+		WebhookSecretSet: settings.NotifyWebhookSecret != "",
+		Overridden:       overriddenFields(&s.overrides),
+	}
+}
+
+// Check reports whether Update would accept the given values, without
+// applying them
+func (s *SettingsService) Check(update *models.SettingsUpdate) error {
+	return checkSettings(update)
+}
+
+// Update merges the given values into the stored overrides and applies them
+func (s *SettingsService) Update(update *models.SettingsUpdate) (*models.SettingsResponse, error) {
+	s.mu.Lock()
+	if update.NotifyChannels != nil {
+		current := mergeSettings(s.defaults, &s.overrides).NotifyChannels
+		update.NotifyChannels = keepChannelSecrets(*update.NotifyChannels, current)
+	}
+	if err := checkSettings(update); err != nil {
+		s.mu.Unlock()
+		return nil, err
+	}
+
+	overrides := s.overrides
+	mergeOverrides(&overrides, update)
+	if err := s.store.SetJSON(settingsKey, overrides, 0); err != nil {
+		s.mu.Unlock()
+		return nil, err
+	}
+	s.overrides = overrides
+	s.mu.Unlock()
+
+	s.apply()
+	s.events.Publish(EventSettingsChanged, nil, nil)
+	return s.Get(), nil
+}
+
+// Reset drops every override so the environment defaults apply again
+func (s *SettingsService) Reset() (*models.SettingsResponse, error) {
+	s.mu.Lock()
+	if err := s.store.DeleteKey(settingsKey); err != nil {
+		s.mu.Unlock()
+		return nil, err
+	}
+	s.overrides = models.SettingsUpdate{}
+	s.mu.Unlock()
+
+	s.apply()
+	s.events.Publish(EventSettingsChanged, nil, nil)
+	return s.Get(), nil
+}
+
+// apply pushes the effective settings to every listener
+func (s *SettingsService) apply() {
+	s.mu.RLock()
+	settings := mergeSettings(s.defaults, &s.overrides)
+	listeners := s.listeners
+	s.mu.RUnlock()
+
+	for _, listener := range listeners {
+		listener(settings)
+	}
+}
+
+// redactChannels returns a copy of channels with their secrets replaced by
+// SecretSet
+func redactChannels(channels []models.NotificationChannel) []models.NotificationChannel {
+	redacted := make([]models.NotificationChannel, len(channels))
+	for i, channel := range channels {
+		channel.SecretSet = channel.Secret != ""
+		channel.Secret = ""
+		redacted[i] = channel
+	}
+	return redacted
+}
+
+// keepChannelSecrets fills in the secret of every submitted channel that
+// reports SecretSet without a secret from the current channel of the same
+// name, so settings read from the API can be saved back unchanged
+func keepChannelSecrets(channels, current []models.NotificationChannel) *[]models.NotificationChannel {
+	secrets := make(map[string]string, len(current))
+	for _, channel := range current {
+		secrets[channel.Name] = channel.Secret
+	}
+
+	kept := make([]models.NotificationChannel, len(channels))
+	for i, channel := range channels {
+		if channel.Secret == "" && channel.SecretSet {
+			channel.Secret = secrets[channel.Name]
+		}
+		channel.SecretSet = false
+		kept[i] = channel
+	}
+	return &kept
+}
+
+// checkSettings validates values the struct tags cannot express
+func checkSettings(update *models.SettingsUpdate) error {
+	if update.NotifyWebhookURL != nil && *update.NotifyWebhookURL != "" {
+		u, err := url.Parse(*update.NotifyWebhookURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return &SettingsError{Field: "notify_webhook_url", Reason: "must be an http(s) URL"}
+		}
+	}
+	if update.NotifyQuietHours != nil {
+		if _, err := ParseQuietHours(*update.NotifyQuietHours); err != nil {
+			return &SettingsError{Field: "notify_quiet_hours", Reason: "expected HH:MM-HH:MM"}
+		}
+	}
+	if update.ComputedFields != nil {
+		if _, err := ParseComputedFields(*update.ComputedFields); err != nil {
+			return &SettingsError{Field: "computed_fields", Reason: err.Error()}
+		}
+	}
+	if update.NotifyChannels != nil {
+		if index, err := checkNotificationChannels(*update.NotifyChannels); err != nil {
+			return &SettingsError{Field: fmt.Sprintf("notify_channels[%d]", index), Reason: err.Error()}
+		}
+	}
+	return nil
+}
+
+// mergeOverrides copies every value set in update into overrides
+func mergeOverrides(overrides, update *models.SettingsUpdate) {
+	if update.CacheTTLSeconds != nil {
+		overrides.CacheTTLSeconds = update.CacheTTLSeconds
+	}
+	if update.AlertUsageThreshold != nil {
+		overrides.AlertUsageThreshold = update.AlertUsageThreshold
+	}
+	if update.AutoDisableFailures != nil {
+		overrides.AutoDisableFailures = update.AutoDisableFailures
+	}
+	if update.MaskPrefixChars != nil {
+		overrides.MaskPrefixChars = update.MaskPrefixChars
+	}
+	if update.MaskSuffixChars != nil {
+		overrides.MaskSuffixChars = update.MaskSuffixChars
+	}
+	if update.NotifyWebhookURL != nil {
+		overrides.NotifyWebhookURL = update.NotifyWebhookURL
+	}
+	if update.NotifyWebhookSecret != nil {
+		overrides.NotifyWebhookSecret = update.NotifyWebhookSecret
+	}
+	if update.NotifyQuietHours != nil {
+		overrides.NotifyQuietHours = update.NotifyQuietHours
+	}
+	if update.ComputedFields != nil {
+		overrides.ComputedFields = update.ComputedFields
+	}
+	if update.NotifyChannels != nil {
+		overrides.NotifyChannels = update.NotifyChannels
+	}
+}
+
+// mergeSettings returns the defaults with the overrides applied
+func mergeSettings(defaults models.Settings, overrides *models.SettingsUpdate) models.Settings {
+	settings := defaults
+	if overrides.CacheTTLSeconds != nil {
+		settings.CacheTTLSeconds = *overrides.CacheTTLSeconds
+	}
+	if overrides.AlertUsageThreshold != nil {
+		settings.AlertUsageThreshold = *overrides.AlertUsageThreshold
+	}
+	if overrides.AutoDisableFailures != nil {
+		settings.AutoDisableFailures = *overrides.AutoDisableFailures
+	}
+	if overrides.MaskPrefixChars != nil {
+		settings.MaskPrefixChars = *overrides.MaskPrefixChars
+	}
+	if overrides.MaskSuffixChars != nil {
+		settings.MaskSuffixChars = *overrides.MaskSuffixChars
+	}
+	if overrides.NotifyWebhookURL != nil {
+		settings.NotifyWebhookURL = *overrides.NotifyWebhookURL
+	}
+	if overrides.NotifyWebhookSecret != nil {
+		settings.NotifyWebhookSecret = *overrides.NotifyWebhookSecret
+	}
+	if overrides.NotifyQuietHours != nil {
+		settings.NotifyQuietHours = *overrides.NotifyQuietHours
+	}
+	if overrides.ComputedFields != nil {
+		settings.ComputedFields = *overrides.ComputedFields
+	}
+	if overrides.NotifyChannels != nil {
+		settings.NotifyChannels = *overrides.NotifyChannels
+	}
+	return settings
+}
+
+// overriddenFields lists the JSON names of the overridden settings
+func overriddenFields(overrides *models.SettingsUpdate) []string {
+	fields := []string{}
+	if overrides.CacheTTLSeconds != nil {
+		fields = append(fields, "cache_ttl_seconds")
+	}
+	if overrides.AlertUsageThreshold != nil {
+		fields = append(fields, "alert_usage_threshold")
+	}
+	if overrides.AutoDisableFailures != nil {
+		fields = append(fields, "auto_disable_failures")
+	}
+	if overrides.MaskPrefixChars != nil {
+		fields = append(fields, "mask_prefix_chars")
+	}
+	if overrides.MaskSuffixChars != nil {
+		fields = append(fields, "mask_suffix_chars")
+	}
+	if overrides.NotifyWebhookURL != nil {
+		fields = append(fields, "notify_webhook_url")
+	}
+	if overrides.NotifyWebhookSecret != nil {
+		fields = append(fields, "notify_webhook_secret")
+	}
+	if overrides.NotifyQuietHours != nil {
+		fields = append(fields, "notify_quiet_hours")
+	}
+	if overrides.ComputedFields != nil {
+		fields = append(fields, "computed_fields")
+	}
+	if overrides.NotifyChannels != nil {
+		fields = append(fields, "notify_channels")
+	}
+	return fields
+}
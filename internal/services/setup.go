@@ -0,0 +1,141 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/droid-keyusage-go/internal/models"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrSetupComplete is returned when the first-run setup is attempted again,
+// or when the admin password is set by ADMIN_PASSWORD
+var ErrSetupComplete = errors.New("setup already complete")
+
+// setupKey holds the outcome of the first-run setup
+const setupKey = "setup"
+
+// setupState is written once by the first-run setup of a fresh install
+type setupState struct {
+	AdminPasswordHash string    `json:"admin_password_hash"`
+	JWTSecret         string    `json:"jwt_secret"`
+	CompletedAt       time.Time `json:"completed_at"`
+}
+
+// ConfigureSetup loads the outcome of the first-run setup. Without
+// ADMIN_PASSWORD the admin password comes from the setup, and until it has
+// been run only the setup endpoints are available; authDisabled instead
+// keeps the service open without any login.
+func (s *AuthService) ConfigureSetup(authDisabled bool) {
+	s.authDisabled = authDisabled
+	if s.currentAdminPassword() == "" && s.setupState() == nil && !authDisabled {
+		fmt.Fprintln(console, "⚠️  未设置 ADMIN_PASSWORD，请调用 POST /api/setup 完成初始化")
+	}
+}
+
+// setupState returns the stored setup, or nil while it has not been run.
+// Until it is found the store is read on every call, so a setup completed
+// on another replica takes effect here too.
+func (s *AuthService) setupState() *setupState {
+	s.setupMu.RLock()
+	state := s.setup
+	s.setupMu.RUnlock()
+	if state != nil || s.currentAdminPassword() != "" {
+		return state
+	}
+
+	var stored setupState
+	found, err := s.store.GetJSON(setupKey, &stored)
+	if err != nil || !found {
+		return nil
+	}
+	s.applySetup(&stored)
+	return &stored
+}
+
+// applySetup makes a completed setup take effect; its JWT secret only
+// replaces a generated one, never JWT_SECRET
+func (s *AuthService) applySetup(state *setupState) {
+	s.setupMu.Lock()
+	defer s.setupMu.Unlock()
+
+	s.setup = state
+	if s.tokens.Secret == "" && state.JWTSecret != "" {
+		s.jwtSecret = []byte(state.JWTSecret)
+	}
+}
+
+// signingKey returns the secret tokens are signed with
+func (s *AuthService) signingKey() []byte {
+	s.setupMu.RLock()
+	defer s.setupMu.RUnlock()
+	return s.jwtSecret
+}
+
+// SetupRequired reports whether the first-run setup still has to be run
+// before anyone can log in
+func (s *AuthService) SetupRequired() bool {
+	return s.currentAdminPassword() == "" && !s.authDisabled && s.setupState() == nil
+}
+
+// SetupStatus describes whether the first-run setup has been run
+func (s *AuthService) SetupStatus() *models.SetupStatus {
+	status := &models.SetupStatus{Required: s.SetupRequired()}
+	switch {
+	case s.currentAdminPassword() != "":
+		status.PasswordSource = "env"
+	case s.authDisabled:
+		status.PasswordSource = "disabled"
+	default:
+		if state := s.setupState(); state != nil {
+			status.PasswordSource = "setup"
+			status.CompletedAt = &state.CompletedAt
+		}
+	}
+	return status
+}
+
+// CompleteSetup stores the admin password (hashed) and a generated JWT
+// secret; it fails with ErrSetupComplete unless the setup is still required,
+// including when another request completed it first
+func (s *AuthService) CompleteSetup(password string) error {
+	if !s.SetupRequired() {
+		return ErrSetupComplete
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return err
+	}
+
+	state := &setupState{
+		AdminPasswordHash: string(hash),
+		JWTSecret:         hex.EncodeToString(secret),
+		CompletedAt:       time.Now(),
+	}
+	stored, err := s.store.SetJSONNX(setupKey, state, 0)
+	if err != nil {
+		return err
+	}
+	if !stored {
+		return ErrSetupComplete
+	}
+	s.applySetup(state)
+	return nil
+}
+
+// checkSetupPassword checks a password against the one set by the setup
+func (s *AuthService) checkSetupPassword(password string) bool {
+	state := s.setupState()
+	if state == nil {
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(state.AdminPasswordHash), []byte(password)) == nil
+}
@@ -0,0 +1,142 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/droid-keyusage-go/internal/models"
+	"github.com/droid-keyusage-go/internal/storage"
+)
+
+// maxSlowKeys bounds how many slow keys are remembered; the ones not seen
+// slow for the longest are forgotten first
+const maxSlowKeys = 500
+
+// slowKey is what is remembered about a key whose fetches were slow
+type slowKey struct {
+	provider    string
+	slowCount   int64
+	timeouts    int64
+	maxLatency  time.Duration
+	lastLatency time.Duration
+	lastSeen    time.Time
+}
+
+// SlowTaskTracker remembers the keys whose fetches took longer than a
+// threshold or ran into the task deadline, so keys that are consistently
+// slow upstream can be found
+type SlowTaskTracker struct {
+	mu        sync.Mutex
+	threshold time.Duration
+	keys      map[string]*slowKey
+}
+
+// NewSlowTaskTracker creates a tracker for fetches slower than threshold;
+// threshold <= 0 only tracks fetches that ran into the task deadline
+func NewSlowTaskTracker(threshold time.Duration) *SlowTaskTracker {
+	return &SlowTaskTracker{
+		threshold: threshold,
+		keys:      make(map[string]*slowKey),
+	}
+}
+
+// Record notes a fetch of key that took latency; fast fetches are ignored
+func (t *SlowTaskTracker) Record(key *storage.APIKey, latency time.Duration, timedOut bool) {
+	if !timedOut && (t.threshold <= 0 || latency < t.threshold) {
+		return
+	}
+	if timedOut {
+		fmt.Fprintf(console, "🐢 Key %s 查询超过任务期限 (%s, %v)\n", key.ID, providerName(key), latency.Round(time.Millisecond))
+	} else {
+		fmt.Fprintf(console, "🐢 Key %s 查询较慢 (%s, %v)\n", key.ID, providerName(key), latency.Round(time.Millisecond))
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	k, ok := t.keys[key.ID]
+	if !ok {
+		if len(t.keys) >= maxSlowKeys {
+			t.evictOldest()
+		}
+		k = &slowKey{}
+		t.keys[key.ID] = k
+	}
+	k.provider = providerName(key)
+	k.slowCount++
+	if timedOut {
+		k.timeouts++
+	}
+	if latency > k.maxLatency {
+		k.maxLatency = latency
+	}
+	k.lastLatency = latency
+	k.lastSeen = time.Now()
+}
+
+// evictOldest forgets the key not seen slow for the longest; t.mu is held
+func (t *SlowTaskTracker) evictOldest() {
+	oldestID := ""
+	var oldest time.Time
+	for id, k := range t.keys {
+		if oldestID == "" || k.lastSeen.Before(oldest) {
+			oldestID, oldest = id, k.lastSeen
+		}
+	}
+	delete(t.keys, oldestID)
+}
+
+// Slowest returns the tracked keys accepted by include, slowest first
+func (t *SlowTaskTracker) Slowest(include func(id string) bool) []*models.SlowKey {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	slow := make([]*models.SlowKey, 0)
+	for id, k := range t.keys {
+		if !include(id) {
+			continue
+		}
+		slow = append(slow, &models.SlowKey{
+			ID:            id,
+			Provider:      k.provider,
+			SlowCount:     k.slowCount,
+			Timeouts:      k.timeouts,
+			MaxLatencyMs:  k.maxLatency.Milliseconds(),
+			LastLatencyMs: k.lastLatency.Milliseconds(),
+			LastSeen:      k.lastSeen,
+		})
+	}
+	sort.Slice(slow, func(i, j int) bool {
+		if slow[i].MaxLatencyMs != slow[j].MaxLatencyMs {
+			return slow[i].MaxLatencyMs > slow[j].MaxLatencyMs
+		}
+		return slow[i].ID < slow[j].ID
+	})
+	return slow
+}
+
+// SlowKeys returns the keys p may see whose fetches were slow, slowest
+// first. The worker pool is shared by all tenants, so keys of other
+// tenants are left out.
+func (s *APIKeyService) SlowKeys(ctx context.Context, limit int, p Principal) ([]*models.SlowKey, error) {
+	keys, err := s.store.WithContext(ctx).GetAllAPIKeys()
+	if err != nil {
+		return nil, err
+	}
+	visible := make(map[string]*storage.APIKey, len(keys))
+	for _, key := range s.visibleKeys(keys, p) {
+		visible[key.ID] = key
+	}
+
+	slow := s.workerPool.SlowTasks().Slowest(func(id string) bool { return visible[id] != nil })
+	if limit > 0 && len(slow) > limit {
+		slow = slow[:limit]
+	}
+	for _, k := range slow {
+		k.Name = visible[k.ID].Name
+	}
+	return slow, nil
+}
@@ -0,0 +1,372 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/droid-keyusage-go/internal/models"
+	"github.com/droid-keyusage-go/internal/storage"
+)
+
+const (
+	// statsKey is where the statistics computed after each refresh are stored
+	statsKey = "stats:latest"
+
+	// DefaultProvider is the upstream used for keys without an explicit provider
+	DefaultProvider = "factory"
+
+	// UngroupedName is the group summary name for keys without a group
+	UngroupedName = "(ungrouped)"
+)
+
+// Key statuses reported in statistics
+const (
+	KeyStatusActive    = "active"
+	KeyStatusExhausted = "exhausted"
+	KeyStatusError     = "error"
+	KeyStatusExpired   = "expired"
+	KeyStatusDisabled  = "disabled"
+)
+
+// GetStats returns the statistics computed after the last refresh,
+// computing them on demand if no refresh has happened yet; principals
+// limited to their own keys get statistics over those keys only
+func (s *APIKeyService) GetStats(ctx context.Context, p Principal) (*models.Stats, error) {
+	store := s.store.WithContext(ctx)
+	if s.restricted(p) {
+		data, err := s.GetAggregatedData(ctx, p)
+		if err != nil {
+			return nil, err
+		}
+		keys, err := store.GetAllAPIKeys()
+		if err != nil {
+			return nil, err
+		}
+		return computeStats(s.visibleKeys(keys, p), data.Data, time.Now()), nil
+	}
+
+	var stats models.Stats
+	found, err := store.GetJSON(statsKey, &stats)
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		return &stats, nil
+	}
+
+	if _, err := s.GetAggregatedData(ctx, adminPrincipal); err != nil {
+		return nil, err
+	}
+	if _, err := store.GetJSON(statsKey, &stats); err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// UpstreamStats returns rolling latency and success rate per provider as
+// seen by this replica
+func (s *APIKeyService) UpstreamStats() map[string]*models.UpstreamStats {
+	return s.workerPool.UpstreamStats()
+}
+
+// PingStorage checks that Redis is reachable
+func (s *APIKeyService) PingStorage() error {
+	return s.store.Ping()
+}
+
+// saveStats computes statistics for the given results and stores them
+func (s *APIKeyService) saveStats(keys []*storage.APIKey, results []*models.Usage) {
+	stats := computeStats(keys, results, time.Now())
+	if err := s.store.SetJSON(statsKey, stats, 0); err != nil {
+		fmt.Fprintf(console, "⚠️  保存统计数据失败: %v\n", err)
+	}
+}
+
+// computeStats aggregates usage results into counts, totals and distributions
+func computeStats(keys []*storage.APIKey, results []*models.Usage, now time.Time) *models.Stats {
+	keyMap := make(map[string]*storage.APIKey, len(keys))
+	for _, key := range keys {
+		keyMap[key.ID] = key
+	}
+
+	stats := &models.Stats{
+		GeneratedAt:      now,
+		TotalKeys:        len(keys),
+		ByStatus:         make(map[string]int),
+		ByGroup:          make(map[string]*models.StatsTotals),
+		ByTag:            make(map[string]*models.StatsTotals),
+		ByProvider:       make(map[string]*models.StatsTotals),
+		UsedRatioBuckets: make([]models.RatioBucket, 11),
+	}
+
+	for i := 0; i < 10; i++ {
+		stats.UsedRatioBuckets[i].Range = fmt.Sprintf("%d-%d%%", i*10, (i+1)*10)
+	}
+	stats.UsedRatioBuckets[10].Range = ">=100%"
+
+	var latencyTotal int64
+	var latencyCount int
+
+	for _, usage := range results {
+		key := keyMap[usage.ID]
+		status := usageStatus(key, usage, now)
+		stats.ByStatus[status]++
+
+		if usage.LatencyMs > 0 {
+			latencyTotal += usage.LatencyMs
+			latencyCount++
+		}
+
+		if status == KeyStatusError || status == KeyStatusExpired || status == KeyStatusDisabled {
+			continue
+		}
+
+		if status == KeyStatusExhausted {
+			stats.ExhaustedThisPeriod++
+		}
+
+		bucket := int(usage.UsedRatio * 10)
+		if bucket < 0 {
+			bucket = 0
+		}
+		if bucket > 10 {
+			bucket = 10
+		}
+		stats.UsedRatioBuckets[bucket].Count++
+
+		addToTotals(stats.ByProvider, providerName(key), usage)
+		if key == nil {
+			continue
+		}
+		if key.Group != "" {
+			addToTotals(stats.ByGroup, key.Group, usage)
+		}
+		for _, tag := range key.Tags {
+			addToTotals(stats.ByTag, tag, usage)
+		}
+	}
+
+	if latencyCount > 0 {
+		stats.AvgRefreshLatencyMs = float64(latencyTotal) / float64(latencyCount)
+	}
+
+	return stats
+}
+
+// computeTotals sums usage over successfully fetched keys, counts keys by
+// status and marks each result that is left out of the healthy totals
+func computeTotals(keys []*storage.APIKey, results []*models.Usage, now time.Time) models.Totals {
+	keyMap := make(map[string]*storage.APIKey, len(keys))
+	for _, key := range keys {
+		keyMap[key.ID] = key
+	}
+
+	totals := newTotalsBuilder(now)
+	for _, usage := range results {
+		totals.add(keyMap[usage.ID], usage)
+	}
+	return totals.totals
+}
+
+// totalsBuilder computes the totals one result at a time, so they can be
+// summed without holding every result
+type totalsBuilder struct {
+	totals models.Totals
+	orgs   map[string]bool
+	now    time.Time
+}
+
+func newTotalsBuilder(now time.Time) *totalsBuilder {
+	return &totalsBuilder{orgs: make(map[string]bool), now: now}
+}
+
+// add counts the result of key and marks it when it is left out of the
+// healthy totals
+func (b *totalsBuilder) add(key *storage.APIKey, usage *models.Usage) {
+	totals := &b.totals
+	status := usageStatus(key, usage, b.now)
+	usage.ExcludedReason = ""
+	if status != KeyStatusActive {
+		usage.ExcludedReason = status
+	}
+	usage.OrgID = ""
+
+	switch status {
+	case KeyStatusError:
+		totals.ErrorCount++
+		return
+	case KeyStatusDisabled:
+		totals.DisabledCount++
+		return
+	case KeyStatusExpired:
+		totals.ExpiredCount++
+		return
+	}
+
+	totals.SuccessCount++
+	totals.TotalOrgTotalTokensUsed += usage.OrgTotalUsed
+	totals.TotalAllowance += usage.TotalAllowance
+
+	usage.OrgID = orgID(key, usage)
+	if !b.orgs[usage.OrgID] {
+		b.orgs[usage.OrgID] = true
+		totals.OrgCount++
+		totals.OrgAllowance += usage.TotalAllowance
+		totals.OrgUsed += usage.OrgTotalUsed
+		totals.OrgRemaining += usage.Remaining
+	}
+
+	if status == KeyStatusExhausted {
+		totals.ExhaustedCount++
+		return
+	}
+	totals.HealthyAllowance += usage.TotalAllowance
+	totals.HealthyUsed += usage.OrgTotalUsed
+	totals.HealthyRemaining += usage.Remaining
+}
+
+// computeGroups summarizes results per key group; keys without a group are
+// listed under UngroupedName. It returns nil when no key has a group.
+func computeGroups(keys []*storage.APIKey, results []*models.Usage, now time.Time) []*models.GroupSummary {
+	keyMap := make(map[string]*storage.APIKey, len(keys))
+	for _, key := range keys {
+		keyMap[key.ID] = key
+	}
+
+	groups := newGroupsBuilder(now)
+	for _, usage := range results {
+		groups.add(keyMap[usage.ID], usage)
+	}
+	return groups.result()
+}
+
+// groupsBuilder computes the group summaries one result at a time
+type groupsBuilder struct {
+	summaries map[string]*models.GroupSummary
+	grouped   bool
+	now       time.Time
+}
+
+func newGroupsBuilder(now time.Time) *groupsBuilder {
+	return &groupsBuilder{summaries: make(map[string]*models.GroupSummary), now: now}
+}
+
+// add counts the result of key in the summary of its group
+func (b *groupsBuilder) add(key *storage.APIKey, usage *models.Usage) {
+	name := UngroupedName
+	if key != nil && key.Group != "" {
+		name = key.Group
+		b.grouped = true
+	}
+
+	summary, ok := b.summaries[name]
+	if !ok {
+		summary = &models.GroupSummary{Name: name}
+		b.summaries[name] = summary
+	}
+	summary.KeyCount++
+
+	status := usageStatus(key, usage, b.now)
+	if status != KeyStatusActive && status != KeyStatusExhausted {
+		return
+	}
+	summary.SuccessCount++
+	summary.TotalAllowance += usage.TotalAllowance
+	summary.TotalUsed += usage.OrgTotalUsed
+	summary.Remaining += usage.Remaining
+}
+
+// result returns the summaries sorted by group name, or nil when no key has
+// a group
+func (b *groupsBuilder) result() []*models.GroupSummary {
+	if !b.grouped {
+		return nil
+	}
+	groups := make([]*models.GroupSummary, 0, len(b.summaries))
+	for _, summary := range b.summaries {
+		groups = append(groups, summary)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Name < groups[j].Name
+	})
+	return groups
+}
+
+// usageStatus classifies a key from its latest usage result
+func usageStatus(key *storage.APIKey, usage *models.Usage, now time.Time) string {
+	switch {
+	case usage.Disabled || (key != nil && key.Disabled):
+		return KeyStatusDisabled
+	case key != nil && key.IsExpired(now):
+		return KeyStatusExpired
+	case usage.Error != "":
+		return KeyStatusError
+	case usage.TotalAllowance > 0 && usage.Remaining <= 0:
+		return KeyStatusExhausted
+	default:
+		return KeyStatusActive
+	}
+}
+
+// Machine-readable codes for per-key usage errors
+const (
+	UsageErrKeyDisabled       = "KEY_DISABLED"
+	UsageErrKeyExpired        = "KEY_EXPIRED"
+	UsageErrQueueFull         = "QUEUE_FULL"
+	UsageErrProcessingTimeout = "PROCESSING_TIMEOUT"
+	UsageErrUnauthorized      = "UPSTREAM_UNAUTHORIZED"
+	UsageErrRateLimited       = "UPSTREAM_RATE_LIMITED"
+	UsageErrUpstream5xx       = "UPSTREAM_5XX"
+	UsageErrUpstreamStatus    = "UPSTREAM_HTTP_ERROR"
+	UsageErrUpstreamTimeout   = "UPSTREAM_TIMEOUT"
+	UsageErrUpstreamFailed    = "UPSTREAM_UNAVAILABLE"
+	UsageErrParse             = "UPSTREAM_PARSE_ERROR"
+	UsageErrFetchFailed       = "FETCH_FAILED"
+)
+
+// usageErrorCode classifies a usage result: the code it was fetched with,
+// else one derived from its error message
+func usageErrorCode(usage *models.Usage) string {
+	switch {
+	case usage.Error == "":
+		return ""
+	case usage.ErrorCode != "":
+		return usage.ErrorCode
+	case usage.Disabled:
+		return UsageErrKeyDisabled
+	case usage.Error == "Key expired":
+		return UsageErrKeyExpired
+	case usage.Error == errQueueFull:
+		return UsageErrQueueFull
+	case usage.Error == errProcessingTimeout:
+		return UsageErrProcessingTimeout
+	case strings.HasPrefix(usage.Error, "HTTP "):
+		status, err := strconv.Atoi(strings.TrimPrefix(usage.Error, "HTTP "))
+		if err != nil {
+			return UsageErrUpstreamStatus
+		}
+		return statusErrorCode(status)
+	case strings.HasPrefix(usage.Error, "API request timed out"):
+		return UsageErrUpstreamTimeout
+	case strings.HasPrefix(usage.Error, "API request failed"):
+		return UsageErrUpstreamFailed
+	default:
+		return UsageErrFetchFailed
+	}
+}
+
+func addToTotals(totals map[string]*models.StatsTotals, name string, usage *models.Usage) {
+	t, ok := totals[name]
+	if !ok {
+		t = &models.StatsTotals{}
+		totals[name] = t
+	}
+	t.Keys++
+	t.TotalAllowance += usage.TotalAllowance
+	t.TotalUsed += usage.OrgTotalUsed
+	t.Remaining += usage.Remaining
+}
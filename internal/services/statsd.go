@@ -0,0 +1,155 @@
+package services
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// StatsdEmitter pushes metrics to a StatsD agent over UDP; tags use the
+// DogStatsD syntax and are ignored by plain StatsD. A nil emitter drops
+// every metric, so callers need not check whether metrics are configured.
+type StatsdEmitter struct {
+	conn   net.Conn
+	prefix string
+	tags   []string
+}
+
+// NewStatsdEmitter creates an emitter sending to host:port; it returns nil
+// when host is empty. tags is a comma-separated list such as "env:prod,team:ai".
+func NewStatsdEmitter(host string, port int, prefix, tags string) (*StatsdEmitter, error) {
+	if host == "" {
+		return nil, nil
+	}
+	conn, err := net.Dial("udp", net.JoinHostPort(host, strconv.Itoa(port)))
+	if err != nil {
+		return nil, err
+	}
+
+	e := &StatsdEmitter{conn: conn, prefix: prefix}
+	for _, tag := range strings.Split(tags, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			e.tags = append(e.tags, tag)
+		}
+	}
+	return e, nil
+}
+
+// WithTags returns an emitter sharing the connection that adds tags to
+// every metric
+func (e *StatsdEmitter) WithTags(tags ...string) *StatsdEmitter {
+	if e == nil {
+		return nil
+	}
+	merged := make([]string, 0, len(e.tags)+len(tags))
+	merged = append(merged, e.tags...)
+	merged = append(merged, tags...)
+	return &StatsdEmitter{conn: e.conn, prefix: e.prefix, tags: merged}
+}
+
+// Count adds n to a counter
+func (e *StatsdEmitter) Count(name string, n int64, tags ...string) {
+	e.send(name, strconv.FormatInt(n, 10), "c", tags)
+}
+
+// Gauge sets a gauge
+func (e *StatsdEmitter) Gauge(name string, value float64, tags ...string) {
+	e.send(name, strconv.FormatFloat(value, 'f', -1, 64), "g", tags)
+}
+
+// Timing records a duration in milliseconds
+func (e *StatsdEmitter) Timing(name string, d time.Duration, tags ...string) {
+	e.send(name, strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64), "ms", tags)
+}
+
+// Close closes the connection
+func (e *StatsdEmitter) Close() error {
+	if e == nil {
+		return nil
+	}
+	return e.conn.Close()
+}
+
+// send writes one metric; UDP is fire-and-forget, so errors are dropped
+func (e *StatsdEmitter) send(name, value, kind string, tags []string) {
+	if e == nil {
+		return
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s%s:%s|%s", e.prefix, name, value, kind)
+	if len(e.tags)+len(tags) > 0 {
+		b.WriteString("|#")
+		b.WriteString(strings.Join(append(append([]string{}, e.tags...), tags...), ","))
+	}
+	_, _ = e.conn.Write([]byte(b.String()))
+}
+
+// QueueMetrics periodically reports the depth of the shared worker queue
+// and the upstream statistics per provider
+type QueueMetrics struct {
+	metrics  *StatsdEmitter
+	pool     *WorkerPool
+	interval time.Duration
+	shutdown chan struct{}
+	wg       sync.WaitGroup
+}
+
+// NewQueueMetrics creates the queue reporter; it does nothing when metrics
+// is nil or interval <= 0
+func NewQueueMetrics(metrics *StatsdEmitter, pool *WorkerPool, interval time.Duration) *QueueMetrics {
+	return &QueueMetrics{
+		metrics:  metrics,
+		pool:     pool,
+		interval: interval,
+		shutdown: make(chan struct{}),
+	}
+}
+
+// Start launches the background reporter
+func (q *QueueMetrics) Start() {
+	if q.metrics == nil || q.interval <= 0 {
+		return
+	}
+
+	q.wg.Add(1)
+	go func() {
+		defer q.wg.Done()
+
+		ticker := time.NewTicker(q.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				q.metrics.Gauge("queue.depth", float64(len(q.pool.taskQueue)))
+				q.metrics.Gauge("queue.results", float64(len(q.pool.resultQueue)))
+				q.metrics.Gauge("queue.in_flight", float64(q.pool.inFlight()))
+				q.metrics.Gauge("workers.active", float64(atomic.LoadInt32(&q.pool.activeWorkers)))
+				for provider, stats := range q.pool.UpstreamStats() {
+					tag := "provider:" + provider
+					q.metrics.Gauge("upstream.latency.p50", float64(stats.P50LatencyMs), tag)
+					q.metrics.Gauge("upstream.latency.p95", float64(stats.P95LatencyMs), tag)
+					q.metrics.Gauge("upstream.success_rate", stats.SuccessRate, tag)
+					if conns := stats.Connections; conns != nil {
+						q.metrics.Gauge("upstream.connections.new", float64(conns.New), tag)
+						q.metrics.Gauge("upstream.connections.reused", float64(conns.Reused), tag)
+						q.metrics.Gauge("upstream.connections.reuse_ratio", conns.ReuseRatio, tag)
+						q.metrics.Gauge("upstream.requests.http2", float64(conns.HTTP2), tag)
+					}
+				}
+			case <-q.shutdown:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the background reporter
+func (q *QueueMetrics) Stop() {
+	close(q.shutdown)
+	q.wg.Wait()
+}
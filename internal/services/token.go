@@ -0,0 +1,276 @@
+package services
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/droid-keyusage-go/internal/models"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// ErrInvalidToken is returned for tokens that are malformed, expired,
+// revoked, of the wrong type or issued by another tenant
+var ErrInvalidToken = errors.New("invalid token")
+
+// ErrDenylistUnavailable is returned when a token cannot be checked against
+// the denylist, e.g. while Redis is unavailable; such tokens are refused
+var ErrDenylistUnavailable = errors.New("token denylist unavailable")
+
+// ErrInvalidScope is returned when a token is requested with an unknown scope
+var ErrInvalidScope = errors.New("invalid scope")
+
+// Token scopes; a token limited to some scopes can only call the routes
+// requiring them
+const (
+	ScopeRead     = "read"
+	ScopeWrite    = "write"
+	ScopeFullKeys = "keys:full"
+	ScopeAdmin    = "admin"
+)
+
+// Scopes lists every token scope
+var Scopes = []string{ScopeRead, ScopeWrite, ScopeFullKeys, ScopeAdmin}
+
+// ParseScopes parses a space-separated scope list; an empty list means
+// unrestricted (nil)
+func ParseScopes(spec string) ([]string, error) {
+	fields := strings.Fields(spec)
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	for _, scope := range fields {
+		known := false
+		for _, s := range Scopes {
+			known = known || s == scope
+		}
+		if !known {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidScope, scope)
+		}
+	}
+	return fields, nil
+}
+
+// Token types, carried in the "typ" claim
+const (
+	tokenAccess  = "access"
+	tokenRefresh = "refresh"
+)
+
+// tokenIssuer is the "iss" claim of every token
+const tokenIssuer = "droid-keyusage"
+
+// TokenConfig configures the bearer tokens issued by POST /api/token
+type TokenConfig struct {
+	// Secret signs tokens (HS256); when empty the secret generated by the
+	// first-run setup is used, or else a random one, so tokens do not
+	// survive restarts and are not shared across replicas
+	Secret string
+	// Audience is the tenant the tokens are valid for
+	Audience   string
+	AccessTTL  time.Duration
+	RefreshTTL time.Duration
+}
+
+// tokenClaims are the claims of access and refresh tokens
+type tokenClaims struct {
+	Role  string `json:"role"`
+	User  string `json:"user,omitempty"`
+	Type  string `json:"typ"`
+	Scope string `json:"scope,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// ConfigureTokens sets how bearer tokens are signed and how long they last
+func (s *AuthService) ConfigureTokens(cfg TokenConfig) {
+	if cfg.Secret == "" {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			panic(err)
+		}
+		s.jwtSecret = secret
+		fmt.Fprintln(console, "⚠️  未设置 JWT_SECRET，已生成随机密钥，重启后已签发的 Token 失效")
+	} else {
+		s.jwtSecret = []byte(cfg.Secret)
+	}
+	if cfg.AccessTTL <= 0 {
+		cfg.AccessTTL = 15 * time.Minute
+	}
+	if cfg.RefreshTTL <= 0 {
+		cfg.RefreshTTL = 30 * 24 * time.Hour
+	}
+	s.tokens = cfg
+}
+
+// SetJWTSecret replaces the secret tokens are signed with, e.g. after
+// JWT_SECRET was rotated in the secret store; tokens signed with the old
+// secret stop working, so clients have to request new ones
+func (s *AuthService) SetJWTSecret(secret string) {
+	if secret == "" {
+		return
+	}
+	s.setupMu.Lock()
+	defer s.setupMu.Unlock()
+	s.tokens.Secret = secret
+	s.jwtSecret = []byte(secret)
+}
+
+// IssueTokens creates an access and a refresh token for the principal
+func (s *AuthService) IssueTokens(p Principal) (*models.TokenResponse, error) {
+	access, err := s.signToken(p, tokenAccess, s.tokens.AccessTTL)
+	if err != nil {
+		return nil, err
+	}
+	refresh, err := s.signToken(p, tokenRefresh, s.tokens.RefreshTTL)
+	if err != nil {
+		return nil, err
+	}
+	return &models.TokenResponse{
+		AccessToken:  access,
+		RefreshToken: refresh,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(s.tokens.AccessTTL / time.Second),
+		Scope:        strings.Join(p.Scopes, " "),
+	}, nil
+}
+
+// RefreshTokens exchanges a refresh token for a new token pair. The old
+// refresh token is revoked, so each one can be used once.
+func (s *AuthService) RefreshTokens(refreshToken string) (*models.TokenResponse, error) {
+	claims, err := s.parseToken(refreshToken, tokenRefresh)
+	if err != nil {
+		return nil, err
+	}
+
+	// Named users removed from the configuration lose their tokens, and a
+	// changed role applies from the next refresh
+	p := claims.principal()
+	if p.User != "" {
+		user, ok := s.users[p.User]
+		if !ok {
+			return nil, ErrInvalidToken
+		}
+		p.Role = user.Role
+	}
+
+	if err := s.claimToken(claims); err != nil {
+		return nil, err
+	}
+	return s.IssueTokens(p)
+}
+
+// RevokeToken adds an access or refresh token to the denylist until it
+// expires
+func (s *AuthService) RevokeToken(token string) error {
+	claims, err := s.parseToken(token, "")
+	if err != nil {
+		return err
+	}
+	return s.denyToken(claims)
+}
+
+// ValidateAccessToken checks a bearer token and returns whom it was issued
+// to. While the denylist cannot be read it fails with
+// ErrDenylistUnavailable, so revoked tokens are never accepted.
+func (s *AuthService) ValidateAccessToken(token string) (Principal, error) {
+	claims, err := s.parseToken(token, tokenAccess)
+	if err != nil {
+		return Principal{}, err
+	}
+	return claims.principal(), nil
+}
+
+// principal returns whom the token was issued to
+func (c *tokenClaims) principal() Principal {
+	p := Principal{User: c.User, Role: c.Role}
+	if c.Scope != "" {
+		p.Scopes = strings.Fields(c.Scope)
+	}
+	return p
+}
+
+func (s *AuthService) signToken(p Principal, typ string, ttl time.Duration) (string, error) {
+	subject := p.User
+	if subject == "" {
+		subject = p.Role
+	}
+	now := time.Now()
+	claims := &tokenClaims{
+		Role:  p.Role,
+		User:  p.User,
+		Type:  typ,
+		Scope: strings.Join(p.Scopes, " "),
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.New().String(),
+			Issuer:    tokenIssuer,
+			Subject:   subject,
+			Audience:  jwt.ClaimStrings{s.tokens.Audience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(s.signingKey())
+}
+
+// parseToken verifies a token and, unless typ is empty, its type. When the
+// denylist cannot be read the claims are returned along with the error.
+func (s *AuthService) parseToken(token, typ string) (*tokenClaims, error) {
+	claims := &tokenClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(*jwt.Token) (interface{}, error) {
+		return s.signingKey(), nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithIssuer(tokenIssuer),
+		jwt.WithAudience(s.tokens.Audience),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil || claims.ID == "" || (typ != "" && claims.Type != typ) {
+		return nil, ErrInvalidToken
+	}
+	if !knownRole(claims.Role) {
+		return nil, ErrInvalidToken
+	}
+
+	denied, err := s.store.GetJSON(deniedTokenKey(claims.ID), new(bool))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDenylistUnavailable, err)
+	}
+	if denied {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+// denyToken keeps the token's ID on the denylist until the token expires
+func (s *AuthService) denyToken(claims *tokenClaims) error {
+	ttl := time.Until(claims.ExpiresAt.Time)
+	if ttl <= 0 {
+		return nil
+	}
+	return s.store.SetJSON(deniedTokenKey(claims.ID), true, ttl)
+}
+
+// claimToken puts the token's ID on the denylist in one step that fails
+// when it is there already, so of concurrent refreshes with the same token
+// only one succeeds
+func (s *AuthService) claimToken(claims *tokenClaims) error {
+	ttl := time.Until(claims.ExpiresAt.Time)
+	if ttl <= 0 {
+		return ErrInvalidToken
+	}
+	stored, err := s.store.SetJSONNX(deniedTokenKey(claims.ID), true, ttl)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrDenylistUnavailable, err)
+	}
+	if !stored {
+		return ErrInvalidToken
+	}
+	return nil
+}
+
+func deniedTokenKey(id string) string {
+	return "token:denied:" + id
+}
@@ -0,0 +1,140 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/droid-keyusage-go/internal/storage"
+	"github.com/redis/go-redis/v9"
+)
+
+// newTestStore returns a storage backed by a Redis the test can stop
+func newTestStore(t *testing.T) (*storage.Storage, *miniredis.Miniredis) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	opts := storage.DefaultRedisOptions("redis://" + server.Addr())
+	opts.MinIdleConns = 0
+	opts.MaxRetries = 0
+	client, err := storage.NewRedisClient(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return storage.NewStorage(client), server
+}
+
+// newTokenAuth returns an auth service issuing tokens
+func newTokenAuth(t *testing.T) (*AuthService, *miniredis.Miniredis) {
+	t.Helper()
+	store, server := newTestStore(t)
+	auth, err := NewAuthService(store, "pw", "", "", 0, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth.ConfigureTokens(TokenConfig{Secret: "test-secret", Audience: "default"})
+	return auth, server
+}
+
+func TestValidateAccessTokenRevoked(t *testing.T) {
+	auth, _ := newTokenAuth(t)
+	tokens, err := auth.IssueTokens(Principal{Role: RoleAdmin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := auth.ValidateAccessToken(tokens.AccessToken); err != nil {
+		t.Fatalf("fresh token rejected: %v", err)
+	}
+	if err := auth.RevokeToken(tokens.AccessToken); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := auth.ValidateAccessToken(tokens.AccessToken); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("revoked token: got %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestValidateAccessTokenFailsClosed(t *testing.T) {
+	auth, server := newTokenAuth(t)
+	tokens, err := auth.IssueTokens(Principal{Role: RoleAdmin})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Without Redis a revoked token can't be told from a valid one
+	server.Close()
+	p, err := auth.ValidateAccessToken(tokens.AccessToken)
+	if !errors.Is(err, ErrDenylistUnavailable) {
+		t.Fatalf("got %v, want ErrDenylistUnavailable", err)
+	}
+	if p.Role != "" {
+		t.Fatalf("got principal %+v while the denylist is unavailable", p)
+	}
+}
+
+// slowReads delays every GET, so concurrent callers all read before any
+// of them writes
+type slowReads struct{}
+
+func (slowReads) DialHook(next redis.DialHook) redis.DialHook { return next }
+
+func (slowReads) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		err := next(ctx, cmd)
+		if cmd.Name() == "get" {
+			time.Sleep(20 * time.Millisecond)
+		}
+		return err
+	}
+}
+
+func (slowReads) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return next
+}
+
+func TestRefreshTokensOnce(t *testing.T) {
+	client, err := storage.NewRedisClient(storage.DefaultRedisOptions("redis://" + miniredis.RunT(t).Addr()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	client.GetClient().AddHook(slowReads{})
+	auth, err := NewAuthService(storage.NewStorage(client), "pw", "", "", 0, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth.ConfigureTokens(TokenConfig{Secret: "test-secret", Audience: "default"})
+
+	tokens, err := auth.IssueTokens(Principal{Role: RoleAdmin})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// However many refreshes race, the refresh token is exchanged once
+	const attempts = 20
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	var succeeded atomic.Int32
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			_, err := auth.RefreshTokens(tokens.RefreshToken)
+			switch {
+			case err == nil:
+				succeeded.Add(1)
+			case !errors.Is(err, ErrInvalidToken):
+				t.Errorf("refresh: %v", err)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+	if n := succeeded.Load(); n != 1 {
+		t.Fatalf("%d of %d concurrent refreshes succeeded, want 1", n, attempts)
+	}
+}
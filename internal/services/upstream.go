@@ -0,0 +1,114 @@
+package services
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/droid-keyusage-go/internal/models"
+)
+
+// upstreamMinSamples is the number of fetches needed before a provider can
+// be reported as degraded
+const upstreamMinSamples = 10
+
+// upstreamSample is one fetch from a provider
+type upstreamSample struct {
+	latency time.Duration
+	ok      bool
+}
+
+// upstreamWindow is a ring buffer of the most recent fetches of a provider
+type upstreamWindow struct {
+	samples []upstreamSample
+	next    int
+	full    bool
+}
+
+// UpstreamTracker keeps rolling latency and success rate per provider over
+// the last fetches made by this process
+type UpstreamTracker struct {
+	mu            sync.Mutex
+	size          int
+	degradedBelow float64
+	providers     map[string]*upstreamWindow
+}
+
+// NewUpstreamTracker creates a tracker keeping the last size fetches per
+// provider; a provider is degraded when its success rate drops below
+// degradedBelow
+func NewUpstreamTracker(size int, degradedBelow float64) *UpstreamTracker {
+	if size <= 0 {
+		size = 200
+	}
+	return &UpstreamTracker{
+		size:          size,
+		degradedBelow: degradedBelow,
+		providers:     make(map[string]*upstreamWindow),
+	}
+}
+
+// Record adds a fetch to the provider's window
+func (t *UpstreamTracker) Record(provider string, latency time.Duration, ok bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	w, exists := t.providers[provider]
+	if !exists {
+		w = &upstreamWindow{samples: make([]upstreamSample, t.size)}
+		t.providers[provider] = w
+	}
+	w.samples[w.next] = upstreamSample{latency: latency, ok: ok}
+	w.next = (w.next + 1) % len(w.samples)
+	if w.next == 0 {
+		w.full = true
+	}
+}
+
+// Stats returns the latency percentiles and success rate of every provider
+// fetched so far
+func (t *UpstreamTracker) Stats() map[string]*models.UpstreamStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	stats := make(map[string]*models.UpstreamStats, len(t.providers))
+	for provider, w := range t.providers {
+		samples := w.samples[:w.next]
+		if w.full {
+			samples = w.samples
+		}
+		if len(samples) == 0 {
+			continue
+		}
+
+		latencies := make([]time.Duration, len(samples))
+		succeeded := 0
+		for i, sample := range samples {
+			latencies[i] = sample.latency
+			if sample.ok {
+				succeeded++
+			}
+		}
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+		s := &models.UpstreamStats{
+			Samples:      len(samples),
+			SuccessRate:  float64(succeeded) / float64(len(samples)),
+			P50LatencyMs: percentile(latencies, 0.50).Milliseconds(),
+			P95LatencyMs: percentile(latencies, 0.95).Milliseconds(),
+		}
+		s.Degraded = s.Samples >= upstreamMinSamples && s.SuccessRate < t.degradedBelow
+		stats[provider] = s
+	}
+	return stats
+}
+
+// percentile returns the nearest-rank percentile of sorted values
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return sorted[rank]
+}
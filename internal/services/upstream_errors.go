@@ -0,0 +1,94 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/droid-keyusage-go/internal/models"
+	"github.com/droid-keyusage-go/internal/utils"
+)
+
+// maxErrorDetail bounds the upstream error detail kept with a usage result
+const maxErrorDetail = 200
+
+// statusErrorCode classifies an upstream HTTP status
+func statusErrorCode(status int) string {
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return UsageErrUnauthorized
+	case status == http.StatusTooManyRequests:
+		return UsageErrRateLimited
+	case status >= 500:
+		return UsageErrUpstream5xx
+	default:
+		return UsageErrUpstreamStatus
+	}
+}
+
+// statusUsage is the result of a usage request the provider answered with
+// an error status; body is the response, whose message becomes the detail
+func statusUsage(id string, status int, body []byte) *models.Usage {
+	return &models.Usage{
+		ID:          id,
+		Error:       fmt.Sprintf("HTTP %d", status),
+		ErrorCode:   statusErrorCode(status),
+		ErrorDetail: upstreamErrorDetail(status, body),
+	}
+}
+
+// parseErrorUsage is the result of a usage response that could not be parsed
+func parseErrorUsage(id string, err error) *models.Usage {
+	return &models.Usage{
+		ID:          id,
+		Error:       "Invalid upstream response",
+		ErrorCode:   UsageErrParse,
+		ErrorDetail: truncateDetail(utils.Redact(err.Error())),
+	}
+}
+
+// errorUsage is the result of a fetch that failed with err
+func errorUsage(id string, err error) *models.Usage {
+	usage := &models.Usage{ID: id, Error: err.Error()}
+	if upstreamErr, ok := err.(*UpstreamError); ok {
+		usage.ErrorDetail = upstreamErr.Message
+	}
+	return usage
+}
+
+// upstreamErrorDetail extracts the message of an error response: the
+// "error", "message" or "detail" field of a JSON body, else a plain text
+// body, else the status text
+func upstreamErrorDetail(status int, body []byte) string {
+	var doc map[string]interface{}
+	if json.Unmarshal(body, &doc) == nil {
+		for _, field := range []string{"error", "message", "detail", "error_description"} {
+			switch value := doc[field].(type) {
+			case string:
+				if value != "" {
+					return truncateDetail(utils.Redact(value))
+				}
+			case map[string]interface{}:
+				if message, ok := value["message"].(string); ok && message != "" {
+					return truncateDetail(utils.Redact(message))
+				}
+			}
+		}
+	}
+
+	text := strings.TrimSpace(string(body))
+	if text != "" && utf8.ValidString(text) && !strings.HasPrefix(text, "<") && !strings.HasPrefix(text, "{") {
+		return truncateDetail(utils.Redact(text))
+	}
+	return http.StatusText(status)
+}
+
+// truncateDetail cuts an error detail to maxErrorDetail bytes
+func truncateDetail(detail string) string {
+	if len(detail) <= maxErrorDetail {
+		return detail
+	}
+	return strings.ToValidUTF8(detail[:maxErrorDetail], "") + "…"
+}
@@ -0,0 +1,68 @@
+package services
+
+import (
+	"encoding/json"
+
+	"github.com/droid-keyusage-go/internal/models"
+	"github.com/droid-keyusage-go/internal/storage"
+)
+
+// storage.Usage is what is cached and kept in the history of a key;
+// models.Usage is what the API returns. The conversions below are the only
+// places where one is built from the other, so a field added to both only
+// needs to be mapped here.
+
+// usageToStorage converts fetched usage to its stored form. Fields derived
+// for each response (the masked key, name, expiry, deltas, status, organization
+// and computed fields) are not stored.
+func usageToStorage(usage *models.Usage) *storage.Usage {
+	stored := &storage.Usage{
+		ID:             usage.ID,
+		StartDate:      usage.StartDate,
+		EndDate:        usage.EndDate,
+		TotalAllowance: usage.TotalAllowance,
+		OrgTotalUsed:   usage.OrgTotalUsed,
+		Remaining:      usage.Remaining,
+		UsedRatio:      usage.UsedRatio,
+		LastUpdated:    usage.LastUpdated,
+		LatencyMs:      usage.LatencyMs,
+		Error:          usage.Error,
+		Partial:        usage.Partial,
+		MissingFields:  usage.MissingFields,
+	}
+	if len(usage.Blocks) > 0 {
+		stored.Blocks, _ = json.Marshal(usage.Blocks)
+	}
+	return stored
+}
+
+// usageFromStorage converts stored usage of key to its API form
+func (s *APIKeyService) usageFromStorage(key *storage.APIKey, usage *storage.Usage) *models.Usage {
+	result := &models.Usage{
+		ID:             usage.ID,
+		StartDate:      usage.StartDate,
+		EndDate:        usage.EndDate,
+		TotalAllowance: usage.TotalAllowance,
+		OrgTotalUsed:   usage.OrgTotalUsed,
+		Remaining:      usage.Remaining,
+		UsedRatio:      usage.UsedRatio,
+		LastUpdated:    usage.LastUpdated,
+		LatencyMs:      usage.LatencyMs,
+		Error:          usage.Error,
+		Partial:        usage.Partial,
+		MissingFields:  usage.MissingFields,
+	}
+	if len(usage.Blocks) > 0 {
+		_ = json.Unmarshal(usage.Blocks, &result.Blocks)
+	}
+	s.attachKey(result, key)
+	return result
+}
+
+// attachKey sets the fields of a usage result that come from its key rather
+// than from upstream, so cached and fresh results look the same
+func (s *APIKeyService) attachKey(usage *models.Usage, key *storage.APIKey) {
+	usage.Key = s.maskKey(key.Key)
+	usage.Name = key.Name
+	usage.ExpiresAt = key.ExpiresAt
+}
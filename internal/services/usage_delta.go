@@ -0,0 +1,69 @@
+package services
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/droid-keyusage-go/internal/models"
+	"github.com/droid-keyusage-go/internal/storage"
+)
+
+// attachDeltas fills the delta fields of successful results from the usage
+// history, so clients don't have to diff snapshots themselves
+func (s *APIKeyService) attachDeltas(results []*models.Usage, now time.Time) {
+	hourAgo := make(map[string]time.Time, len(results))
+	dayAgo := make(map[string]time.Time, len(results))
+	lastRefresh := make(map[string]time.Time, len(results))
+	for _, usage := range results {
+		if usage.Error != "" {
+			continue
+		}
+		hourAgo[usage.ID] = now.Add(-time.Hour)
+		dayAgo[usage.ID] = now.Add(-24 * time.Hour)
+		lastRefresh[usage.ID] = usage.LastUpdated
+	}
+	if len(lastRefresh) == 0 {
+		return
+	}
+
+	hourBase, err := s.store.LatestSnapshotsBefore(hourAgo)
+	if err != nil {
+		fmt.Fprintf(console, "⚠️  读取用量历史失败: %v\n", err)
+		return
+	}
+	dayBase, err := s.store.LatestSnapshotsBefore(dayAgo)
+	if err != nil {
+		fmt.Fprintf(console, "⚠️  读取用量历史失败: %v\n", err)
+		return
+	}
+	previous, err := s.store.LatestSnapshotsBefore(lastRefresh)
+	if err != nil {
+		fmt.Fprintf(console, "⚠️  读取用量历史失败: %v\n", err)
+		return
+	}
+
+	for _, usage := range results {
+		if usage.Error != "" {
+			continue
+		}
+		usage.UsedDelta1h = usedDelta(usage, hourBase[usage.ID])
+		usage.UsedDelta24h = usedDelta(usage, dayBase[usage.ID])
+		if prev := previous[usage.ID]; prev != nil {
+			change := usage.Remaining - prev.Remaining
+			usage.RemainingChange = &change
+		}
+	}
+}
+
+// usedDelta returns the usage since the baseline snapshot; when the billing
+// period rolled over in between, all usage of the current period counts
+func usedDelta(usage *models.Usage, base *storage.Usage) *float64 {
+	if base == nil {
+		return nil
+	}
+	delta := usage.OrgTotalUsed - base.OrgTotalUsed
+	if base.StartDate != usage.StartDate {
+		delta = usage.OrgTotalUsed
+	}
+	return &delta
+}
@@ -0,0 +1,60 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+)
+
+// User is a named login; keys created by a user are owned by it
+type User struct {
+	Name     string
+	Role     string
+	Password string
+}
+
+// ParseUsers parses named logins such as "alice:editor:secret;bob:admin:pw".
+// Each entry is name:role:password; the password may itself contain colons.
+func ParseUsers(spec string) (map[string]*User, error) {
+	users := make(map[string]*User)
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) != 3 || parts[2] == "" {
+			return nil, fmt.Errorf("invalid user %q: expected name:role:password", parts[0])
+		}
+		name := strings.TrimSpace(parts[0])
+		role := strings.TrimSpace(parts[1])
+		if name == "" {
+			return nil, fmt.Errorf("invalid user entry: missing name")
+		}
+		if !knownRole(role) {
+			return nil, fmt.Errorf("invalid user %q: unknown role %q", name, role)
+		}
+		if _, ok := users[name]; ok {
+			return nil, fmt.Errorf("invalid user %q: defined twice", name)
+		}
+		users[name] = &User{Name: name, Role: role, Password: parts[2]}
+	}
+	return users, nil
+}
+
+// Authenticate checks a login: with a username it must match a named user,
+// otherwise the shared admin and viewer passwords apply. ok is false when
+// the credentials match nothing.
+func (s *AuthService) Authenticate(username, password string) (Principal, bool) {
+	username = strings.TrimSpace(username)
+	if username == "" {
+		role := s.RoleForPassword(password)
+		return Principal{Role: role}, role != ""
+	}
+
+	user, ok := s.users[username]
+	if !ok || password != user.Password {
+		return Principal{}, false
+	}
+	return Principal{User: user.Name, Role: user.Role}, true
+}
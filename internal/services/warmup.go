@@ -0,0 +1,80 @@
+package services
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// WarmCache loads the usage of every key from Redis into the local cache,
+// so the first requests after a restart don't read Redis key by key. Keys
+// whose cached usage has expired get their latest history snapshot
+// instead; it is stale and refreshed as usual unless served during the
+// startup refresh. It returns the number of keys loaded.
+func (s *APIKeyService) WarmCache(ctx context.Context) (int, error) {
+	store := s.store.WithContext(ctx)
+	keys, err := store.GetAllAPIKeys()
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	ids := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if !key.Disabled && !key.IsExpired(now) {
+			ids = append(ids, key.ID)
+		}
+	}
+
+	loaded := 0
+	for start := 0; start < len(ids); start += s.batchSize {
+		end := start + s.batchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		batch := ids[start:end]
+
+		usages, err := store.BatchGetUsage(batch)
+		if err != nil {
+			return loaded, err
+		}
+		missing := make(map[string]time.Time)
+		for _, id := range batch {
+			if usages[id] == nil {
+				missing[id] = now.Add(time.Second)
+			}
+		}
+		if len(missing) > 0 {
+			snapshots, err := store.LatestSnapshotsBefore(missing)
+			if err != nil {
+				return loaded, err
+			}
+			for id, snapshot := range snapshots {
+				snapshot.ID = id
+				usages[id] = snapshot
+			}
+		}
+
+		for _, usage := range usages {
+			s.setLocalUsage(usage)
+		}
+		loaded += len(usages)
+	}
+	return loaded, nil
+}
+
+// refreshWarm refreshes every key with requests made meanwhile served the
+// usage loaded by WarmCache instead of joining the refresh
+func (s *APIKeyService) refreshWarm(ctx context.Context) error {
+	atomic.StoreInt32(&s.warming, 1)
+	defer atomic.StoreInt32(&s.warming, 0)
+
+	_, err := s.GetAggregatedData(withRefreshTrigger(ctx, TriggerStartup), adminPrincipal)
+	return err
+}
+
+// servingWarm reports whether a refresh made with ctx should serve cached
+// usage however old, because the startup refresh is fetching it
+func (s *APIKeyService) servingWarm(ctx context.Context) bool {
+	return atomic.LoadInt32(&s.warming) == 1 && refreshTrigger(ctx) != TriggerStartup
+}
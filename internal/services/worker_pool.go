@@ -2,21 +2,72 @@ package services
 
 import (
 	"context"
-	"encoding/json"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/droid-keyusage-go/internal/i18n"
 	"github.com/droid-keyusage-go/internal/models"
 	"github.com/droid-keyusage-go/internal/storage"
+	"github.com/droid-keyusage-go/internal/utils"
 )
 
+// Errors recorded for keys that were never fetched because of local limits
+const (
+	errQueueFull         = "task queue full"
+	errProcessingTimeout = "Processing timeout"
+)
+
+// ErrUpstreamUnavailable is matched by every UpstreamError
+var ErrUpstreamUnavailable = errors.New("upstream unavailable")
+
+// UpstreamError reports that the provider API could not be reached
+type UpstreamError struct {
+	Message string
+	Timeout bool
+}
+
+func (e *UpstreamError) Error() string {
+	if e.Timeout {
+		return "API request timed out: " + e.Message
+	}
+	return "API request failed: " + e.Message
+}
+
+// Is makes errors.Is(err, ErrUpstreamUnavailable) match
+func (e *UpstreamError) Is(target error) bool {
+	return target == ErrUpstreamUnavailable
+}
+
 // Task represents a work task
 type Task struct {
-	ID     string
-	APIKey string
+	ID  string
+	Key *storage.APIKey
+	// Ctx cancels the fetch, e.g. when the request that asked for it ends
+	Ctx context.Context
+	// flight receives the result instead of the result queue
+	flight *flight
+}
+
+// flight is a fetch of one key that every batch asking for the key while
+// it is queued or running waits for, so the provider is called only once
+type flight struct {
+	// id is the key of the flight in WorkerPool.flights
+	id     string
+	done   chan struct{}
+	result Result
+	// ctx is canceled once every waiter has given up on the fetch
+	ctx     context.Context
+	cancel  context.CancelFunc
+	waiters int
 }
 
 // Result represents task result
@@ -34,33 +85,179 @@ type WorkerPool struct {
 	resultQueue  chan Result
 	wg           sync.WaitGroup
 	shutdown     chan struct{}
-	httpClient   *http.Client
 	activeWorkers int32
 	processedTasks int64
+	upstream     *UpstreamTracker
+	mock         *MockFetcher
+	taskTimeout  time.Duration
+	slow         *SlowTaskTracker
+
+	// flights holds the fetches queued or running, by key ID or, with
+	// dedupeByKey, by the hash of the key value
+	flightsMu    sync.Mutex
+	flights      map[string]*flight
+	attachedTasks int64
+	dedupeByKey  bool
+	// rawMaxBytes is how much of each provider response is kept on the
+	// usage; 0 keeps none
+	rawMaxBytes  int
 }
 
 // NewWorkerPool creates a new worker pool
 func NewWorkerPool(maxWorkers, queueSize int) *WorkerPool {
-	// Create HTTP client with connection pooling
-	httpClient := &http.Client{
-		Timeout: 30 * time.Second,
-		Transport: &http.Transport{
-			MaxIdleConns:        maxWorkers * 2,
-			MaxIdleConnsPerHost: 10,
-			IdleConnTimeout:     90 * time.Second,
-			DisableCompression:  true,
-			DisableKeepAlives:   false,
-		},
-	}
-
 	return &WorkerPool{
 		maxWorkers:  maxWorkers,
 		queueSize:   queueSize,
 		taskQueue:   make(chan Task, queueSize),
 		resultQueue: make(chan Result, queueSize),
 		shutdown:    make(chan struct{}),
-		httpClient:  httpClient,
+		upstream:    NewUpstreamTracker(0, 0.9),
+		taskTimeout: defaultTaskTimeout,
+		slow:        NewSlowTaskTracker(defaultSlowTaskThreshold),
+		flights:     make(map[string]*flight),
+	}
+}
+
+// Defaults of ConfigureTasks
+const (
+	defaultTaskTimeout       = 15 * time.Second
+	defaultSlowTaskThreshold = 10 * time.Second
+)
+
+// ConfigureTasks sets the deadline of a single fetch, independent of the
+// timeout of the batch it belongs to, and the latency above which a fetch
+// is logged and tracked as slow
+func (wp *WorkerPool) ConfigureTasks(timeout, slowThreshold time.Duration) {
+	if timeout <= 0 {
+		timeout = defaultTaskTimeout
+	}
+	wp.taskTimeout = timeout
+	wp.slow = NewSlowTaskTracker(slowThreshold)
+}
+
+// SlowTasks returns the tracker of slow fetches
+func (wp *WorkerPool) SlowTasks() *SlowTaskTracker {
+	return wp.slow
+}
+
+// DedupeByKey makes entries that store the same key value under different
+// IDs (for example the same key in several tenants) share one fetch, whose
+// result is handed to each of them under its own ID
+func (wp *WorkerPool) DedupeByKey(enabled bool) {
+	wp.dedupeByKey = enabled
+}
+
+// KeepRawResponses keeps up to maxBytes of each provider response, masked,
+// on the usage read from it (models.Usage.Raw); 0 keeps none
+func (wp *WorkerPool) KeepRawResponses(maxBytes int) {
+	wp.rawMaxBytes = maxBytes
+}
+
+// rawResponse returns the part of a provider response that is kept, or nil
+// when responses are not kept
+func (wp *WorkerPool) rawResponse(status int, body []byte) *models.RawResponse {
+	if wp.rawMaxBytes <= 0 {
+		return nil
+	}
+	raw := &models.RawResponse{
+		Status:    status,
+		Size:      len(body),
+		Body:      utils.RedactJSON(body),
+		FetchedAt: time.Now(),
 	}
+	if len(raw.Body) > wp.rawMaxBytes {
+		raw.Body = strings.ToValidUTF8(raw.Body[:wp.rawMaxBytes], "")
+		raw.Truncated = true
+	}
+	return raw
+}
+
+// flightID identifies the fetch of key among the flights
+func (wp *WorkerPool) flightID(key *storage.APIKey) string {
+	if !wp.dedupeByKey {
+		return key.ID
+	}
+	sum := sha256.Sum256([]byte(providerName(key) + ":" + key.Key))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// join attaches to the fetch of key already queued or running, or starts
+// a new flight; leader reports whether the caller must submit its task.
+// The flight's context keeps ctx's values but not its cancellation, which
+// only applies once every waiter has left.
+func (wp *WorkerPool) join(ctx context.Context, key *storage.APIKey) (f *flight, leader bool) {
+	wp.flightsMu.Lock()
+	defer wp.flightsMu.Unlock()
+
+	id := wp.flightID(key)
+	if f, ok := wp.flights[id]; ok {
+		f.waiters++
+		atomic.AddInt64(&wp.attachedTasks, 1)
+		return f, false
+	}
+	fctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	f = &flight{id: id, done: make(chan struct{}), ctx: fctx, cancel: cancel, waiters: 1}
+	wp.flights[id] = f
+	return f, true
+}
+
+// leave gives up waiting for a flight; the fetch is canceled when nobody
+// waits for it anymore, and later batches start a new one
+func (wp *WorkerPool) leave(f *flight) {
+	wp.flightsMu.Lock()
+	defer wp.flightsMu.Unlock()
+
+	f.waiters--
+	if f.waiters > 0 {
+		return
+	}
+	f.cancel()
+	if wp.flights[f.id] == f {
+		delete(wp.flights, f.id)
+	}
+}
+
+// land delivers a flight's result to everyone waiting for it
+func (wp *WorkerPool) land(f *flight, result Result) {
+	wp.flightsMu.Lock()
+	if wp.flights[f.id] == f {
+		delete(wp.flights, f.id)
+	}
+	wp.flightsMu.Unlock()
+
+	f.result = result
+	close(f.done)
+	f.cancel()
+}
+
+// inFlight returns the number of keys queued or being fetched by batches
+func (wp *WorkerPool) inFlight() int {
+	wp.flightsMu.Lock()
+	defer wp.flightsMu.Unlock()
+	return len(wp.flights)
+}
+
+// TrackUpstream sets how many recent fetches per provider the upstream
+// statistics cover and the success rate below which a provider is degraded
+func (wp *WorkerPool) TrackUpstream(window int, degradedBelow float64) {
+	wp.upstream = NewUpstreamTracker(window, degradedBelow)
+}
+
+// UseMock makes every fetch return synthetic usage instead of calling the
+// providers
+func (wp *WorkerPool) UseMock(mock *MockFetcher) {
+	wp.mock = mock
+}
+
+// UpstreamStats returns rolling latency and success rate per provider
+func (wp *WorkerPool) UpstreamStats() map[string]*models.UpstreamStats {
+	stats := wp.upstream.Stats()
+	for provider, conns := range ProviderConnectionStats() {
+		if s, ok := stats[provider]; ok {
+			s.Connections = conns
+		}
+	}
+	return stats
 }
 
 // Start initializes and starts worker goroutines
@@ -93,6 +290,17 @@ func (wp *WorkerPool) worker(id int) {
 			}
 			
 			result := wp.processTask(task)
+			if task.flight != nil {
+				wp.land(task.flight, result)
+				atomic.AddInt64(&wp.processedTasks, 1)
+				continue
+			}
+
+			// Nobody collects the results of a canceled batch anymore; they
+			// would be taken for results of the next batch
+			if task.Ctx != nil && task.Ctx.Err() != nil {
+				continue
+			}
 			
 			select {
 			case wp.resultQueue <- result:
@@ -109,7 +317,15 @@ func (wp *WorkerPool) worker(id int) {
 
 // processTask fetches usage data for an API key
 func (wp *WorkerPool) processTask(task Task) Result {
-	usage, err := wp.fetchUsageFromAPI(task.ID, task.APIKey)
+	ctx := task.Ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	// Tasks of a canceled batch are dropped without calling the provider
+	if err := ctx.Err(); err != nil {
+		return Result{ID: task.ID, Error: err}
+	}
+	usage, err := wp.Fetch(ctx, task.Key)
 	return Result{
 		ID:    task.ID,
 		Usage: usage,
@@ -117,63 +333,106 @@ func (wp *WorkerPool) processTask(task Task) Result {
 	}
 }
 
-// fetchUsageFromAPI calls Factory.ai API
-func (wp *WorkerPool) fetchUsageFromAPI(id, apiKey string) (*models.Usage, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+// Fetch synchronously fetches usage for a single key, bypassing the queue.
+// The fetch is given up once it runs past the task deadline.
+func (wp *WorkerPool) Fetch(ctx context.Context, key *storage.APIKey) (*models.Usage, error) {
+	taskCtx, cancel := context.WithTimeout(ctx, wp.taskTimeout)
 	defer cancel()
 
-	req, err := http.NewRequestWithContext(ctx, "GET", 
-		"https://app.factory.ai/api/organization/members/chat-usage", nil)
+	start := time.Now()
+	usage, err := wp.fetchUsageFromAPI(taskCtx, key)
+	latency := time.Since(start)
+
+	// Out of time, rather than abandoned by the caller
+	timedOut := errors.Is(taskCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil
+	if timedOut && (err != nil || usage == nil) {
+		usage = nil
+		err = &UpstreamError{Message: fmt.Sprintf("task deadline of %v exceeded", wp.taskTimeout), Timeout: true}
+	}
+	if ctx.Err() == nil {
+		wp.slow.Record(key, latency, timedOut)
+	}
+	if usage != nil {
+		usage.LatencyMs = latency.Milliseconds()
+	}
+	return usage, err
+}
+
+// fetchUsageFromAPI calls the key's upstream provider
+func (wp *WorkerPool) fetchUsageFromAPI(ctx context.Context, key *storage.APIKey) (*models.Usage, error) {
+	provider, err := GetProvider(key.Provider)
 	if err != nil {
 		return nil, err
 	}
 
-	req.Header.Set("Authorization", "Bearer "+apiKey)
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36")
+	if wp.mock != nil {
+		start := time.Now()
+		usage, err := wp.mock.Fetch(ctx, key)
+		if errors.Is(ctx.Err(), context.Canceled) {
+			return nil, ctx.Err()
+		}
+		if ctx.Err() != nil {
+			usage, err = nil, ctx.Err()
+		}
+		wp.upstream.Record(providerName(key), time.Since(start), err == nil && usage.Error == "")
+		return usage, err
+	}
 
-	resp, err := wp.httpClient.Do(req)
+	req, err := provider.NewUsageRequest(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("API request failed: %w", err)
+		return nil, err
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return &models.Usage{
-			ID:    id,
-			Error: fmt.Sprintf("HTTP %d", resp.StatusCode),
-		}, nil
+	if err := applyCredential(req, key); err != nil {
+		return nil, err
 	}
 
-	// Parse response
-	var apiResp models.FactoryAPIResponse
-	if err := json.NewDecoder(resp.Body).Decode(&apiResp); err != nil {
-		return nil, fmt.Errorf("failed to decode response: %w", err)
+	// Every request that reaches the provider counts towards its statistics
+	start := time.Now()
+	usage, err := wp.doUsageRequest(provider, key, req)
+	// Requests abandoned by the caller say nothing about the provider
+	if errors.Is(err, context.Canceled) {
+		return nil, err
 	}
+	wp.upstream.Record(providerName(key), time.Since(start), err == nil && usage.Error == "")
+	return usage, err
+}
 
-	// Format dates
-	formatDate := func(timestamp int64) string {
-		if timestamp == 0 {
-			return "N/A"
+// doUsageRequest sends a usage request and parses the response
+func (wp *WorkerPool) doUsageRequest(provider Provider, key *storage.APIKey, req *http.Request) (*models.Usage, error) {
+	resp, err := providerHTTPClient(provider.Name()).Do(req)
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			return nil, context.Canceled
 		}
-		return time.Unix(timestamp/1000, 0).Format("2006-01-02")
+		// Drop the request URL, which may carry a query credential
+		timeout := errors.Is(err, context.DeadlineExceeded)
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			timeout = timeout || urlErr.Timeout()
+			err = urlErr.Err
+		}
+		return nil, &UpstreamError{Message: utils.Redact(err.Error()), Timeout: timeout}
 	}
+	defer resp.Body.Close()
 
-	// Mask API key
-	maskedKey := fmt.Sprintf("%s...%s", apiKey[:min(4, len(apiKey))], 
-		apiKey[max(0, len(apiKey)-4):])
+	if resp.StatusCode != http.StatusOK {
+		// Only the start of the body is needed for the error detail
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		usage := statusUsage(key.ID, resp.StatusCode, body)
+		usage.Raw = wp.rawResponse(resp.StatusCode, body)
+		return usage, nil
+	}
 
-	usage := &models.Usage{
-		ID:             id,
-		Key:            maskedKey,
-		StartDate:      formatDate(apiResp.Usage.StartDate),
-		EndDate:        formatDate(apiResp.Usage.EndDate),
-		TotalAllowance: apiResp.Usage.Standard.TotalAllowance,
-		OrgTotalUsed:   apiResp.Usage.Standard.OrgTotalTokensUsed,
-		Remaining:      apiResp.Usage.Standard.TotalAllowance - apiResp.Usage.Standard.OrgTotalTokensUsed,
-		UsedRatio:      apiResp.Usage.Standard.UsedRatio,
-		LastUpdated:    time.Now(),
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
 	}
 
+	usage, err := provider.ParseUsage(key.ID, body)
+	if err != nil {
+		usage = parseErrorUsage(key.ID, err)
+	}
+	usage.Raw = wp.rawResponse(resp.StatusCode, body)
 	return usage, nil
 }
 
@@ -197,55 +456,42 @@ func (wp *WorkerPool) GetResult() (Result, bool) {
 	}
 }
 
-// BatchProcess processes multiple API keys concurrently
-func (wp *WorkerPool) BatchProcess(keys []*storage.APIKey) ([]*models.Usage, error) {
+// BatchProcess processes multiple API keys concurrently. Keys already
+// queued or being fetched for another batch are not fetched again: the
+// batch waits for that fetch instead. When ctx is canceled, queued fetches
+// nobody else waits for are dropped and ctx's error is returned.
+func (wp *WorkerPool) BatchProcess(ctx context.Context, keys []*storage.APIKey) ([]*models.Usage, error) {
 	if len(keys) == 0 {
 		return []*models.Usage{}, nil
 	}
 
-	resultMap := make(map[string]*models.Usage, len(keys))
-	var mu sync.Mutex
-	var wg sync.WaitGroup
-
 	// 计算动态超时时间：每个key给2秒 + 基础30秒
 	timeoutDuration := 30*time.Second + time.Duration(len(keys)/wp.maxWorkers)*2*time.Second
 	if timeoutDuration > 5*time.Minute {
 		timeoutDuration = 5 * time.Minute // 最多5分钟
 	}
 
-	fmt.Printf("🚀 开始处理 %d 个 API Keys，使用 %d 个 workers，超时时间：%v\n",
-		len(keys), wp.maxWorkers, timeoutDuration)
+	fmt.Fprintln(console, i18n.Server("progress.start", len(keys), wp.maxWorkers, timeoutDuration))
 	startTime := time.Now()
 
-	// 创建一个带缓冲的结果channel，避免阻塞
-	resultChan := make(chan Result, len(keys))
-	
-	// 启动结果收集器
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		for result := range resultChan {
-			mu.Lock()
-			if result.Error != nil {
-				resultMap[result.ID] = &models.Usage{
-					ID:    result.ID,
-					Error: result.Error.Error(),
-				}
-			} else {
-				resultMap[result.ID] = result.Usage
-			}
-			mu.Unlock()
+	// 批量提交任务；已在队列中或正在查询的 Key 直接等待已有任务
+	flights := make([]*flight, len(keys))
+	submitted, attached := 0, 0
+	for i, key := range keys {
+		f, leader := wp.join(ctx, key)
+		flights[i] = f
+		if !leader {
+			attached++
+			continue
 		}
-	}()
 
-	// 批量提交任务
-	submitted := 0
-	for _, key := range keys {
 		task := Task{
 			ID:     key.ID,
-			APIKey: key.Key,
+			Key:    key,
+			Ctx:    f.ctx,
+			flight: f,
 		}
-		
+
 		// 非阻塞提交
 		select {
 		case wp.taskQueue <- task:
@@ -258,18 +504,21 @@ func (wp *WorkerPool) BatchProcess(keys []*storage.APIKey) ([]*models.Usage, err
 				submitted++
 			default:
 				// 仍然失败，记录错误
-				resultChan <- Result{
+				wp.land(f, Result{
 					ID:    key.ID,
-					Error: fmt.Errorf("task queue full"),
-				}
+					Error: errors.New(errQueueFull),
+				})
 			}
 		}
 	}
 
-	fmt.Printf("✅ 已提交 %d/%d 个任务到队列\n", submitted, len(keys))
+	fmt.Fprintln(console, i18n.Server("progress.submitted", submitted, len(keys)))
+	if attached > 0 {
+		fmt.Fprintln(console, i18n.Server("progress.attached", attached))
+	}
 
 	// 使用超时context收集结果
-	ctx, cancel := context.WithTimeout(context.Background(), timeoutDuration)
+	collectCtx, cancel := context.WithTimeout(ctx, timeoutDuration)
 	defer cancel()
 
 	received := 0
@@ -277,56 +526,78 @@ func (wp *WorkerPool) BatchProcess(keys []*storage.APIKey) ([]*models.Usage, err
 	defer ticker.Stop()
 
 collectLoop:
-	for received < len(keys) {
-		select {
-		case result := <-wp.resultQueue:
-			resultChan <- result
-			received++
-			
-			// 每收到100个结果打印一次进度
-			if received%100 == 0 {
+	for _, f := range flights {
+		for {
+			select {
+			case <-f.done:
+				received++
+
+				// 每收到100个结果打印一次进度
+				if received%100 == 0 {
+					elapsed := time.Since(startTime)
+					rate := float64(received) / elapsed.Seconds()
+					fmt.Fprintln(console, i18n.Server("progress.received",
+						received, len(keys), float64(received)/float64(len(keys))*100, rate))
+				}
+				continue collectLoop
+
+			case <-ticker.C:
+				// 每秒打印一次进度
 				elapsed := time.Since(startTime)
 				rate := float64(received) / elapsed.Seconds()
-				fmt.Printf("📊 进度: %d/%d (%.1f%%) | 速度: %.1f keys/s\n",
-					received, len(keys), float64(received)/float64(len(keys))*100, rate)
+				fmt.Fprintln(console, i18n.Server("progress.tick",
+					received, len(keys), float64(received)/float64(len(keys))*100, rate, elapsed.Round(time.Second)))
+
+			case <-collectCtx.Done():
+				if ctx.Err() == nil {
+					fmt.Fprintln(console, i18n.Server("progress.timeout", received, len(keys)))
+				}
+				break collectLoop
 			}
-			
-		case <-ticker.C:
-			// 每秒打印一次进度
-			elapsed := time.Since(startTime)
-			rate := float64(received) / elapsed.Seconds()
-			fmt.Printf("⏱️  处理中: %d/%d (%.1f%%) | 速度: %.1f keys/s | 耗时: %v\n",
-				received, len(keys), float64(received)/float64(len(keys))*100, rate, elapsed.Round(time.Second))
-			
-		case <-ctx.Done():
-			fmt.Printf("⚠️  超时! 已收到 %d/%d 个结果\n", received, len(keys))
-			break collectLoop
 		}
 	}
 
-	// 关闭结果channel并等待收集器完成
-	close(resultChan)
-	wg.Wait()
-
-	elapsed := time.Since(startTime)
-	rate := float64(received) / elapsed.Seconds()
-	fmt.Printf("🎉 处理完成! 总计: %d 个 | 成功: %d 个 | 耗时: %v | 平均速度: %.1f keys/s\n",
-		len(keys), received, elapsed.Round(time.Millisecond), rate)
-
-	// 转换为有序结果
+	// 转换为有序结果；其他批次和相同 Key 的条目共享同一结果，因此逐个复制并换成各自的 ID
 	results := make([]*models.Usage, 0, len(keys))
-	for _, key := range keys {
-		if usage, exists := resultMap[key.ID]; exists {
-			results = append(results, usage)
-		} else {
-			// 超时未收到的结果
+	received = 0
+	for i, key := range keys {
+		f := flights[i]
+		select {
+		case <-f.done:
+			received++
+		default:
+			// 超时或取消，不再等待
+			wp.leave(f)
 			results = append(results, &models.Usage{
 				ID:    key.ID,
-				Error: "Processing timeout",
+				Error: errProcessingTimeout,
+			})
+			continue
+		}
+
+		switch {
+		case f.result.Error != nil:
+			results = append(results, errorUsage(key.ID, f.result.Error))
+		case f.result.Usage != nil:
+			usage := *f.result.Usage
+			usage.ID = key.ID
+			results = append(results, &usage)
+		default:
+			results = append(results, &models.Usage{
+				ID:    key.ID,
+				Error: errProcessingTimeout,
 			})
 		}
 	}
 
+	elapsed := time.Since(startTime)
+	rate := float64(received) / elapsed.Seconds()
+	fmt.Fprintln(console, i18n.Server("progress.done",
+		len(keys), received, elapsed.Round(time.Millisecond), rate))
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	return results, nil
 }
 
@@ -337,22 +608,9 @@ func (wp *WorkerPool) GetStats() map[string]interface{} {
 		"queue_size":       len(wp.taskQueue),
 		"result_queue_size": len(wp.resultQueue),
 		"processed_tasks":  atomic.LoadInt64(&wp.processedTasks),
+		"in_flight":        wp.inFlight(),
+		"attached_tasks":   atomic.LoadInt64(&wp.attachedTasks),
 		"max_workers":      wp.maxWorkers,
 		"queue_capacity":   wp.queueSize,
 	}
 }
-
-// Helper functions
-func min(a, b int) int {
-	if a < b {
-		return a
-	}
-	return b
-}
-
-func max(a, b int) int {
-	if a > b {
-		return a
-	}
-	return b
-}
@@ -0,0 +1,198 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Alert states
+const (
+	AlertStateOpen         = "open"
+	AlertStateAcknowledged = "acknowledged"
+	AlertStateResolved     = "resolved"
+)
+
+// Alert is a fired alert tracked through its lifecycle
+type Alert struct {
+	ID             string     `json:"id"`
+	Rule           string     `json:"rule"`
+	KeyID          string     `json:"key_id"`
+	KeyName        string     `json:"key_name,omitempty"`
+	Provider       string     `json:"provider,omitempty"`
+	Severity       string     `json:"severity,omitempty"`
+	Message        string     `json:"message"`
+	State          string     `json:"state"`
+	Count          int        `json:"count"`
+	FiredAt        time.Time  `json:"fired_at"`
+	LastSeenAt     time.Time  `json:"last_seen_at"`
+	AcknowledgedBy string     `json:"acknowledged_by,omitempty"`
+	AcknowledgedAt *time.Time `json:"acknowledged_at,omitempty"`
+	ResolvedAt     *time.Time `json:"resolved_at,omitempty"`
+}
+
+// Fingerprint identifies the condition an alert was raised for
+func (a *Alert) Fingerprint() string {
+	if a.Provider != "" {
+		return a.Rule + ":" + a.KeyID + ":" + a.Provider
+	}
+	return a.Rule + ":" + a.KeyID
+}
+
+const (
+	alertsKey       = "alerts"
+	alertsIndexKey  = "alerts:index"
+	alertsActiveKey = "alerts:active"
+)
+
+// SaveAlert stores an alert and keeps the active-alert index in sync with its state
+func (s *Storage) SaveAlert(alert *Alert) error {
+	ctx := s.context()
+	data, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+
+	pipe := s.redis.client.Pipeline()
+	pipe.HSet(ctx, s.ns(alertsKey), alert.ID, data)
+	pipe.ZAdd(ctx, s.ns(alertsIndexKey), redis.Z{
+		Score:  float64(alert.FiredAt.Unix()),
+		Member: alert.ID,
+	})
+	if alert.State == AlertStateResolved {
+		pipe.HDel(ctx, s.ns(alertsActiveKey), alert.Fingerprint())
+	} else {
+		pipe.HSet(ctx, s.ns(alertsActiveKey), alert.Fingerprint(), alert.ID)
+	}
+
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// GetAlert retrieves an alert by ID
+func (s *Storage) GetAlert(id string) (*Alert, error) {
+	ctx := s.context()
+	data, err := s.redis.client.HGet(ctx, s.ns(alertsKey), id).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var alert Alert
+	if err := json.Unmarshal([]byte(data), &alert); err != nil {
+		return nil, err
+	}
+	return &alert, nil
+}
+
+// GetActiveAlerts returns all open or acknowledged alerts keyed by fingerprint
+func (s *Storage) GetActiveAlerts() (map[string]*Alert, error) {
+	ctx := s.context()
+	active, err := s.redis.client.HGetAll(ctx, s.ns(alertsActiveKey)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	alerts := make(map[string]*Alert, len(active))
+	if len(active) == 0 {
+		return alerts, nil
+	}
+
+	ids := make([]string, 0, len(active))
+	for _, id := range active {
+		ids = append(ids, id)
+	}
+
+	values, err := s.redis.client.HMGet(ctx, s.ns(alertsKey), ids...).Result()
+	if err != nil {
+		return nil, err
+	}
+	for _, value := range values {
+		data, ok := value.(string)
+		if !ok {
+			continue
+		}
+		var alert Alert
+		if err := json.Unmarshal([]byte(data), &alert); err != nil {
+			continue
+		}
+		alerts[alert.Fingerprint()] = &alert
+	}
+
+	return alerts, nil
+}
+
+// ListAlerts returns the most recently fired alerts, newest first
+func (s *Storage) ListAlerts(limit int64) ([]*Alert, error) {
+	ctx := s.context()
+	ids, err := s.redis.client.ZRevRange(ctx, s.ns(alertsIndexKey), 0, limit-1).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return []*Alert{}, nil
+	}
+
+	values, err := s.redis.client.HMGet(ctx, s.ns(alertsKey), ids...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	alerts := make([]*Alert, 0, len(values))
+	for _, value := range values {
+		data, ok := value.(string)
+		if !ok {
+			continue
+		}
+		var alert Alert
+		if err := json.Unmarshal([]byte(data), &alert); err != nil {
+			continue
+		}
+		alerts = append(alerts, &alert)
+	}
+	return alerts, nil
+}
+
+// PruneAlerts removes resolved alerts fired before cutoff
+func (s *Storage) PruneAlerts(cutoff time.Time) (int64, error) {
+	ctx := s.context()
+	ids, err := s.redis.client.ZRangeByScore(ctx, s.ns(alertsIndexKey), &redis.ZRangeBy{
+		Min: "-inf",
+		Max: fmt.Sprintf("(%d", cutoff.Unix()),
+	}).Result()
+	if err != nil || len(ids) == 0 {
+		return 0, err
+	}
+
+	var removed int64
+	for _, id := range ids {
+		alert, err := s.GetAlert(id)
+		if err != nil {
+			return removed, err
+		}
+		if alert != nil && alert.State != AlertStateResolved {
+			continue
+		}
+
+		pipe := s.redis.client.Pipeline()
+		pipe.HDel(ctx, s.ns(alertsKey), id)
+		pipe.ZRem(ctx, s.ns(alertsIndexKey), id)
+		if _, err := pipe.Exec(ctx); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// MarkAlertSent records that a notification went out for a fingerprint.
+// It returns false if one was already sent within the dedup window.
+func (s *Storage) MarkAlertSent(fingerprint string, window time.Duration) (bool, error) {
+	ctx := s.context()
+	key := s.ns(fmt.Sprintf("alerts:sent:%s", fingerprint))
+	return s.redis.client.SetNX(ctx, key, time.Now().Unix(), window).Result()
+}
@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// AuditEntry records a security-relevant action
+type AuditEntry struct {
+	ID        string    `json:"id"`
+	Time      time.Time `json:"time"`
+	Action    string    `json:"action"`
+	Actor     string    `json:"actor"`
+	IP        string    `json:"ip,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	Location  string    `json:"location,omitempty"`
+	Country   string    `json:"country,omitempty"`
+	ASN       uint      `json:"asn,omitempty"`
+	ASOrg     string    `json:"as_org,omitempty"`
+	KeyID     string    `json:"key_id,omitempty"`
+	Success   bool      `json:"success"`
+	Detail    string    `json:"detail,omitempty"`
+}
+
+const auditLogKey = "audit:log"
+
+// AppendAudit records an audit entry, scored by its time
+func (s *Storage) AppendAudit(entry *AuditEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return s.redis.client.ZAdd(s.context(), s.ns(auditLogKey), redis.Z{
+		Score:  float64(entry.Time.UnixNano()),
+		Member: data,
+	}).Err()
+}
+
+// ListAudit returns up to limit audit entries, newest first, optionally
+// filtered by action
+func (s *Storage) ListAudit(action string, limit int) ([]*AuditEntry, error) {
+	ctx := s.context()
+
+	// Filtering happens client side, so read ahead when an action is given
+	fetch := int64(limit)
+	if action != "" {
+		fetch = int64(limit) * 10
+	}
+	members, err := s.redis.client.ZRevRange(ctx, s.ns(auditLogKey), 0, fetch-1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*AuditEntry, 0, limit)
+	for _, member := range members {
+		var entry AuditEntry
+		if err := json.Unmarshal([]byte(member), &entry); err != nil {
+			continue
+		}
+		if action != "" && entry.Action != action {
+			continue
+		}
+		entries = append(entries, &entry)
+		if len(entries) >= limit {
+			break
+		}
+	}
+	return entries, nil
+}
+
+// MarkLoginIP remembers that actor logged in from ip. isNew reports whether
+// the IP had not been seen for the actor, seenBefore whether the actor had
+// logged in from any IP before.
+func (s *Storage) MarkLoginIP(actor, ip string) (isNew, seenBefore bool, err error) {
+	key := s.ns("audit:login_ips:" + actor)
+	pipe := s.redis.client.TxPipeline()
+	card := pipe.SCard(s.context(), key)
+	added := pipe.SAdd(s.context(), key, ip)
+	if _, err := pipe.Exec(s.context()); err != nil {
+		return false, false, err
+	}
+	return added.Val() > 0, card.Val() > 0, nil
+}
+
+// PruneAudit removes audit entries recorded before cutoff
+func (s *Storage) PruneAudit(cutoff time.Time) (int64, error) {
+	return s.redis.client.ZRemRangeByScore(s.context(), s.ns(auditLogKey),
+		"-inf", fmt.Sprintf("(%d", cutoff.UnixNano())).Result()
+}
@@ -0,0 +1,215 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Soft-deleted keys leave keys:list but keep their record until purged
+const (
+	// deletedKeysKey is a sorted set of deleted key IDs scored by deletion time
+	deletedKeysKey = "keys:deleted"
+	// deletedKeyIndexKey maps the SHA-256 of a deleted key value to its ID
+	deletedKeyIndexKey = "keys:deleted:index"
+)
+
+// queueSoftDelete queues the commands that move a key to the deleted set;
+// its usage cache, failure state and search entry are dropped, its history
+// is kept
+func (s *Storage) queueSoftDelete(ctx context.Context, pipe redis.Pipeliner, key *APIKey, now time.Time) error {
+	key.DeletedAt = &now
+	keyData, err := json.Marshal(key)
+	if err != nil {
+		return err
+	}
+
+	pipe.HSet(ctx, s.ns(fmt.Sprintf("key:%s", key.ID)), "data", keyData)
+	pipe.SRem(ctx, s.ns("keys:list"), key.ID)
+	pipe.ZAdd(ctx, s.ns(deletedKeysKey), redis.Z{Score: float64(now.Unix()), Member: key.ID})
+	pipe.HSet(ctx, s.ns(deletedKeyIndexKey), keyHash(key.Key), key.ID)
+	pipe.Del(ctx, s.ns(fmt.Sprintf("key:%s:usage", key.ID)))
+	pipe.Del(ctx, s.ns(fmt.Sprintf("key:%s:expiry_reminded", key.ID)))
+	pipe.Del(ctx, s.ns(failuresKey(key.ID)))
+	pipe.HDel(ctx, s.ns(keySearchKey), key.ID)
+	return nil
+}
+
+// softDeleteCmds is the number of commands queued by queueSoftDelete
+const softDeleteCmds = 8
+
+// DeleteAPIKey soft-deletes an API key, returning ErrNotFound when it does
+// not exist
+func (s *Storage) DeleteAPIKey(id string) error {
+	key, err := s.GetAPIKey(id)
+	if err != nil {
+		return err
+	}
+	if key == nil {
+		return ErrNotFound
+	}
+
+	ctx := s.context()
+	pipe := s.redis.client.Pipeline()
+	if err := s.queueSoftDelete(ctx, pipe, key, time.Now()); err != nil {
+		return err
+	}
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// BatchDeleteAPIKeys soft-deletes multiple API keys and reports the outcome
+// of every ID: nil when deleted, ErrNotFound when it did not exist, or the
+// Redis error
+func (s *Storage) BatchDeleteAPIKeys(ids []string) map[string]error {
+	ctx := s.context()
+	results := make(map[string]error, len(ids))
+
+	// Load the keys first; deleting needs their values for the index
+	readPipe := s.redis.client.Pipeline()
+	reads := make([]*redis.StringCmd, len(ids))
+	for i, id := range ids {
+		reads[i] = readPipe.HGet(ctx, s.ns(fmt.Sprintf("key:%s", id)), "data")
+	}
+	_, _ = readPipe.Exec(ctx)
+
+	now := time.Now()
+	pipe := s.redis.client.Pipeline()
+	queued := make([]string, 0, len(ids))
+	for i, id := range ids {
+		// A repeated ID keeps the outcome of its first occurrence
+		if _, seen := results[id]; seen {
+			continue
+		}
+
+		data, err := reads[i].Result()
+		if err == redis.Nil {
+			results[id] = ErrNotFound
+			continue
+		}
+		if err != nil {
+			results[id] = err
+			continue
+		}
+
+		var key APIKey
+		if err := json.Unmarshal([]byte(data), &key); err != nil {
+			results[id] = err
+			continue
+		}
+		if key.DeletedAt != nil {
+			results[id] = ErrNotFound
+			continue
+		}
+		if err := s.queueSoftDelete(ctx, pipe, &key, now); err != nil {
+			results[id] = err
+			continue
+		}
+		results[id] = nil
+		queued = append(queued, id)
+	}
+	if len(queued) == 0 {
+		return results
+	}
+
+	cmds, err := pipe.Exec(ctx)
+	for i, id := range queued {
+		if cmdErr := pipelineError(cmds, i*softDeleteCmds, softDeleteCmds, err); cmdErr != nil {
+			results[id] = cmdErr
+		}
+	}
+	return results
+}
+
+// FindDeletedAPIKey returns the soft-deleted key with the given value, or
+// nil when there is none
+func (s *Storage) FindDeletedAPIKey(raw string) (*APIKey, error) {
+	ctx := s.context()
+	id, err := s.redis.client.HGet(ctx, s.ns(deletedKeyIndexKey), keyHash(raw)).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	data, err := s.redis.client.HGet(ctx, s.ns(fmt.Sprintf("key:%s", id)), "data").Result()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var key APIKey
+	if err := json.Unmarshal([]byte(data), &key); err != nil {
+		return nil, err
+	}
+	if key.DeletedAt == nil || key.Key != raw {
+		return nil, nil
+	}
+	return &key, nil
+}
+
+// RestoreAPIKey brings a soft-deleted key back, returning ErrDuplicate when
+// the same key value has been stored again since it was deleted
+func (s *Storage) RestoreAPIKey(key *APIKey) error {
+	key.DeletedAt = nil
+	if err := s.CreateAPIKey(key); err != nil {
+		return err
+	}
+
+	ctx := s.context()
+	pipe := s.redis.client.Pipeline()
+	pipe.ZRem(ctx, s.ns(deletedKeysKey), key.ID)
+	pipe.HDel(ctx, s.ns(deletedKeyIndexKey), keyHash(key.Key))
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// PruneDeletedKeys permanently removes keys deleted before cutoff along
+// with their usage history
+func (s *Storage) PruneDeletedKeys(cutoff time.Time) (int64, error) {
+	ctx := s.context()
+	ids, err := s.redis.client.ZRangeByScore(ctx, s.ns(deletedKeysKey), &redis.ZRangeBy{
+		Min: "-inf",
+		Max: "(" + strconv.FormatInt(cutoff.Unix(), 10),
+	}).Result()
+	if err != nil || len(ids) == 0 {
+		return 0, err
+	}
+
+	var removed int64
+	for _, id := range ids {
+		data, err := s.redis.client.HGet(ctx, s.ns(fmt.Sprintf("key:%s", id)), "data").Result()
+		if err != nil && err != redis.Nil {
+			return removed, err
+		}
+
+		pipe := s.redis.client.Pipeline()
+		var key APIKey
+		if data != "" && json.Unmarshal([]byte(data), &key) == nil {
+			// Restored keys are live again and must not be purged
+			if key.DeletedAt == nil {
+				pipe.ZRem(ctx, s.ns(deletedKeysKey), id)
+				if _, err := pipe.Exec(ctx); err != nil {
+					return removed, err
+				}
+				continue
+			}
+			pipe.HDel(ctx, s.ns(deletedKeyIndexKey), keyHash(key.Key))
+		}
+		pipe.Del(ctx, s.ns(fmt.Sprintf("key:%s", id)))
+		pipe.Del(ctx, s.ns(historyKey(id)))
+		pipe.ZRem(ctx, s.ns(deletedKeysKey), id)
+		if _, err := pipe.Exec(ctx); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
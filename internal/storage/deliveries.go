@@ -0,0 +1,59 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// DeliveryRecord is one attempt to deliver a notification to a channel
+type DeliveryRecord struct {
+	Time       time.Time `json:"time"`
+	Channel    string    `json:"channel"`
+	Event      string    `json:"event"`
+	Title      string    `json:"title"`
+	KeyID      string    `json:"key_id,omitempty"`
+	Success    bool      `json:"success"`
+	Error      string    `json:"error,omitempty"`
+	DurationMs int64     `json:"duration_ms"`
+}
+
+const deliveryLogKey = "notifications:deliveries"
+
+// AppendDelivery records a delivery attempt, scored by its time
+func (s *Storage) AppendDelivery(record *DeliveryRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return s.redis.client.ZAdd(s.context(), s.ns(deliveryLogKey), redis.Z{
+		Score:  float64(record.Time.UnixNano()),
+		Member: data,
+	}).Err()
+}
+
+// ListDeliveries returns up to limit delivery attempts, newest first
+func (s *Storage) ListDeliveries(limit int) ([]*DeliveryRecord, error) {
+	members, err := s.redis.client.ZRevRange(s.context(), s.ns(deliveryLogKey), 0, int64(limit)-1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]*DeliveryRecord, 0, len(members))
+	for _, member := range members {
+		var record DeliveryRecord
+		if err := json.Unmarshal([]byte(member), &record); err != nil {
+			continue
+		}
+		records = append(records, &record)
+	}
+	return records, nil
+}
+
+// PruneDeliveries removes delivery attempts made before cutoff
+func (s *Storage) PruneDeliveries(cutoff time.Time) (int64, error) {
+	return s.redis.client.ZRemRangeByScore(s.context(), s.ns(deliveryLogKey),
+		"-inf", fmt.Sprintf("(%d", cutoff.UnixNano())).Result()
+}
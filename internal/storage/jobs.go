@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// JobSummary records one refresh batch: which keys were fetched from the
+// upstream, how many succeeded and why the others failed
+type JobSummary struct {
+	ID         string    `json:"id"`
+	Trigger    string    `json:"trigger"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	DurationMs int64     `json:"duration_ms"`
+	// TotalKeys is the number of keys, fetched or served from cache
+	TotalKeys int `json:"total_keys"`
+	Attempted int `json:"attempted"`
+	Succeeded int `json:"succeeded"`
+	Failed    int `json:"failed"`
+	// FailedByReason counts the failed keys by usage error code
+	FailedByReason map[string]int `json:"failed_by_reason,omitempty"`
+	// Throughput is the number of keys fetched per second
+	Throughput float64 `json:"throughput"`
+}
+
+// RefreshProgress describes the refresh batch running or last finished,
+// updated after each chunk of keys
+type RefreshProgress struct {
+	ID        string    `json:"id"`
+	Trigger   string    `json:"trigger"`
+	StartedAt time.Time `json:"started_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// Total is the number of keys the batch fetches, Done those fetched so far
+	Total      int  `json:"total"`
+	Done       int  `json:"done"`
+	Succeeded  int  `json:"succeeded"`
+	Failed     int  `json:"failed"`
+	Chunks     int  `json:"chunks"`
+	ChunksDone int  `json:"chunks_done"`
+	Finished   bool `json:"finished"`
+}
+
+const (
+	jobHistoryKey      = "jobs:history"
+	refreshProgressKey = "jobs:current"
+	// refreshProgressTTL drops the progress of a batch whose process died
+	refreshProgressTTL = time.Hour
+)
+
+// SaveRefreshProgress replaces the progress of the current refresh batch
+func (s *Storage) SaveRefreshProgress(progress *RefreshProgress) error {
+	return s.SetJSON(refreshProgressKey, progress, refreshProgressTTL)
+}
+
+// GetRefreshProgress returns the progress of the refresh batch running or
+// last finished, or nil when there was none within the last hour
+func (s *Storage) GetRefreshProgress() (*RefreshProgress, error) {
+	var progress RefreshProgress
+	found, err := s.GetJSON(refreshProgressKey, &progress)
+	if err != nil || !found {
+		return nil, err
+	}
+	return &progress, nil
+}
+
+// AppendJobSummary records a refresh batch, scored by its start time
+func (s *Storage) AppendJobSummary(job *JobSummary) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return s.redis.client.ZAdd(s.context(), s.ns(jobHistoryKey), redis.Z{
+		Score:  float64(job.StartedAt.UnixNano()),
+		Member: data,
+	}).Err()
+}
+
+// ListJobSummaries returns up to limit refresh batches, newest first,
+// optionally only those started by trigger
+func (s *Storage) ListJobSummaries(trigger string, limit int) ([]*JobSummary, error) {
+	ctx := s.context()
+
+	// Filtering happens client side, so read ahead when a trigger is given
+	fetch := int64(limit)
+	if trigger != "" {
+		fetch = int64(limit) * 10
+	}
+	members, err := s.redis.client.ZRevRange(ctx, s.ns(jobHistoryKey), 0, fetch-1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := make([]*JobSummary, 0, limit)
+	for _, member := range members {
+		var job JobSummary
+		if err := json.Unmarshal([]byte(member), &job); err != nil {
+			continue
+		}
+		if trigger != "" && job.Trigger != trigger {
+			continue
+		}
+		jobs = append(jobs, &job)
+		if len(jobs) >= limit {
+			break
+		}
+	}
+	return jobs, nil
+}
+
+// PruneJobSummaries removes refresh batches started before cutoff
+func (s *Storage) PruneJobSummaries(cutoff time.Time) (int64, error) {
+	return s.redis.client.ZRemRangeByScore(s.context(), s.ns(jobHistoryKey),
+		"-inf", fmt.Sprintf("(%d", cutoff.UnixNano())).Result()
+}
@@ -0,0 +1,152 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// keyIndexKey maps the SHA-256 of every stored key value to its ID so the
+// same key can't be stored twice, even by concurrent imports
+const keyIndexKey = "keys:index"
+
+// ErrDuplicate is reported when a key with the same value is already stored
+var ErrDuplicate = errors.New("duplicate key")
+
+// createKeySrc stores a key and its index entry unless the index already
+// points at a live key; it returns the ID of that key, or "" when stored.
+// KEYS: keys:index, key:<id>, keys:list, keys:search; ARGV: hash, id, data,
+// search entry
+const createKeySrc = `
+local existing = redis.call('HGET', KEYS[1], ARGV[1])
+if existing and redis.call('SISMEMBER', KEYS[3], existing) == 1 then
+	return existing
+end
+redis.call('HSET', KEYS[1], ARGV[1], ARGV[2])
+redis.call('HSET', KEYS[2], 'data', ARGV[3])
+redis.call('SADD', KEYS[3], ARGV[2])
+redis.call('HSET', KEYS[4], ARGV[2], ARGV[4])
+return ''
+`
+
+// pruneKeyIndexSrc drops index entries of keys that no longer exist.
+// KEYS: keys:index, keys:list
+const pruneKeyIndexSrc = `
+local removed = 0
+local entries = redis.call('HGETALL', KEYS[1])
+for i = 1, #entries, 2 do
+	if redis.call('SISMEMBER', KEYS[2], entries[i + 1]) == 0 then
+		redis.call('HDEL', KEYS[1], entries[i])
+		removed = removed + 1
+	end
+end
+return removed
+`
+
+var createKeyScript = redis.NewScript(createKeySrc)
+
+// keyHash identifies a key value in the index without storing it again
+func keyHash(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *Storage) createKeyArgs(key *APIKey) ([]string, []interface{}, error) {
+	keyData, err := json.Marshal(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	searchData, err := searchEntryData(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keys := []string{s.ns(keyIndexKey), s.ns(fmt.Sprintf("key:%s", key.ID)), s.ns("keys:list"), s.ns(keySearchKey)}
+	return keys, []interface{}{keyHash(key.Key), key.ID, keyData, searchData}, nil
+}
+
+// CreateAPIKey stores a new key, returning ErrDuplicate when a key with the
+// same value is already stored
+func (s *Storage) CreateAPIKey(key *APIKey) error {
+	ctx := s.context()
+	keys, args, err := s.createKeyArgs(key)
+	if err != nil {
+		return err
+	}
+
+	existing, err := createKeyScript.Run(ctx, s.redis.client, keys, args...).Text()
+	if err != nil {
+		return err
+	}
+	if existing != "" {
+		return ErrDuplicate
+	}
+	return nil
+}
+
+// BatchCreateAPIKeys stores new keys in a single pipeline with the same
+// uniqueness check as CreateAPIKey, and returns the error of every key that
+// was not stored, by key ID
+func (s *Storage) BatchCreateAPIKeys(keys []*APIKey) map[string]error {
+	ctx := s.context()
+	failed := make(map[string]error)
+
+	// Load the script once so the pipeline can use EVALSHA
+	if err := createKeyScript.Load(ctx, s.redis.client).Err(); err != nil {
+		for _, key := range keys {
+			failed[key.ID] = err
+		}
+		return failed
+	}
+
+	pipe := s.redis.client.Pipeline()
+	queued := make([]*APIKey, 0, len(keys))
+	cmds := make([]*redis.Cmd, 0, len(keys))
+	for _, key := range keys {
+		redisKeys, args, err := s.createKeyArgs(key)
+		if err != nil {
+			failed[key.ID] = err
+			continue
+		}
+		cmds = append(cmds, createKeyScript.EvalSha(ctx, pipe, redisKeys, args...))
+		queued = append(queued, key)
+	}
+	if len(queued) == 0 {
+		return failed
+	}
+
+	// Per-command errors are read below
+	_, _ = pipe.Exec(ctx)
+	for i, key := range queued {
+		existing, err := cmds[i].Text()
+		switch {
+		case err != nil:
+			failed[key.ID] = err
+		case existing != "":
+			failed[key.ID] = ErrDuplicate
+		}
+	}
+	return failed
+}
+
+// RebuildKeyIndex adds index entries for keys stored before the index
+// existed and drops entries of deleted keys
+func (s *Storage) RebuildKeyIndex() error {
+	ctx := s.context()
+	keys, err := s.GetAllAPIKeys()
+	if err != nil {
+		return err
+	}
+
+	// Prune first so stale entries don't block live keys with the same value
+	pipe := s.redis.client.Pipeline()
+	pipe.Eval(ctx, pruneKeyIndexSrc, []string{s.ns(keyIndexKey), s.ns("keys:list")})
+	for _, key := range keys {
+		pipe.HSetNX(ctx, s.ns(keyIndexKey), keyHash(key.Key), key.ID)
+	}
+	_, err = pipe.Exec(ctx)
+	return err
+}
@@ -0,0 +1,168 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// newTestStorage returns a storage backed by miniredis
+func newTestStorage(t *testing.T) *Storage {
+	t.Helper()
+	server := miniredis.RunT(t)
+	client, err := NewRedisClient(DefaultRedisOptions("redis://" + server.Addr()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return NewStorage(client)
+}
+
+// newMemoryTestStorage returns a storage on the in-memory backend
+func newMemoryTestStorage(t *testing.T) *Storage {
+	t.Helper()
+	client, err := NewMemoryClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return NewStorage(client)
+}
+
+// eachBackend runs test against Redis and against the in-memory backend
+func eachBackend(t *testing.T, test func(t *testing.T, s *Storage)) {
+	t.Run("redis", func(t *testing.T) { test(t, newTestStorage(t)) })
+	t.Run("memory", func(t *testing.T) { test(t, newMemoryTestStorage(t)) })
+}
+
+func TestCreateAPIKeyConcurrentDuplicates(t *testing.T) {
+	eachBackend(t, func(t *testing.T, s *Storage) {
+		const attempts = 50
+		var wg sync.WaitGroup
+		errs := make([]error, attempts)
+		for i := 0; i < attempts; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				errs[i] = s.CreateAPIKey(&APIKey{ID: fmt.Sprintf("key-%d", i), Key: "fk-same-value", Name: "dup"})
+			}(i)
+		}
+		wg.Wait()
+
+		created := 0
+		for i, err := range errs {
+			switch {
+			case err == nil:
+				created++
+			case !errors.Is(err, ErrDuplicate):
+				t.Fatalf("attempt %d: %v", i, err)
+			}
+		}
+		if created != 1 {
+			t.Fatalf("%d concurrent adds of the same key were stored, want 1", created)
+		}
+		keys, err := s.GetAllAPIKeys()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(keys) != 1 {
+			t.Fatalf("%d keys stored, want 1", len(keys))
+		}
+	})
+}
+
+func TestBatchCreateAPIKeysConcurrentDuplicates(t *testing.T) {
+	eachBackend(t, func(t *testing.T, s *Storage) {
+		// Two imports of the same values race; each value must be stored once
+		var wg sync.WaitGroup
+		results := make([]map[string]error, 2)
+		for i := range results {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				batch := make([]*APIKey, 20)
+				for j := range batch {
+					batch[j] = &APIKey{ID: fmt.Sprintf("import%d-%d", i, j), Key: fmt.Sprintf("fk-value-%d", j)}
+				}
+				results[i] = s.BatchCreateAPIKeys(batch)
+			}(i)
+		}
+		wg.Wait()
+
+		for j := 0; j < 20; j++ {
+			first, second := results[0][fmt.Sprintf("import0-%d", j)], results[1][fmt.Sprintf("import1-%d", j)]
+			if (first == nil) == (second == nil) {
+				t.Errorf("value %d: outcomes %v and %v, want exactly one stored", j, first, second)
+			}
+			for _, err := range []error{first, second} {
+				if err != nil && !errors.Is(err, ErrDuplicate) {
+					t.Errorf("value %d: %v", j, err)
+				}
+			}
+		}
+		keys, err := s.GetAllAPIKeys()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(keys) != 20 {
+			t.Fatalf("%d keys stored, want 20", len(keys))
+		}
+	})
+}
+
+func TestDeleteFreesKeyIndex(t *testing.T) {
+	eachBackend(t, func(t *testing.T, s *Storage) {
+		if err := s.CreateAPIKey(&APIKey{ID: "first", Key: "fk-reused"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.CreateAPIKey(&APIKey{ID: "second", Key: "fk-reused"}); !errors.Is(err, ErrDuplicate) {
+			t.Fatalf("duplicate add: got %v, want ErrDuplicate", err)
+		}
+
+		if err := s.DeleteAPIKey("first"); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.CreateAPIKey(&APIKey{ID: "second", Key: "fk-reused"}); err != nil {
+			t.Fatalf("add after delete: %v", err)
+		}
+		if err := s.CreateAPIKey(&APIKey{ID: "third", Key: "fk-reused"}); !errors.Is(err, ErrDuplicate) {
+			t.Fatalf("add after re-add: got %v, want ErrDuplicate", err)
+		}
+
+		// Batch deletes free the index the same way
+		if errs := s.BatchDeleteAPIKeys([]string{"second"}); errs["second"] != nil {
+			t.Fatal(errs["second"])
+		}
+		if failed := s.BatchCreateAPIKeys([]*APIKey{{ID: "fourth", Key: "fk-reused"}}); len(failed) != 0 {
+			t.Fatalf("batch add after batch delete: %v", failed)
+		}
+	})
+}
+
+func TestRebuildKeyIndexDropsDeletedKeys(t *testing.T) {
+	eachBackend(t, func(t *testing.T, s *Storage) {
+		if err := s.CreateAPIKey(&APIKey{ID: "gone", Key: "fk-gone"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.CreateAPIKey(&APIKey{ID: "kept", Key: "fk-kept"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.DeleteAPIKey("gone"); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.RebuildKeyIndex(); err != nil {
+			t.Fatal(err)
+		}
+
+		index, err := s.redis.client.HGetAll(s.context(), s.ns(keyIndexKey)).Result()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(index) != 1 || index[keyHash("fk-kept")] != "kept" {
+			t.Fatalf("index after rebuild: %v, want only the kept key", index)
+		}
+	})
+}
@@ -0,0 +1,508 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// memorySweepInterval is how often expired keys of the in-memory backend
+// are removed; reads never return them either way
+const memorySweepInterval = time.Minute
+
+// memoryPubSubLimit bounds the bytes of messages waiting to be read by a
+// subscriber; one falling further behind is disconnected, like Redis does
+// when a client exceeds its output buffer limit
+const memoryPubSubLimit = 32 << 20
+
+var errMemoryClosed = errors.New("in-memory storage is closed")
+
+// memoryServer keeps the data of the in-memory backend in maps, with TTLs
+// that follow the wall clock. go-redis reaches it through in-process pipes
+// instead of network connections, so the storage code, its pipelines,
+// transactions, scripts and pub/sub are the same for both backends.
+type memoryServer struct {
+	// mu guards the data, the scripts and the Lua state; every command, a
+	// transaction and a script run while holding it
+	mu      sync.Mutex
+	entries map[string]*memoryEntry
+	scripts map[string]*lua.LFunction
+	lua     *lua.LState
+
+	// connsMu guards the connections and their pub/sub patterns
+	connsMu sync.Mutex
+	conns   map[*memoryConn]struct{}
+	closed  bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewMemoryClient starts an in-process, in-memory store and connects to it.
+// Every storage operation (including pub/sub and scripts) works unchanged;
+// data is lost on exit. Useful for integration tests and demos without
+// external services.
+func NewMemoryClient() (*RedisClient, error) {
+	mem := newMemoryServer()
+	client := redis.NewClient(&redis.Options{
+		Addr:             "memory",
+		Dialer:           mem.dial,
+		PoolSize:         100,
+		DisableIndentity: true,
+	})
+	ctx := context.Background()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		mem.close()
+		return nil, fmt.Errorf("failed to connect to in-memory storage: %w", err)
+	}
+
+	return &RedisClient{
+		client: client,
+		ctx:    ctx,
+		memory: mem,
+	}, nil
+}
+
+func newMemoryServer() *memoryServer {
+	m := &memoryServer{
+		entries: make(map[string]*memoryEntry),
+		scripts: make(map[string]*lua.LFunction),
+		conns:   make(map[*memoryConn]struct{}),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	m.lua = m.newScriptState()
+	go m.sweep()
+	return m
+}
+
+// dial opens a connection served by a goroutine of its own
+func (m *memoryServer) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	client, server := net.Pipe()
+	conn := &memoryConn{
+		server: m,
+		conn:   server,
+		ready:  make(chan struct{}, 1),
+		closed: make(chan struct{}),
+	}
+
+	m.connsMu.Lock()
+	if m.closed {
+		m.connsMu.Unlock()
+		client.Close()
+		server.Close()
+		return nil, errMemoryClosed
+	}
+	m.conns[conn] = struct{}{}
+	m.connsMu.Unlock()
+
+	go conn.serve()
+	go conn.write()
+	return client, nil
+}
+
+// sweep removes expired keys
+func (m *memoryServer) sweep() {
+	defer close(m.done)
+
+	ticker := time.NewTicker(memorySweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			m.mu.Lock()
+			for key, entry := range m.entries {
+				if entry.expired(now) {
+					delete(m.entries, key)
+				}
+			}
+			m.mu.Unlock()
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+func (m *memoryServer) close() {
+	close(m.stop)
+	<-m.done
+
+	m.connsMu.Lock()
+	m.closed = true
+	conns := make([]*memoryConn, 0, len(m.conns))
+	for conn := range m.conns {
+		conns = append(conns, conn)
+	}
+	m.connsMu.Unlock()
+	for _, conn := range conns {
+		conn.close()
+	}
+
+	m.mu.Lock()
+	m.lua.Close()
+	m.mu.Unlock()
+}
+
+// memoryConn is the server side of one connection
+type memoryConn struct {
+	server *memoryServer
+	conn   net.Conn
+
+	// pending holds the encoded replies and messages not written yet, like
+	// the output buffer of a Redis client; ready signals there are some
+	outMu     sync.Mutex
+	pending   []byte
+	ready     chan struct{}
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	// The transaction being queued between MULTI and EXEC; aborted when a
+	// queued command was invalid
+	multi   bool
+	queued  [][]string
+	aborted bool
+
+	// patterns are the pub/sub patterns subscribed to, guarded by the
+	// server's connsMu
+	patterns map[string]bool
+}
+
+// serve reads and runs commands until the connection is closed
+func (c *memoryConn) serve() {
+	defer c.close()
+
+	reader := bufio.NewReader(c.conn)
+	for {
+		args, err := readMemoryCommand(reader)
+		if err != nil {
+			return
+		}
+		var reply []byte
+		for _, r := range c.handle(args) {
+			reply = appendReply(reply, r)
+		}
+		c.send(reply, 0)
+	}
+}
+
+// write passes the pending replies and messages to the client. Commands are
+// read meanwhile, so a client writing a long pipeline before it reads the
+// replies doesn't block.
+func (c *memoryConn) write() {
+	for {
+		select {
+		case <-c.ready:
+			c.outMu.Lock()
+			data := c.pending
+			c.pending = nil
+			c.outMu.Unlock()
+			if _, err := c.conn.Write(data); err != nil {
+				c.close()
+				return
+			}
+		case <-c.closed:
+			return
+		}
+	}
+}
+
+// send adds data to the pending output; with a limit, it disconnects the
+// client instead once more than limit bytes are pending, rather than holding
+// up a publisher
+func (c *memoryConn) send(data []byte, limit int) {
+	c.outMu.Lock()
+	full := limit > 0 && len(c.pending)+len(data) > limit
+	if !full {
+		c.pending = append(c.pending, data...)
+	}
+	c.outMu.Unlock()
+
+	if full {
+		go c.close()
+		return
+	}
+	select {
+	case c.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (c *memoryConn) close() {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.conn.Close()
+
+		c.server.connsMu.Lock()
+		delete(c.server.conns, c)
+		c.server.connsMu.Unlock()
+	})
+}
+
+// handle runs the commands that depend on the state of the connection, and
+// passes the others to the server; it returns the replies to send, of which
+// only pub/sub commands have more than one
+func (c *memoryConn) handle(args []string) []interface{} {
+	name := strings.ToLower(args[0])
+	switch name {
+	case "multi":
+		if c.multi {
+			return []interface{}{memoryError("ERR MULTI calls can not be nested")}
+		}
+		c.multi = true
+		return []interface{}{memoryOK}
+	case "exec":
+		if !c.multi {
+			return []interface{}{memoryError("ERR EXEC without MULTI")}
+		}
+		queued, aborted := c.queued, c.aborted
+		c.multi, c.queued, c.aborted = false, nil, false
+		if aborted {
+			return []interface{}{memoryError("EXECABORT Transaction discarded because of previous errors.")}
+		}
+		return []interface{}{c.server.runAll(queued)}
+	case "discard":
+		if !c.multi {
+			return []interface{}{memoryError("ERR DISCARD without MULTI")}
+		}
+		c.multi, c.queued, c.aborted = false, nil, false
+		return []interface{}{memoryOK}
+	case "psubscribe":
+		return c.psubscribe(args[1:])
+	case "punsubscribe":
+		return c.punsubscribe(args[1:])
+	}
+
+	if c.multi {
+		if err := checkMemoryCommand(args); err != nil {
+			c.aborted = true
+			return []interface{}{err}
+		}
+		c.queued = append(c.queued, args)
+		return []interface{}{memoryStatus("QUEUED")}
+	}
+	if c.subscribed() {
+		if name == "ping" {
+			return []interface{}{[]interface{}{"pong", ""}}
+		}
+		return []interface{}{memoryError(fmt.Sprintf("ERR Can't execute '%s': only PSUBSCRIBE / PUNSUBSCRIBE / PING are allowed in this context", name))}
+	}
+	return []interface{}{c.server.runAll([][]string{args})[0]}
+}
+
+func (c *memoryConn) subscribed() bool {
+	c.server.connsMu.Lock()
+	defer c.server.connsMu.Unlock()
+	return len(c.patterns) > 0
+}
+
+func (c *memoryConn) psubscribe(patterns []string) []interface{} {
+	if len(patterns) == 0 {
+		return []interface{}{errWrongArgs("psubscribe")}
+	}
+	c.server.connsMu.Lock()
+	defer c.server.connsMu.Unlock()
+
+	if c.patterns == nil {
+		c.patterns = make(map[string]bool)
+	}
+	replies := make([]interface{}, len(patterns))
+	for i, pattern := range patterns {
+		c.patterns[pattern] = true
+		replies[i] = []interface{}{"psubscribe", pattern, int64(len(c.patterns))}
+	}
+	return replies
+}
+
+func (c *memoryConn) punsubscribe(patterns []string) []interface{} {
+	c.server.connsMu.Lock()
+	defer c.server.connsMu.Unlock()
+
+	if len(patterns) == 0 {
+		for pattern := range c.patterns {
+			patterns = append(patterns, pattern)
+		}
+	}
+	if len(patterns) == 0 {
+		return []interface{}{[]interface{}{"punsubscribe", nil, int64(0)}}
+	}
+	replies := make([]interface{}, len(patterns))
+	for i, pattern := range patterns {
+		delete(c.patterns, pattern)
+		replies[i] = []interface{}{"punsubscribe", pattern, int64(len(c.patterns))}
+	}
+	return replies
+}
+
+// publish delivers a message to the connections subscribed to a pattern
+// matching channel, and returns how many received it
+func (m *memoryServer) publish(channel, payload string) int64 {
+	m.connsMu.Lock()
+	defer m.connsMu.Unlock()
+
+	var receivers int64
+	for conn := range m.conns {
+		for pattern := range conn.patterns {
+			if memoryMatch(pattern, channel) {
+				conn.send(appendReply(nil, []interface{}{"pmessage", pattern, channel, payload}), memoryPubSubLimit)
+				receivers++
+			}
+		}
+	}
+	return receivers
+}
+
+// Replies other than bulk strings (string), integers (int64), nil and
+// arrays ([]interface{})
+type (
+	memoryStatus string
+	memoryError  string
+)
+
+const memoryOK = memoryStatus("OK")
+
+func errWrongArgs(name string) memoryError {
+	return memoryError(fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(name)))
+}
+
+// readMemoryCommand reads one command, sent as an array of bulk strings
+func readMemoryCommand(r *bufio.Reader) ([]string, error) {
+	n, err := readMemoryHeader(r, '*')
+	if err != nil {
+		return nil, err
+	}
+	if n <= 0 {
+		return nil, errors.New("empty command")
+	}
+	args := make([]string, n)
+	for i := range args {
+		size, err := readMemoryHeader(r, '$')
+		if err != nil {
+			return nil, err
+		}
+		if size < 0 {
+			return nil, errors.New("invalid bulk string length")
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+// readMemoryHeader reads a line holding prefix and a length
+func readMemoryHeader(r *bufio.Reader, prefix byte) (int, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return 0, err
+	}
+	line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+	if len(line) < 2 || line[0] != prefix {
+		return 0, fmt.Errorf("unexpected %q", line)
+	}
+	return strconv.Atoi(line[1:])
+}
+
+// appendReply encodes a reply in the protocol go-redis reads
+func appendReply(b []byte, reply interface{}) []byte {
+	switch v := reply.(type) {
+	case nil:
+		return append(b, "$-1\r\n"...)
+	case memoryStatus:
+		return append(append(append(b, '+'), v...), "\r\n"...)
+	case memoryError:
+		line := strings.NewReplacer("\r", " ", "\n", " ").Replace(string(v))
+		return append(append(append(b, '-'), line...), "\r\n"...)
+	case int64:
+		return append(strconv.AppendInt(append(b, ':'), v, 10), "\r\n"...)
+	case string:
+		b = append(strconv.AppendInt(append(b, '$'), int64(len(v)), 10), "\r\n"...)
+		return append(append(b, v...), "\r\n"...)
+	case []interface{}:
+		b = append(strconv.AppendInt(append(b, '*'), int64(len(v)), 10), "\r\n"...)
+		for _, item := range v {
+			b = appendReply(b, item)
+		}
+		return b
+	default:
+		panic(fmt.Sprintf("unexpected reply %T", reply))
+	}
+}
+
+// memoryMatch reports whether s matches the glob-style pattern, which like
+// in Redis may hold *, ?, [...] classes and \ escapes
+func memoryMatch(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if memoryMatch(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+			end := strings.IndexByte(pattern[1:], ']')
+			if end < 0 {
+				return false
+			}
+			class := pattern[1 : end+1]
+			negate := len(class) > 0 && class[0] == '^'
+			if negate {
+				class = class[1:]
+			}
+			matched := false
+			for i := 0; i < len(class); i++ {
+				if i+2 < len(class) && class[i+1] == '-' {
+					if class[i] <= s[0] && s[0] <= class[i+2] {
+						matched = true
+					}
+					i += 2
+				} else if class[i] == s[0] {
+					matched = true
+				}
+			}
+			if matched == negate {
+				return false
+			}
+			pattern = pattern[end+1:]
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(s) == 0 || s[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern, s = pattern[1:], s[1:]
+	}
+	return len(s) == 0
+}
@@ -0,0 +1,862 @@
+package storage
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// memoryEntry is one key of the in-memory backend; value is a string, a
+// memoryHash, a memorySet or a *memoryZSet
+type memoryEntry struct {
+	value    interface{}
+	expireAt time.Time
+}
+
+func (e *memoryEntry) expired(now time.Time) bool {
+	return !e.expireAt.IsZero() && !now.Before(e.expireAt)
+}
+
+type (
+	memoryHash map[string]string
+	memorySet  map[string]struct{}
+)
+
+// memoryCommand runs one command; arity counts the name too, and a
+// negative arity is a minimum
+type memoryCommand struct {
+	arity int
+	run   func(m *memoryServer, args []string) interface{}
+}
+
+// memoryCommands are the commands of the in-memory backend: the ones the
+// storage code and its scripts send
+var memoryCommands map[string]memoryCommand
+
+func init() {
+	memoryCommands = map[string]memoryCommand{
+		"ping":             {-1, memoryPing},
+		"select":           {2, memorySelect},
+		"publish":          {3, memoryPublish},
+		"eval":             {-3, memoryEval},
+		"evalsha":          {-3, memoryEvalSha},
+		"script":           {-2, memoryScript},
+		"del":              {-2, memoryDel},
+		"exists":           {-2, memoryExists},
+		"pexpireat":        {3, memoryPExpireAt},
+		"scan":             {-2, memoryScan},
+		"get":              {2, memoryGet},
+		"mget":             {-2, memoryMGet},
+		"set":              {-3, memorySetCmd},
+		"setnx":            {3, memorySetNX},
+		"incr":             {2, memoryIncr},
+		"hset":             {-4, memoryHSet},
+		"hsetnx":           {4, memoryHSetNX},
+		"hget":             {3, memoryHGet},
+		"hmget":            {-3, memoryHMGet},
+		"hgetall":          {2, memoryHGetAll},
+		"hkeys":            {2, memoryHKeys},
+		"hdel":             {-3, memoryHDel},
+		"hexists":          {3, memoryHExists},
+		"sadd":             {-3, memorySAdd},
+		"srem":             {-3, memorySRem},
+		"smembers":         {2, memorySMembers},
+		"scard":            {2, memorySCard},
+		"sismember":        {3, memorySIsMember},
+		"zadd":             {-4, memoryZAdd},
+		"zrem":             {-3, memoryZRem},
+		"zcard":            {2, memoryZCard},
+		"zrangebyscore":    {-4, memoryZRangeByScore},
+		"zrevrangebyscore": {-4, memoryZRevRangeByScore},
+		"zrevrange":        {-4, memoryZRevRange},
+		"zremrangebyscore": {4, memoryZRemRangeByScore},
+	}
+}
+
+var (
+	errWrongType   = memoryError("WRONGTYPE Operation against a key holding the wrong kind of value")
+	errSyntax      = memoryError("ERR syntax error")
+	errNotInteger  = memoryError("ERR value is not an integer or out of range")
+	errNotFloat    = memoryError("ERR value is not a valid float")
+	errScoreFormat = memoryError("ERR min or max is not a float")
+)
+
+// checkMemoryCommand returns the error running args would fail with before
+// it starts: an unknown command or a wrong number of arguments
+func checkMemoryCommand(args []string) interface{} {
+	cmd, ok := memoryCommands[strings.ToLower(args[0])]
+	if !ok {
+		return memoryError(fmt.Sprintf("ERR unknown command '%s'", args[0]))
+	}
+	if cmd.arity > 0 && len(args) != cmd.arity || cmd.arity < 0 && len(args) < -cmd.arity {
+		return errWrongArgs(args[0])
+	}
+	return nil
+}
+
+// runAll runs commands one after another without letting other commands in
+// between, and returns their replies
+func (m *memoryServer) runAll(commands [][]string) []interface{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	replies := make([]interface{}, len(commands))
+	for i, args := range commands {
+		replies[i] = m.run(args)
+	}
+	return replies
+}
+
+// run runs one command; the caller holds mu
+func (m *memoryServer) run(args []string) interface{} {
+	if err := checkMemoryCommand(args); err != nil {
+		return err
+	}
+	return memoryCommands[strings.ToLower(args[0])].run(m, args)
+}
+
+// lookup returns the entry of key, or nil when it doesn't exist or expired
+func (m *memoryServer) lookup(key string) *memoryEntry {
+	entry, ok := m.entries[key]
+	if !ok {
+		return nil
+	}
+	if entry.expired(time.Now()) {
+		delete(m.entries, key)
+		return nil
+	}
+	return entry
+}
+
+// str returns the string stored at key; ok is false when key holds another
+// type
+func (m *memoryServer) str(key string) (value string, exists, ok bool) {
+	entry := m.lookup(key)
+	if entry == nil {
+		return "", false, true
+	}
+	value, ok = entry.value.(string)
+	return value, true, ok
+}
+
+// hash returns the hash stored at key, creating it when create is set; ok
+// is false when key holds another type
+func (m *memoryServer) hash(key string, create bool) (hash memoryHash, ok bool) {
+	entry := m.lookup(key)
+	if entry == nil {
+		if create {
+			hash = memoryHash{}
+			m.entries[key] = &memoryEntry{value: hash}
+		}
+		return hash, true
+	}
+	hash, ok = entry.value.(memoryHash)
+	return hash, ok
+}
+
+func (m *memoryServer) set(key string, create bool) (set memorySet, ok bool) {
+	entry := m.lookup(key)
+	if entry == nil {
+		if create {
+			set = memorySet{}
+			m.entries[key] = &memoryEntry{value: set}
+		}
+		return set, true
+	}
+	set, ok = entry.value.(memorySet)
+	return set, ok
+}
+
+func (m *memoryServer) zset(key string, create bool) (zset *memoryZSet, ok bool) {
+	entry := m.lookup(key)
+	if entry == nil {
+		if create {
+			zset = &memoryZSet{scores: make(map[string]float64)}
+			m.entries[key] = &memoryEntry{value: zset}
+		}
+		return zset, true
+	}
+	zset, ok = entry.value.(*memoryZSet)
+	return zset, ok
+}
+
+// dropEmpty removes key once its hash, set or sorted set is empty, as Redis
+// does
+func (m *memoryServer) dropEmpty(key string, size int) {
+	if size == 0 {
+		delete(m.entries, key)
+	}
+}
+
+func memoryPing(m *memoryServer, args []string) interface{} {
+	switch len(args) {
+	case 1:
+		return memoryStatus("PONG")
+	case 2:
+		return args[1]
+	}
+	return errWrongArgs(args[0])
+}
+
+func memorySelect(m *memoryServer, args []string) interface{} {
+	if args[1] != "0" {
+		return memoryError("ERR DB index is out of range")
+	}
+	return memoryOK
+}
+
+func memoryPublish(m *memoryServer, args []string) interface{} {
+	return m.publish(args[1], args[2])
+}
+
+func memoryDel(m *memoryServer, args []string) interface{} {
+	var deleted int64
+	for _, key := range args[1:] {
+		if m.lookup(key) != nil {
+			delete(m.entries, key)
+			deleted++
+		}
+	}
+	return deleted
+}
+
+func memoryExists(m *memoryServer, args []string) interface{} {
+	var found int64
+	for _, key := range args[1:] {
+		if m.lookup(key) != nil {
+			found++
+		}
+	}
+	return found
+}
+
+func memoryPExpireAt(m *memoryServer, args []string) interface{} {
+	at, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		return errNotInteger
+	}
+	entry := m.lookup(args[1])
+	if entry == nil {
+		return int64(0)
+	}
+	entry.expireAt = time.UnixMilli(at)
+	if entry.expired(time.Now()) {
+		delete(m.entries, args[1])
+	}
+	return int64(1)
+}
+
+// memoryScan pages through the keys in sorted order; the cursor is the
+// position of the next key
+func memoryScan(m *memoryServer, args []string) interface{} {
+	cursor, err := strconv.Atoi(args[1])
+	if err != nil || cursor < 0 {
+		return memoryError("ERR invalid cursor")
+	}
+	pattern, count := "*", 10
+	for i := 2; i < len(args); i += 2 {
+		if i+1 == len(args) {
+			return errSyntax
+		}
+		switch strings.ToLower(args[i]) {
+		case "match":
+			pattern = args[i+1]
+		case "count":
+			if count, err = strconv.Atoi(args[i+1]); err != nil || count < 1 {
+				return errSyntax
+			}
+		default:
+			return errSyntax
+		}
+	}
+
+	now := time.Now()
+	keys := make([]string, 0, len(m.entries))
+	for key, entry := range m.entries {
+		if !entry.expired(now) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	matched := []interface{}{}
+	next := cursor
+	for ; next < len(keys) && next < cursor+count; next++ {
+		if memoryMatch(pattern, keys[next]) {
+			matched = append(matched, keys[next])
+		}
+	}
+	if next >= len(keys) {
+		next = 0
+	}
+	return []interface{}{strconv.Itoa(next), matched}
+}
+
+func memoryGet(m *memoryServer, args []string) interface{} {
+	value, exists, ok := m.str(args[1])
+	if !ok {
+		return errWrongType
+	}
+	if !exists {
+		return nil
+	}
+	return value
+}
+
+func memoryMGet(m *memoryServer, args []string) interface{} {
+	values := make([]interface{}, len(args)-1)
+	for i, key := range args[1:] {
+		if value, exists, ok := m.str(key); exists && ok {
+			values[i] = value
+		}
+	}
+	return values
+}
+
+// memorySetCmd is SET key value [NX|XX] [EX seconds|PX milliseconds|KEEPTTL]
+func memorySetCmd(m *memoryServer, args []string) interface{} {
+	var nx, xx, keepTTL bool
+	var expireAt time.Time
+	for i := 3; i < len(args); i++ {
+		switch option := strings.ToLower(args[i]); option {
+		case "nx":
+			nx = true
+		case "xx":
+			xx = true
+		case "keepttl":
+			keepTTL = true
+		case "ex", "px":
+			if i+1 == len(args) {
+				return errSyntax
+			}
+			n, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil || n <= 0 {
+				return memoryError("ERR invalid expire time in 'set' command")
+			}
+			unit := time.Second
+			if option == "px" {
+				unit = time.Millisecond
+			}
+			expireAt = time.Now().Add(time.Duration(n) * unit)
+			i++
+		default:
+			return errSyntax
+		}
+	}
+	if nx && xx {
+		return errSyntax
+	}
+
+	existing := m.lookup(args[1])
+	if nx && existing != nil || xx && existing == nil {
+		return nil
+	}
+	if keepTTL && existing != nil {
+		expireAt = existing.expireAt
+	}
+	m.entries[args[1]] = &memoryEntry{value: args[2], expireAt: expireAt}
+	return memoryOK
+}
+
+func memorySetNX(m *memoryServer, args []string) interface{} {
+	if m.lookup(args[1]) != nil {
+		return int64(0)
+	}
+	m.entries[args[1]] = &memoryEntry{value: args[2]}
+	return int64(1)
+}
+
+func memoryIncr(m *memoryServer, args []string) interface{} {
+	value, exists, ok := m.str(args[1])
+	if !ok {
+		return errWrongType
+	}
+	var n int64
+	if exists {
+		var err error
+		if n, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return errNotInteger
+		}
+	}
+	if n == math.MaxInt64 {
+		return memoryError("ERR increment or decrement would overflow")
+	}
+	n++
+	if exists {
+		m.entries[args[1]].value = strconv.FormatInt(n, 10)
+	} else {
+		m.entries[args[1]] = &memoryEntry{value: strconv.FormatInt(n, 10)}
+	}
+	return n
+}
+
+func memoryHSet(m *memoryServer, args []string) interface{} {
+	if len(args)%2 != 0 {
+		return errWrongArgs(args[0])
+	}
+	hash, ok := m.hash(args[1], true)
+	if !ok {
+		return errWrongType
+	}
+	var added int64
+	for i := 2; i < len(args); i += 2 {
+		if _, exists := hash[args[i]]; !exists {
+			added++
+		}
+		hash[args[i]] = args[i+1]
+	}
+	return added
+}
+
+func memoryHSetNX(m *memoryServer, args []string) interface{} {
+	hash, ok := m.hash(args[1], true)
+	if !ok {
+		return errWrongType
+	}
+	if _, exists := hash[args[2]]; exists {
+		return int64(0)
+	}
+	hash[args[2]] = args[3]
+	return int64(1)
+}
+
+func memoryHGet(m *memoryServer, args []string) interface{} {
+	hash, ok := m.hash(args[1], false)
+	if !ok {
+		return errWrongType
+	}
+	if value, exists := hash[args[2]]; exists {
+		return value
+	}
+	return nil
+}
+
+func memoryHMGet(m *memoryServer, args []string) interface{} {
+	hash, ok := m.hash(args[1], false)
+	if !ok {
+		return errWrongType
+	}
+	values := make([]interface{}, len(args)-2)
+	for i, field := range args[2:] {
+		if value, exists := hash[field]; exists {
+			values[i] = value
+		}
+	}
+	return values
+}
+
+func memoryHGetAll(m *memoryServer, args []string) interface{} {
+	hash, ok := m.hash(args[1], false)
+	if !ok {
+		return errWrongType
+	}
+	values := make([]interface{}, 0, 2*len(hash))
+	for field, value := range hash {
+		values = append(values, field, value)
+	}
+	return values
+}
+
+func memoryHKeys(m *memoryServer, args []string) interface{} {
+	hash, ok := m.hash(args[1], false)
+	if !ok {
+		return errWrongType
+	}
+	fields := make([]interface{}, 0, len(hash))
+	for field := range hash {
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+func memoryHDel(m *memoryServer, args []string) interface{} {
+	hash, ok := m.hash(args[1], false)
+	if !ok {
+		return errWrongType
+	}
+	var deleted int64
+	for _, field := range args[2:] {
+		if _, exists := hash[field]; exists {
+			delete(hash, field)
+			deleted++
+		}
+	}
+	if hash != nil {
+		m.dropEmpty(args[1], len(hash))
+	}
+	return deleted
+}
+
+func memoryHExists(m *memoryServer, args []string) interface{} {
+	hash, ok := m.hash(args[1], false)
+	if !ok {
+		return errWrongType
+	}
+	if _, exists := hash[args[2]]; exists {
+		return int64(1)
+	}
+	return int64(0)
+}
+
+func memorySAdd(m *memoryServer, args []string) interface{} {
+	set, ok := m.set(args[1], true)
+	if !ok {
+		return errWrongType
+	}
+	var added int64
+	for _, member := range args[2:] {
+		if _, exists := set[member]; !exists {
+			set[member] = struct{}{}
+			added++
+		}
+	}
+	return added
+}
+
+func memorySRem(m *memoryServer, args []string) interface{} {
+	set, ok := m.set(args[1], false)
+	if !ok {
+		return errWrongType
+	}
+	var removed int64
+	for _, member := range args[2:] {
+		if _, exists := set[member]; exists {
+			delete(set, member)
+			removed++
+		}
+	}
+	if set != nil {
+		m.dropEmpty(args[1], len(set))
+	}
+	return removed
+}
+
+func memorySMembers(m *memoryServer, args []string) interface{} {
+	set, ok := m.set(args[1], false)
+	if !ok {
+		return errWrongType
+	}
+	members := make([]interface{}, 0, len(set))
+	for member := range set {
+		members = append(members, member)
+	}
+	return members
+}
+
+func memorySCard(m *memoryServer, args []string) interface{} {
+	set, ok := m.set(args[1], false)
+	if !ok {
+		return errWrongType
+	}
+	return int64(len(set))
+}
+
+func memorySIsMember(m *memoryServer, args []string) interface{} {
+	set, ok := m.set(args[1], false)
+	if !ok {
+		return errWrongType
+	}
+	if _, exists := set[args[2]]; exists {
+		return int64(1)
+	}
+	return int64(0)
+}
+
+func memoryZAdd(m *memoryServer, args []string) interface{} {
+	if len(args)%2 != 0 {
+		return errSyntax
+	}
+	scores := make([]float64, 0, (len(args)-2)/2)
+	for i := 2; i < len(args); i += 2 {
+		score, err := strconv.ParseFloat(args[i], 64)
+		if err != nil || math.IsNaN(score) {
+			return errNotFloat
+		}
+		scores = append(scores, score)
+	}
+	zset, ok := m.zset(args[1], true)
+	if !ok {
+		return errWrongType
+	}
+	var added int64
+	for i, score := range scores {
+		if zset.add(args[3+2*i], score) {
+			added++
+		}
+	}
+	return added
+}
+
+func memoryZRem(m *memoryServer, args []string) interface{} {
+	zset, ok := m.zset(args[1], false)
+	if !ok {
+		return errWrongType
+	}
+	if zset == nil {
+		return int64(0)
+	}
+	var removed int64
+	for _, member := range args[2:] {
+		if zset.remove(member) {
+			removed++
+		}
+	}
+	m.dropEmpty(args[1], len(zset.members))
+	return removed
+}
+
+func memoryZCard(m *memoryServer, args []string) interface{} {
+	zset, ok := m.zset(args[1], false)
+	if !ok {
+		return errWrongType
+	}
+	if zset == nil {
+		return int64(0)
+	}
+	return int64(len(zset.members))
+}
+
+func memoryZRangeByScore(m *memoryServer, args []string) interface{} {
+	return m.zrangeByScore(args, false)
+}
+
+func memoryZRevRangeByScore(m *memoryServer, args []string) interface{} {
+	return m.zrangeByScore(args, true)
+}
+
+// zrangeByScore is Z[REV]RANGEBYSCORE key min max [WITHSCORES] [LIMIT
+// offset count], with max before min when reversed
+func (m *memoryServer) zrangeByScore(args []string, reverse bool) interface{} {
+	minArg, maxArg := args[2], args[3]
+	if reverse {
+		minArg, maxArg = maxArg, minArg
+	}
+	min, err := parseScoreBound(minArg)
+	if err != nil {
+		return errScoreFormat
+	}
+	max, err := parseScoreBound(maxArg)
+	if err != nil {
+		return errScoreFormat
+	}
+	withScores, offset, count := false, 0, -1
+	for i := 4; i < len(args); i++ {
+		switch strings.ToLower(args[i]) {
+		case "withscores":
+			withScores = true
+		case "limit":
+			if i+2 >= len(args) {
+				return errSyntax
+			}
+			if offset, err = strconv.Atoi(args[i+1]); err != nil {
+				return errNotInteger
+			}
+			if count, err = strconv.Atoi(args[i+2]); err != nil {
+				return errNotInteger
+			}
+			i += 2
+		default:
+			return errSyntax
+		}
+	}
+
+	zset, ok := m.zset(args[1], false)
+	if !ok {
+		return errWrongType
+	}
+	result := []interface{}{}
+	if zset == nil || offset < 0 {
+		return result
+	}
+	lo, hi := zset.rangeByScore(min, max)
+	for i := offset; lo+i < hi && (count < 0 || i < offset+count); i++ {
+		member := zset.members[lo+i]
+		if reverse {
+			member = zset.members[hi-1-i]
+		}
+		result = appendZMember(result, member, withScores)
+	}
+	return result
+}
+
+// memoryZRevRange is ZREVRANGE key start stop [WITHSCORES]
+func memoryZRevRange(m *memoryServer, args []string) interface{} {
+	start, err := strconv.Atoi(args[2])
+	if err != nil {
+		return errNotInteger
+	}
+	stop, err := strconv.Atoi(args[3])
+	if err != nil {
+		return errNotInteger
+	}
+	if len(args) > 5 || len(args) == 5 && !strings.EqualFold(args[4], "withscores") {
+		return errSyntax
+	}
+	withScores := len(args) == 5
+
+	zset, ok := m.zset(args[1], false)
+	if !ok {
+		return errWrongType
+	}
+	result := []interface{}{}
+	if zset == nil {
+		return result
+	}
+	size := len(zset.members)
+	if start < 0 {
+		start += size
+	}
+	if stop < 0 {
+		stop += size
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= size {
+		stop = size - 1
+	}
+	for i := start; i <= stop; i++ {
+		result = appendZMember(result, zset.members[size-1-i], withScores)
+	}
+	return result
+}
+
+func memoryZRemRangeByScore(m *memoryServer, args []string) interface{} {
+	min, err := parseScoreBound(args[2])
+	if err != nil {
+		return errScoreFormat
+	}
+	max, err := parseScoreBound(args[3])
+	if err != nil {
+		return errScoreFormat
+	}
+	zset, ok := m.zset(args[1], false)
+	if !ok {
+		return errWrongType
+	}
+	if zset == nil {
+		return int64(0)
+	}
+	lo, hi := zset.rangeByScore(min, max)
+	for _, member := range zset.members[lo:hi] {
+		delete(zset.scores, member.member)
+	}
+	zset.members = append(zset.members[:lo], zset.members[hi:]...)
+	m.dropEmpty(args[1], len(zset.members))
+	return int64(hi - lo)
+}
+
+func appendZMember(result []interface{}, member memoryZMember, withScores bool) []interface{} {
+	result = append(result, member.member)
+	if withScores {
+		result = append(result, formatScore(member.score))
+	}
+	return result
+}
+
+func formatScore(score float64) string {
+	switch {
+	case math.IsInf(score, 1):
+		return "inf"
+	case math.IsInf(score, -1):
+		return "-inf"
+	}
+	return strconv.FormatFloat(score, 'f', -1, 64)
+}
+
+// memoryZSet is a sorted set: the score of every member, and the members
+// ordered by score and then by member
+type memoryZSet struct {
+	scores  map[string]float64
+	members []memoryZMember
+}
+
+type memoryZMember struct {
+	score  float64
+	member string
+}
+
+func (a memoryZMember) less(b memoryZMember) bool {
+	return a.score < b.score || a.score == b.score && a.member < b.member
+}
+
+// search returns the position of member in members, or where it belongs
+func (z *memoryZSet) search(member memoryZMember) int {
+	return sort.Search(len(z.members), func(i int) bool {
+		return !z.members[i].less(member)
+	})
+}
+
+// add sets the score of member, and reports whether it is new
+func (z *memoryZSet) add(member string, score float64) bool {
+	old, exists := z.scores[member]
+	if exists {
+		if old == score {
+			return false
+		}
+		z.remove(member)
+	}
+	z.scores[member] = score
+	entry := memoryZMember{score: score, member: member}
+	i := z.search(entry)
+	z.members = append(z.members, memoryZMember{})
+	copy(z.members[i+1:], z.members[i:])
+	z.members[i] = entry
+	return !exists
+}
+
+func (z *memoryZSet) remove(member string) bool {
+	score, exists := z.scores[member]
+	if !exists {
+		return false
+	}
+	delete(z.scores, member)
+	i := z.search(memoryZMember{score: score, member: member})
+	z.members = append(z.members[:i], z.members[i+1:]...)
+	return true
+}
+
+// rangeByScore returns the positions [lo, hi) of the members scored
+// between min and max
+func (z *memoryZSet) rangeByScore(min, max scoreBound) (lo, hi int) {
+	lo = sort.Search(len(z.members), func(i int) bool {
+		return !min.below(z.members[i].score)
+	})
+	hi = sort.Search(len(z.members), func(i int) bool {
+		score := z.members[i].score
+		return score > max.value || max.exclusive && score == max.value
+	})
+	if hi < lo {
+		hi = lo
+	}
+	return lo, hi
+}
+
+// scoreBound is a score range limit such as 5, (5 or -inf
+type scoreBound struct {
+	value     float64
+	exclusive bool
+}
+
+// below reports whether score lies below the bound as a minimum: lower, or
+// equal to an exclusive bound
+func (b scoreBound) below(score float64) bool {
+	return score < b.value || b.exclusive && score == b.value
+}
+
+func parseScoreBound(arg string) (scoreBound, error) {
+	bound := scoreBound{}
+	if strings.HasPrefix(arg, "(") {
+		bound.exclusive = true
+		arg = arg[1:]
+	}
+	value, err := strconv.ParseFloat(arg, 64)
+	if err != nil || math.IsNaN(value) {
+		return bound, fmt.Errorf("invalid score %q", arg)
+	}
+	bound.value = value
+	return bound, nil
+}
@@ -0,0 +1,247 @@
+package storage
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// newScriptState creates the Lua state scripts run in, with the libraries
+// Redis offers scripts apart from cjson and friends, and redis.call and
+// redis.pcall running commands against the data
+func (m *memoryServer) newScriptState() *lua.LState {
+	state := lua.NewState(lua.Options{SkipOpenLibs: true})
+	for _, lib := range []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		state.Push(state.NewFunction(lib.open))
+		state.Push(lua.LString(lib.name))
+		state.Call(1, 0)
+	}
+	for _, name := range []string{"dofile", "loadfile", "load", "loadstring", "require", "module"} {
+		state.SetGlobal(name, lua.LNil)
+	}
+
+	api := state.NewTable()
+	api.RawSetString("call", state.NewFunction(m.scriptCall(true)))
+	api.RawSetString("pcall", state.NewFunction(m.scriptCall(false)))
+	api.RawSetString("status_reply", state.NewFunction(func(L *lua.LState) int {
+		reply := L.NewTable()
+		reply.RawSetString("ok", lua.LString(L.CheckString(1)))
+		L.Push(reply)
+		return 1
+	}))
+	api.RawSetString("error_reply", state.NewFunction(func(L *lua.LState) int {
+		reply := L.NewTable()
+		reply.RawSetString("err", lua.LString(L.CheckString(1)))
+		L.Push(reply)
+		return 1
+	}))
+	state.SetGlobal("redis", api)
+	return state
+}
+
+// scriptCall returns redis.call, which raises command errors, or
+// redis.pcall, which returns them as an error table
+func (m *memoryServer) scriptCall(raise bool) lua.LGFunction {
+	return func(L *lua.LState) int {
+		n := L.GetTop()
+		if n == 0 {
+			L.RaiseError("Please specify at least one argument for this redis lib call")
+		}
+		args := make([]string, n)
+		for i := range args {
+			switch arg := L.Get(i + 1).(type) {
+			case lua.LString:
+				args[i] = string(arg)
+			case lua.LNumber:
+				args[i] = arg.String()
+			default:
+				L.RaiseError("Lua redis lib command arguments must be strings or integers")
+			}
+		}
+
+		var reply interface{}
+		switch strings.ToLower(args[0]) {
+		case "eval", "evalsha", "script":
+			reply = memoryError("ERR This Redis command is not allowed from script")
+		default:
+			reply = m.run(args)
+		}
+		if err, ok := reply.(memoryError); ok && raise {
+			L.RaiseError("%s", string(err))
+		}
+		L.Push(replyToLua(L, reply))
+		return 1
+	}
+}
+
+// memoryEval is EVAL script numkeys [key ...] [arg ...]
+func memoryEval(m *memoryServer, args []string) interface{} {
+	fn, err := m.loadScript(args[1])
+	if err != nil {
+		return err
+	}
+	return m.runScript(fn, args[2:])
+}
+
+// memoryEvalSha is EVALSHA sha1 numkeys [key ...] [arg ...]
+func memoryEvalSha(m *memoryServer, args []string) interface{} {
+	fn, ok := m.scripts[strings.ToLower(args[1])]
+	if !ok {
+		return memoryError("NOSCRIPT No matching script. Please use EVAL.")
+	}
+	return m.runScript(fn, args[2:])
+}
+
+// memoryScript is SCRIPT LOAD, EXISTS or FLUSH
+func memoryScript(m *memoryServer, args []string) interface{} {
+	switch strings.ToLower(args[1]) {
+	case "load":
+		if len(args) != 3 {
+			return errWrongArgs("script|load")
+		}
+		if _, err := m.loadScript(args[2]); err != nil {
+			return err
+		}
+		return scriptSHA(args[2])
+	case "exists":
+		found := make([]interface{}, len(args)-2)
+		for i, sha := range args[2:] {
+			found[i] = int64(0)
+			if _, ok := m.scripts[strings.ToLower(sha)]; ok {
+				found[i] = int64(1)
+			}
+		}
+		return found
+	case "flush":
+		m.scripts = make(map[string]*lua.LFunction)
+		return memoryOK
+	}
+	return memoryError(fmt.Sprintf("ERR unknown subcommand '%s'", args[1]))
+}
+
+func scriptSHA(source string) string {
+	sum := sha1.Sum([]byte(source))
+	return hex.EncodeToString(sum[:])
+}
+
+// loadScript compiles source, or returns it compiled before
+func (m *memoryServer) loadScript(source string) (*lua.LFunction, interface{}) {
+	sha := scriptSHA(source)
+	if fn, ok := m.scripts[sha]; ok {
+		return fn, nil
+	}
+	fn, err := m.lua.LoadString(source)
+	if err != nil {
+		return nil, memoryError("ERR Error compiling script " + err.Error())
+	}
+	m.scripts[sha] = fn
+	return fn, nil
+}
+
+// runScript calls a script with numkeys keys followed by arguments
+func (m *memoryServer) runScript(fn *lua.LFunction, args []string) interface{} {
+	numKeys, err := strconv.Atoi(args[0])
+	if err != nil {
+		return errNotInteger
+	}
+	if numKeys < 0 {
+		return memoryError("ERR Number of keys can't be negative")
+	}
+	if numKeys > len(args)-1 {
+		return memoryError("ERR Number of keys can't be greater than number of args")
+	}
+
+	L := m.lua
+	L.SetGlobal("KEYS", stringsToLua(L, args[1:1+numKeys]))
+	L.SetGlobal("ARGV", stringsToLua(L, args[1+numKeys:]))
+	defer L.SetTop(0)
+
+	L.Push(fn)
+	if err := L.PCall(0, 1, nil); err != nil {
+		message := err.Error()
+		if apiErr, ok := err.(*lua.ApiError); ok {
+			message = apiErr.Object.String()
+		}
+		return memoryError("ERR Error running script: " + message)
+	}
+	return luaToReply(L.Get(-1))
+}
+
+func stringsToLua(L *lua.LState, values []string) *lua.LTable {
+	table := L.CreateTable(len(values), 0)
+	for _, value := range values {
+		table.Append(lua.LString(value))
+	}
+	return table
+}
+
+// replyToLua converts a command reply the way Redis passes it to scripts
+func replyToLua(L *lua.LState, reply interface{}) lua.LValue {
+	switch v := reply.(type) {
+	case nil:
+		return lua.LFalse
+	case int64:
+		return lua.LNumber(v)
+	case string:
+		return lua.LString(v)
+	case memoryStatus:
+		table := L.NewTable()
+		table.RawSetString("ok", lua.LString(v))
+		return table
+	case memoryError:
+		table := L.NewTable()
+		table.RawSetString("err", lua.LString(v))
+		return table
+	case []interface{}:
+		table := L.CreateTable(len(v), 0)
+		for _, item := range v {
+			table.Append(replyToLua(L, item))
+		}
+		return table
+	}
+	return lua.LNil
+}
+
+// luaToReply converts the value a script returns the way Redis does:
+// numbers are truncated to integers, and an array ends at its first nil
+func luaToReply(value lua.LValue) interface{} {
+	switch v := value.(type) {
+	case lua.LNumber:
+		return int64(v)
+	case lua.LString:
+		return string(v)
+	case lua.LBool:
+		if v {
+			return int64(1)
+		}
+		return nil
+	case *lua.LTable:
+		if err, ok := v.RawGetString("err").(lua.LString); ok {
+			return memoryError(err)
+		}
+		if status, ok := v.RawGetString("ok").(lua.LString); ok {
+			return memoryStatus(status)
+		}
+		items := []interface{}{}
+		for i := 1; ; i++ {
+			item := v.RawGetInt(i)
+			if item == lua.LNil {
+				return items
+			}
+			items = append(items, luaToReply(item))
+		}
+	}
+	return nil
+}
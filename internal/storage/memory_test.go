@@ -0,0 +1,196 @@
+package storage
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMemoryExpiry(t *testing.T) {
+	s := newMemoryTestStorage(t)
+
+	if err := s.SetJSON("lock", "first", 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if stored, err := s.SetJSONNX("lock", "second", time.Minute); err != nil || stored {
+		t.Fatalf("SetJSONNX on a live key: stored %v, err %v", stored, err)
+	}
+
+	time.Sleep(80 * time.Millisecond)
+	var value string
+	if found, err := s.GetJSON("lock", &value); err != nil || found {
+		t.Fatalf("expired key: found %v (%q), err %v", found, value, err)
+	}
+	if stored, err := s.SetJSONNX("lock", "second", time.Minute); err != nil || !stored {
+		t.Fatalf("SetJSONNX on an expired key: stored %v, err %v", stored, err)
+	}
+}
+
+func TestHistoryRanges(t *testing.T) {
+	eachBackend(t, func(t *testing.T, s *Storage) {
+		if err := s.CreateAPIKey(&APIKey{ID: "k", Key: "fk-history"}); err != nil {
+			t.Fatal(err)
+		}
+		base := time.Unix(1700000000, 0)
+		var snapshots []*Usage
+		for i := 0; i < 3; i++ {
+			snapshots = append(snapshots, &Usage{ID: "k", LastUpdated: base.Add(time.Duration(i) * time.Hour)})
+		}
+		if err := s.BatchAppendHistory(snapshots); err != nil {
+			t.Fatal(err)
+		}
+
+		history, err := s.GetHistory("k", base, base.Add(time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(history) != 2 || !history[0].LastUpdated.Equal(base) {
+			t.Fatalf("history in the first hour: %d snapshots", len(history))
+		}
+		latest, err := s.LatestSnapshotsBefore(map[string]time.Time{"k": base.Add(2 * time.Hour)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if latest["k"] == nil || !latest["k"].LastUpdated.Equal(base.Add(time.Hour)) {
+			t.Fatalf("latest snapshot before the last: %+v", latest["k"])
+		}
+		if removed, err := s.PruneHistory(base.Add(time.Hour)); err != nil || removed != 1 {
+			t.Fatalf("prune removed %d, err %v; want 1", removed, err)
+		}
+	})
+}
+
+func TestRateLimitScripts(t *testing.T) {
+	eachBackend(t, func(t *testing.T, s *Storage) {
+		for i, allowed := range []bool{true, true, false} {
+			result, err := s.TakeRateLimit("api", time.Minute, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Allowed != allowed {
+				t.Fatalf("request %d: allowed %v, want %v", i, result.Allowed, allowed)
+			}
+			if !allowed && result.RetryAfter <= 0 {
+				t.Fatalf("denied request without a retry time: %+v", result)
+			}
+		}
+
+		end := time.Now().Add(time.Hour)
+		for i, allowed := range []bool{true, true, false} {
+			ok, used, err := s.TakeQuota("daily", 2, end)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != allowed || used != min(i+1, 2) {
+				t.Fatalf("request %d: allowed %v with %d used", i, ok, used)
+			}
+		}
+	})
+}
+
+func TestPubSub(t *testing.T) {
+	eachBackend(t, func(t *testing.T, s *Storage) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		received := make(chan string, 10)
+		go s.Subscribe(ctx, "events:*", func(channel string, payload []byte) {
+			received <- channel + "=" + string(payload)
+		})
+
+		// Publish until the subscription is in place
+		ticker := time.NewTicker(10 * time.Millisecond)
+		defer ticker.Stop()
+		deadline := time.After(2 * time.Second)
+		for {
+			select {
+			case message := <-received:
+				if message != "events:keys=changed" {
+					t.Fatalf("received %q", message)
+				}
+				return
+			case <-ticker.C:
+				if err := s.Publish("other", []byte("ignored")); err != nil {
+					t.Fatal(err)
+				}
+				if err := s.Publish("events:keys", []byte("changed")); err != nil {
+					t.Fatal(err)
+				}
+			case <-deadline:
+				t.Fatal("no message received")
+			}
+		}
+	})
+}
+
+func TestCommands(t *testing.T) {
+	eachBackend(t, func(t *testing.T, s *Storage) {
+		client, ctx := s.redis.client, context.Background()
+
+		pipe := client.TxPipeline()
+		first := pipe.Incr(ctx, "counter")
+		second := pipe.Incr(ctx, "counter")
+		if _, err := pipe.Exec(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if first.Val() != 1 || second.Val() != 2 {
+			t.Fatalf("transaction results %d, %d", first.Val(), second.Val())
+		}
+
+		if err := client.HSet(ctx, "hash", "field", "value").Err(); err != nil {
+			t.Fatal(err)
+		}
+		if err := client.Get(ctx, "hash").Err(); err == nil || !strings.HasPrefix(err.Error(), "WRONGTYPE") {
+			t.Fatalf("GET on a hash: %v, want WRONGTYPE", err)
+		}
+		client.HDel(ctx, "hash", "field")
+		if n := client.Exists(ctx, "hash").Val(); n != 0 {
+			t.Fatal("emptied hash still exists")
+		}
+
+		for _, key := range []string{"bench:a", "bench:b", "other"} {
+			client.Set(ctx, key, "1", 0)
+		}
+		var found []string
+		var cursor uint64
+		for {
+			keys, next, err := client.Scan(ctx, cursor, "bench:*", 1).Result()
+			if err != nil {
+				t.Fatal(err)
+			}
+			found = append(found, keys...)
+			if next == 0 {
+				break
+			}
+			cursor = next
+		}
+		sort.Strings(found)
+		if strings.Join(found, ",") != "bench:a,bench:b" {
+			t.Fatalf("scan found %v", found)
+		}
+	})
+}
+
+func TestMemoryMatch(t *testing.T) {
+	tests := []struct {
+		pattern, s string
+		match      bool
+	}{
+		{"events:*", "events:keys", true},
+		{"events:*", "tenant:a:events:keys", false},
+		{"*:events:*", "tenant:a:events:keys", true},
+		{"key:?", "key:1", true},
+		{"key:?", "key:12", false},
+		{"key:[0-9]", "key:7", true},
+		{"key:[^0-9]", "key:7", false},
+		{`key:\*`, "key:*", true},
+		{`key:\*`, "key:1", false},
+	}
+	for _, tt := range tests {
+		if got := memoryMatch(tt.pattern, tt.s); got != tt.match {
+			t.Errorf("memoryMatch(%q, %q) = %v, want %v", tt.pattern, tt.s, got, tt.match)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RateLimitResult is the outcome of taking one request from a limit
+type RateLimitResult struct {
+	Allowed bool
+	// Remaining is how many more requests the limit allows right now
+	Remaining int
+	// RetryAfter is how long a denied request has to wait
+	RetryAfter time.Duration
+	// Reset is how long until the limit is fully available again
+	Reset time.Duration
+}
+
+// rateLimitSrc applies the generic cell rate algorithm: the key holds the
+// theoretical arrival time of the next request, which every request moves
+// one interval further and which may run ahead of now by burst intervals
+//
+// KEYS[1] limit key
+// ARGV[1] now (unix ms), ARGV[2] interval (ms), ARGV[3] burst
+const rateLimitSrc = `
+local now = tonumber(ARGV[1])
+local interval = tonumber(ARGV[2])
+local burst = tonumber(ARGV[3])
+local tolerance = interval * burst
+
+local tat = tonumber(redis.call('GET', KEYS[1]) or now)
+if tat < now then
+	tat = now
+end
+local newTat = tat + interval
+local allowAt = newTat - tolerance
+if allowAt > now then
+	return {0, 0, allowAt - now, tat - now}
+end
+redis.call('SET', KEYS[1], newTat, 'PX', math.ceil(newTat - now))
+return {1, math.floor((tolerance - (newTat - now)) / interval), 0, newTat - now}
+`
+
+var rateLimitScript = redis.NewScript(rateLimitSrc)
+
+// TakeRateLimit takes one request from the limit stored under name, which
+// allows burst requests at once and refills one every interval
+func (s *Storage) TakeRateLimit(name string, interval time.Duration, burst int) (*RateLimitResult, error) {
+	ctx := s.context()
+	now := time.Now().UnixMilli()
+	values, err := rateLimitScript.Run(ctx, s.redis.client, []string{s.ns("ratelimit:" + name)},
+		now, float64(interval)/float64(time.Millisecond), burst).Int64Slice()
+	if err != nil {
+		return nil, err
+	}
+	return &RateLimitResult{
+		Allowed:    values[0] == 1,
+		Remaining:  int(values[1]),
+		RetryAfter: time.Duration(values[2]) * time.Millisecond,
+		Reset:      time.Duration(values[3]) * time.Millisecond,
+	}, nil
+}
+
+// takeQuotaSrc counts a request against a quota that expires at the end of
+// its period, unless the quota is used up
+//
+// KEYS[1] quota key
+// ARGV[1] limit, ARGV[2] end of the period (unix ms)
+const takeQuotaSrc = `
+local used = tonumber(redis.call('GET', KEYS[1]) or 0)
+if used >= tonumber(ARGV[1]) then
+	return {0, used}
+end
+used = redis.call('INCR', KEYS[1])
+if used == 1 then
+	redis.call('PEXPIREAT', KEYS[1], ARGV[2])
+end
+return {1, used}
+`
+
+var takeQuotaScript = redis.NewScript(takeQuotaSrc)
+
+// TakeQuota counts one request against the quota stored under name for the
+// period ending at end, and returns whether it was allowed and how many
+// requests the period has used
+func (s *Storage) TakeQuota(name string, limit int, end time.Time) (bool, int, error) {
+	ctx := s.context()
+	values, err := takeQuotaScript.Run(ctx, s.redis.client, []string{s.ns("quota:" + name)},
+		limit, end.UnixMilli()).Int64Slice()
+	if err != nil {
+		return false, 0, err
+	}
+	return values[0] == 1, int(values[1]), nil
+}
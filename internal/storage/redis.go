@@ -3,31 +3,76 @@ package storage
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/redis/go-redis/v9"
 )
 
+// ErrNotFound is reported for batch items whose key does not exist
+var ErrNotFound = errors.New("not found")
+
 type RedisClient struct {
 	client *redis.Client
 	ctx    context.Context
+
+	// memory is set when the client talks to the in-memory backend
+	memory *memoryServer
+}
+
+// RedisOptions configures the Redis connection; Password and DB override
+// the values in URL when set (DB < 0 keeps the URL's database)
+type RedisOptions struct {
+	URL          string
+	Password     string
+	DB           int
+	PoolSize     int
+	MinIdleConns int
+	MaxRetries   int
+	DialTimeout  time.Duration
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	PoolTimeout  time.Duration
+}
+
+// DefaultRedisOptions returns the connection pool configuration tuned for
+// high concurrency
+func DefaultRedisOptions(url string) RedisOptions {
+	return RedisOptions{
+		URL:          url,
+		DB:           -1,
+		PoolSize:     100,
+		MinIdleConns: 10,
+		MaxRetries:   3,
+		DialTimeout:  5 * time.Second,
+		ReadTimeout:  3 * time.Second,
+		WriteTimeout: 3 * time.Second,
+		PoolTimeout:  4 * time.Second,
+	}
 }
 
-func NewRedisClient(redisURL string) (*RedisClient, error) {
-	opts, err := redis.ParseURL(redisURL)
+func NewRedisClient(options RedisOptions) (*RedisClient, error) {
+	opts, err := redis.ParseURL(options.URL)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse redis URL: %w", err)
 	}
+	if options.Password != "" {
+		opts.Password = options.Password
+	}
+	if options.DB >= 0 {
+		opts.DB = options.DB
+	}
 
-	// Connection pool configuration for high concurrency
-	opts.PoolSize = 100
-	opts.MinIdleConns = 10
-	opts.MaxRetries = 3
-	opts.DialTimeout = 5 * time.Second
-	opts.ReadTimeout = 3 * time.Second
-	opts.WriteTimeout = 3 * time.Second
-	opts.PoolTimeout = 4 * time.Second
+	// Connection pool configuration
+	opts.PoolSize = options.PoolSize
+	opts.MinIdleConns = options.MinIdleConns
+	opts.MaxRetries = options.MaxRetries
+	opts.DialTimeout = options.DialTimeout
+	opts.ReadTimeout = options.ReadTimeout
+	opts.WriteTimeout = options.WriteTimeout
+	opts.PoolTimeout = options.PoolTimeout
 
 	client := redis.NewClient(opts)
 
@@ -43,7 +88,11 @@ func NewRedisClient(redisURL string) (*RedisClient, error) {
 }
 
 func (r *RedisClient) Close() error {
-	return r.client.Close()
+	err := r.client.Close()
+	if r.memory != nil {
+		r.memory.close()
+	}
+	return err
 }
 
 func (r *RedisClient) GetClient() *redis.Client {
@@ -52,21 +101,87 @@ func (r *RedisClient) GetClient() *redis.Client {
 
 // Storage provides high-level storage operations
 type Storage struct {
-	redis *RedisClient
+	redis  *RedisClient
+	prefix string
+	ctx    context.Context
 }
 
 func NewStorage(redis *RedisClient) *Storage {
 	return &Storage{redis: redis}
 }
 
+// WithPrefix returns a storage sharing the same connection whose keys and
+// pub/sub channels are all namespaced under prefix
+func (s *Storage) WithPrefix(prefix string) *Storage {
+	return &Storage{redis: s.redis, prefix: prefix, ctx: s.ctx}
+}
+
+// WithContext returns a storage whose commands are canceled with ctx, e.g.
+// when the request or background job they belong to ends
+func (s *Storage) WithContext(ctx context.Context) *Storage {
+	return &Storage{redis: s.redis, prefix: s.prefix, ctx: ctx}
+}
+
+// context returns the context commands run under
+func (s *Storage) context() context.Context {
+	if s.ctx == nil {
+		return context.Background()
+	}
+	return s.ctx
+}
+
+// Prefix returns the namespace of the storage's keys
+func (s *Storage) Prefix() string {
+	return s.prefix
+}
+
+// ns namespaces a Redis key or channel name
+func (s *Storage) ns(name string) string {
+	return s.prefix + name
+}
+
 // API Key operations
 type APIKey struct {
-	ID        string    `json:"id"`
-	Key       string    `json:"key"`
-	Name      string    `json:"name"`
-	CreatedAt time.Time `json:"created_at"`
+	ID         string      `json:"id"`
+	Key        string      `json:"key"`
+	Name       string      `json:"name"`
+	Provider   string      `json:"provider,omitempty"`
+	Credential *Credential `json:"credential,omitempty"`
+	Group      string      `json:"group,omitempty"`
+	Tags       []string    `json:"tags,omitempty"`
+	Owner      string      `json:"owner,omitempty"`
+	Notes      string      `json:"notes,omitempty"`
+	// RefreshInterval overrides the cache TTL of the key's group, e.g. "1m"
+	RefreshInterval string     `json:"refresh_interval,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+	ExpiresAt       *time.Time `json:"expires_at,omitempty"`
+
+	// Disabled keys are kept but never refreshed
+	Disabled       bool       `json:"disabled,omitempty"`
+	DisabledAt     *time.Time `json:"disabled_at,omitempty"`
+	DisabledReason string     `json:"disabled_reason,omitempty"`
+	AutoDisabled   bool       `json:"auto_disabled,omitempty"`
+
+	// Deleted keys are kept until purged so they can be restored
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
 }
 
+// Credential describes how a key is presented to its upstream provider.
+// A nil credential means a bearer token.
+type Credential struct {
+	Type     string            `json:"type"`
+	Username string            `json:"username,omitempty"`
+	Name     string            `json:"name,omitempty"`
+	Headers  map[string]string `json:"headers,omitempty"`
+}
+
+// IsExpired reports whether the key has passed its expiry time
+func (k *APIKey) IsExpired(now time.Time) bool {
+	return k.ExpiresAt != nil && !now.Before(*k.ExpiresAt)
+}
+
+// Usage is the usage of a key as cached and kept in its history. It never
+// holds the key itself; the API form with the masked key is models.Usage.
 type Usage struct {
 	ID               string    `json:"id"`
 	StartDate        string    `json:"start_date"`
@@ -76,12 +191,18 @@ type Usage struct {
 	Remaining        float64   `json:"remaining"`
 	UsedRatio        float64   `json:"used_ratio"`
 	LastUpdated      time.Time `json:"last_updated"`
+	LatencyMs        int64     `json:"latency_ms,omitempty"`
 	Error            string    `json:"error,omitempty"`
+	// Blocks holds the JSON of the models.Usage blocks, kept opaque so the
+	// storage does not depend on the API model
+	Blocks        json.RawMessage `json:"blocks,omitempty"`
+	Partial       bool            `json:"partial,omitempty"`
+	MissingFields []string        `json:"missing_fields,omitempty"`
 }
 
 // SaveAPIKey stores an API key
 func (s *Storage) SaveAPIKey(key *APIKey) error {
-	ctx := context.Background()
+	ctx := s.context()
 	pipe := s.redis.client.Pipeline()
 
 	// Save key data
@@ -90,17 +211,40 @@ func (s *Storage) SaveAPIKey(key *APIKey) error {
 		return err
 	}
 
-	pipe.HSet(ctx, fmt.Sprintf("key:%s", key.ID), "data", keyData)
-	pipe.SAdd(ctx, "keys:list", key.ID)
+	searchData, err := searchEntryData(key)
+	if err != nil {
+		return err
+	}
+
+	pipe.HSet(ctx, s.ns(fmt.Sprintf("key:%s", key.ID)), "data", keyData)
+	pipe.SAdd(ctx, s.ns("keys:list"), key.ID)
+	pipe.HSet(ctx, s.ns(keySearchKey), key.ID, searchData)
 
 	_, err = pipe.Exec(ctx)
 	return err
 }
 
+// pipelineError returns the first error among the count commands queued for
+// one item starting at offset, or execErr when they were never run
+func pipelineError(cmds []redis.Cmder, offset, count int, execErr error) error {
+	if offset+count > len(cmds) {
+		if execErr != nil {
+			return execErr
+		}
+		return fmt.Errorf("missing pipeline result")
+	}
+	for _, cmd := range cmds[offset : offset+count] {
+		if err := cmd.Err(); err != nil && err != redis.Nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // GetAPIKey retrieves an API key
 func (s *Storage) GetAPIKey(id string) (*APIKey, error) {
-	ctx := context.Background()
-	data, err := s.redis.client.HGet(ctx, fmt.Sprintf("key:%s", id), "data").Result()
+	ctx := s.context()
+	data, err := s.redis.client.HGet(ctx, s.ns(fmt.Sprintf("key:%s", id)), "data").Result()
 	if err != nil {
 		if err == redis.Nil {
 			return nil, nil
@@ -113,19 +257,31 @@ func (s *Storage) GetAPIKey(id string) (*APIKey, error) {
 		return nil, err
 	}
 
+	// Deleted keys are only reachable through FindDeletedAPIKey
+	if key.DeletedAt != nil {
+		return nil, nil
+	}
+
 	return &key, nil
 }
 
 // GetAllAPIKeys retrieves all API keys
 func (s *Storage) GetAllAPIKeys() ([]*APIKey, error) {
-	ctx := context.Background()
+	ctx := s.context()
 	
 	// Get all key IDs
-	ids, err := s.redis.client.SMembers(ctx, "keys:list").Result()
+	ids, err := s.redis.client.SMembers(ctx, s.ns("keys:list")).Result()
 	if err != nil {
 		return nil, err
 	}
 
+	return s.GetAPIKeys(ids)
+}
+
+// GetAPIKeys retrieves the API keys with the given IDs in a single pipeline,
+// skipping those that don't exist
+func (s *Storage) GetAPIKeys(ids []string) ([]*APIKey, error) {
+	ctx := s.context()
 	if len(ids) == 0 {
 		return []*APIKey{}, nil
 	}
@@ -135,10 +291,10 @@ func (s *Storage) GetAllAPIKeys() ([]*APIKey, error) {
 	cmds := make([]*redis.StringCmd, len(ids))
 
 	for i, id := range ids {
-		cmds[i] = pipe.HGet(ctx, fmt.Sprintf("key:%s", id), "data")
+		cmds[i] = pipe.HGet(ctx, s.ns(fmt.Sprintf("key:%s", id)), "data")
 	}
 
-	_, err = pipe.Exec(ctx)
+	_, err := pipe.Exec(ctx)
 	if err != nil && err != redis.Nil {
 		return nil, err
 	}
@@ -163,68 +319,68 @@ func (s *Storage) GetAllAPIKeys() ([]*APIKey, error) {
 	return keys, nil
 }
 
-// DeleteAPIKey removes an API key
-func (s *Storage) DeleteAPIKey(id string) error {
-	ctx := context.Background()
-	pipe := s.redis.client.Pipeline()
-
-	pipe.Del(ctx, fmt.Sprintf("key:%s", id))
-	pipe.Del(ctx, fmt.Sprintf("key:%s:usage", id))
-	pipe.SRem(ctx, "keys:list", id)
-
-	_, err := pipe.Exec(ctx)
-	return err
+// MarkExpiryReminded records that an expiry reminder was sent for a key.
+// It returns false if a reminder had already been recorded.
+func (s *Storage) MarkExpiryReminded(id string, ttl time.Duration) (bool, error) {
+	ctx := s.context()
+	key := s.ns(fmt.Sprintf("key:%s:expiry_reminded", id))
+	return s.redis.client.SetNX(ctx, key, time.Now().Unix(), ttl).Result()
 }
 
-// BatchDeleteAPIKeys removes multiple API keys
-func (s *Storage) BatchDeleteAPIKeys(ids []string) (int, int) {
-	success := 0
-	failed := 0
+// failuresKey is the counter of consecutive usage fetch failures for a key
+func failuresKey(id string) string {
+	return fmt.Sprintf("key:%s:failures", id)
+}
 
-	// Use pipeline for batch deletion
-	ctx := context.Background()
+// IncrKeyFailures increments the consecutive failure counter of each key
+// and returns the new counts
+func (s *Storage) IncrKeyFailures(ids []string) (map[string]int64, error) {
+	ctx := s.context()
 	pipe := s.redis.client.Pipeline()
 
+	cmds := make(map[string]*redis.IntCmd, len(ids))
 	for _, id := range ids {
-		pipe.Del(ctx, fmt.Sprintf("key:%s", id))
-		pipe.Del(ctx, fmt.Sprintf("key:%s:usage", id))
-		pipe.SRem(ctx, "keys:list", id)
+		cmds[id] = pipe.Incr(ctx, s.ns(failuresKey(id)))
 	}
-
-	cmds, err := pipe.Exec(ctx)
-	if err != nil && err != redis.Nil {
-		failed = len(ids)
-		return success, failed
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
 	}
 
-	// Count successes
-	for i := 0; i < len(ids); i++ {
-		if i*3 < len(cmds) && cmds[i*3].Err() == nil {
-			success++
-		} else {
-			failed++
-		}
+	counts := make(map[string]int64, len(ids))
+	for id, cmd := range cmds {
+		counts[id] = cmd.Val()
 	}
+	return counts, nil
+}
 
-	return success, failed
+// ResetKeyFailures clears the consecutive failure counter of each key
+func (s *Storage) ResetKeyFailures(ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = s.ns(failuresKey(id))
+	}
+	return s.redis.client.Del(s.context(), keys...).Err()
 }
 
 // SaveUsage stores usage data with cache
 func (s *Storage) SaveUsage(usage *Usage, ttl time.Duration) error {
-	ctx := context.Background()
+	ctx := s.context()
 	data, err := json.Marshal(usage)
 	if err != nil {
 		return err
 	}
 
-	key := fmt.Sprintf("key:%s:usage", usage.ID)
+	key := s.ns(fmt.Sprintf("key:%s:usage", usage.ID))
 	return s.redis.client.Set(ctx, key, data, ttl).Err()
 }
 
 // GetUsage retrieves cached usage data
 func (s *Storage) GetUsage(id string) (*Usage, error) {
-	ctx := context.Background()
-	key := fmt.Sprintf("key:%s:usage", id)
+	ctx := s.context()
+	key := s.ns(fmt.Sprintf("key:%s:usage", id))
 	
 	data, err := s.redis.client.Get(ctx, key).Result()
 	if err != nil {
@@ -242,9 +398,63 @@ func (s *Storage) GetUsage(id string) (*Usage, error) {
 	return &usage, nil
 }
 
+// BatchGetUsage retrieves the cached usage of several keys; keys without
+// cached usage are omitted
+func (s *Storage) BatchGetUsage(ids []string) (map[string]*Usage, error) {
+	usages := make(map[string]*Usage, len(ids))
+	if len(ids) == 0 {
+		return usages, nil
+	}
+
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = s.ns(fmt.Sprintf("key:%s:usage", id))
+	}
+	values, err := s.redis.client.MGet(s.context(), keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	for i, value := range values {
+		data, ok := value.(string)
+		if !ok {
+			continue
+		}
+		var usage Usage
+		if err := json.Unmarshal([]byte(data), &usage); err != nil {
+			continue
+		}
+		usages[ids[i]] = &usage
+	}
+	return usages, nil
+}
+
+// DeleteUsage drops the cached usage of several keys and returns how many
+// were cached
+func (s *Storage) DeleteUsage(ids []string) (int, error) {
+	ctx := s.context()
+	deleted := 0
+	for start := 0; start < len(ids); start += 500 {
+		end := start + 500
+		if end > len(ids) {
+			end = len(ids)
+		}
+		keys := make([]string, 0, end-start)
+		for _, id := range ids[start:end] {
+			keys = append(keys, s.ns(fmt.Sprintf("key:%s:usage", id)))
+		}
+		n, err := s.redis.client.Del(ctx, keys...).Result()
+		if err != nil {
+			return deleted, err
+		}
+		deleted += int(n)
+	}
+	return deleted, nil
+}
+
 // BatchSaveUsage saves multiple usage records using pipeline
 func (s *Storage) BatchSaveUsage(usages []*Usage, ttl time.Duration) error {
-	ctx := context.Background()
+	ctx := s.context()
 	pipe := s.redis.client.Pipeline()
 
 	for _, usage := range usages {
@@ -252,7 +462,7 @@ func (s *Storage) BatchSaveUsage(usages []*Usage, ttl time.Duration) error {
 		if err != nil {
 			continue
 		}
-		key := fmt.Sprintf("key:%s:usage", usage.ID)
+		key := s.ns(fmt.Sprintf("key:%s:usage", usage.ID))
 		pipe.Set(ctx, key, data, ttl)
 	}
 
@@ -263,24 +473,27 @@ func (s *Storage) BatchSaveUsage(usages []*Usage, ttl time.Duration) error {
 // Session operations
 type Session struct {
 	ID        string    `json:"id"`
+	Role      string    `json:"role,omitempty"`
+	User      string    `json:"user,omitempty"`
 	CreatedAt time.Time `json:"created_at"`
 	ExpiresAt time.Time `json:"expires_at"`
+	Remember  bool      `json:"remember,omitempty"`
 }
 
 func (s *Storage) SaveSession(session *Session, ttl time.Duration) error {
-	ctx := context.Background()
+	ctx := s.context()
 	data, err := json.Marshal(session)
 	if err != nil {
 		return err
 	}
 
-	key := fmt.Sprintf("session:%s", session.ID)
+	key := s.ns(fmt.Sprintf("session:%s", session.ID))
 	return s.redis.client.Set(ctx, key, data, ttl).Err()
 }
 
 func (s *Storage) GetSession(id string) (*Session, error) {
-	ctx := context.Background()
-	key := fmt.Sprintf("session:%s", id)
+	ctx := s.context()
+	key := s.ns(fmt.Sprintf("session:%s", id))
 	
 	data, err := s.redis.client.Get(ctx, key).Result()
 	if err != nil {
@@ -299,21 +512,28 @@ func (s *Storage) GetSession(id string) (*Session, error) {
 }
 
 func (s *Storage) DeleteSession(id string) error {
-	ctx := context.Background()
-	key := fmt.Sprintf("session:%s", id)
+	ctx := s.context()
+	key := s.ns(fmt.Sprintf("session:%s", id))
 	return s.redis.client.Del(ctx, key).Err()
 }
 
+// Ping checks that Redis is reachable
+func (s *Storage) Ping() error {
+	ctx, cancel := context.WithTimeout(s.context(), 2*time.Second)
+	defer cancel()
+	return s.redis.client.Ping(ctx).Err()
+}
+
 // Metrics operations
 func (s *Storage) IncrementMetric(metric string) error {
-	ctx := context.Background()
-	key := fmt.Sprintf("metrics:%s", metric)
+	ctx := s.context()
+	key := s.ns(fmt.Sprintf("metrics:%s", metric))
 	return s.redis.client.Incr(ctx, key).Err()
 }
 
 func (s *Storage) GetMetric(metric string) (int64, error) {
-	ctx := context.Background()
-	key := fmt.Sprintf("metrics:%s", metric)
+	ctx := s.context()
+	key := s.ns(fmt.Sprintf("metrics:%s", metric))
 	
 	val, err := s.redis.client.Get(ctx, key).Int64()
 	if err != nil {
@@ -325,3 +545,224 @@ func (s *Storage) GetMetric(metric string) (int64, error) {
 	
 	return val, nil
 }
+
+// Usage history operations
+
+func historyKey(id string) string {
+	return fmt.Sprintf("key:%s:history", id)
+}
+
+// BatchAppendHistory records usage snapshots in each key's history,
+// scored by the time the snapshot was taken
+func (s *Storage) BatchAppendHistory(usages []*Usage) error {
+	ctx := s.context()
+	pipe := s.redis.client.Pipeline()
+
+	for _, usage := range usages {
+		data, err := json.Marshal(usage)
+		if err != nil {
+			continue
+		}
+		pipe.ZAdd(ctx, s.ns(historyKey(usage.ID)), redis.Z{
+			Score:  float64(usage.LastUpdated.Unix()),
+			Member: data,
+		})
+	}
+
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// GetHistory retrieves usage snapshots for a key within [from, to]
+func (s *Storage) GetHistory(id string, from, to time.Time) ([]*Usage, error) {
+	ctx := s.context()
+	members, err := s.redis.client.ZRangeByScore(ctx, s.ns(historyKey(id)), &redis.ZRangeBy{
+		Min: fmt.Sprintf("%d", from.Unix()),
+		Max: fmt.Sprintf("%d", to.Unix()),
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	history := make([]*Usage, 0, len(members))
+	for _, member := range members {
+		var usage Usage
+		if err := json.Unmarshal([]byte(member), &usage); err != nil {
+			continue
+		}
+		history = append(history, &usage)
+	}
+
+	return history, nil
+}
+
+// BatchGetHistory retrieves usage snapshots within [from, to] for several keys
+func (s *Storage) BatchGetHistory(ids []string, from, to time.Time) (map[string][]*Usage, error) {
+	ctx := s.context()
+	pipe := s.redis.client.Pipeline()
+
+	cmds := make(map[string]*redis.StringSliceCmd, len(ids))
+	for _, id := range ids {
+		cmds[id] = pipe.ZRangeByScore(ctx, s.ns(historyKey(id)), &redis.ZRangeBy{
+			Min: fmt.Sprintf("%d", from.Unix()),
+			Max: fmt.Sprintf("%d", to.Unix()),
+		})
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	histories := make(map[string][]*Usage, len(cmds))
+	for id, cmd := range cmds {
+		members := cmd.Val()
+		history := make([]*Usage, 0, len(members))
+		for _, member := range members {
+			var usage Usage
+			if err := json.Unmarshal([]byte(member), &usage); err != nil {
+				continue
+			}
+			history = append(history, &usage)
+		}
+		histories[id] = history
+	}
+	return histories, nil
+}
+
+// LatestSnapshotsBefore returns, for each key, its most recent usage snapshot
+// taken strictly before the given time; keys without one are omitted
+func (s *Storage) LatestSnapshotsBefore(before map[string]time.Time) (map[string]*Usage, error) {
+	ctx := s.context()
+	pipe := s.redis.client.Pipeline()
+
+	cmds := make(map[string]*redis.StringSliceCmd, len(before))
+	for id, t := range before {
+		cmds[id] = pipe.ZRevRangeByScore(ctx, s.ns(historyKey(id)), &redis.ZRangeBy{
+			Max:   fmt.Sprintf("(%d", t.Unix()),
+			Min:   "-inf",
+			Count: 1,
+		})
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	snapshots := make(map[string]*Usage, len(cmds))
+	for id, cmd := range cmds {
+		members := cmd.Val()
+		if len(members) == 0 {
+			continue
+		}
+		var usage Usage
+		if err := json.Unmarshal([]byte(members[0]), &usage); err != nil {
+			continue
+		}
+		snapshots[id] = &usage
+	}
+	return snapshots, nil
+}
+
+// PruneHistory drops usage snapshots older than cutoff for all keys
+func (s *Storage) PruneHistory(cutoff time.Time) (int64, error) {
+	ctx := s.context()
+	ids, err := s.redis.client.SMembers(ctx, s.ns("keys:list")).Result()
+	if err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	pipe := s.redis.client.Pipeline()
+	cmds := make([]*redis.IntCmd, len(ids))
+	maxScore := fmt.Sprintf("(%d", cutoff.Unix())
+	for i, id := range ids {
+		cmds[i] = pipe.ZRemRangeByScore(ctx, s.ns(historyKey(id)), "-inf", maxScore)
+	}
+
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return 0, err
+	}
+
+	var removed int64
+	for _, cmd := range cmds {
+		removed += cmd.Val()
+	}
+	return removed, nil
+}
+
+// Generic JSON document operations
+
+// SetJSON stores a JSON-encoded value; a zero ttl keeps it forever
+func (s *Storage) SetJSON(key string, value interface{}, ttl time.Duration) error {
+	ctx := s.context()
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return s.redis.client.Set(ctx, s.ns(key), data, ttl).Err()
+}
+
+// GetJSON decodes a stored JSON value into dest, reporting whether it existed
+func (s *Storage) GetJSON(key string, dest interface{}) (bool, error) {
+	ctx := s.context()
+	data, err := s.redis.client.Get(ctx, s.ns(key)).Bytes()
+	if err != nil {
+		if err == redis.Nil {
+			return false, nil
+		}
+		return false, err
+	}
+	if err := json.Unmarshal(data, dest); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Pub/sub operations
+
+// Publish sends a message on a pub/sub channel
+func (s *Storage) Publish(channel string, payload []byte) error {
+	ctx := s.context()
+	return s.redis.client.Publish(ctx, s.ns(channel), payload).Err()
+}
+
+// Subscribe delivers messages from channels matching pattern to handler
+// until ctx is cancelled; channel names are passed without the namespace
+func (s *Storage) Subscribe(ctx context.Context, pattern string, handler func(channel string, payload []byte)) error {
+	pubsub := s.redis.client.PSubscribe(ctx, s.ns(pattern))
+	defer pubsub.Close()
+
+	if _, err := pubsub.Receive(ctx); err != nil {
+		return err
+	}
+
+	messages := pubsub.Channel()
+	for {
+		select {
+		case msg, ok := <-messages:
+			if !ok {
+				return nil
+			}
+			handler(strings.TrimPrefix(msg.Channel, s.prefix), []byte(msg.Payload))
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// SetJSONNX stores a JSON-encoded value only if key does not exist yet,
+// reporting whether it was stored
+func (s *Storage) SetJSONNX(key string, value interface{}, ttl time.Duration) (bool, error) {
+	ctx := s.context()
+	data, err := json.Marshal(value)
+	if err != nil {
+		return false, err
+	}
+	return s.redis.client.SetNX(ctx, s.ns(key), data, ttl).Result()
+}
+
+// DeleteKey removes a raw storage key
+func (s *Storage) DeleteKey(key string) error {
+	ctx := s.context()
+	return s.redis.client.Del(ctx, s.ns(key)).Err()
+}
@@ -0,0 +1,123 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Report describes a generated usage report; the rendered artifacts are
+// stored next to it, one per format
+type Report struct {
+	ID          string         `json:"id"`
+	GeneratedAt time.Time      `json:"generated_at"`
+	TotalKeys   int            `json:"total_keys"`
+	Formats     []string       `json:"formats"`
+	Sizes       map[string]int `json:"sizes"`
+}
+
+const (
+	reportsKey      = "reports"
+	reportsIndexKey = "reports:index"
+)
+
+func reportArtifactKey(id, format string) string {
+	return fmt.Sprintf("report:%s:%s", id, format)
+}
+
+// SaveReport stores a report together with its artifacts by format
+func (s *Storage) SaveReport(report *Report, artifacts map[string][]byte) error {
+	ctx := s.context()
+	data, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+
+	pipe := s.redis.client.TxPipeline()
+	for format, artifact := range artifacts {
+		pipe.Set(ctx, s.ns(reportArtifactKey(report.ID, format)), artifact, 0)
+	}
+	pipe.HSet(ctx, s.ns(reportsKey), report.ID, data)
+	pipe.ZAdd(ctx, s.ns(reportsIndexKey), redis.Z{
+		Score:  float64(report.GeneratedAt.Unix()),
+		Member: report.ID,
+	})
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// ListReports returns the most recent reports, newest first
+func (s *Storage) ListReports(limit int) ([]*Report, error) {
+	ctx := s.context()
+	ids, err := s.redis.client.ZRevRange(ctx, s.ns(reportsIndexKey), 0, int64(limit)-1).Result()
+	if err != nil || len(ids) == 0 {
+		return []*Report{}, err
+	}
+
+	values, err := s.redis.client.HMGet(ctx, s.ns(reportsKey), ids...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	reports := make([]*Report, 0, len(values))
+	for _, value := range values {
+		data, ok := value.(string)
+		if !ok {
+			continue
+		}
+		var report Report
+		if err := json.Unmarshal([]byte(data), &report); err != nil {
+			continue
+		}
+		reports = append(reports, &report)
+	}
+	return reports, nil
+}
+
+// GetReportArtifact returns a rendered report, or nil if the report or the
+// format does not exist
+func (s *Storage) GetReportArtifact(id, format string) ([]byte, error) {
+	ctx := s.context()
+	data, err := s.redis.client.Get(ctx, s.ns(reportArtifactKey(id, format))).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	return data, err
+}
+
+// PruneReports removes reports generated before cutoff with their artifacts
+func (s *Storage) PruneReports(cutoff time.Time) (int64, error) {
+	ctx := s.context()
+	ids, err := s.redis.client.ZRangeByScore(ctx, s.ns(reportsIndexKey), &redis.ZRangeBy{
+		Min: "-inf",
+		Max: fmt.Sprintf("(%d", cutoff.Unix()),
+	}).Result()
+	if err != nil || len(ids) == 0 {
+		return 0, err
+	}
+
+	values, err := s.redis.client.HMGet(ctx, s.ns(reportsKey), ids...).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	pipe := s.redis.client.Pipeline()
+	for i, id := range ids {
+		if data, ok := values[i].(string); ok {
+			var report Report
+			if err := json.Unmarshal([]byte(data), &report); err == nil {
+				for _, format := range report.Formats {
+					pipe.Del(ctx, s.ns(reportArtifactKey(id, format)))
+				}
+			}
+		}
+		pipe.HDel(ctx, s.ns(reportsKey), id)
+		pipe.ZRem(ctx, s.ns(reportsIndexKey), id)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	return int64(len(ids)), nil
+}
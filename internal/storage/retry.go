@@ -0,0 +1,245 @@
+package storage
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RetryEntry tracks a key whose fetch failed, while it waits in the retry
+// queue and once it has been moved to the dead-letter list
+type RetryEntry struct {
+	KeyID         string    `json:"key_id"`
+	Attempts      int       `json:"attempts"`
+	ErrorCode     string    `json:"error_code"`
+	LastError     string    `json:"last_error"`
+	FirstFailedAt time.Time `json:"first_failed_at"`
+	NextAttemptAt time.Time `json:"next_attempt_at,omitempty"`
+	// DeadAt is when the key was moved to the dead-letter list
+	DeadAt *time.Time `json:"dead_at,omitempty"`
+}
+
+const (
+	// retryQueueKey scores the ID of every queued key by the time of its
+	// next attempt
+	retryQueueKey   = "retry:queue"
+	retryEntriesKey = "retry:entries"
+	deadLetterKey   = "retry:deadletter"
+)
+
+// enqueueRetrySrc queues a key unless it is already queued or dead-lettered,
+// so repeated failures don't reset its backoff
+//
+// KEYS[1] retry:entries, KEYS[2] retry:queue, KEYS[3] retry:deadletter
+// ARGV[1] key ID, ARGV[2] entry, ARGV[3] next attempt (unix ms)
+const enqueueRetrySrc = `
+if redis.call('HEXISTS', KEYS[3], ARGV[1]) == 1 then
+	return 0
+end
+if redis.call('HSETNX', KEYS[1], ARGV[1], ARGV[2]) == 0 then
+	return 0
+end
+redis.call('ZADD', KEYS[2], ARGV[3], ARGV[1])
+return 1
+`
+
+var enqueueRetryScript = redis.NewScript(enqueueRetrySrc)
+
+// EnqueueRetries queues keys for a retry; keys that are already queued or
+// dead-lettered are left alone
+func (s *Storage) EnqueueRetries(entries []*RetryEntry) error {
+	ctx := s.context()
+	keys := []string{s.ns(retryEntriesKey), s.ns(retryQueueKey), s.ns(deadLetterKey)}
+	for _, entry := range entries {
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		err = enqueueRetryScript.Run(ctx, s.redis.client, keys,
+			entry.KeyID, data, entry.NextAttemptAt.UnixMilli()).Err()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DueRetries returns up to limit queued keys whose next attempt is due
+func (s *Storage) DueRetries(now time.Time, limit int) ([]*RetryEntry, error) {
+	ctx := s.context()
+	ids, err := s.redis.client.ZRangeByScore(ctx, s.ns(retryQueueKey), &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   strconv.FormatInt(now.UnixMilli(), 10),
+		Count: int64(limit),
+	}).Result()
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+
+	values, err := s.redis.client.HMGet(ctx, s.ns(retryEntriesKey), ids...).Result()
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]*RetryEntry, 0, len(ids))
+	for i, value := range values {
+		raw, ok := value.(string)
+		var entry RetryEntry
+		if !ok || json.Unmarshal([]byte(raw), &entry) != nil {
+			// Queued without an entry; start over
+			entry = RetryEntry{KeyID: ids[i], FirstFailedAt: now}
+		}
+		entries = append(entries, &entry)
+	}
+	return entries, nil
+}
+
+// RescheduleRetry saves a queued key after a failed retry
+func (s *Storage) RescheduleRetry(entry *RetryEntry) error {
+	ctx := s.context()
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	pipe := s.redis.client.TxPipeline()
+	pipe.HSet(ctx, s.ns(retryEntriesKey), entry.KeyID, data)
+	pipe.ZAdd(ctx, s.ns(retryQueueKey), redis.Z{
+		Score:  float64(entry.NextAttemptAt.UnixMilli()),
+		Member: entry.KeyID,
+	})
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// MoveToDeadLetter takes a key off the retry queue and onto the dead-letter
+// list
+func (s *Storage) MoveToDeadLetter(entry *RetryEntry) error {
+	ctx := s.context()
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	pipe := s.redis.client.TxPipeline()
+	pipe.HDel(ctx, s.ns(retryEntriesKey), entry.KeyID)
+	pipe.ZRem(ctx, s.ns(retryQueueKey), entry.KeyID)
+	pipe.HSet(ctx, s.ns(deadLetterKey), entry.KeyID, data)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// RetryingKeyIDs returns the IDs of the keys that are queued for a retry or
+// dead-lettered
+func (s *Storage) RetryingKeyIDs() (map[string]bool, error) {
+	ctx := s.context()
+	pipe := s.redis.client.Pipeline()
+	queued := pipe.HKeys(ctx, s.ns(retryEntriesKey))
+	dead := pipe.HKeys(ctx, s.ns(deadLetterKey))
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	ids := make(map[string]bool)
+	for _, id := range append(queued.Val(), dead.Val()...) {
+		ids[id] = true
+	}
+	return ids, nil
+}
+
+// ClearRetries takes keys off the retry queue and the dead-letter list
+func (s *Storage) ClearRetries(ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	ctx := s.context()
+	members := make([]interface{}, len(ids))
+	for i, id := range ids {
+		members[i] = id
+	}
+	pipe := s.redis.client.TxPipeline()
+	pipe.HDel(ctx, s.ns(retryEntriesKey), ids...)
+	pipe.ZRem(ctx, s.ns(retryQueueKey), members...)
+	pipe.HDel(ctx, s.ns(deadLetterKey), ids...)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// ListDeadLetters returns the dead-lettered keys, most recent first
+func (s *Storage) ListDeadLetters() ([]*RetryEntry, error) {
+	data, err := s.redis.client.HGetAll(s.context(), s.ns(deadLetterKey)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*RetryEntry, 0, len(data))
+	for _, raw := range data {
+		var entry RetryEntry
+		if err := json.Unmarshal([]byte(raw), &entry); err != nil {
+			continue
+		}
+		entries = append(entries, &entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		ti, tj := entries[i].DeadTime(), entries[j].DeadTime()
+		if !ti.Equal(tj) {
+			return ti.After(tj)
+		}
+		return entries[i].KeyID < entries[j].KeyID
+	})
+	return entries, nil
+}
+
+// DeadTime returns when the key was dead-lettered, or the zero time
+func (e *RetryEntry) DeadTime() time.Time {
+	if e.DeadAt == nil {
+		return time.Time{}
+	}
+	return *e.DeadAt
+}
+
+// requeueDeadLetterSrc moves a dead-lettered key back onto the retry queue
+// with a fresh attempt count, due immediately
+//
+// KEYS[1] retry:deadletter, KEYS[2] retry:entries, KEYS[3] retry:queue
+// ARGV[1] key ID, ARGV[2] entry, ARGV[3] next attempt (unix ms)
+const requeueDeadLetterSrc = `
+if redis.call('HDEL', KEYS[1], ARGV[1]) == 0 then
+	return 0
+end
+redis.call('HSET', KEYS[2], ARGV[1], ARGV[2])
+redis.call('ZADD', KEYS[3], ARGV[3], ARGV[1])
+return 1
+`
+
+var requeueDeadLetterScript = redis.NewScript(requeueDeadLetterSrc)
+
+// RequeueDeadLetter moves a dead-lettered key back onto the retry queue as
+// entry; it reports false when the key is not dead-lettered
+func (s *Storage) RequeueDeadLetter(entry *RetryEntry) (bool, error) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return false, err
+	}
+	moved, err := requeueDeadLetterScript.Run(s.context(), s.redis.client,
+		[]string{s.ns(deadLetterKey), s.ns(retryEntriesKey), s.ns(retryQueueKey)},
+		entry.KeyID, data, entry.NextAttemptAt.UnixMilli()).Int()
+	return moved == 1, err
+}
+
+// GetDeadLetter returns a dead-lettered key, or nil when it is not
+// dead-lettered
+func (s *Storage) GetDeadLetter(id string) (*RetryEntry, error) {
+	raw, err := s.redis.client.HGet(s.context(), s.ns(deadLetterKey), id).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entry RetryEntry
+	if err := json.Unmarshal([]byte(raw), &entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
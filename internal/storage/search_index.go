@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"encoding/json"
+)
+
+// keySearchKey maps the ID of every live key to its search entry, so keys
+// can be searched without loading (and decoding) every key record
+const keySearchKey = "keys:search"
+
+// searchSuffixLen is how many trailing characters of a key value its search
+// entry keeps; searches only ever match the part the mask policy shows
+const searchSuffixLen = 8
+
+// KeySearchEntry is the searchable metadata of a key
+type KeySearchEntry struct {
+	Name   string   `json:"name"`
+	Notes  string   `json:"notes,omitempty"`
+	Group  string   `json:"group,omitempty"`
+	Tags   []string `json:"tags,omitempty"`
+	Owner  string   `json:"owner,omitempty"`
+	Suffix string   `json:"suffix"`
+	// Length is the length of the key value, which decides how much of it
+	// the mask policy shows
+	Length int `json:"length"`
+}
+
+// searchEntryData encodes the search entry of a key
+func searchEntryData(key *APIKey) ([]byte, error) {
+	suffix := key.Key
+	if len(suffix) > searchSuffixLen {
+		suffix = suffix[len(suffix)-searchSuffixLen:]
+	}
+	return json.Marshal(&KeySearchEntry{
+		Name:   key.Name,
+		Notes:  key.Notes,
+		Group:  key.Group,
+		Tags:   key.Tags,
+		Owner:  key.Owner,
+		Suffix: suffix,
+		Length: len(key.Key),
+	})
+}
+
+// GetKeySearchEntries returns the search entries of all live keys by key ID
+func (s *Storage) GetKeySearchEntries() (map[string]*KeySearchEntry, error) {
+	ctx := s.context()
+	data, err := s.redis.client.HGetAll(ctx, s.ns(keySearchKey)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make(map[string]*KeySearchEntry, len(data))
+	for id, raw := range data {
+		var entry KeySearchEntry
+		if err := json.Unmarshal([]byte(raw), &entry); err != nil {
+			continue
+		}
+		entries[id] = &entry
+	}
+	return entries, nil
+}
+
+// RebuildSearchIndex rewrites the search entries of all keys, covering keys
+// stored before the index existed
+func (s *Storage) RebuildSearchIndex() error {
+	ctx := s.context()
+	keys, err := s.GetAllAPIKeys()
+	if err != nil {
+		return err
+	}
+
+	pipe := s.redis.client.TxPipeline()
+	pipe.Del(ctx, s.ns(keySearchKey))
+	for _, key := range keys {
+		data, err := searchEntryData(key)
+		if err != nil {
+			return err
+		}
+		pipe.HSet(ctx, s.ns(keySearchKey), key.ID, data)
+	}
+	_, err = pipe.Exec(ctx)
+	return err
+}
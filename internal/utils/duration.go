@@ -0,0 +1,28 @@
+package utils
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseDuration extends time.ParseDuration with a day unit, e.g. "7d" or "1d12h"
+func ParseDuration(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if i := strings.Index(s, "d"); i > 0 {
+		days, err := strconv.Atoi(s[:i])
+		if err != nil {
+			return 0, err
+		}
+		total := time.Duration(days) * 24 * time.Hour
+		if rest := s[i+1:]; rest != "" {
+			d, err := time.ParseDuration(rest)
+			if err != nil {
+				return 0, err
+			}
+			total += d
+		}
+		return total, nil
+	}
+	return time.ParseDuration(s)
+}
@@ -53,8 +53,8 @@ func NewLogger() *zap.SugaredLogger {
 		}
 	}
 
-	// Combine cores
-	core := zapcore.NewTee(cores...)
+	// Combine cores, masking secrets before anything is written
+	core := &redactingCore{Core: zapcore.NewTee(cores...)}
 
 	// Create logger
 	logger := zap.New(core, zap.AddCaller(), zap.AddStacktrace(zapcore.ErrorLevel))
@@ -0,0 +1,139 @@
+package utils
+
+import (
+	"encoding/json"
+	"io"
+	"regexp"
+	"strings"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// redacted replaces every secret found by Redact
+const redacted = "[REDACTED]"
+
+// secretPatterns match things that look like credentials, with the part to
+// keep captured in group 1
+var secretPatterns = []*regexp.Regexp{
+	// Authorization header values
+	regexp.MustCompile(`(?i)(\b(?:bearer|basic)\s+)[A-Za-z0-9._~+/=-]{8,}`),
+	// Prefixed API keys such as fk-..., sk-...
+	regexp.MustCompile(`\b((?:fk|sk|pk|rk)-)[A-Za-z0-9_-]{8,}`),
+	// Credentials in query strings and form bodies
+	regexp.MustCompile(`(?i)(\b(?:api[_-]?key|access[_-]?token|token|secret|password)=)[^&\s"']+`),
+	// Credentials in JSON bodies
+	regexp.MustCompile(`(?i)("(?:api[_-]?key|key|access[_-]?token|token|secret|password)"\s*:\s*")[^"]*`),
+	// Long opaque tokens
+	regexp.MustCompile(`()\b[A-Za-z0-9]{32,}\b`),
+}
+
+// Redact masks anything in s that looks like an API key or bearer token
+func Redact(s string) string {
+	for _, pattern := range secretPatterns {
+		s = pattern.ReplaceAllString(s, "${1}"+redacted)
+	}
+	return s
+}
+
+// secretFields are JSON fields whose values are always masked, whatever
+// they look like
+var secretFields = map[string]bool{
+	"key": true, "keys": true, "api_key": true, "apikey": true,
+	"password": true, "token": true, "access_token": true, "refresh_token": true,
+	"secret": true, "client_secret": true,
+}
+
+// RedactJSON masks the values of credential fields such as "key", "keys"
+// and "password" anywhere in a JSON document, then applies Redact. Input
+// that is not valid JSON only goes through Redact.
+func RedactJSON(data []byte) string {
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return Redact(string(data))
+	}
+	masked, err := json.Marshal(redactValue(doc, false))
+	if err != nil {
+		return Redact(string(data))
+	}
+	return Redact(string(masked))
+}
+
+// redactValue masks v when secret is set, or the credential fields within it
+func redactValue(v interface{}, secret bool) interface{} {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		for k, field := range value {
+			value[k] = redactValue(field, secret || secretFields[strings.ToLower(k)])
+		}
+		return value
+	case []interface{}:
+		for i, item := range value {
+			value[i] = redactValue(item, secret)
+		}
+		return value
+	case string:
+		if secret && value != "" {
+			return redacted
+		}
+	}
+	return v
+}
+
+// redactingWriter scrubs secrets from everything written through it
+type redactingWriter struct {
+	w io.Writer
+}
+
+// NewRedactingWriter wraps w so that secrets are masked before writing
+func NewRedactingWriter(w io.Writer) io.Writer {
+	return &redactingWriter{w: w}
+}
+
+func (r *redactingWriter) Write(p []byte) (int, error) {
+	if _, err := r.w.Write([]byte(Redact(string(p)))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// redactingCore scrubs secrets from log messages, stack traces and fields
+type redactingCore struct {
+	zapcore.Core
+}
+
+func (c *redactingCore) With(fields []zapcore.Field) zapcore.Core {
+	return &redactingCore{Core: c.Core.With(redactFields(fields))}
+}
+
+func (c *redactingCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(entry.Level) {
+		return checked.AddCore(entry, c)
+	}
+	return checked
+}
+
+func (c *redactingCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	entry.Message = Redact(entry.Message)
+	entry.Stack = Redact(entry.Stack)
+	return c.Core.Write(entry, redactFields(fields))
+}
+
+func redactFields(fields []zapcore.Field) []zapcore.Field {
+	scrubbed := make([]zapcore.Field, len(fields))
+	for i, field := range fields {
+		switch field.Type {
+		case zapcore.StringType:
+			field.String = Redact(field.String)
+		case zapcore.ErrorType:
+			if err, ok := field.Interface.(error); ok {
+				field = zapcore.Field{Key: field.Key, Type: zapcore.StringType, String: Redact(err.Error())}
+			}
+		case zapcore.StringerType:
+			if s, ok := field.Interface.(interface{ String() string }); ok {
+				field = zapcore.Field{Key: field.Key, Type: zapcore.StringType, String: Redact(s.String())}
+			}
+		}
+		scrubbed[i] = field
+	}
+	return scrubbed
+}
@@ -0,0 +1,83 @@
+// Command example is a sample plugin: the provider "example" reads
+// {"used": ..., "limit": ...} from EXAMPLE_USAGE_URL, and the channel type
+// "log" writes notifications to stderr, which ends up in the server log.
+//
+//	go build -o example-plugin ./plugin/example
+//	PLUGINS=./example-plugin ./server
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/droid-keyusage-go/plugin"
+)
+
+func main() {
+	plugin.Serve("example", "1.0.0",
+		[]plugin.Provider{exampleProvider{url: os.Getenv("EXAMPLE_USAGE_URL")}},
+		[]plugin.Notifier{logNotifier{}},
+	)
+}
+
+type exampleProvider struct {
+	url string
+}
+
+func (p exampleProvider) Name() string { return "example" }
+
+func (p exampleProvider) UsageRequest() (*plugin.Request, error) {
+	if p.url == "" {
+		return nil, errors.New("EXAMPLE_USAGE_URL is not set")
+	}
+	return &plugin.Request{
+		Method: "GET",
+		URL:    p.url,
+		Header: map[string]string{"Accept": "application/json"},
+	}, nil
+}
+
+func (p exampleProvider) ParseUsage(body []byte) (*plugin.Usage, error) {
+	var resp struct {
+		Used  *float64 `json:"used"`
+		Limit *float64 `json:"limit"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	now := time.Now()
+	usage := &plugin.Usage{
+		StartDate: time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location()).Format("2006-01-02"),
+		EndDate:   time.Date(now.Year(), now.Month()+1, 0, 0, 0, 0, 0, now.Location()).Format("2006-01-02"),
+	}
+	if resp.Used != nil {
+		usage.OrgTotalUsed = *resp.Used
+	} else {
+		usage.MissingFields = append(usage.MissingFields, "org_total_tokens_used")
+	}
+	if resp.Limit != nil {
+		usage.TotalAllowance = *resp.Limit
+	} else {
+		usage.MissingFields = append(usage.MissingFields, "total_allowance")
+	}
+	usage.Partial = len(usage.MissingFields) > 0
+	return usage, nil
+}
+
+type logNotifier struct{}
+
+func (logNotifier) Type() string { return "log" }
+
+func (logNotifier) Check(channel plugin.Channel) error {
+	return nil
+}
+
+func (logNotifier) Send(ctx context.Context, channel plugin.Channel, n plugin.Notification) error {
+	_, err := fmt.Fprintf(os.Stderr, "[%s] %s: %s\n", channel.Name, n.Title, n.Message)
+	return err
+}
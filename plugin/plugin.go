@@ -0,0 +1,240 @@
+// Package plugin lets providers and notification channels live in their own
+// executables, so proprietary upstreams can be monitored without forking the
+// server. A plugin is a program calling Serve; the server starts each plugin
+// listed in PLUGINS and talks to it over its stdin and stdout with JSON-RPC,
+// so a plugin may be written in any language that speaks the same protocol.
+//
+// Provider plugins never see the keys: the server builds the usage request
+// from the one the plugin describes, applies the key's credential and sends
+// it, and only hands the response body back to the plugin to parse.
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"time"
+)
+
+// ProtocolVersion is the version of the protocol below; the server refuses
+// plugins reporting another one
+const ProtocolVersion = 1
+
+// MagicEnv is set by the server when it starts a plugin, so a plugin run by
+// hand explains itself instead of waiting for requests on its terminal
+const MagicEnv = "DROID_KEYUSAGE_PLUGIN"
+
+// MagicValue is the value of MagicEnv
+const MagicValue = "droid-keyusage"
+
+// ServiceName is the RPC service the methods below are registered under,
+// e.g. "Plugin.Manifest"
+const ServiceName = "Plugin"
+
+// Manifest describes what a plugin provides
+type Manifest struct {
+	ProtocolVersion int      `json:"protocol_version"`
+	Name            string   `json:"name"`
+	Version         string   `json:"version,omitempty"`
+	Providers       []string `json:"providers,omitempty"`
+	Notifiers       []string `json:"notifiers,omitempty"`
+}
+
+// Request is an upstream request without credentials
+type Request struct {
+	Method string            `json:"method"`
+	URL    string            `json:"url"`
+	Header map[string]string `json:"header,omitempty"`
+	Body   []byte            `json:"body,omitempty"`
+}
+
+// UsageBlock is usage reported besides the standard allowance
+type UsageBlock struct {
+	Used      float64                `json:"used"`
+	Allowance float64                `json:"allowance,omitempty"`
+	UsedRatio float64                `json:"used_ratio,omitempty"`
+	Other     map[string]float64     `json:"other,omitempty"`
+	Breakdown map[string]*UsageBlock `json:"breakdown,omitempty"`
+}
+
+// Usage is the usage a provider read from a response. Dates are formatted
+// as 2006-01-02; the server computes the remaining allowance, and the used
+// ratio when it is left at zero.
+type Usage struct {
+	StartDate      string                 `json:"start_date"`
+	EndDate        string                 `json:"end_date"`
+	TotalAllowance float64                `json:"total_allowance"`
+	OrgTotalUsed   float64                `json:"org_total_tokens_used"`
+	UsedRatio      float64                `json:"used_ratio"`
+	OrgID          string                 `json:"org_id,omitempty"`
+	Blocks         map[string]*UsageBlock `json:"blocks,omitempty"`
+	Partial        bool                   `json:"partial,omitempty"`
+	MissingFields  []string               `json:"missing_fields,omitempty"`
+}
+
+// Channel is the configuration of a notification channel
+type Channel struct {
+	Name    string            `json:"name"`
+	Type    string            `json:"type"`
+	URL     string            `json:"url,omitempty"`
+	Secret  string            `json:"secret,omitempty"`
+	Options map[string]string `json:"options,omitempty"`
+}
+
+// Notification is a notification to deliver
+type Notification struct {
+	Event    string                 `json:"event"`
+	Title    string                 `json:"title"`
+	Message  string                 `json:"message"`
+	KeyID    string                 `json:"key_id,omitempty"`
+	Time     time.Time              `json:"time"`
+	Data     map[string]interface{} `json:"data,omitempty"`
+	Severity string                 `json:"severity,omitempty"`
+	DedupKey string                 `json:"dedup_key,omitempty"`
+}
+
+// Arguments and replies of the RPC methods
+type (
+	UsageRequestArgs struct {
+		Provider string `json:"provider"`
+	}
+	ParseUsageArgs struct {
+		Provider string `json:"provider"`
+		Body     []byte `json:"body"`
+	}
+	CheckChannelArgs struct {
+		Channel Channel `json:"channel"`
+	}
+	SendArgs struct {
+		Channel      Channel      `json:"channel"`
+		Notification Notification `json:"notification"`
+		// Timeout is how long the server waits for the delivery, in ms
+		Timeout int64 `json:"timeout_ms,omitempty"`
+	}
+	// Empty is the reply of methods returning nothing but an error
+	Empty struct{}
+)
+
+// Provider fetches usage from one upstream
+type Provider interface {
+	// Name is the provider name keys refer to
+	Name() string
+	// UsageRequest describes the usage request; the server adds the key
+	UsageRequest() (*Request, error)
+	// ParseUsage reads the body of a successful usage response
+	ParseUsage(body []byte) (*Usage, error)
+}
+
+// Notifier delivers notifications over one channel type
+type Notifier interface {
+	// Type is the channel type channels refer to
+	Type() string
+	// Check rejects a channel configuration it can't deliver with
+	Check(channel Channel) error
+	// Send delivers a notification, giving up when ctx ends
+	Send(ctx context.Context, channel Channel, n Notification) error
+}
+
+// Serve answers the server's requests on stdin and stdout until the server
+// closes them. Plugins must not write anything else to stdout; logs go to
+// stderr, which the server passes through.
+func Serve(name, version string, providers []Provider, notifiers []Notifier) {
+	if os.Getenv(MagicEnv) != MagicValue {
+		fmt.Fprintf(os.Stderr, "%s is a droid-keyusage plugin: add its path to PLUGINS instead of running it\n", name)
+		os.Exit(1)
+	}
+
+	service := &service{
+		manifest:  Manifest{ProtocolVersion: ProtocolVersion, Name: name, Version: version},
+		providers: make(map[string]Provider, len(providers)),
+		notifiers: make(map[string]Notifier, len(notifiers)),
+	}
+	for _, p := range providers {
+		service.providers[p.Name()] = p
+		service.manifest.Providers = append(service.manifest.Providers, p.Name())
+	}
+	for _, n := range notifiers {
+		service.notifiers[n.Type()] = n
+		service.manifest.Notifiers = append(service.manifest.Notifiers, n.Type())
+	}
+
+	server := rpc.NewServer()
+	if err := server.RegisterName(ServiceName, service); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+		os.Exit(1)
+	}
+	server.ServeCodec(jsonrpc.NewServerCodec(stdio{}))
+}
+
+// stdio is the connection to the server, an io.ReadWriteCloser
+type stdio struct{}
+
+func (stdio) Read(p []byte) (int, error)  { return os.Stdin.Read(p) }
+func (stdio) Write(p []byte) (int, error) { return os.Stdout.Write(p) }
+func (stdio) Close() error                { return nil }
+
+// service exposes the plugin's providers and notifiers over RPC
+type service struct {
+	manifest  Manifest
+	providers map[string]Provider
+	notifiers map[string]Notifier
+}
+
+// errUnknown is returned for a provider or channel type the plugin lacks
+var errUnknown = errors.New("not provided by this plugin")
+
+func (s *service) Manifest(_ Empty, reply *Manifest) error {
+	*reply = s.manifest
+	return nil
+}
+
+func (s *service) UsageRequest(args UsageRequestArgs, reply *Request) error {
+	p, ok := s.providers[args.Provider]
+	if !ok {
+		return fmt.Errorf("provider %s %w", args.Provider, errUnknown)
+	}
+	req, err := p.UsageRequest()
+	if err != nil {
+		return err
+	}
+	*reply = *req
+	return nil
+}
+
+func (s *service) ParseUsage(args ParseUsageArgs, reply *Usage) error {
+	p, ok := s.providers[args.Provider]
+	if !ok {
+		return fmt.Errorf("provider %s %w", args.Provider, errUnknown)
+	}
+	usage, err := p.ParseUsage(args.Body)
+	if err != nil {
+		return err
+	}
+	*reply = *usage
+	return nil
+}
+
+func (s *service) CheckChannel(args CheckChannelArgs, _ *Empty) error {
+	n, ok := s.notifiers[args.Channel.Type]
+	if !ok {
+		return fmt.Errorf("channel type %s %w", args.Channel.Type, errUnknown)
+	}
+	return n.Check(args.Channel)
+}
+
+func (s *service) Send(args SendArgs, _ *Empty) error {
+	n, ok := s.notifiers[args.Channel.Type]
+	if !ok {
+		return fmt.Errorf("channel type %s %w", args.Channel.Type, errUnknown)
+	}
+	ctx := context.Background()
+	if args.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(args.Timeout)*time.Millisecond)
+		defer cancel()
+	}
+	return n.Send(ctx, args.Channel, args.Notification)
+}
@@ -0,0 +1,19 @@
+// Package web embeds the dashboard assets into the server binary
+package web
+
+import (
+	"embed"
+	"io/fs"
+)
+
+//go:embed static
+var files embed.FS
+
+// Static returns the embedded dashboard assets rooted at web/static
+func Static() fs.FS {
+	static, err := fs.Sub(files, "static")
+	if err != nil {
+		panic(err)
+	}
+	return static
+}